@@ -68,4 +68,35 @@ component: nodeplugin
 {{- define "warm-metal-csi-driver.controllerplugin.selectorLabels" -}}
 component: controllerplugin
 {{ include "warm-metal-csi-driver.selectorLabels" . }}
-{{- end }}
\ No newline at end of file
+{{- end }}
+{{- define "warm-metal-csi-driver.webhook.labels" -}}
+component: webhook
+{{ include "warm-metal-csi-driver.labels" . }}
+{{- end }}
+
+{{- define "warm-metal-csi-driver.webhook.selectorLabels" -}}
+component: webhook
+{{ include "warm-metal-csi-driver.selectorLabels" . }}
+{{- end }}
+
+{{/*
+Runtime addresses of the node plugin, the preferred socket followed by fallback ones.
+*/}}
+{{- define "warm-metal-csi-driver.runtimeAddr" -}}
+{{- $addrs := list }}
+{{- range prepend .Values.runtime.fallbackSocketPaths .Values.runtime.socketPath }}
+{{- $addrs = append $addrs (printf "%s://%s" $.Values.runtime.engine .) }}
+{{- end }}
+{{- join "," $addrs }}
+{{- end }}
+
+{{/*
+Directories of runtime sockets mounted if fallback sockets are given.
+*/}}
+{{- define "warm-metal-csi-driver.runtimeSocketDirs" -}}
+{{- $dirs := list }}
+{{- range prepend .Values.runtime.fallbackSocketPaths .Values.runtime.socketPath }}
+{{- $dirs = append $dirs (dir .) }}
+{{- end }}
+{{- toJson (uniq $dirs) }}
+{{- end }}
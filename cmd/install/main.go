@@ -216,6 +216,7 @@ spec:
   volumeLifecycleModes:
     - Persistent
     - Ephemeral
+  seLinuxMount: true
 ---
 `
 
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+	"k8s.io/klog/v2"
+)
+
+// adminSocket is the unix socket in the data directory gc and inspect modes reach the node plugin via.
+const adminSocket = "admin.sock"
+
+// ServeAdmin serves requests of gc and inspect modes via the socket until ctx is done. POST /gc collects garbage with
+// gcOpts, and GET /inspect reports the state of the node. The socket is only reachable on the node, so that garbage
+// can't be removed nor the state read remotely.
+func (n NodeServer) ServeAdmin(ctx context.Context, socket string, gcOpts backend.JanitorOptions) error {
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Chmod(socket, 0o600); err != nil {
+		listener.Close()
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /gc", n.serveGC(gcOpts))
+	mux.HandleFunc("GET /inspect", n.serveInspect)
+
+	server := &http.Server{Handler: mux}
+	context.AfterFunc(ctx, func() { server.Close() })
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			klog.Errorf("unable to serve the admin socket: %s", err)
+		}
+	}()
+
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		klog.Errorf("unable to write the response: %s", err)
+	}
+}
+
+// callAdmin sends a request to the node plugin listening on the socket, and decodes its response into v.
+func callAdmin(socket, method, path string, v any) error {
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+
+	req, err := http.NewRequest(method, "http://localhost"+path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to reach the node plugin via %q: %w", socket, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+
+	return nil
+}
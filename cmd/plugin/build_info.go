@@ -0,0 +1,95 @@
+package main
+
+import (
+	"runtime/debug"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
+)
+
+// driverVersion and gitCommit are set via -ldflags by make build.
+var (
+	driverVersion = "v1.0.0"
+	gitCommit     = ""
+)
+
+// criAPIVersion is the version of the CRI API the driver speaks to runtimes.
+const criAPIVersion = "v1"
+
+// featureFlags are flags enabling optional features, which are reported along with the version if set.
+var featureFlags = []string{
+	"enable-daemon-image-credential-cache",
+	"enable-volume-secret-refs",
+	"enable-volume-snapshots",
+	"enable-volume-attributes-classes",
+	"persistent-scratch-cleanup",
+	"reclaim-images",
+	"pre-pull-on-attach",
+	"cache-coordinator",
+	"socket-handover",
+	"annotate-digests",
+	"manage-csidriver",
+	"metrics-detailed-images",
+}
+
+// buildInfo tells the build and enabled features of the running driver, so that version skew across nodes is
+// visible via the build_info metric and GetPluginInfo.
+type buildInfo struct {
+	version       string
+	gitCommit     string
+	criAPIVersion string
+	features      []string
+}
+
+// currentBuildInfo returns the build info of the binary, with features enabled by parsed flags.
+func currentBuildInfo() buildInfo {
+	commit := gitCommit
+	if len(commit) == 0 {
+		commit = vcsRevision()
+	}
+
+	var features []string
+	for _, name := range featureFlags {
+		if f := flag.Lookup(name); f != nil && f.Value.String() == "true" {
+			features = append(features, name)
+		}
+	}
+
+	return buildInfo{
+		version:       driverVersion,
+		gitCommit:     commit,
+		criAPIVersion: criAPIVersion,
+		features:      features,
+	}
+}
+
+// vcsRevision returns the revision go build stamped the binary with, if it is built in a git checkout.
+func vcsRevision() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+
+	return ""
+}
+
+// export sets the build_info metric.
+func (b buildInfo) export() {
+	metrics.BuildInfo.WithLabelValues(b.version, b.gitCommit, b.criAPIVersion, strings.Join(b.features, ",")).Set(1)
+}
+
+// manifest returns the build info as the manifest of GetPluginInfo.
+func (b buildInfo) manifest() map[string]string {
+	return map[string]string{
+		"gitCommit":     b.gitCommit,
+		"criAPIVersion": b.criAPIVersion,
+		"features":      strings.Join(b.features, ","),
+	}
+}
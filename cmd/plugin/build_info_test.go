@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	flag "github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
+)
+
+func TestBuildInfo(t *testing.T) {
+	assert.NoError(t, flag.Set("enable-volume-snapshots", "true"))
+	defer flag.Set("enable-volume-snapshots", "false")
+
+	info := currentBuildInfo()
+	info.export()
+	assert.Contains(t, info.features, "enable-volume-snapshots")
+	assert.NotContains(t, info.features, "reclaim-images")
+
+	resp, err := NewIdentityServer(info).GetPluginInfo(context.Background(), &csi.GetPluginInfoRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, driverVersion, resp.GetVendorVersion())
+	assert.Equal(t, criAPIVersion, resp.GetManifest()["criAPIVersion"])
+	assert.Contains(t, resp.GetManifest()["features"], "enable-volume-snapshots")
+
+	families, err := metrics.RegisterMetrics().Gather()
+	assert.NoError(t, err)
+	labels := map[string]string{}
+	for _, family := range families {
+		if family.GetName() != "warm_metal_build_info" {
+			continue
+		}
+		for _, label := range family.GetMetric()[0].GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+	}
+
+	assert.Equal(t, driverVersion, labels["version"])
+	assert.Equal(t, resp.GetManifest()["gitCommit"], labels["git_commit"])
+	assert.Equal(t, resp.GetManifest()["features"], labels["features"])
+}
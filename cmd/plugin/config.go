@@ -0,0 +1,65 @@
+package main
+
+import (
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+	"github.com/warm-metal/container-image-csi-driver/pkg/config"
+	csicommon "github.com/warm-metal/container-image-csi-driver/pkg/csi-common"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+)
+
+// tunables are components of the node plugin retuned by the config file. mounter is nil if volumes are mounted by
+// backend plugins.
+type tunables struct {
+	server     csicommon.NonBlockingGRPCServer
+	nodeServer *NodeServer
+	mounter    *backend.SnapshotMounter
+}
+
+// apply applies tunables of the config over their flags, so that tunables removed from the config are reset to their
+// flags.
+func (t tunables) apply(cfg *config.Config) {
+	concurrency, queueLength := *maxConcurrentRequests, *requestQueueLength
+	if cfg.MaxConcurrentRequests != nil {
+		concurrency = cfg.MaxConcurrentRequests
+	}
+
+	if cfg.RequestQueueLength != nil {
+		queueLength = *cfg.RequestQueueLength
+	}
+
+	t.server.SetRequestLimits(concurrency, queueLength)
+
+	pullTimeout := *asyncImagePullTimeout
+	if cfg.PullTimeout != nil {
+		pullTimeout = cfg.PullTimeout.Duration
+	}
+
+	t.nodeServer.SetAsyncPullTimeout(pullTimeout)
+	klog.Infof("tunables applied: max concurrent requests %v, request queue length %d, async pull timeout %s",
+		concurrency, queueLength, pullTimeout)
+
+	if t.mounter == nil {
+		return
+	}
+
+	cacheSize, err := resource.ParseQuantity(*blockCacheSize)
+	if err != nil {
+		klog.Fatalf("invalid block cache size %q: %s", *blockCacheSize, err)
+	}
+
+	if cfg.BlockCacheSize != nil {
+		cacheSize = *cfg.BlockCacheSize
+	}
+
+	t.mounter.SetBlockCacheSize(cacheSize.Value())
+
+	retention := *snapshotRetention
+	if cfg.SnapshotRetention != nil {
+		retention = cfg.SnapshotRetention.Duration
+	}
+
+	t.mounter.EnableSnapshotRetention(retention)
+
+	klog.Infof("tunables applied: block cache size %s, snapshot retention %s", &cacheSize, retention)
+}
@@ -2,13 +2,26 @@ package main
 
 import (
 	"context"
+	"maps"
+	"strconv"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
-	"github.com/pkg/errors"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/platforms"
+	"github.com/distribution/reference"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	csicommon "github.com/warm-metal/container-image-csi-driver/pkg/csi-common"
+	"github.com/warm-metal/container-image-csi-driver/pkg/remoteimage"
+	"github.com/warm-metal/container-image-csi-driver/pkg/secret"
 	"github.com/warm-metal/container-image-csi-driver/pkg/watcher"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
 )
 
 const (
@@ -18,77 +31,330 @@ const (
 	defaultVolumeSize = 1 * GiB
 )
 
-func NewControllerServer(driver *csicommon.CSIDriver, watcher *watcher.Watcher) *ControllerServer {
+func NewControllerServer(
+	driver *csicommon.CSIDriver, watcher *watcher.Watcher, secretStore secret.Store, kubeClient kubernetes.Interface,
+	validateImages bool,
+) *ControllerServer {
 	return &ControllerServer{
-		driver:  driver,
-		watcher: watcher,
+		driver:         driver,
+		watcher:        watcher,
+		secretStore:    secretStore,
+		kubeClient:     kubeClient,
+		validateImages: validateImages,
 	}
 }
 
 type ControllerServer struct {
-	driver  *csicommon.CSIDriver
-	watcher *watcher.Watcher
+	driver      *csicommon.CSIDriver
+	watcher     *watcher.Watcher
+	secretStore secret.Store
+	// kubeClient fetches secrets referred by the parameter secretRef of StorageClasses
+	kubeClient kubernetes.Interface
+	// images of dynamically provisioned volumes are checked against their registries if validateImages is set
+	validateImages bool
+	// volume snapshots are disabled if snapshotContents is nil
+	snapshotContents *watcher.SnapshotContents
+	// volumes are attached to nodes if prePullOnAttach is set, which makes nodes pull images once attached
+	prePullOnAttach bool
+	// parameters of VolumeAttributesClasses are accepted if modifyVolumes is set
+	modifyVolumes bool
 	csi.UnimplementedControllerServer
 }
 
-func (c ControllerServer) ControllerExpandVolume(context.Context, *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+// ControllerExpandVolume only accepts the new capacity, since quotas of writable layers are grown by nodes.
+func (c ControllerServer) ControllerExpandVolume(_ context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	if len(req.VolumeId) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "VolumeId is missing")
+	}
+
+	size := req.GetCapacityRange().GetRequiredBytes()
+	if size <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "required bytes of CapacityRange are missing")
+	}
+
+	return &csi.ControllerExpandVolumeResponse{
+		CapacityBytes:         size,
+		NodeExpansionRequired: true,
+	}, nil
 }
 
 func (c ControllerServer) ControllerGetVolume(context.Context, *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "")
 }
 
+// DeleteVolume releases nothing, since images and writable layers of volumes are kept by runtimes of nodes rather
+// than the controller. Node plugins watching PVs remove them once PVs are deleted instead, if --reclaim-images and
+// --persistent-scratch-cleanup are enabled.
 func (c *ControllerServer) DeleteVolume(_ context.Context, _ *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
 	return &csi.DeleteVolumeResponse{}, nil
 }
 
-func (c ControllerServer) CreateVolume(_ context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+// CreateVolume provisions a volume of the image given by the StorageClass parameter image, or the annotation
+// csi.storage.k8s.io/image of the PVC. Other parameters are passed to nodes as volume attributes.
+func (c ControllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	if len(req.Name) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Name is missing")
+	}
+
+	if len(req.VolumeCapabilities) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "VolumeCapabilities are missing")
+	}
+
+	params, err := parseParameters(req.Parameters)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid StorageClass parameters: %s", err)
+	}
+
 	volumeSize := int64(defaultVolumeSize)
+	volumeContext := params.attributes
+	if len(req.MutableParameters) > 0 {
+		if !c.modifyVolumes {
+			return nil, status.Error(codes.InvalidArgument, "VolumeAttributesClasses are not enabled")
+		}
+
+		mutable, err := parseMutableParameters(req.MutableParameters)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid VolumeAttributesClass parameters: %s", err)
+		}
+
+		maps.Copy(volumeContext, mutable)
+	}
+
 	if req.GetCapacityRange() != nil {
 		volumeSize = req.GetCapacityRange().GetRequiredBytes()
+		if volumeSize > 0 {
+			// The requested capacity limits the writable layer if the volume is published read-write. Volumes
+			// requesting no capacity are not limited, rather than by the default size.
+			volumeContext[ctxKeyQuota] = strconv.FormatInt(volumeSize, 10)
+		}
 	}
 
-	volumeID, err := c.watcher.GetImage(req.Name)
+	image := params.image
+	if image == "" {
+		if image, err = c.watcher.GetImage(req.Name); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"neither parameter %s of the StorageClass nor the image annotation of the PVC is set: %s",
+				ctxKeyImage, err)
+		}
+	}
+
+	supported, err := c.validateImage(ctx, image, req.Secrets, params.secretRef)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get volume handle")
+		return nil, err
+	}
+
+	// Volumes are only accessible from nodes of the platform the StorageClass pins, or those the image supports.
+	if platform, found := volumeContext[ctxKeyPlatform]; found {
+		p, _ := platforms.Parse(platform)
+		supported = []ocispec.Platform{p}
+	}
+
+	// Writable layers, e.g. persistent scratch layers, are keyed by volume IDs, so each volume gets a unique ID
+	// derived from the name of its PV, and its image is passed to nodes as a volume attribute.
+	volumeContext[ctxKeyImage] = image
+	topologies := platformTopologies(supported)
+	contentSource := req.GetVolumeContentSource()
+	if source := contentSource.GetVolume(); source != nil {
+		node, err := c.scratchNode(ctx, source.VolumeId)
+		if err != nil {
+			return nil, err
+		}
+
+		// Clones own their writable layers, which are seeded from the layer of the source volume on the node
+		// having it. So, they are only accessible from the node.
+		volumeContext[ctxKeyPersistentScratch] = "true"
+		volumeContext[ctxKeyCloneSource] = source.VolumeId
+		topologies = []*csi.Topology{{Segments: map[string]string{topologyKeyHostname: node}}}
+	} else if snapshot := contentSource.GetSnapshot(); snapshot != nil {
+		node, err := c.snapshotNode(ctx, snapshot.SnapshotId)
+		if err != nil {
+			return nil, err
+		}
+
+		// Restored volumes own their writable layers as clones do, which are seeded from the snapshot
+		// saved on the node. So, they are only accessible from the node.
+		volumeContext[ctxKeyPersistentScratch] = "true"
+		volumeContext[ctxKeySnapshotSource] = snapshot.SnapshotId
+		topologies = []*csi.Topology{{Segments: map[string]string{topologyKeyHostname: node}}}
+	} else if contentSource != nil {
+		return nil, status.Error(codes.InvalidArgument, "unknown volume content source")
+	}
+
+	// Read-only volumes are pulled and mounted by each node independently, so they can be accessed from many
+	// nodes at once. Writable layers are local to nodes, so writable volumes can only be accessed from one node.
+	for _, capability := range req.VolumeCapabilities {
+		if err = validateVolumeCapability(capability, volumeContext); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "unsupported VolumeCapability: %s", err)
+		}
+	}
+
+	if err = checkAccessibility(req.GetAccessibilityRequirements(), topologies); err != nil {
+		return nil, err
 	}
 
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
-			VolumeId:      volumeID,
-			CapacityBytes: volumeSize,
+			VolumeId:           req.Name,
+			CapacityBytes:      volumeSize,
+			VolumeContext:      volumeContext,
+			ContentSource:      contentSource,
+			AccessibleTopology: topologies,
 		},
 	}, nil
 }
 
-// ControllerModifyVolume implements the required interface
-func (cs *ControllerServer) ControllerModifyVolume(context.Context, *csi.ControllerModifyVolumeRequest) (*csi.ControllerModifyVolumeResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+// validateImage checks that the image exists in its registry, using credentials in the provisioner secret
+// and the secret referred by secretRef of the StorageClass, and returns platforms the image supports if it is
+// an index. The referred secret must exist even if images are not validated, since nodes pull images with it.
+func (c ControllerServer) validateImage(
+	ctx context.Context, image string, secrets map[string]string, ref *secretRef,
+) ([]ocispec.Platform, error) {
+	namedRef, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid image %q: %s", image, err)
+	}
+
+	var refKeyring secret.DockerKeyring
+	if ref != nil {
+		if refKeyring, err = secret.FetchKeyring(ctx, c.kubeClient, ref.namespace, ref.name); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s", paramSecretRef, err)
+		}
+	}
+
+	if !c.validateImages {
+		return nil, nil
+	}
+
+	keyring, err := c.secretStore.GetDockerKeyring(ctx, secrets)
+	if err != nil {
+		return nil, status.Errorf(codes.Aborted, "unable to fetch keyring: %s", err)
+	}
+
+	if refKeyring != nil {
+		keyring = secret.UnionDockerKeyring{keyring, refKeyring}
+	}
+
+	dgst, err := remoteimage.Resolve(ctx, namedRef, keyring)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "image %q is not found: %s", image, err)
+		}
+
+		return nil, status.Errorf(codes.Unavailable, "unable to resolve image %q: %s", image, err)
+	}
+
+	supported, err := remoteimage.Platforms(ctx, namedRef, keyring)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "unable to fetch platforms of image %q: %s", image, err)
+	}
+
+	klog.Infof("provision volume of image %q with digest %s", image, dgst)
+	return supported, nil
+}
+
+// ControllerPublishVolume only acknowledges attachments. Node plugins watch VolumeAttachments, which are created
+// right before this is called, and start pulling images of the attached PVs.
+func (c *ControllerServer) ControllerPublishVolume(_ context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
+	if !c.prePullOnAttach {
+		return nil, status.Error(codes.Unimplemented, "")
+	}
+
+	if len(req.VolumeId) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "VolumeId is missing")
+	}
+
+	if len(req.NodeId) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodeId is missing")
+	}
+
+	if req.VolumeCapability == nil {
+		return nil, status.Error(codes.InvalidArgument, "VolumeCapability is missing")
+	}
+
+	klog.V(4).Infof("volume %q is attached to node %s", req.VolumeId, req.NodeId)
+	return &csi.ControllerPublishVolumeResponse{}, nil
+}
+
+// ControllerUnpublishVolume does nothing since attaching volumes doesn't change nodes.
+func (c *ControllerServer) ControllerUnpublishVolume(_ context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
+	if !c.prePullOnAttach {
+		return nil, status.Error(codes.Unimplemented, "")
+	}
+
+	if len(req.VolumeId) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "VolumeId is missing")
+	}
+
+	return &csi.ControllerUnpublishVolumeResponse{}, nil
+}
+
+// ControllerModifyVolume accepts mutable parameters of VolumeAttributesClasses. Nothing is changed here, since the
+// resizer sets the class on the PV once accepted, and nodes apply its parameters when they publish the PV next time.
+func (c *ControllerServer) ControllerModifyVolume(_ context.Context, req *csi.ControllerModifyVolumeRequest) (*csi.ControllerModifyVolumeResponse, error) {
+	if !c.modifyVolumes {
+		return nil, status.Error(codes.Unimplemented, "")
+	}
+
+	if len(req.VolumeId) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "VolumeId is missing")
+	}
+
+	if _, err := parseMutableParameters(req.MutableParameters); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid VolumeAttributesClass parameters: %s", err)
+	}
+
+	return &csi.ControllerModifyVolumeResponse{}, nil
 }
 
 // ControllerGetCapabilities returns the capabilities of the controller service.
 func (c *ControllerServer) ControllerGetCapabilities(_ context.Context, _ *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
-	return &csi.ControllerGetCapabilitiesResponse{
-		Capabilities: []*csi.ControllerServiceCapability{
-			{
-				Type: &csi.ControllerServiceCapability_Rpc{
-					Rpc: &csi.ControllerServiceCapability_RPC{
-						Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
-					},
+	rpcs := []csi.ControllerServiceCapability_RPC_Type{
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+		csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+		csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+	}
+
+	if c.snapshotContents != nil {
+		rpcs = append(rpcs, csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT)
+	}
+
+	if c.prePullOnAttach {
+		rpcs = append(rpcs, csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME)
+	}
+
+	if c.modifyVolumes {
+		rpcs = append(rpcs, csi.ControllerServiceCapability_RPC_MODIFY_VOLUME)
+	}
+
+	var capabilities []*csi.ControllerServiceCapability
+	for _, rpc := range rpcs {
+		capabilities = append(capabilities, &csi.ControllerServiceCapability{
+			Type: &csi.ControllerServiceCapability_Rpc{
+				Rpc: &csi.ControllerServiceCapability_RPC{
+					Type: rpc,
 				},
 			},
-		},
-	}, nil
+		})
+	}
+
+	return &csi.ControllerGetCapabilitiesResponse{Capabilities: capabilities}, nil
 }
 
 // ValidateVolumeCapabilities validates the volume capabilities.
 func (c *ControllerServer) ValidateVolumeCapabilities(_ context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
+	if len(req.VolumeId) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "VolumeId is missing")
+	}
+
+	if len(req.VolumeCapabilities) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "VolumeCapabilities are missing")
+	}
+
 	for _, cap := range req.VolumeCapabilities {
-		if cap.AccessMode.Mode != csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY &&
-			cap.AccessMode.Mode != csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY {
+		if err := validateVolumeCapability(cap, req.VolumeContext); err != nil {
 			return &csi.ValidateVolumeCapabilitiesResponse{
-				Message: "Only ReadOnlyMany or ReadOnlyOnce access modes are supported",
+				Message: err.Error(),
 			}, nil
 		}
 	}
@@ -107,19 +373,122 @@ func (c *ControllerServer) ListVolumes(_ context.Context, _ *csi.ListVolumesRequ
 	return nil, status.Error(codes.Unimplemented, "")
 }
 
-// GetCapacity is not implemented.
-func (c *ControllerServer) GetCapacity(_ context.Context, _ *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+// GetCapacity reports the allocatable ephemeral storage of nodes in the given topology, which is used by the
+// scheduler to avoid nodes that can't hold images of volumes if storage capacity tracking is enabled.
+func (c *ControllerServer) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
+	capacity, err := c.nodeCapacity(ctx, req.GetAccessibleTopology())
+	if err != nil {
+		return nil, err
+	}
+
+	return &csi.GetCapacityResponse{
+		AvailableCapacity: capacity,
+		MaximumVolumeSize: wrapperspb.Int64(capacity),
+	}, nil
 }
 
-// CreateSnapshot is not implemented.
-func (c *ControllerServer) CreateSnapshot(_ context.Context, _ *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+// CreateSnapshot returns the snapshot of the VolumeSnapshotContent given by the extra metadata of the snapshotter.
+// Writable layers are saved by nodes watching VolumeSnapshotContents, so the snapshot is ready once a node
+// which holds the writable layer of the source volume has saved it, and pushed it if pushImage is set.
+func (c *ControllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	if len(req.Name) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Name is missing")
+	}
+
+	if len(req.SourceVolumeId) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "SourceVolumeId is missing")
+	}
+
+	if c.snapshotContents == nil {
+		return nil, status.Error(codes.Unimplemented, "volume snapshots are not enabled")
+	}
+
+	name := req.Parameters[paramSnapshotContentName]
+	if name == "" {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"parameter %s is missing. the snapshotter must run with --extra-create-metadata", paramSnapshotContentName)
+	}
+
+	if target := req.Parameters[paramPushImage]; target != "" {
+		if _, err := reference.ParseNormalizedNamed(target); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s %q: %s", paramPushImage, target, err)
+		}
+	}
+
+	content, err := c.snapshotContents.Get(ctx, name)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "unable to get VolumeSnapshotContent %s: %s", name, err)
+	}
+
+	if content.VolumeHandle != req.SourceVolumeId {
+		return nil, status.Errorf(codes.AlreadyExists, "VolumeSnapshotContent %s is of volume %q rather than %q",
+			name, content.VolumeHandle, req.SourceVolumeId)
+	}
+
+	return &csi.CreateSnapshotResponse{
+		Snapshot: &csi.Snapshot{
+			SnapshotId:     name,
+			SourceVolumeId: req.SourceVolumeId,
+			CreationTime:   timestamppb.New(content.CreationTimestamp),
+			ReadyToUse:     content.Node != "",
+		},
+	}, nil
 }
 
-// DeleteSnapshot is not implemented.
-func (c *ControllerServer) DeleteSnapshot(_ context.Context, _ *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+// DeleteSnapshot does nothing since nodes remove snapshots once their VolumeSnapshotContents are deleted.
+func (c *ControllerServer) DeleteSnapshot(_ context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	if len(req.SnapshotId) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "SnapshotId is missing")
+	}
+
+	return &csi.DeleteSnapshotResponse{}, nil
+}
+
+// snapshotNode returns the node which saved the snapshot.
+func (c *ControllerServer) snapshotNode(ctx context.Context, snapshotID string) (string, error) {
+	if c.snapshotContents == nil {
+		return "", status.Error(codes.InvalidArgument, "volume snapshots are not enabled")
+	}
+
+	content, err := c.snapshotContents.Get(ctx, snapshotID)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", status.Errorf(codes.NotFound, "snapshot %q is not found", snapshotID)
+		}
+
+		return "", status.Errorf(codes.Unavailable, "unable to get VolumeSnapshotContent %s: %s", snapshotID, err)
+	}
+
+	if content.Node == "" {
+		return "", status.Errorf(codes.Unavailable, "snapshot %q is not ready", snapshotID)
+	}
+
+	return content.Node, nil
+}
+
+// scratchNode returns the node having the persistent scratch layer of the volume, which node plugins annotate the
+// PV of the volume with. PVs provisioned by the driver are named by their volume IDs.
+func (c *ControllerServer) scratchNode(ctx context.Context, volumeID string) (string, error) {
+	if c.kubeClient == nil {
+		return "", status.Error(codes.InvalidArgument, "volume clones are not enabled")
+	}
+
+	pv, err := c.kubeClient.CoreV1().PersistentVolumes().Get(ctx, volumeID, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", status.Errorf(codes.NotFound, "source volume %q is not found", volumeID)
+		}
+
+		return "", status.Errorf(codes.Unavailable, "unable to get PV %s: %s", volumeID, err)
+	}
+
+	node := pv.Annotations[ScratchNodeAnnotation]
+	if node == "" {
+		return "", status.Errorf(codes.Unavailable,
+			"source volume %q hasn't been published with a persistent scratch layer on any node", volumeID)
+	}
+
+	return node, nil
 }
 
 // ListSnapshots is not implemented.
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	csicommon "github.com/warm-metal/container-image-csi-driver/pkg/csi-common"
+	"github.com/warm-metal/container-image-csi-driver/pkg/secret"
+	"github.com/warm-metal/container-image-csi-driver/pkg/volume"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCreateVolume(t *testing.T) {
+	driver := csicommon.NewCSIDriver(driverName, driverVersion, "fake-node")
+	c := NewControllerServer(driver, nil, secret.CreateStoreOrDie("", "", "", false), nil, false)
+	capabilities := []*csi.VolumeCapability{{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}}
+	params := map[string]string{ctxKeyImage: "docker.io/library/redis:latest", ctxKeyPersistentScratch: "true"}
+
+	// Volumes of the same StorageClass must not share writable layers, which are keyed by volume IDs.
+	ids := map[string]bool{}
+	for _, name := range []string{"pvc-1", "pvc-2"} {
+		resp, err := c.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+			Name:               name,
+			Parameters:         params,
+			VolumeCapabilities: capabilities,
+		})
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		assert.Equal(t, name, resp.Volume.VolumeId)
+		assert.Equal(t, "docker.io/library/redis:latest", resp.Volume.VolumeContext[ctxKeyImage])
+		assert.Equal(t, "docker.io/library/redis:latest", volume.Image(resp.Volume.VolumeId, resp.Volume.VolumeContext))
+		ids[resp.Volume.VolumeId] = true
+	}
+
+	assert.Len(t, ids, 2)
+
+	// Only requested capacities limit writable layers.
+	resp, err := c.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               "pvc-sized",
+		Parameters:         params,
+		VolumeCapabilities: capabilities,
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 2 << 30},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "2147483648", resp.Volume.VolumeContext[ctxKeyQuota])
+	assert.EqualValues(t, 2<<30, resp.Volume.CapacityBytes)
+
+	resp, err = c.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               "pvc-unsized",
+		Parameters:         params,
+		VolumeCapabilities: capabilities,
+	})
+	require.NoError(t, err)
+	assert.NotContains(t, resp.Volume.VolumeContext, ctxKeyQuota)
+}
+
+func TestCreateVolumeFromVolume(t *testing.T) {
+	driver := csicommon.NewCSIDriver(driverName, driverVersion, "fake-node")
+	client := fake.NewSimpleClientset(
+		&corev1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{
+			Name:        "pvc-source",
+			Annotations: map[string]string{ScratchNodeAnnotation: "node-1"},
+		}},
+		&corev1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pvc-unpublished"}},
+	)
+	c := NewControllerServer(driver, nil, secret.CreateStoreOrDie("", "", "", false), client, false)
+	capabilities := []*csi.VolumeCapability{{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}}
+	clone := func(source string) (*csi.CreateVolumeResponse, error) {
+		return c.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+			Name:               "pvc-clone",
+			Parameters:         map[string]string{ctxKeyImage: "docker.io/library/redis:latest"},
+			VolumeCapabilities: capabilities,
+			VolumeContentSource: &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Volume{
+				Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: source},
+			}},
+		})
+	}
+
+	// Clones are only accessible from the node having the layer of the source volume.
+	resp, err := clone("pvc-source")
+	require.NoError(t, err)
+	assert.Equal(t, "true", resp.Volume.VolumeContext[ctxKeyPersistentScratch])
+	assert.Equal(t, "pvc-source", resp.Volume.VolumeContext[ctxKeyCloneSource])
+	assert.Equal(t, []*csi.Topology{{Segments: map[string]string{topologyKeyHostname: "node-1"}}},
+		resp.Volume.AccessibleTopology)
+
+	_, err = clone("pvc-unpublished")
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	_, err = clone("pvc-missing")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
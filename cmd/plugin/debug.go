@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"path/filepath"
+	rpprof "runtime/pprof"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog/v2"
+	k8smount "k8s.io/mount-utils"
+)
+
+// mountPoint is an entry of the mount table of the node.
+type mountPoint struct {
+	Device string   `json:"device"`
+	Path   string   `json:"path"`
+	Type   string   `json:"type"`
+	Opts   []string `json:"opts"`
+}
+
+// debugState is the state of the node plugin along with the mount table of its volumes.
+type debugState struct {
+	*nodeState
+	// Mounts are mounts of volumes of the driver, and mounts under its staging and data directories.
+	Mounts []mountPoint `json:"mounts"`
+}
+
+// startDebugServer serves pprof profiles, goroutine dumps, and the state dump of state if it is not nil, on the
+// loopback interface only, since they reveal internals of the driver. Failures are logged instead of stopping the
+// driver, which works without them.
+func startDebugServer(port int, state http.HandlerFunc) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("GET /debug/goroutines", serveGoroutines)
+	if state != nil {
+		mux.HandleFunc("GET /debug/state", state)
+	}
+
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	go func() {
+		klog.Infof("serving debug endpoints at %s", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			klog.Errorf("unable to serve debug endpoints: %s", err)
+		}
+	}()
+}
+
+// serveGoroutines writes stacks of all goroutines in the format of panics.
+func serveGoroutines(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := rpprof.Lookup("goroutine").WriteTo(w, 2); err != nil {
+		klog.Errorf("unable to dump goroutines: %s", err)
+	}
+}
+
+// serveDebugState returns the handler dumping the state of the node plugin, and mounts of its volumes and under the
+// directories.
+func (n NodeServer) serveDebugState(dirs ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state := n.Inspect(r.Context())
+		roots := append([]string{}, dirs...)
+		for _, v := range state.Volumes {
+			roots = append(roots, v.Target)
+			roots = append(roots, v.Publications...)
+		}
+
+		mounts, err := listMounts(roots)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("unable to list mounts: %s", err), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, &debugState{nodeState: state, Mounts: mounts})
+	}
+}
+
+// listMounts returns mounts at or under the directories.
+func listMounts(roots []string) ([]mountPoint, error) {
+	all, err := k8smount.New("").List()
+	if err != nil {
+		return nil, err
+	}
+
+	mounts := []mountPoint{}
+	for _, m := range all {
+		for _, root := range roots {
+			if m.Path == root || strings.HasPrefix(m.Path, filepath.Clean(root)+string(filepath.Separator)) {
+				mounts = append(mounts, mountPoint{Device: m.Device, Path: m.Path, Type: m.Type, Opts: m.Opts})
+				break
+			}
+		}
+	}
+
+	return mounts, nil
+}
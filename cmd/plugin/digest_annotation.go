@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
+	"github.com/warm-metal/container-image-csi-driver/pkg/remoteimage"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// DigestAnnotationPrefix prefixes annotations of pods set to digests of images their volumes are mounted from, e.g.
+// digest.container-image.csi.k8s.io/models: sha256:... for the volume models.
+const DigestAnnotationPrefix = "digest.container-image.csi.k8s.io/"
+
+// digestAnnotationTimeout bounds annotating a pod.
+const digestAnnotationTimeout = 30 * time.Second
+
+// podVolumeName returns the name of the volume in the pod the target is published for. Targets are
+// pods/<pod UID>/volumes/kubernetes.io~csi/<volume>/mount for filesystem volumes, and
+// volumeDevices/publish/<volume>/<pod UID> for block volumes, where PVs are named by their PV names.
+func podVolumeName(target string) string {
+	return filepath.Base(filepath.Dir(target))
+}
+
+// annotateDigest annotates the pod the volume is published for with the digest of the image in background, so that
+// admission and audit systems can record the content each pod receives. Pods are identified by the pod info in
+// volumeContext. Failures are logged without failing the publication.
+func (n NodeServer) annotateDigest(volumeContext map[string]string, target string, image reference.Named) {
+	namespace, name := volumeContext[ctxKeyPodNamespace], volumeContext[ctxKeyPodName]
+	if n.podAnnotations == nil || namespace == "" || name == "" {
+		return
+	}
+
+	if !n.background.start() {
+		return
+	}
+
+	go func() {
+		defer n.background.done()
+		ctx, cancel := context.WithTimeout(context.Background(), digestAnnotationTimeout)
+		defer cancel()
+
+		digest, err := remoteimage.LocalDigest(ctx, n.imageSvc, image)
+		if err != nil {
+			klog.Errorf("unable to resolve the digest of image %q: %s", image, err)
+			metrics.OperationErrorsCount.WithLabelValues("annotate-digest").Inc()
+			return
+		}
+
+		metadata := map[string]interface{}{
+			"annotations": map[string]string{DigestAnnotationPrefix + podVolumeName(target): digest.String()},
+		}
+		if uid := volumeContext[ctxKeyPodUID]; uid != "" {
+			// The UID fails the patch if the pod is recreated with the same name.
+			metadata["uid"] = uid
+		}
+
+		patch, err := json.Marshal(map[string]interface{}{"metadata": metadata})
+		if err != nil {
+			klog.Errorf("unable to encode the digest annotation: %s", err)
+			return
+		}
+
+		if _, err = n.podAnnotations.CoreV1().Pods(namespace).Patch(ctx, name, types.MergePatchType, patch,
+			metav1.PatchOptions{}); err != nil {
+			klog.Errorf("unable to annotate pod %s/%s with the digest of image %q: %s", namespace, name, image, err)
+			metrics.OperationErrorsCount.WithLabelValues("annotate-digest").Inc()
+			return
+		}
+
+		klog.V(2).Infof("annotated pod %s/%s with digest %s of image %q", namespace, name, digest, image)
+	}()
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/warm-metal/container-image-csi-driver/pkg/secret"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// Pod information kubelet passes to volumes since podInfoOnMount of the CSIDriver is set.
+const (
+	ctxKeyPodName            = "csi.storage.k8s.io/pod.name"
+	ctxKeyPodUID             = "csi.storage.k8s.io/pod.uid"
+	ctxKeyPodServiceAccount  = "csi.storage.k8s.io/serviceAccount.name"
+	ctxKeyKubeletAttrsPrefix = "csi.storage.k8s.io/"
+)
+
+// ephemeralAttributes are the volume attributes ephemeral volumes accept besides those set by kubelet.
+// Attributes of persistent writable layers and block volumes only apply to PVs.
+var ephemeralAttributes = []string{
+	ctxKeyImage, ctxKeySecret, ctxKeySecretNamespace, ctxKeyPullAlways, ctxKeyPullTimeout, ctxKeyFSType, ctxKeyQuota,
+	ctxKeyUpperLayer, ctxKeyPath, ctxKeyOverlayImages, ctxKeyMountOptions, ctxKeyImageMetadata, ctxKeyReferrers,
+	ctxKeyRequiredAttestations, ctxKeyContainerDisk, ctxKeyPlatform, ctxKeyBackend,
+}
+
+// podInfo identifies the pod an ephemeral volume belongs to.
+type podInfo struct {
+	name, namespace, uid, serviceAccount string
+}
+
+func (p podInfo) String() string {
+	return fmt.Sprintf("%s/%s(%s)", p.namespace, p.name, p.uid)
+}
+
+func isEphemeralVolume(volumeContext map[string]string) bool {
+	return strings.ToLower(volumeContext[ctxKeyEphemeralVolume]) == "true"
+}
+
+// validateEphemeralVolume checks the attributes of an ephemeral volume, which are written by pod authors
+// rather than cluster admins, so that unknown attributes fail instead of being ignored.
+func validateEphemeralVolume(volumeContext map[string]string) (*podInfo, error) {
+	for k := range volumeContext {
+		if !strings.HasPrefix(k, ctxKeyKubeletAttrsPrefix) && !slices.Contains(ephemeralAttributes, k) {
+			return nil, fmt.Errorf("attribute %q is not supported by ephemeral volumes, must be one of %s",
+				k, strings.Join(ephemeralAttributes, ", "))
+		}
+	}
+
+	if volumeContext[ctxKeyImage] == "" {
+		return nil, fmt.Errorf("attribute %q is required by ephemeral volumes", ctxKeyImage)
+	}
+
+	pod := &podInfo{
+		name:           volumeContext[ctxKeyPodName],
+		namespace:      volumeContext[ctxKeyPodNamespace],
+		uid:            volumeContext[ctxKeyPodUID],
+		serviceAccount: volumeContext[ctxKeyPodServiceAccount],
+	}
+	if pod.namespace == "" {
+		return nil, fmt.Errorf("attribute %q is missing. podInfoOnMount of the CSIDriver must be enabled",
+			ctxKeyPodNamespace)
+	}
+
+	return pod, nil
+}
+
+// podKeyring adds credentials of the image pull secrets of the service account of the pod to the keyring,
+// so that ephemeral volumes are pulled with the same secrets as the containers of the pod.
+func (n NodeServer) podKeyring(
+	ctx context.Context, pod *podInfo, keyring secret.DockerKeyring,
+) (secret.DockerKeyring, error) {
+	if n.kubeClient == nil || pod.serviceAccount == "" {
+		return keyring, nil
+	}
+
+	podKeyring, err := secret.FetchServiceAccountKeyring(ctx, n.kubeClient, pod.namespace, pod.serviceAccount)
+	if err != nil {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+
+	return secret.UnionDockerKeyring{
+		keyring, secret.WithSource(podKeyring, secret.ServiceAccountSource(pod.namespace, pod.serviceAccount)),
+	}, nil
+}
+
+// serviceAccountTokenKeyring prepends credentials of plugins receiving service account tokens of the pod to the
+// keyring, if tokenRequests of the CSIDriver is set. Tokens are passed in secrets instead of volume attributes if
+// serviceAccountTokenInSecrets of the CSIDriver is set.
+func serviceAccountTokenKeyring(
+	req *csi.NodePublishVolumeRequest, keyring secret.DockerKeyring,
+) (secret.DockerKeyring, error) {
+	data := req.Secrets[secret.ServiceAccountTokensKey]
+	if data == "" {
+		data = req.VolumeContext[secret.ServiceAccountTokensKey]
+	}
+
+	if data == "" {
+		return keyring, nil
+	}
+
+	tokens, err := secret.ParseServiceAccountTokens(data)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return secret.UnionDockerKeyring{
+		secret.WithSource(secret.NewServiceAccountTokenKeyring(tokens), secret.SourceServiceAccountToken), keyring,
+	}, nil
+}
+
+// cleanupEphemeralTarget removes the target of an ephemeral volume which failed to be mounted. Ephemeral volumes
+// never outlive their pods, so targets are not left behind for retries, which create them again.
+func cleanupEphemeralTarget(pod *podInfo, target string) {
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		klog.Warningf("unable to remove target %q of ephemeral volume of pod %s: %s", target, pod, err)
+	}
+}
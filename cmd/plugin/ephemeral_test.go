@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateEphemeralVolume(t *testing.T) {
+	tests := []struct {
+		name          string
+		volumeContext map[string]string
+		wantErr       bool
+	}{
+		{
+			name: "valid",
+			volumeContext: map[string]string{
+				ctxKeyImage:             "docker.io/library/redis:latest",
+				ctxKeyPullAlways:        "true",
+				ctxKeyEphemeralVolume:   "true",
+				ctxKeyPodNamespace:      "default",
+				ctxKeyPodName:           "test-pod",
+				ctxKeyPodServiceAccount: "default",
+			},
+		},
+		{
+			name: "unknown attribute",
+			volumeContext: map[string]string{
+				ctxKeyImage:           "docker.io/library/redis:latest",
+				"pullAllways":         "true",
+				ctxKeyEphemeralVolume: "true",
+				ctxKeyPodNamespace:    "default",
+			},
+			wantErr: true,
+		},
+		{
+			name: "persistent scratch",
+			volumeContext: map[string]string{
+				ctxKeyImage:             "docker.io/library/redis:latest",
+				ctxKeyPersistentScratch: "true",
+				ctxKeyEphemeralVolume:   "true",
+				ctxKeyPodNamespace:      "default",
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing image",
+			volumeContext: map[string]string{
+				ctxKeyEphemeralVolume: "true",
+				ctxKeyPodNamespace:    "default",
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing pod info",
+			volumeContext: map[string]string{
+				ctxKeyImage:           "docker.io/library/redis:latest",
+				ctxKeyEphemeralVolume: "true",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod, err := validateEphemeralVolume(tt.volumeContext)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.volumeContext[ctxKeyPodNamespace], pod.namespace)
+			assert.Equal(t, tt.volumeContext[ctxKeyPodServiceAccount], pod.serviceAccount)
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Reasons of events of mount failures, named after image errors of kubelet so that the same alerts match them.
+const (
+	ReasonErrImagePull          = "ErrImagePull"
+	ReasonErrAuth               = "ErrAuth"
+	ReasonErrSnapshotterMissing = "ErrSnapshotterMissing"
+	ReasonErrDiskPressure       = "ErrDiskPressure"
+)
+
+// ReasonImagePolicyViolation is the reason of events of volumes refused by ClusterImageVolumePolicies or registry
+// rules.
+const ReasonImagePolicyViolation = "ImagePolicyViolation"
+
+// newEventRecorder returns the recorder emitting events of the node plugin on the node.
+func newEventRecorder(client kubernetes.Interface, nodeID string) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: driverName, Host: nodeID})
+}
+
+func containsAny(s string, substrs ...string) bool {
+	for _, substr := range substrs {
+		if strings.Contains(s, substr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isDiskPressure(msg string) bool {
+	return containsAny(msg, "no space left on device", "disk quota exceeded")
+}
+
+// pullFailureReason classifies the failure of a pull by the error of the runtime, which only reports it in messages.
+func pullFailureReason(err error) string {
+	msg := strings.ToLower(err.Error())
+	switch {
+	case status.Code(err) == codes.PermissionDenied:
+		return ReasonImagePolicyViolation
+	case isDiskPressure(msg):
+		return ReasonErrDiskPressure
+	case containsAny(msg, "unauthorized", "authentication required", "access denied", "denied:", "forbidden"):
+		return ReasonErrAuth
+	default:
+		return ReasonErrImagePull
+	}
+}
+
+// mountFailureReason classifies the failure of a mount, or returns "" if it is none of the reasons, in which case
+// the FailedMount event of kubelet tells enough.
+func mountFailureReason(err error) string {
+	msg := strings.ToLower(err.Error())
+	switch {
+	case isDiskPressure(msg):
+		return ReasonErrDiskPressure
+	case strings.Contains(msg, "snapshotter") && containsAny(msg, "not enabled", "not loaded", "not found"):
+		return ReasonErrSnapshotterMissing
+	default:
+		return ""
+	}
+}
+
+// recordMountFailure emits a warning event of the reason to the pod the volume is published for, which is identified
+// by the pod info in volumeContext, so that users see why their pods are stuck without reading logs of the driver.
+func (n NodeServer) recordMountFailure(volumeContext map[string]string, image, reason string, err error) {
+	if reason == "" {
+		return
+	}
+
+	n.recordPodEvent(volumeContext[ctxKeyPodNamespace], volumeContext[ctxKeyPodName], volumeContext[ctxKeyPodUID],
+		corev1.EventTypeWarning, reason, fmt.Sprintf("Failed to mount image %q: %s", image, err))
+}
+
+// recordPodEvent emits an event to the pod namespace/name if events are enabled and the pod is known.
+func (n NodeServer) recordPodEvent(namespace, name, uid, eventtype, reason, message string) {
+	if n.events == nil || namespace == "" || name == "" {
+		return
+	}
+
+	pod := &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Namespace:  namespace,
+		Name:       name,
+		UID:        types.UID(uid),
+	}
+	n.events.Event(pod, eventtype, reason, message)
+}
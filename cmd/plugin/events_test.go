@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestMountFailureEvents(t *testing.T) {
+	assert.Equal(t, ReasonErrAuth,
+		pullFailureReason(fmt.Errorf("failed to authorize: 401 Unauthorized")))
+	assert.Equal(t, ReasonErrImagePull, pullFailureReason(fmt.Errorf("docker.io/library/redis:foo: not found")))
+	assert.Equal(t, ReasonErrDiskPressure, pullFailureReason(fmt.Errorf("write /var/lib: no space left on device")))
+	assert.Equal(t, ReasonErrSnapshotterMissing,
+		mountFailureReason(fmt.Errorf(`snapshotter "erofs" is not enabled in containerd`)))
+	assert.Empty(t, mountFailureReason(fmt.Errorf("permission denied")))
+
+	ns := newTestNodeServer(t, testNodeOptions{})
+	recorder := record.NewFakeRecorder(10)
+	ns.events = recorder
+
+	image := "docker.io/library/redis:latest"
+	podContext := map[string]string{ctxKeyPodNamespace: "default", ctxKeyPodName: "redis"}
+	ns.recordMountFailure(podContext, image, ReasonErrAuth, fmt.Errorf("401 Unauthorized"))
+	ns.recordMountFailure(map[string]string{}, image, ReasonErrAuth, fmt.Errorf("401 Unauthorized"))
+	ns.recordMountFailure(podContext, image, "", fmt.Errorf("permission denied"))
+	close(recorder.Events)
+
+	var events []string
+	for event := range recorder.Events {
+		events = append(events, event)
+	}
+	assert.Equal(t, []string{`Warning ErrAuth Failed to mount image "docker.io/library/redis:latest": 401 Unauthorized`},
+		events)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	cri "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// gcStagingDirMinAge is the minimum age of stale staging directories removed on demand if the janitor is
+	// disabled, so that directories of volumes being mounted are kept.
+	gcStagingDirMinAge = 10 * time.Minute
+	// gcTimeout bounds garbage collections on demand.
+	gcTimeout = 10 * time.Minute
+)
+
+// gcReport lists garbage found on the node, and how much of it is removed.
+type gcReport struct {
+	// Images are images pulled by the driver since it started which no volumes on the node use.
+	Images        []string `json:"images"`
+	RemovedImages int      `json:"removedImages"`
+	// ReclaimedBytes is the compressed size of images removed.
+	ReclaimedBytes uint64 `json:"reclaimedBytes"`
+	// Janitor is the report of the janitor, if the mounter runs it.
+	Janitor *backend.JanitorReport `json:"janitor,omitempty"`
+}
+
+// CollectGarbage reports, and removes unless opts.DryRun is set, images pulled by the driver which no volumes on the
+// node use, as well as stale snapshots, runtime resources, and staging directories via the janitor of the mounter,
+// the same as its background runs. Images pinned by the runtime, e.g. the sandbox image, are kept. Images also used
+// by containers are pulled by kubelet again once needed. Collections which aren't dry runs are recorded in metrics.
+func (n NodeServer) CollectGarbage(ctx context.Context, opts backend.JanitorOptions) (report *gcReport, err error) {
+	report = &gcReport{Images: []string{}}
+	if !opts.DryRun {
+		start := time.Now()
+		defer func() {
+			metrics.ObserveGC(report.RemovedImages, report.ReclaimedBytes, time.Since(start).Seconds(), err != nil)
+		}()
+	}
+
+	if gc, ok := n.mounter.(backend.GarbageCollector); ok {
+		janitor := gc.CollectGarbage(ctx, opts)
+		report.Janitor = &janitor
+	}
+
+	user, ok := n.mounter.(backend.ImageUser)
+	if !ok {
+		return report, nil
+	}
+
+	if !n.reclaimer.lock.TryLock() {
+		return report, status.Error(codes.Unavailable, "volumes are being published. retry later")
+	}
+	defer n.reclaimer.lock.Unlock()
+
+	for _, image := range n.localImages.list() {
+		namedRef, err := reference.ParseDockerRef(image)
+		if err != nil || user.ImageInUse(namedRef) {
+			continue
+		}
+
+		resp, err := n.imageSvc.ImageStatus(ctx, &cri.ImageStatusRequest{Image: &cri.ImageSpec{Image: image}})
+		if err != nil {
+			return report, status.Errorf(codes.Internal, "unable to fetch status of image %q: %s", image, err)
+		}
+
+		if resp.Image == nil {
+			n.localImages.remove(image)
+			continue
+		}
+
+		if resp.Image.Pinned {
+			continue
+		}
+
+		report.Images = append(report.Images, image)
+		if opts.DryRun {
+			klog.Infof("[dry-run] would remove unused image %q", image)
+			continue
+		}
+
+		if _, err = n.imageSvc.RemoveImage(ctx, &cri.RemoveImageRequest{
+			Image: &cri.ImageSpec{Image: image},
+		}); err != nil {
+			klog.Errorf("unable to remove unused image %q: %s", image, err)
+			continue
+		}
+
+		n.localImages.remove(image)
+		report.RemovedImages++
+		report.ReclaimedBytes += resp.Image.Size
+		klog.Infof("removed unused image %q", image)
+	}
+
+	return report, nil
+}
+
+// serveGC collects garbage on requests. Garbage is only reported unless the query confirm is true.
+func (n NodeServer) serveGC(opts backend.JanitorOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		confirm, _ := strconv.ParseBool(r.URL.Query().Get("confirm"))
+		opts := opts
+		opts.DryRun = !confirm
+		klog.Infof("collect garbage on demand, confirm: %t", confirm)
+		ctx, cancel := context.WithTimeout(r.Context(), gcTimeout)
+		defer cancel()
+
+		report, err := n.CollectGarbage(ctx, opts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		writeJSON(w, report)
+	}
+}
+
+// requestGC asks the node plugin listening on the socket to collect garbage, and prints the report.
+func requestGC(socket string, confirm bool) error {
+	report := &gcReport{}
+	if err := callAdmin(socket, http.MethodPost, fmt.Sprintf("/gc?confirm=%t", confirm), report); err != nil {
+		return err
+	}
+
+	for _, image := range report.Images {
+		fmt.Printf("image %q\n", image)
+	}
+
+	fmt.Printf("%d unused images found", len(report.Images))
+	if confirm {
+		fmt.Printf(", %d removed, %d bytes reclaimed", report.RemovedImages, report.ReclaimedBytes)
+	}
+	fmt.Println()
+
+	if report.Janitor != nil {
+		for _, stale := range report.Janitor.Stale {
+			fmt.Println(stale)
+		}
+
+		fmt.Printf("%d stale snapshots or staging directories and %d stale runtime resources found",
+			len(report.Janitor.Stale), report.Janitor.RuntimeResources)
+		if confirm {
+			fmt.Printf(", %d removed", report.Janitor.Removed)
+		}
+		fmt.Println()
+	}
+
+	if !confirm {
+		fmt.Println("Nothing is removed. Rerun with --confirm to remove them.")
+	}
+
+	return nil
+}
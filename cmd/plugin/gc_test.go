@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/distribution/reference"
+	"github.com/stretchr/testify/assert"
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+	fakeruntime "github.com/warm-metal/container-image-csi-driver/pkg/fake"
+	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	images := fakeruntime.NewImageService()
+	mounter := fakeruntime.NewMounter(images)
+	ns := newTestNodeServer(t, testNodeOptions{images: images, mounter: mounter})
+
+	ctx := context.Background()
+	for _, image := range []string{"docker.io/library/redis:latest", "docker.io/library/nginx:latest"} {
+		_, err := images.PullImage(ctx, &criapi.PullImageRequest{Image: &criapi.ImageSpec{Image: image}})
+		assert.NoError(t, err)
+		ns.localImages.add(image)
+	}
+
+	namedRef, err := reference.ParseDockerRef("docker.io/library/redis:latest")
+	assert.NoError(t, err)
+	assert.NoError(t, mounter.Mount(ctx, "vol", "/target", namedRef, backend.MountOptions{}))
+
+	report, err := ns.CollectGarbage(ctx, backend.JanitorOptions{DryRun: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"docker.io/library/nginx:latest"}, report.Images)
+	assert.Zero(t, report.RemovedImages)
+	assert.True(t, images.Pulled("docker.io/library/nginx:latest"))
+
+	report, err = ns.CollectGarbage(ctx, backend.JanitorOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.RemovedImages)
+	assert.Equal(t, uint64(100<<20), report.ReclaimedBytes)
+	assert.False(t, images.Pulled("docker.io/library/nginx:latest"))
+	assert.True(t, images.Pulled("docker.io/library/redis:latest"))
+	assert.Equal(t, []string{"docker.io/library/redis:latest"}, ns.localImages.list())
+
+	families, err := metrics.RegisterMetrics().Gather()
+	assert.NoError(t, err)
+	values := map[string]float64{}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			values[family.GetName()] = m.GetCounter().GetValue() + m.GetGauge().GetValue() +
+				float64(m.GetHistogram().GetSampleCount())
+		}
+	}
+
+	assert.Equal(t, 1.0, values["warm_metal_gc_removed_images_total"])
+	assert.Equal(t, float64(100<<20), values["warm_metal_gc_reclaimed_bytes_total"])
+	assert.Equal(t, 1.0, values["warm_metal_gc_duration_seconds"], "dry runs aren't recorded")
+	assert.Greater(t, values["warm_metal_gc_last_success_timestamp_seconds"], 0.0)
+}
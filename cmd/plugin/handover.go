@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// handoverPollPeriod is the period plugins check handover requests and locks at.
+const handoverPollPeriod = time.Second
+
+// handover passes a node from the running plugin to a new one started beside it, e.g. by a rolling upgrade of the
+// DaemonSet with maxSurge. The plugin serving the node holds an exclusive lock. A new plugin requests the lock,
+// then the running one finishes requests in progress, stops its background loops, and releases the lock without
+// exiting. The new plugin loads the volume state saved on disk, adopts mounts of the old one, and replaces the
+// socket, while kubelet retries requests failed in between. Plugins not supporting handover never hold the lock,
+// so the socket is taken over from them as before.
+type handover struct {
+	dir string
+	id  string
+
+	lock *os.File
+}
+
+func newHandover(dir string) *handover {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		klog.Fatalf("unable to create the directory for handover: %s", err)
+	}
+
+	id, err := os.Hostname()
+	if err != nil {
+		klog.Fatalf("unable to get the hostname: %s", err)
+	}
+
+	return &handover{dir: dir, id: fmt.Sprintf("%s-%d", id, os.Getpid())}
+}
+
+func (h *handover) requestFile() string {
+	return filepath.Join(h.dir, "request")
+}
+
+// acquire takes the node over from the running plugin if any, and waits until it is handed over or ctx expires.
+func (h *handover) acquire(ctx context.Context) error {
+	lock, err := os.OpenFile(filepath.Join(h.dir, "lock"), os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return err
+	}
+
+	requested := false
+	for {
+		err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			lock.Close()
+			return fmt.Errorf("unable to lock the node: %w", err)
+		}
+
+		if !requested {
+			klog.Infof("another plugin is serving the node, request it to hand over")
+			if err = os.WriteFile(h.requestFile(), []byte(h.id), 0o600); err != nil {
+				lock.Close()
+				return fmt.Errorf("unable to request handover: %w", err)
+			}
+
+			requested = true
+		}
+
+		select {
+		case <-ctx.Done():
+			lock.Close()
+			return fmt.Errorf("the node is not handed over: %w", ctx.Err())
+		case <-time.After(handoverPollPeriod):
+		}
+	}
+
+	if err = os.Remove(h.requestFile()); err != nil && !os.IsNotExist(err) {
+		klog.Errorf("unable to remove the handover request: %s", err)
+	}
+
+	if requested {
+		klog.Info("the node is handed over")
+	}
+
+	h.lock = lock
+	return nil
+}
+
+// requested returns a channel closed once another plugin requests the node.
+func (h *handover) requested(ctx context.Context) <-chan struct{} {
+	requested := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(handoverPollPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			requester, err := os.ReadFile(h.requestFile())
+			if err != nil {
+				if !os.IsNotExist(err) {
+					klog.Errorf("unable to read the handover request: %s", err)
+				}
+
+				continue
+			}
+
+			if string(requester) != h.id {
+				klog.Infof("plugin %s requests the node", requester)
+				close(requested)
+				return
+			}
+		}
+	}()
+
+	return requested
+}
+
+// release unlocks the node for the plugin requesting it.
+func (h *handover) release() {
+	if err := h.lock.Close(); err != nil {
+		klog.Errorf("unable to unlock the node: %s", err)
+	}
+}
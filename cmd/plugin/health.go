@@ -0,0 +1,203 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime/pprof"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/klog/v2"
+)
+
+// healthCheckTimeout bounds each check, so that probes fail instead of hanging if a dependency hangs.
+const healthCheckTimeout = 5 * time.Second
+
+type healthCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// healthChecker checks dependencies the node plugin can't mount volumes without, so that kubelet restarts node plugins
+// whose runtime connection silently died, instead of leaving them failing every mount.
+type healthChecker struct {
+	// liveness checks are served at /healthz and by the CSI call Probe
+	liveness []healthCheck
+	// readiness checks are served at /readyz in addition to liveness checks
+	readiness []healthCheck
+}
+
+func (h *healthChecker) addLiveness(name string, check func(ctx context.Context) error) {
+	h.liveness = append(h.liveness, healthCheck{name: name, check: check})
+}
+
+func (h *healthChecker) addReadiness(name string, check func(ctx context.Context) error) {
+	h.readiness = append(h.readiness, healthCheck{name: name, check: check})
+}
+
+// Check runs liveness checks, and returns their errors.
+func (h *healthChecker) Check(ctx context.Context) error {
+	var errs []error
+	for _, c := range h.liveness {
+		if err := runHealthCheck(ctx, c); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func runHealthCheck(ctx context.Context, c healthCheck) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	return c.check(ctx)
+}
+
+// Handlers returns handlers of /healthz and /readyz, which list results of checks and respond 503 if any fails.
+func (h *healthChecker) Handlers() map[string]http.Handler {
+	return map[string]http.Handler{
+		"/healthz": h.serve(h.liveness),
+		"/readyz":  h.serve(append(append([]healthCheck{}, h.liveness...), h.readiness...)),
+	}
+}
+
+func (h *healthChecker) serve(checks []healthCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var report strings.Builder
+		healthy := true
+		for _, c := range checks {
+			if err := runHealthCheck(r.Context(), c); err != nil {
+				healthy = false
+				klog.Warningf("health check %s failed: %s", c.name, err)
+				fmt.Fprintf(&report, "[-]%s failed: %s\n", c.name, err)
+				continue
+			}
+
+			fmt.Fprintf(&report, "[+]%s ok\n", c.name)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		fmt.Fprint(w, report.String())
+	}
+}
+
+// checkRuntimeSockets returns the check that one of the unix sockets of the runtime accepts connections. The image
+// service fails over to any of them.
+func checkRuntimeSockets(paths []string) func(ctx context.Context) error {
+	return func(context.Context) error {
+		var errs []error
+		for _, path := range paths {
+			err := probeRuntimeSocket(path)
+			if err == nil {
+				return nil
+			}
+
+			errs = append(errs, err)
+		}
+
+		return errors.Join(errs...)
+	}
+}
+
+// checkDataDir returns the check that files can be created in the directory, which fails if its filesystem turns
+// read-only or full.
+func checkDataDir(dir string) func(ctx context.Context) error {
+	return func(context.Context) error {
+		f, err := os.CreateTemp(dir, ".healthz-")
+		if err != nil {
+			return err
+		}
+
+		_, err = f.WriteString("ok")
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+
+		if removeErr := os.Remove(f.Name()); err == nil {
+			err = removeErr
+		}
+
+		return err
+	}
+}
+
+// checkImageStatus returns the check that the image service answers the status of the canary image. The image
+// doesn't have to be present, since images not found are not errors of ImageStatus.
+func checkImageStatus(imageSvc criapi.ImageServiceClient, image string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := imageSvc.ImageStatus(ctx, &criapi.ImageStatusRequest{Image: &criapi.ImageSpec{Image: image}})
+		return err
+	}
+}
+
+// checkServing returns the check that fails once the node is handed over to another plugin, i.e. ctx is done.
+func checkServing(ctx context.Context) func(context.Context) error {
+	return func(context.Context) error {
+		if ctx.Err() != nil {
+			return errors.New("the node is handed over")
+		}
+
+		return nil
+	}
+}
+
+// stuckOperationDefaultTimeout is the timeout of operations without deadlines in checks of stuck operations.
+const stuckOperationDefaultTimeout = 10 * time.Minute
+
+// checkStuckOperations returns the check that no publication, unpublication or pull has been running longer than
+// factor times its timeout, after which it should have failed unless its goroutine leaked or deadlocked. Stacks of
+// goroutines and the state of the node plugin are logged once the check starts failing, since they are what such
+// bugs are diagnosed from.
+func (n NodeServer) checkStuckOperations(factor float64) func(ctx context.Context) error {
+	var dumped atomic.Bool
+	return func(context.Context) error {
+		overdue := metrics.InFlightOperations.Overdue(factor, stuckOperationDefaultTimeout)
+		if len(overdue) == 0 {
+			dumped.Store(false)
+			return nil
+		}
+
+		if !dumped.Swap(true) {
+			go n.dumpStuckOperations(overdue, factor)
+		}
+
+		oldest := slices.MaxFunc(overdue, func(a, b metrics.OverdueOperation) int { return cmp.Compare(a.Age, b.Age) })
+		return fmt.Errorf("%d operations are stuck, the oldest %s has been running for %s with timeout %s",
+			len(overdue), oldest.Operation, oldest.Age.Round(time.Second), oldest.Timeout.Round(time.Second))
+	}
+}
+
+// dumpStuckOperations logs stacks of goroutines, then the state of the node plugin. Stacks go first since reading
+// the state may block on locks held by the stuck operations.
+func (n NodeServer) dumpStuckOperations(overdue []metrics.OverdueOperation, factor float64) {
+	for _, op := range overdue {
+		klog.Errorf("%s has been running for %s, longer than %g times its timeout %s", op.Operation,
+			op.Age.Round(time.Second), factor, op.Timeout.Round(time.Second))
+	}
+
+	var stacks strings.Builder
+	if err := pprof.Lookup("goroutine").WriteTo(&stacks, 2); err == nil {
+		klog.Errorf("stacks of goroutines:\n%s", stacks.String())
+	}
+
+	state, err := json.Marshal(n.Inspect(context.Background()))
+	if err != nil {
+		klog.Errorf("unable to dump the state of the node plugin: %s", err)
+		return
+	}
+
+	klog.Errorf("state of the node plugin: %s", state)
+}
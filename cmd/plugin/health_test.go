@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	fakeruntime "github.com/warm-metal/container-image-csi-driver/pkg/fake"
+	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHealthChecks(t *testing.T) {
+	dataDir := t.TempDir()
+	loops, stopLoops := context.WithCancel(context.Background())
+	defer stopLoops()
+
+	health := &healthChecker{}
+	health.addLiveness("data-dir", checkDataDir(dataDir))
+	health.addLiveness("image-status", checkImageStatus(fakeruntime.NewImageService(), "docker.io/library/redis:latest"))
+	health.addReadiness("serving", checkServing(loops))
+	ids := NewIdentityServer(currentBuildInfo())
+	ids.health = health
+
+	probe := func(path string) int {
+		w := httptest.NewRecorder()
+		health.Handlers()[path].ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+
+	ctx := context.Background()
+	_, err := ids.Probe(ctx, &csi.ProbeRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, probe("/healthz"))
+	assert.Equal(t, http.StatusOK, probe("/readyz"))
+
+	stopLoops()
+	assert.Equal(t, http.StatusOK, probe("/healthz"))
+	assert.Equal(t, http.StatusServiceUnavailable, probe("/readyz"))
+
+	assert.NoError(t, os.Remove(dataDir))
+	_, err = ids.Probe(ctx, &csi.ProbeRequest{})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Equal(t, http.StatusServiceUnavailable, probe("/healthz"))
+}
+
+func TestStuckOperations(t *testing.T) {
+	ns := newTestNodeServer(t, testNodeOptions{})
+	check := ns.checkStuckOperations(2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	done := metrics.InFlightOperations.Start(ctx, metrics.OperationPull)
+	assert.NoError(t, check(context.Background()))
+
+	time.Sleep(30 * time.Millisecond)
+	assert.ErrorContains(t, check(context.Background()), "1 operations are stuck, the oldest pull")
+
+	done()
+	assert.NoError(t, check(context.Background()))
+}
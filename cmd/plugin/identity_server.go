@@ -3,28 +3,40 @@ package main
 import (
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 type IdentityServer struct {
-	version string
+	info buildInfo
+	// the plugin is always ready if health is nil
+	health *healthChecker
 	csi.UnimplementedIdentityServer
 }
 
-func NewIdentityServer(version string) *IdentityServer {
+func NewIdentityServer(info buildInfo) *IdentityServer {
 	return &IdentityServer{
-		version: version,
+		info: info,
 	}
 }
 
 func (ids *IdentityServer) GetPluginInfo(_ context.Context, _ *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
 	return &csi.GetPluginInfoResponse{
 		Name:          driverName,
-		VendorVersion: ids.version,
+		VendorVersion: ids.info.version,
+		Manifest:      ids.info.manifest(),
 	}, nil
 }
 
-func (ids *IdentityServer) Probe(_ context.Context, _ *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+// Probe fails with FailedPrecondition if liveness checks fail, so that the livenessprobe sidecar restarts the plugin.
+func (ids *IdentityServer) Probe(ctx context.Context, _ *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	if ids.health != nil {
+		if err := ids.health.Check(ctx); err != nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "unhealthy: %s", err)
+		}
+	}
+
 	return &csi.ProbeResponse{
 		Ready: &wrapperspb.BoolValue{Value: true},
 	}, nil
@@ -40,6 +52,20 @@ func (ids *IdentityServer) GetPluginCapabilities(_ context.Context, _ *csi.GetPl
 					},
 				},
 			},
+			{
+				Type: &csi.PluginCapability_Service_{
+					Service: &csi.PluginCapability_Service{
+						Type: csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
+					},
+				},
+			},
+			{
+				Type: &csi.PluginCapability_VolumeExpansion_{
+					VolumeExpansion: &csi.PluginCapability_VolumeExpansion{
+						Type: csi.PluginCapability_VolumeExpansion_ONLINE,
+					},
+				},
+			},
 		},
 	}, nil
 }
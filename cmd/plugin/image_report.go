@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
+	"github.com/warm-metal/container-image-csi-driver/pkg/watcher"
+	"k8s.io/klog/v2"
+)
+
+// imageSet records images of volumes pulled on the node.
+type imageSet struct {
+	guard  sync.Mutex
+	images map[string]struct{}
+}
+
+func newImageSet() *imageSet {
+	return &imageSet{images: make(map[string]struct{})}
+}
+
+func (s *imageSet) add(image string) {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	s.images[image] = struct{}{}
+}
+
+func (s *imageSet) remove(image string) {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	delete(s.images, image)
+}
+
+func (s *imageSet) has(image string) bool {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	_, found := s.images[image]
+	return found
+}
+
+// list returns images in sorted order.
+func (s *imageSet) list() []string {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	images := make([]string, 0, len(s.images))
+	for image := range s.images {
+		images = append(images, image)
+	}
+
+	sort.Strings(images)
+	return images
+}
+
+// restoreLocalImages records images of volumes the mounter recovered after restarts, which were pulled by the driver
+// before it restarted, so that they are reclaimed and reported as well.
+func (n NodeServer) restoreLocalImages() {
+	inspector, ok := n.mounter.(backend.StateInspector)
+	if !ok {
+		return
+	}
+
+	for _, v := range inspector.InspectState().Volumes {
+		n.localImages.add(v.Image)
+		for _, image := range v.OverlayImages {
+			n.localImages.add(image)
+		}
+	}
+}
+
+// ReportImages reports images of volumes present on the node every period, so that the cache coordinator labels
+// the node by them. Images reported before the driver restarted are restored. Images removed from the node, e.g.
+// by the image GC of kubelet, are dropped from reports.
+func (n NodeServer) ReportImages(ctx context.Context, reporter *watcher.NodeImages, period time.Duration) {
+	node := n.driver.GetNodeID()
+	if restored, err := reporter.Get(ctx, node); err != nil {
+		klog.Warningf("unable to restore images reported by the node: %s", err)
+	} else {
+		for _, image := range restored {
+			n.localImages.add(image)
+		}
+	}
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	var last []string
+	reported := false
+	for {
+		var images []string
+		for _, image := range n.localImages.list() {
+			namedRef, err := reference.ParseNormalizedNamed(image)
+			if err != nil || !n.mounter.ImageExists(ctx, namedRef) {
+				n.localImages.remove(image)
+				continue
+			}
+
+			images = append(images, image)
+		}
+
+		if !reported || !slices.Equal(images, last) {
+			if err := reporter.Report(ctx, node, images); err != nil {
+				klog.Errorf("unable to report images of the node: %s", err)
+			} else {
+				klog.V(2).Infof("reported %d images of the node", len(images))
+				last, reported = images, true
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ObserveCachedImages exports the number of images pulled by the driver which are still on the node, whether volumes
+// use them, and their sizes if the mounter can measure them, every period. Sizes aren't exported if no image can be
+// measured, e.g. since the runtime doesn't support it. Images removed from the node are dropped.
+func (n NodeServer) ObserveCachedImages(ctx context.Context, period time.Duration) {
+	user, _ := n.mounter.(backend.ImageUser)
+	sizer, _ := n.mounter.(backend.ImageSizer)
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		var inUse, unused, measured, unmeasured int
+		var compressed, unpacked int64
+		for _, image := range n.localImages.list() {
+			namedRef, err := reference.ParseNormalizedNamed(image)
+			if err != nil || !n.mounter.ImageExists(ctx, namedRef) {
+				n.localImages.remove(image)
+				continue
+			}
+
+			if user == nil || user.ImageInUse(namedRef) {
+				inUse++
+			} else {
+				unused++
+			}
+
+			if sizer == nil {
+				continue
+			}
+
+			size, err := sizer.ImageSize(ctx, namedRef)
+			if err != nil {
+				klog.V(2).Infof("unable to measure image %q: %s", image, err)
+				unmeasured++
+				continue
+			}
+
+			measured++
+			compressed += size.Compressed
+			unpacked += size.Unpacked
+		}
+
+		metrics.ObserveCachedImages(inUse, unused)
+		if sizer != nil && (measured > 0 || unmeasured == 0) {
+			metrics.ObserveCachedImageBytes(compressed, unpacked)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/stretchr/testify/assert"
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+	fakeruntime "github.com/warm-metal/container-image-csi-driver/pkg/fake"
+	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+func TestObserveCachedImages(t *testing.T) {
+	images := fakeruntime.NewImageService()
+	mounter := fakeruntime.NewMounter(images)
+	ns := newTestNodeServer(t, testNodeOptions{images: images, mounter: mounter})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	for _, image := range []string{"docker.io/library/redis:latest", "docker.io/library/nginx:latest"} {
+		_, err := images.PullImage(ctx, &criapi.PullImageRequest{Image: &criapi.ImageSpec{Image: image}})
+		assert.NoError(t, err)
+		ns.localImages.add(image)
+	}
+	ns.localImages.add("docker.io/library/removed:latest")
+
+	namedRef, err := reference.ParseDockerRef("docker.io/library/redis:latest")
+	assert.NoError(t, err)
+	assert.NoError(t, mounter.Mount(ctx, "vol", "/target", namedRef, backend.MountOptions{}))
+
+	cancel()
+	ns.ObserveCachedImages(ctx, time.Minute)
+
+	families, err := metrics.RegisterMetrics().Gather()
+	assert.NoError(t, err)
+	values := map[string]float64{}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			if len(m.GetLabel()) == 1 {
+				values[family.GetName()+"/"+m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+			}
+		}
+	}
+
+	assert.Equal(t, 1.0, values["warm_metal_cached_images/in-use"])
+	assert.Equal(t, 1.0, values["warm_metal_cached_images/unused"])
+	assert.Equal(t, float64(200<<20), values["warm_metal_cached_image_bytes/compressed"])
+	assert.Equal(t, float64(400<<20), values["warm_metal_cached_image_bytes/unpacked"])
+	assert.False(t, ns.localImages.has("docker.io/library/removed:latest"))
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+const (
+	opPublish   = "publish"
+	opUnpublish = "unpublish"
+	opStage     = "stage"
+	opUnstage   = "unstage"
+)
+
+// inFlightOp is an operation on a volume target, which is recorded until it finishes.
+type inFlightOp struct {
+	Op       string `json:"op"`
+	VolumeId string `json:"volumeId"`
+	Target   string `json:"target"`
+}
+
+// inFlight guards operations on volume targets. Retries of an operation still in progress wait for it instead of
+// racing it, while other operations on the target are aborted. Operations are also recorded in dir if it is set, so
+// that operations interrupted by restarts of the driver are known when they are retried.
+type inFlight struct {
+	guard sync.Mutex
+	ops   map[string]*runningOp
+	dir   string
+}
+
+// runningOp is an operation in progress. done is closed once it finishes.
+type runningOp struct {
+	inFlightOp
+	done chan struct{}
+}
+
+func newInFlight(dir string) *inFlight {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			klog.Fatalf("unable to create the directory for in-flight operations: %s", err)
+		}
+	}
+
+	return &inFlight{ops: make(map[string]*runningOp), dir: dir}
+}
+
+func inFlightKey(volumeId, target string) string {
+	sum := sha256.Sum256([]byte(volumeId + "\x00" + target))
+	return hex.EncodeToString(sum[:])
+}
+
+// start records the operation on the volume target. If the same operation on the target is in progress, start
+// waits until it finishes or ctx is done. Aborted is returned if another operation on the target is in progress.
+// Otherwise, the operation interrupted by the last restart of the driver, if any, is returned.
+func (f *inFlight) start(ctx context.Context, op, volumeId, target string) (*inFlightOp, error) {
+	key := inFlightKey(volumeId, target)
+	f.guard.Lock()
+	defer f.guard.Unlock()
+	for {
+		running, found := f.ops[key]
+		if !found {
+			break
+		}
+
+		if running.Op != op {
+			return nil, status.Errorf(codes.Aborted, "%s of volume %q at %q is in progress", running.Op, volumeId,
+				target)
+		}
+
+		f.guard.Unlock()
+		select {
+		case <-running.done:
+		case <-ctx.Done():
+		}
+		f.guard.Lock()
+
+		if err := ctx.Err(); err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+	}
+
+	current := inFlightOp{Op: op, VolumeId: volumeId, Target: target}
+	f.ops[key] = &runningOp{inFlightOp: current, done: make(chan struct{})}
+	if f.dir == "" {
+		return nil, nil
+	}
+
+	var interrupted *inFlightOp
+	path := filepath.Join(f.dir, key)
+	if data, err := os.ReadFile(path); err == nil {
+		interrupted = &inFlightOp{}
+		if err = json.Unmarshal(data, interrupted); err != nil {
+			klog.Warningf("invalid record of in-flight operation %s: %s", path, err)
+			interrupted = &inFlightOp{Op: op, VolumeId: volumeId, Target: target}
+		}
+
+		klog.Infof("%s of volume %q at %q was interrupted by a restart", interrupted.Op, volumeId, target)
+	}
+
+	data, err := json.Marshal(current)
+	if err == nil {
+		err = os.WriteFile(path, data, 0o600)
+	}
+
+	if err != nil {
+		klog.Errorf("unable to record %s of volume %q at %q: %s", op, volumeId, target, err)
+	}
+
+	return interrupted, nil
+}
+
+// finish forgets the operation on the volume target.
+func (f *inFlight) finish(volumeId, target string) {
+	key := inFlightKey(volumeId, target)
+	f.guard.Lock()
+	defer f.guard.Unlock()
+	if running, found := f.ops[key]; found {
+		close(running.done)
+		delete(f.ops, key)
+	}
+
+	if f.dir == "" {
+		return
+	}
+
+	if err := os.Remove(filepath.Join(f.dir, key)); err != nil && !os.IsNotExist(err) {
+		klog.Errorf("unable to remove the record of in-flight operation on volume %q at %q: %s", volumeId, target, err)
+	}
+}
+
+// list returns operations in progress, sorted by their targets.
+func (f *inFlight) list() []inFlightOp {
+	f.guard.Lock()
+	defer f.guard.Unlock()
+	ops := make([]inFlightOp, 0, len(f.ops))
+	for _, op := range f.ops {
+		ops = append(ops, op.inFlightOp)
+	}
+
+	sort.Slice(ops, func(i, j int) bool { return ops[i].Target < ops[j].Target })
+	return ops
+}
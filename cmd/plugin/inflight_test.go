@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+	fakeruntime "github.com/warm-metal/container-image-csi-driver/pkg/fake"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestInFlight(t *testing.T) {
+	dir := t.TempDir()
+	f := newInFlight(dir)
+
+	interrupted, err := f.start(context.Background(), opPublish, "vol", "/target")
+	assert.NoError(t, err)
+	assert.Nil(t, interrupted)
+
+	_, err = f.start(context.Background(), opUnpublish, "vol", "/target")
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	// Retries wait for the operation in progress.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = f.start(ctx, opPublish, "vol", "/target")
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+
+	retried := make(chan error)
+	go func() {
+		_, err := f.start(context.Background(), opPublish, "vol", "/target")
+		retried <- err
+	}()
+	f.finish("vol", "/target")
+	assert.NoError(t, <-retried)
+
+	_, err = f.start(context.Background(), opPublish, "vol", "/another-target")
+	assert.NoError(t, err)
+	f.finish("vol", "/another-target")
+
+	// A new instance takes the recorded operation as interrupted by a restart.
+	restarted := newInFlight(dir)
+	interrupted, err = restarted.start(context.Background(), opPublish, "vol", "/target")
+	assert.NoError(t, err)
+	if assert.NotNil(t, interrupted) {
+		assert.Equal(t, opPublish, interrupted.Op)
+	}
+
+	restarted.finish("vol", "/target")
+	interrupted, err = restarted.start(context.Background(), opPublish, "vol", "/target")
+	assert.NoError(t, err)
+	assert.Nil(t, interrupted)
+}
+
+// TestInFlightRecords publishes volumes with operations recorded in the data directory, as the node plugin does.
+func TestInFlightRecords(t *testing.T) {
+	images := fakeruntime.NewImageService()
+	images.PullDelay = 500 * time.Millisecond
+	mounter := fakeruntime.NewMounter(images)
+	recordDir := filepath.Join(t.TempDir(), "inflight")
+	ns := newTestNodeServer(t, testNodeOptions{images: images, mounter: mounter})
+	ns.inFlight = newInFlight(recordDir)
+
+	target := filepath.Join(t.TempDir(), "target")
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:      "docker.io/library/redis:latest",
+		TargetPath:    target,
+		VolumeContext: map[string]string{"pod-name": "test-pod", "namespace": "test-namespace", "uid": "test-uid"},
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY},
+		},
+	}
+
+	records := func() []inFlightOp {
+		entries, err := os.ReadDir(recordDir)
+		require.NoError(t, err)
+		var ops []inFlightOp
+		for _, entry := range entries {
+			data, err := os.ReadFile(filepath.Join(recordDir, entry.Name()))
+			require.NoError(t, err)
+			var op inFlightOp
+			require.NoError(t, json.Unmarshal(data, &op))
+			ops = append(ops, op)
+		}
+
+		return ops
+	}
+
+	published := make(chan error, 1)
+	go func() {
+		_, err := ns.NodePublishVolume(context.Background(), req)
+		published <- err
+	}()
+
+	// The publication is recorded while the image is pulled. Retries wait for it meanwhile, and unpublications are
+	// aborted.
+	require.Eventually(t, func() bool { return len(records()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []inFlightOp{{Op: opPublish, VolumeId: req.VolumeId, Target: target}}, records())
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := ns.NodePublishVolume(ctx, req)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	_, err = ns.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+		VolumeId: req.VolumeId, TargetPath: target,
+	})
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	require.NoError(t, <-published)
+	assert.Empty(t, records())
+	assert.Equal(t, req.VolumeId, mounter.Mounts()[backend.MountTarget(target)])
+
+	// A record left by a restart during unpublication is removed once the volume is published again.
+	interruptedTarget := filepath.Join(t.TempDir(), "interrupted")
+	data, err := json.Marshal(inFlightOp{Op: opUnpublish, VolumeId: req.VolumeId, Target: interruptedTarget})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(recordDir, inFlightKey(req.VolumeId, interruptedTarget)), data, 0o600))
+
+	restarted := newTestNodeServer(t, testNodeOptions{images: images, mounter: mounter})
+	restarted.inFlight = newInFlight(recordDir)
+	req.TargetPath = interruptedTarget
+	_, err = restarted.NodePublishVolume(context.Background(), req)
+	require.NoError(t, err)
+	assert.Empty(t, records())
+	assert.Equal(t, req.VolumeId, mounter.Mounts()[backend.MountTarget(interruptedTarget)])
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"github.com/distribution/reference"
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+	"github.com/warm-metal/container-image-csi-driver/pkg/secret"
+	"k8s.io/klog/v2"
+)
+
+// inspectedVolume is a volume mounted by the driver, with digests of its image if the mounter can inspect it.
+type inspectedVolume struct {
+	backend.VolumeState
+	Digest         string `json:"digest,omitempty"`
+	ManifestDigest string `json:"manifestDigest,omitempty"`
+}
+
+// nodeState is the view of the node plugin on the node, which contains no credentials.
+type nodeState struct {
+	Node string `json:"node"`
+	// Volumes are volumes mounted since the driver started, and Snapshots the read-only snapshots they share.
+	Volumes   []inspectedVolume       `json:"volumes"`
+	Snapshots []backend.SnapshotState `json:"snapshots"`
+	// Operations are publications and unpublications in progress.
+	Operations []inFlightOp `json:"operations"`
+	// Images are images of volumes pulled by the driver since it started.
+	Images []string `json:"images"`
+	// Pulls are images being pulled in async mode.
+	AsyncPull bool     `json:"asyncPull"`
+	Pulls     []string `json:"pulls"`
+	// CredentialSources are where credentials of images come from, in priority order.
+	CredentialSources []string `json:"credentialSources"`
+}
+
+// Inspect returns the state of the node plugin, i.e. mounted volumes, digests and refcounts of their images, pulls
+// in progress, and sources of credentials, for troubleshooting.
+func (n NodeServer) Inspect(ctx context.Context) *nodeState {
+	state := &nodeState{
+		Node:       n.driver.GetNodeID(),
+		Volumes:    []inspectedVolume{},
+		Snapshots:  []backend.SnapshotState{},
+		Operations: n.inFlight.list(),
+		Images:     n.localImages.list(),
+		AsyncPull:  n.asyncImagePuller != nil,
+		Pulls:      []string{},
+	}
+
+	if state.AsyncPull {
+		state.Pulls = n.asyncImagePuller.Pulls()
+	}
+
+	if inspector, ok := n.mounter.(backend.StateInspector); ok {
+		mounter := inspector.InspectState()
+		state.Snapshots = mounter.Snapshots
+		metadata := make(map[string]*backend.ImageMetadata)
+		for _, v := range mounter.Volumes {
+			volume := inspectedVolume{VolumeState: v}
+			meta, found := metadata[v.Image]
+			if !found {
+				meta = n.inspectImage(ctx, v.Image)
+				metadata[v.Image] = meta
+			}
+
+			if meta != nil {
+				volume.Digest, volume.ManifestDigest = meta.Digest, meta.ManifestDigest
+			}
+
+			state.Volumes = append(state.Volumes, volume)
+		}
+	}
+
+	state.CredentialSources = []string{}
+	if lister, ok := n.secretStore.(secret.SourceLister); ok {
+		state.CredentialSources = lister.Sources()
+	}
+
+	if n.kubeClient != nil {
+		state.CredentialSources = append(state.CredentialSources,
+			"secrets referred by volume attributes", "imagePullSecrets of ServiceAccounts of pods of ephemeral volumes")
+	}
+
+	return state
+}
+
+// inspectImage returns metadata of the local image, or nil if it can't be inspected.
+func (n NodeServer) inspectImage(ctx context.Context, image string) *backend.ImageMetadata {
+	namedRef, err := reference.ParseDockerRef(image)
+	if err != nil {
+		return nil
+	}
+
+	meta, err := n.mounter.InspectImage(ctx, namedRef)
+	if err != nil {
+		klog.V(2).Infof("unable to inspect image %q: %s", image, err)
+		return nil
+	}
+
+	return meta
+}
+
+func (n NodeServer) serveInspect(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, n.Inspect(r.Context()))
+}
+
+// requestInspect asks the node plugin listening on the socket for its state, and prints it.
+func requestInspect(socket string) error {
+	state := &nodeState{}
+	if err := callAdmin(socket, http.MethodGet, "/inspect", state); err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(state)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/distribution/reference"
+	"github.com/stretchr/testify/assert"
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+	fakeruntime "github.com/warm-metal/container-image-csi-driver/pkg/fake"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+func TestInspect(t *testing.T) {
+	images := fakeruntime.NewImageService()
+	mounter := fakeruntime.NewMounter(images)
+	ns := newTestNodeServer(t, testNodeOptions{images: images, mounter: mounter})
+
+	ctx := context.Background()
+	image := "docker.io/library/redis:latest"
+	_, err := images.PullImage(ctx, &criapi.PullImageRequest{Image: &criapi.ImageSpec{Image: image}})
+	assert.NoError(t, err)
+	ns.localImages.add(image)
+
+	namedRef, err := reference.ParseDockerRef(image)
+	assert.NoError(t, err)
+	assert.NoError(t, mounter.Mount(ctx, "vol", "/target", namedRef, backend.MountOptions{}))
+
+	state := ns.Inspect(ctx)
+	assert.Equal(t, "fake-node", state.Node)
+	assert.Equal(t, []string{image}, state.Images)
+	assert.False(t, state.AsyncPull)
+	assert.Empty(t, state.Pulls)
+	if assert.Len(t, state.Volumes, 1) {
+		assert.Equal(t, "/target", state.Volumes[0].Target)
+		assert.Equal(t, image, state.Volumes[0].Image)
+		assert.NotEmpty(t, state.Volumes[0].Digest)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	flag "github.com/spf13/pflag"
+	"k8s.io/klog/v2"
+	"k8s.io/klog/v2/textlogger"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// setupLogging writes logs as JSON objects if format is json, so that values of contextual loggers, e.g. request IDs
+// and volume IDs, are fields which log pipelines can index. Verbosity is still set by -v. Credentials are masked in
+// all logs if scrub is set.
+func setupLogging(format string, scrub bool) error {
+	switch format {
+	case logFormatText:
+		if !scrub {
+			return nil
+		}
+	case logFormatJSON:
+	default:
+		return fmt.Errorf("unknown log format %q, must be %q or %q", format, logFormatText, logFormatJSON)
+	}
+
+	verbosity := 0
+	if v := flag.Lookup("v"); v != nil {
+		verbosity, _ = strconv.Atoi(v.Value.String())
+	}
+
+	var output io.Writer = os.Stderr
+	if scrub {
+		output = scrubbingWriter{output}
+	}
+
+	if format == logFormatText {
+		// klog passes lines it formats to the text logger as is, so that logs look the same as without it.
+		logger := textlogger.NewLogger(textlogger.NewConfig(textlogger.Output(output), textlogger.Verbosity(verbosity)))
+		klog.SetLoggerWithOptions(logger,
+			klog.WriteKlogBuffer(logger.GetSink().(textlogger.KlogBufferWriter).WriteKlogBuffer))
+		return nil
+	}
+
+	// logr passes V(n) as slog level -n.
+	handler := slog.NewJSONHandler(output, &slog.HandlerOptions{Level: slog.Level(-verbosity)})
+	klog.SetLogger(logr.FromSlogHandler(handler))
+	return nil
+}
+
+const redacted = "[REDACTED]"
+
+var (
+	// bearerTokens matches credentials of Authorization headers, which are checked by isHeaderCredential.
+	bearerTokens = regexp.MustCompile(`(?i)\b(bearer|basic)(\s+)([A-Za-z0-9._~+/-]{8,}=*)`)
+	// credentialFields matches values of password-like fields in any format, e.g. password=..., "password": "...", or
+	// escaped in JSON strings. Fields merely named like token or auth are left as is.
+	credentialFields = regexp.MustCompile(`(?i)\b((?:password|passwd|identitytoken|registrytoken|access_token|` +
+		`refresh_token|client_secret)\\?"?\s*[:=]\s*\\?"?)([^"\\\s,;{}\[\]&]+)`)
+	// authFields matches values of fields named auth, e.g. of docker configs, which are checked by isBasicCredential.
+	authFields = regexp.MustCompile(`(?i)\b(auth\\?"?\s*[:=]\s*\\?"?)([A-Za-z0-9+/]{4,}=*)`)
+	// encodedJSON matches JWTs and base64 encoded JSON objects, e.g. whole docker configs, which start with eyJ, i.e.
+	// {" encoded.
+	encodedJSON = regexp.MustCompile(`\beyJ[A-Za-z0-9+/_-]{8,}=*(?:\.[A-Za-z0-9_-]+){0,2}`)
+)
+
+// scrubbingWriter masks credentials in each write, i.e. each log line, before it reaches the underlying writer,
+// so that credentials never leak to logs even if a log line carries them by mistake.
+type scrubbingWriter struct {
+	io.Writer
+}
+
+func (w scrubbingWriter) Write(p []byte) (int, error) {
+	if _, err := w.Writer.Write([]byte(scrubCredentials(string(p)))); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// scrubCredentials masks credentials of Authorization headers, values of password-like fields, auth of docker
+// configs, JWTs, and base64 encoded JSON objects like whole docker configs in the line.
+func scrubCredentials(line string) string {
+	line = bearerTokens.ReplaceAllStringFunc(line, func(header string) string {
+		m := bearerTokens.FindStringSubmatch(header)
+		if !isHeaderCredential(m[1], m[3]) {
+			return header
+		}
+
+		return m[1] + m[2] + redacted
+	})
+	line = credentialFields.ReplaceAllString(line, "${1}"+redacted)
+	line = authFields.ReplaceAllStringFunc(line, func(field string) string {
+		m := authFields.FindStringSubmatch(field)
+		if !isBasicCredential(m[2]) {
+			return field
+		}
+
+		return m[1] + redacted
+	})
+	return encodedJSON.ReplaceAllString(line, redacted)
+}
+
+// isHeaderCredential returns whether the value following bearer or basic is a credential rather than a word, e.g.
+// "basic authentication". Bearer tokens contain digits or punctuation, and basic credentials are checked by
+// isBasicCredential.
+func isHeaderCredential(scheme, value string) bool {
+	if strings.EqualFold(scheme, "basic") {
+		return isBasicCredential(value)
+	}
+
+	return strings.IndexFunc(value, func(r rune) bool {
+		return (r < 'a' || r > 'z') && (r < 'A' || r > 'Z')
+	}) >= 0
+}
+
+// isBasicCredential returns whether the value is a base64 encoded user:password pair.
+func isBasicCredential(value string) bool {
+	decoded, err := base64.StdEncoding.WithPadding(base64.NoPadding).DecodeString(strings.TrimRight(value, "="))
+	if err != nil || !strings.Contains(string(decoded), ":") {
+		return false
+	}
+
+	for _, b := range decoded {
+		if b < 0x20 || b > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
@@ -4,7 +4,12 @@ import (
 	"context"
 	goflag "flag"
 	"fmt"
+	"net/http"
 	"net/url"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -12,23 +17,46 @@ import (
 	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
 	"github.com/warm-metal/container-image-csi-driver/pkg/backend/containerd"
 	"github.com/warm-metal/container-image-csi-driver/pkg/backend/crio"
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend/docker"
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend/plugin"
+	"github.com/warm-metal/container-image-csi-driver/pkg/config"
 	"github.com/warm-metal/container-image-csi-driver/pkg/cri"
 	csicommon "github.com/warm-metal/container-image-csi-driver/pkg/csi-common"
+	"github.com/warm-metal/container-image-csi-driver/pkg/errorlog"
+	"github.com/warm-metal/container-image-csi-driver/pkg/fake"
 	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
+	"github.com/warm-metal/container-image-csi-driver/pkg/remoteimage"
 	"github.com/warm-metal/container-image-csi-driver/pkg/secret"
+	"github.com/warm-metal/container-image-csi-driver/pkg/tracing"
 	"github.com/warm-metal/container-image-csi-driver/pkg/watcher"
+	"github.com/warm-metal/container-image-csi-driver/pkg/webhook"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
 )
 
 const (
-	driverName    = "container-image.csi.k8s.io"
-	driverVersion = "v1.0.0"
+	driverName = "container-image.csi.k8s.io"
 
 	containerdScheme = "containerd"
 	criOScheme       = "cri-o"
+	criDockerdScheme = "cri-dockerd"
+	podmanScheme     = "podman"
+	// fakeScheme runs the node plugin with in-memory images and mounts, e.g. for csi-sanity.
+	fakeScheme = "fake"
 
 	nodeMode       = "node"
 	controllerMode = "controller"
+	repairMode     = "repair"
+	webhookMode    = "webhook"
+	prefetchMode   = "prefetch"
+	gcMode         = "gc"
+	inspectMode    = "inspect"
+	// backendPluginMode serves MountBackend via the in-tree backend of the runtime for node plugins delegating mounts.
+	backendPluginMode = "backend-plugin"
 )
 
 var (
@@ -40,14 +68,46 @@ var (
 		"The unix socket of containerd. Deprecated. Use --runtime-addr instead.")
 	runtimeAddr = flag.String(
 		"runtime-addr", "",
-		fmt.Sprintf("The unix socket of the container runtime. Currently both containerd and cri-o are supported."+
-			"Users need to replace the leading %q with %q or %q to indicate the working runtime.",
-			"unix", containerdScheme, criOScheme),
+		fmt.Sprintf("The unix socket of the container runtime. Currently containerd, cri-o, cri-dockerd, and podman "+
+			"are supported. Users need to replace the leading %q with %q, %q, %q, or %q to indicate the working runtime.",
+			"unix", containerdScheme, criOScheme, criDockerdScheme, podmanScheme)+
+			" Well-known sockets of these runtimes are probed if empty. A comma-separated list of addresses selects "+
+			"the first one accepting connections, and its image service fails over to the others of the same runtime. "+
+			fmt.Sprintf("%q runs the node plugin without a runtime, which pulls and mounts nothing, for tests.",
+				fakeScheme+"://"),
 	)
+	backendPlugin = flag.String("backend-plugin-addr", "",
+		"The unix socket of an out-of-tree mount backend serving the MountBackend gRPC service, which mounts "+
+			"volumes instead of the backend of the container runtime. Images are still pulled via the runtime. "+
+			"The socket the driver listens on in backend-plugin mode.")
+	delegateMounts = flag.Bool("delegate-mounts", false,
+		"Delegate all mounts of the node plugin to the backend plugin at --backend-plugin-addr in node mode, which "+
+			"runs on the host with CAP_SYS_ADMIN, e.g. the driver in backend-plugin mode, so that the node plugin "+
+			"itself needs no privileges. --volume-size-estimate isn't supported, since /proc of the host is "+
+			"inaccessible.")
+	dockerAddr = flag.String("docker-addr", "/var/run/docker.sock",
+		"The unix socket of Docker Engine, whose images are mounted if the runtime is cri-dockerd.")
+	containerdSnapshotter = flag.String("containerd-snapshotter", "",
+		"The containerd snapshotter for image layers, e.g. devmapper. The containerd default, overlayfs, is used if empty.")
+	snapshotLabels = flag.StringToString("containerd-snapshot-labels", nil,
+		"Comma-separated labels in key=value set on all snapshots the driver creates in containerd, "+
+			"in addition to the GC root label.")
+	overlayOptions = flag.StringSlice("overlay-options", nil,
+		"Comma-separated overlay mount options applied to read-write volumes for performance. "+
+			"metacopy=on|off, xino=on|off|auto, and volatile are supported. Only valid for containerd with overlayfs.")
 	icpConf = flag.String("image-credential-provider-config", "",
 		"The path to the credential provider plugin config file.")
 	icpBin = flag.String("image-credential-provider-bin-dir", "",
 		"The path to the directory where credential provider plugin binaries are located.")
+	helperEnvAllowlist = flag.StringSlice("credential-helper-env-allowlist", secret.DefaultHelperEnvAllowlist,
+		"Environment variables of the driver passed to credential provider plugins and docker credential helpers "+
+			"besides those in their config. Names ending with * match prefixes.")
+	helperTimeout = flag.Duration("credential-helper-timeout", time.Minute,
+		"Kill credential helpers running longer, along with their children. 0 means no timeout.")
+	helperCPUTime = flag.Duration("credential-helper-cpu-time", 0,
+		"The limit of CPU time of each credential helper process, e.g. 10s. 0 means unlimited.")
+	helperMemory = flag.String("credential-helper-memory", "",
+		"The limit of the address space of each credential helper process, e.g. 512Mi. Unlimited if empty.")
 	nodePluginSA = flag.String("node-plugin-sa", "container-image-csi-driver",
 		"The name of the ServiceAccount for pulling image.")
 	enableCache = flag.Bool("enable-daemon-image-credential-cache", true,
@@ -55,11 +115,222 @@ var (
 	asyncImagePullTimeout = flag.Duration("async-pull-timeout", 10*time.Minute,
 		"Timeout for asynchronous image pulling. Only valid if --async-pull is enabled.")
 	mode = flag.String("mode", nodeMode,
-		fmt.Sprintf("Mode determines the role this instance plays. One of %q or %q.", nodeMode, controllerMode))
+		fmt.Sprintf("Mode determines the role this instance plays. One of %q or %q. "+
+			"%q sets GC labels on snapshots created by the driver, including older versions, then exits. "+
+			"%q serves admission webhooks of image volumes. "+
+			"%q pulls an image on the node as the node plugin does with the same flags, then exits. "+
+			"%q asks the node plugin with the same --data-dir to report garbage on the node, then exits. "+
+			"%q prints the state of the node plugin with the same --data-dir, i.e. volumes, images, pulls, and "+
+			"sources of credentials, then exits. "+
+			"%q serves the MountBackend gRPC service on --backend-plugin-addr via the backend of the runtime, "+
+			"which mounts volumes of node plugins delegating mounts on the host.",
+			nodeMode, controllerMode, repairMode, webhookMode, prefetchMode, gcMode, inspectMode, backendPluginMode))
+	gcConfirm = flag.Bool("confirm", false,
+		"Remove the garbage reported in gc mode, i.e. unused images pulled by the driver and stale resources "+
+			"the janitor removes.")
+	prefetchImage      = flag.String("prefetch-image", "", "The image pulled in prefetch mode.")
+	prefetchAttributes = flag.StringToString("prefetch-attributes", nil,
+		"Volume attributes of the image pulled in prefetch mode, e.g. secret, secretNamespace, or pullTimeout, "+
+			"which are resolved as those of volumes.")
 	watcherResyncPeriod = flag.Duration("watcher-resync-period", 10*time.Minute,
-		"Resync period for the PVC watcher. Only valid in controller mode.")
+		"Resync period for the PVC watcher in controller mode and the PV watcher in node mode.")
+	volumeSecretRefs = flag.Bool("enable-volume-secret-refs", false,
+		"Pull images with image pull secrets referred by the volume attributes secret and secretNamespace, and "+
+			"those of service accounts of pods using ephemeral volumes in node mode. The node plugin must be "+
+			"allowed to get secrets and service accounts.")
+	validateProvisionedImages = flag.Bool("validate-provisioned-images", true,
+		"Check that images of dynamically provisioned volumes exist in their registries in controller mode. "+
+			"Disable it if registries are not reachable from the controller.")
 	metricsPort = flag.Int("metrics-port", 8080,
 		"Port for serving Prometheus metrics.")
+	metricsCertDir = flag.String("metrics-cert-dir", "",
+		"Directory of the certificate tls.crt and the key tls.key to serve the metrics port over TLS with. It is "+
+			"served in plaintext if empty.")
+	metricsClientCA = flag.String("metrics-client-ca", "",
+		"CA bundle client certificates of scrapes of /metrics must be signed by. Requires --metrics-cert-dir.")
+	metricsBearerTokenFile = flag.String("metrics-bearer-token-file", "",
+		"File of the bearer token scrapes of /metrics must present. It is read on every scrape.")
+	detailedImageMetrics = flag.Bool("metrics-detailed-images", false,
+		"Label gauges of pulls with images in addition to registries. Each image pulled adds series until they "+
+			"expire, so only enable it on nodes pulling a bounded set of images.")
+	logFormat = flag.String("log-format", logFormatText,
+		fmt.Sprintf("The format of logs, %q or %q. JSON logs carry the request ID, volume ID, image, and pod UID of "+
+			"requests as fields.", logFormatText, logFormatJSON))
+	scrubLogs = flag.Bool("scrub-logs", true,
+		"Mask credentials in logs, i.e. bearer tokens, values of password-like fields, base64 encoded "+
+			"credentials like auth of docker configs, and JWTs, as a defense in depth against credentials leaking "+
+			"to logs.")
+	metricsSeriesTTL = flag.Duration("metrics-series-ttl", metrics.DefaultSeriesTTL,
+		"Period to export series of gauges of pulls after the last pull updating them.")
+	otlpEndpoint = flag.String("otlp-endpoint", "",
+		"host:port of the OTLP receiver spans are exported to, e.g. otel-collector:4317. Spans aren't exported if "+
+			"empty. OTEL_EXPORTER_OTLP_* environment variables, e.g. headers, are respected as well.")
+	otlpProtocol = flag.String("otlp-protocol", tracing.ProtocolGRPC,
+		fmt.Sprintf("The protocol of the OTLP exporter, %q or %q.", tracing.ProtocolGRPC, tracing.ProtocolHTTP))
+	otlpInsecure = flag.Bool("otlp-insecure", false,
+		"Export spans to the OTLP receiver without TLS.")
+	traceSamplingRatio = flag.Float64("trace-sampling-ratio", 1,
+		"The ratio of traces sampled, from 0 to 1. Spans whose parents are sampled are always sampled.")
+	debugPort = flag.Int("debug-port", 0,
+		"Port on 127.0.0.1 for serving pprof profiles at /debug/pprof/, goroutine dumps at /debug/goroutines, and "+
+			"in node mode the state of the node plugin and its mounts at /debug/state. Disabled if 0.")
+	configFile = flag.String("config-file", "",
+		"A versioned config file of tunables of the node plugin, which override their flags. It is reloaded on "+
+			"SIGHUP or once changed.")
+	configReloadPeriod = flag.Duration("config-reload-period", 30*time.Second,
+		"Period to check the config file for changes.")
+	healthCanaryImage = flag.String("health-canary-image", "",
+		"Image whose status is queried from the runtime by health checks of the node plugin, so that the node plugin "+
+			"is restarted if its image service stops answering. The image doesn't have to exist on nodes.")
+	stuckOperationFactor = flag.Float64("stuck-operation-factor", 3,
+		"Fail health checks and log stacks of goroutines and the state of the node plugin once a publication, "+
+			"unpublication or pull runs longer than this multiple of its timeout, which is its deadline or 10m if "+
+			"it has none. Disabled if 0.")
+	runtimeProbePeriod = flag.Duration("runtime-probe-period", 10*time.Second,
+		"Period to probe image services of runtime addresses given in --runtime-addr, and to fail back to the "+
+			"first healthy one.")
+	mountHealthCheckPeriod = flag.Duration("mount-health-check-period", 5*time.Minute,
+		"Period to check mounts of volumes and mount broken read-only volumes again. 0 disables the check.")
+	janitorPeriod = flag.Duration("janitor-period", 0,
+		"Period to remove stale snapshots, runtime resources, and staging directories created by the driver. "+
+			"0 disables the janitor.")
+	snapshotRetention = flag.Duration("snapshot-retention", 0,
+		"Keep read-only snapshots of images for the duration after their last volumes are unmounted, so that pods "+
+			"restarting in the meantime, e.g. in CrashLoopBackOff or rolling restarts, reuse them and layers of "+
+			"their images stay rooted. 0 deletes snapshots once they are unused.")
+	janitorMaxRemovals = flag.Int("janitor-max-removals", 10,
+		"Maximum number of stale resources the janitor removes in a single run. 0 means unlimited.")
+	janitorDryRun = flag.Bool("janitor-dry-run", false,
+		"Only log stale resources found by the janitor instead of removing them.")
+	kubeletRoot = flag.String("kubelet-root", "/var/lib/kubelet",
+		"The root directory of kubelet.")
+	dataDir = flag.String("data-dir", "/var/lib/container-image-csi-driver",
+		"The directory on the host to keep data of the driver, e.g. images of block volumes. "+
+			"It must be mounted to the same path in the driver container.")
+	blockCacheSize = flag.String("block-cache-size", "10Gi",
+		"Maximum size of images of block volumes cached on the node. Images in use are never evicted. "+
+			"0 means unlimited.")
+	pullRuntimeHandler = flag.String("pull-runtime-handler", "",
+		"The runtime handler passed to the container runtime when pulling images. containerd unpacks images "+
+			"with the snapshotter of the handler, so that they aren't unpacked again by the driver.")
+	decryptionKeysDir = flag.String("decryption-keys-dir", "",
+		"The directory on the host the container runtime decrypts images with keys in, e.g. "+
+			"/etc/containerd/ocicrypt/keys. It must be mounted to the same path in the driver container. "+
+			"Image decryption is disabled if empty.")
+	notationTrustPolicy = flag.String("notation-trust-policy", "",
+		"The notation trust policy document images must be verified by in node mode, which refuses to mount images "+
+			"without Notary Project signatures trusted by the policy of their repositories. Verification is "+
+			"disabled if empty.")
+	notationTrustStoreDir = flag.String("notation-trust-store-dir", "",
+		"The directory of notation trust stores, laid out as x509/<type>/<name>/ holding certificates, which "+
+			"trust policies of --notation-trust-policy refer to.")
+	allowedRegistries = flag.StringSlice("allowed-registries", nil,
+		"Glob patterns of registry hosts, e.g. ghcr.io,*.acme.io,registry.acme.io:5000, the only registries images "+
+			"can be pulled from and mounted in node and prefetch modes. All registries are allowed if empty.")
+	deniedRegistries = flag.StringSlice("denied-registries", nil,
+		"Glob patterns of registry hosts images are never pulled from nor mounted in node and prefetch modes, "+
+			"which take precedence over --allowed-registries.")
+	imageVolumePolicies = flag.Bool("image-volume-policies", false,
+		"Refuse to pull images of volumes violating ClusterImageVolumePolicies in node mode, which restrict "+
+			"registries of images, require digests or signatures of images for namespaces they select. The CRD "+
+			"must be installed.")
+	persistentScratchCleanup = flag.Bool("persistent-scratch-cleanup", false,
+		"Watch PVs and remove persistent scratch layers of deleted PVs from the node. Only valid in node mode.")
+	reclaimImages = flag.Bool("reclaim-images", false,
+		"Watch PVs and remove images of deleted PVs provisioned by the driver with reclaimPolicy Delete from the "+
+			"node, unless other volumes or PVs use them. Only valid in node mode.")
+	volumeSnapshots = flag.Bool("enable-volume-snapshots", false,
+		"Support snapshots of persistent writable layers of volumes. Nodes watch VolumeSnapshotContents to save "+
+			"snapshots under --data-dir. The snapshot CRDs must be installed.")
+	maxVolumes = flag.Int64("max-volumes-per-node", 0,
+		"Maximum number of volumes of the driver on the node, which is reported to kubelet so that the scheduler "+
+			"doesn't place more. 0 means unlimited, unless --volume-size-estimate is set.")
+	volumeSizeEstimate = flag.String("volume-size-estimate", "",
+		"Estimated size of images of volumes, e.g. 2Gi. If set and --max-volumes-per-node is 0, the volume limit is "+
+			"derived by dividing the size of the image filesystem by it.")
+	prePullOnAttach = flag.Bool("pre-pull-on-attach", false,
+		"Attach PVs to nodes via ControllerPublishVolume, and pull images of PVs once they are attached in node mode. "+
+			"The CSIDriver must require attachment.")
+	shutdownGracePeriod = flag.Duration("shutdown-grace-period", 30*time.Second,
+		"Time given to requests and background pulls or snapshots in progress to finish after SIGTERM is received. "+
+			"It should be less than terminationGracePeriodSeconds of the pod.")
+	imageReportPeriod = flag.Duration("image-report-period", 0,
+		"Period to report images of volumes present on the node via an annotation of the Node, which the cache "+
+			"coordinator labels the Node by. 0 disables reporting.")
+	cachedImagesPeriod = flag.Duration("cached-images-period", time.Minute,
+		"Period to export the number and sizes of images pulled by the driver present on the node in node mode. "+
+			"Disabled if 0.")
+	volumeStatusPeriod = flag.Duration("volume-status-period", 0,
+		"Period to report mounted images, their digests and numbers of volumes, and usage of the image cache of the "+
+			"node via the NodeImageVolumeStatus named after the node in node mode. The CRD must be installed. 0 "+
+			"disables reporting.")
+	cacheCoordinator = flag.Bool("cache-coordinator", false,
+		"Label Nodes by images reported by node plugins in controller mode, so that workloads can prefer nodes "+
+			"having their images. Controllers elect a leader to do so.")
+	maxConcurrentRequests = flag.StringToInt("max-concurrent-requests", nil,
+		"Maximum number of concurrent requests of CSI methods, e.g. NodePublishVolume=20,NodeUnpublishVolume=50. "+
+			"Methods not listed are unlimited.")
+	errorLogInterval = flag.Duration("error-log-interval", time.Minute,
+		"Log identical errors, e.g. of the same CSI method and volume, at most once per interval, along with the "+
+			"number suppressed in between, so that retries of stuck volumes don't flood logs. 0 logs all errors.")
+	requestQueueLength = flag.Int("request-queue-length", 100,
+		"Maximum number of requests of each method in --max-concurrent-requests waiting for others to finish. "+
+			"Requests beyond it are rejected with ResourceExhausted and retried by kubelet later.")
+	socketHandover = flag.Bool("socket-handover", false,
+		"Hand the node over between node plugins running side by side during rolling upgrades. A new plugin "+
+			"waits for the running one to finish requests in progress before serving the socket.")
+	handoverTimeout = flag.Duration("handover-timeout", 2*time.Minute,
+		"Maximum time a new node plugin waits for the running one to hand the node over.")
+	annotateDigests = flag.Bool("annotate-digests", false,
+		"Annotate pods with digests of images their volumes are mounted from, as "+
+			"digest.container-image.csi.k8s.io/<volume>: <digest>, in node mode.")
+	annotateScratchNodes = flag.Bool("annotate-scratch-nodes", false,
+		"Annotate PVs with the node which has their persistent scratch layers, as "+
+			"container-image.csi.k8s.io/scratch-node: <node>, in node mode. Clones of the volumes are provisioned on the node.")
+	mountFailureEvents = flag.Bool("mount-failure-events", true,
+		fmt.Sprintf("Emit events to pods whose volumes fail to mount with reasons %s, %s, %s, or %s, in node mode.",
+			ReasonErrImagePull, ReasonErrAuth, ReasonErrSnapshotterMissing, ReasonErrDiskPressure))
+	auditLog = flag.String("audit-log", "",
+		"Write an audit record of each pull as a JSON line to the file, or stdout if it is -, in node mode. Records "+
+			"contain the image, its digest and registry, the source of the credential it is pulled with, and the "+
+			"requesting volume and pod, but never credentials.")
+	slowPullThreshold = flag.Duration("slow-pull-threshold", 0,
+		fmt.Sprintf("Warn about pulls taking longer via logs and %s events to pods, in node mode. 0 disables it.",
+			ReasonSlowPull))
+	slowPullMinThroughput = flag.String("slow-pull-min-throughput", "",
+		fmt.Sprintf("Warn about pulls longer than %s fetching fewer bytes per second, e.g. 1Mi, via logs and %s "+
+			"events to pods, in node mode.", slowPullMinDuration, ReasonSlowPull))
+	volumeAttributesClasses = flag.Bool("enable-volume-attributes-classes", false,
+		"Accept VolumeAttributesClasses changing pullPolicy and pullTimeout of PVs in controller mode, and apply "+
+			"them when PVs are published in node mode.")
+	manageCSIDriver = flag.Bool("manage-csidriver", false,
+		"Create the CSIDriver object of the driver and keep it in line with capabilities of the binary and flags "+
+			"in controller mode, instead of deploying it separately.")
+	fsGroupPolicy = flag.String("fs-group-policy", string(storagev1.NoneFSGroupPolicy),
+		"fsGroupPolicy of the CSIDriver object managed by --manage-csidriver.")
+	seLinuxMount = flag.Bool("selinux-mount", true,
+		"Set seLinuxMount of the CSIDriver object managed by --manage-csidriver, which lets kubelet mount volumes "+
+			"with the SELinux context of pods.")
+	storageCapacity = flag.Bool("storage-capacity", false,
+		"Set storageCapacity of the CSIDriver object managed by --manage-csidriver, which must be set if "+
+			"capacity is tracked by the external provisioner.")
+	tokenAudiences = flag.StringSlice("token-audiences", nil,
+		"Audiences of service account tokens kubelet passes to volumes, set as tokenRequests of the CSIDriver "+
+			"object managed by --manage-csidriver, for credential provider plugins with tokenAttributes.")
+	webhookAddr = flag.String("webhook-addr", ":9443",
+		"Address admission webhooks are served at in webhook mode.")
+	webhookCertDir = flag.String("webhook-cert-dir", "/etc/webhook/certs",
+		"Directory of the certificate tls.crt and the key tls.key of admission webhooks.")
+	allowedImages = flag.StringSlice("allowed-images", nil,
+		"Prefixes of repositories images of volumes must be in, e.g. ghcr.io/org,docker.io/library, which the "+
+			"validating webhook enforces. All repositories are allowed if empty.")
+	requireDigests = flag.Bool("require-digests", false,
+		"Reject volumes of images referred by tags instead of digests by the validating webhook in webhook mode, "+
+			"and refuse to pull or mount them in node and prefetch modes, so that mounts are reproducible.")
+	translateImageVolumes = flag.String("translate-image-volumes", "",
+		fmt.Sprintf("Translate native image volumes of pods into CSI ephemeral volumes of the driver with %q, or the "+
+			"reverse with %q, in webhook mode. Pods are left as is if empty.",
+			webhook.TranslateToCSI, webhook.TranslateToNative))
 )
 
 func main() {
@@ -82,10 +353,55 @@ func main() {
 
 	flag.Parse()
 	defer klog.Flush()
+	if err := setupLogging(*logFormat, *scrubLogs); err != nil {
+		klog.Fatal(err)
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Endpoint:       *otlpEndpoint,
+		Protocol:       *otlpProtocol,
+		Insecure:       *otlpInsecure,
+		SamplingRatio:  *traceSamplingRatio,
+		ServiceName:    driverName,
+		ServiceVersion: driverVersion,
+		NodeName:       *nodeID,
+	})
+	if err != nil {
+		klog.Fatalf("unable to set up tracing: %s", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			klog.Errorf("unable to flush spans: %s", err)
+		}
+	}()
+
+	metrics.ConfigureImageMetrics(*detailedImageMetrics, *metricsSeriesTTL)
+	helperSandbox := secret.HelperSandbox{
+		EnvAllowlist: *helperEnvAllowlist,
+		Timeout:      *helperTimeout,
+		CPUTime:      *helperCPUTime,
+	}
+	if *helperMemory != "" {
+		memory, err := resource.ParseQuantity(*helperMemory)
+		if err != nil || memory.Sign() < 0 {
+			klog.Fatalf("invalid memory limit of credential helpers %q", *helperMemory)
+		}
+
+		helperSandbox.MemoryBytes = uint64(memory.Value())
+	}
+	secret.SetHelperSandbox(helperSandbox)
+
+	info := currentBuildInfo()
+	info.export()
 
 	driver := csicommon.NewCSIDriver(driverName, driverVersion, *nodeID)
 	driver.AddVolumeCapabilityAccessModes([]csi.VolumeCapability_AccessMode_Mode{
 		csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
 	})
 	driver.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{
 		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
@@ -95,15 +411,25 @@ func main() {
 		klog.Fatalf("The mode of the driver is required.")
 	}
 
+	errorlog.SetInterval(*errorLogInterval)
 	server := csicommon.NewNonBlockingGRPCServer()
+	if len(*maxConcurrentRequests) > 0 {
+		server.SetRequestLimits(*maxConcurrentRequests, *requestQueueLength)
+	}
+	background := &backgroundTasks{}
+	// Background loops of the node plugin, which are stopped once the node is handed over.
+	loops, stopLoops := context.WithCancel(context.Background())
+	defer stopLoops()
+	var takeover *handover
+	// debugState dumps the state of the node plugin on the debug port, which is only served in node mode.
+	var debugState http.HandlerFunc
+	// health checks the node plugin, whose probes are only served in node mode.
+	var health *healthChecker
 
 	switch *mode {
 	case nodeMode:
-		if len(*runtimeAddr) == 0 {
-			if len(*containerdSock) == 0 {
-				klog.Fatalf("The unit socket of container runtime is required.")
-			}
-
+		var fallbackRuntimeAddrs []string
+		if len(*runtimeAddr) == 0 && len(*containerdSock) > 0 {
 			klog.Warning("--containerd-addr is deprecated. Use --runtime-addr instead.")
 			addr, err := url.Parse(*containerdSock)
 			if err != nil {
@@ -111,55 +437,575 @@ func main() {
 			}
 			addr.Scheme = containerdScheme
 			*runtimeAddr = addr.String()
+			metrics.RuntimeInfo.WithLabelValues(addr.Scheme, addr.Path, "false").Set(1)
+		} else if len(*runtimeAddr) == 0 {
+			detected, err := detectRuntimeAddr()
+			if err != nil {
+				klog.Fatalf("The unit socket of container runtime is required: %s", err)
+			}
+			*runtimeAddr = detected
+		} else {
+			selected, fallbacks, err := selectRuntimeAddr(*runtimeAddr)
+			if err != nil {
+				klog.Fatalf("invalid runtime address: %s", err)
+			}
+
+			*runtimeAddr = selected
+			fallbackRuntimeAddrs = fallbacks
+			if addr, err := url.Parse(*runtimeAddr); err == nil {
+				metrics.RuntimeInfo.WithLabelValues(addr.Scheme, addr.Path, "false").Set(1)
+			}
 		}
 
-		var mounter backend.Mounter
-		if len(*runtimeAddr) > 0 {
+		var mounter *backend.SnapshotMounter
+		var criClient criapi.ImageServiceClient
+		var backendName string
+		var fakeMounter *fake.Mounter
+		if strings.HasPrefix(*runtimeAddr, fakeScheme+"://") {
+			klog.Warning("fake runtime in use, images are neither pulled nor mounted")
+			fakeImages := fake.NewImageService()
+			criClient = fakeImages
+			fakeMounter = fake.NewMounter(fakeImages)
+			backendName = fakeScheme
+		} else if len(*runtimeAddr) > 0 && len(*backendPlugin) > 0 {
 			addr, err := url.Parse(*runtimeAddr)
 			if err != nil {
 				klog.Fatalf("invalid runtime address: %s", err)
 			}
 
-			klog.Infof("runtime %s at %q", addr.Scheme, addr.Path)
-			switch addr.Scheme {
-			case containerdScheme:
-				mounter = containerd.NewMounter(addr.Path)
-			case criOScheme:
-				mounter = crio.NewMounter(addr.Path)
-			default:
-				klog.Fatalf("unknown container runtime %q", addr.Scheme)
+			if addr.Scheme == podmanScheme {
+				criClient = cri.NewPodmanImageService(addr.Path)
+			}
+
+			backendName = backendPluginName
+			addr.Scheme = "unix"
+			*runtimeAddr = addr.String()
+		} else if len(*runtimeAddr) > 0 {
+			addr, err := url.Parse(*runtimeAddr)
+			if err != nil {
+				klog.Fatalf("invalid runtime address: %s", err)
 			}
 
+			mounter, criClient = newRuntimeMounter(addr)
+			backendName = addr.Scheme
 			addr.Scheme = "unix"
 			*runtimeAddr = addr.String()
 		}
 
-		criClient, err := cri.NewRemoteImageService(*runtimeAddr, time.Second)
+		if criClient == nil && len(fallbackRuntimeAddrs) > 0 {
+			failover, err := cri.NewFailoverImageService(append([]string{*runtimeAddr}, fallbackRuntimeAddrs...),
+				time.Second)
+			if err != nil {
+				klog.Fatalf("unable to connect to cri daemons: %s", err)
+			}
+
+			go failover.Probe(loops, *runtimeProbePeriod)
+			criClient = failover
+		} else if criClient == nil {
+			var err error
+			if criClient, err = cri.NewRemoteImageService(*runtimeAddr, time.Second); err != nil {
+				klog.Fatalf(`unable to connect to cri daemon "%s": %s`, *runtimeAddr, err)
+			}
+		}
+
+		cacheSize, err := resource.ParseQuantity(*blockCacheSize)
 		if err != nil {
-			klog.Fatalf(`unable to connect to cri daemon "%s": %s`, *endpoint, err)
+			klog.Fatalf("invalid block cache size %q: %s", *blockCacheSize, err)
+		}
+
+		if *socketHandover {
+			// Volume state must be loaded after the running plugin saves its state and hands the node over.
+			takeover = newHandover(filepath.Join(*dataDir, "handover"))
+			ctx, cancel := context.WithTimeout(context.Background(), *handoverTimeout)
+			if err := takeover.acquire(ctx); err != nil {
+				klog.Fatalf("unable to take the node over: %s", err)
+			}
+
+			cancel()
+		}
+
+		if *delegateMounts && fakeMounter == nil && len(*backendPlugin) == 0 {
+			klog.Fatal("--delegate-mounts requires --backend-plugin-addr, which mounts volumes instead")
+		}
+
+		var volumeMounter backend.Mounter
+		if fakeMounter != nil {
+			volumeMounter = fakeMounter
+		} else if len(*backendPlugin) > 0 {
+			// Backend plugins manage state, health, and stale resources of their volumes themselves.
+			volumeMounter = plugin.NewMounter(*backendPlugin)
+		} else {
+			enableMounterFeatures(loops, mounter, cacheSize.Value())
+			volumeMounter = mounter
 		}
 
 		secretStore := secret.CreateStoreOrDie(*icpConf, *icpBin, *nodePluginSA, *enableCache)
+		nodeServer := NewNodeServer(driver, volumeMounter, criClient, secretStore, *asyncImagePullTimeout)
+		nodeServer.referrersDir = filepath.Join(*dataDir, "referrers")
+		nodeServer.pullRuntimeHandler = *pullRuntimeHandler
+		nodeServer.backend = backendName
+		nodeServer.snapshotsDir = filepath.Join(*dataDir, "snapshots")
+		nodeServer.inFlight = newInFlight(filepath.Join(*dataDir, "inflight"))
+		nodeServer.background = background
+		if nodeServer.registries, err = newRegistryRules(*allowedRegistries, *deniedRegistries); err != nil {
+			klog.Fatalf("invalid registry rules: %s", err)
+		}
+		nodeServer.requireDigests = *requireDigests
+		var bytesPerVolume int64
+		if *volumeSizeEstimate != "" {
+			if *delegateMounts {
+				klog.Fatal("--volume-size-estimate isn't supported with --delegate-mounts")
+			}
+
+			estimate, err := resource.ParseQuantity(*volumeSizeEstimate)
+			if err != nil {
+				klog.Fatalf("invalid volume size estimate %q: %s", *volumeSizeEstimate, err)
+			}
+
+			bytesPerVolume = estimate.Value()
+		}
+		nodeServer.maxVolumes = maxVolumesPerNode(context.Background(), criClient, *maxVolumes, bytesPerVolume)
+		if *volumeSecretRefs {
+			if nodeServer.kubeClient, err = secret.NewClient(); err != nil {
+				klog.Fatalf("unable to create Kubernetes client: %s", err)
+			}
+		}
+		if *volumeAttributesClasses {
+			client, err := secret.NewClient()
+			if err != nil {
+				klog.Fatalf("unable to create VolumeAttributesClass client: %s", err)
+			}
+
+			nodeServer.volumeAttributesClasses = watcher.NewVolumeAttributesClasses(client, driverName)
+		}
+
+		if *annotateDigests {
+			if nodeServer.podAnnotations, err = secret.NewClient(); err != nil {
+				klog.Fatalf("unable to create Kubernetes client: %s", err)
+			}
+		}
+		if *annotateScratchNodes {
+			if nodeServer.scratchNodes, err = secret.NewClient(); err != nil {
+				klog.Fatalf("unable to create Kubernetes client: %s", err)
+			}
+		}
+		if *mountFailureEvents {
+			if client, err := secret.NewClient(); err != nil {
+				klog.Warningf("unable to create Kubernetes client, events of mount failures won't be emitted: %s", err)
+			} else {
+				nodeServer.events = newEventRecorder(client, *nodeID)
+			}
+		}
+		if *auditLog != "" {
+			if nodeServer.auditLog, err = remoteimage.OpenAuditLog(*auditLog); err != nil {
+				klog.Fatalf("unable to audit pulls: %s", err)
+			}
+			defer nodeServer.auditLog.Close()
+		}
+		if *slowPullThreshold > 0 || *slowPullMinThroughput != "" {
+			nodeServer.slowPull = &slowPullThresholds{duration: *slowPullThreshold}
+			if *slowPullMinThroughput != "" {
+				throughput, err := resource.ParseQuantity(*slowPullMinThroughput)
+				if err != nil {
+					klog.Fatalf("invalid minimum throughput of pulls %q: %s", *slowPullMinThroughput, err)
+				}
+
+				nodeServer.slowPull.bytesPerSecond = throughput.Value()
+			}
+		}
+		if *decryptionKeysDir != "" {
+			nodeServer.decryptionKeys = secret.NewDecryptionKeyStoreOrDie(*decryptionKeysDir)
+		}
+
+		if *notationTrustPolicy != "" {
+			if nodeServer.notation, err = remoteimage.NewNotationVerifier(*notationTrustPolicy,
+				*notationTrustStoreDir); err != nil {
+				klog.Fatalf("unable to load notation trust policies: %s", err)
+			}
+		}
+
+		if *imageVolumePolicies {
+			if nodeServer.imagePolicies, err = watcher.NewImageVolumePolicies(loops,
+				*watcherResyncPeriod); err != nil {
+				klog.Fatalf("unable to watch %ss: %s", watcher.ClusterImageVolumePolicyKind, err)
+			}
+		}
+
+		if *persistentScratchCleanup || *reclaimImages {
+			pvWatcher, err := watcher.WatchPVDeletion(loops, *watcherResyncPeriod, driverName,
+				func(pv *corev1.PersistentVolume, remaining []*corev1.CSIPersistentVolumeSource) {
+					if *persistentScratchCleanup {
+						nodeServer.RemoveScratchOfPV(pv.Spec.CSI, remaining)
+					}
+
+					if *reclaimImages {
+						nodeServer.RemoveImagesOfPV(pv, remaining)
+					}
+				})
+			if err != nil {
+				klog.Fatalf("unable to create PV watcher: %s", err)
+			}
+
+			context.AfterFunc(loops, pvWatcher.Stop)
+		}
+
+		if *volumeSnapshots {
+			if nodeServer.snapshotContents, err = watcher.NewSnapshotContents(); err != nil {
+				klog.Fatalf("unable to create VolumeSnapshotContent client: %s", err)
+			}
+
+			snapshotWatcher, err := nodeServer.snapshotContents.Watch(loops, *watcherResyncPeriod,
+				driverName, nodeServer.SaveSnapshot, nodeServer.RemoveSnapshot)
+			if err != nil {
+				klog.Fatalf("unable to create VolumeSnapshotContent watcher: %s", err)
+			}
+
+			context.AfterFunc(loops, snapshotWatcher.Stop)
+		}
+
+		if *cachedImagesPeriod > 0 {
+			go nodeServer.ObserveCachedImages(loops, *cachedImagesPeriod)
+		}
+
+		if *imageReportPeriod > 0 {
+			reporter, err := watcher.NewNodeImages()
+			if err != nil {
+				klog.Fatalf("unable to create Node client: %s", err)
+			}
+
+			go nodeServer.ReportImages(loops, reporter, *imageReportPeriod)
+		}
+
+		if *volumeStatusPeriod > 0 {
+			reporter, err := watcher.NewNodeImageVolumeStatuses()
+			if err != nil {
+				klog.Fatalf("unable to create NodeImageVolumeStatus client: %s", err)
+			}
+
+			go nodeServer.ReportVolumeStatus(loops, reporter, *volumeStatusPeriod)
+		}
+
+		if *prePullOnAttach {
+			attachmentWatcher, err := watcher.WatchAttachments(loops, *watcherResyncPeriod,
+				driverName, *nodeID, nodeServer.PrePull)
+			if err != nil {
+				klog.Fatalf("unable to create VolumeAttachment watcher: %s", err)
+			}
 
+			context.AfterFunc(loops, attachmentWatcher.Stop)
+		}
+
+		gcOpts := backend.JanitorOptions{Period: *janitorPeriod, KubeletRoot: *kubeletRoot}
+		if gcOpts.Period <= 0 {
+			gcOpts.Period = gcStagingDirMinAge
+		}
+
+		if err := nodeServer.ServeAdmin(loops, filepath.Join(*dataDir, adminSocket), gcOpts); err != nil {
+			klog.Fatalf("unable to serve the admin socket: %s", err)
+		}
+
+		if *debugPort > 0 {
+			debugState = nodeServer.serveDebugState(*dataDir,
+				filepath.Join(*kubeletRoot, "plugins", "kubernetes.io", "csi", driverName))
+		}
+
+		if *configFile != "" {
+			t := tunables{server: server, nodeServer: nodeServer, mounter: mounter}
+			if err := config.Watch(loops, *configFile, *configReloadPeriod, t.apply); err != nil {
+				klog.Fatalf("unable to load config %q: %s", *configFile, err)
+			}
+		}
+
+		health = &healthChecker{}
+		if fakeMounter == nil {
+			var sockets []string
+			for _, addr := range append([]string{*runtimeAddr}, fallbackRuntimeAddrs...) {
+				if u, err := url.Parse(addr); err == nil {
+					sockets = append(sockets, u.Path)
+				}
+			}
+
+			health.addLiveness("runtime", checkRuntimeSockets(sockets))
+		}
+
+		health.addLiveness("data-dir", checkDataDir(*dataDir))
+		if *healthCanaryImage != "" {
+			health.addLiveness("image-status", checkImageStatus(criClient, *healthCanaryImage))
+		}
+
+		if *stuckOperationFactor > 0 {
+			health.addLiveness("stuck-operations", nodeServer.checkStuckOperations(*stuckOperationFactor))
+		}
+
+		health.addReadiness("serving", checkServing(loops))
+		identityServer := NewIdentityServer(info)
+		identityServer.health = health
 		server.Start(*endpoint,
-			NewIdentityServer(driverVersion),
+			identityServer,
 			nil,
-			NewNodeServer(driver, mounter, criClient, secretStore, *asyncImagePullTimeout))
+			nodeServer)
 	case controllerMode:
-		watcher, err := watcher.New(context.Background(), *watcherResyncPeriod)
+		pvcWatcher, err := watcher.New(context.Background(), *watcherResyncPeriod)
 		if err != nil {
 			klog.Fatalf("unable to create PVC watcher: %s", err)
 		}
 
-		defer watcher.Stop()
+		defer pvcWatcher.Stop()
+
+		kubeClient, err := secret.NewClient()
+		if err != nil {
+			klog.Fatalf("unable to create Kubernetes client: %s", err)
+		}
+
+		// Only secrets of StorageClasses are used to access registries.
+		controllerServer := NewControllerServer(driver, pvcWatcher, secret.CreateStoreOrDie("", "", "", false), kubeClient,
+			*validateProvisionedImages)
+		controllerServer.prePullOnAttach = *prePullOnAttach
+		controllerServer.modifyVolumes = *volumeAttributesClasses
+		if *volumeSnapshots {
+			if controllerServer.snapshotContents, err = watcher.NewSnapshotContents(); err != nil {
+				klog.Fatalf("unable to create VolumeSnapshotContent client: %s", err)
+			}
+		}
+
+		if *manageCSIDriver {
+			policy := storagev1.FSGroupPolicy(*fsGroupPolicy)
+			if policy != storagev1.NoneFSGroupPolicy && policy != storagev1.FileFSGroupPolicy &&
+				policy != storagev1.ReadWriteOnceWithFSTypeFSGroupPolicy {
+				klog.Fatalf("invalid fsGroupPolicy %q", policy)
+			}
+
+			var tokenRequests []storagev1.TokenRequest
+			for _, audience := range *tokenAudiences {
+				tokenRequests = append(tokenRequests, storagev1.TokenRequest{Audience: audience})
+			}
+
+			go watcher.ManageCSIDriver(context.Background(), kubeClient, driverName, storagev1.CSIDriverSpec{
+				AttachRequired:  ptr.To(*prePullOnAttach),
+				PodInfoOnMount:  ptr.To(true),
+				StorageCapacity: ptr.To(*storageCapacity),
+				FSGroupPolicy:   &policy,
+				SELinuxMount:    ptr.To(*seLinuxMount),
+				TokenRequests:   tokenRequests,
+				VolumeLifecycleModes: []storagev1.VolumeLifecycleMode{
+					storagev1.VolumeLifecyclePersistent,
+					storagev1.VolumeLifecycleEphemeral,
+				},
+			}, *watcherResyncPeriod)
+		}
+
+		if *cacheCoordinator {
+			nodeImages, err := watcher.NewNodeImages()
+			if err != nil {
+				klog.Fatalf("unable to create Node client: %s", err)
+			}
+
+			go func() {
+				if err := nodeImages.Coordinate(context.Background(), *watcherResyncPeriod); err != nil {
+					klog.Fatalf("unable to run the cache coordinator: %s", err)
+				}
+			}()
+		}
 
 		server.Start(*endpoint,
-			NewIdentityServer(driverVersion),
-			NewControllerServer(driver, watcher),
+			NewIdentityServer(info),
+			controllerServer,
 			nil,
 		)
+	case repairMode:
+		selected, _, err := selectRuntimeAddr(*runtimeAddr)
+		if err != nil {
+			klog.Fatalf("invalid runtime address: %s", err)
+		}
+
+		addr, err := url.Parse(selected)
+		if err != nil {
+			klog.Fatalf("invalid runtime address: %s", err)
+		}
+
+		if addr.Scheme != containerdScheme {
+			klog.Fatalf("only snapshots of containerd can be repaired")
+		}
+
+		if err = containerd.RepairSnapshots(context.Background(), addr.Path, *containerdSnapshotter,
+			*snapshotLabels); err != nil {
+			klog.Fatalf("unable to repair snapshots: %s", err)
+		}
+
+		return
+	case prefetchMode:
+		imageSvc, err := prefetchImageService()
+		if err != nil {
+			klog.Fatalf("unable to connect to the image service: %s", err)
+		}
+
+		secretStore := secret.CreateStoreOrDie(*icpConf, *icpBin, *nodePluginSA, *enableCache)
+		nodeServer := NewNodeServer(driver, nil, imageSvc, secretStore, 0)
+		nodeServer.pullRuntimeHandler = *pullRuntimeHandler
+		if nodeServer.registries, err = newRegistryRules(*allowedRegistries, *deniedRegistries); err != nil {
+			klog.Fatalf("invalid registry rules: %s", err)
+		}
+		nodeServer.requireDigests = *requireDigests
+		if *volumeSecretRefs {
+			if nodeServer.kubeClient, err = secret.NewClient(); err != nil {
+				klog.Fatalf("unable to create Kubernetes client: %s", err)
+			}
+		}
+
+		attributes := map[string]string{}
+		for k, v := range *prefetchAttributes {
+			attributes[k] = v
+		}
+
+		if *prefetchImage != "" {
+			attributes[ctxKeyImage] = *prefetchImage
+		}
+
+		image, err := nodeServer.Prefetch(context.Background(), attributes)
+		if err != nil {
+			klog.Fatalf("unable to prefetch image: %s", err)
+		}
+
+		fmt.Printf("image: %s\nid: %s\nrepoDigests: %s\nsize: %d\n", attributes[ctxKeyImage], image.GetId(),
+			strings.Join(image.GetRepoDigests(), ","), image.GetSize())
+		return
+	case gcMode:
+		if err := requestGC(filepath.Join(*dataDir, adminSocket), *gcConfirm); err != nil {
+			klog.Fatalf("unable to collect garbage: %s", err)
+		}
+
+		return
+	case inspectMode:
+		if err := requestInspect(filepath.Join(*dataDir, adminSocket)); err != nil {
+			klog.Fatalf("unable to inspect the node plugin: %s", err)
+		}
+
+		return
+	case backendPluginMode:
+		if len(*backendPlugin) == 0 {
+			klog.Fatal("--backend-plugin-addr is required in backend-plugin mode")
+		}
+
+		if len(*runtimeAddr) == 0 {
+			detected, err := detectRuntimeAddr()
+			if err != nil {
+				klog.Fatalf("The unit socket of container runtime is required: %s", err)
+			}
+			*runtimeAddr = detected
+		} else if selected, _, err := selectRuntimeAddr(*runtimeAddr); err != nil {
+			klog.Fatalf("invalid runtime address: %s", err)
+		} else {
+			*runtimeAddr = selected
+		}
+
+		addr, err := url.Parse(*runtimeAddr)
+		if err != nil {
+			klog.Fatalf("invalid runtime address: %s", err)
+		}
+
+		cacheSize, err := resource.ParseQuantity(*blockCacheSize)
+		if err != nil {
+			klog.Fatalf("invalid block cache size %q: %s", *blockCacheSize, err)
+		}
+
+		mounter, _ := newRuntimeMounter(addr)
+		enableMounterFeatures(loops, mounter, cacheSize.Value())
+		metrics.StartMetricsServer(metrics.RegisterMetrics(), *metricsPort, nil, metricsServerOptions())
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		if err = plugin.Serve(ctx, *backendPlugin, mounter); err != nil {
+			klog.Fatalf("unable to serve the backend plugin: %s", err)
+		}
+
+		return
+	case webhookMode:
+		webhookServer := webhook.NewServer(*webhookCertDir)
+		webhookServer.HandleMutation("/mutate-pods", driverName)
+		webhookServer.HandleValidation("/validate-images", driverName, webhook.RegistryPolicy{
+			AllowedPrefixes: *allowedImages,
+			RequireDigests:  *requireDigests,
+		})
+		if *translateImageVolumes != "" {
+			to := webhook.Translation(*translateImageVolumes)
+			if to != webhook.TranslateToCSI && to != webhook.TranslateToNative {
+				klog.Fatalf("unknown translation %q of image volumes", to)
+			}
+
+			webhookServer.HandleTranslation("/translate-pods", driverName, to)
+		}
+
+		if *debugPort > 0 {
+			startDebugServer(*debugPort, nil)
+		}
+
+		metrics.StartMetricsServer(metrics.RegisterMetrics(), *metricsPort, nil, metricsServerOptions())
+		klog.Fatalf("unable to serve admission webhooks: %s", webhookServer.ListenAndServe(*webhookAddr))
+	default:
+		klog.Fatalf("unknown mode %q", *mode)
+	}
+
+	if *debugPort > 0 {
+		startDebugServer(*debugPort, debugState)
 	}
 
-	metrics.StartMetricsServer(metrics.RegisterMetrics(), *metricsPort)
-	server.Wait()
+	var handlers map[string]http.Handler
+	if health != nil {
+		handlers = health.Handlers()
+	}
+
+	metrics.StartMetricsServer(metrics.RegisterMetrics(), *metricsPort, handlers, metricsServerOptions())
+	serveUntilTerminated(server, background, *shutdownGracePeriod, takeover, stopLoops)
+}
+
+// metricsServerOptions returns how the metrics port is protected by flags.
+func metricsServerOptions() metrics.ServerOptions {
+	return metrics.ServerOptions{
+		CertDir:         *metricsCertDir,
+		ClientCAFile:    *metricsClientCA,
+		BearerTokenFile: *metricsBearerTokenFile,
+	}
+}
+
+// newRuntimeMounter creates the in-tree backend of the runtime at addr, and the image service of the runtime if it
+// isn't served at addr.
+func newRuntimeMounter(addr *url.URL) (*backend.SnapshotMounter, criapi.ImageServiceClient) {
+	klog.Infof("runtime %s at %q", addr.Scheme, addr.Path)
+	switch addr.Scheme {
+	case containerdScheme:
+		return containerd.NewMounter(addr.Path, *containerdSnapshotter, *overlayOptions, *snapshotLabels), nil
+	case criOScheme:
+		return crio.NewMounter(addr.Path), nil
+	case criDockerdScheme:
+		return docker.NewMounter(*dockerAddr, filepath.Join(*dataDir, "docker")), nil
+	case podmanScheme:
+		return crio.NewStorageMounter(), cri.NewPodmanImageService(addr.Path)
+	default:
+		klog.Fatalf("unknown container runtime %q", addr.Scheme)
+		return nil, nil
+	}
+}
+
+// enableMounterFeatures enables state, metadata, block volumes, and background loops of the in-tree backend in the
+// data directory, which run until ctx is done.
+func enableMounterFeatures(ctx context.Context, mounter *backend.SnapshotMounter, blockCacheSize int64) {
+	mounter.EnableStateStore(filepath.Join(*dataDir, "state"))
+	mounter.EnableImageMetadata(filepath.Join(*dataDir, "metadata"))
+	mounter.EnableBlockVolumes(filepath.Join(*dataDir, "block"), blockCacheSize)
+	mounter.EnableJournal(filepath.Join(*dataDir, "journal"))
+	if *snapshotRetention > 0 {
+		mounter.EnableSnapshotRetention(*snapshotRetention)
+	}
+
+	if *mountHealthCheckPeriod > 0 {
+		mounter.StartHealthCheck(ctx, *mountHealthCheckPeriod)
+	}
+
+	if *janitorPeriod > 0 {
+		mounter.StartJanitor(ctx, backend.JanitorOptions{
+			Period:      *janitorPeriod,
+			MaxRemovals: *janitorMaxRemovals,
+			DryRun:      *janitorDryRun,
+			KubeletRoot: *kubeletRoot,
+		})
+	}
 }
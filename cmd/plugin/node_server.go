@@ -1,44 +1,129 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/containerd/platforms"
 	"github.com/distribution/reference"
 	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
 	csicommon "github.com/warm-metal/container-image-csi-driver/pkg/csi-common"
 	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
 	"github.com/warm-metal/container-image-csi-driver/pkg/remoteimage"
 	"github.com/warm-metal/container-image-csi-driver/pkg/remoteimageasync"
 	"github.com/warm-metal/container-image-csi-driver/pkg/secret"
+	"github.com/warm-metal/container-image-csi-driver/pkg/tracing"
+	"github.com/warm-metal/container-image-csi-driver/pkg/volume"
+	"github.com/warm-metal/container-image-csi-driver/pkg/watcher"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sys/unix"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	cri "k8s.io/cri-api/pkg/apis/runtime/v1"
 	"k8s.io/klog/v2"
 	k8smount "k8s.io/mount-utils"
 )
 
 const (
-	ctxKeyVolumeHandle    = "volumeHandle"
-	ctxKeyImage           = "image"
-	ctxKeyPullAlways      = "pullAlways"
-	ctxKeyEphemeralVolume = "csi.storage.k8s.io/ephemeral"
+	ctxKeyVolumeHandle         = volume.AttrVolumeHandle
+	ctxKeyImage                = volume.AttrImage
+	ctxKeyPullAlways           = "pullAlways"
+	ctxKeyPullTimeout          = "pullTimeout"
+	ctxKeyFSType               = "fsType"
+	ctxKeyQuota                = "quota"
+	ctxKeyPersistentScratch    = "persistentScratch"
+	ctxKeyUpperLayer           = "upperLayer"
+	ctxKeyCloneSource          = "cloneSource"
+	ctxKeySnapshotSource       = "snapshotSource"
+	ctxKeyPath                 = "path"
+	ctxKeyOverlayImages        = volume.AttrOverlayImages
+	ctxKeyMountOptions         = "mountOptions"
+	ctxKeyBlockFormat          = "blockFormat"
+	ctxKeyImageMetadata        = "imageMetadata"
+	ctxKeyReferrers            = "referrers"
+	ctxKeyRequiredAttestations = "requiredAttestations"
+	ctxKeyVerity               = "verity"
+	ctxKeyContainerDisk        = "containerDisk"
+	ctxKeySecret               = "secret"
+	ctxKeySecretNamespace      = "secretNamespace"
+	ctxKeyPlatform             = "platform"
+	ctxKeyBackend              = "backend"
+	ctxKeyEphemeralVolume      = "csi.storage.k8s.io/ephemeral"
+	ctxKeyPodNamespace         = "csi.storage.k8s.io/pod.namespace"
 )
 
 type ImagePullStatus int
 
 type NodeServer struct {
-	driver                *csicommon.CSIDriver
-	mounter               backend.Mounter
-	imageSvc              cri.ImageServiceClient
-	secretStore           secret.Store
-	asyncImagePullTimeout time.Duration
+	driver      *csicommon.CSIDriver
+	mounter     backend.Mounter
+	imageSvc    cri.ImageServiceClient
+	secretStore secret.Store
+	// asyncImagePullTimeout is the default timeout of pulls in async mode, in nanoseconds, which may be reloaded
+	asyncImagePullTimeout *atomic.Int64
 	asyncImagePuller      remoteimageasync.AsyncPuller
+	// the runtime handler passed to the runtime to pick the snapshotter images are unpacked with
+	pullRuntimeHandler string
+	// referrers are disabled if referrersDir is empty
+	referrersDir string
+	// image decryption is disabled if decryptionKeys is nil
+	decryptionKeys *secret.DecryptionKeyStore
+	// signatures of images aren't verified if notation is nil
+	notation *remoteimage.NotationVerifier
+	// images can be pulled from all registries if registries is nil
+	registries *registryRules
+	// images referred by tags are refused if requireDigests is set
+	requireDigests bool
+	// images aren't checked against ClusterImageVolumePolicies if imagePolicies is nil
+	imagePolicies *watcher.ImageVolumePolicies
+	// backend is the name of the backend mounting volumes, which is checked against the volume attribute backend
+	backend string
+	// secrets referred by volume attributes are ignored if kubeClient is nil
+	kubeClient kubernetes.Interface
+	// VolumeAttributesClasses of PVs are ignored if volumeAttributesClasses is nil
+	volumeAttributesClasses *watcher.VolumeAttributesClasses
+	// pods aren't annotated with digests of images of their volumes if podAnnotations is nil
+	podAnnotations kubernetes.Interface
+	// PVs aren't annotated with nodes of their persistent scratch layers if scratchNodes is nil
+	scratchNodes kubernetes.Interface
+	// events of mount failures aren't emitted to pods if events is nil
+	events record.EventRecorder
+	// pulls aren't audited if auditLog is nil
+	auditLog *remoteimage.AuditLog
+	// slow pulls aren't warned about if slowPull is nil
+	slowPull *slowPullThresholds
+	// volume snapshots are saved to and restored from snapshotsDir
+	snapshotsDir string
+	// snapshots are not saved if snapshotContents is nil
+	snapshotContents *watcher.SnapshotContents
+	// maxVolumes is the maximum number of volumes on the node reported to kubelet. 0 means unlimited.
+	maxVolumes int64
+	// inFlight guards publications and unpublications of volumes against their retries
+	inFlight *inFlight
+	// background tracks pre-pulls and snapshot saving, which are drained on shutdown
+	background *backgroundTasks
+	// localImages records images of volumes pulled on the node, which are reported to the cache coordinator
+	localImages *imageSet
+	// reclaimer removes images of deleted PVs, which are never removed while volumes are being mounted
+	reclaimer *imageReclaimer
 	csi.UnimplementedNodeServer
 }
 
@@ -51,22 +136,39 @@ func NewNodeServer(driver *csicommon.CSIDriver, mounter backend.Mounter, imageSv
 		mounter:               mounter,
 		imageSvc:              imageSvc,
 		secretStore:           secretStore,
-		asyncImagePullTimeout: asyncImagePullTimeout,
+		asyncImagePullTimeout: &atomic.Int64{},
 		asyncImagePuller:      nil,
+		inFlight:              newInFlight(""),
+		background:            &backgroundTasks{},
+		localImages:           newImageSet(),
+		reclaimer:             newImageReclaimer(),
 	}
+	ns.restoreLocalImages()
 	if asyncImagePullTimeout >= time.Duration(30*time.Second) {
 		klog.Infof("Starting node server in Async mode with %v timeout", asyncImagePullTimeout)
 		ns.asyncImagePuller = remoteimageasync.StartAsyncPuller(context.TODO(), 100)
+		ns.asyncImagePullTimeout.Store(int64(asyncImagePullTimeout))
 	} else {
 		klog.Info("Starting node server in Sync mode")
-		ns.asyncImagePullTimeout = 0 // set to default value
 	}
 	return ns
 }
 
+// SetAsyncPullTimeout changes the default timeout of pulls in async mode. It is ignored in sync mode, which can only
+// be switched on startup.
+func (n NodeServer) SetAsyncPullTimeout(timeout time.Duration) {
+	if n.asyncImagePuller != nil {
+		n.asyncImagePullTimeout.Store(int64(timeout))
+	}
+}
+
 func (n NodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (resp *csi.NodePublishVolumeResponse, err error) {
-	valuesLogger := klog.LoggerWithValues(klog.NewKlogr(), "pod-name", req.VolumeContext["pod-name"], "namespace", req.VolumeContext["namespace"], "uid", req.VolumeContext["uid"])
-	valuesLogger.Info("Incoming NodePublishVolume request", "request string", protosanitizer.StripSecrets(req))
+	defer metrics.InFlightOperations.Start(ctx, metrics.OperationPublish)()
+	ctx, span := tracing.Start(ctx, "NodePublishVolume", tracing.AttrVolumeID.String(req.VolumeId))
+	defer func() { tracing.End(span, err) }()
+	valuesLogger := klog.LoggerWithValues(klog.FromContext(ctx), "pod-name", req.VolumeContext[ctxKeyLogPodName], "namespace", req.VolumeContext[ctxKeyLogNamespace], "uid", req.VolumeContext[ctxKeyLogUID])
+	ctx = klog.NewContext(ctx, valuesLogger)
+	valuesLogger.Info("Incoming NodePublishVolume request", "request string", csicommon.StripSecrets(req))
 	if len(req.VolumeId) == 0 {
 		err = status.Error(codes.InvalidArgument, "VolumeId is missing")
 		return
@@ -82,23 +184,73 @@ func (n NodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishV
 		return
 	}
 
-	if _, isBlock := req.VolumeCapability.AccessType.(*csi.VolumeCapability_Block); isBlock {
-		err = status.Error(codes.InvalidArgument, "unable to mount as a block device")
+	if len(req.VolumeContext) == 0 {
+		err = status.Error(codes.InvalidArgument, "VolumeContext is missing")
 		return
 	}
 
-	if len(req.VolumeContext) == 0 {
-		err = status.Error(codes.InvalidArgument, "VolumeContext is missing")
+	if err = validateVolumeCapability(req.VolumeCapability, req.VolumeContext); err != nil {
+		err = status.Error(codes.InvalidArgument, err.Error())
+		return
+	}
+
+	if err = validateVolumeAttributes(req.VolumeId, req.VolumeContext); err != nil {
+		err = status.Error(codes.InvalidArgument, err.Error())
+		return
+	}
+
+	if req.StagingTargetPath != "" {
+		if err = n.publishStagedVolume(ctx, req); err != nil {
+			return
+		}
+
+		valuesLogger.Info("Successfully completed NodePublishVolume request", "request string", csicommon.StripSecrets(req))
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	var pod *podInfo
+	if isEphemeralVolume(req.VolumeContext) {
+		if pod, err = validateEphemeralVolume(req.VolumeContext); err != nil {
+			err = status.Error(codes.InvalidArgument, err.Error())
+			return
+		}
+
+		valuesLogger.Info("publish ephemeral volume", "pod", pod.String())
+	} else if err = n.applyVolumeAttributesClass(ctx, req, podVolumeName(req.TargetPath)); err != nil {
+		return
+	}
+
+	interrupted, err := n.inFlight.start(ctx, opPublish, req.VolumeId, req.TargetPath)
+	if err != nil {
 		return
 	}
+	defer n.inFlight.finish(req.VolumeId, req.TargetPath)
 
-	if req.VolumeContext[ctxKeyEphemeralVolume] != "true" &&
-		req.VolumeCapability.AccessMode.Mode != csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY &&
-		req.VolumeCapability.AccessMode.Mode != csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY {
-		err = status.Error(codes.InvalidArgument, "AccessMode of PV can be only ReadOnlyMany or ReadOnlyOnce")
+	namedRef, err := n.mountVolume(ctx, req, pod, interrupted)
+	if err != nil {
 		return
 	}
 
+	if namedRef != nil {
+		n.annotateDigest(req.VolumeContext, req.TargetPath, namedRef)
+	}
+	valuesLogger.Info("Successfully completed NodePublishVolume request", "request string", csicommon.StripSecrets(req))
+
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// mountVolume pulls and verifies images of the volume, and mounts the volume to the target of the request. PVs are
+// mounted to their staging targets by NodeStageVolume, and ephemeral volumes to their targets by NodePublishVolume.
+// The returned image is nil if the volume is already mounted.
+func (n NodeServer) mountVolume(
+	ctx context.Context, req *csi.NodePublishVolumeRequest, pod *podInfo, interrupted *inFlightOp,
+) (namedRef reference.Named, err error) {
+	valuesLogger := klog.FromContext(ctx)
+	defer n.holdImages()()
+
+	persistentScratch := strings.ToLower(req.VolumeContext[ctxKeyPersistentScratch]) == "true"
+	block := req.VolumeCapability.GetBlock() != nil
+
 	notMnt, err := k8smount.New("").IsLikelyNotMountPoint(req.TargetPath)
 	if err != nil {
 		if !os.IsNotExist(err) {
@@ -106,7 +258,7 @@ func (n NodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishV
 			return
 		}
 
-		if err = os.MkdirAll(req.TargetPath, 0o755); err != nil {
+		if err = createTarget(req.TargetPath, block); err != nil {
 			err = status.Error(codes.Internal, err.Error())
 			return
 		}
@@ -114,150 +266,1405 @@ func (n NodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishV
 		notMnt = true
 	}
 
-	if !notMnt {
-		return &csi.NodePublishVolumeResponse{}, nil
+	if pod != nil {
+		defer func() {
+			if err != nil {
+				cleanupEphemeralTarget(pod, req.TargetPath)
+			}
+		}()
 	}
 
-	// For PVs, VolumeId is the image. For ephemeral volumes, it is a string.
-	image := req.VolumeId
+	if !notMnt {
+		if interrupted == nil {
+			return nil, nil
+		}
 
-	if len(req.VolumeContext[ctxKeyVolumeHandle]) > 0 {
-		image = req.VolumeContext[ctxKeyVolumeHandle]
-	} else if len(req.VolumeContext[ctxKeyImage]) > 0 {
-		image = req.VolumeContext[ctxKeyImage]
+		// The mount may be left by the interrupted operation before it completed, so it is made again.
+		valuesLogger.Info("unmount the target to publish the volume again", "target", req.TargetPath)
+		if err = n.unmountVolume(ctx, req.VolumeId, req.TargetPath); err != nil {
+			return
+		}
 	}
 
+	image := volume.Image(req.VolumeId, req.VolumeContext)
 	pullAlways := strings.ToLower(req.VolumeContext[ctxKeyPullAlways]) == "true"
+	pullTimeout, err := n.pullTimeout(req.VolumeContext)
+	if err != nil {
+		err = status.Error(codes.InvalidArgument, err.Error())
+		return
+	}
 
-	keyring, err := n.secretStore.GetDockerKeyring(ctx, req.Secrets)
+	fsType, err := imageFSType(req)
 	if err != nil {
-		err = status.Errorf(codes.Aborted, "unable to fetch keyring: %s", err)
+		err = status.Error(codes.InvalidArgument, err.Error())
 		return
 	}
 
-	namedRef, err := reference.ParseDockerRef(image)
+	if fsType != "" && len(volume.SplitList(req.VolumeContext[ctxKeyOverlayImages])) > 0 {
+		err = status.Errorf(codes.InvalidArgument, "%s can't be used with fsType %q", ctxKeyOverlayImages, fsType)
+		return
+	}
+
+	if err = n.checkPlacement(req.VolumeContext); err != nil {
+		return
+	}
+
+	keyring, err := n.resolveKeyring(ctx, req, pod)
 	if err != nil {
-		klog.Errorf("unable to normalize image %q: %s", image, err)
+		n.recordMountFailure(req.VolumeContext, image, ReasonErrAuth, err)
 		return
 	}
 
-	// NOTE: we are relying on n.mounter.ImageExists() to return false when
-	//      a first-time pull is in progress, else this logic may not be
-	//      correct. should test this.
-	if pullAlways || !n.mounter.ImageExists(ctx, namedRef) {
-		klog.Errorf("pull image %q", image)
-		puller := remoteimage.NewPuller(n.imageSvc, namedRef, keyring)
+	if namedRef, err = reference.ParseDockerRef(image); err != nil {
+		klog.Errorf("unable to normalize image %q: %s", image, err)
+		err = status.Errorf(codes.InvalidArgument, "invalid image %q: %s", image, err)
+		return nil, err
+	}
 
-		if n.asyncImagePuller != nil {
-			var session *remoteimageasync.PullSession
-			session, err = n.asyncImagePuller.StartPull(image, puller, n.asyncImagePullTimeout)
-			if err != nil {
-				err = status.Errorf(codes.Aborted, "unable to pull image %q: %s", image, err)
-				metrics.OperationErrorsCount.WithLabelValues("pull-async-start").Inc()
-				return
-			}
-			if err = n.asyncImagePuller.WaitForPull(session, ctx); err != nil {
-				err = status.Errorf(codes.Aborted, "unable to pull image %q: %s", image, err)
-				metrics.OperationErrorsCount.WithLabelValues("pull-async-wait").Inc()
-				return
-			}
-		} else {
-			if err = puller.Pull(ctx); err != nil {
-				err = status.Errorf(codes.Aborted, "unable to pull image %q: %s", image, err)
-				metrics.OperationErrorsCount.WithLabelValues("pull-sync-call").Inc()
+	trace.SpanFromContext(ctx).SetAttributes(tracing.ImageAttributes(namedRef)...)
+
+	if err = n.checkImagePolicies(req.VolumeContext, image, namedRef); err != nil {
+		return
+	}
+
+	for _, overlayImage := range volume.SplitList(req.VolumeContext[ctxKeyOverlayImages]) {
+		if overlayRef, parseErr := reference.ParseDockerRef(overlayImage); parseErr == nil {
+			if err = n.checkImagePolicies(req.VolumeContext, overlayImage, overlayRef); err != nil {
 				return
 			}
 		}
 	}
 
+	decryptionKeys := secret.DecryptionKeys(req.Secrets)
+	if len(decryptionKeys) > 0 {
+		if n.decryptionKeys == nil {
+			err = status.Error(codes.FailedPrecondition, "image decryption is not enabled")
+			return
+		}
+
+		// Keys are only kept in the key directory of the runtime while images of the volume are pulled.
+		var releaseKeys func()
+		if releaseKeys, err = n.decryptionKeys.Install(decryptionKeys); err != nil {
+			err = status.Error(codes.Internal, err.Error())
+			return
+		}
+		defer releaseKeys()
+	}
+
+	if err = n.pullImage(ctx, image, namedRef, keyring, pullAlways, pullTimeout,
+		pullRequester(req.VolumeId, req.VolumeContext)); err != nil {
+		n.recordMountFailure(req.VolumeContext, image, pullFailureReason(err), err)
+		return
+	}
+
+	if err = n.authorizeDecryption(ctx, namedRef, decryptionKeys); err != nil {
+		return
+	}
+
+	if err = n.verifySignatures(ctx, namedRef, keyring); err != nil {
+		return
+	}
+
+	if err = n.verifyAttestations(ctx, req.VolumeContext, namedRef, keyring); err != nil {
+		return
+	}
+
+	var overlayImages []reference.Named
+	for _, overlayImage := range volume.SplitList(req.VolumeContext[ctxKeyOverlayImages]) {
+		var overlayRef reference.Named
+		if overlayRef, err = reference.ParseDockerRef(overlayImage); err != nil {
+			err = status.Errorf(codes.InvalidArgument, "invalid overlay image %q: %s", overlayImage, err)
+			return
+		}
+
+		if err = n.pullImage(ctx, overlayImage, overlayRef, keyring, pullAlways, pullTimeout,
+			pullRequester(req.VolumeId, req.VolumeContext)); err != nil {
+			n.recordMountFailure(req.VolumeContext, overlayImage, pullFailureReason(err), err)
+			return
+		}
+
+		if err = n.authorizeDecryption(ctx, overlayRef, decryptionKeys); err != nil {
+			return
+		}
+
+		if err = n.verifySignatures(ctx, overlayRef, keyring); err != nil {
+			return
+		}
+
+		if err = n.verifyAttestations(ctx, req.VolumeContext, overlayRef, keyring); err != nil {
+			return
+		}
+
+		overlayImages = append(overlayImages, overlayRef)
+	}
+
+	// Staged volumes are shared by all publications, so readonly of a publication only applies to its bind.
 	ro := req.Readonly ||
 		req.VolumeCapability.AccessMode.Mode == csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY ||
 		req.VolumeCapability.AccessMode.Mode == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY
-	if err = n.mounter.Mount(ctx, req.VolumeId, backend.MountTarget(req.TargetPath), namedRef, ro); err != nil {
-		err = status.Error(codes.Internal, err.Error())
-		metrics.OperationErrorsCount.WithLabelValues("mount").Inc()
+	mountFlags, roFlag, err := volumeMountFlags(req.VolumeCapability, req.VolumeContext, ro)
+	if err != nil {
+		err = status.Error(codes.InvalidArgument, err.Error())
+		return
+	}
+	ro = ro || roFlag
+	opts := backend.MountOptions{ReadOnly: ro, FSType: fsType, PersistentScratch: persistentScratch && !ro}
+	if opts.PersistentScratch {
+		opts.CloneSource = req.VolumeContext[ctxKeyCloneSource]
+		if snapshot := req.VolumeContext[ctxKeySnapshotSource]; snapshot != "" {
+			opts.SnapshotSource = n.snapshotPath(snapshot)
+		}
+	}
+	opts.OverlayImages = overlayImages
+	opts.SELinuxContext = seLinuxMountContext(req.VolumeCapability)
+	opts.MountFlags = mountFlags
+	if path := req.VolumeContext[ctxKeyPath]; path != "" && filepath.Clean("/"+path) != "/" {
+		opts.Path = filepath.Clean("/" + path)
+	}
+	if strings.ToLower(req.VolumeContext[ctxKeyContainerDisk]) == "true" && !block && opts.Path == "" {
+		// Publish the directory holding the VM disk of KubeVirt containerDisk images.
+		opts.Path = backend.ContainerDiskDir
+	}
+	if strings.ToLower(req.VolumeContext[ctxKeyImageMetadata]) == "true" {
+		if block || opts.Path != "" {
+			err = status.Errorf(codes.InvalidArgument, "%s can't be used with block volumes or %s",
+				ctxKeyImageMetadata, ctxKeyPath)
+			return
+		}
+		opts.ImageMetadata = true
+	}
+	if strings.ToLower(req.VolumeContext[ctxKeyReferrers]) == "true" {
+		if block || opts.Path != "" {
+			err = status.Errorf(codes.InvalidArgument, "%s can't be used with block volumes or %s",
+				ctxKeyReferrers, ctxKeyPath)
+			return
+		}
+		if opts.ReferrersDir, err = n.fetchReferrers(ctx, namedRef, keyring); err != nil {
+			return
+		}
+	}
+	if block {
+		if opts.BlockFormat, err = blockFormat(req.VolumeContext); err != nil {
+			err = status.Error(codes.InvalidArgument, err.Error())
+			return
+		}
+		opts.Verity = strings.ToLower(req.VolumeContext[ctxKeyVerity]) == "true"
+	} else if strings.ToLower(req.VolumeContext[ctxKeyVerity]) == "true" {
+		err = status.Errorf(codes.InvalidArgument, "%s is only supported by block volumes", ctxKeyVerity)
 		return
 	}
+	if !ro {
+		if opts.Quota, err = writableQuota(req.VolumeContext); err != nil {
+			err = status.Error(codes.InvalidArgument, err.Error())
+			return
+		}
 
-	valuesLogger.Info("Successfully completed NodePublishVolume request", "request string", protosanitizer.StripSecrets(req))
+		switch upperLayer := req.VolumeContext[ctxKeyUpperLayer]; upperLayer {
+		case "", "disk":
+		case "tmpfs":
+			if opts.PersistentScratch {
+				err = status.Errorf(codes.InvalidArgument, "%s %q can't be used with %s",
+					ctxKeyUpperLayer, upperLayer, ctxKeyPersistentScratch)
+				return
+			}
+			opts.TmpfsUpper = true
+		default:
+			err = status.Errorf(codes.InvalidArgument, "unsupported %s %q", ctxKeyUpperLayer, upperLayer)
+			return
+		}
+	}
 
-	return &csi.NodePublishVolumeResponse{}, nil
+	// The caller may give up while images are pulled. Images are kept, and the mount is made by its retry.
+	if err = ctx.Err(); err != nil {
+		err = status.FromContextError(err).Err()
+		return
+	}
+
+	mountCtx, mountSpan := tracing.Start(ctx, "Mount")
+	err = n.mounter.Mount(mountCtx, req.VolumeId, backend.MountTarget(req.TargetPath), namedRef, opts)
+	tracing.End(mountSpan, err)
+
+	if err != nil {
+		n.recordMountFailure(req.VolumeContext, image, mountFailureReason(err), err)
+		metrics.OperationErrorsCount.WithLabelValues("mount").Inc()
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if opts.PersistentScratch {
+		n.annotateScratchNode(req.VolumeId)
+	}
+
+	return namedRef, nil
 }
 
-func (n NodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (resp *csi.NodeUnpublishVolumeResponse, err error) {
-	klog.V(4).Infof("NodeUnpublishVolume: unmount request: %s", protosanitizer.StripSecrets(req))
+// publishStagedVolume binds the volume staged by NodeStageVolume to the target. Images of the volume are pulled and
+// verified when it is staged, while image policies are checked by each publication.
+func (n NodeServer) publishStagedVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (err error) {
+	interrupted, err := n.inFlight.start(ctx, opPublish, req.VolumeId, req.TargetPath)
+	if err != nil {
+		return err
+	}
+	defer n.inFlight.finish(req.VolumeId, req.TargetPath)
 
-	// Validate required fields
-	if len(req.VolumeId) == 0 {
-		return nil, status.Error(codes.InvalidArgument, "VolumeId is missing")
+	block := req.VolumeCapability.GetBlock() != nil
+	staged := stagedTarget(req.StagingTargetPath, block)
+	if notMnt, err := k8smount.New("").IsLikelyNotMountPoint(string(staged)); err != nil || notMnt {
+		return status.Errorf(codes.FailedPrecondition, "volume %q isn't staged at %q", req.VolumeId, staged)
 	}
 
-	if len(req.TargetPath) == 0 {
-		return nil, status.Error(codes.InvalidArgument, "TargetPath is missing")
+	notMnt, err := k8smount.New("").IsLikelyNotMountPoint(req.TargetPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return status.Error(codes.Internal, err.Error())
+		}
+
+		if err = createTarget(req.TargetPath, block); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+
+		notMnt = true
 	}
 
-	// Check if it's a mount point
-	mnt, err := k8smount.New("").IsMountPoint(req.TargetPath)
+	if !notMnt {
+		if interrupted == nil {
+			return nil
+		}
+
+		// The bind may be left by the interrupted operation before it completed, so it is made again.
+		klog.FromContext(ctx).Info("unmount the target to publish the volume again", "target", req.TargetPath)
+		if err = n.unmountVolume(ctx, req.VolumeId, req.TargetPath); err != nil {
+			return err
+		}
+	}
+
+	image := volume.Image(req.VolumeId, req.VolumeContext)
+	namedRef, err := reference.ParseDockerRef(image)
 	if err != nil {
-		if os.IsNotExist(err) {
-			// Path doesn't exist, volume is already unmounted
-			klog.V(4).Infof("NodeUnpublishVolume: target path %s does not exist, assuming volume is already unmounted", req.TargetPath)
-			return &csi.NodeUnpublishVolumeResponse{}, nil
+		return status.Errorf(codes.InvalidArgument, "invalid image %q: %s", image, err)
+	}
+
+	trace.SpanFromContext(ctx).SetAttributes(tracing.ImageAttributes(namedRef)...)
+	if err = n.checkImagePolicies(req.VolumeContext, image, namedRef); err != nil {
+		return err
+	}
+
+	for _, overlayImage := range volume.SplitList(req.VolumeContext[ctxKeyOverlayImages]) {
+		if overlayRef, parseErr := reference.ParseDockerRef(overlayImage); parseErr == nil {
+			if err = n.checkImagePolicies(req.VolumeContext, overlayImage, overlayRef); err != nil {
+				return err
+			}
 		}
-		// Other errors should be reported
-		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to check if path %s is a mount point: %v", req.TargetPath, err))
 	}
 
-	// If not mounted, return success
-	if !mnt {
-		klog.V(4).Infof("NodeUnpublishVolume: %s is not a mount point, no unmount needed", req.TargetPath)
-		return &csi.NodeUnpublishVolumeResponse{}, nil
+	ro := req.Readonly ||
+		req.VolumeCapability.AccessMode.Mode == csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY ||
+		req.VolumeCapability.AccessMode.Mode == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY
+	mountFlags, roFlag, err := volumeMountFlags(req.VolumeCapability, req.VolumeContext, ro)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	// Attempt to unmount
-	if err = n.mounter.Unmount(ctx, req.VolumeId, backend.MountTarget(req.TargetPath)); err != nil {
-		metrics.OperationErrorsCount.WithLabelValues("unmount").Inc()
-		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to unmount volume at %s: %v", req.TargetPath, err))
+	// Only the bind is made by publications, so only its flags are passed.
+	opts := backend.MountOptions{ReadOnly: ro || roFlag, MountFlags: mountFlags}
+	mountCtx, mountSpan := tracing.Start(ctx, "Bind")
+	err = n.mounter.Publish(mountCtx, req.VolumeId, staged, backend.MountTarget(req.TargetPath), namedRef, opts,
+		req.Readonly)
+	tracing.End(mountSpan, err)
+	if err != nil {
+		n.recordMountFailure(req.VolumeContext, image, mountFailureReason(err), err)
+		metrics.OperationErrorsCount.WithLabelValues("mount").Inc()
+		return status.Error(codes.Internal, err.Error())
 	}
 
-	klog.V(4).Infof("NodeUnpublishVolume: volume %s has been unmounted successfully", req.VolumeId)
-	return &csi.NodeUnpublishVolumeResponse{}, nil
+	n.annotateDigest(req.VolumeContext, req.TargetPath, namedRef)
+	return nil
+}
+
+// resolveKeyring collects credentials of the volume, i.e. its secrets, secrets its attributes refer to, image pull
+// secrets of the service account of the pod for ephemeral volumes, and plugins receiving service account tokens.
+func (n NodeServer) resolveKeyring(
+	ctx context.Context, req *csi.NodePublishVolumeRequest, pod *podInfo,
+) (keyring secret.DockerKeyring, err error) {
+	ctx, span := tracing.Start(ctx, "ResolveCredentials")
+	defer func() { tracing.End(span, err) }()
+
+	if keyring, err = n.secretStore.GetDockerKeyring(ctx, req.Secrets); err != nil {
+		return nil, status.Errorf(codes.Aborted, "unable to fetch keyring: %s", err)
+	}
+
+	if keyring, err = n.referredKeyring(ctx, req.VolumeContext, keyring); err != nil {
+		return nil, err
+	}
+
+	if pod != nil {
+		if keyring, err = n.podKeyring(ctx, pod, keyring); err != nil {
+			return nil, err
+		}
+	}
+
+	return serviceAccountTokenKeyring(req, keyring)
 }
 
-func (n NodeServer) NodeStageVolume(ctx context.Context, _ *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+// applyVolumeAttributesClass overrides attributes of the PV with parameters of its VolumeAttributesClass, which may
+// be changed since the PV is provisioned.
+func (n NodeServer) applyVolumeAttributesClass(
+	ctx context.Context, req *csi.NodePublishVolumeRequest, pvName string,
+) error {
+	if n.volumeAttributesClasses == nil {
+		return nil
+	}
+
+	params, err := n.volumeAttributesClasses.ParametersOf(ctx, pvName)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "unable to fetch the VolumeAttributesClass of PV %s: %s", pvName, err)
+	}
+
+	attributes, err := parseMutableParameters(params)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid VolumeAttributesClass of PV %s: %s", pvName, err)
+	}
+
+	if len(attributes) > 0 && req.VolumeContext == nil {
+		req.VolumeContext = map[string]string{}
+	}
+
+	for k, v := range attributes {
+		klog.V(2).Infof("override attribute %s of volume %q with %q of its VolumeAttributesClass", k, req.VolumeId, v)
+		req.VolumeContext[k] = v
+	}
+
+	return nil
 }
 
-func (n NodeServer) NodeUnstageVolume(ctx context.Context, _ *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+// defaultPrePullTimeout bounds pre-pulls in sync mode, where pulls have no deadline unless pullTimeout is set.
+const defaultPrePullTimeout = 10 * time.Minute
+
+// PrePull pulls the image of a PV attached to this node in background, so that the image is likely present
+// once the PV is published. Images are pulled with credentials of the driver and the secret referred by the
+// volume attributes, since node stage secrets are only passed to NodeStageVolume.
+func (n NodeServer) PrePull(source *corev1.CSIPersistentVolumeSource) {
+	image := volume.Image(source.VolumeHandle, source.VolumeAttributes)
+	namedRef, err := reference.ParseDockerRef(image)
+	if err != nil {
+		klog.Errorf("unable to normalize image %q of volume %q: %s", image, source.VolumeHandle, err)
+		return
+	}
+
+	pullTimeout, err := n.pullTimeout(source.VolumeAttributes)
+	if err != nil {
+		klog.Errorf("unable to pre-pull image %q of volume %q: %s", image, source.VolumeHandle, err)
+		return
+	}
+
+	if !n.background.start() {
+		klog.Infof("skip pre-pulling image %q of volume %q since the driver is shutting down", image,
+			source.VolumeHandle)
+		return
+	}
+
+	go func() {
+		defer n.background.done()
+		ctx, cancel := context.WithTimeout(context.Background(), cmp.Or(pullTimeout, defaultPrePullTimeout))
+		defer cancel()
+
+		keyring, err := n.secretStore.GetDockerKeyring(ctx, nil)
+		if err != nil {
+			klog.Errorf("unable to fetch keyring to pre-pull image %q: %s", image, err)
+			return
+		}
+
+		if keyring, err = n.referredKeyring(ctx, source.VolumeAttributes, keyring); err != nil {
+			klog.Errorf("unable to fetch keyring to pre-pull image %q: %s", image, err)
+			return
+		}
+
+		klog.Infof("pre-pull image %q of attached volume %q", image, source.VolumeHandle)
+		if err = n.pullImage(ctx, image, namedRef, keyring, false, pullTimeout,
+			pullRequester(source.VolumeHandle, nil)); err != nil {
+			klog.Errorf("unable to pre-pull image %q: %s", image, err)
+			metrics.OperationErrorsCount.WithLabelValues("pre-pull").Inc()
+		}
+	}()
 }
 
-func (n NodeServer) NodeExpandVolume(ctx context.Context, _ *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+// pullTimeout returns the deadline of pulls of the volume, which is the volume attribute pullTimeout if set, or
+// --async-pull-timeout otherwise. 0 means pulls are only bounded by requests.
+func (n NodeServer) pullTimeout(volumeContext map[string]string) (time.Duration, error) {
+	v := volumeContext[ctxKeyPullTimeout]
+	if v == "" {
+		return time.Duration(n.asyncImagePullTimeout.Load()), nil
+	}
+
+	timeout, err := time.ParseDuration(v)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid %s %q, must be a positive duration, e.g. 20m", ctxKeyPullTimeout, v)
+	}
+
+	return timeout, nil
 }
 
-func (n NodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
-	nodeID := n.driver.GetNodeID()
-	return &csi.NodeGetInfoResponse{
-		NodeId: nodeID,
-		AccessibleTopology: &csi.Topology{
-			Segments: map[string]string{
-				"kubernetes.io/hostname": nodeID,
-			},
-		},
-	}, nil
+// pullRequester identifies the volume and the pod in volumeContext, if any, pulls are audited for.
+func pullRequester(volumeId string, volumeContext map[string]string) remoteimage.PullRequester {
+	return remoteimage.PullRequester{
+		VolumeID:     volumeId,
+		PodNamespace: volumeContext[ctxKeyPodNamespace],
+		PodName:      volumeContext[ctxKeyPodName],
+		PodUID:       volumeContext[ctxKeyPodUID],
+	}
 }
 
-func (n NodeServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
-	return &csi.NodeGetCapabilitiesResponse{
-		Capabilities: []*csi.NodeServiceCapability{
-			{
-				Type: &csi.NodeServiceCapability_Rpc{
-					Rpc: &csi.NodeServiceCapability_RPC{
-						Type: csi.NodeServiceCapability_RPC_UNKNOWN,
-					},
-				},
+// pullImage pulls the image if it doesn't exist on the node or pullAlways is set. The pull is given timeout if it
+// is not 0. In async mode, the pull continues in background after the request expires until timeout, and retries
+// of the request wait for the same pull. Pulls are audited as requested by requester. Images of registries the
+// registry rules refuse, and those referred by tags if digests are required, fail with PermissionDenied.
+func (n NodeServer) pullImage(
+	ctx context.Context, image string, namedRef reference.Named, keyring secret.DockerKeyring, pullAlways bool,
+	timeout time.Duration, requester remoteimage.PullRequester,
+) error {
+	// Images are refused even if they exist, since they may be pulled before the rules change, or by other clients
+	// of the runtime.
+	if n.registries != nil {
+		if err := n.registries.check(namedRef); err != nil {
+			metrics.OperationErrorsCount.WithLabelValues("registry-denied").Inc()
+			return status.Error(codes.PermissionDenied, err.Error())
+		}
+	}
+
+	if _, isDigested := namedRef.(reference.Digested); n.requireDigests && !isDigested {
+		metrics.OperationErrorsCount.WithLabelValues("digest-required").Inc()
+		return status.Errorf(codes.PermissionDenied, "image %q must be referred by digest, e.g. %s@sha256:<digest>",
+			image, namedRef.Name())
+	}
+
+	// NOTE: we are relying on n.mounter.ImageExists() to return false when
+	//      a first-time pull is in progress, else this logic may not be
+	//      correct. should test this.
+	if pullAlways || !n.mounter.ImageExists(ctx, namedRef) {
+		klog.FromContext(ctx).Info("pull image", "image", image, "pullAlways", pullAlways)
+		puller := remoteimage.NewAuditedPuller(n.imageSvc, namedRef, keyring, n.pullRuntimeHandler, n.auditLog,
+			requester)
+		start := time.Now()
+
+		if n.asyncImagePuller != nil {
+			session, err := n.asyncImagePuller.StartPull(image, puller, timeout)
+			if err != nil {
+				metrics.OperationErrorsCount.WithLabelValues("pull-async-start").Inc()
+				return status.Errorf(codes.Aborted, "unable to pull image %q: %s", image, err)
+			}
+			if err := n.asyncImagePuller.WaitForPull(session, ctx); err != nil {
+				metrics.OperationErrorsCount.WithLabelValues("pull-async-wait").Inc()
+				return status.Errorf(codes.Aborted, "unable to pull image %q: %s", image, err)
+			}
+		} else {
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+
+			if err := puller.Pull(ctx); err != nil {
+				metrics.OperationErrorsCount.WithLabelValues("pull-sync-call").Inc()
+				return status.Errorf(codes.Aborted, "unable to pull image %q: %s", image, err)
+			}
+		}
+
+		n.warnSlowPull(ctx, namedRef, puller, time.Since(start), requester)
+	}
+
+	n.localImages.add(namedRef.String())
+	return nil
+}
+
+// authorizeDecryption refuses to mount encrypted images unless the keys can decrypt them.
+func (n NodeServer) authorizeDecryption(ctx context.Context, image reference.Named, keys map[string][]byte) error {
+	if n.decryptionKeys == nil {
+		return nil
+	}
+
+	metadata, err := n.mounter.InspectImage(ctx, image)
+	if err != nil {
+		return status.Errorf(codes.Internal, "unable to inspect image %q: %s", image, err)
+	}
+
+	if !metadata.Encrypted {
+		return nil
+	}
+
+	var manifest ocispec.Manifest
+	if err = json.Unmarshal(metadata.Manifest, &manifest); err != nil {
+		return status.Errorf(codes.Internal, "invalid manifest of image %q: %s", image, err)
+	}
+
+	if err = secret.AuthorizeDecryption(manifest.Layers, keys); err != nil {
+		metrics.OperationErrorsCount.WithLabelValues("decryption-keys").Inc()
+		return status.Errorf(codes.PermissionDenied, "unable to decrypt image %q: %s", image, err)
+	}
+
+	return nil
+}
+
+// checkImagePolicies refuses to pull images violating ClusterImageVolumePolicies applying to the namespace of the pod
+// of the volume. Images of policies requiring signatures must be enforced by the trust policy of their repositories,
+// which verifySignatures checks once they are pulled.
+func (n NodeServer) checkImagePolicies(volumeContext map[string]string, image string, namedRef reference.Named) error {
+	if n.imagePolicies == nil {
+		return nil
+	}
+
+	requirements, err := n.imagePolicies.Check(volumeContext[ctxKeyPodNamespace], namedRef)
+	if err == nil && len(requirements.SignaturesRequiredBy) > 0 &&
+		(n.notation == nil || !n.notation.Enforces(namedRef)) {
+		err = fmt.Errorf("%s %s requires signatures of image %q, which no enforcing trust policy verifies",
+			watcher.ClusterImageVolumePolicyKind, requirements.SignaturesRequiredBy[0], image)
+	}
+
+	if err != nil {
+		klog.Warningf("refused to mount image %q for pods in namespace %q: %s", image,
+			volumeContext[ctxKeyPodNamespace], err)
+		metrics.OperationErrorsCount.WithLabelValues("image-policy").Inc()
+		n.recordMountFailure(volumeContext, image, ReasonImagePolicyViolation, err)
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	return nil
+}
+
+// verifySignatures refuses to mount images without Notary Project signatures verified by the trust policy of their
+// repositories.
+func (n NodeServer) verifySignatures(ctx context.Context, image reference.Named, keyring secret.DockerKeyring) error {
+	if n.notation == nil {
+		return nil
+	}
+
+	subject, err := remoteimage.LocalDigest(ctx, n.imageSvc, image)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	if err = n.notation.Verify(ctx, image, subject, keyring); err != nil {
+		metrics.OperationErrorsCount.WithLabelValues("verify-signatures").Inc()
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	return nil
+}
+
+// verifyAttestations refuses to mount images without attestations required by the volume attribute
+// requiredAttestations and ClusterImageVolumePolicies applying to the volume.
+func (n NodeServer) verifyAttestations(
+	ctx context.Context, volumeContext map[string]string, image reference.Named, keyring secret.DockerKeyring,
+) error {
+	required := volume.SplitList(volumeContext[ctxKeyRequiredAttestations])
+	if n.imagePolicies != nil {
+		requirements, err := n.imagePolicies.Check(volumeContext[ctxKeyPodNamespace], image)
+		if err != nil {
+			return status.Error(codes.PermissionDenied, err.Error())
+		}
+
+		for _, attestation := range requirements.Attestations {
+			if !slices.Contains(required, attestation) {
+				required = append(required, attestation)
+			}
+		}
+	}
+
+	if len(required) == 0 {
+		return nil
+	}
+
+	subject, err := remoteimage.LocalDigest(ctx, n.imageSvc, image)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	if err = remoteimage.VerifyAttestations(ctx, image, subject, keyring, required); err != nil {
+		metrics.OperationErrorsCount.WithLabelValues("verify-attestations").Inc()
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	return nil
+}
+
+// fetchReferrers downloads referrers of the local image and returns the directory to merge into the volume.
+func (n NodeServer) fetchReferrers(
+	ctx context.Context, image reference.Named, keyring secret.DockerKeyring,
+) (string, error) {
+	if n.referrersDir == "" {
+		return "", status.Error(codes.FailedPrecondition, "referrers are not enabled")
+	}
+
+	subject, err := remoteimage.LocalDigest(ctx, n.imageSvc, image)
+	if err != nil {
+		return "", status.Error(codes.Internal, err.Error())
+	}
+
+	dir := filepath.Join(n.referrersDir, fmt.Sprintf("%x", sha256.Sum256([]byte(image.Name()+"@"+subject.String()))))
+	if err = remoteimage.FetchReferrers(ctx, image, subject, keyring, dir); err != nil {
+		metrics.OperationErrorsCount.WithLabelValues("fetch-referrers").Inc()
+		return "", status.Errorf(codes.Unavailable, "unable to fetch referrers of image %q: %s", image, err)
+	}
+
+	return dir, nil
+}
+
+// checkPlacement fails if the volume requires a platform or a backend other than those of the node.
+func (n NodeServer) checkPlacement(volumeContext map[string]string) error {
+	if platform := volumeContext[ctxKeyPlatform]; platform != "" {
+		p, err := platforms.Parse(platform)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid %s %q: %s", ctxKeyPlatform, platform, err)
+		}
+
+		if !platforms.Default().Match(p) {
+			return status.Errorf(codes.FailedPrecondition, "platform %q is not supported by the node, which is %q",
+				platform, platforms.Format(platforms.DefaultSpec()))
+		}
+	}
+
+	if backend := volumeContext[ctxKeyBackend]; backend != "" && n.backend != "" && backend != n.backend {
+		return status.Errorf(codes.FailedPrecondition, "backend %q is required, but the node uses %q",
+			backend, n.backend)
+	}
+
+	return nil
+}
+
+// referredKeyring adds credentials of the image pull secret referred by the volume attributes secret and
+// secretNamespace to the keyring. Ephemeral volumes can only refer to secrets in the namespace of their pods.
+func (n NodeServer) referredKeyring(
+	ctx context.Context, volumeContext map[string]string, keyring secret.DockerKeyring,
+) (secret.DockerKeyring, error) {
+	name := volumeContext[ctxKeySecret]
+	if name == "" {
+		return keyring, nil
+	}
+
+	if n.kubeClient == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "secrets referred by volume attributes are not enabled")
+	}
+
+	podNamespace := volumeContext[ctxKeyPodNamespace]
+	namespace := volumeContext[ctxKeySecretNamespace]
+	if namespace == "" {
+		namespace = podNamespace
+	}
+
+	if isEphemeralVolume(volumeContext) && namespace != podNamespace {
+		return nil, status.Errorf(codes.PermissionDenied,
+			"ephemeral volumes can only refer to secrets in namespace %q of their pods", podNamespace)
+	}
+
+	referred, err := secret.FetchKeyring(ctx, n.kubeClient, namespace, name)
+	if err != nil {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+
+	return secret.UnionDockerKeyring{secret.WithSource(referred, secret.SecretSource(namespace, name)), keyring}, nil
+}
+
+// blockStagingDevice is the device file of a staged block volume in its staging directory.
+const blockStagingDevice = "device"
+
+// stagedTarget returns the path a volume is staged to in the staging directory kubelet passes.
+func stagedTarget(stagingPath string, block bool) backend.MountTarget {
+	if block {
+		return backend.MountTarget(filepath.Join(stagingPath, blockStagingDevice))
+	}
+
+	return backend.MountTarget(stagingPath)
+}
+
+// stagedVolumeName returns the name of the PV staged at the staging directory kubelet passes. Staging directories of
+// filesystem volumes are plugins/kubernetes.io/csi/pv/<PV>/globalmount in older versions of kubelet, and named by
+// hashes of volume handles in newer ones, which record PV names in vol_data.json next to globalmount. Those of block
+// volumes are plugins/kubernetes.io/csi/volumeDevices/staging/<PV>.
+func stagedVolumeName(stagingPath string) string {
+	if filepath.Base(stagingPath) != "globalmount" {
+		return filepath.Base(stagingPath)
+	}
+
+	dir := filepath.Dir(stagingPath)
+	var volData struct {
+		SpecVolID string `json:"specVolID"`
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "vol_data.json")); err == nil &&
+		json.Unmarshal(data, &volData) == nil && volData.SpecVolID != "" {
+		return volData.SpecVolID
+	}
+
+	return filepath.Base(dir)
+}
+
+// createTarget creates the target path kubelet passes, which is a file for block volumes and
+// a directory otherwise.
+func createTarget(target string, block bool) error {
+	if !block {
+		return os.MkdirAll(target, 0o755)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0o750); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// blockFormat returns the filesystem the image rootfs of a block volume is packed into, or disk for the VM disk.
+func blockFormat(volumeContext map[string]string) (string, error) {
+	switch format := volumeContext[ctxKeyBlockFormat]; format {
+	case "", backend.FSTypeSquashfs:
+		return backend.FSTypeSquashfs, nil
+	case backend.FSTypeEROFS:
+		return backend.FSTypeEROFS, nil
+	case backend.BlockFormatDisk:
+		return backend.BlockFormatDisk, nil
+	default:
+		return "", fmt.Errorf("unsupported %s %q", ctxKeyBlockFormat, format)
+	}
+}
+
+// seLinuxMountContext returns the SELinux context kubelet passes via the context mount flag, which
+// is set only if the CSIDriver enables seLinuxMount and the pod has an SELinux context.
+func seLinuxMountContext(capability *csi.VolumeCapability) string {
+	for _, flag := range capability.GetMount().GetMountFlags() {
+		if value, found := strings.CutPrefix(flag, "context="); found {
+			return strings.Trim(value, `"`)
+		}
+	}
+
+	return ""
+}
+
+// mountFlagPairs maps the supported per-mount flags to their opposites.
+var mountFlagPairs = map[string]string{
+	"ro":     "rw",
+	"rw":     "ro",
+	"noexec": "exec",
+	"exec":   "noexec",
+	"nosuid": "suid",
+	"suid":   "nosuid",
+	"nodev":  "dev",
+	"dev":    "nodev",
+}
+
+// volumeMountFlags collects per-mount flags from the mountFlags of the capability and the mountOptions
+// volume attribute. It returns the flags to be applied to the mount besides ro, and whether ro is requested.
+func volumeMountFlags(
+	capability *csi.VolumeCapability, volumeContext map[string]string, readOnly bool,
+) (flags []string, ro bool, err error) {
+	var requested []string
+	for _, flag := range capability.GetMount().GetMountFlags() {
+		// The SELinux context is handled separately.
+		if !strings.HasPrefix(flag, "context=") {
+			requested = append(requested, strings.Split(flag, ",")...)
+		}
+	}
+	requested = append(requested, strings.Split(volumeContext[ctxKeyMountOptions], ",")...)
+
+	set := make(map[string]struct{})
+	for _, flag := range requested {
+		if flag = strings.TrimSpace(flag); flag == "" {
+			continue
+		}
+
+		opposite, supported := mountFlagPairs[flag]
+		if !supported {
+			return nil, false, fmt.Errorf("unsupported mount option %q", flag)
+		}
+
+		if _, conflict := set[opposite]; conflict {
+			return nil, false, fmt.Errorf("mount options %q and %q conflict", flag, opposite)
+		}
+
+		if _, found := set[flag]; found {
+			continue
+		}
+
+		set[flag] = struct{}{}
+		switch flag {
+		case "ro":
+			ro = true
+		case "rw":
+			if readOnly {
+				return nil, false, fmt.Errorf("mount option %q conflicts with the read-only volume", flag)
+			}
+		case "noexec", "nosuid", "nodev":
+			flags = append(flags, flag)
+		}
+	}
+
+	return flags, ro, nil
+}
+
+// validateVolumeCapability checks whether a volume with the given context can be published with
+// the capability. Read-write volumes get a private writable layer per publication, so writes are
+// never shared and multi-writer access modes can't be satisfied. Block volumes are always read-only.
+func validateVolumeCapability(capability *csi.VolumeCapability, volumeContext map[string]string) error {
+	if capability == nil {
+		return fmt.Errorf("VolumeCapability is missing")
+	}
+
+	if capability.AccessMode == nil {
+		return fmt.Errorf("AccessMode is missing")
+	}
+
+	persistentScratch := strings.ToLower(volumeContext[ctxKeyPersistentScratch]) == "true"
+	ephemeral := volumeContext[ctxKeyEphemeralVolume] == "true"
+	if ephemeral && persistentScratch {
+		return fmt.Errorf("%s is not supported by ephemeral volumes", ctxKeyPersistentScratch)
+	}
+
+	if len(volumeContext[ctxKeyCloneSource]) > 0 && !persistentScratch {
+		return fmt.Errorf("%s requires %s", ctxKeyCloneSource, ctxKeyPersistentScratch)
+	}
+
+	if snapshot := volumeContext[ctxKeySnapshotSource]; len(snapshot) > 0 {
+		if !persistentScratch {
+			return fmt.Errorf("%s requires %s", ctxKeySnapshotSource, ctxKeyPersistentScratch)
+		}
+
+		if filepath.Base(snapshot) != snapshot {
+			return fmt.Errorf("invalid %s %q", ctxKeySnapshotSource, snapshot)
+		}
+
+		// Snapshots are only saved on the node holding the writable layer of their source volumes.
+		switch mode := capability.AccessMode.Mode; mode {
+		case csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+			csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
+			csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER:
+			return fmt.Errorf("AccessMode %s is not supported by volumes restored from snapshots, which are only "+
+				"accessible from the node holding the snapshot", mode)
+		}
+	}
+
+	_, isBlock := capability.AccessType.(*csi.VolumeCapability_Block)
+	if !isBlock && strings.ToLower(volumeContext[ctxKeyVerity]) == "true" {
+		return fmt.Errorf("%s is only supported by block volumes", ctxKeyVerity)
+	}
+
+	if isBlock {
+		if persistentScratch {
+			return fmt.Errorf("%s is not supported by block volumes", ctxKeyPersistentScratch)
+		}
+
+		if _, err := blockFormat(volumeContext); err != nil {
+			return err
+		}
+
+		switch mode := capability.AccessMode.Mode; mode {
+		case csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+			csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY:
+			return nil
+		default:
+			return fmt.Errorf("AccessMode %s is not supported since block volumes are read-only", mode)
+		}
+	}
+
+	switch mode := capability.AccessMode.Mode; mode {
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY:
+		return nil
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER:
+		if !ephemeral && !persistentScratch {
+			return fmt.Errorf("AccessMode %s of PV requires %s, otherwise only ReadOnlyMany or ReadOnlyOnce are supported",
+				mode, ctxKeyPersistentScratch)
+		}
+		return nil
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER:
+		return fmt.Errorf("AccessMode %s is not supported since writes to image volumes are never shared", mode)
+	default:
+		return fmt.Errorf("unknown AccessMode %s", mode)
+	}
+}
+
+// imageFSType returns the filesystem image layers should be presented as. The volume attribute takes
+// precedence over the fsType of the volume capability. Since provisioners may fill in a default fsType,
+// unknown values in the capability are ignored rather than rejected.
+func imageFSType(req *csi.NodePublishVolumeRequest) (string, error) {
+	if fsType, found := req.VolumeContext[ctxKeyFSType]; found {
+		switch fsType {
+		case "", "overlay":
+			return "", nil
+		case backend.FSTypeEROFS:
+			return backend.FSTypeEROFS, nil
+		default:
+			return "", fmt.Errorf("unsupported %s %q", ctxKeyFSType, fsType)
+		}
+	}
+
+	if req.VolumeCapability.GetMount().GetFsType() == backend.FSTypeEROFS {
+		return backend.FSTypeEROFS, nil
+	}
+
+	return "", nil
+}
+
+// writableQuota parses the size limit of the writable layer from volume attributes.
+func writableQuota(volumeContext map[string]string) (int64, error) {
+	v, found := volumeContext[ctxKeyQuota]
+	if !found || len(v) == 0 {
+		return 0, nil
+	}
+
+	q, err := resource.ParseQuantity(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %s", ctxKeyQuota, v, err)
+	}
+
+	if q.Sign() <= 0 {
+		return 0, fmt.Errorf("%s must be positive, got %q", ctxKeyQuota, v)
+	}
+
+	return q.Value(), nil
+}
+
+// RemoveScratchOfPV removes the persistent writable layer of a deleted PV from this node, unless remaining PVs share
+// its volume handle, which keys the layer.
+func (n NodeServer) RemoveScratchOfPV(
+	source *corev1.CSIPersistentVolumeSource, remaining []*corev1.CSIPersistentVolumeSource,
+) {
+	if strings.ToLower(source.VolumeAttributes[ctxKeyPersistentScratch]) != "true" {
+		return
+	}
+
+	if slices.ContainsFunc(remaining, func(other *corev1.CSIPersistentVolumeSource) bool {
+		return other.VolumeHandle == source.VolumeHandle
+	}) {
+		klog.V(2).Infof("the persistent scratch layer of volume %q is still used by other PVs", source.VolumeHandle)
+		return
+	}
+
+	if err := n.mounter.RemoveScratch(context.TODO(), source.VolumeHandle); err != nil {
+		klog.Errorf("unable to remove the persistent scratch layer of volume %q: %s", source.VolumeHandle, err)
+		metrics.OperationErrorsCount.WithLabelValues("remove-scratch").Inc()
+	}
+}
+
+// snapshotPath returns the path of the tarball of a volume snapshot on this node.
+func (n NodeServer) snapshotPath(snapshotID string) string {
+	return filepath.Join(n.snapshotsDir, snapshotID+".tar")
+}
+
+// SaveSnapshot saves the writable layer of the source volume of a VolumeSnapshotContent if the volume has
+// one on this node, then marks the snapshot saved by this node.
+func (n NodeServer) SaveSnapshot(content *watcher.SnapshotContent) {
+	if content.Node != "" || content.VolumeHandle == "" {
+		return
+	}
+
+	saver, ok := n.mounter.(backend.ScratchSaver)
+	if !ok {
+		return
+	}
+
+	// Snapshots skipped are saved by the next start of the driver since they are not marked saved.
+	if !n.background.start() {
+		return
+	}
+	defer n.background.done()
+
+	ctx := context.TODO()
+	path := n.snapshotPath(content.Name)
+	if _, err := os.Stat(path); err != nil {
+		err = saver.SaveScratch(ctx, content.VolumeHandle, path)
+		if errors.Is(err, backend.ErrNoScratch) {
+			return
+		}
+
+		if err != nil {
+			klog.Errorf("unable to save snapshot %s of volume %q: %s", content.Name, content.VolumeHandle, err)
+			metrics.OperationErrorsCount.WithLabelValues("save-snapshot").Inc()
+			return
+		}
+	}
+
+	pushedImage, err := n.pushSnapshot(ctx, content)
+	if err != nil {
+		klog.Errorf("unable to push snapshot %s of volume %q: %s", content.Name, content.VolumeHandle, err)
+		metrics.OperationErrorsCount.WithLabelValues("push-snapshot").Inc()
+		return
+	}
+
+	if err := n.snapshotContents.MarkSaved(ctx, content.Name, n.driver.GetNodeID(), pushedImage); err != nil {
+		klog.Errorf("unable to mark snapshot %s saved: %s", content.Name, err)
+		return
+	}
+
+	klog.Infof("saved snapshot %s of volume %q", content.Name, content.VolumeHandle)
+}
+
+// pushSnapshot commits the writable layer of the source volume as a new image and pushes it with credentials
+// of the driver, if the parameter pushImage of the VolumeSnapshotClass is set. The pushed image is returned.
+func (n NodeServer) pushSnapshot(ctx context.Context, content *watcher.SnapshotContent) (string, error) {
+	if content.ClassName == "" {
+		return "", nil
+	}
+
+	params, err := n.snapshotContents.ClassParameters(ctx, content.ClassName)
+	if err != nil {
+		return "", fmt.Errorf("unable to get VolumeSnapshotClass %s: %w", content.ClassName, err)
+	}
+
+	if params[paramPushImage] == "" {
+		return "", nil
+	}
+
+	target, err := reference.ParseNormalizedNamed(params[paramPushImage])
+	if err != nil {
+		return "", fmt.Errorf("invalid %s %q: %w", paramPushImage, params[paramPushImage], err)
+	}
+
+	committer, ok := n.mounter.(backend.VolumeCommitter)
+	if !ok {
+		return "", fmt.Errorf("the backend doesn't support pushing snapshots")
+	}
+
+	keyring, err := n.secretStore.GetDockerKeyring(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to fetch keyring: %w", err)
+	}
+
+	target = reference.TagNameOnly(target)
+	dgst, err := committer.CommitVolume(ctx, content.VolumeHandle, target, remoteimage.NewResolver(target, keyring))
+	if err != nil {
+		return "", err
+	}
+
+	return target.Name() + "@" + dgst.String(), nil
+}
+
+// RemoveSnapshot removes the tarball of a deleted VolumeSnapshotContent from this node.
+func (n NodeServer) RemoveSnapshot(content *watcher.SnapshotContent) {
+	if err := os.Remove(n.snapshotPath(content.Name)); err != nil && !os.IsNotExist(err) {
+		klog.Errorf("unable to remove snapshot %s: %s", content.Name, err)
+		metrics.OperationErrorsCount.WithLabelValues("remove-snapshot").Inc()
+	}
+}
+
+func (n NodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (resp *csi.NodeUnpublishVolumeResponse, err error) {
+	defer metrics.InFlightOperations.Start(ctx, metrics.OperationUnpublish)()
+	logger := klog.FromContext(ctx)
+	logger.V(4).Info("NodeUnpublishVolume: unmount request", "request", protosanitizer.StripSecrets(req))
+
+	// Validate required fields
+	if len(req.VolumeId) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "VolumeId is missing")
+	}
+
+	if len(req.TargetPath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "TargetPath is missing")
+	}
+
+	if _, err = n.inFlight.start(ctx, opUnpublish, req.VolumeId, req.TargetPath); err != nil {
+		return nil, err
+	}
+	defer n.inFlight.finish(req.VolumeId, req.TargetPath)
+
+	if err = n.unmountVolume(ctx, req.VolumeId, req.TargetPath); err != nil {
+		return nil, err
+	}
+
+	metrics.ForgetVolumeDiskUsage(req.VolumeId)
+	logger.V(4).Info("NodeUnpublishVolume: volume has been unmounted successfully")
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
+// unmountVolume unmounts the volume at the target if it is a mount point.
+func (n NodeServer) unmountVolume(ctx context.Context, volumeId, target string) error {
+	// Check if it's a mount point
+	mnt, err := k8smount.New("").IsMountPoint(target)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Path doesn't exist, volume is already unmounted
+			klog.V(4).Infof("target path %s does not exist, assuming volume is already unmounted", target)
+			return nil
+		}
+		// Other errors should be reported
+		return status.Error(codes.Internal, fmt.Sprintf("failed to check if path %s is a mount point: %v", target, err))
+	}
+
+	// If not mounted, return success
+	if !mnt {
+		klog.V(4).Infof("%s is not a mount point, no unmount needed", target)
+		return nil
+	}
+
+	// Attempt to unmount
+	if err = n.mounter.Unmount(ctx, volumeId, backend.MountTarget(target)); err != nil {
+		metrics.OperationErrorsCount.WithLabelValues("unmount").Inc()
+		return status.Error(codes.Internal, fmt.Sprintf("failed to unmount volume at %s: %v", target, err))
+	}
+
+	return nil
+}
+
+// NodeStageVolume pulls and verifies images of the volume, and mounts it to the staging target, which all
+// publications of the volume on the node are bound to. Stage secrets of the PV are used to pull images.
+func (n NodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (resp *csi.NodeStageVolumeResponse, err error) {
+	defer metrics.InFlightOperations.Start(ctx, metrics.OperationStage)()
+	ctx, span := tracing.Start(ctx, "NodeStageVolume", tracing.AttrVolumeID.String(req.VolumeId))
+	defer func() { tracing.End(span, err) }()
+	klog.V(4).Infof("NodeStageVolume: stage request: %s", protosanitizer.StripSecrets(req))
+	if len(req.VolumeId) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "VolumeId is missing")
+	}
+
+	if len(req.StagingTargetPath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "StagingTargetPath is missing")
+	}
+
+	if len(req.VolumeContext) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "VolumeContext is missing")
+	}
+
+	if err = validateVolumeCapability(req.VolumeCapability, req.VolumeContext); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err = validateVolumeAttributes(req.VolumeId, req.VolumeContext); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// Staged volumes are mounted the same way as ephemeral volumes, except that readonly of publications only
+	// applies to their binds.
+	target := string(stagedTarget(req.StagingTargetPath, req.VolumeCapability.GetBlock() != nil))
+	mountReq := &csi.NodePublishVolumeRequest{
+		VolumeId:         req.VolumeId,
+		PublishContext:   req.PublishContext,
+		TargetPath:       target,
+		VolumeCapability: req.VolumeCapability,
+		Secrets:          req.Secrets,
+		VolumeContext:    req.VolumeContext,
+	}
+
+	// Volume IDs of static PVs are images rather than PV names.
+	if err = n.applyVolumeAttributesClass(ctx, mountReq, stagedVolumeName(req.StagingTargetPath)); err != nil {
+		return nil, err
+	}
+
+	interrupted, err := n.inFlight.start(ctx, opStage, req.VolumeId, target)
+	if err != nil {
+		return nil, err
+	}
+	defer n.inFlight.finish(req.VolumeId, target)
+
+	if _, err = n.mountVolume(ctx, mountReq, nil, interrupted); err != nil {
+		return nil, err
+	}
+
+	klog.V(4).Infof("NodeStageVolume: volume %q is staged at %q", req.VolumeId, target)
+	return &csi.NodeStageVolumeResponse{}, nil
+}
+
+// NodeUnstageVolume unmounts the staged volume, and releases its snapshots. Staged volumes can't be unstaged while
+// they are still published.
+func (n NodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+	defer metrics.InFlightOperations.Start(ctx, metrics.OperationUnstage)()
+	klog.V(4).Infof("NodeUnstageVolume: unstage request: %s", protosanitizer.StripSecrets(req))
+	if len(req.VolumeId) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "VolumeId is missing")
+	}
+
+	if len(req.StagingTargetPath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "StagingTargetPath is missing")
+	}
+
+	_, err := os.Stat(string(stagedTarget(req.StagingTargetPath, true)))
+	block := err == nil
+	target := stagedTarget(req.StagingTargetPath, block)
+	if _, err = n.inFlight.start(ctx, opUnstage, req.VolumeId, string(target)); err != nil {
+		return nil, err
+	}
+	defer n.inFlight.finish(req.VolumeId, string(target))
+
+	if err := n.unmountVolume(ctx, req.VolumeId, string(target)); err != nil {
+		return nil, err
+	}
+
+	// Kubelet only removes the staging directory, which must be empty.
+	if block {
+		if err := os.Remove(string(target)); err != nil && !os.IsNotExist(err) {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	return &csi.NodeUnstageVolumeResponse{}, nil
+}
+
+// NodeExpandVolume grows the quota of the writable layer of a read-write volume online.
+func (n NodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	if len(req.VolumeId) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "VolumeId is missing")
+	}
+
+	if len(req.VolumePath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "VolumePath is missing")
+	}
+
+	if req.GetVolumeCapability().GetBlock() != nil {
+		return nil, status.Error(codes.InvalidArgument, "block volumes can't be expanded")
+	}
+
+	size := req.GetCapacityRange().GetRequiredBytes()
+	if size <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "required bytes of CapacityRange are missing")
+	}
+
+	expander, ok := n.mounter.(backend.VolumeExpander)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "the backend doesn't support volume expansion")
+	}
+
+	if err := expander.ExpandVolume(ctx, backend.MountTarget(req.VolumePath), size); err != nil {
+		klog.Errorf("unable to expand volume %q at %q: %s", req.VolumeId, req.VolumePath, err)
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	return &csi.NodeExpandVolumeResponse{CapacityBytes: size}, nil
+}
+
+func (n NodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	nodeID := n.driver.GetNodeID()
+	return &csi.NodeGetInfoResponse{
+		NodeId:             nodeID,
+		MaxVolumesPerNode:  n.maxVolumes,
+		AccessibleTopology: nodeTopology(nodeID),
+	}, nil
+}
+
+func (n NodeServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
+	var capabilities []*csi.NodeServiceCapability
+	for _, rpc := range []csi.NodeServiceCapability_RPC_Type{
+		csi.NodeServiceCapability_RPC_UNKNOWN,
+		csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
+		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+		csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
+		csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
+	} {
+		capabilities = append(capabilities, &csi.NodeServiceCapability{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{
+					Type: rpc,
+				},
+			},
+		})
+	}
+
+	return &csi.NodeGetCapabilitiesResponse{
+		Capabilities: capabilities,
+	}, nil
+}
+
+// NodeGetVolumeStats reports the usage of a volume and whether its mount is still working.
+func (n NodeServer) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
+	if len(req.VolumeId) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "VolumeId is missing")
+	}
+
+	if len(req.VolumePath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "VolumePath is missing")
+	}
+
+	fi, err := os.Stat(req.VolumePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.NotFound, "volume path %s is not found", req.VolumePath)
+		}
+
+		// Paths of broken mounts, e.g. of crashed FUSE daemons, exist but can't be stat'ed.
+		klog.Errorf("volume %q at %q is abnormal: %s", req.VolumeId, req.VolumePath, err)
+		return &csi.NodeGetVolumeStatsResponse{
+			VolumeCondition: &csi.VolumeCondition{Abnormal: true, Message: err.Error()},
+		}, nil
+	}
+
+	if err := n.mounter.CheckMount(ctx, backend.MountTarget(req.VolumePath)); err != nil {
+		klog.Errorf("volume %q at %q is abnormal: %s", req.VolumeId, req.VolumePath, err)
+		return &csi.NodeGetVolumeStatsResponse{
+			VolumeCondition: &csi.VolumeCondition{Abnormal: true, Message: err.Error()},
+		}, nil
+	}
+
+	if fi != nil && fi.Mode()&os.ModeDevice != 0 {
+		return blockVolumeStats(req.VolumePath)
+	}
+
+	var statfs unix.Statfs_t
+	if err := unix.Statfs(req.VolumePath, &statfs); err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to stat filesystem of %s: %s", req.VolumePath, err)
+	}
+
+	blockSize := statfs.Bsize
+	used := int64(statfs.Blocks-statfs.Bfree) * blockSize
+	if usage, ok := n.volumeUsage(ctx, req.VolumeId, req.VolumePath); ok {
+		used = usage.Writable + usage.Image
+	}
+
+	return &csi.NodeGetVolumeStatsResponse{
+		Usage: []*csi.VolumeUsage{
+			{
+				Unit:      csi.VolumeUsage_BYTES,
+				Total:     int64(statfs.Blocks) * blockSize,
+				Available: int64(statfs.Bavail) * blockSize,
+				Used:      used,
+			},
+			{
+				Unit:      csi.VolumeUsage_INODES,
+				Total:     int64(statfs.Files),
+				Available: int64(statfs.Ffree),
+				Used:      int64(statfs.Files - statfs.Ffree),
+			},
+		},
+		VolumeCondition: &csi.VolumeCondition{Abnormal: false, Message: "volume is healthy"},
+	}, nil
+}
+
+// volumeUsage measures the snapshot chain of the volume at the target, and exports it, if the mounter can. Overlay
+// mounts report the usage of the filesystem of the snapshotter, so the snapshot chain is what the volume really uses.
+func (n NodeServer) volumeUsage(ctx context.Context, volumeId, target string) (backend.SnapshotUsage, bool) {
+	reporter, ok := n.mounter.(backend.VolumeUsageReporter)
+	if !ok {
+		return backend.SnapshotUsage{}, false
+	}
+
+	usage, err := reporter.VolumeUsage(ctx, backend.MountTarget(target))
+	if err != nil {
+		klog.FromContext(ctx).V(2).Info("Unable to measure the snapshots of the volume", "err", err)
+		return backend.SnapshotUsage{}, false
+	}
+
+	metrics.ObserveVolumeDiskUsage(volumeId, usage.Writable, usage.Image)
+	return usage, true
+}
+
+// blockVolumeStats reports the size of a block volume, which is the only usage available for devices.
+func blockVolumeStats(path string) (*csi.NodeGetVolumeStatsResponse, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to open device %s: %s", path, err)
+	}
+	defer f.Close()
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to get the size of device %s: %s", path, err)
+	}
+
+	return &csi.NodeGetVolumeStatsResponse{
+		Usage: []*csi.VolumeUsage{
+			{
+				Unit:  csi.VolumeUsage_BYTES,
+				Total: size,
 			},
 		},
+		VolumeCondition: &csi.VolumeCondition{Abnormal: false, Message: "volume is healthy"},
 	}, nil
 }
@@ -8,22 +8,40 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/distribution/reference"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
 	"github.com/warm-metal/container-image-csi-driver/pkg/backend/containerd"
 	"github.com/warm-metal/container-image-csi-driver/pkg/cri"
 	csicommon "github.com/warm-metal/container-image-csi-driver/pkg/csi-common"
+	fakeruntime "github.com/warm-metal/container-image-csi-driver/pkg/fake"
 	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
+	"github.com/warm-metal/container-image-csi-driver/pkg/remoteimage"
 	"github.com/warm-metal/container-image-csi-driver/pkg/secret"
 	"github.com/warm-metal/container-image-csi-driver/pkg/test/utils"
+	"github.com/warm-metal/container-image-csi-driver/pkg/watcher"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/wait"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 	"k8s.io/klog/v2"
 )
 
@@ -38,11 +56,10 @@ func TestNodePublishVolumeAsync(t *testing.T) {
 		Mounted:        make(map[string]bool),
 	}
 
-	driver := csicommon.NewCSIDriver(driverName, driverVersion, "fake-node")
-	assert.NotNil(t, driver)
-
 	asyncImagePulls := 15 * time.Minute //TODO: determine intended value for this in the context of this test
-	ns := NewNodeServer(driver, mounter, criClient, &testSecretStore{}, asyncImagePulls)
+	ns := newTestNodeServer(t, testNodeOptions{
+		images: criClient, mounter: mounter, asyncImagePullTimeout: asyncImagePulls,
+	})
 
 	// based on kubelet's csi mounter plugin code
 	// check https://github.com/kubernetes/kubernetes/blob/b06a31b87235784bad2858be62115049b6eb6bcd/pkg/volume/csi/csi_mounter.go#L111-L112
@@ -284,6 +301,74 @@ func TestNodePublishVolumeSync(t *testing.T) {
 	assert.ErrorContains(t, err, "not found")
 }
 
+// pullRecorder records images it pulled, so that the mock mounter sharing its images finds them, and counts pulls.
+type pullRecorder struct {
+	*utils.MockImageServiceClient
+	pulls atomic.Int32
+}
+
+func (c *pullRecorder) PullImage(
+	ctx context.Context, in *criapi.PullImageRequest, opts ...grpc.CallOption,
+) (*criapi.PullImageResponse, error) {
+	resp, err := c.MockImageServiceClient.PullImage(ctx, in, opts...)
+	if err == nil {
+		named, err := reference.ParseNormalizedNamed(in.GetImage().GetImage())
+		if err != nil {
+			return nil, err
+		}
+
+		c.PulledImages[named.Name()] = true
+		c.pulls.Add(1)
+	}
+
+	return resp, err
+}
+
+// TestNodePublishVolumeRetries publishes a volume without pull-always, whose first publication times out while the
+// image is pulled. Retries wait for the publication in progress, which gives up mounting, then mount the image it
+// pulled without pulling it again.
+func TestNodePublishVolumeRetries(t *testing.T) {
+	criClient := &pullRecorder{MockImageServiceClient: &utils.MockImageServiceClient{
+		PulledImages:  make(map[string]bool),
+		ImagePullTime: time.Second,
+	}}
+	mounter := &utils.MockMounter{
+		ImageSvcClient: *criClient.MockImageServiceClient,
+		Mounted:        make(map[string]bool),
+	}
+	ns := newTestNodeServer(t, testNodeOptions{images: criClient, mounter: mounter})
+
+	volId := "docker.io/library/redis:latest"
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:      volId,
+		TargetPath:    filepath.Join(t.TempDir(), "target"),
+		VolumeContext: map[string]string{"pod-name": "test-pod", "namespace": "test-namespace", "uid": "test-uid"},
+		VolumeCapability: &csi.VolumeCapability{
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY},
+		},
+	}
+
+	publish := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, err := ns.NodePublishVolume(ctx, req)
+		return err
+	}
+
+	published := make(chan error, 1)
+	go func() { published <- publish() }()
+	require.Eventually(t, func() bool { return len(ns.inFlight.list()) == 1 }, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(publish()))
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(<-published))
+	assert.False(t, mounter.Mounted[volId])
+	assert.EqualValues(t, 1, criClient.pulls.Load())
+
+	assert.NoError(t, publish())
+	assert.True(t, mounter.Mounted[volId])
+	assert.EqualValues(t, 1, criClient.pulls.Load())
+}
+
 // Check test/integration/node-server/README.md for how to run this test correctly
 func TestMetrics(t *testing.T) {
 	socketAddr := "unix:///run/containerd/containerd.sock"
@@ -294,14 +379,13 @@ func TestMetrics(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotNil(t, criClient)
 
-	mounter := containerd.NewMounter(addr.Path)
+	mounter := containerd.NewMounter(addr.Path, "", nil, nil)
 	assert.NotNil(t, mounter)
 
-	driver := csicommon.NewCSIDriver(driverName, driverVersion, "fake-node")
-	assert.NotNil(t, driver)
-
 	asyncImagePulls := 15 * time.Minute //TODO: determine intended value for this in the context of this test
-	ns := NewNodeServer(driver, mounter, criClient, &testSecretStore{}, asyncImagePulls)
+	ns := newTestNodeServer(t, testNodeOptions{
+		images: criClient, mounter: mounter, asyncImagePullTimeout: asyncImagePulls,
+	})
 
 	// based on kubelet's csi mounter plugin code
 	// check https://github.com/kubernetes/kubernetes/blob/b06a31b87235784bad2858be62115049b6eb6bcd/pkg/volume/csi/csi_mounter.go#L111-L112
@@ -322,7 +406,7 @@ func TestMetrics(t *testing.T) {
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
-		metrics.StartMetricsServer(metrics.RegisterMetrics(), 8080)
+		metrics.StartMetricsServer(metrics.RegisterMetrics(), 8080, nil, metrics.ServerOptions{})
 
 		server.Start(*endpoint,
 			nil,
@@ -456,3 +540,411 @@ type testSecretStore struct{}
 func (t *testSecretStore) GetDockerKeyring(ctx context.Context, secrets map[string]string) (secret.DockerKeyring, error) {
 	return secret.NewDockerKeyring(), nil
 }
+
+// testNodeOptions are what node servers of tests are created with. Fake images, and a fake mounter of them, are
+// used if images or mounter is not set.
+type testNodeOptions struct {
+	images                criapi.ImageServiceClient
+	mounter               backend.Mounter
+	asyncImagePullTimeout time.Duration
+}
+
+// newTestNodeServer creates the node server of node fake-node.
+func newTestNodeServer(t *testing.T, opts testNodeOptions) *NodeServer {
+	t.Helper()
+	if opts.images == nil {
+		opts.images = fakeruntime.NewImageService()
+	}
+
+	if opts.mounter == nil {
+		images, ok := opts.images.(*fakeruntime.ImageService)
+		require.True(t, ok, "fake mounters only mount fake images")
+		opts.mounter = fakeruntime.NewMounter(images)
+	}
+
+	driver := csicommon.NewCSIDriver(driverName, driverVersion, "fake-node")
+	require.NotNil(t, driver)
+	return NewNodeServer(driver, opts.mounter, opts.images, &testSecretStore{}, opts.asyncImagePullTimeout)
+}
+
+func TestReferredKeyringOfEphemeralVolumes(t *testing.T) {
+	client := fake.NewClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "pull-secret", Namespace: "default"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{
+				corev1.DockerConfigJsonKey: []byte(`{"auths":{"registry.example.com":{"username":"u","password":"p"}}}`),
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "pull-secret", Namespace: "other"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{
+				corev1.DockerConfigJsonKey: []byte(`{"auths":{"registry.example.com":{"username":"u","password":"p"}}}`),
+			},
+		},
+	)
+
+	ns := NodeServer{kubeClient: client}
+	volumeContext := map[string]string{
+		ctxKeyImage:           "registry.example.com/app:latest",
+		ctxKeySecret:          "pull-secret",
+		ctxKeyEphemeralVolume: "true",
+		ctxKeyPodNamespace:    "default",
+	}
+
+	keyring, err := ns.referredKeyring(context.Background(), volumeContext, secret.NewDockerKeyring())
+	assert.NoError(t, err)
+	authConfigs, found := keyring.Lookup("registry.example.com/app")
+	assert.True(t, found)
+	assert.Equal(t, "u", authConfigs[0].Username)
+
+	// Ephemeral volumes can't refer to secrets in other namespaces.
+	volumeContext[ctxKeySecretNamespace] = "other"
+	_, err = ns.referredKeyring(context.Background(), volumeContext, secret.NewDockerKeyring())
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// PVs are created by admins, so they can.
+	delete(volumeContext, ctxKeyEphemeralVolume)
+	_, err = ns.referredKeyring(context.Background(), volumeContext, secret.NewDockerKeyring())
+	assert.NoError(t, err)
+}
+
+func TestImageVolumePolicies(t *testing.T) {
+	policy := func(name string, spec map[string]interface{}) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "container-image.csi.k8s.io/v1alpha1",
+			"kind":       watcher.ClusterImageVolumePolicyKind,
+			"metadata":   map[string]interface{}{"name": name},
+			"spec":       spec,
+		}}
+	}
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{{
+			Group: "container-image.csi.k8s.io", Version: "v1alpha1", Resource: "clusterimagevolumepolicies",
+		}: "ClusterImageVolumePolicyList"},
+		policy("registries", map[string]interface{}{"allowedRegistries": []interface{}{"ghcr.io/org"}}),
+		policy("prod", map[string]interface{}{
+			"requireDigests":    true,
+			"requireSignatures": true,
+			"namespaceSelector": map[string]interface{}{"matchLabels": map[string]interface{}{"env": "prod"}},
+		}),
+	)
+	client := fake.NewClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "prod", Labels: map[string]string{"env": "prod"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "dev"}},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	policies, err := watcher.WatchImageVolumePolicies(ctx, dynamicClient, client, time.Minute)
+	assert.NoError(t, err)
+
+	ns := NodeServer{imagePolicies: policies}
+	check := func(namespace, image string) error {
+		return ns.checkImagePolicies(map[string]string{ctxKeyPodNamespace: namespace}, image,
+			mustParseDockerRef(t, image))
+	}
+
+	assert.NoError(t, check("dev", "ghcr.io/org/app:v1"))
+	assert.Equal(t, codes.PermissionDenied, status.Code(check("dev", "docker.io/library/busybox:latest")))
+	assert.Equal(t, codes.PermissionDenied, status.Code(check("prod", "ghcr.io/org/app:v1")))
+
+	// Digests are enforced in prod, then signatures, which no trust policy verifies.
+	digested := "ghcr.io/org/app@sha256:" + strings.Repeat("a", 64)
+	err = check("prod", digested)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Contains(t, err.Error(), "requires signatures")
+
+	// Pods of unknown namespaces are subject to all policies.
+	assert.Equal(t, codes.PermissionDenied, status.Code(check("", "ghcr.io/org/app:v1")))
+}
+
+func TestRequireDigests(t *testing.T) {
+	ns := newTestNodeServer(t, testNodeOptions{})
+	ns.requireDigests = true
+
+	pull := func(image string) error {
+		return ns.pullImage(context.Background(), image, mustParseDockerRef(t, image), secret.NewDockerKeyring(),
+			false, 0, remoteimage.PullRequester{})
+	}
+
+	err := pull("redis:latest")
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Contains(t, err.Error(), "docker.io/library/redis@sha256:<digest>")
+	assert.Equal(t, codes.PermissionDenied, status.Code(pull("redis")))
+	assert.NoError(t, pull("redis@sha256:"+strings.Repeat("a", 64)))
+	assert.NoError(t, pull("redis:7@sha256:"+strings.Repeat("a", 64)))
+}
+
+func mustParseDockerRef(t *testing.T, image string) reference.Named {
+	named, err := reference.ParseDockerRef(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return named
+}
+
+func TestVolumeDiskUsage(t *testing.T) {
+	images := fakeruntime.NewImageService()
+	mounter := fakeruntime.NewMounter(images)
+	ns := newTestNodeServer(t, testNodeOptions{images: images, mounter: mounter})
+
+	ctx := context.Background()
+	image := "docker.io/library/redis:latest"
+	_, err := images.PullImage(ctx, &criapi.PullImageRequest{Image: &criapi.ImageSpec{Image: image}})
+	assert.NoError(t, err)
+	namedRef, err := reference.ParseDockerRef(image)
+	assert.NoError(t, err)
+	target := t.TempDir()
+	assert.NoError(t, mounter.Mount(ctx, "vol", backend.MountTarget(target), namedRef, backend.MountOptions{}))
+
+	resp, err := ns.NodeGetVolumeStats(ctx, &csi.NodeGetVolumeStatsRequest{VolumeId: "vol", VolumePath: target})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(200<<20), resp.GetUsage()[0].GetUsed())
+
+	usage := func() map[string]float64 {
+		families, err := metrics.RegisterMetrics().Gather()
+		assert.NoError(t, err)
+		values := map[string]float64{}
+		for _, family := range families {
+			if family.GetName() != "warm_metal_volume_disk_usage_bytes" {
+				continue
+			}
+			for _, m := range family.GetMetric() {
+				values[m.GetLabel()[0].GetValue()+"/"+m.GetLabel()[1].GetValue()] = m.GetGauge().GetValue()
+			}
+		}
+		return values
+	}
+	assert.Equal(t, map[string]float64{"image/vol": 200 << 20, "writable/vol": 0}, usage())
+
+	metrics.ForgetVolumeDiskUsage("vol")
+	assert.Empty(t, usage())
+}
+
+func TestNodeGetVolumeStats(t *testing.T) {
+	images := fakeruntime.NewImageService()
+	mounter := fakeruntime.NewMounter(images)
+	ns := newTestNodeServer(t, testNodeOptions{images: images, mounter: mounter})
+
+	ctx := context.Background()
+	image := "docker.io/library/redis:latest"
+	_, err := images.PullImage(ctx, &criapi.PullImageRequest{Image: &criapi.ImageSpec{Image: image}})
+	require.NoError(t, err)
+	mounted := t.TempDir()
+	require.NoError(t, mounter.Mount(ctx, "vol", backend.MountTarget(mounted), mustParseDockerRef(t, image),
+		backend.MountOptions{}))
+	file := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o644))
+
+	tests := []struct {
+		name     string
+		path     string
+		code     codes.Code
+		abnormal string
+	}{
+		{name: "mounted", path: mounted},
+		{name: "missing", path: filepath.Join(mounted, "missing"), code: codes.NotFound},
+		{name: "unreadable", path: filepath.Join(file, "target"), abnormal: "not a directory"},
+		{name: "unmounted", path: t.TempDir(), abnormal: "is not mounted"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := ns.NodeGetVolumeStats(ctx, &csi.NodeGetVolumeStatsRequest{VolumeId: "vol", VolumePath: tt.path})
+			require.Equal(t, tt.code, status.Code(err))
+			if tt.code != codes.OK {
+				return
+			}
+
+			if tt.abnormal == "" {
+				assert.False(t, resp.GetVolumeCondition().GetAbnormal())
+				assert.NotEmpty(t, resp.GetUsage())
+				return
+			}
+
+			assert.True(t, resp.GetVolumeCondition().GetAbnormal())
+			assert.Contains(t, resp.GetVolumeCondition().GetMessage(), tt.abnormal)
+			assert.Empty(t, resp.GetUsage())
+		})
+	}
+}
+
+func TestValidateVolumeCapability(t *testing.T) {
+	capabilityOf := func(mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+		}
+	}
+
+	tests := []struct {
+		name          string
+		mode          csi.VolumeCapability_AccessMode_Mode
+		volumeContext map[string]string
+		wantErr       bool
+	}{
+		{
+			name: "read-only on many nodes",
+			mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+		},
+		{
+			name:          "writable with persistent scratch",
+			mode:          csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			volumeContext: map[string]string{ctxKeyPersistentScratch: "true"},
+		},
+		{
+			name:    "writable without persistent scratch",
+			mode:    csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			wantErr: true,
+		},
+		{
+			name:          "writable on many nodes",
+			mode:          csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			volumeContext: map[string]string{ctxKeyPersistentScratch: "true"},
+			wantErr:       true,
+		},
+		{
+			name: "restored on many nodes",
+			mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+			volumeContext: map[string]string{
+				ctxKeyPersistentScratch: "true",
+				ctxKeySnapshotSource:    "snapcontent-1",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateVolumeCapability(capabilityOf(tt.mode), tt.volumeContext)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestWritableQuota(t *testing.T) {
+	tests := []struct {
+		name    string
+		quota   string
+		size    int64
+		wantErr string
+	}{
+		{name: "unlimited"},
+		{name: "bytes", quota: "1048576", size: 1 << 20},
+		{name: "quantity", quota: "1Gi", size: 1 << 30},
+		{name: "zero", quota: "0", wantErr: "must be positive"},
+		{name: "negative", quota: "-1Gi", wantErr: "must be positive"},
+		{name: "invalid", quota: "1 GiB", wantErr: "invalid quota"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size, err := writableQuota(map[string]string{ctxKeyQuota: tt.quota})
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.size, size)
+		})
+	}
+}
+
+func TestNodeStageVolumeOfStaticPVs(t *testing.T) {
+	pvOfClass := func(name, class string) *corev1.PersistentVolume {
+		return &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       corev1.PersistentVolumeSpec{VolumeAttributesClassName: &class},
+		}
+	}
+
+	client := fake.NewClientset(
+		pvOfClass("pv-static", "always"),
+		pvOfClass("pv-invalid", "invalid"),
+		&storagev1.VolumeAttributesClass{
+			ObjectMeta: metav1.ObjectMeta{Name: "always"},
+			DriverName: driverName,
+			Parameters: map[string]string{paramPullPolicy: pullPolicyAlways},
+		},
+		&storagev1.VolumeAttributesClass{
+			ObjectMeta: metav1.ObjectMeta{Name: "invalid"},
+			DriverName: driverName,
+			Parameters: map[string]string{paramPullPolicy: "Never"},
+		},
+	)
+
+	kubeletDir := t.TempDir()
+	hashedDir := filepath.Join(kubeletDir, "plugins/kubernetes.io/csi", driverName, "0123abcd")
+	require.NoError(t, os.MkdirAll(hashedDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(hashedDir, "vol_data.json"),
+		[]byte(`{"driverName":"`+driverName+`","specVolID":"pv-invalid"}`), 0o644))
+
+	tests := []struct {
+		name        string
+		stagingPath string
+		block       bool
+		code        codes.Code
+	}{
+		{
+			name:        "PV named by the staging directory",
+			stagingPath: "plugins/kubernetes.io/csi/pv/pv-static/globalmount",
+		},
+		{
+			name:        "PV named by the staging directory of an invalid class",
+			stagingPath: "plugins/kubernetes.io/csi/pv/pv-invalid/globalmount",
+			code:        codes.InvalidArgument,
+		},
+		{
+			name:        "PV recorded in vol_data.json",
+			stagingPath: filepath.Join("plugins/kubernetes.io/csi", driverName, "0123abcd/globalmount"),
+			code:        codes.InvalidArgument,
+		},
+		{
+			name:        "staging directory of a block volume",
+			stagingPath: "plugins/kubernetes.io/csi/volumeDevices/staging/pv-invalid",
+			block:       true,
+			code:        codes.InvalidArgument,
+		},
+		{
+			name:        "unknown PV",
+			stagingPath: filepath.Join("plugins/kubernetes.io/csi", driverName, "4567cdef/globalmount"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns := newTestNodeServer(t, testNodeOptions{})
+			ns.volumeAttributesClasses = watcher.NewVolumeAttributesClasses(client, driverName)
+			capability := &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+				},
+			}
+			if tt.block {
+				capability.AccessType = &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}
+			}
+
+			// Volume IDs of static PVs are images.
+			_, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+				VolumeId:          "docker.io/library/redis:latest",
+				StagingTargetPath: filepath.Join(kubeletDir, tt.stagingPath),
+				VolumeCapability:  capability,
+				VolumeContext:     map[string]string{ctxKeyPullAlways: "false"},
+			})
+			assert.Equal(t, tt.code, status.Code(err), "%v", err)
+			if tt.code == codes.InvalidArgument {
+				assert.ErrorContains(t, err, "VolumeAttributesClass of PV pv-invalid")
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containerd/platforms"
+	"github.com/distribution/reference"
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+	"github.com/warm-metal/container-image-csi-driver/pkg/volume"
+)
+
+// StorageClass parameters which are not volume attributes themselves.
+const (
+	paramPullPolicy = "pullPolicy"
+	paramSecretRef  = "secretRef"
+	paramPlatform   = "platform"
+	paramBackend    = "backend"
+)
+
+const (
+	// paramSnapshotContentName is passed to CreateSnapshot if the snapshotter runs with --extra-create-metadata.
+	paramSnapshotContentName = "csi.storage.k8s.io/volumesnapshotcontent/name"
+	// paramPushImage of VolumeSnapshotClasses is the image snapshots are committed and pushed as.
+	paramPushImage = "pushImage"
+)
+
+const (
+	pullPolicyAlways       = "Always"
+	pullPolicyIfNotPresent = "IfNotPresent"
+	backendPluginName      = "plugin"
+)
+
+// passthroughParameters are volume attributes which can be set via StorageClass parameters as is.
+var passthroughParameters = map[string]bool{
+	ctxKeyPullAlways:           true,
+	ctxKeyPullTimeout:          true,
+	ctxKeyFSType:               true,
+	ctxKeyPersistentScratch:    true,
+	ctxKeyUpperLayer:           true,
+	ctxKeyPath:                 true,
+	ctxKeyOverlayImages:        true,
+	ctxKeyMountOptions:         true,
+	ctxKeyBlockFormat:          true,
+	ctxKeyImageMetadata:        true,
+	ctxKeyReferrers:            true,
+	ctxKeyRequiredAttestations: true,
+	ctxKeyVerity:               true,
+	ctxKeyContainerDisk:        true,
+}
+
+// backends are the values of the backend parameter, which are the schemes of --runtime-addr and plugin.
+var backends = []string{containerdScheme, criOScheme, criDockerdScheme, podmanScheme, backendPluginName}
+
+// secretRef is the image pull secret referred by the parameter secretRef in the form of namespace/name.
+type secretRef struct {
+	namespace, name string
+}
+
+// storageClassParameters are the parsed parameters of a StorageClass.
+type storageClassParameters struct {
+	image     string
+	secretRef *secretRef
+	// attributes are the volume attributes passed to nodes, excluding the image.
+	attributes map[string]string
+}
+
+// parseParameters validates parameters of a StorageClass, which fails on unknown parameters, so that typos
+// fail provisioning instead of being ignored by nodes.
+func parseParameters(params map[string]string) (*storageClassParameters, error) {
+	parsed := &storageClassParameters{attributes: map[string]string{}}
+	for k, v := range params {
+		switch {
+		case k == ctxKeyImage:
+			if _, err := reference.ParseNormalizedNamed(v); err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %s", k, v, err)
+			}
+			parsed.image = v
+		case k == paramPullPolicy:
+			switch v {
+			case pullPolicyAlways:
+				parsed.attributes[ctxKeyPullAlways] = "true"
+			case pullPolicyIfNotPresent:
+			default:
+				return nil, fmt.Errorf("invalid %s %q, must be %q or %q", k, v, pullPolicyAlways, pullPolicyIfNotPresent)
+			}
+		case k == paramSecretRef:
+			namespace, name, found := strings.Cut(v, "/")
+			if !found || namespace == "" || name == "" || strings.Contains(name, "/") {
+				return nil, fmt.Errorf("invalid %s %q, must be in the form of namespace/name", k, v)
+			}
+			parsed.secretRef = &secretRef{namespace: namespace, name: name}
+			parsed.attributes[ctxKeySecret] = name
+			parsed.attributes[ctxKeySecretNamespace] = namespace
+		case k == paramPlatform:
+			p, err := platforms.Parse(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %s", k, v, err)
+			}
+			parsed.attributes[ctxKeyPlatform] = platforms.Format(p)
+		case k == paramBackend:
+			if !slices.Contains(backends, v) {
+				return nil, fmt.Errorf("invalid %s %q, must be one of %s", k, v, strings.Join(backends, ", "))
+			}
+			parsed.attributes[ctxKeyBackend] = v
+		case passthroughParameters[k]:
+			parsed.attributes[k] = v
+		default:
+			return nil, fmt.Errorf("unknown parameter %q, must be one of %s", k, strings.Join(knownParameters(), ", "))
+		}
+	}
+
+	return parsed, nil
+}
+
+func knownParameters() []string {
+	known := []string{ctxKeyImage, paramPullPolicy, paramSecretRef, paramPlatform, paramBackend}
+	for k := range passthroughParameters {
+		known = append(known, k)
+	}
+
+	slices.Sort(known)
+	return known
+}
+
+// mutableParameters are parameters of VolumeAttributesClasses, which can be changed on existing volumes. Changes
+// take effect once volumes are published again.
+var mutableParameters = []string{paramPullPolicy, ctxKeyPullTimeout}
+
+// parseMutableParameters validates parameters of a VolumeAttributesClass and returns volume attributes overriding
+// those of volumes.
+func parseMutableParameters(params map[string]string) (map[string]string, error) {
+	attributes := map[string]string{}
+	for k, v := range params {
+		switch k {
+		case paramPullPolicy:
+			switch v {
+			case pullPolicyAlways:
+				attributes[ctxKeyPullAlways] = "true"
+			case pullPolicyIfNotPresent:
+				attributes[ctxKeyPullAlways] = "false"
+			default:
+				return nil, fmt.Errorf("invalid %s %q, must be %q or %q", k, v, pullPolicyAlways, pullPolicyIfNotPresent)
+			}
+		case ctxKeyPullTimeout:
+			if timeout, err := time.ParseDuration(v); err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("invalid %s %q, must be a positive duration, e.g. 20m", k, v)
+			}
+			attributes[k] = v
+		default:
+			return nil, fmt.Errorf("parameter %q is immutable, mutable ones are %s", k,
+				strings.Join(mutableParameters, ", "))
+		}
+	}
+
+	return attributes, nil
+}
+
+// Attributes set by the provisioner, and those set by users to identify the pod in logs of NodePublishVolume.
+const (
+	ctxKeyProvisionerAttrsPrefix = "storage.kubernetes.io/"
+	ctxKeyLogPodName             = "pod-name"
+	ctxKeyLogNamespace           = "namespace"
+	ctxKeyLogUID                 = "uid"
+)
+
+// volumeAttributes are all attributes volumes accept besides those set by kubelet and the provisioner.
+var volumeAttributes = []string{
+	ctxKeyVolumeHandle, ctxKeyImage, ctxKeyPullAlways, ctxKeyPullTimeout, ctxKeyFSType, ctxKeyQuota, ctxKeyPersistentScratch,
+	ctxKeyUpperLayer, ctxKeyCloneSource, ctxKeySnapshotSource, ctxKeyPath, ctxKeyOverlayImages, ctxKeyMountOptions,
+	ctxKeyBlockFormat, ctxKeyImageMetadata, ctxKeyReferrers, ctxKeyRequiredAttestations, ctxKeyVerity,
+	ctxKeyContainerDisk, ctxKeySecret, ctxKeySecretNamespace, ctxKeyPlatform, ctxKeyBackend, ctxKeyLogPodName,
+	ctxKeyLogNamespace, ctxKeyLogUID,
+}
+
+// boolAttributes are volume attributes which must be true or false.
+var boolAttributes = []string{
+	ctxKeyPullAlways, ctxKeyPersistentScratch, ctxKeyImageMetadata, ctxKeyReferrers, ctxKeyVerity, ctxKeyContainerDisk,
+}
+
+// validateVolumeAttributes checks volume attributes as a whole before anything is pulled or mounted, so that typos
+// and conflicting attributes fail with the offending attribute named instead of failing deep in pulls or mounts.
+// Checks depending on the volume capability are done by validateVolumeCapability.
+func validateVolumeAttributes(volumeId string, volumeContext map[string]string) error {
+	for k := range volumeContext {
+		if strings.HasPrefix(k, ctxKeyKubeletAttrsPrefix) || strings.HasPrefix(k, ctxKeyProvisionerAttrsPrefix) {
+			continue
+		}
+
+		if !slices.Contains(volumeAttributes, k) {
+			return fmt.Errorf("unknown attribute %q, must be one of %s", k, strings.Join(volumeAttributes, ", "))
+		}
+	}
+
+	for _, k := range boolAttributes {
+		if v, found := volumeContext[k]; found {
+			if _, err := strconv.ParseBool(v); err != nil {
+				return fmt.Errorf("invalid %s %q, must be true or false", k, v)
+			}
+		}
+	}
+
+	imageKey := "volume ID"
+	if volumeContext[ctxKeyVolumeHandle] != "" {
+		imageKey = ctxKeyVolumeHandle
+	} else if volumeContext[ctxKeyImage] != "" {
+		imageKey = ctxKeyImage
+	}
+
+	image := volume.Image(volumeId, volumeContext)
+	if _, err := reference.ParseDockerRef(image); err != nil {
+		return fmt.Errorf("invalid image %q in %s: %s", image, imageKey, err)
+	}
+
+	overlayImages := volume.SplitList(volumeContext[ctxKeyOverlayImages])
+	for _, overlayImage := range overlayImages {
+		if _, err := reference.ParseDockerRef(overlayImage); err != nil {
+			return fmt.Errorf("invalid image %q in %s: %s", overlayImage, ctxKeyOverlayImages, err)
+		}
+	}
+
+	switch fsType := volumeContext[ctxKeyFSType]; fsType {
+	case "", "overlay":
+	case backend.FSTypeEROFS:
+		if len(overlayImages) > 0 {
+			return fmt.Errorf("%s can't be used with %s %q", ctxKeyOverlayImages, ctxKeyFSType, fsType)
+		}
+	default:
+		return fmt.Errorf("unsupported %s %q, must be overlay or %s", ctxKeyFSType, fsType, backend.FSTypeEROFS)
+	}
+
+	if v := volumeContext[ctxKeyPullTimeout]; v != "" {
+		if timeout, err := time.ParseDuration(v); err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid %s %q, must be a positive duration, e.g. 20m", ctxKeyPullTimeout, v)
+		}
+	}
+
+	if v := volumeContext[ctxKeyPlatform]; v != "" {
+		if _, err := platforms.Parse(v); err != nil {
+			return fmt.Errorf("invalid %s %q: %s", ctxKeyPlatform, v, err)
+		}
+	}
+
+	if v := volumeContext[ctxKeyBackend]; v != "" && !slices.Contains(backends, v) {
+		return fmt.Errorf("invalid %s %q, must be one of %s", ctxKeyBackend, v, strings.Join(backends, ", "))
+	}
+
+	if volumeContext[ctxKeySecretNamespace] != "" && volumeContext[ctxKeySecret] == "" {
+		return fmt.Errorf("%s requires %s", ctxKeySecretNamespace, ctxKeySecret)
+	}
+
+	path := volumeContext[ctxKeyPath]
+	for _, k := range []string{ctxKeyImageMetadata, ctxKeyReferrers} {
+		if path != "" && strings.ToLower(volumeContext[k]) == "true" {
+			return fmt.Errorf("%s can't be used with %s", k, ctxKeyPath)
+		}
+	}
+
+	persistentScratch := strings.ToLower(volumeContext[ctxKeyPersistentScratch]) == "true"
+	switch upperLayer := volumeContext[ctxKeyUpperLayer]; upperLayer {
+	case "", "disk":
+	case "tmpfs":
+		if persistentScratch {
+			return fmt.Errorf("%s %q can't be used with %s", ctxKeyUpperLayer, upperLayer, ctxKeyPersistentScratch)
+		}
+	default:
+		return fmt.Errorf("unsupported %s %q, must be disk or tmpfs", ctxKeyUpperLayer, upperLayer)
+	}
+
+	if _, err := writableQuota(volumeContext); err != nil {
+		return err
+	}
+
+	return nil
+}
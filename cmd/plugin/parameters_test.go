@@ -0,0 +1,117 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateVolumeAttributes(t *testing.T) {
+	tests := []struct {
+		name          string
+		volumeId      string
+		volumeContext map[string]string
+		wantErr       string
+	}{
+		{
+			name:     "valid",
+			volumeId: "docker.io/library/redis:latest",
+			volumeContext: map[string]string{
+				ctxKeyPullAlways: "True",
+				ctxKeyPodName:    "test-pod",
+				"storage.kubernetes.io/csiProvisionerIdentity": "test",
+			},
+		},
+		{
+			name:          "unknown attribute",
+			volumeId:      "docker.io/library/redis:latest",
+			volumeContext: map[string]string{"pullAllways": "true"},
+			wantErr:       `unknown attribute "pullAllways"`,
+		},
+		{
+			name:          "invalid image",
+			volumeId:      "pv-1",
+			volumeContext: map[string]string{ctxKeyImage: "Redis:latest"},
+			wantErr:       "in image",
+		},
+		{
+			name:          "invalid bool",
+			volumeId:      "docker.io/library/redis:latest",
+			volumeContext: map[string]string{ctxKeyPersistentScratch: "yes"},
+			wantErr:       ctxKeyPersistentScratch,
+		},
+		{
+			name:     "conflicting upper layer",
+			volumeId: "docker.io/library/redis:latest",
+			volumeContext: map[string]string{
+				ctxKeyPersistentScratch: "true",
+				ctxKeyUpperLayer:        "tmpfs",
+			},
+			wantErr: ctxKeyUpperLayer,
+		},
+		{
+			name:     "metadata of path",
+			volumeId: "docker.io/library/redis:latest",
+			volumeContext: map[string]string{
+				ctxKeyImageMetadata: "true",
+				ctxKeyPath:          "/etc",
+			},
+			wantErr: ctxKeyImageMetadata,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateVolumeAttributes(tt.volumeId, tt.volumeContext)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestParseMutableParameters(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  map[string]string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:   "pull policy and timeout",
+			params: map[string]string{paramPullPolicy: pullPolicyAlways, ctxKeyPullTimeout: "20m"},
+			want:   map[string]string{ctxKeyPullAlways: "true", ctxKeyPullTimeout: "20m"},
+		},
+		{
+			name:   "pull if not present",
+			params: map[string]string{paramPullPolicy: pullPolicyIfNotPresent},
+			want:   map[string]string{ctxKeyPullAlways: "false"},
+		},
+		{
+			name:    "invalid timeout",
+			params:  map[string]string{ctxKeyPullTimeout: "-1m"},
+			wantErr: ctxKeyPullTimeout,
+		},
+		{
+			name:    "immutable parameter",
+			params:  map[string]string{ctxKeyImage: "docker.io/library/redis:latest"},
+			wantErr: "immutable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMutableParameters(tt.params)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
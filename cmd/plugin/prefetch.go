@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/distribution/reference"
+	"github.com/warm-metal/container-image-csi-driver/pkg/cri"
+	"github.com/warm-metal/container-image-csi-driver/pkg/fake"
+	"github.com/warm-metal/container-image-csi-driver/pkg/volume"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// prefetchImageService connects to the image service of the runtime the node plugin would use with the same flags.
+func prefetchImageService() (criapi.ImageServiceClient, error) {
+	addr := *runtimeAddr
+	if addr == "" {
+		detected, err := detectRuntimeAddr()
+		if err != nil {
+			return nil, err
+		}
+
+		addr = detected
+	}
+
+	if strings.HasPrefix(addr, fakeScheme+"://") {
+		return fake.NewImageService(), nil
+	}
+
+	selected, _, err := selectRuntimeAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	runtime, err := url.Parse(selected)
+	if err != nil {
+		return nil, fmt.Errorf("invalid runtime address: %w", err)
+	}
+
+	if runtime.Scheme == podmanScheme {
+		return cri.NewPodmanImageService(runtime.Path), nil
+	}
+
+	runtime.Scheme = "unix"
+	return cri.NewRemoteImageService(runtime.String(), time.Second)
+}
+
+// Prefetch pulls the image of the volume attributes as NodePublishVolume does, via the same credential chain,
+// including secrets referred by the attributes, and the same runtime handler, which unpacks layers for the
+// snapshotter of the driver. The image is always pulled. Volumes are neither prepared nor mounted, so that snapshots
+// of the running node plugin are never touched. Returns the local image.
+func (n NodeServer) Prefetch(ctx context.Context, attributes map[string]string) (*criapi.Image, error) {
+	req := &csi.NodePublishVolumeRequest{VolumeId: "prefetch", VolumeContext: attributes}
+	image := volume.Image(req.VolumeId, req.VolumeContext)
+	if image == req.VolumeId {
+		return nil, status.Errorf(codes.InvalidArgument, "attribute %q is required", ctxKeyImage)
+	}
+
+	pullTimeout, err := n.pullTimeout(req.VolumeContext)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	keyring, err := n.resolveKeyring(ctx, req, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	namedRef, err := reference.ParseDockerRef(image)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid image %q: %s", image, err)
+	}
+
+	if err = n.pullImage(ctx, image, namedRef, keyring, true, pullTimeout,
+		pullRequester(req.VolumeId, req.VolumeContext)); err != nil {
+		return nil, err
+	}
+
+	resp, err := n.imageSvc.ImageStatus(ctx, &criapi.ImageStatusRequest{
+		Image: &criapi.ImageSpec{Image: namedRef.String()},
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to fetch status of image %q: %s", namedRef, err)
+	}
+
+	if resp.Image == nil {
+		return nil, status.Errorf(codes.NotFound, "image %q is pulled but not found on the node", namedRef)
+	}
+
+	return resp.Image, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+	"github.com/warm-metal/container-image-csi-driver/pkg/errorlog"
+	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
+	"github.com/warm-metal/container-image-csi-driver/pkg/volume"
+	corev1 "k8s.io/api/core/v1"
+	cri "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/klog/v2"
+)
+
+// annProvisionedBy is set by the external provisioner on PVs it provisions.
+const annProvisionedBy = "pv.kubernetes.io/provisioned-by"
+
+const (
+	// imageReclaimTimeout bounds how long removals of images of deleted PVs are queued while volumes are mounted.
+	imageReclaimTimeout = 10 * time.Minute
+	// imageRemovalTimeout bounds removing an image from the runtime.
+	imageRemovalTimeout = time.Minute
+)
+
+// imageReclaimer queues removals of images of deleted PVs. Mounts hold lock for reading while they pull and mount
+// images, so images are removed once no volume is being mounted, by the last mount in progress.
+type imageReclaimer struct {
+	// lock is held for reading by mounts, and for writing while images are removed
+	lock  sync.RWMutex
+	guard sync.Mutex
+	// queue maps images to remove to their removals
+	queue map[string]imageRemoval
+}
+
+// imageRemoval is the removal of an image of a deleted PV, which is dropped if it is still queued after deadline.
+type imageRemoval struct {
+	image    reference.Named
+	pvName   string
+	deadline time.Time
+}
+
+func newImageReclaimer() *imageReclaimer {
+	return &imageReclaimer{queue: make(map[string]imageRemoval)}
+}
+
+func (r *imageReclaimer) enqueue(image reference.Named, pvName string) {
+	r.guard.Lock()
+	defer r.guard.Unlock()
+	r.queue[image.String()] = imageRemoval{image: image, pvName: pvName, deadline: time.Now().Add(imageReclaimTimeout)}
+}
+
+func (r *imageReclaimer) empty() bool {
+	r.guard.Lock()
+	defer r.guard.Unlock()
+	return len(r.queue) == 0
+}
+
+// take returns queued removals and empties the queue.
+func (r *imageReclaimer) take() []imageRemoval {
+	r.guard.Lock()
+	defer r.guard.Unlock()
+	removals := make([]imageRemoval, 0, len(r.queue))
+	for _, removal := range r.queue {
+		removals = append(removals, removal)
+	}
+
+	clear(r.queue)
+	return removals
+}
+
+// expire drops removals queued for longer than imageReclaimTimeout.
+func (r *imageReclaimer) expire() {
+	r.guard.Lock()
+	defer r.guard.Unlock()
+	now := time.Now()
+	for key, removal := range r.queue {
+		if now.After(removal.deadline) {
+			delete(r.queue, key)
+			errorlog.Errorf("gave up removing image %q of deleted PV %s, since volumes are being mounted for %s",
+				removal.image, removal.pvName, imageReclaimTimeout)
+			metrics.OperationErrorsCount.WithLabelValues("remove-image-timeout").Inc()
+		}
+	}
+}
+
+// pvImages returns images of the PV, including overlay images.
+func pvImages(source *corev1.CSIPersistentVolumeSource) []string {
+	return volume.Images(source.VolumeHandle, source.VolumeAttributes)
+}
+
+// RemoveImagesOfPV removes images of a deleted PV from this node in background, if the PV is provisioned by the
+// driver with reclaimPolicy Delete, so that deleting the PV releases the disk its images take. Images not pulled by
+// the driver since it started, or still used by volumes on the node or by remaining PVs, are kept. So are images of
+// mounters not tracking images of their volumes, e.g. backend plugins.
+func (n NodeServer) RemoveImagesOfPV(pv *corev1.PersistentVolume, remaining []*corev1.CSIPersistentVolumeSource) {
+	if _, ok := n.mounter.(backend.ImageUser); !ok {
+		return
+	}
+
+	if pv.Spec.PersistentVolumeReclaimPolicy != corev1.PersistentVolumeReclaimDelete ||
+		pv.Annotations[annProvisionedBy] != pv.Spec.CSI.Driver {
+		return
+	}
+
+	var referred []string
+	for _, source := range remaining {
+		referred = append(referred, pvImages(source)...)
+	}
+
+	for _, image := range pvImages(pv.Spec.CSI) {
+		namedRef, err := reference.ParseDockerRef(image)
+		if err != nil || !n.localImages.has(namedRef.String()) {
+			continue
+		}
+
+		if slices.ContainsFunc(referred, func(other string) bool {
+			otherRef, err := reference.ParseDockerRef(other)
+			return err == nil && otherRef.String() == namedRef.String()
+		}) {
+			klog.V(2).Infof("image %q of deleted PV %s is still used by other PVs", namedRef, pv.Name)
+			continue
+		}
+
+		n.reclaimer.enqueue(namedRef, pv.Name)
+	}
+
+	n.reclaimImages()
+}
+
+// holdImages keeps images from being removed until the returned function is called, which removes images queued
+// in the meantime unless other volumes are still being mounted.
+func (n NodeServer) holdImages() (release func()) {
+	n.reclaimer.lock.RLock()
+	return func() {
+		n.reclaimer.lock.RUnlock()
+		n.reclaimImages()
+	}
+}
+
+// reclaimImages removes queued images in background unless volumes are being mounted, in which case the last mount
+// in progress removes them once it finishes.
+func (n NodeServer) reclaimImages() {
+	if n.reclaimer.empty() || !n.background.start() {
+		return
+	}
+
+	go func() {
+		defer n.background.done()
+		// Images may be queued while others are being removed, whose reclaims can't take the lock.
+		for !n.reclaimer.empty() {
+			if !n.reclaimer.lock.TryLock() {
+				klog.V(4).Info("volumes are being mounted. remove images of deleted PVs once they are mounted")
+				n.reclaimer.expire()
+				return
+			}
+
+			for _, removal := range n.reclaimer.take() {
+				n.removeImage(removal.image, removal.pvName)
+			}
+			n.reclaimer.lock.Unlock()
+		}
+	}()
+}
+
+// removeImage removes the image unless volumes on the node use it. It must be called with reclaimer.lock held.
+func (n NodeServer) removeImage(image reference.Named, pvName string) {
+	if n.mounter.(backend.ImageUser).ImageInUse(image) {
+		klog.V(2).Infof("image %q of deleted PV %s is still used by volumes on the node", image, pvName)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), imageRemovalTimeout)
+	defer cancel()
+	if _, err := n.imageSvc.RemoveImage(ctx, &cri.RemoveImageRequest{
+		Image: &cri.ImageSpec{Image: image.String()},
+	}); err != nil {
+		errorlog.Errorf("unable to remove image %q of deleted PV %s: %s", image, pvName, err)
+		metrics.OperationErrorsCount.WithLabelValues("remove-image").Inc()
+		return
+	}
+
+	n.localImages.remove(image.String())
+	klog.Infof("removed image %q of deleted PV %s", image, pvName)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+	fakeruntime "github.com/warm-metal/container-image-csi-driver/pkg/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// scratchRecorder records volumes whose persistent scratch layers are removed.
+type scratchRecorder struct {
+	*fakeruntime.Mounter
+	removed []string
+}
+
+func (m *scratchRecorder) RemoveScratch(_ context.Context, volumeId string) error {
+	m.removed = append(m.removed, volumeId)
+	return nil
+}
+
+func TestRemoveScratchOfPV(t *testing.T) {
+	images := fakeruntime.NewImageService()
+	mounter := &scratchRecorder{Mounter: fakeruntime.NewMounter(images)}
+	ns := newTestNodeServer(t, testNodeOptions{images: images, mounter: mounter})
+
+	source := func(handle string, persistent bool) *corev1.CSIPersistentVolumeSource {
+		attrs := map[string]string{ctxKeyImage: "docker.io/library/redis:latest"}
+		if persistent {
+			attrs[ctxKeyPersistentScratch] = "true"
+		}
+
+		return &corev1.CSIPersistentVolumeSource{Driver: driverName, VolumeHandle: handle, VolumeAttributes: attrs}
+	}
+
+	for _, c := range []struct {
+		name      string
+		deleted   *corev1.CSIPersistentVolumeSource
+		remaining []*corev1.CSIPersistentVolumeSource
+		removed   []string
+	}{
+		{name: "ephemeral layer", deleted: source("pv-1", false)},
+		{name: "sole user", deleted: source("pv-1", true), remaining: []*corev1.CSIPersistentVolumeSource{
+			source("pv-2", true),
+		}, removed: []string{"pv-1"}},
+		{name: "shared handle", deleted: source("pv-1", true), remaining: []*corev1.CSIPersistentVolumeSource{
+			source("pv-2", true), source("pv-1", true),
+		}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			mounter.removed = nil
+			ns.RemoveScratchOfPV(c.deleted, c.remaining)
+			assert.Equal(t, c.removed, mounter.removed)
+		})
+	}
+}
+
+func TestRemoveImagesOfPV(t *testing.T) {
+	const (
+		redis = "docker.io/library/redis:latest"
+		nginx = "docker.io/library/nginx:latest"
+	)
+
+	source := func(handle, image string, overlays ...string) *corev1.CSIPersistentVolumeSource {
+		attrs := map[string]string{ctxKeyImage: image}
+		if len(overlays) > 0 {
+			attrs[ctxKeyOverlayImages] = strings.Join(overlays, ",")
+		}
+
+		return &corev1.CSIPersistentVolumeSource{Driver: driverName, VolumeHandle: handle, VolumeAttributes: attrs}
+	}
+
+	pv := func(
+		source *corev1.CSIPersistentVolumeSource, policy corev1.PersistentVolumeReclaimPolicy,
+	) *corev1.PersistentVolume {
+		return &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: source.VolumeHandle, Annotations: map[string]string{
+				annProvisionedBy: driverName,
+			}},
+			Spec: corev1.PersistentVolumeSpec{
+				PersistentVolumeReclaimPolicy: policy,
+				PersistentVolumeSource:        corev1.PersistentVolumeSource{CSI: source},
+			},
+		}
+	}
+
+	for _, c := range []struct {
+		name      string
+		deleted   *corev1.PersistentVolume
+		remaining []*corev1.CSIPersistentVolumeSource
+		kept      []string
+	}{
+		{name: "sole user", deleted: pv(source("pv-1", redis, nginx), corev1.PersistentVolumeReclaimDelete)},
+		{name: "retained", deleted: pv(source("pv-1", redis, nginx), corev1.PersistentVolumeReclaimRetain),
+			kept: []string{redis, nginx}},
+		{name: "image of remaining PV", deleted: pv(source("pv-1", redis, nginx), corev1.PersistentVolumeReclaimDelete),
+			remaining: []*corev1.CSIPersistentVolumeSource{source("pv-2", "redis")}, kept: []string{redis}},
+		{name: "overlay of remaining PV", deleted: pv(source("pv-1", redis, nginx), corev1.PersistentVolumeReclaimDelete),
+			remaining: []*corev1.CSIPersistentVolumeSource{source("pv-2", "busybox", nginx)}, kept: []string{nginx}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			images := fakeruntime.NewImageService()
+			ns := newTestNodeServer(t, testNodeOptions{images: images})
+			for _, image := range []string{redis, nginx} {
+				_, err := images.PullImage(context.Background(),
+					&criapi.PullImageRequest{Image: &criapi.ImageSpec{Image: image}})
+				assert.NoError(t, err)
+				ns.localImages.add(image)
+			}
+
+			ns.RemoveImagesOfPV(c.deleted, c.remaining)
+			assert.NoError(t, ns.background.drain(context.Background()))
+			for _, image := range []string{redis, nginx} {
+				assert.Equal(t, slices.Contains(c.kept, image), images.Pulled(image), image)
+			}
+		})
+	}
+}
+
+func TestReclaimImagesWhileMounting(t *testing.T) {
+	const redis = "docker.io/library/redis:latest"
+	images := fakeruntime.NewImageService()
+	mounter := fakeruntime.NewMounter(images)
+	ctx := context.Background()
+	_, err := images.PullImage(ctx, &criapi.PullImageRequest{Image: &criapi.ImageSpec{Image: redis}})
+	require.NoError(t, err)
+	image, err := reference.ParseDockerRef(redis)
+	require.NoError(t, err)
+	require.NoError(t, mounter.Mount(ctx, "pv-1", "/target", image, backend.MountOptions{}))
+
+	// Images of volumes recovered after restarts are restored.
+	ns := newTestNodeServer(t, testNodeOptions{images: images, mounter: mounter})
+	assert.True(t, ns.localImages.has(redis))
+	require.NoError(t, mounter.Unmount(ctx, "pv-1", "/target"))
+
+	// Removals are queued while volumes are being mounted, and made by the last mount in progress.
+	release := ns.holdImages()
+	releaseAgain := ns.holdImages()
+	deleted := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv-1", Annotations: map[string]string{annProvisionedBy: driverName}},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimDelete,
+			PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{
+				Driver: driverName, VolumeHandle: redis,
+			}},
+		},
+	}
+	ns.RemoveImagesOfPV(deleted, nil)
+	release()
+	assert.True(t, images.Pulled(redis))
+
+	releaseAgain()
+	require.NoError(t, ns.background.drain(ctx))
+	assert.False(t, images.Pulled(redis))
+	assert.False(t, ns.localImages.has(redis))
+	assert.True(t, ns.reclaimer.empty())
+}
+
+func TestImageReclaimerExpiry(t *testing.T) {
+	r := newImageReclaimer()
+	image, err := reference.ParseDockerRef("docker.io/library/redis:latest")
+	require.NoError(t, err)
+	r.enqueue(image, "pv-1")
+	r.expire()
+	assert.False(t, r.empty())
+
+	// Removals queued for longer than imageReclaimTimeout are dropped.
+	removal := r.queue[image.String()]
+	removal.deadline = time.Now().Add(-time.Second)
+	r.queue[image.String()] = removal
+	r.expire()
+	assert.True(t, r.empty())
+}
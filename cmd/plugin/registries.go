@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/distribution/reference"
+)
+
+// registryRules restrict registry hosts images can be pulled from by glob patterns, e.g. *.acme.io or
+// registry.acme.io:5000. Denied patterns take precedence over allowed ones.
+type registryRules struct {
+	// allowed are patterns of the only registries images can be pulled from. All registries are allowed if it is
+	// empty.
+	allowed []string
+	denied  []string
+}
+
+// newRegistryRules validates patterns of the rules, and returns nil if there are none.
+func newRegistryRules(allowed, denied []string) (*registryRules, error) {
+	if len(allowed) == 0 && len(denied) == 0 {
+		return nil, nil
+	}
+
+	for _, pattern := range append(append([]string{}, allowed...), denied...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid registry pattern %q: %s", pattern, err)
+		}
+	}
+
+	return &registryRules{allowed: allowed, denied: denied}, nil
+}
+
+// check fails with the matched rule if the registry of the image is denied, or with all allowed rules if it matches
+// none of them.
+func (r *registryRules) check(image reference.Named) error {
+	registry := strings.ToLower(reference.Domain(image))
+	if pattern, denied := matchRegistry(r.denied, registry); denied {
+		return fmt.Errorf("registry %q of image %q is denied by rule %q", registry, image, pattern)
+	}
+
+	if len(r.allowed) == 0 {
+		return nil
+	}
+
+	if _, allowed := matchRegistry(r.allowed, registry); !allowed {
+		return fmt.Errorf("registry %q of image %q matches none of allowed registries %s", registry, image,
+			strings.Join(r.allowed, ", "))
+	}
+
+	return nil
+}
+
+func matchRegistry(patterns []string, registry string) (string, bool) {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToLower(pattern), registry); matched {
+			return pattern, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	fakeruntime "github.com/warm-metal/container-image-csi-driver/pkg/fake"
+	"github.com/warm-metal/container-image-csi-driver/pkg/remoteimage"
+	"github.com/warm-metal/container-image-csi-driver/pkg/secret"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+func TestRegistryRules(t *testing.T) {
+	_, err := newRegistryRules([]string{"[ghcr.io"}, nil)
+	assert.Error(t, err)
+	rules, err := newRegistryRules(nil, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, rules)
+
+	images := fakeruntime.NewImageService()
+	ns := newTestNodeServer(t, testNodeOptions{images: images})
+	ns.registries, err = newRegistryRules([]string{"docker.io", "*.acme.io"}, []string{"untrusted.acme.io"})
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	pull := func(image string) error {
+		return ns.pullImage(ctx, image, mustParseDockerRef(t, image), secret.NewDockerKeyring(), false, 0,
+			remoteimage.PullRequester{})
+	}
+
+	assert.NoError(t, pull("redis:latest"))
+	assert.NoError(t, pull("registry.acme.io/app:v1"))
+	err = pull("untrusted.acme.io/app:v1")
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Contains(t, err.Error(), `rule "untrusted.acme.io"`)
+	assert.Equal(t, ReasonImagePolicyViolation, pullFailureReason(err))
+
+	// Images of registries not allowed are refused even if they exist.
+	_, err = images.PullImage(ctx, &criapi.PullImageRequest{Image: &criapi.ImageSpec{Image: "ghcr.io/org/app:v1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, codes.PermissionDenied, status.Code(pull("ghcr.io/org/app:v1")))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
+	"k8s.io/klog/v2"
+)
+
+// runtimeSocket is a well-known socket of a container runtime.
+type runtimeSocket struct {
+	scheme string
+	path   string
+}
+
+// wellKnownRuntimeSockets are probed in order if no runtime address is given. cri-dockerd goes first since
+// nodes running it also run containerd for Docker Engine, and cri-o goes before containerd for the same reason.
+var wellKnownRuntimeSockets = []runtimeSocket{
+	{criDockerdScheme, "/var/run/cri-dockerd.sock"},
+	{criOScheme, "/var/run/crio/crio.sock"},
+	{containerdScheme, "/run/k3s/containerd/containerd.sock"},
+	{containerdScheme, "/var/snap/microk8s/common/run/containerd.sock"},
+	{containerdScheme, "/run/containerd/containerd.sock"},
+}
+
+// detectRuntimeAddr returns the address of the first well-known runtime socket accepting connections,
+// including the one of rootless Podman of the current user. The decision is reported via the runtime
+// info metric.
+func detectRuntimeAddr() (string, error) {
+	sockets := wellKnownRuntimeSockets
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		sockets = append(sockets, runtimeSocket{podmanScheme, filepath.Join(runtimeDir, "podman", "podman.sock")})
+	}
+
+	for _, socket := range sockets {
+		if fi, err := os.Stat(socket.path); err != nil || fi.Mode()&os.ModeSocket == 0 {
+			continue
+		}
+
+		if err := probeRuntimeSocket(socket.path); err != nil {
+			klog.Warningf("runtime socket %q exists but doesn't accept connections: %s", socket.path, err)
+			continue
+		}
+
+		addr := (&url.URL{Scheme: socket.scheme, Path: socket.path}).String()
+		klog.Infof("detected container runtime %s at %q", socket.scheme, socket.path)
+		metrics.RuntimeInfo.WithLabelValues(socket.scheme, socket.path, "true").Set(1)
+		return addr, nil
+	}
+
+	return "", fmt.Errorf("none of the well-known runtime sockets accepts connections")
+}
+
+// probeRuntimeSocket returns an error if the unix socket doesn't accept connections.
+func probeRuntimeSocket(path string) error {
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// selectRuntimeAddr returns the first of comma-separated runtime addresses whose socket accepts connections, and
+// the other addresses of the same runtime, with the scheme "unix", as fallbacks of its image service. So, the same
+// addresses work on nodes whose runtime sockets are at different paths. A single address is returned as is.
+func selectRuntimeAddr(addrs string) (selected string, fallbacks []string, err error) {
+	candidates := strings.Split(addrs, ",")
+	if len(candidates) == 1 {
+		return addrs, nil, nil
+	}
+
+	var runtimes []*url.URL
+	for _, candidate := range candidates {
+		addr, err := url.Parse(strings.TrimSpace(candidate))
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid runtime address %q: %w", candidate, err)
+		}
+
+		runtimes = append(runtimes, addr)
+	}
+
+	i := slices.IndexFunc(runtimes, func(addr *url.URL) bool {
+		if err := probeRuntimeSocket(addr.Path); err != nil {
+			klog.Warningf("runtime socket %q doesn't accept connections: %s", addr.Path, err)
+			return false
+		}
+
+		return true
+	})
+	if i < 0 {
+		return "", nil, fmt.Errorf("none of runtime sockets %q accepts connections", addrs)
+	}
+
+	selected = runtimes[i].String()
+	for j, addr := range runtimes {
+		if j == i || addr.Scheme != runtimes[i].Scheme {
+			continue
+		}
+
+		fallbacks = append(fallbacks, (&url.URL{Scheme: "unix", Path: addr.Path}).String())
+	}
+
+	klog.Infof("selected container runtime %s at %q, falling back to %q", runtimes[i].Scheme, runtimes[i].Path,
+		fallbacks)
+	return selected, fallbacks, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// ScratchNodeAnnotation is set on PVs to the node which has their persistent scratch layers, so that clones of the
+// volumes are provisioned on the node.
+const ScratchNodeAnnotation = "container-image.csi.k8s.io/scratch-node"
+
+// scratchNodeAnnotationTimeout bounds annotating a PV.
+const scratchNodeAnnotationTimeout = 30 * time.Second
+
+// annotateScratchNode annotates the PV of the volume with the node in background, once its persistent scratch layer
+// is created on the node. PVs provisioned by the driver are named by their volume IDs. Failures are logged without
+// failing the publication.
+func (n NodeServer) annotateScratchNode(volumeId string) {
+	if n.scratchNodes == nil {
+		return
+	}
+
+	if !n.background.start() {
+		return
+	}
+
+	go func() {
+		defer n.background.done()
+		ctx, cancel := context.WithTimeout(context.Background(), scratchNodeAnnotationTimeout)
+		defer cancel()
+
+		node := n.driver.GetNodeID()
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{"annotations": map[string]string{ScratchNodeAnnotation: node}},
+		})
+		if err != nil {
+			klog.Errorf("unable to encode the scratch node annotation: %s", err)
+			return
+		}
+
+		if _, err = n.scratchNodes.CoreV1().PersistentVolumes().Patch(ctx, volumeId, types.MergePatchType, patch,
+			metav1.PatchOptions{}); err != nil {
+			klog.Errorf("unable to annotate PV %s with node %s: %s", volumeId, node, err)
+			metrics.OperationErrorsCount.WithLabelValues("annotate-scratch-node").Inc()
+			return
+		}
+
+		klog.V(2).Infof("annotated PV %s with node %s of its persistent scratch layer", volumeId, node)
+	}()
+}
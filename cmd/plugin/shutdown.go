@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	csicommon "github.com/warm-metal/container-image-csi-driver/pkg/csi-common"
+	"k8s.io/klog/v2"
+)
+
+// backgroundTasks tracks tasks started out of CSI requests, like pre-pulls and snapshot saving, so that they
+// can be drained before the driver exits.
+type backgroundTasks struct {
+	guard    sync.Mutex
+	running  sync.WaitGroup
+	draining bool
+}
+
+// start returns false if the driver is shutting down. Otherwise, done must be called once the task finishes.
+func (t *backgroundTasks) start() bool {
+	t.guard.Lock()
+	defer t.guard.Unlock()
+	if t.draining {
+		return false
+	}
+
+	t.running.Add(1)
+	return true
+}
+
+func (t *backgroundTasks) done() {
+	t.running.Done()
+}
+
+// drain rejects new tasks, then waits for running tasks to finish until ctx expires.
+func (t *backgroundTasks) drain(ctx context.Context) error {
+	t.guard.Lock()
+	t.draining = true
+	t.guard.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		t.running.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// serveUntilTerminated waits for the server until SIGTERM or SIGINT is received. The server then stops accepting
+// new requests, and requests and background tasks in progress are given gracePeriod to finish. Requests still
+// running after that are cancelled. Volume state is saved by each operation, and operations interrupted are
+// redone once kubelet retries them after the driver restarts.
+//
+// If takeover is not nil, the server is drained the same way once another plugin requests the node. Background loops
+// are then stopped by stopLoops and the node is handed over, while the process waits for the signal to exit, so that
+// it isn't restarted to compete with the new plugin.
+func serveUntilTerminated(
+	server csicommon.NonBlockingGRPCServer, tasks *backgroundTasks, gracePeriod time.Duration, takeover *handover,
+	stopLoops context.CancelFunc,
+) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+
+	stopped := make(chan struct{})
+	go func() {
+		server.Wait()
+		close(stopped)
+	}()
+
+	var requested <-chan struct{}
+	if takeover != nil {
+		watchCtx, stopWatching := context.WithCancel(context.Background())
+		defer stopWatching()
+		requested = takeover.requested(watchCtx)
+	}
+
+	handingOver := false
+	select {
+	case <-stopped:
+		return
+	case sig := <-signals:
+		klog.Infof("received %s, draining requests within %s", sig, gracePeriod)
+	case <-requested:
+		klog.Infof("handing the node over, draining requests within %s", gracePeriod)
+		handingOver = true
+	}
+
+	drain(server, stopped, tasks, gracePeriod)
+	if !handingOver {
+		return
+	}
+
+	stopLoops()
+	takeover.release()
+	klog.Info("the node is handed over, waiting to be terminated")
+	<-signals
+}
+
+// drain stops the server, then waits for requests and background tasks in progress to finish within gracePeriod.
+func drain(server csicommon.NonBlockingGRPCServer, stopped <-chan struct{}, tasks *backgroundTasks,
+	gracePeriod time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+
+	go server.Stop()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		klog.Warningf("requests are still running after %s, cancel them", gracePeriod)
+		server.ForceStop()
+		<-stopped
+	}
+
+	if err := tasks.drain(ctx); err != nil {
+		klog.Warningf("background tasks are still running after %s, leave them to the next start", gracePeriod)
+		return
+	}
+
+	klog.Info("all requests and background tasks are drained")
+}
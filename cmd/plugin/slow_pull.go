@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/warm-metal/container-image-csi-driver/pkg/remoteimage"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// ReasonSlowPull is the reason of events of pulls slower than the thresholds of --slow-pull-threshold and
+// --slow-pull-min-throughput.
+const ReasonSlowPull = "SlowPull"
+
+// slowPullMinDuration is the duration pulls must exceed to be slow by throughput, since the latency of registries
+// dominates the throughput of small images.
+const slowPullMinDuration = 5 * time.Second
+
+// slowPullThresholds tell which pulls are slow, i.e. those taking longer than duration, or fetching less than
+// bytesPerSecond. Zero thresholds are disabled.
+type slowPullThresholds struct {
+	duration       time.Duration
+	bytesPerSecond int64
+}
+
+// slow returns whether a pull of size bytes taking elapsed is slow.
+func (t slowPullThresholds) slow(elapsed time.Duration, size int64) bool {
+	if t.duration > 0 && elapsed > t.duration {
+		return true
+	}
+
+	return t.bytesPerSecond > 0 && elapsed > slowPullMinDuration &&
+		float64(size)/elapsed.Seconds() < float64(t.bytesPerSecond)
+}
+
+// warnSlowPull logs and emits a warning event to the pod of requester if the pull of the image, which took elapsed,
+// is slow, so that users can tell big images from broken networking. CRI doesn't report progress of layers, so
+// pulls are identified by their images and registries.
+func (n NodeServer) warnSlowPull(
+	ctx context.Context, image reference.Named, puller remoteimage.Puller, elapsed time.Duration,
+	requester remoteimage.PullRequester,
+) {
+	if n.slowPull == nil {
+		return
+	}
+
+	size, err := puller.ImageSize(ctx)
+	if err != nil {
+		klog.FromContext(ctx).V(2).Info("Unable to check whether the pull is slow", "err", err)
+		return
+	}
+
+	if !n.slowPull.slow(elapsed, int64(size)) {
+		return
+	}
+
+	registry := reference.Domain(image)
+	bytesPerSecond := float64(size) / elapsed.Seconds()
+	klog.FromContext(ctx).Info("Slow pull", "registry", registry, "duration", elapsed.Round(time.Millisecond),
+		"bytes", size, "bytesPerSecond", int64(bytesPerSecond))
+	n.recordPodEvent(requester.PodNamespace, requester.PodName, requester.PodUID, corev1.EventTypeWarning,
+		ReasonSlowPull, fmt.Sprintf("Pulling image %q of %.1fMiB from registry %s took %s at %.1fMiB/s", image,
+			float64(size)/(1<<20), registry, elapsed.Round(time.Second), bytesPerSecond/(1<<20)))
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/stretchr/testify/assert"
+	fakeruntime "github.com/warm-metal/container-image-csi-driver/pkg/fake"
+	"github.com/warm-metal/container-image-csi-driver/pkg/remoteimage"
+	"github.com/warm-metal/container-image-csi-driver/pkg/secret"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestSlowPullEvents(t *testing.T) {
+	thresholds := slowPullThresholds{duration: time.Minute, bytesPerSecond: 1 << 20}
+	assert.True(t, thresholds.slow(2*time.Minute, 1<<30))
+	assert.True(t, thresholds.slow(10*time.Second, 1<<20))
+	assert.False(t, thresholds.slow(10*time.Second, 100<<20))
+	assert.False(t, thresholds.slow(time.Second, 1<<10), "small images are never slow by throughput")
+	assert.False(t, slowPullThresholds{}.slow(time.Hour, 0))
+
+	images := fakeruntime.NewImageService()
+	ns := newTestNodeServer(t, testNodeOptions{images: images})
+	recorder := record.NewFakeRecorder(10)
+	ns.events = recorder
+	ns.slowPull = &slowPullThresholds{duration: time.Minute}
+
+	namedRef, err := reference.ParseDockerRef("docker.io/library/redis:latest")
+	assert.NoError(t, err)
+	puller := remoteimage.NewPuller(images, namedRef, secret.NewDockerKeyring(), "")
+	assert.NoError(t, puller.Pull(context.Background()))
+	requester := pullRequester("vol", map[string]string{ctxKeyPodNamespace: "default", ctxKeyPodName: "redis"})
+	ns.warnSlowPull(context.Background(), namedRef, puller, time.Second, requester)
+	ns.warnSlowPull(context.Background(), namedRef, puller, 100*time.Second, requester)
+	close(recorder.Events)
+
+	var events []string
+	for event := range recorder.Events {
+		events = append(events, event)
+	}
+	assert.Equal(t, []string{`Warning SlowPull Pulling image "docker.io/library/redis:latest" of 100.0MiB from ` +
+		`registry docker.io took 1m40s at 1.0MiB/s`}, events)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	goruntime "runtime"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Topology keys reported by nodes. They are well-known node labels, so topologies of volumes can be
+// matched against nodes without extra labels.
+const (
+	topologyKeyHostname = "kubernetes.io/hostname"
+	topologyKeyOS       = "kubernetes.io/os"
+	topologyKeyArch     = "kubernetes.io/arch"
+)
+
+// nodeTopology returns the topology of the node the driver runs on.
+func nodeTopology(nodeID string) *csi.Topology {
+	return &csi.Topology{
+		Segments: map[string]string{
+			topologyKeyHostname: nodeID,
+			topologyKeyOS:       goruntime.GOOS,
+			topologyKeyArch:     goruntime.GOARCH,
+		},
+	}
+}
+
+// platformTopologies returns a topology for each distinct OS and architecture of the given platforms.
+// Variants are ignored since nodes don't report them.
+func platformTopologies(supported []ocispec.Platform) []*csi.Topology {
+	var topologies []*csi.Topology
+	seen := make(map[string]bool)
+	for _, p := range supported {
+		key := p.OS + "/" + p.Architecture
+		if seen[key] {
+			continue
+		}
+
+		seen[key] = true
+		topologies = append(topologies, &csi.Topology{
+			Segments: map[string]string{
+				topologyKeyOS:   p.OS,
+				topologyKeyArch: p.Architecture,
+			},
+		})
+	}
+
+	return topologies
+}
+
+// checkAccessibility returns ResourceExhausted if none of the topologies required by the provisioner is
+// covered by the given topologies, which makes the scheduler pick another node for pods of the PVC.
+func checkAccessibility(requirement *csi.TopologyRequirement, topologies []*csi.Topology) error {
+	if len(topologies) == 0 || requirement == nil {
+		return nil
+	}
+
+	required := requirement.GetRequisite()
+	if len(required) == 0 {
+		required = requirement.GetPreferred()
+	}
+
+	if len(required) == 0 {
+		return nil
+	}
+
+	var segments []map[string]string
+	for _, r := range required {
+		for _, t := range topologies {
+			if coversTopology(r, t) {
+				return nil
+			}
+		}
+
+		segments = append(segments, r.Segments)
+	}
+
+	return status.Errorf(codes.ResourceExhausted, "the image doesn't support platforms of topologies %v", segments)
+}
+
+// coversTopology returns true if segments of the required topology matches all segments of t they share.
+func coversTopology(required, t *csi.Topology) bool {
+	for k, v := range t.Segments {
+		if rv, found := required.Segments[k]; found && rv != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// nodeCapacity returns the largest allocatable ephemeral storage of nodes which match all segments of the
+// topology. Images are stored on the node filesystem unless kubelet is configured with a dedicated imagefs,
+// whose capacity isn't exposed by nodes.
+func (c ControllerServer) nodeCapacity(ctx context.Context, topology *csi.Topology) (int64, error) {
+	if c.kubeClient == nil {
+		return 0, status.Error(codes.FailedPrecondition, "the controller doesn't have a Kubernetes client")
+	}
+
+	nodes, err := c.kubeClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(topology.GetSegments()).String(),
+	})
+	if err != nil {
+		return 0, status.Errorf(codes.Unavailable, "unable to list nodes: %s", err)
+	}
+
+	var capacity int64
+	for _, node := range nodes.Items {
+		storage, found := node.Status.Allocatable[corev1.ResourceEphemeralStorage]
+		if found && storage.Value() > capacity {
+			capacity = storage.Value()
+		}
+	}
+
+	return capacity, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+	cri "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/klog/v2"
+)
+
+// hostRoot is the root filesystem of the host, which is visible via /proc of the host mounted to /host/proc.
+const hostRoot = "/host/proc/1/root"
+
+// maxVolumesPerNode returns the maximum number of volumes kubelet allows on the node, which is limit if it is
+// set, or otherwise derived from the size of the image filesystem assuming each volume takes bytesPerVolume.
+// 0 means unlimited.
+func maxVolumesPerNode(ctx context.Context, imageSvc cri.ImageServiceClient, limit, bytesPerVolume int64) int64 {
+	if limit > 0 || bytesPerVolume <= 0 {
+		return limit
+	}
+
+	size, err := imageFsSize(ctx, imageSvc)
+	if err != nil {
+		klog.Warningf("unable to derive the volume limit from the image filesystem, the limit is disabled: %s", err)
+		return 0
+	}
+
+	limit = max(size/bytesPerVolume, 1)
+	klog.Infof("allow %d volumes on the node with the image filesystem of %d bytes", limit, size)
+	return limit
+}
+
+// imageFsSize returns the size of the largest image filesystem reported by the container runtime.
+func imageFsSize(ctx context.Context, imageSvc cri.ImageServiceClient) (int64, error) {
+	resp, err := imageSvc.ImageFsInfo(ctx, &cri.ImageFsInfoRequest{})
+	if err != nil {
+		return 0, fmt.Errorf("unable to fetch image filesystems: %w", err)
+	}
+
+	var size int64
+	for _, fs := range resp.ImageFilesystems {
+		if fs.FsId == nil || fs.FsId.Mountpoint == "" {
+			continue
+		}
+
+		var stat unix.Statfs_t
+		if err = unix.Statfs(filepath.Join(hostRoot, fs.FsId.Mountpoint), &stat); err != nil {
+			return 0, fmt.Errorf("unable to stat image filesystem %s: %w", fs.FsId.Mountpoint, err)
+		}
+
+		size = max(size, int64(stat.Blocks)*stat.Bsize)
+	}
+
+	if size == 0 {
+		return 0, fmt.Errorf("the container runtime doesn't report image filesystems")
+	}
+
+	return size, nil
+}
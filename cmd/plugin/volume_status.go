@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+	"github.com/warm-metal/container-image-csi-driver/pkg/remoteimage"
+	"github.com/warm-metal/container-image-csi-driver/pkg/watcher"
+	"k8s.io/klog/v2"
+)
+
+// volumeStatus returns the status of image volumes of the node: images of mounted volumes with their digests and
+// numbers of volumes, and images cached by the driver with their sizes if the mounter can measure them.
+func (n NodeServer) volumeStatus(ctx context.Context) watcher.NodeImageVolumeStatus {
+	status := watcher.NodeImageVolumeStatus{MountedImages: []watcher.MountedImage{}}
+	sizer, _ := n.mounter.(backend.ImageSizer)
+	sizes := make(map[string]backend.ImageSize)
+	for _, image := range n.localImages.list() {
+		namedRef, err := reference.ParseNormalizedNamed(image)
+		if err != nil || !n.mounter.ImageExists(ctx, namedRef) {
+			continue
+		}
+
+		status.CachedImages++
+		if sizer == nil {
+			continue
+		}
+
+		size, err := sizer.ImageSize(ctx, namedRef)
+		if err != nil {
+			klog.V(2).Infof("unable to measure image %q: %s", image, err)
+			continue
+		}
+
+		sizes[image] = size
+		status.CachedCompressedBytes += size.Compressed
+		status.CachedUnpackedBytes += size.Unpacked
+	}
+
+	inspector, ok := n.mounter.(backend.StateInspector)
+	if !ok {
+		return status
+	}
+
+	volumes := make(map[string]int)
+	for _, v := range inspector.InspectState().Volumes {
+		volumes[v.Image]++
+	}
+
+	for image, count := range volumes {
+		mounted := watcher.MountedImage{Image: image, Volumes: count}
+		if namedRef, err := reference.ParseNormalizedNamed(image); err == nil {
+			if digest, err := remoteimage.LocalDigest(ctx, n.imageSvc, namedRef); err == nil {
+				mounted.Digest = digest.String()
+			}
+		}
+
+		if size, found := sizes[image]; found {
+			mounted.CompressedBytes, mounted.UnpackedBytes = size.Compressed, size.Unpacked
+		}
+
+		status.MountedImages = append(status.MountedImages, mounted)
+	}
+
+	sort.Slice(status.MountedImages, func(i, j int) bool {
+		return status.MountedImages[i].Image < status.MountedImages[j].Image
+	})
+	return status
+}
+
+// ReportVolumeStatus reports the status of image volumes of the node via its NodeImageVolumeStatus every period if
+// it changed, so that admins can query image volumes of nodes with kubectl.
+func (n NodeServer) ReportVolumeStatus(ctx context.Context, reporter *watcher.NodeImageVolumeStatuses,
+	period time.Duration) {
+	node := n.driver.GetNodeID()
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	var last *watcher.NodeImageVolumeStatus
+	for {
+		status := n.volumeStatus(ctx)
+		if last == nil || !reflect.DeepEqual(status, *last) {
+			if err := reporter.Report(ctx, node, status); err != nil {
+				klog.Errorf("unable to report the image volume status of the node: %s", err)
+			} else {
+				klog.V(2).Infof("reported %d mounted images of the node", len(status.MountedImages))
+				last = &status
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/distribution/reference"
+	"github.com/stretchr/testify/assert"
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+	fakeruntime "github.com/warm-metal/container-image-csi-driver/pkg/fake"
+	"github.com/warm-metal/container-image-csi-driver/pkg/remoteimage"
+	"github.com/warm-metal/container-image-csi-driver/pkg/watcher"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+func TestVolumeStatus(t *testing.T) {
+	images := fakeruntime.NewImageService()
+	mounter := fakeruntime.NewMounter(images)
+	ns := newTestNodeServer(t, testNodeOptions{images: images, mounter: mounter})
+
+	ctx := context.Background()
+	for _, image := range []string{"docker.io/library/redis:latest", "docker.io/library/nginx:latest"} {
+		_, err := images.PullImage(ctx, &criapi.PullImageRequest{Image: &criapi.ImageSpec{Image: image}})
+		assert.NoError(t, err)
+		ns.localImages.add(image)
+	}
+	ns.localImages.add("docker.io/library/removed:latest")
+
+	namedRef, err := reference.ParseDockerRef("docker.io/library/redis:latest")
+	assert.NoError(t, err)
+	assert.NoError(t, mounter.Mount(ctx, "vol-1", "/target-1", namedRef, backend.MountOptions{}))
+	assert.NoError(t, mounter.Mount(ctx, "vol-2", "/target-2", namedRef, backend.MountOptions{}))
+	digest, err := remoteimage.LocalDigest(ctx, images, namedRef)
+	assert.NoError(t, err)
+
+	status := ns.volumeStatus(ctx)
+	assert.Equal(t, []watcher.MountedImage{{
+		Image:           "docker.io/library/redis:latest",
+		Digest:          digest.String(),
+		Volumes:         2,
+		CompressedBytes: 100 << 20,
+		UnpackedBytes:   200 << 20,
+	}}, status.MountedImages)
+	assert.Equal(t, 2, status.CachedImages)
+	assert.Equal(t, int64(200<<20), status.CachedCompressedBytes)
+	assert.Equal(t, int64(400<<20), status.CachedUnpackedBytes)
+}
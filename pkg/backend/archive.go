@@ -0,0 +1,99 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/warm-metal/container-image-csi-driver/pkg/errorlog"
+	"k8s.io/klog/v2"
+)
+
+// ScratchArchiver is implemented by runtimes which can save the writable layer of a read-write snapshot
+// into a tarball and restore it. Only these runtimes support volume snapshots.
+type ScratchArchiver interface {
+	// ExportScratch saves the writable layer of the snapshot into the tarball at path, which is on the host.
+	ExportScratch(ctx context.Context, key SnapshotKey, path string) error
+
+	// ImportScratch extracts the tarball at path into the empty writable layer of the snapshot.
+	ImportScratch(ctx context.Context, key SnapshotKey, path string) error
+}
+
+// ScratchSaver is implemented by mounters which can save persistent writable layers of volumes.
+type ScratchSaver interface {
+	// SaveScratch saves the persistent writable layer of the volume into the tarball at path.
+	// ErrNoScratch is returned if the volume doesn't have a persistent writable layer on this node.
+	SaveScratch(ctx context.Context, volumeId, path string) error
+}
+
+// ErrNoScratch means the volume doesn't have a persistent writable layer on this node.
+var ErrNoScratch = errors.New("persistent writable layer not found")
+
+// SaveScratch saves the persistent writable layer of the volume into a tarball. The tarball is written to a
+// temporary file first, so that partial tarballs are never restored.
+func (s *SnapshotMounter) SaveScratch(ctx context.Context, volumeId, path string) error {
+	archiver, ok := s.runtime.(ScratchArchiver)
+	if !ok {
+		return fmt.Errorf("the container runtime doesn't support volume snapshots")
+	}
+
+	key, err := s.scratchOf(ctx, volumeId)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	klog.Infof("save the writable layer %q of volume %q to %q", key, volumeId, path)
+	if err := archiver.ExportScratch(ctx, key, tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("unable to save the writable layer of volume %q: %w", volumeId, err)
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// scratchOf returns the key of the persistent writable layer of the volume, or ErrNoScratch if the volume
+// doesn't have one on this node.
+func (s *SnapshotMounter) scratchOf(ctx context.Context, volumeId string) (SnapshotKey, error) {
+	key := GenScratchKey(volumeId)
+	if !s.runtime.SnapshotExists(ctx, key) {
+		return "", ErrNoScratch
+	}
+
+	return key, nil
+}
+
+// prepareRestoredScratch creates the persistent scratch layer with the given key and seeds it with the
+// tarball in MountOptions.SnapshotSource, which must be on the same node.
+func (s *SnapshotMounter) prepareRestoredScratch(
+	ctx context.Context, imageID string, key SnapshotKey, opts MountOptions,
+) error {
+	archiver, ok := s.runtime.(ScratchArchiver)
+	if !ok {
+		return fmt.Errorf("the container runtime doesn't support volume snapshots")
+	}
+
+	if _, err := os.Stat(opts.SnapshotSource); err != nil {
+		return fmt.Errorf("volume snapshot isn't found on this node: %w", err)
+	}
+
+	if err := s.runtime.PrepareRWSnapshot(ctx, imageID, key, nil, opts); err != nil {
+		return err
+	}
+
+	klog.Infof("restore the writable layer %q from %q", key, opts.SnapshotSource)
+	if err := archiver.ImportScratch(ctx, key, opts.SnapshotSource); err != nil {
+		if destroyErr := s.runtime.DestroySnapshot(ctx, key); destroyErr != nil {
+			errorlog.Errorf("unable to remove the restored snapshot %q: %s", key, destroyErr)
+		}
+		return fmt.Errorf("unable to restore volume snapshot %q: %w", opts.SnapshotSource, err)
+	}
+
+	return nil
+}
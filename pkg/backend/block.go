@@ -0,0 +1,327 @@
+package backend
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/warm-metal/container-image-csi-driver/pkg/errorlog"
+	"github.com/warm-metal/container-image-csi-driver/pkg/sandbox"
+	"k8s.io/klog/v2"
+)
+
+// LoopDeviceManager is implemented by runtimes which can attach files as loop devices in the mount
+// namespace they mount volumes in. Only these runtimes support block volumes.
+type LoopDeviceManager interface {
+	// AttachLoopDevice attaches the file as a read-only loop device and returns the path of the device.
+	AttachLoopDevice(ctx context.Context, file string) (string, error)
+
+	// DetachLoopDevice detaches the loop device.
+	DetachLoopDevice(ctx context.Context, device string) error
+}
+
+// blockVolume is saved for each published block volume, so that it can be unpublished after restarts.
+type blockVolume struct {
+	Image  string `json:"image"`
+	Device string `json:"device"`
+	// HashDevice and VerityDevice are only set if the volume is protected by dm-verity.
+	HashDevice   string `json:"hashDevice,omitempty"`
+	VerityDevice string `json:"verityDevice,omitempty"`
+}
+
+// EnableBlockVolumes enables block volumes if the runtime supports them. Images of block volumes are
+// cached in dir, which must be at the same path on the host and in the driver, until the cache exceeds
+// cacheSize bytes. 0 means unlimited.
+func (s *SnapshotMounter) EnableBlockVolumes(dir string, cacheSize int64) {
+	loopDevices, ok := s.runtime.(LoopDeviceManager)
+	if !ok {
+		klog.Warningf("the container runtime doesn't support block volumes")
+		return
+	}
+
+	for _, subDir := range []string{"images", "volumes"} {
+		if err := os.MkdirAll(filepath.Join(dir, subDir), 0o700); err != nil {
+			klog.Fatalf("unable to create the directory for block volumes: %s", err)
+		}
+	}
+
+	s.blockDir = dir
+	s.blockImages = &blockImageCache{dir: filepath.Join(dir, "images")}
+	s.blockImages.maxSize.Store(cacheSize)
+	s.loopDevices = loopDevices
+	s.verityDevices, _ = s.runtime.(VerityDeviceManager)
+}
+
+// SetBlockCacheSize changes the size of the cache of images of block volumes, which applies from the next eviction,
+// when a block volume is published or unpublished.
+func (s *SnapshotMounter) SetBlockCacheSize(cacheSize int64) {
+	if s.blockImages != nil {
+		s.blockImages.maxSize.Store(cacheSize)
+	}
+}
+
+// blockVolumeOf returns the file saving the block volume published to the target.
+func (s *SnapshotMounter) blockVolumeOf(target MountTarget) string {
+	return filepath.Join(s.blockDir, "volumes", fmt.Sprintf("%x.json", sha256.Sum256([]byte(target))))
+}
+
+// isBlockVolume checks whether a block volume is published to the target.
+func (s *SnapshotMounter) isBlockVolume(target MountTarget) bool {
+	if s.loopDevices == nil {
+		return false
+	}
+
+	_, err := os.Stat(s.blockVolumeOf(target))
+	return err == nil
+}
+
+// blockImagesInUse returns images of all published block volumes.
+func (s *SnapshotMounter) blockImagesInUse() map[string]struct{} {
+	files, err := filepath.Glob(filepath.Join(s.blockDir, "volumes", "*.json"))
+	if err != nil {
+		errorlog.Errorf("unable to list block volumes: %s", err)
+	}
+
+	images := make(map[string]struct{}, len(files))
+	for _, file := range files {
+		if v, err := readBlockVolume(file); err == nil {
+			images[v.Image] = struct{}{}
+		}
+	}
+
+	return images
+}
+
+func readBlockVolume(file string) (*blockVolume, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &blockVolume{}
+	if err = json.Unmarshal(data, v); err != nil {
+		return nil, fmt.Errorf("invalid block volume %s: %w", file, err)
+	}
+
+	return v, nil
+}
+
+// mountBlock binds a loop device of the image of MountOptions.BlockFormat packed from the snapshots to
+// the target. The image is taken from the cache, or packed from the rootfs, or the directory in
+// MountOptions.Path, if not cached. It doesn't depend on the snapshots once it is created.
+func (s *SnapshotMounter) mountBlock(
+	ctx context.Context, keys []SnapshotKey, target MountTarget, opts MountOptions,
+) (err error) {
+	if s.loopDevices == nil {
+		return fmt.Errorf("block volumes are not enabled")
+	}
+
+	if !opts.ReadOnly {
+		return fmt.Errorf("block volumes must be read-only")
+	}
+
+	if opts.Verity && s.verityDevices == nil {
+		return fmt.Errorf("the container runtime doesn't support dm-verity")
+	}
+
+	image, err := s.blockImages.get(blockImageKey(keys, opts), func(image string) error {
+		return s.packSnapshots(ctx, keys, image, opts)
+	})
+	if err != nil {
+		return err
+	}
+
+	v := &blockVolume{Image: image}
+	defer func() {
+		if err != nil {
+			s.detachBlockVolume(ctx, v)
+			os.Remove(s.blockVolumeOf(target))
+		}
+	}()
+
+	if v.Device, err = s.loopDevices.AttachLoopDevice(ctx, image); err != nil {
+		return fmt.Errorf("unable to attach %q as a loop device: %w", image, err)
+	}
+
+	device := v.Device
+	if opts.Verity {
+		if device, err = s.openVerity(ctx, v, target); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if err = os.WriteFile(s.blockVolumeOf(target), data, 0o600); err != nil {
+		return err
+	}
+
+	if err = fileTarget(target); err != nil {
+		return err
+	}
+
+	klog.Infof("bind device %q of image %q to %q", device, image, target)
+	if err = s.runtime.Bind(ctx, device, target, opts); err != nil {
+		return err
+	}
+
+	s.blockImages.evict(s.blockImagesInUse())
+	return nil
+}
+
+// openVerity attaches the hash tree of the image of the block volume as a loop device, then maps the
+// verity device of the volume. It returns the path of the verity device.
+func (s *SnapshotMounter) openVerity(ctx context.Context, v *blockVolume, target MountTarget) (string, error) {
+	hashTree, rootHash, err := s.verityHashTree(ctx, v.Image)
+	if err != nil {
+		return "", fmt.Errorf("unable to create the verity hash tree of %q: %w", v.Image, err)
+	}
+
+	if v.HashDevice, err = s.loopDevices.AttachLoopDevice(ctx, hashTree); err != nil {
+		return "", fmt.Errorf("unable to attach %q as a loop device: %w", hashTree, err)
+	}
+
+	name := verityDeviceName(target)
+	device, err := s.verityDevices.OpenVerity(ctx, name, v.Device, v.HashDevice, rootHash)
+	if err != nil {
+		return "", err
+	}
+
+	v.VerityDevice = name
+	return device, nil
+}
+
+// detachBlockVolume releases devices of a block volume which failed to be published.
+func (s *SnapshotMounter) detachBlockVolume(ctx context.Context, v *blockVolume) {
+	if v.VerityDevice != "" {
+		if err := s.verityDevices.CloseVerity(ctx, v.VerityDevice); err != nil {
+			errorlog.Errorf("unable to close verity device %q: %s", v.VerityDevice, err)
+		}
+	}
+
+	for _, device := range []string{v.HashDevice, v.Device} {
+		if device == "" {
+			continue
+		}
+
+		if err := s.loopDevices.DetachLoopDevice(ctx, device); err != nil {
+			errorlog.Errorf("unable to detach loop device %q: %s", device, err)
+		}
+	}
+}
+
+// unmountBlock detaches the loop device of the block volume published to the target. The image is
+// kept in the cache.
+func (s *SnapshotMounter) unmountBlock(ctx context.Context, target MountTarget) error {
+	file := s.blockVolumeOf(target)
+	v, err := readBlockVolume(file)
+	if err != nil {
+		return err
+	}
+
+	if v.VerityDevice != "" {
+		if err = s.verityDevices.CloseVerity(ctx, v.VerityDevice); err != nil {
+			return err
+		}
+	}
+
+	for _, device := range []string{v.HashDevice, v.Device} {
+		if device == "" {
+			continue
+		}
+
+		if err = s.loopDevices.DetachLoopDevice(ctx, device); err != nil {
+			return err
+		}
+	}
+
+	if err = os.Remove(file); err != nil {
+		return err
+	}
+
+	s.blockImages.evict(s.blockImagesInUse())
+	return nil
+}
+
+// packSnapshots mounts the snapshots to a staging directory next to the image, then packs them into the image.
+func (s *SnapshotMounter) packSnapshots(
+	ctx context.Context, keys []SnapshotKey, image string, opts MountOptions,
+) error {
+	staging := MountTarget(strings.TrimSuffix(image, filepath.Ext(image)) + ".rootfs")
+	if err := os.MkdirAll(string(staging), 0o755); err != nil {
+		return err
+	}
+
+	if err := s.mountSnapshots(ctx, keys, staging, opts); err != nil {
+		os.Remove(string(staging))
+		return err
+	}
+
+	err := packRootfs(ctx, string(staging), image, opts)
+	if umountErr := s.unmountAndRemoveDir(ctx, staging); umountErr != nil {
+		errorlog.Errorf("unable to unmount the image rootfs at %q: %s", staging, umountErr)
+	}
+
+	return err
+}
+
+// packRootfs packs the rootfs into a filesystem image, or extracts the VM disk in it for BlockFormatDisk.
+// The image is created in a temporary file first, so that a partial image is never taken as a complete one.
+func packRootfs(ctx context.Context, rootfs, image string, opts MountOptions) error {
+	source := rootfs
+	if opts.Path != "" {
+		var err error
+		if source, err = securejoin.SecureJoin(rootfs, opts.Path); err != nil {
+			return fmt.Errorf("unable to resolve path %q in the image: %w", opts.Path, err)
+		}
+
+		fi, err := os.Stat(source)
+		if err != nil {
+			return fmt.Errorf("path %q is not found in the image: %w", opts.Path, err)
+		}
+
+		if !fi.IsDir() && opts.BlockFormat != BlockFormatDisk {
+			return fmt.Errorf("path %q in the image is not a directory", opts.Path)
+		}
+	} else if opts.BlockFormat == BlockFormatDisk {
+		var err error
+		if source, err = securejoin.SecureJoin(rootfs, ContainerDiskDir); err != nil {
+			return err
+		}
+	}
+
+	tmp := image + ".tmp"
+	var cmd *exec.Cmd
+	switch opts.BlockFormat {
+	case FSTypeSquashfs:
+		cmd = sandbox.Tools.CommandContext(ctx, "mksquashfs", source, tmp, "-noappend", "-no-progress")
+	case FSTypeEROFS:
+		cmd = sandbox.Tools.CommandContext(ctx, "mkfs.erofs", tmp, source)
+	case BlockFormatDisk:
+		if err := extractDisk(ctx, source, tmp); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+
+		return os.Rename(tmp, image)
+	default:
+		return fmt.Errorf("unsupported block format %q", opts.BlockFormat)
+	}
+
+	klog.Infof("pack %q into %s image %q", source, opts.BlockFormat, image)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("unable to create %s image: %w, output: %s", opts.BlockFormat, err, output)
+	}
+
+	return os.Rename(tmp, image)
+}
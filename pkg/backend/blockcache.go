@@ -0,0 +1,138 @@
+package backend
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/warm-metal/container-image-csi-driver/pkg/errorlog"
+	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
+	"k8s.io/klog/v2"
+)
+
+// blockImageCache keeps images of block volumes on the node, so that an image is packed only once and
+// shared by all volumes of it. The least recently used images are evicted once the cache exceeds its
+// size, while images in use are always kept.
+type blockImageCache struct {
+	// guard serializes packing, so that an image is never packed twice at the same time.
+	guard   sync.Mutex
+	dir     string
+	maxSize atomic.Int64
+}
+
+// blockImageKey identifies the image packed from the snapshots with the given options. Keys of read-only
+// snapshots are derived from image IDs, so images of the same image digest share the key.
+func blockImageKey(keys []SnapshotKey, opts MountOptions) string {
+	h := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(h, "%s\n", key)
+	}
+	fmt.Fprintf(h, "%s\n%s\n", opts.Path, opts.BlockFormat)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// get returns the cached image with the given key. If the image isn't cached, create is called to
+// create it at the returned path.
+func (c *blockImageCache) get(key string, create func(image string) error) (string, error) {
+	c.guard.Lock()
+	defer c.guard.Unlock()
+
+	image := filepath.Join(c.dir, key+".img")
+	if _, err := os.Stat(image); err == nil {
+		klog.Infof("use cached image %q", image)
+		metrics.BlockImageCacheCount.WithLabelValues("hit").Inc()
+		// The modification time tracks the last use of the image.
+		now := time.Now()
+		if err = os.Chtimes(image, now, now); err != nil {
+			klog.Warningf("unable to update the last use of image %q: %s", image, err)
+		}
+		return image, nil
+	}
+
+	metrics.BlockImageCacheCount.WithLabelValues("miss").Inc()
+	if err := create(image); err != nil {
+		return "", err
+	}
+
+	return image, nil
+}
+
+// withGuard runs f while no images are packed or evicted.
+func (c *blockImageCache) withGuard(f func() error) error {
+	c.guard.Lock()
+	defer c.guard.Unlock()
+	return f()
+}
+
+// evict removes the least recently used images, except those in use, until the cache fits its size.
+func (c *blockImageCache) evict(inUse map[string]struct{}) {
+	maxSize := c.maxSize.Load()
+	if maxSize <= 0 {
+		return
+	}
+
+	c.guard.Lock()
+	defer c.guard.Unlock()
+
+	images, err := filepath.Glob(filepath.Join(c.dir, "*.img"))
+	if err != nil {
+		errorlog.Errorf("unable to list cached images: %s", err)
+		return
+	}
+
+	var size int64
+	fis := make(map[string]os.FileInfo, len(images))
+	for _, image := range images {
+		fi, err := os.Stat(image)
+		if err != nil {
+			continue
+		}
+
+		fis[image] = fi
+		size += fi.Size()
+	}
+
+	sort.Slice(images, func(i, j int) bool {
+		fi, fj := fis[images[i]], fis[images[j]]
+		if fi == nil || fj == nil {
+			return fj != nil
+		}
+		return fi.ModTime().Before(fj.ModTime())
+	})
+
+	for _, image := range images {
+		if size <= maxSize {
+			return
+		}
+
+		fi := fis[image]
+		if fi == nil {
+			continue
+		}
+
+		if _, found := inUse[image]; found {
+			continue
+		}
+
+		if err := os.Remove(image); err != nil {
+			errorlog.Errorf("unable to evict image %q: %s", image, err)
+			continue
+		}
+
+		// Verity hash trees are only valid for the image they are created from.
+		os.Remove(image + ".roothash")
+		os.Remove(image + ".verity")
+		size -= fi.Size()
+		metrics.BlockImageCacheCount.WithLabelValues("evicted").Inc()
+		klog.Infof("evicted image %q of %d bytes", image, fi.Size())
+	}
+
+	if size > maxSize {
+		klog.Warningf("images in use take %d bytes, more than the cache size %d", size, maxSize)
+	}
+}
@@ -0,0 +1,47 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/warm-metal/container-image-csi-driver/pkg/errorlog"
+	"k8s.io/klog/v2"
+)
+
+// ScratchCloner is implemented by runtimes which can copy the writable layer of a read-write snapshot
+// into another one. Only these runtimes support cloning volumes.
+type ScratchCloner interface {
+	// CloneScratch copies the writable layer of the snapshot source into the empty writable layer of
+	// the snapshot target. Both snapshots must be created from the same image.
+	CloneScratch(ctx context.Context, source, target SnapshotKey) error
+}
+
+// prepareClonedScratch creates the persistent scratch layer with the given key and seeds it with the
+// persistent scratch layer of the volume in MountOptions.CloneSource, which must be on the same node.
+func (s *SnapshotMounter) prepareClonedScratch(
+	ctx context.Context, imageID string, key SnapshotKey, opts MountOptions,
+) error {
+	cloner, ok := s.runtime.(ScratchCloner)
+	if !ok {
+		return fmt.Errorf("the container runtime doesn't support cloning volumes")
+	}
+
+	source := GenScratchKey(opts.CloneSource)
+	if !s.runtime.SnapshotExists(ctx, source) {
+		return fmt.Errorf("source volume %q doesn't have a persistent writable layer on this node", opts.CloneSource)
+	}
+
+	if err := s.runtime.PrepareRWSnapshot(ctx, imageID, key, nil, opts); err != nil {
+		return err
+	}
+
+	klog.Infof("clone the writable layer of volume %q from %q to %q", opts.CloneSource, source, key)
+	if err := cloner.CloneScratch(ctx, source, key); err != nil {
+		if destroyErr := s.runtime.DestroySnapshot(ctx, key); destroyErr != nil {
+			errorlog.Errorf("unable to remove the cloned snapshot %q: %s", key, destroyErr)
+		}
+		return fmt.Errorf("unable to clone volume %q: %w", opts.CloneSource, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/distribution/reference"
+	digest "github.com/opencontainers/go-digest"
+	"k8s.io/klog/v2"
+)
+
+// ScratchCommitter is implemented by runtimes which can commit the writable layer of a read-write snapshot
+// on top of the image it is created from as a new image, and push the image.
+type ScratchCommitter interface {
+	// CommitScratch commits the writable layer of the snapshot as a new layer of its image, and pushes
+	// the new image to target via resolver. The digest of the pushed manifest is returned.
+	CommitScratch(
+		ctx context.Context, key SnapshotKey, target reference.Named, resolver remotes.Resolver,
+	) (digest.Digest, error)
+}
+
+// VolumeCommitter is implemented by mounters which can commit persistent writable layers of volumes as images.
+type VolumeCommitter interface {
+	// CommitVolume commits the persistent writable layer of the volume as a new image and pushes it to target.
+	// ErrNoScratch is returned if the volume doesn't have a persistent writable layer on this node.
+	CommitVolume(
+		ctx context.Context, volumeId string, target reference.Named, resolver remotes.Resolver,
+	) (digest.Digest, error)
+}
+
+func (s *SnapshotMounter) CommitVolume(
+	ctx context.Context, volumeId string, target reference.Named, resolver remotes.Resolver,
+) (digest.Digest, error) {
+	committer, ok := s.runtime.(ScratchCommitter)
+	if !ok {
+		return "", fmt.Errorf("the container runtime doesn't support committing volumes")
+	}
+
+	key, err := s.scratchOf(ctx, volumeId)
+	if err != nil {
+		return "", err
+	}
+
+	klog.Infof("commit the writable layer %q of volume %q to %q", key, volumeId, target)
+	dgst, err := committer.CommitScratch(ctx, key, target, resolver)
+	if err != nil {
+		return "", fmt.Errorf("unable to commit volume %q to %q: %w", volumeId, target, err)
+	}
+
+	return dgst, nil
+}
@@ -0,0 +1,47 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+	"github.com/warm-metal/container-image-csi-driver/pkg/sandbox"
+	"k8s.io/klog/v2"
+)
+
+// tarXattrFlags keep overlay xattrs and whiteouts in tarballs of upperdirs, so that deletions are restored as well.
+var tarXattrFlags = []string{"--xattrs", "--xattrs-include=*", "--numeric-owner"}
+
+// ExportScratch archives the upperdir of the snapshot in the host mount namespace.
+func (s snapshotMounter) ExportScratch(ctx context.Context, key backend.SnapshotKey, path string) error {
+	upper, _, err := s.upperdirOf(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	args := append([]string{"--mount=" + hostMountNS, "--", "tar"}, tarXattrFlags...)
+	cmd := sandbox.Tools.CommandContext(ctx, "nsenter", append(args, "-C", upper, "-cpf", path, ".")...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("unable to archive %s to %s: %w, output: %s", upper, path, err, output)
+	}
+
+	klog.V(4).Infof("archived %s to %s", upper, path)
+	return nil
+}
+
+// ImportScratch extracts the tarball into the upperdir of the snapshot in the host mount namespace.
+func (s snapshotMounter) ImportScratch(ctx context.Context, key backend.SnapshotKey, path string) error {
+	upper, _, err := s.upperdirOf(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	args := append([]string{"--mount=" + hostMountNS, "--", "tar"}, tarXattrFlags...)
+	cmd := sandbox.Tools.CommandContext(ctx, "nsenter", append(args, "-C", upper, "-xpf", path)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("unable to extract %s to %s: %w, output: %s", path, upper, err, output)
+	}
+
+	klog.V(4).Infof("extracted %s to %s", path, upper)
+	return nil
+}
@@ -0,0 +1,62 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+	"github.com/warm-metal/container-image-csi-driver/pkg/sandbox"
+	"k8s.io/klog/v2"
+)
+
+// CloneScratch copies the upperdir of the source snapshot into the upperdir of the target snapshot in the
+// host mount namespace. Whiteouts and overlay xattrs are preserved, so that deletions are cloned as well.
+func (s snapshotMounter) CloneScratch(ctx context.Context, source, target backend.SnapshotKey) error {
+	sourceUpper, sourceParent, err := s.upperdirOf(ctx, source)
+	if err != nil {
+		return err
+	}
+
+	targetUpper, targetParent, err := s.upperdirOf(ctx, target)
+	if err != nil {
+		return err
+	}
+
+	if sourceParent != targetParent {
+		return fmt.Errorf("snapshot %q is based on %q rather than %q", source, sourceParent, targetParent)
+	}
+
+	cmd := sandbox.Tools.CommandContext(ctx,
+		"nsenter", "--mount="+hostMountNS, "--",
+		"cp", "-a", sourceUpper+"/.", targetUpper)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("unable to copy %s to %s: %w, output: %s", sourceUpper, targetUpper, err, output)
+	}
+
+	klog.V(4).Infof("copied %s to %s", sourceUpper, targetUpper)
+	return nil
+}
+
+// upperdirOf returns the upperdir and the parent of a read-write snapshot.
+func (s snapshotMounter) upperdirOf(ctx context.Context, key backend.SnapshotKey) (upper, parent string, err error) {
+	snapshotter, err := s.snapshotterOf(ctx, key)
+	if err != nil {
+		return "", "", err
+	}
+
+	info, err := snapshotter.Stat(ctx, string(key))
+	if err != nil {
+		return "", "", err
+	}
+
+	mounts, err := snapshotter.Mounts(ctx, string(key))
+	if err != nil {
+		return "", "", err
+	}
+
+	if upper = overlayDir(mounts, "upperdir"); upper == "" {
+		return "", "", fmt.Errorf("snapshot %q isn't an overlay and can't be cloned", key)
+	}
+
+	return upper, info.Parent, nil
+}
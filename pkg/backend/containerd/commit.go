@@ -0,0 +1,155 @@
+package containerd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/containerd/containerd/v2/pkg/rootfs"
+	"github.com/distribution/reference"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/identity"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+	"k8s.io/klog/v2"
+)
+
+// CommitScratch diffs the snapshot against its parent via the containerd diff service, then pushes the image the
+// snapshot is created from with the diff appended as its topmost layer. Layers of the image must be kept in the
+// content store, or they can't be pushed.
+func (s snapshotMounter) CommitScratch(
+	ctx context.Context, key backend.SnapshotKey, target reference.Named, resolver remotes.Resolver,
+) (digest.Digest, error) {
+	snapshotter, err := s.snapshotterOf(ctx, key)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := snapshotter.Stat(ctx, string(key))
+	if err != nil {
+		return "", err
+	}
+
+	img, err := s.imageOfChain(ctx, info.Parent)
+	if err != nil {
+		return "", err
+	}
+
+	// Blobs written below are only referenced by the lease until the image is pushed.
+	ctx, done, err := s.cli.WithLease(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer done(ctx)
+
+	layer, err := rootfs.CreateDiff(ctx, string(key), snapshotter, s.cli.DiffService())
+	if err != nil {
+		return "", fmt.Errorf("unable to diff snapshot %q: %w", key, err)
+	}
+
+	cs := s.cli.ContentStore()
+	diffID, err := images.GetDiffID(ctx, cs, layer)
+	if err != nil {
+		return "", err
+	}
+
+	manifestDesc, err := platformManifest(ctx, img)
+	if err != nil {
+		return "", err
+	}
+
+	var manifest ocispec.Manifest
+	if err = readJSON(ctx, cs, manifestDesc, &manifest); err != nil {
+		return "", err
+	}
+
+	var config ocispec.Image
+	if err = readJSON(ctx, cs, manifest.Config, &config); err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	config.Created = &now
+	config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diffID)
+	config.History = append(config.History, ocispec.History{
+		Created:   &now,
+		CreatedBy: "container-image-csi-driver commit",
+		Comment:   fmt.Sprintf("writable layer of snapshot %s", key),
+	})
+
+	if manifest.Config, err = writeJSON(ctx, cs, manifest.Config.MediaType, config); err != nil {
+		return "", err
+	}
+
+	// Registries may reject Docker manifests with OCI layers.
+	if manifestDesc.MediaType == images.MediaTypeDockerSchema2Manifest {
+		layer.MediaType = images.MediaTypeDockerSchema2LayerGzip
+	}
+
+	manifest.Layers = append(manifest.Layers, layer)
+	desc, err := writeJSON(ctx, cs, manifestDesc.MediaType, manifest)
+	if err != nil {
+		return "", err
+	}
+
+	if err = s.cli.Push(ctx, target.String(), desc, client.WithResolver(resolver)); err != nil {
+		return "", fmt.Errorf("unable to push %q: %w", target, err)
+	}
+
+	klog.Infof("pushed snapshot %q on top of image %q to %q with digest %s", key, img.Name(), target, desc.Digest)
+	return desc.Digest, nil
+}
+
+// imageOfChain returns the local image unpacked to the snapshot chain.
+func (s snapshotMounter) imageOfChain(ctx context.Context, chainID string) (client.Image, error) {
+	imgs, err := s.cli.ListImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, img := range imgs {
+		diffIDs, err := img.RootFS(ctx)
+		if err != nil {
+			continue
+		}
+
+		if identity.ChainID(diffIDs).String() == chainID {
+			return img, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no image is unpacked to snapshot %q", chainID)
+}
+
+func readJSON(ctx context.Context, cs content.Store, desc ocispec.Descriptor, v interface{}) error {
+	data, err := content.ReadBlob(ctx, cs, desc)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %w", desc.Digest, err)
+	}
+
+	if err = json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid %s %s: %w", desc.MediaType, desc.Digest, err)
+	}
+
+	return nil
+}
+
+func writeJSON(ctx context.Context, cs content.Store, mediaType string, v interface{}) (ocispec.Descriptor, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	desc := ocispec.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(data), Size: int64(len(data))}
+	if err = content.WriteBlob(ctx, cs, desc.Digest.String(), bytes.NewReader(data), desc); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("unable to write %s: %w", desc.Digest, err)
+	}
+
+	return desc, nil
+}
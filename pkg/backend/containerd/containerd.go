@@ -5,35 +5,132 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"strings"
 	"time"
 
 	"github.com/containerd/containerd/v2/client"
 	"github.com/containerd/containerd/v2/core/mount"
 	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/plugins"
+	"github.com/containerd/errdefs"
 	"github.com/distribution/reference"
 	"github.com/opencontainers/image-spec/identity"
 	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+	"github.com/warm-metal/container-image-csi-driver/pkg/errorlog"
+	"github.com/warm-metal/container-image-csi-driver/pkg/sandbox"
 	"k8s.io/klog/v2"
 )
 
+// erofsSnapshotter is the name of the containerd snapshotter that unpacks layers as EROFS blobs.
+const erofsSnapshotter = "erofs"
+
 type snapshotMounter struct {
 	snapshotter snapshots.Snapshotter
-	cli         *client.Client
+	// defaultSnapshotter is the name of snapshotter, empty for the containerd default.
+	defaultSnapshotter string
+	// erofs is nil if the erofs snapshotter isn't loaded by containerd.
+	erofs snapshots.Snapshotter
+	cli   *client.Client
+	// overlayOptions are applied to overlay mounts of read-write volumes.
+	overlayOptions []string
+	// labels are set on all snapshots the driver creates, in addition to the GC root label.
+	labels map[string]string
 }
 
-func NewMounter(socketPath string) backend.Mounter {
+// NewMounter creates a mounter using the given snapshotter for image layers. An empty snapshotter means
+// the containerd default, overlayfs. Block-based snapshotters like devmapper are supported as well, which
+// mount the thin devices they activate for snapshots instead of overlay lowerdirs.
+// The overlay options, e.g. metacopy=on, xino=on, or volatile, are applied to writable overlay mounts.
+// The labels are set on all snapshots the driver creates, e.g. to keep them from GC policies of the deployment.
+func NewMounter(
+	socketPath string, snapshotter string, overlayOptions []string, labels map[string]string,
+) *backend.SnapshotMounter {
+	if err := validateOverlayOptions(overlayOptions); err != nil {
+		klog.Fatalf("invalid overlay options: %s", err)
+	}
+
 	c, err := client.New(socketPath, client.WithDefaultNamespace("k8s.io"))
 	if err != nil {
 		klog.Fatalf("containerd connection is broken because the mounted unix socket somehow dose not work,"+
 			"recreate the container may fix: %s", err)
 	}
 
-	return backend.NewMounter(&snapshotMounter{
-		snapshotter: c.SnapshotService(""),
-		cli:         c,
-	})
+	if snapshotter != "" && !snapshotterLoaded(c, snapshotter) {
+		klog.Fatalf("snapshotter %q is not loaded by containerd", snapshotter)
+	}
+
+	m := &snapshotMounter{
+		snapshotter:        c.SnapshotService(snapshotter),
+		defaultSnapshotter: snapshotter,
+		cli:                c,
+		overlayOptions:     overlayOptions,
+		labels:             labels,
+	}
+
+	if snapshotterLoaded(c, erofsSnapshotter) {
+		klog.Infof("snapshotter %q is available, enable %s volumes", erofsSnapshotter, backend.FSTypeEROFS)
+		m.erofs = c.SnapshotService(erofsSnapshotter)
+	}
+
+	return backend.NewMounter(m)
+}
+
+// snapshotterLoaded checks whether containerd loaded the named snapshotter plugin without errors.
+func snapshotterLoaded(c *client.Client, name string) bool {
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancel()
+
+	resp, err := c.IntrospectionService().Plugins(ctx,
+		fmt.Sprintf("type==%s,id==%s", plugins.SnapshotPlugin, name))
+	if err != nil {
+		errorlog.Errorf("unable to introspect snapshotter %q: %s", name, err)
+		return false
+	}
+
+	for _, p := range resp.Plugins {
+		if p.InitErr == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// snapshotterName returns the containerd snapshotter used for the given filesystem.
+func (s snapshotMounter) snapshotterName(fsType string) (string, error) {
+	switch fsType {
+	case "":
+		return s.defaultSnapshotter, nil
+	case backend.FSTypeEROFS:
+		return erofsSnapshotter, nil
+	default:
+		return "", fmt.Errorf("filesystem %q is not supported by containerd", fsType)
+	}
+}
+
+func (s snapshotMounter) snapshotterFor(fsType string) (snapshots.Snapshotter, error) {
+	switch fsType {
+	case "":
+		return s.snapshotter, nil
+	case backend.FSTypeEROFS:
+		if s.erofs == nil {
+			return nil, fmt.Errorf("snapshotter %q is not enabled in containerd", erofsSnapshotter)
+		}
+		return s.erofs, nil
+	default:
+		return nil, fmt.Errorf("filesystem %q is not supported by containerd", fsType)
+	}
+}
+
+// snapshotterOf finds the snapshotter which owns the snapshot with the given key.
+func (s snapshotMounter) snapshotterOf(ctx context.Context, key backend.SnapshotKey) (snapshots.Snapshotter, error) {
+	if s.erofs != nil {
+		if _, err := s.erofs.Stat(ctx, string(key)); err == nil {
+			return s.erofs, nil
+		}
+	}
+
+	return s.snapshotter, nil
 }
 
 // selinuxContext returns the configured SELinux mount context or a safe default.
@@ -64,12 +161,17 @@ func isSELinuxEnforcing() bool {
 // fd-based mount API (fsopen/fsconfig/fsmount) which returns EINVAL on kernel 6.12
 // (Bottlerocket 1.59) when the overlay lowerdir string exceeds ~256 chars. The legacy
 // mount(2) syscall is not affected. See docs/design/bottlerocket-1.59-overlay-regression.md.
-func mountInHostNamespace(ctx context.Context, mounts []mount.Mount, target string) error {
+//
+// A non-empty seLinuxContext, which is the one of the pod, takes precedence over the configured one.
+func mountInHostNamespace(ctx context.Context, mounts []mount.Mount, target string, seLinuxContext string) error {
 	// Compute SELinux enforcement once per mount operation.
-	enforcing := isSELinuxEnforcing()
+	enforcing := seLinuxContext != "" || isSELinuxEnforcing()
 	var contextOpt string
 	if enforcing {
-		contextOpt = fmt.Sprintf("context=\"%s\"", selinuxContext())
+		if seLinuxContext == "" {
+			seLinuxContext = selinuxContext()
+		}
+		contextOpt = fmt.Sprintf("context=\"%s\"", seLinuxContext)
 	}
 
 	for i, m := range mounts {
@@ -89,7 +191,7 @@ func mountInHostNamespace(ctx context.Context, mounts []mount.Mount, target stri
 		}
 
 		if err := syscallMountInHostNamespace(m.Source, target, m.Type, mountOptions); err != nil {
-			klog.Errorf("mount failed (attempt %d/%d): source=%s target=%s type=%s opts=%v err=%s",
+			errorlog.Errorf("mount failed (attempt %d/%d): source=%s target=%s type=%s opts=%v err=%s",
 				i+1, len(mounts), m.Source, target, m.Type, mountOptions, err)
 			return err
 		}
@@ -100,45 +202,122 @@ func mountInHostNamespace(ctx context.Context, mounts []mount.Mount, target stri
 	return nil
 }
 
+// mountFlagsOf returns the per-mount flags of the mount options.
+func mountFlagsOf(opts backend.MountOptions) []string {
+	flags := append([]string{}, opts.MountFlags...)
+	if opts.ReadOnly {
+		flags = append(flags, "ro")
+	}
+
+	return flags
+}
+
 // unmountInHostNamespace unmounts directly in the host mount namespace using nsenter
 func unmountInHostNamespace(ctx context.Context, target string) error {
-	cmd := exec.CommandContext(ctx,
+	cmd := sandbox.Tools.CommandContext(ctx,
 		"nsenter", "--mount=/host/proc/1/ns/mnt", "--",
 		"umount", target)
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		klog.Errorf("nsenter unmount failed: %s, output: %s", err, string(output))
+		errorlog.Errorf("nsenter unmount failed: %s, output: %s", err, string(output))
 		return fmt.Errorf("unmount failed: %w, output: %s", err, string(output))
 	}
 	klog.V(4).Infof("unmounted %s using nsenter", target)
 	return nil
 }
 
-func (s snapshotMounter) Mount(ctx context.Context, key backend.SnapshotKey, target backend.MountTarget, ro bool) error {
-	mounts, err := s.snapshotter.Mounts(ctx, string(key))
+func (s snapshotMounter) Mount(
+	ctx context.Context, key backend.SnapshotKey, target backend.MountTarget, opts backend.MountOptions,
+) error {
+	snapshotter, err := s.snapshotterFor(opts.FSType)
 	if err != nil {
-		klog.Errorf("unable to retrieve mounts of snapshot %q: %s", key, err)
 		return err
 	}
 
+	mounts, err := snapshotter.Mounts(ctx, string(key))
+	if err != nil {
+		errorlog.Errorf("unable to retrieve mounts of snapshot %q: %s", key, err)
+		return err
+	}
+
+	if opts.FSType == backend.FSTypeEROFS {
+		// EROFS snapshots are returned as a chain of loop and overlay mounts. Let containerd perform
+		// the loop mounts on the host and hand back the final mounts.
+		if mounts, err = s.activateMounts(ctx, key, mounts); err != nil {
+			errorlog.Errorf("unable to activate mounts of snapshot %q: %s", key, err)
+			return err
+		}
+	}
+
+	if err = withMetadataLayers(mounts, opts); err != nil {
+		return err
+	}
+
+	if !opts.ReadOnly {
+		s.withOverlayOptions(mounts, opts)
+	}
+
+	// Apply per-mount flags to the mount itself, e.g. make it read-only even if the snapshot is writable.
+	if flags := mountFlagsOf(opts); len(flags) > 0 {
+		for i := range mounts {
+			mounts[i].Options = append(mounts[i].Options, flags...)
+		}
+	}
+
 	// Mount in host namespace using nsenter
-	err = mountInHostNamespace(ctx, mounts, string(target))
+	err = mountInHostNamespace(ctx, mounts, string(target), opts.SELinuxContext)
 	if err != nil {
 		mountsErr := describeMounts(mounts, string(target))
 		if len(mountsErr) > 0 {
 			err = errors.New(mountsErr)
 		}
 
-		klog.Errorf("unable to mount snapshot %q to target %s: %s", key, target, err)
+		errorlog.Errorf("unable to mount snapshot %q to target %s: %s", key, target, err)
 	}
 
 	return err
 }
 
+// activateMounts resolves the mounts of a snapshot via the containerd mount manager. Activations are
+// named after the snapshot so that all volumes sharing a read-only snapshot reuse the same one.
+func (s snapshotMounter) activateMounts(
+	ctx context.Context, key backend.SnapshotKey, mounts []mount.Mount,
+) ([]mount.Mount, error) {
+	mm := s.cli.MountManager()
+	info, err := mm.Info(ctx, string(key))
+	if err == nil {
+		return info.System, nil
+	}
+
+	if !errdefs.IsNotFound(err) {
+		return nil, err
+	}
+
+	info, err = mm.Activate(ctx, string(key), mounts)
+	if err != nil {
+		return nil, err
+	}
+
+	return info.System, nil
+}
+
 func (s snapshotMounter) Unmount(ctx context.Context, target backend.MountTarget) error {
 	if err := unmountInHostNamespace(ctx, string(target)); err != nil {
-		klog.Errorf("fail to unmount %s: %s", target, err)
+		errorlog.Errorf("fail to unmount %s: %s", target, err)
+		return err
+	}
+
+	return nil
+}
+
+func (s snapshotMounter) Bind(
+	_ context.Context, source string, target backend.MountTarget, opts backend.MountOptions,
+) error {
+	options := append([]string{"rbind"}, mountFlagsOf(opts)...)
+
+	if err := syscallMountInHostNamespace(source, string(target), "", options); err != nil {
+		errorlog.Errorf("unable to bind %q to %q: %s", source, target, err)
 		return err
 	}
 
@@ -150,13 +329,20 @@ func (s snapshotMounter) ImageExists(ctx context.Context, image reference.Named)
 	return err == nil
 }
 
-func (s snapshotMounter) GetImageIDOrDie(ctx context.Context, image reference.Named) string {
+func (s snapshotMounter) GetImageIDOrDie(
+	ctx context.Context, image reference.Named, opts backend.MountOptions,
+) string {
 	localImage, err := s.cli.GetImage(ctx, image.String())
 	if err != nil {
 		klog.Fatalf("unable to retrieve local image %q: %s", image, err)
 	}
 
-	if err = localImage.Unpack(ctx, ""); err != nil {
+	snapshotter, err := s.snapshotterName(opts.FSType)
+	if err != nil {
+		klog.Fatalf("unable to unpack image %q: %s", image, err)
+	}
+
+	if err = localImage.Unpack(ctx, snapshotter); err != nil {
 		klog.Fatalf("unable to unpack image %q: %s", image, err)
 	}
 
@@ -171,20 +357,29 @@ func (s snapshotMounter) GetImageIDOrDie(ctx context.Context, image reference.Na
 
 func (s snapshotMounter) PrepareReadOnlySnapshot(
 	ctx context.Context, imageID string, key backend.SnapshotKey, metadata backend.SnapshotMetadata,
+	opts backend.MountOptions,
 ) error {
-	labels := defaultSnapshotLabels()
+	snapshotter, err := s.snapshotterFor(opts.FSType)
+	if err != nil {
+		return err
+	}
+
+	labels := snapshotLabels(s.labels)
 	if metadata != nil {
-		labels = withTargets(defaultSnapshotLabels(), metadata.GetTargets())
+		labels = withTargets(labels, metadata.GetTargets())
 	}
 
 	klog.Infof("create ro snapshot %q for image %q with metadata %#v", key, imageID, labels)
-	info, err := s.FindSnapshot(ctx, string(key), imageID, snapshots.KindView, labels)
+	info, err := findSnapshot(ctx, snapshotter, string(key), imageID, snapshots.KindView, labels)
 	if info != nil {
+		if err == nil {
+			_, err = ensureSnapshotLabels(ctx, snapshotter, *info, s.labels)
+		}
 		return err
 	}
 
-	if _, err = s.snapshotter.View(ctx, string(key), imageID, snapshots.WithLabels(labels)); err != nil {
-		klog.Errorf("unable to create read-only snapshot %q of image %q: %s", key, imageID, err)
+	if _, err = snapshotter.View(ctx, string(key), imageID, snapshots.WithLabels(labels)); err != nil {
+		errorlog.Errorf("unable to create read-only snapshot %q of image %q: %s", key, imageID, err)
 	}
 
 	return err
@@ -192,29 +387,60 @@ func (s snapshotMounter) PrepareReadOnlySnapshot(
 
 func (s snapshotMounter) PrepareRWSnapshot(
 	ctx context.Context, imageID string, key backend.SnapshotKey, metadata backend.SnapshotMetadata,
+	opts backend.MountOptions,
 ) error {
-	labels := defaultSnapshotLabels()
+	snapshotter, err := s.snapshotterFor(opts.FSType)
+	if err != nil {
+		return err
+	}
+
+	labels := snapshotLabels(s.labels)
 	if metadata != nil {
-		labels = withTargets(defaultSnapshotLabels(), metadata.GetTargets())
+		labels = withTargets(labels, metadata.GetTargets())
 	}
 
 	klog.Infof("create rw snapshot %q for image %q with metadata %#v", key, imageID, labels)
-	info, err := s.FindSnapshot(ctx, string(key), imageID, snapshots.KindActive, labels)
+	info, err := findSnapshot(ctx, snapshotter, string(key), imageID, snapshots.KindActive, labels)
 	if info != nil {
+		if err == nil {
+			_, err = ensureSnapshotLabels(ctx, snapshotter, *info, s.labels)
+		}
 		return err
 	}
 
-	if _, err = s.snapshotter.Prepare(ctx, string(key), imageID, snapshots.WithLabels(labels)); err != nil {
-		klog.Errorf("unable to create snapshot %q of image %q: %s", key, imageID, err)
+	mounts, err := snapshotter.Prepare(ctx, string(key), imageID, snapshots.WithLabels(labels))
+	if err != nil {
+		errorlog.Errorf("unable to create snapshot %q of image %q: %s", key, imageID, err)
+		return err
 	}
 
-	return err
+	if opts.TmpfsUpper {
+		err = mountTmpfsUpper(ctx, mounts, opts.Quota)
+	} else if opts.Quota > 0 {
+		if overlayDir(mounts, "upperdir") == "" {
+			// Snapshots of block-based snapshotters are limited by the size of their devices instead.
+			klog.Warningf("snapshot %q isn't an overlay. ignore the quota of %d bytes", key, opts.Quota)
+		} else {
+			err = quotaOrUnlimited(key, opts.Quota, setUpperQuota(mounts, opts.Quota))
+		}
+	}
+
+	if err != nil {
+		errorlog.Errorf("unable to set up the writable layer of snapshot %q: %s", key, err)
+		if rmErr := snapshotter.Remove(ctx, string(key)); rmErr != nil {
+			errorlog.Errorf("unable to remove the snapshot %q: %s", key, rmErr)
+		}
+		return err
+	}
+
+	return nil
 }
 
-func (s snapshotMounter) FindSnapshot(
-	ctx context.Context, key, parent string, kind snapshots.Kind, labels map[string]string,
+func findSnapshot(
+	ctx context.Context, snapshotter snapshots.Snapshotter, key, parent string, kind snapshots.Kind,
+	labels map[string]string,
 ) (info *snapshots.Info, err error) {
-	stat, err := s.snapshotter.Stat(ctx, key)
+	stat, err := snapshotter.Stat(ctx, key)
 	if err != nil {
 		return
 	}
@@ -223,7 +449,8 @@ func (s snapshotMounter) FindSnapshot(
 
 	if info.Kind == kind && info.Parent == parent {
 		for k, v := range labels {
-			if k == gcLabel {
+			// Only labels of volumes tell the configuration. Others may have been changed by the deployment.
+			if !strings.HasPrefix(k, labelPrefix) {
 				continue
 			}
 
@@ -245,9 +472,14 @@ func (s snapshotMounter) UpdateSnapshotMetadata(
 	ctx context.Context, key backend.SnapshotKey, metadata backend.SnapshotMetadata,
 ) error {
 	klog.Infof("update metadata of snapshot %q to %#v", key, metadata)
-	info, err := s.snapshotter.Stat(ctx, string(key))
+	snapshotter, err := s.snapshotterOf(ctx, key)
 	if err != nil {
-		klog.Errorf("unable to fetch stat of snapshot %q: %s", key, err)
+		return err
+	}
+
+	info, err := snapshotter.Stat(ctx, string(key))
+	if err != nil {
+		errorlog.Errorf("unable to fetch stat of snapshot %q: %s", key, err)
 		return err
 	}
 
@@ -259,25 +491,102 @@ func (s snapshotMounter) UpdateSnapshotMetadata(
 
 	info.Labels = withTargets(info.Labels, metadata.GetTargets())
 	klog.Infof("labels of snapshot %q are %#v", key, info.Labels)
-	_, err = s.snapshotter.Update(ctx, info)
+	_, err = snapshotter.Update(ctx, info)
 	if err != nil {
-		klog.Errorf("unable to update metadata of snapshot %q: %s", key, err)
+		errorlog.Errorf("unable to update metadata of snapshot %q: %s", key, err)
 	}
 	return err
 }
 
+func (s snapshotMounter) SnapshotExists(ctx context.Context, key backend.SnapshotKey) bool {
+	snapshotter, err := s.snapshotterOf(ctx, key)
+	if err != nil {
+		return false
+	}
+
+	_, err = snapshotter.Stat(ctx, string(key))
+	return err == nil
+}
+
+func (s snapshotMounter) ResizeWritableLayer(
+	ctx context.Context, key backend.SnapshotKey, size int64, opts backend.MountOptions,
+) error {
+	snapshotter, err := s.snapshotterOf(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	mounts, err := snapshotter.Mounts(ctx, string(key))
+	if err != nil {
+		return fmt.Errorf("unable to fetch mounts of snapshot %q: %w", key, err)
+	}
+
+	if opts.TmpfsUpper {
+		return resizeTmpfsUpper(ctx, mounts, size)
+	}
+
+	return quotaOrUnlimited(key, size, resizeUpperQuota(mounts, size))
+}
+
+func (s snapshotMounter) CheckRuntime(ctx context.Context) error {
+	serving, err := s.cli.IsServing(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !serving {
+		return fmt.Errorf("containerd is not serving")
+	}
+
+	return nil
+}
+
 func (s snapshotMounter) DestroySnapshot(ctx context.Context, key backend.SnapshotKey) error {
+	snapshotter, err := s.snapshotterOf(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	if snapshotter == s.erofs {
+		if err := s.cli.MountManager().Deactivate(ctx, string(key)); err != nil && !errdefs.IsNotFound(err) {
+			errorlog.Errorf("unable to deactivate mounts of snapshot %q: %s", key, err)
+			return err
+		}
+	}
+
+	if mounts, err := snapshotter.Mounts(ctx, string(key)); err == nil {
+		clearUpperQuota(mounts)
+		if err := unmountTmpfsUpper(ctx, mounts); err != nil {
+			errorlog.Errorf("unable to unmount the tmpfs of snapshot %q: %s", key, err)
+			return err
+		}
+	}
+
 	klog.Infof("remove snapshot %q", key)
-	err := s.snapshotter.Remove(ctx, string(key))
+	err = snapshotter.Remove(ctx, string(key))
 	if err != nil {
-		klog.Errorf("unable to remove the snapshot %q: %s", key, err)
+		errorlog.Errorf("unable to remove the snapshot %q: %s", key, err)
 	}
 
 	return err
 }
 
 func (s snapshotMounter) ListSnapshots(ctx context.Context) (ss []backend.SnapshotMetadata, err error) {
-	err = s.snapshotter.Walk(ctx, func(ctx context.Context, info snapshots.Info) error {
+	ss, err = listSnapshots(ctx, s.snapshotter)
+	if err != nil || s.erofs == nil {
+		return
+	}
+
+	erofsSnapshots, err := listSnapshots(ctx, s.erofs)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(ss, erofsSnapshots...), nil
+}
+
+func listSnapshots(ctx context.Context, snapshotter snapshots.Snapshotter) (ss []backend.SnapshotMetadata, err error) {
+	err = snapshotter.Walk(ctx, func(ctx context.Context, info snapshots.Info) error {
 		if len(info.Labels) == 0 {
 			return nil
 		}
@@ -321,7 +630,7 @@ func (s snapshotMounter) ListSnapshots(ctx context.Context) (ss []backend.Snapsh
 	})
 
 	if err != nil {
-		klog.Errorf("unable to list snapshots: %s", err)
+		errorlog.Errorf("unable to list snapshots: %s", err)
 		return nil, err
 	}
 
@@ -335,10 +644,17 @@ const (
 	gcLabel             = "containerd.io/gc.root"
 )
 
-func defaultSnapshotLabels() map[string]string {
-	return map[string]string{
+// snapshotLabels returns labels of new snapshots, which are the GC root label and the given labels.
+func snapshotLabels(extra map[string]string) map[string]string {
+	labels := map[string]string{
 		gcLabel: time.Now().UTC().Format(time.RFC3339),
 	}
+
+	for k, v := range extra {
+		labels[k] = v
+	}
+
+	return labels
 }
 
 func genTargetLabel(target string) string {
@@ -415,3 +731,45 @@ func describeMounts(mounts []mount.Mount, target string) string {
 
 	return ""
 }
+
+// CleanStaleResources deactivates mount manager activations of EROFS snapshots which have been removed.
+func (s snapshotMounter) CleanStaleResources(ctx context.Context, dryRun bool, limit int) (found int, err error) {
+	if s.erofs == nil {
+		return 0, nil
+	}
+
+	mm := s.cli.MountManager()
+	activations, err := mm.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, activation := range activations {
+		if !strings.HasPrefix(activation.Name, labelPrefix) {
+			continue
+		}
+
+		if _, err := s.erofs.Stat(ctx, activation.Name); !errdefs.IsNotFound(err) {
+			continue
+		}
+
+		if dryRun {
+			klog.Infof("[dry-run] would deactivate stale mounts %q", activation.Name)
+			continue
+		}
+
+		if limit >= 0 && found >= limit {
+			break
+		}
+
+		if err := mm.Deactivate(ctx, activation.Name); err != nil && !errdefs.IsNotFound(err) {
+			errorlog.Errorf("unable to deactivate stale mounts %q: %s", activation.Name, err)
+			continue
+		}
+
+		found++
+		klog.Infof("deactivated stale mounts %q", activation.Name)
+	}
+
+	return found, nil
+}
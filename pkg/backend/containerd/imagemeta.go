@@ -0,0 +1,233 @@
+package containerd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/containerd/errdefs"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/image-spec/identity"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+)
+
+// InspectImage returns metadata of the local image. Since containerd updates images when they are pulled,
+// the update time of the image is taken as the pull time.
+func (s snapshotMounter) InspectImage(ctx context.Context, image reference.Named) (*backend.ImageMetadata, error) {
+	img, err := s.cli.GetImage(ctx, image.String())
+	if err != nil {
+		return nil, err
+	}
+
+	spec, err := img.Spec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	desc, err := platformManifest(ctx, img)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := content.ReadBlob(ctx, img.ContentStore(), desc)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read manifest %s: %w", desc.Digest, err)
+	}
+
+	var parsed ocispec.Manifest
+	if err = json.Unmarshal(manifest, &parsed); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", desc.Digest, err)
+	}
+
+	encrypted := false
+	for _, layer := range parsed.Layers {
+		if strings.HasSuffix(layer.MediaType, "+encrypted") {
+			encrypted = true
+		}
+	}
+
+	return &backend.ImageMetadata{
+		Image:          image.String(),
+		Registry:       reference.Domain(image),
+		Digest:         img.Target().Digest.String(),
+		ManifestDigest: desc.Digest.String(),
+		Manifest:       manifest,
+		Encrypted:      encrypted,
+		PulledAt:       img.Metadata().UpdatedAt,
+		Labels:         spec.Config.Labels,
+		Env:            spec.Config.Env,
+		Entrypoint:     spec.Config.Entrypoint,
+		Cmd:            spec.Config.Cmd,
+		WorkingDir:     spec.Config.WorkingDir,
+		User:           spec.Config.User,
+	}, nil
+}
+
+// ImageSize implements backend.ImageSizer. The unpacked size only counts snapshots of the snapshotter of the driver,
+// and layers the image isn't unpacked to yet are left out.
+func (s snapshotMounter) ImageSize(ctx context.Context, image reference.Named) (backend.ImageSize, error) {
+	img, err := s.cli.GetImage(ctx, image.String())
+	if err != nil {
+		return backend.ImageSize{}, err
+	}
+
+	compressed, err := img.Size(ctx)
+	if err != nil {
+		return backend.ImageSize{}, err
+	}
+
+	diffIDs, err := img.RootFS(ctx)
+	if err != nil {
+		return backend.ImageSize{}, err
+	}
+
+	size := backend.ImageSize{Compressed: compressed}
+	for _, chainID := range identity.ChainIDs(diffIDs) {
+		usage, err := s.snapshotter.Usage(ctx, chainID.String())
+		if errdefs.IsNotFound(err) {
+			break
+		}
+
+		if err != nil {
+			return backend.ImageSize{}, err
+		}
+
+		size.Unpacked += usage.Size
+	}
+
+	return size, nil
+}
+
+// SnapshotUsage implements backend.SnapshotUsageReporter. The usage of the active snapshot is measured by walking
+// its files, as the overlayfs snapshotter does for active snapshots.
+func (s snapshotMounter) SnapshotUsage(ctx context.Context, key backend.SnapshotKey) (backend.SnapshotUsage, error) {
+	snapshotter, err := s.snapshotterOf(ctx, key)
+	if err != nil {
+		return backend.SnapshotUsage{}, err
+	}
+
+	usage, err := snapshotter.Usage(ctx, string(key))
+	if err != nil {
+		return backend.SnapshotUsage{}, fmt.Errorf("unable to measure snapshot %q: %w", key, err)
+	}
+
+	info, err := snapshotter.Stat(ctx, string(key))
+	if err != nil {
+		return backend.SnapshotUsage{}, fmt.Errorf("unable to stat snapshot %q: %w", key, err)
+	}
+
+	result := backend.SnapshotUsage{Writable: usage.Size}
+	for parent := info.Parent; parent != ""; {
+		usage, err := snapshotter.Usage(ctx, parent)
+		if err != nil {
+			return backend.SnapshotUsage{}, fmt.Errorf("unable to measure snapshot %q: %w", parent, err)
+		}
+
+		info, err := snapshotter.Stat(ctx, parent)
+		if err != nil {
+			return backend.SnapshotUsage{}, fmt.Errorf("unable to stat snapshot %q: %w", parent, err)
+		}
+
+		result.Image += usage.Size
+		parent = info.Parent
+	}
+
+	return result, nil
+}
+
+// platformManifest returns the descriptor of the manifest of the image for the platform it is unpacked for,
+// resolving indexes of multi-platform images.
+func platformManifest(ctx context.Context, img client.Image) (ocispec.Descriptor, error) {
+	desc := img.Target()
+	platform := img.Platform()
+	for {
+		switch {
+		case images.IsManifestType(desc.MediaType):
+			return desc, nil
+		case images.IsIndexType(desc.MediaType):
+			data, err := content.ReadBlob(ctx, img.ContentStore(), desc)
+			if err != nil {
+				return desc, fmt.Errorf("unable to read index %s: %w", desc.Digest, err)
+			}
+
+			var index ocispec.Index
+			if err = json.Unmarshal(data, &index); err != nil {
+				return desc, fmt.Errorf("invalid index %s: %w", desc.Digest, err)
+			}
+
+			var matched []ocispec.Descriptor
+			for _, m := range index.Manifests {
+				if m.Platform == nil || platform.Match(*m.Platform) {
+					matched = append(matched, m)
+				}
+			}
+
+			if len(matched) == 0 {
+				return desc, fmt.Errorf("index %s doesn't have a manifest for the platform", desc.Digest)
+			}
+
+			// Manifests of the best matching platforms go first. Those without platforms go last.
+			sort.SliceStable(matched, func(i, j int) bool {
+				if matched[i].Platform == nil || matched[j].Platform == nil {
+					return matched[j].Platform == nil && matched[i].Platform != nil
+				}
+				return platform.Less(*matched[i].Platform, *matched[j].Platform)
+			})
+			desc = matched[0]
+		default:
+			return desc, fmt.Errorf("unsupported media type %q of %s", desc.MediaType, desc.Digest)
+		}
+	}
+}
+
+// withMetadataLayers merges the directories of referrers and image metadata, if any, on top of the mounts.
+func withMetadataLayers(mounts []mount.Mount, opts backend.MountOptions) error {
+	for _, dir := range []string{opts.ReferrersDir, opts.MetadataDir} {
+		if dir == "" {
+			continue
+		}
+
+		if err := withMetadataLayer(mounts, dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// withMetadataLayer merges the directory on top of the read-only layers of the mounts. Bind mounts of
+// images with a single layer are turned into overlay mounts.
+func withMetadataLayer(mounts []mount.Mount, dir string) error {
+	if len(mounts) == 0 {
+		return fmt.Errorf("snapshot doesn't have any mount")
+	}
+
+	m := &mounts[len(mounts)-1]
+	switch m.Type {
+	case "bind":
+		for _, opt := range m.Options {
+			if opt == "rw" {
+				return fmt.Errorf("images without layers don't support image metadata")
+			}
+		}
+
+		*m = mount.Mount{Type: "overlay", Source: "overlay", Options: []string{"lowerdir=" + dir + ":" + m.Source}}
+		return nil
+	case "overlay":
+		for i, opt := range m.Options {
+			if strings.HasPrefix(opt, "lowerdir=") {
+				m.Options[i] = "lowerdir=" + dir + ":" + strings.TrimPrefix(opt, "lowerdir=")
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("snapshots of mount type %q don't support image metadata", m.Type)
+}
@@ -0,0 +1,38 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/warm-metal/container-image-csi-driver/pkg/sandbox"
+	"k8s.io/klog/v2"
+)
+
+// AttachLoopDevice attaches the file as a read-only loop device in the host mount namespace.
+func (s snapshotMounter) AttachLoopDevice(ctx context.Context, file string) (string, error) {
+	cmd := sandbox.Tools.CommandContext(ctx,
+		"nsenter", "--mount="+hostMountNS, "--",
+		"losetup", "--find", "--show", "--read-only", file)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("losetup failed: %w, output: %s", err, output)
+	}
+
+	device := strings.TrimSpace(string(output))
+	klog.V(4).Infof("attached %s to loop device %s", file, device)
+	return device, nil
+}
+
+// DetachLoopDevice detaches the loop device in the host mount namespace.
+func (s snapshotMounter) DetachLoopDevice(ctx context.Context, device string) error {
+	cmd := sandbox.Tools.CommandContext(ctx,
+		"nsenter", "--mount="+hostMountNS, "--",
+		"losetup", "--detach", device)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("unable to detach loop device %s: %w, output: %s", device, err, output)
+	}
+
+	klog.V(4).Infof("detached loop device %s", device)
+	return nil
+}
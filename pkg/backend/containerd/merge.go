@@ -0,0 +1,109 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+	"github.com/warm-metal/container-image-csi-driver/pkg/errorlog"
+)
+
+// MountMerged stacks the layers of all snapshots into a single overlay mount. The first snapshot is
+// the topmost one. The writable layer of the read-write snapshot, if any, is above all of them.
+func (s snapshotMounter) MountMerged(
+	ctx context.Context, keys []backend.SnapshotKey, target backend.MountTarget, opts backend.MountOptions,
+) error {
+	if opts.FSType != "" {
+		return fmt.Errorf("images of fsType %q can't be merged", opts.FSType)
+	}
+
+	var snapshotMounts [][]mount.Mount
+	for _, key := range keys {
+		mounts, err := s.snapshotter.Mounts(ctx, string(key))
+		if err != nil {
+			errorlog.Errorf("unable to retrieve mounts of snapshot %q: %s", key, err)
+			return err
+		}
+
+		snapshotMounts = append(snapshotMounts, mounts)
+	}
+
+	merged, err := mergeMounts(snapshotMounts)
+	if err != nil {
+		return err
+	}
+
+	mounts := []mount.Mount{merged}
+	if err = withMetadataLayers(mounts, opts); err != nil {
+		return err
+	}
+
+	if !opts.ReadOnly {
+		s.withOverlayOptions(mounts, opts)
+	}
+
+	mounts[0].Options = append(mounts[0].Options, mountFlagsOf(opts)...)
+
+	if err = mountInHostNamespace(ctx, mounts, string(target), opts.SELinuxContext); err != nil {
+		errorlog.Errorf("unable to mount merged snapshots %v to target %s: %s", keys, target, err)
+	}
+
+	return err
+}
+
+// mergeMounts builds an overlay mount of which lowerdir contains the layers of all snapshots in order.
+// The upperdir and workdir are taken from the only read-write snapshot, and other overlay options from
+// all snapshots.
+func mergeMounts(snapshotMounts [][]mount.Mount) (mount.Mount, error) {
+	merged := mount.Mount{Type: "overlay", Source: "overlay"}
+	var lowers []string
+	writable := false
+	options := make(map[string]struct{})
+	for _, mounts := range snapshotMounts {
+		if len(mounts) != 1 {
+			return merged, fmt.Errorf("snapshots with %d mounts can't be merged", len(mounts))
+		}
+
+		m := mounts[0]
+		switch m.Type {
+		case "bind":
+			// Snapshots of images with a single layer are bind mounts of the layer. Writable ones are
+			// snapshots of images without any layer, which have nothing to merge.
+			for _, opt := range m.Options {
+				if opt == "rw" {
+					return merged, fmt.Errorf("images without layers can't be merged")
+				}
+			}
+			lowers = append(lowers, m.Source)
+		case "overlay":
+			for _, opt := range m.Options {
+				switch {
+				case strings.HasPrefix(opt, "lowerdir="):
+					lowers = append(lowers, strings.Split(strings.TrimPrefix(opt, "lowerdir="), ":")...)
+				case strings.HasPrefix(opt, "upperdir="):
+					if writable {
+						return merged, fmt.Errorf("only one snapshot can be writable")
+					}
+					writable = true
+					merged.Options = append(merged.Options, opt)
+				case strings.HasPrefix(opt, "workdir="):
+					merged.Options = append(merged.Options, opt)
+				case opt == "ro" || opt == "rw":
+					// Whether the merged mount is read-only is decided by the volume.
+				default:
+					if _, found := options[opt]; !found {
+						options[opt] = struct{}{}
+						merged.Options = append(merged.Options, opt)
+					}
+				}
+			}
+		default:
+			return merged, fmt.Errorf("snapshots of mount type %q can't be merged", m.Type)
+		}
+	}
+
+	merged.Options = append(merged.Options, "lowerdir="+strings.Join(lowers, ":"))
+	return merged, nil
+}
@@ -20,13 +20,15 @@ package containerd
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
+	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/warm-metal/container-image-csi-driver/pkg/sandbox"
 	"golang.org/x/sys/unix"
 	"k8s.io/klog/v2"
 )
@@ -68,16 +70,68 @@ func runNsenterMountChild() error {
 		return fmt.Errorf("decode mount request: %w", err)
 	}
 
-	data := strings.Join(req.Options, ",")
+	flags, data := parseMountOptions(req.Options)
 
-	if err := unix.Mount(req.Source, req.Target, req.FSType, 0, data); err != nil {
-		return fmt.Errorf("mount(%q → %q, type=%q, data=%q): %w",
-			req.Source, req.Target, req.FSType, data, err)
+	// The kernel ignores per-mount flags like MS_RDONLY when creating a bind mount, so they are applied
+	// by a remount.
+	if flags&unix.MS_BIND != 0 && flags&bindRemountFlags != 0 {
+		if err := unix.Mount(req.Source, req.Target, req.FSType, flags&^bindRemountFlags, data); err != nil {
+			return fmt.Errorf("mount(%q → %q, type=%q, flags=%#x, data=%q): %w",
+				req.Source, req.Target, req.FSType, flags, data, err)
+		}
+
+		remountFlags := unix.MS_REMOUNT | unix.MS_BIND | (flags & bindRemountFlags)
+		if err := unix.Mount("", req.Target, "", remountFlags, ""); err != nil {
+			return fmt.Errorf("remount(%q, flags=%#x): %w", req.Target, remountFlags, err)
+		}
+
+		return nil
+	}
+
+	if err := unix.Mount(req.Source, req.Target, req.FSType, flags, data); err != nil {
+		return fmt.Errorf("mount(%q → %q, type=%q, flags=%#x, data=%q): %w",
+			req.Source, req.Target, req.FSType, flags, data, err)
 	}
 
 	return nil
 }
 
+// mountFlags maps mount options to the flags of mount(2). All other options are passed as data.
+var mountFlags = map[string]uintptr{
+	"ro":          unix.MS_RDONLY,
+	"bind":        unix.MS_BIND,
+	"rbind":       unix.MS_BIND | unix.MS_REC,
+	"nosuid":      unix.MS_NOSUID,
+	"nodev":       unix.MS_NODEV,
+	"noexec":      unix.MS_NOEXEC,
+	"noatime":     unix.MS_NOATIME,
+	"nodiratime":  unix.MS_NODIRATIME,
+	"relatime":    unix.MS_RELATIME,
+	"strictatime": unix.MS_STRICTATIME,
+}
+
+// bindRemountFlags are the flags that can be applied to a bind mount by remounting it.
+const bindRemountFlags = unix.MS_RDONLY | unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC |
+	unix.MS_NOATIME | unix.MS_NODIRATIME | unix.MS_RELATIME | unix.MS_STRICTATIME
+
+func parseMountOptions(options []string) (flags uintptr, data string) {
+	var dataOptions []string
+	for _, opt := range options {
+		if flag, found := mountFlags[opt]; found {
+			flags |= flag
+			continue
+		}
+
+		if opt == "rw" {
+			continue
+		}
+
+		dataOptions = append(dataOptions, opt)
+	}
+
+	return flags, strings.Join(dataOptions, ",")
+}
+
 // csiSocketDir returns the host-side path of the CSI socket directory.
 // This directory is a hostPath volume visible from both the container and host namespaces.
 // The initContainer copies the driver binary here as "mount-helper" before the main
@@ -110,8 +164,8 @@ func syscallMountInHostNamespace(source, target, fstype string, options []string
 
 	// nsenter enters the host mount namespace, then execs the helper binary from the
 	// hostPath volume (accessible from both container and host namespaces).
-	cmd := exec.Command("nsenter", "--mount="+hostMountNS, "--", hostHelper)
-	cmd.Env = []string{envNsenterMount + "=1"}
+	cmd := sandbox.Tools.CommandContext(context.Background(), "nsenter", "--mount="+hostMountNS, "--", hostHelper)
+	cmd.Env = append(cmd.Env, envNsenterMount+"=1")
 	cmd.Stdin = bytes.NewReader(payload)
 
 	out, err := cmd.CombinedOutput()
@@ -123,3 +177,19 @@ func syscallMountInHostNamespace(source, target, fstype string, options []string
 		source, target, fstype, options)
 	return nil
 }
+
+// MountInHostNamespace mounts the mounts to the target in the host mount namespace the same way as snapshots
+// of containerd, so that backends of runtimes keeping image layers on the host can share it.
+func MountInHostNamespace(ctx context.Context, mounts []mount.Mount, target string, seLinuxContext string) error {
+	return mountInHostNamespace(ctx, mounts, target, seLinuxContext)
+}
+
+// BindInHostNamespace binds the source to the target in the host mount namespace.
+func BindInHostNamespace(source, target string, options []string) error {
+	return syscallMountInHostNamespace(source, target, "", options)
+}
+
+// UnmountInHostNamespace unmounts the target in the host mount namespace.
+func UnmountInHostNamespace(ctx context.Context, target string) error {
+	return unmountInHostNamespace(ctx, target)
+}
@@ -0,0 +1,51 @@
+package containerd
+
+import (
+	"fmt"
+
+	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+)
+
+// volatileOption makes overlayfs skip all syncs of the upper layer. Overlays which weren't unmounted
+// cleanly, e.g. after a node crash, can't be mounted again.
+const volatileOption = "volatile"
+
+// supportedOverlayOptions are overlay options which trade consistency or compatibility for performance.
+var supportedOverlayOptions = map[string]struct{}{
+	"metacopy=on":  {},
+	"metacopy=off": {},
+	"xino=on":      {},
+	"xino=off":     {},
+	"xino=auto":    {},
+	volatileOption: {},
+}
+
+// validateOverlayOptions checks whether all the given overlay options are supported.
+func validateOverlayOptions(options []string) error {
+	for _, opt := range options {
+		if _, found := supportedOverlayOptions[opt]; !found {
+			return fmt.Errorf("unsupported overlay option %q", opt)
+		}
+	}
+
+	return nil
+}
+
+// withOverlayOptions appends the configured overlay options to the writable overlay mounts. Since persistent
+// scratch layers must survive node crashes, volatile is never applied to them.
+func (s snapshotMounter) withOverlayOptions(mounts []mount.Mount, opts backend.MountOptions) {
+	for i := range mounts {
+		if mounts[i].Type != "overlay" || overlayDir(mounts[i:i+1], "upperdir") == "" {
+			continue
+		}
+
+		for _, opt := range s.overlayOptions {
+			if opt == volatileOption && opts.PersistentScratch {
+				continue
+			}
+
+			mounts[i].Options = append(mounts[i].Options, opt)
+		}
+	}
+}
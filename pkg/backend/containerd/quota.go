@@ -0,0 +1,133 @@
+package containerd
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+	"go.podman.io/storage/drivers/quota"
+	"k8s.io/klog/v2"
+)
+
+// quotaControls caches a project quota control per snapshotter root, since each control allocates
+// project IDs for a single filesystem.
+var quotaControls = struct {
+	sync.Mutex
+	controls map[string]*quota.Control
+}{controls: make(map[string]*quota.Control)}
+
+// errQuotaUnavailable is returned if the filesystem of a snapshotter doesn't support project quotas, e.g. if it isn't
+// mounted with prjquota, or the driver is built without cgo.
+var errQuotaUnavailable = errors.New("project quota is not available")
+
+// overlayDir returns the value of the given overlay option, e.g. upperdir or workdir, in the mounts
+// of a read-write snapshot.
+func overlayDir(mounts []mount.Mount, option string) string {
+	prefix := option + "="
+	for _, m := range mounts {
+		for _, opt := range m.Options {
+			if strings.HasPrefix(opt, prefix) {
+				return opt[len(prefix):]
+			}
+		}
+	}
+
+	return ""
+}
+
+// quotaControlOf returns the quota control for the snapshotter owning the given upperdir, which
+// is always in the form of <root>/snapshots/<id>/fs.
+func quotaControlOf(upper string) (*quota.Control, error) {
+	root := filepath.Dir(filepath.Dir(filepath.Dir(upper)))
+
+	quotaControls.Lock()
+	defer quotaControls.Unlock()
+	if c, found := quotaControls.controls[root]; found {
+		return c, nil
+	}
+
+	c, err := quota.NewControl(root)
+	if err != nil {
+		return nil, fmt.Errorf("%w under %s: %w", errQuotaUnavailable, root, err)
+	}
+
+	quotaControls.controls[root] = c
+	return c, nil
+}
+
+// setUpperQuota limits the writable layer of a read-write snapshot to size bytes using project quotas.
+// The upperdir must be empty, which is always true right after the snapshot is prepared.
+func setUpperQuota(mounts []mount.Mount, size int64) error {
+	upper := overlayDir(mounts, "upperdir")
+	if upper == "" {
+		return fmt.Errorf("snapshot doesn't have an upperdir to set quota on")
+	}
+
+	c, err := quotaControlOf(upper)
+	if err != nil {
+		return err
+	}
+
+	if err = c.SetQuota(upper, quota.Quota{Size: uint64(size)}); err != nil {
+		return fmt.Errorf("unable to set quota on %s: %w", upper, err)
+	}
+
+	klog.Infof("set quota of %d bytes on %s", size, upper)
+	return nil
+}
+
+// resizeUpperQuota changes the quota of the writable layer of a read-write snapshot. Only quotas set since
+// the driver started can be changed, since project IDs of existing upperdirs are not tracked across restarts.
+func resizeUpperQuota(mounts []mount.Mount, size int64) error {
+	upper := overlayDir(mounts, "upperdir")
+	if upper == "" {
+		return fmt.Errorf("snapshot doesn't have an upperdir to set quota on")
+	}
+
+	c, err := quotaControlOf(upper)
+	if err != nil {
+		return err
+	}
+
+	var current quota.Quota
+	if err = c.GetQuota(upper, &current); err != nil {
+		return fmt.Errorf("unable to get quota of %s: %w", upper, err)
+	}
+
+	if err = c.SetQuota(upper, quota.Quota{Size: uint64(size)}); err != nil {
+		return fmt.Errorf("unable to resize quota on %s. it may be set before the driver restarted: %w", upper, err)
+	}
+
+	klog.Infof("resized quota on %s from %d to %d bytes", upper, current.Size, size)
+	return nil
+}
+
+// quotaOrUnlimited returns nil if err is errQuotaUnavailable, so that writable layers of snapshots are left unlimited
+// instead of failing their mounts and expansions on nodes without project quotas.
+func quotaOrUnlimited(key backend.SnapshotKey, size int64, err error) error {
+	if !errors.Is(err, errQuotaUnavailable) {
+		return err
+	}
+
+	klog.Warningf("writable layer of snapshot %q isn't limited to %d bytes: %s", key, size, err)
+	return nil
+}
+
+// clearUpperQuota forgets the project ID assigned to the upperdir of a read-write snapshot.
+func clearUpperQuota(mounts []mount.Mount) {
+	upper := overlayDir(mounts, "upperdir")
+	if upper == "" {
+		return
+	}
+
+	root := filepath.Dir(filepath.Dir(filepath.Dir(upper)))
+	quotaControls.Lock()
+	defer quotaControls.Unlock()
+	if c, found := quotaControls.controls[root]; found {
+		c.ClearQuota(upper)
+	}
+}
@@ -0,0 +1,111 @@
+package containerd
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/stretchr/testify/assert"
+	"go.podman.io/storage/drivers/quota"
+)
+
+func TestOverlayDir(t *testing.T) {
+	tests := []struct {
+		name   string
+		mounts []mount.Mount
+		option string
+		dir    string
+	}{
+		{
+			name:   "bind mount",
+			mounts: []mount.Mount{{Type: "bind", Source: "/snapshots/1/fs", Options: []string{"rbind", "rw"}}},
+			option: "upperdir",
+		},
+		{
+			name: "overlay mount",
+			mounts: []mount.Mount{{Type: "overlay", Source: "overlay", Options: []string{
+				"index=off", "workdir=/snapshots/2/work", "upperdir=/snapshots/2/fs", "lowerdir=/snapshots/1/fs",
+			}}},
+			option: "upperdir",
+			dir:    "/snapshots/2/fs",
+		},
+		{
+			name: "option prefix",
+			mounts: []mount.Mount{{Type: "overlay", Source: "overlay", Options: []string{
+				"workdir=/snapshots/2/work", "upperdir=/snapshots/2/fs",
+			}}},
+			option: "work",
+		},
+		{
+			name: "second mount",
+			mounts: []mount.Mount{
+				{Type: "bind", Source: "/snapshots/1/fs"},
+				{Type: "overlay", Source: "overlay", Options: []string{"workdir=/snapshots/2/work"}},
+			},
+			option: "workdir",
+			dir:    "/snapshots/2/work",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.dir, overlayDir(tt.mounts, tt.option))
+		})
+	}
+}
+
+func TestUpperQuota(t *testing.T) {
+	root := t.TempDir()
+	if _, err := quota.NewControl(root); err == nil {
+		t.Skipf("%s supports project quotas", root)
+	}
+
+	upper := []mount.Mount{{Type: "overlay", Source: "overlay", Options: []string{
+		"upperdir=" + filepath.Join(root, "snapshots", "2", "fs"),
+	}}}
+	bind := []mount.Mount{{Type: "bind", Source: filepath.Join(root, "snapshots", "1", "fs")}}
+	tests := []struct {
+		name    string
+		set     func([]mount.Mount, int64) error
+		mounts  []mount.Mount
+		wantErr string
+	}{
+		{
+			name:    "set without upperdir",
+			set:     setUpperQuota,
+			mounts:  bind,
+			wantErr: "doesn't have an upperdir",
+		},
+		{
+			name:    "resize without upperdir",
+			set:     resizeUpperQuota,
+			mounts:  bind,
+			wantErr: "doesn't have an upperdir",
+		},
+		{
+			name:    "set without project quotas",
+			set:     setUpperQuota,
+			mounts:  upper,
+			wantErr: "project quota is not available under " + root,
+		},
+		{
+			name:    "resize without project quotas",
+			set:     resizeUpperQuota,
+			mounts:  upper,
+			wantErr: "project quota is not available under " + root,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.set(tt.mounts, 1<<20)
+			assert.ErrorContains(t, err, tt.wantErr)
+			// Only unavailable project quotas leave writable layers unlimited instead of failing.
+			assert.Equal(t, strings.HasPrefix(tt.wantErr, "project quota"),
+				quotaOrUnlimited("snapshot", 1<<20, err) == nil)
+			// Quotas are never set, so there is nothing to clear.
+			assert.NotPanics(t, func() { clearUpperQuota(tt.mounts) })
+		})
+	}
+}
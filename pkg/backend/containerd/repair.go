@@ -0,0 +1,88 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"k8s.io/klog/v2"
+)
+
+// ensureSnapshotLabels sets the GC root label and the given labels on the snapshot if they are missing or
+// different. It returns whether the snapshot is updated.
+func ensureSnapshotLabels(
+	ctx context.Context, snapshotter snapshots.Snapshotter, info snapshots.Info, labels map[string]string,
+) (bool, error) {
+	var fieldPaths []string
+	if info.Labels == nil {
+		info.Labels = make(map[string]string)
+	}
+
+	for k, v := range snapshotLabels(labels) {
+		if _, found := info.Labels[k]; found && (k == gcLabel || info.Labels[k] == v) {
+			continue
+		}
+
+		info.Labels[k] = v
+		fieldPaths = append(fieldPaths, "labels."+k)
+	}
+
+	if len(fieldPaths) == 0 {
+		return false, nil
+	}
+
+	klog.Infof("set labels %v of snapshot %q", fieldPaths, info.Name)
+	if _, err := snapshotter.Update(ctx, info, fieldPaths...); err != nil {
+		return false, fmt.Errorf("unable to update labels of snapshot %q: %w", info.Name, err)
+	}
+
+	return true, nil
+}
+
+// RepairSnapshots sets the GC root label and the given labels on all snapshots created by the driver,
+// including those created by older versions without them, so that containerd GC never removes snapshots
+// of mounted volumes. Snapshots of the given snapshotter and the erofs snapshotter are repaired.
+func RepairSnapshots(ctx context.Context, socketPath, snapshotter string, labels map[string]string) error {
+	c, err := client.New(socketPath, client.WithDefaultNamespace("k8s.io"))
+	if err != nil {
+		return fmt.Errorf("unable to connect to containerd: %w", err)
+	}
+	defer c.Close()
+
+	snapshotters := []string{snapshotter}
+	if snapshotter != erofsSnapshotter && snapshotterLoaded(c, erofsSnapshotter) {
+		snapshotters = append(snapshotters, erofsSnapshotter)
+	}
+
+	for _, name := range snapshotters {
+		var infos []snapshots.Info
+		svc := c.SnapshotService(name)
+		err = svc.Walk(ctx, func(_ context.Context, info snapshots.Info) error {
+			if strings.HasPrefix(info.Name, labelPrefix+"-") {
+				infos = append(infos, info)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("unable to list snapshots of snapshotter %q: %w", name, err)
+		}
+
+		repaired := 0
+		for _, info := range infos {
+			updated, err := ensureSnapshotLabels(ctx, svc, info, labels)
+			if err != nil {
+				return err
+			}
+
+			if updated {
+				repaired++
+			}
+		}
+
+		klog.Infof("repaired %d of %d snapshots of snapshotter %q", repaired, len(infos), name)
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/warm-metal/container-image-csi-driver/pkg/errorlog"
+	"github.com/warm-metal/container-image-csi-driver/pkg/sandbox"
+	"k8s.io/klog/v2"
+)
+
+// mountTmpfsUpper mounts a tmpfs on the directory holding the upperdir and workdir of a read-write
+// snapshot, so that all writes to the volume stay in memory. A size of 0 uses the kernel default.
+// Overlayfs requires both directories to be on the same filesystem, so they are recreated on the tmpfs.
+func mountTmpfsUpper(ctx context.Context, mounts []mount.Mount, size int64) error {
+	upper := overlayDir(mounts, "upperdir")
+	work := overlayDir(mounts, "workdir")
+	if upper == "" || work == "" {
+		return fmt.Errorf("snapshot doesn't have an upperdir and a workdir to put on tmpfs")
+	}
+
+	dir := filepath.Dir(upper)
+	if filepath.Dir(work) != dir {
+		return fmt.Errorf("upperdir %s and workdir %s are not in the same directory", upper, work)
+	}
+
+	options := []string{"mode=0755"}
+	if size > 0 {
+		options = append(options, "size="+strconv.FormatInt(size, 10))
+	}
+
+	if err := syscallMountInHostNamespace("tmpfs", dir, "tmpfs", options); err != nil {
+		return err
+	}
+
+	cmd := sandbox.Tools.CommandContext(ctx,
+		"nsenter", "--mount="+hostMountNS, "--",
+		"mkdir", "-m", "0755", upper, work)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if umountErr := unmountInHostNamespace(ctx, dir); umountErr != nil {
+			errorlog.Errorf("unable to unmount tmpfs on %s: %s", dir, umountErr)
+		}
+		return fmt.Errorf("unable to create upperdir and workdir on tmpfs: %w, output: %s", err, string(output))
+	}
+
+	klog.Infof("mounted tmpfs with options %v on %s", options, dir)
+	return nil
+}
+
+// unmountTmpfsUpper unmounts the tmpfs mounted by mountTmpfsUpper if any.
+func unmountTmpfsUpper(ctx context.Context, mounts []mount.Mount) error {
+	upper := overlayDir(mounts, "upperdir")
+	if upper == "" {
+		return nil
+	}
+
+	dir := filepath.Dir(upper)
+	cmd := sandbox.Tools.CommandContext(ctx,
+		"nsenter", "--mount="+hostMountNS, "--",
+		"findmnt", "--noheadings", "--types", "tmpfs", "--mountpoint", dir)
+	if err := cmd.Run(); err != nil {
+		// findmnt exits with 1 if the directory isn't a tmpfs mountpoint.
+		return nil
+	}
+
+	return unmountInHostNamespace(ctx, dir)
+}
+
+// resizeTmpfsUpper changes the size of the tmpfs mounted by mountTmpfsUpper online.
+func resizeTmpfsUpper(ctx context.Context, mounts []mount.Mount, size int64) error {
+	upper := overlayDir(mounts, "upperdir")
+	if upper == "" {
+		return fmt.Errorf("snapshot doesn't have an upperdir on tmpfs")
+	}
+
+	dir := filepath.Dir(upper)
+	cmd := sandbox.Tools.CommandContext(ctx,
+		"nsenter", "--mount="+hostMountNS, "--",
+		"mount", "-o", "remount,size="+strconv.FormatInt(size, 10), dir)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("unable to resize tmpfs on %s: %w, output: %s", dir, err, string(output))
+	}
+
+	klog.Infof("resized tmpfs on %s to %d bytes", dir, size)
+	return nil
+}
@@ -0,0 +1,59 @@
+package containerd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/warm-metal/container-image-csi-driver/pkg/sandbox"
+	"k8s.io/klog/v2"
+)
+
+// FormatVerity creates the dm-verity hash tree of the image in the host mount namespace.
+func (s snapshotMounter) FormatVerity(ctx context.Context, image, hashTree string) (string, error) {
+	cmd := sandbox.Tools.CommandContext(ctx,
+		"nsenter", "--mount="+hostMountNS, "--",
+		"veritysetup", "format", image, hashTree)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("veritysetup format failed: %w, output: %s", err, output)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		if hash, found := strings.CutPrefix(scanner.Text(), "Root hash:"); found {
+			return strings.TrimSpace(hash), nil
+		}
+	}
+
+	return "", fmt.Errorf("root hash is not found in the output of veritysetup: %s", output)
+}
+
+// OpenVerity maps the data device verified by the hash device in the host mount namespace.
+func (s snapshotMounter) OpenVerity(ctx context.Context, name, dataDevice, hashDevice, rootHash string) (string, error) {
+	cmd := sandbox.Tools.CommandContext(ctx,
+		"nsenter", "--mount="+hostMountNS, "--",
+		"veritysetup", "open", dataDevice, name, hashDevice, rootHash)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("veritysetup open failed: %w, output: %s", err, output)
+	}
+
+	device := "/dev/mapper/" + name
+	klog.V(4).Infof("opened verity device %s of %s", device, dataDevice)
+	return device, nil
+}
+
+// CloseVerity removes the verity device in the host mount namespace.
+func (s snapshotMounter) CloseVerity(ctx context.Context, name string) error {
+	cmd := sandbox.Tools.CommandContext(ctx,
+		"nsenter", "--mount="+hostMountNS, "--",
+		"veritysetup", "close", name)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("unable to close verity device %s: %w, output: %s", name, err, output)
+	}
+
+	klog.V(4).Infof("closed verity device %s", name)
+	return nil
+}
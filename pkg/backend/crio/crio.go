@@ -7,15 +7,17 @@ import (
 	"net"
 	"net/http"
 	"reflect"
+	"strconv"
 	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/distribution/reference"
 	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+	"github.com/warm-metal/container-image-csi-driver/pkg/errorlog"
 	"go.podman.io/storage"
 	"go.podman.io/storage/types"
 	"k8s.io/klog/v2"
-	k8smount "k8s.io/utils/mount"
+	k8smount "k8s.io/mount-utils"
 )
 
 type snapshotMounter struct {
@@ -33,29 +35,80 @@ func NewMounter(socketPath string) *backend.SnapshotMounter {
 	})
 }
 
-func (s snapshotMounter) Mount(_ context.Context, key backend.SnapshotKey, target backend.MountTarget, ro bool) error {
-	src, err := s.imageStore.Mount(string(key), "")
+// NewStorageMounter creates a mounter using the containers-storage configured by storage.conf, which can be
+// overridden via CONTAINERS_STORAGE_CONF. Storage in the home directory is used if the driver runs rootless,
+// e.g. the one of rootless Podman.
+func NewStorageMounter() *backend.SnapshotMounter {
+	opts, err := types.LoadStoreOptions(types.LoadOptions{})
 	if err != nil {
-		klog.Errorf("unable to mount snapshot %q: %s", key, err)
-		return err
+		klog.Fatalf("unable to load the configuration of containers-storage: %s", err)
 	}
 
-	mountOpts := []string{"rbind"}
-	if ro {
-		mountOpts = append(mountOpts, "ro")
+	klog.Infof("containers-storage configuration: %#v", opts)
+	store, err := storage.GetStore(opts)
+	if err != nil {
+		klog.Fatalf("unable to create image store: %s", err)
+	}
+
+	return backend.NewMounter(&snapshotMounter{
+		imageStore: store,
+	})
+}
+
+func (s snapshotMounter) Mount(
+	_ context.Context, key backend.SnapshotKey, target backend.MountTarget, opts backend.MountOptions,
+) error {
+	// The label only takes effect when the snapshot is mounted for the first time.
+	src, err := s.imageStore.Mount(string(key), opts.SELinuxContext)
+	if err != nil {
+		errorlog.Errorf("unable to mount snapshot %q: %s", key, err)
+		return err
 	}
 
-	if err = k8smount.New("").Mount(src, string(target), "", mountOpts); err != nil {
-		klog.Errorf("unable to bind %q to %q: %s", src, target, err)
+	if err = k8smount.New("").Mount(src, string(target), "", bindOptions(opts)); err != nil {
+		errorlog.Errorf("unable to bind %q to %q: %s", src, target, err)
 		return err
 	}
 
 	return nil
 }
 
+// bindOptions returns the options to bind a snapshot with the given mount options.
+func bindOptions(opts backend.MountOptions) []string {
+	if !opts.ReadOnly && len(opts.MountFlags) == 0 {
+		return []string{"rbind"}
+	}
+
+	// The kernel ignores per-mount flags like ro when creating a bind mount. The mounter only remounts
+	// a bind mount with the given flags for "bind", so use it instead of "rbind".
+	mountOpts := append([]string{"bind"}, opts.MountFlags...)
+	if opts.ReadOnly {
+		mountOpts = append(mountOpts, "ro")
+	}
+
+	return mountOpts
+}
+
 func (s snapshotMounter) Unmount(_ context.Context, target backend.MountTarget) error {
 	if err := k8smount.New("").Unmount(string(target)); err != nil {
-		klog.Errorf("unable to unmount %q: %s", target, err)
+		errorlog.Errorf("unable to unmount %q: %s", target, err)
+		return err
+	}
+
+	return nil
+}
+
+func (s snapshotMounter) MountMerged(
+	_ context.Context, _ []backend.SnapshotKey, _ backend.MountTarget, _ backend.MountOptions,
+) error {
+	return fmt.Errorf("merging images is not supported by cri-o")
+}
+
+func (s snapshotMounter) Bind(
+	_ context.Context, source string, target backend.MountTarget, opts backend.MountOptions,
+) error {
+	if err := k8smount.New("").Mount(source, string(target), "", bindOptions(opts)); err != nil {
+		errorlog.Errorf("unable to bind %q to %q: %s", source, target, err)
 		return err
 	}
 
@@ -64,14 +117,14 @@ func (s snapshotMounter) Unmount(_ context.Context, target backend.MountTarget)
 
 func (s snapshotMounter) ImageExists(ctx context.Context, image reference.Named) bool {
 	if _, err := s.imageStore.Image(image.String()); err != nil {
-		klog.Errorf("unable to retrieve the local image %q: %s", image, err)
+		errorlog.Errorf("unable to retrieve the local image %q: %s", image, err)
 		return false
 	}
 
 	return true
 }
 
-func (s snapshotMounter) GetImageIDOrDie(ctx context.Context, image reference.Named) string {
+func (s snapshotMounter) GetImageIDOrDie(ctx context.Context, image reference.Named, _ backend.MountOptions) string {
 	img, err := s.imageStore.Image(image.String())
 	if err != nil {
 		klog.Fatalf("unable to retrieve local image %q: %s", image, err)
@@ -82,25 +135,42 @@ func (s snapshotMounter) GetImageIDOrDie(ctx context.Context, image reference.Na
 
 func (s snapshotMounter) PrepareReadOnlySnapshot(
 	_ context.Context, imageID string, key backend.SnapshotKey, metadata backend.SnapshotMetadata,
+	mountOpts backend.MountOptions,
 ) error {
-	return s.prepareSnapshot(imageID, key, metadata, &storage.ContainerOptions{MountOpts: []string{"ro"}})
+	return s.prepareSnapshot(imageID, key, metadata, mountOpts, &storage.ContainerOptions{MountOpts: []string{"ro"}})
 }
 
 func (s snapshotMounter) PrepareRWSnapshot(
 	_ context.Context, imageID string, key backend.SnapshotKey, metadata backend.SnapshotMetadata,
+	mountOpts backend.MountOptions,
 ) error {
-	return s.prepareSnapshot(imageID, key, metadata, nil)
+	if mountOpts.TmpfsUpper {
+		return fmt.Errorf("tmpfs upper layers are not supported by cri-o")
+	}
+
+	var opts *storage.ContainerOptions
+	if mountOpts.Quota > 0 {
+		// Enforced by the storage driver if project quotas are enabled on its root.
+		opts = &storage.ContainerOptions{StorageOpt: map[string]string{"size": strconv.FormatInt(mountOpts.Quota, 10)}}
+	}
+
+	return s.prepareSnapshot(imageID, key, metadata, mountOpts, opts)
 }
 
 func (s snapshotMounter) prepareSnapshot(
-	imageID string, key backend.SnapshotKey, metadata backend.SnapshotMetadata, opts *storage.ContainerOptions,
+	imageID string, key backend.SnapshotKey, metadata backend.SnapshotMetadata, mountOpts backend.MountOptions,
+	opts *storage.ContainerOptions,
 ) error {
+	if mountOpts.FSType != "" {
+		return fmt.Errorf("filesystem %q is not supported by cri-o", mountOpts.FSType)
+	}
+
 	var metaString string
 	if metadata != nil {
 		metaString = metadata.Encode()
 	}
 
-	if opts != nil {
+	if opts != nil && len(opts.MountOpts) > 0 {
 		klog.Infof("create ro snapshot %q for image %q with metadata %#v(compressed length %d)",
 			key, imageID, metadata, len(metaString))
 	} else {
@@ -140,7 +210,7 @@ func (s snapshotMounter) prepareSnapshot(
 	}
 
 	if _, err = s.imageStore.CreateContainer(string(key), nil, imageID, "", metaString, opts); err != nil {
-		klog.Errorf("unable to create container for image %q: %s", imageID, err)
+		errorlog.Errorf("unable to create container for image %q: %s", imageID, err)
 		return err
 	}
 
@@ -154,22 +224,27 @@ func (s snapshotMounter) UpdateSnapshotMetadata(
 	klog.Infof("update metadata of snapshot %q to %#v(compressed length %d)", key, metadata, len(metaString))
 	err := s.imageStore.SetMetadata(string(key), metaString)
 	if err != nil {
-		klog.Errorf("unable to update metadata of snapshot %q: %s", key, err)
+		errorlog.Errorf("unable to update metadata of snapshot %q: %s", key, err)
 		return err
 	}
 
 	return err
 }
 
+func (s snapshotMounter) SnapshotExists(_ context.Context, key backend.SnapshotKey) bool {
+	_, err := s.imageStore.Container(string(key))
+	return err == nil
+}
+
 func (s snapshotMounter) DestroySnapshot(_ context.Context, key backend.SnapshotKey) error {
 	klog.Infof("unmount container %q", key)
 	if stillMounted, err := s.imageStore.Unmount(string(key), true); err != nil || stillMounted {
-		klog.Errorf("unable to unmount %q: %t %s", key, stillMounted, err)
+		errorlog.Errorf("unable to unmount %q: %t %s", key, stillMounted, err)
 	}
 
 	klog.Infof("remove container %q", key)
 	if err := s.imageStore.DeleteContainer(string(key)); err != nil {
-		klog.Errorf("unable to destroy container of image %q: %s", key, err)
+		errorlog.Errorf("unable to destroy container of image %q: %s", key, err)
 		return err
 	}
 
@@ -179,7 +254,7 @@ func (s snapshotMounter) DestroySnapshot(_ context.Context, key backend.Snapshot
 func (s snapshotMounter) ListSnapshots(context.Context) (ss []backend.SnapshotMetadata, err error) {
 	containers, err := s.imageStore.Containers()
 	if err != nil {
-		klog.Errorf("unable to list snapshots: %s", err)
+		errorlog.Errorf("unable to list snapshots: %s", err)
 		return nil, err
 	}
 
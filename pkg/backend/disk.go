@@ -0,0 +1,93 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/warm-metal/container-image-csi-driver/pkg/sandbox"
+	"k8s.io/klog/v2"
+)
+
+// ContainerDiskDir is the directory holding the VM disk in containerDisk images of KubeVirt.
+const ContainerDiskDir = "/disk"
+
+// qcow2Magic is the magic number at the beginning of qcow2 images.
+var qcow2Magic = []byte{'Q', 'F', 'I', 0xfb}
+
+// findDisk returns the disk in source, which is either the disk itself or a directory holding exactly one disk.
+func findDisk(source string) (string, error) {
+	fi, err := os.Stat(source)
+	if err != nil {
+		return "", err
+	}
+
+	if fi.Mode().IsRegular() {
+		return source, nil
+	}
+
+	entries, err := os.ReadDir(source)
+	if err != nil {
+		return "", err
+	}
+
+	var disks []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			disks = append(disks, filepath.Join(source, entry.Name()))
+		}
+	}
+
+	if len(disks) != 1 {
+		return "", fmt.Errorf("expect exactly one disk in %q, but found %d files", source, len(disks))
+	}
+
+	return disks[0], nil
+}
+
+// isQcow2 checks whether the disk is a qcow2 image.
+func isQcow2(disk string) (bool, error) {
+	f, err := os.Open(disk)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(qcow2Magic))
+	if _, err = io.ReadFull(f, magic); err != nil && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+
+	return bytes.Equal(magic, qcow2Magic), nil
+}
+
+// extractDisk saves the disk in source as a raw image at dest. qcow2 disks are converted to raw ones.
+func extractDisk(ctx context.Context, source, dest string) error {
+	disk, err := findDisk(source)
+	if err != nil {
+		return fmt.Errorf("unable to find the disk of the image: %w", err)
+	}
+
+	qcow2, err := isQcow2(disk)
+	if err != nil {
+		return err
+	}
+
+	var cmd *exec.Cmd
+	if qcow2 {
+		cmd = sandbox.Tools.CommandContext(ctx, "qemu-img", "convert", "-O", "raw", disk, dest)
+	} else {
+		cmd = sandbox.Tools.CommandContext(ctx, "cp", "--sparse=always", disk, dest)
+	}
+
+	klog.Infof("extract disk %q of the image, qcow2: %t", disk, qcow2)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("unable to extract disk %q: %w, output: %s", disk, err, output)
+	}
+
+	return nil
+}
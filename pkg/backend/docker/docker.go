@@ -0,0 +1,448 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/distribution/reference"
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend/containerd"
+	"github.com/warm-metal/container-image-csi-driver/pkg/errorlog"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// overlay2Driver is the only graph driver whose layers can be mounted by the driver.
+	overlay2Driver = "overlay2"
+
+	// snapshotKeyLabel is the label of containers created as snapshots, whose value is the snapshot key.
+	snapshotKeyLabel = "container-image.csi.k8s.io/snapshot"
+)
+
+var errNotFound = errors.New("not found")
+
+// Snapshots are mounted in the host mount namespace the same way as those of containerd, which tests replace.
+var (
+	mountInHostNamespace   = containerd.MountInHostNamespace
+	bindInHostNamespace    = containerd.BindInHostNamespace
+	unmountInHostNamespace = containerd.UnmountInHostNamespace
+)
+
+// snapshotMounter mounts images of Docker Engine, which are pulled via cri-dockerd. Snapshots are containers
+// which are created but never started, and are mounted via layers of the overlay2 graph driver directly.
+// Since labels of containers are immutable, metadata of snapshots are saved in metadataDir.
+type snapshotMounter struct {
+	cli         *http.Client
+	metadataDir string
+}
+
+// NewMounter creates a mounter for the Docker Engine listening on the socket. Metadata of snapshots are
+// saved in metadataDir.
+func NewMounter(socketPath, metadataDir string) *backend.SnapshotMounter {
+	if err := os.MkdirAll(metadataDir, 0o700); err != nil {
+		klog.Fatalf("unable to create the directory for snapshot metadata: %s", err)
+	}
+
+	s := newSnapshotMounter(socketPath, metadataDir)
+	info := struct{ Driver string }{}
+	if err := s.call(context.TODO(), http.MethodGet, "/info", nil, nil, &info); err != nil {
+		klog.Fatalf("unable to fetch Docker Engine configuration: %s", err)
+	}
+
+	if info.Driver != overlay2Driver {
+		klog.Fatalf("storage driver %q of Docker Engine is not supported. Only %q is supported",
+			info.Driver, overlay2Driver)
+	}
+
+	return backend.NewMounter(s)
+}
+
+func newSnapshotMounter(socketPath, metadataDir string) snapshotMounter {
+	return snapshotMounter{
+		cli: &http.Client{Transport: &http.Transport{
+			DisableCompression: true,
+			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+				return net.DialTimeout("unix", socketPath, 32*time.Second)
+			},
+		}},
+		metadataDir: metadataDir,
+	}
+}
+
+// call sends a request to the Docker Engine API and decodes the response into out if it is not nil.
+func (s snapshotMounter) call(ctx context.Context, method, path string, query url.Values, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, "http://docker"+path, body)
+	if err != nil {
+		return fmt.Errorf("unable to create http request: %w", err)
+	}
+
+	req.URL.RawQuery = query.Encode()
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.cli.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s %s: %w", method, path, errNotFound)
+	}
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s failed with status %d: %s", method, path, resp.StatusCode, data)
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	return json.Unmarshal(data, out)
+}
+
+// containerName returns the name of the container of the snapshot, since snapshot keys may contain
+// characters not allowed in names of containers.
+func containerName(key backend.SnapshotKey) string {
+	return fmt.Sprintf("csi-%x", sha256.Sum256([]byte(key)))
+}
+
+type graphDriver struct {
+	Name string
+	Data map[string]string
+}
+
+type containerJSON struct {
+	Image       string
+	GraphDriver graphDriver
+}
+
+func (s snapshotMounter) inspectContainer(ctx context.Context, key backend.SnapshotKey) (*containerJSON, error) {
+	c := &containerJSON{}
+	if err := s.call(ctx, http.MethodGet, "/containers/"+containerName(key)+"/json", nil, nil, c); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (s snapshotMounter) Mount(
+	ctx context.Context, key backend.SnapshotKey, target backend.MountTarget, opts backend.MountOptions,
+) error {
+	c, err := s.inspectContainer(ctx, key)
+	if err != nil {
+		errorlog.Errorf("unable to retrieve layers of snapshot %q: %s", key, err)
+		return err
+	}
+
+	if c.GraphDriver.Name != overlay2Driver {
+		return fmt.Errorf("graph driver %q of snapshot %q is not supported", c.GraphDriver.Name, key)
+	}
+
+	lowerDir, upperDir := c.GraphDriver.Data["LowerDir"], c.GraphDriver.Data["UpperDir"]
+	var options []string
+	if opts.ReadOnly {
+		// The upper layer of the snapshot is empty, so it is the topmost lower layer of read-only volumes.
+		options = []string{"lowerdir=" + upperDir + ":" + lowerDir}
+	} else {
+		options = []string{
+			"lowerdir=" + lowerDir, "upperdir=" + upperDir, "workdir=" + c.GraphDriver.Data["WorkDir"],
+		}
+	}
+
+	options = append(options, opts.MountFlags...)
+	if opts.ReadOnly {
+		options = append(options, "ro")
+	}
+
+	mounts := []mount.Mount{{Type: "overlay", Source: "overlay", Options: options}}
+	if err = mountInHostNamespace(ctx, mounts, string(target), opts.SELinuxContext); err != nil {
+		errorlog.Errorf("unable to mount snapshot %q to target %s: %s", key, target, err)
+		return err
+	}
+
+	return nil
+}
+
+func (s snapshotMounter) Unmount(ctx context.Context, target backend.MountTarget) error {
+	if err := unmountInHostNamespace(ctx, string(target)); err != nil {
+		errorlog.Errorf("fail to unmount %s: %s", target, err)
+		return err
+	}
+
+	return nil
+}
+
+func (s snapshotMounter) MountMerged(
+	_ context.Context, _ []backend.SnapshotKey, _ backend.MountTarget, _ backend.MountOptions,
+) error {
+	return fmt.Errorf("merging images is not supported by docker")
+}
+
+func (s snapshotMounter) Bind(
+	_ context.Context, source string, target backend.MountTarget, opts backend.MountOptions,
+) error {
+	options := append([]string{"rbind"}, opts.MountFlags...)
+	if opts.ReadOnly {
+		options = append(options, "ro")
+	}
+
+	if err := bindInHostNamespace(source, string(target), options); err != nil {
+		errorlog.Errorf("unable to bind %q to %q: %s", source, target, err)
+		return err
+	}
+
+	return nil
+}
+
+type imageJSON struct {
+	ID string `json:"Id"`
+}
+
+func (s snapshotMounter) inspectImage(ctx context.Context, image reference.Named) (*imageJSON, error) {
+	img := &imageJSON{}
+	if err := s.call(ctx, http.MethodGet, "/images/"+image.String()+"/json", nil, nil, img); err != nil {
+		return nil, err
+	}
+
+	return img, nil
+}
+
+func (s snapshotMounter) ImageExists(ctx context.Context, image reference.Named) bool {
+	if _, err := s.inspectImage(ctx, image); err != nil {
+		errorlog.Errorf("unable to retrieve the local image %q: %s", image, err)
+		return false
+	}
+
+	return true
+}
+
+func (s snapshotMounter) GetImageIDOrDie(ctx context.Context, image reference.Named, _ backend.MountOptions) string {
+	img, err := s.inspectImage(ctx, image)
+	if err != nil {
+		klog.Fatalf("unable to retrieve local image %q: %s", image, err)
+	}
+
+	return img.ID
+}
+
+func (s snapshotMounter) PrepareReadOnlySnapshot(
+	ctx context.Context, imageID string, key backend.SnapshotKey, metadata backend.SnapshotMetadata,
+	opts backend.MountOptions,
+) error {
+	return s.prepareSnapshot(ctx, imageID, key, metadata, opts, false)
+}
+
+func (s snapshotMounter) PrepareRWSnapshot(
+	ctx context.Context, imageID string, key backend.SnapshotKey, metadata backend.SnapshotMetadata,
+	opts backend.MountOptions,
+) error {
+	if opts.TmpfsUpper {
+		return fmt.Errorf("tmpfs upper layers are not supported by docker")
+	}
+
+	return s.prepareSnapshot(ctx, imageID, key, metadata, opts, true)
+}
+
+func (s snapshotMounter) prepareSnapshot(
+	ctx context.Context, imageID string, key backend.SnapshotKey, metadata backend.SnapshotMetadata,
+	opts backend.MountOptions, writable bool,
+) error {
+	if opts.FSType != "" {
+		return fmt.Errorf("filesystem %q is not supported by docker", opts.FSType)
+	}
+
+	if writable {
+		klog.Infof("create rw snapshot %q for image %q with metadata %#v", key, imageID, metadata)
+	} else {
+		klog.Infof("create ro snapshot %q for image %q with metadata %#v", key, imageID, metadata)
+	}
+
+	c, err := s.inspectContainer(ctx, key)
+	if err == nil {
+		if c.Image != imageID {
+			return fmt.Errorf("found existed snapshot %q with different image %#v", key, c.Image)
+		}
+
+		if metadata == nil {
+			klog.Infof("found existed snapshot %q, use it", key)
+			return nil
+		}
+
+		existedMetadata, err := s.readMetadata(key)
+		if err != nil {
+			return fmt.Errorf("found existed snapshot %q with unknown metadata: %w", key, err)
+		}
+
+		for k, v := range metadata {
+			if !reflect.DeepEqual(v, existedMetadata[k]) {
+				return fmt.Errorf("found existed snapshot %q with different configuration %#v", key,
+					existedMetadata)
+			}
+		}
+
+		klog.Infof("found existed snapshot %q, use it", key)
+		return nil
+	}
+
+	if !errors.Is(err, errNotFound) {
+		return err
+	}
+
+	config := map[string]any{
+		"Image": imageID,
+		// Snapshots are never started, while containers without commands can't be created.
+		"Cmd":             []string{"csi-snapshot"},
+		"NetworkDisabled": true,
+		"Labels":          map[string]string{snapshotKeyLabel: string(key)},
+	}
+
+	if writable && opts.Quota > 0 {
+		// Enforced by the graph driver if project quotas are enabled on its root.
+		config["HostConfig"] = map[string]any{
+			"StorageOpt": map[string]string{"size": strconv.FormatInt(opts.Quota, 10)},
+		}
+	}
+
+	query := url.Values{"name": []string{containerName(key)}}
+	if err = s.call(ctx, http.MethodPost, "/containers/create", query, config, nil); err != nil {
+		errorlog.Errorf("unable to create container for image %q: %s", imageID, err)
+		return err
+	}
+
+	if metadata != nil {
+		if err = s.UpdateSnapshotMetadata(ctx, key, metadata); err != nil {
+			if rmErr := s.DestroySnapshot(ctx, key); rmErr != nil {
+				errorlog.Errorf("unable to remove the snapshot %q: %s", key, rmErr)
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s snapshotMounter) metadataFile(key backend.SnapshotKey) string {
+	return filepath.Join(s.metadataDir, containerName(key))
+}
+
+func (s snapshotMounter) readMetadata(key backend.SnapshotKey) (backend.SnapshotMetadata, error) {
+	data, err := os.ReadFile(s.metadataFile(key))
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := make(backend.SnapshotMetadata)
+	if err = metadata.Decode(string(data)); err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+func (s snapshotMounter) UpdateSnapshotMetadata(
+	_ context.Context, key backend.SnapshotKey, metadata backend.SnapshotMetadata,
+) error {
+	klog.Infof("update metadata of snapshot %q to %#v", key, metadata)
+	file := s.metadataFile(key)
+	if err := os.WriteFile(file+".tmp", []byte(metadata.Encode()), 0o600); err != nil {
+		errorlog.Errorf("unable to update metadata of snapshot %q: %s", key, err)
+		return err
+	}
+
+	if err := os.Rename(file+".tmp", file); err != nil {
+		errorlog.Errorf("unable to update metadata of snapshot %q: %s", key, err)
+		return err
+	}
+
+	return nil
+}
+
+func (s snapshotMounter) SnapshotExists(ctx context.Context, key backend.SnapshotKey) bool {
+	_, err := s.inspectContainer(ctx, key)
+	return err == nil
+}
+
+func (s snapshotMounter) CheckRuntime(ctx context.Context) error {
+	return s.call(ctx, http.MethodGet, "/_ping", nil, nil, nil)
+}
+
+func (s snapshotMounter) DestroySnapshot(ctx context.Context, key backend.SnapshotKey) error {
+	klog.Infof("remove container %q of snapshot %q", containerName(key), key)
+	query := url.Values{"force": []string{"true"}}
+	if err := s.call(ctx, http.MethodDelete, "/containers/"+containerName(key), query, nil, nil); err != nil {
+		errorlog.Errorf("unable to remove the snapshot %q: %s", key, err)
+		return err
+	}
+
+	if err := os.Remove(s.metadataFile(key)); err != nil && !os.IsNotExist(err) {
+		klog.Warningf("unable to remove metadata of snapshot %q: %s", key, err)
+	}
+
+	return nil
+}
+
+func (s snapshotMounter) ListSnapshots(ctx context.Context) (ss []backend.SnapshotMetadata, err error) {
+	filters, err := json.Marshal(map[string][]string{"label": {snapshotKeyLabel}})
+	if err != nil {
+		return nil, err
+	}
+
+	var containers []struct {
+		Labels map[string]string
+	}
+	query := url.Values{"all": []string{"true"}, "filters": []string{string(filters)}}
+	if err = s.call(ctx, http.MethodGet, "/containers/json", query, nil, &containers); err != nil {
+		errorlog.Errorf("unable to list snapshots: %s", err)
+		return nil, err
+	}
+
+	klog.Infof("found %d containers", len(containers))
+
+	for _, c := range containers {
+		key := backend.SnapshotKey(c.Labels[snapshotKeyLabel])
+		metadata, err := s.readMetadata(key)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				klog.Warningf("unable to decode the metadata of snapshot %q: %s", key, err)
+			}
+			continue
+		}
+
+		metadata.SetSnapshotKey(string(key))
+		ss = append(ss, metadata)
+		klog.Infof("got ro snapshot %q with targets %#v", key, metadata.GetTargets())
+	}
+
+	return
+}
@@ -0,0 +1,304 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/distribution/reference"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+)
+
+// fakeEngine serves the parts of the Docker Engine API the backend uses. Images are those pulled by cri-dockerd,
+// keyed by their references.
+type fakeEngine struct {
+	guard      sync.Mutex
+	images     map[string]string
+	containers map[string]fakeContainer
+	// failCreate fails creating containers if set.
+	failCreate bool
+}
+
+type fakeContainer struct {
+	Image       string
+	Labels      map[string]string
+	HostConfig  map[string]any
+	GraphDriver graphDriver
+}
+
+// newFakeEngine serves the engine on a unix socket, and returns the mounter of it.
+func newFakeEngine(t *testing.T) (*fakeEngine, snapshotMounter) {
+	e := &fakeEngine{
+		images:     map[string]string{"docker.io/library/redis:latest": "sha256:redis"},
+		containers: make(map[string]fakeContainer),
+	}
+
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	server := httptest.NewUnstartedServer(e)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+	return e, newSnapshotMounter(socket, dir)
+}
+
+func (e *fakeEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.guard.Lock()
+	defer e.guard.Unlock()
+
+	path := r.URL.Path
+	switch {
+	case r.Method == http.MethodGet && path == "/_ping":
+		w.Write([]byte("OK"))
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/images/") && strings.HasSuffix(path, "/json"):
+		id, found := e.images[strings.TrimSuffix(strings.TrimPrefix(path, "/images/"), "/json")]
+		if !found {
+			http.Error(w, `{"message":"No such image"}`, http.StatusNotFound)
+			return
+		}
+
+		json.NewEncoder(w).Encode(imageJSON{ID: id})
+	case r.Method == http.MethodPost && path == "/containers/create":
+		e.createContainer(w, r)
+	case r.Method == http.MethodGet && path == "/containers/json":
+		var filters map[string][]string
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		containers := []fakeContainer{}
+		for _, c := range e.containers {
+			if _, found := c.Labels[filters["label"][0]]; found {
+				containers = append(containers, c)
+			}
+		}
+
+		json.NewEncoder(w).Encode(containers)
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/json"):
+		c, found := e.containers[strings.TrimSuffix(strings.TrimPrefix(path, "/containers/"), "/json")]
+		if !found {
+			http.Error(w, `{"message":"No such container"}`, http.StatusNotFound)
+			return
+		}
+
+		json.NewEncoder(w).Encode(c)
+	case r.Method == http.MethodDelete:
+		name := strings.TrimPrefix(path, "/containers/")
+		if _, found := e.containers[name]; !found {
+			http.Error(w, `{"message":"No such container"}`, http.StatusNotFound)
+			return
+		}
+
+		delete(e.containers, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unexpected request "+r.Method+" "+path, http.StatusInternalServerError)
+	}
+}
+
+func (e *fakeEngine) createContainer(w http.ResponseWriter, r *http.Request) {
+	if e.failCreate {
+		http.Error(w, `{"message":"no space left on device"}`, http.StatusInternalServerError)
+		return
+	}
+
+	var c fakeContainer
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if _, found := e.containers[name]; found {
+		http.Error(w, `{"message":"Conflict"}`, http.StatusConflict)
+		return
+	}
+
+	c.GraphDriver = graphDriver{Name: overlay2Driver, Data: map[string]string{
+		"LowerDir": "/docker/overlay2/" + name + "/lower", "UpperDir": "/docker/overlay2/" + name + "/diff",
+		"WorkDir": "/docker/overlay2/" + name + "/work",
+	}}
+	e.containers[name] = c
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(`{"Id":"` + name + `"}`))
+}
+
+// hostMounts records mounts in the host mount namespace instead of mounting them.
+type hostMounts struct {
+	mounts   map[string][]string
+	unmounts []string
+	// err fails all mounts and unmounts if set.
+	err error
+}
+
+func recordHostMounts(t *testing.T) *hostMounts {
+	h := &hostMounts{mounts: make(map[string][]string)}
+	mountFn, bindFn, unmountFn := mountInHostNamespace, bindInHostNamespace, unmountInHostNamespace
+	t.Cleanup(func() { mountInHostNamespace, bindInHostNamespace, unmountInHostNamespace = mountFn, bindFn, unmountFn })
+
+	mountInHostNamespace = func(_ context.Context, mounts []mount.Mount, target string, _ string) error {
+		if h.err != nil {
+			return h.err
+		}
+
+		h.mounts[target] = mounts[0].Options
+		return nil
+	}
+	bindInHostNamespace = func(source, target string, options []string) error {
+		if h.err != nil {
+			return h.err
+		}
+
+		h.mounts[target] = append([]string{source}, options...)
+		return nil
+	}
+	unmountInHostNamespace = func(_ context.Context, target string) error {
+		if h.err != nil {
+			return h.err
+		}
+
+		h.unmounts = append(h.unmounts, target)
+		return nil
+	}
+	return h
+}
+
+func TestImages(t *testing.T) {
+	_, s := newFakeEngine(t)
+	ctx := context.Background()
+
+	// Images pulled by cri-dockerd are mounted by their IDs.
+	redis, err := reference.ParseDockerRef("redis")
+	require.NoError(t, err)
+	assert.True(t, s.ImageExists(ctx, redis))
+	assert.Equal(t, "sha256:redis", s.GetImageIDOrDie(ctx, redis, backend.MountOptions{}))
+
+	nginx, err := reference.ParseDockerRef("nginx")
+	require.NoError(t, err)
+	assert.False(t, s.ImageExists(ctx, nginx))
+	assert.NoError(t, s.CheckRuntime(ctx))
+}
+
+func TestPrepareSnapshot(t *testing.T) {
+	e, s := newFakeEngine(t)
+	ctx := context.Background()
+	metadata := backend.SnapshotMetadata{}
+	metadata.SetTargets(map[backend.MountTarget]struct{}{"/target": {}})
+
+	require.NoError(t, s.PrepareReadOnlySnapshot(ctx, "sha256:redis", "ro", metadata, backend.MountOptions{}))
+	c := e.containers[containerName("ro")]
+	assert.Equal(t, "sha256:redis", c.Image)
+	assert.Equal(t, map[string]string{snapshotKeyLabel: "ro"}, c.Labels)
+	assert.True(t, s.SnapshotExists(ctx, "ro"))
+
+	// Existing snapshots are reused, unless they are of other images.
+	require.NoError(t, s.PrepareReadOnlySnapshot(ctx, "sha256:redis", "ro", nil, backend.MountOptions{}))
+	assert.Len(t, e.containers, 1)
+	assert.ErrorContains(t, s.PrepareReadOnlySnapshot(ctx, "sha256:nginx", "ro", nil, backend.MountOptions{}),
+		"with different image")
+
+	require.NoError(t, s.PrepareRWSnapshot(ctx, "sha256:redis", "rw", nil, backend.MountOptions{Quota: 1 << 30}))
+	assert.Equal(t, map[string]any{"StorageOpt": map[string]any{"size": "1073741824"}},
+		e.containers[containerName("rw")].HostConfig)
+	assert.Nil(t, e.containers[containerName("ro")].HostConfig, "quotas only apply to writable snapshots")
+
+	assert.ErrorContains(t, s.PrepareRWSnapshot(ctx, "sha256:redis", "tmpfs", nil,
+		backend.MountOptions{TmpfsUpper: true}), "not supported")
+	assert.ErrorContains(t, s.PrepareReadOnlySnapshot(ctx, "sha256:redis", "ext4", nil,
+		backend.MountOptions{FSType: "ext4"}), "not supported")
+
+	e.failCreate = true
+	assert.ErrorContains(t, s.PrepareReadOnlySnapshot(ctx, "sha256:redis", "full", nil, backend.MountOptions{}),
+		"no space left on device")
+	assert.False(t, s.SnapshotExists(ctx, "full"))
+}
+
+func TestMount(t *testing.T) {
+	e, s := newFakeEngine(t)
+	h := recordHostMounts(t)
+	ctx := context.Background()
+	require.NoError(t, s.PrepareReadOnlySnapshot(ctx, "sha256:redis", "ro", nil, backend.MountOptions{}))
+	require.NoError(t, s.PrepareRWSnapshot(ctx, "sha256:redis", "rw", nil, backend.MountOptions{}))
+	ro, rw := e.containers[containerName("ro")].GraphDriver.Data, e.containers[containerName("rw")].GraphDriver.Data
+
+	// The empty upper layer of read-only snapshots is their topmost lower layer.
+	require.NoError(t, s.Mount(ctx, "ro", "/ro", backend.MountOptions{ReadOnly: true, MountFlags: []string{"noexec"}}))
+	assert.Equal(t, []string{"lowerdir=" + ro["UpperDir"] + ":" + ro["LowerDir"], "noexec", "ro"}, h.mounts["/ro"])
+
+	require.NoError(t, s.Mount(ctx, "rw", "/rw", backend.MountOptions{}))
+	assert.Equal(t, []string{
+		"lowerdir=" + rw["LowerDir"], "upperdir=" + rw["UpperDir"], "workdir=" + rw["WorkDir"],
+	}, h.mounts["/rw"])
+
+	require.NoError(t, s.Bind(ctx, "/ro", "/bound", backend.MountOptions{ReadOnly: true}))
+	assert.Equal(t, []string{"/ro", "rbind", "ro"}, h.mounts["/bound"])
+
+	require.NoError(t, s.Unmount(ctx, "/ro"))
+	assert.Equal(t, []string{"/ro"}, h.unmounts)
+
+	assert.ErrorIs(t, s.Mount(ctx, "missing", "/missing", backend.MountOptions{}), errNotFound)
+	assert.Error(t, s.MountMerged(ctx, []backend.SnapshotKey{"ro", "rw"}, "/merged", backend.MountOptions{}))
+
+	c := e.containers[containerName("rw")]
+	c.GraphDriver.Name = "vfs"
+	e.containers[containerName("rw")] = c
+	assert.ErrorContains(t, s.Mount(ctx, "rw", "/vfs", backend.MountOptions{}), `graph driver "vfs"`)
+
+	h.err = errors.New("permission denied")
+	assert.ErrorIs(t, s.Mount(ctx, "ro", "/denied", backend.MountOptions{ReadOnly: true}), h.err)
+	assert.ErrorIs(t, s.Unmount(ctx, "/rw"), h.err)
+	assert.NotContains(t, h.mounts, "/denied")
+}
+
+func TestSnapshots(t *testing.T) {
+	e, s := newFakeEngine(t)
+	ctx := context.Background()
+	metadata := backend.SnapshotMetadata{}
+	metadata.SetTargets(map[backend.MountTarget]struct{}{"/target": {}})
+	require.NoError(t, s.PrepareReadOnlySnapshot(ctx, "sha256:redis", "ro", metadata, backend.MountOptions{}))
+	require.NoError(t, s.PrepareRWSnapshot(ctx, "sha256:redis", "rw", nil, backend.MountOptions{}))
+	e.containers["app"] = fakeContainer{Image: "sha256:redis"}
+
+	// Snapshots without metadata, and containers which aren't snapshots are not listed.
+	snapshots, err := s.ListSnapshots(ctx)
+	require.NoError(t, err)
+	require.Len(t, snapshots, 1)
+	assert.Equal(t, backend.SnapshotKey("ro"), snapshots[0].GetSnapshotKey())
+	assert.Equal(t, map[backend.MountTarget]struct{}{"/target": {}}, snapshots[0].GetTargets())
+
+	require.NoError(t, s.DestroySnapshot(ctx, "ro"))
+	assert.NoFileExists(t, s.metadataFile("ro"))
+	assert.False(t, s.SnapshotExists(ctx, "ro"))
+	assert.ErrorIs(t, s.DestroySnapshot(ctx, "ro"), errNotFound)
+	assert.Contains(t, e.containers, "app")
+
+	snapshots, err = s.ListSnapshots(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, snapshots)
+}
+
+func TestEngineUnavailable(t *testing.T) {
+	s := newSnapshotMounter(filepath.Join(t.TempDir(), "docker.sock"), t.TempDir())
+	ctx := context.Background()
+
+	assert.Error(t, s.CheckRuntime(ctx))
+	redis, err := reference.ParseDockerRef("redis")
+	require.NoError(t, err)
+	assert.False(t, s.ImageExists(ctx, redis))
+	assert.Error(t, s.PrepareReadOnlySnapshot(ctx, "sha256:redis", "ro", nil, backend.MountOptions{}))
+	_, err = s.ListSnapshots(ctx)
+	assert.Error(t, err)
+}
@@ -0,0 +1,73 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/klog/v2"
+)
+
+// WritableLayerResizer is implemented by runtimes which can change the quota of writable layers online.
+type WritableLayerResizer interface {
+	// ResizeWritableLayer limits the writable layer of the read-write snapshot to size bytes.
+	ResizeWritableLayer(ctx context.Context, key SnapshotKey, size int64, opts MountOptions) error
+}
+
+// VolumeExpander is implemented by mounters which can expand volumes online.
+type VolumeExpander interface {
+	// ExpandVolume grows the quota of the writable layer of the volume mounted or published at the target.
+	ExpandVolume(ctx context.Context, target MountTarget, size int64) error
+}
+
+// ExpandVolume grows the quota of a read-write volume mounted since the driver started. Quotas never shrink.
+func (s *SnapshotMounter) ExpandVolume(ctx context.Context, target MountTarget, size int64) error {
+	resizer, ok := s.runtime.(WritableLayerResizer)
+	if !ok {
+		return fmt.Errorf("the container runtime doesn't support resizing writable layers")
+	}
+
+	s.stagingGuard.Lock()
+	if stagingTarget, found := s.publications[target]; found {
+		target = stagingTarget
+	}
+	s.stagingGuard.Unlock()
+
+	s.volumesGuard.Lock()
+	v, found := s.volumes[target]
+	var opts MountOptions
+	if found {
+		opts = v.opts
+	}
+	s.volumesGuard.Unlock()
+
+	if !found {
+		return fmt.Errorf("volume at %q is unknown", target)
+	}
+
+	if opts.ReadOnly {
+		return fmt.Errorf("read-only volumes can't be expanded")
+	}
+
+	if opts.Quota <= 0 {
+		return fmt.Errorf("volume at %q has no quota to expand", target)
+	}
+
+	if size <= opts.Quota {
+		klog.Infof("quota of volume at %q is already %d bytes, no less than %d", target, opts.Quota, size)
+		return nil
+	}
+
+	key, _ := s.snapshotOf(target)
+	if err := resizer.ResizeWritableLayer(ctx, key, size, opts); err != nil {
+		return err
+	}
+
+	s.volumesGuard.Lock()
+	defer s.volumesGuard.Unlock()
+	if v, found := s.volumes[target]; found {
+		v.opts.Quota = size
+		s.state.save(newVolumeRecord(v.volumeId, target, v.image, v.opts))
+	}
+
+	return nil
+}
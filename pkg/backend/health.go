@@ -0,0 +1,232 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/warm-metal/container-image-csi-driver/pkg/errorlog"
+	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
+	"k8s.io/klog/v2"
+	k8smount "k8s.io/mount-utils"
+)
+
+// publishedVolume records how a volume was mounted, so that it can be mounted again once it's broken.
+type publishedVolume struct {
+	volumeId string
+	image    reference.Named
+	opts     MountOptions
+}
+
+func (s *SnapshotMounter) recordVolume(volumeId string, target MountTarget, image reference.Named, opts MountOptions) {
+	s.volumesGuard.Lock()
+	defer s.volumesGuard.Unlock()
+	s.volumes[target] = &publishedVolume{volumeId: volumeId, image: image, opts: opts}
+	s.state.save(newVolumeRecord(volumeId, target, image, opts))
+}
+
+func (s *SnapshotMounter) forgetVolume(target MountTarget) {
+	s.volumesGuard.Lock()
+	defer s.volumesGuard.Unlock()
+	delete(s.volumes, target)
+	s.state.remove(target)
+}
+
+// ImageUser is implemented by mounters tracking images of mounted volumes.
+type ImageUser interface {
+	// ImageInUse returns true if any mounted volume is of the image or overlays it.
+	ImageInUse(image reference.Named) bool
+}
+
+// ImageInUse implements ImageUser.
+func (s *SnapshotMounter) ImageInUse(image reference.Named) bool {
+	s.volumesGuard.Lock()
+	defer s.volumesGuard.Unlock()
+	for _, v := range s.volumes {
+		if v.image.String() == image.String() {
+			return true
+		}
+
+		for _, overlay := range v.opts.OverlayImages {
+			if overlay.String() == image.String() {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// RuntimeHealthChecker is implemented by runtimes serving snapshots via a socket, which can become unreachable.
+type RuntimeHealthChecker interface {
+	// CheckRuntime returns an error if the container runtime is unreachable.
+	CheckRuntime(ctx context.Context) error
+}
+
+// CheckMount verifies that the target is still a working mount. A mount can break if its snapshot
+// was changed or removed by the container runtime, e.g. after the runtime restarted. It also fails if
+// the runtime is unreachable, since snapshots of the volume can't be verified then.
+func (s *SnapshotMounter) CheckMount(ctx context.Context, target MountTarget) error {
+	if err := s.checkMountPoint(target); err != nil {
+		return err
+	}
+
+	if checker, ok := s.runtime.(RuntimeHealthChecker); ok {
+		if err := checker.CheckRuntime(ctx); err != nil {
+			return fmt.Errorf("the container runtime is unreachable: %w", err)
+		}
+	}
+
+	key, found := s.snapshotOf(target)
+	if found && !s.runtime.SnapshotExists(ctx, key) {
+		return fmt.Errorf("snapshot %q of the volume is missing", key)
+	}
+
+	return nil
+}
+
+// snapshotOf returns the key of the snapshot backing the target, or the staged volume the target is
+// published from. Volumes not mounted since the driver started and block volumes are not backed by snapshots.
+func (s *SnapshotMounter) snapshotOf(target MountTarget) (SnapshotKey, bool) {
+	s.stagingGuard.Lock()
+	if stagingTarget, found := s.publications[target]; found {
+		target = stagingTarget
+	}
+	s.stagingGuard.Unlock()
+
+	s.guard.Lock()
+	key, found := s.targetRoSnapshotMap[target]
+	s.guard.Unlock()
+	if found {
+		return key, true
+	}
+
+	s.volumesGuard.Lock()
+	defer s.volumesGuard.Unlock()
+	v, found := s.volumes[target]
+	if !found || v.opts.ReadOnly {
+		return "", false
+	}
+
+	if v.opts.PersistentScratch {
+		return GenScratchKey(v.volumeId), true
+	}
+
+	return GenSnapshotKey(v.volumeId), true
+}
+
+// checkMountPoint verifies that the target is still mounted and readable. Stale overlay mounts fail
+// to be read.
+func (s *SnapshotMounter) checkMountPoint(target MountTarget) error {
+	fi, err := os.Stat(string(target))
+	if err != nil {
+		return fmt.Errorf("unable to stat the mount: %w", err)
+	}
+
+	notMnt, err := k8smount.IsNotMountPoint(k8smount.New(""), string(target))
+	if err != nil {
+		return fmt.Errorf("unable to check the mount: %w", err)
+	}
+
+	if notMnt {
+		return fmt.Errorf("%s is not mounted", target)
+	}
+
+	if !fi.IsDir() {
+		return nil
+	}
+
+	// Stale overlay mounts can still be stat but fail to list their lowerdirs.
+	dir, err := os.Open(string(target))
+	if err != nil {
+		return fmt.Errorf("unable to open the mount: %w", err)
+	}
+	defer dir.Close()
+
+	if _, err = dir.Readdirnames(1); err != nil && err != io.EOF {
+		return fmt.Errorf("unable to read the mount: %w", err)
+	}
+
+	return nil
+}
+
+// StartHealthCheck checks all volumes mounted since the driver started every period until the context
+// is done. Broken read-only volumes are mounted again. Read-write volumes are left as is since their
+// writable layers can't be recovered.
+func (s *SnapshotMounter) StartHealthCheck(ctx context.Context, period time.Duration) {
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.checkVolumes(ctx)
+			}
+		}
+	}()
+}
+
+func (s *SnapshotMounter) checkVolumes(ctx context.Context) {
+	s.volumesGuard.Lock()
+	volumes := make(map[MountTarget]publishedVolume, len(s.volumes))
+	for target, v := range s.volumes {
+		volumes[target] = *v
+	}
+	s.volumesGuard.Unlock()
+
+	for target, v := range volumes {
+		// Volumes are only remounted if their mounts are broken.
+		err := s.checkMountPoint(target)
+		if err == nil {
+			continue
+		}
+
+		errorlog.Errorf("volume %q at %q is broken: %s", v.volumeId, target, err)
+		if !v.opts.ReadOnly {
+			continue
+		}
+
+		if err = s.remount(ctx, target, v); err != nil {
+			errorlog.Errorf("unable to remount volume %q at %q: %s", v.volumeId, target, err)
+			metrics.OperationErrorsCount.WithLabelValues("remount").Inc()
+			continue
+		}
+
+		klog.Infof("volume %q is mounted to %q again", v.volumeId, target)
+	}
+}
+
+// remount releases the broken mount of a read-only volume and its snapshots, then mounts it again.
+func (s *SnapshotMounter) remount(ctx context.Context, target MountTarget, v publishedVolume) error {
+	unlock := s.volumeLocks.lock(v.volumeId)
+	defer unlock()
+
+	// The volume may have been unmounted while waiting for the lock.
+	s.volumesGuard.Lock()
+	_, found := s.volumes[target]
+	s.volumesGuard.Unlock()
+	if !found {
+		return nil
+	}
+
+	// Stale mounts may fail the check itself, so try to unmount them anyway.
+	if notMnt, err := k8smount.IsNotMountPoint(k8smount.New(""), string(target)); err != nil || !notMnt {
+		if err = s.runtime.Unmount(ctx, target); err != nil {
+			return err
+		}
+	}
+
+	if err := s.unmountStagingDir(ctx, target); err != nil {
+		return err
+	}
+
+	s.unrefOverlayImages(ctx, target)
+	s.unrefROSnapshot(ctx, target)
+	s.forgetVolume(target)
+	return s.mount(ctx, v.volumeId, target, v.image, v.opts)
+}
@@ -0,0 +1,116 @@
+package backend
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/distribution/reference"
+	"k8s.io/klog/v2"
+)
+
+// ImageMetadataDir is the directory at the root of volumes which holds metadata of the mounted image.
+const ImageMetadataDir = ".image-metadata"
+
+// ImageMetadata describes a local image and where it comes from. Digest is the digest the image is pulled
+// by, which is of an index for multi-platform images. ManifestDigest is the digest of Manifest, the
+// manifest of the node platform.
+type ImageMetadata struct {
+	Image          string            `json:"image"`
+	Registry       string            `json:"registry"`
+	Digest         string            `json:"digest"`
+	ManifestDigest string            `json:"manifestDigest"`
+	Manifest       []byte            `json:"-"`
+	Encrypted      bool              `json:"encrypted"`
+	PulledAt       time.Time         `json:"pulledAt"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Env            []string          `json:"env,omitempty"`
+	Entrypoint     []string          `json:"entrypoint,omitempty"`
+	Cmd            []string          `json:"cmd,omitempty"`
+	WorkingDir     string            `json:"workingDir,omitempty"`
+	User           string            `json:"user,omitempty"`
+}
+
+// ImageInspector is implemented by runtimes which can read the config of local images and merge a
+// directory on top of the image layers. Only these runtimes support image metadata in volumes.
+type ImageInspector interface {
+	// InspectImage returns metadata of the local image.
+	InspectImage(ctx context.Context, image reference.Named) (*ImageMetadata, error)
+}
+
+// EnableImageMetadata enables image metadata in volumes if the runtime supports it. Metadata layers are
+// saved in dir, which must be at the same path on the host and in the driver.
+func (s *SnapshotMounter) EnableImageMetadata(dir string) {
+	inspector, ok := s.runtime.(ImageInspector)
+	if !ok {
+		klog.Warningf("the container runtime doesn't support image metadata in volumes")
+		return
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		klog.Fatalf("unable to create the directory for image metadata: %s", err)
+	}
+
+	s.metadataDir = dir
+	s.imageInspector = inspector
+}
+
+// InspectImage returns metadata of the local image if the runtime supports it.
+func (s *SnapshotMounter) InspectImage(ctx context.Context, image reference.Named) (*ImageMetadata, error) {
+	if s.imageInspector == nil {
+		return nil, fmt.Errorf("the container runtime doesn't support image inspection")
+	}
+
+	return s.imageInspector.InspectImage(ctx, image)
+}
+
+// imageMetadataLayer returns a directory containing ImageMetadataDir with metadata of the image, which
+// is merged on top of the image. Layers are created once per image and digest, and never changed since
+// they may be in use by mounted volumes.
+func (s *SnapshotMounter) imageMetadataLayer(ctx context.Context, image reference.Named) (string, error) {
+	if s.imageInspector == nil {
+		return "", fmt.Errorf("image metadata is not enabled")
+	}
+
+	metadata, err := s.imageInspector.InspectImage(ctx, image)
+	if err != nil {
+		return "", fmt.Errorf("unable to inspect image %q: %w", image, err)
+	}
+
+	layer := filepath.Join(s.metadataDir,
+		fmt.Sprintf("%x", sha256.Sum256([]byte(metadata.Image+"@"+metadata.Digest))))
+	if _, err = os.Stat(layer); err == nil {
+		return layer, nil
+	}
+
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	// Create the layer in a temporary directory first, so that a partial layer is never used.
+	tmp := layer + ".tmp"
+	if err = os.MkdirAll(filepath.Join(tmp, ImageMetadataDir), 0o755); err == nil {
+		err = os.WriteFile(filepath.Join(tmp, ImageMetadataDir, "image.json"), data, 0o644)
+	}
+
+	if err == nil {
+		err = os.WriteFile(filepath.Join(tmp, ImageMetadataDir, "manifest.json"), metadata.Manifest, 0o644)
+	}
+
+	if err == nil {
+		err = os.Rename(tmp, layer)
+	}
+
+	if err != nil {
+		os.RemoveAll(tmp)
+		return "", fmt.Errorf("unable to save metadata of image %q: %w", image, err)
+	}
+
+	klog.Infof("saved metadata of image %q in %q", image, layer)
+	return layer, nil
+}
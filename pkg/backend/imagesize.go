@@ -0,0 +1,69 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/distribution/reference"
+)
+
+// ImageSize is the disk usage of a local image.
+type ImageSize struct {
+	// Compressed is the size of the blobs of the image in the content store of the runtime.
+	Compressed int64
+	// Unpacked is the size of the snapshots the image is unpacked to, or 0 if it isn't unpacked.
+	Unpacked int64
+}
+
+// ImageSizer is implemented by runtimes and mounters which can measure local images.
+type ImageSizer interface {
+	// ImageSize returns the disk usage of the local image.
+	ImageSize(ctx context.Context, image reference.Named) (ImageSize, error)
+}
+
+// ImageSize implements ImageSizer if the runtime does.
+func (s *SnapshotMounter) ImageSize(ctx context.Context, image reference.Named) (ImageSize, error) {
+	sizer, ok := s.runtime.(ImageSizer)
+	if !ok {
+		return ImageSize{}, fmt.Errorf("the container runtime doesn't support measuring images")
+	}
+
+	return sizer.ImageSize(ctx, image)
+}
+
+// SnapshotUsage is the disk usage of the snapshot chain of a volume.
+type SnapshotUsage struct {
+	// Writable is the size of the active snapshot of the volume, i.e. what it writes, or its view of the image.
+	Writable int64
+	// Image is the size of the committed snapshots of the image the volume is created from, which are shared with
+	// other volumes of the image.
+	Image int64
+}
+
+// SnapshotUsageReporter is implemented by runtimes which can measure snapshots.
+type SnapshotUsageReporter interface {
+	// SnapshotUsage returns the disk usage of the snapshot and its parents.
+	SnapshotUsage(ctx context.Context, key SnapshotKey) (SnapshotUsage, error)
+}
+
+// VolumeUsageReporter is implemented by mounters which can measure volumes.
+type VolumeUsageReporter interface {
+	// VolumeUsage returns the disk usage of the snapshot chain of the volume mounted or published at the target.
+	VolumeUsage(ctx context.Context, target MountTarget) (SnapshotUsage, error)
+}
+
+// VolumeUsage implements VolumeUsageReporter if the runtime implements SnapshotUsageReporter. Volumes mounted before
+// the driver started are unknown.
+func (s *SnapshotMounter) VolumeUsage(ctx context.Context, target MountTarget) (SnapshotUsage, error) {
+	reporter, ok := s.runtime.(SnapshotUsageReporter)
+	if !ok {
+		return SnapshotUsage{}, fmt.Errorf("the container runtime doesn't support measuring snapshots")
+	}
+
+	key, found := s.snapshotOf(target)
+	if !found {
+		return SnapshotUsage{}, fmt.Errorf("volume at %q is unknown", target)
+	}
+
+	return reporter.SnapshotUsage(ctx, key)
+}
@@ -0,0 +1,87 @@
+package backend
+
+import (
+	"sort"
+)
+
+// VolumeState describes a volume mounted by the mounter.
+type VolumeState struct {
+	VolumeId string `json:"volumeId,omitempty"`
+	Target   string `json:"target"`
+	Image    string `json:"image"`
+	// OverlayImages are images merged on top of the image.
+	OverlayImages []string `json:"overlayImages,omitempty"`
+	// Snapshot is the key of the read-only snapshot the volume shares with other volumes of the image, if any.
+	Snapshot string `json:"snapshot,omitempty"`
+	// Publications are the targets the volume is bound to if it is staged.
+	Publications []string `json:"publications,omitempty"`
+}
+
+// SnapshotState describes a read-only snapshot shared by volumes.
+type SnapshotState struct {
+	Key string `json:"key"`
+	// Refs is the number of targets the snapshot is mounted to.
+	Refs int `json:"refs"`
+	// Retained is true if the snapshot is kept after its last unmount until its retention expires.
+	Retained bool `json:"retained"`
+}
+
+// MounterState is the view of the mounter on volumes of the node.
+type MounterState struct {
+	Volumes   []VolumeState   `json:"volumes"`
+	Snapshots []SnapshotState `json:"snapshots"`
+}
+
+// StateInspector is implemented by mounters reporting the volumes and snapshots they track.
+type StateInspector interface {
+	// InspectState returns volumes mounted since the driver started, and read-only snapshots in use or retained.
+	InspectState() MounterState
+}
+
+// InspectState implements StateInspector.
+func (s *SnapshotMounter) InspectState() MounterState {
+	state := MounterState{Volumes: []VolumeState{}, Snapshots: []SnapshotState{}}
+
+	s.stagingGuard.Lock()
+	publications := make(map[MountTarget][]string)
+	for target, stagingTarget := range s.publications {
+		publications[stagingTarget] = append(publications[stagingTarget], string(target))
+	}
+	s.stagingGuard.Unlock()
+
+	s.guard.Lock()
+	snapshots := make(map[MountTarget]SnapshotKey, len(s.targetRoSnapshotMap))
+	for target, key := range s.targetRoSnapshotMap {
+		snapshots[target] = key
+	}
+
+	for key, targets := range s.roSnapshotTargetsMap {
+		_, retained := s.retained[key]
+		state.Snapshots = append(state.Snapshots, SnapshotState{Key: string(key), Refs: len(targets), Retained: retained})
+	}
+	s.guard.Unlock()
+
+	s.volumesGuard.Lock()
+	for target, v := range s.volumes {
+		targets := publications[target]
+		sort.Strings(targets)
+		var overlayImages []string
+		for _, overlay := range v.opts.OverlayImages {
+			overlayImages = append(overlayImages, overlay.String())
+		}
+
+		state.Volumes = append(state.Volumes, VolumeState{
+			VolumeId:      v.volumeId,
+			Target:        string(target),
+			Image:         v.image.String(),
+			OverlayImages: overlayImages,
+			Snapshot:      string(snapshots[target]),
+			Publications:  targets,
+		})
+	}
+	s.volumesGuard.Unlock()
+
+	sort.Slice(state.Volumes, func(i, j int) bool { return state.Volumes[i].Target < state.Volumes[j].Target })
+	sort.Slice(state.Snapshots, func(i, j int) bool { return state.Snapshots[i].Key < state.Snapshots[j].Key })
+	return state
+}
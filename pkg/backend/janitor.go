@@ -0,0 +1,264 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/warm-metal/container-image-csi-driver/pkg/errorlog"
+	"k8s.io/klog/v2"
+	k8smount "k8s.io/mount-utils"
+)
+
+// JanitorOptions configures the janitor removing stale resources created by the driver.
+type JanitorOptions struct {
+	// Period is the interval between two runs.
+	Period time.Duration
+
+	// MaxRemovals is the maximum number of resources removed in a single run. 0 means unlimited.
+	MaxRemovals int
+
+	// DryRun only logs the stale resources found instead of removing them.
+	DryRun bool
+
+	// KubeletRoot is the root directory of kubelet, which is searched for stale staging directories.
+	KubeletRoot string
+}
+
+// JanitorReport lists stale resources found by a janitor run.
+type JanitorReport struct {
+	// Stale lists stale snapshots and staging directories, e.g. `snapshot "key"`.
+	Stale []string `json:"stale"`
+	// RuntimeResources is the number of stale resources of the runtime, e.g. mount activations of EROFS snapshots.
+	RuntimeResources int `json:"runtimeResources"`
+	// Removed is the number of stale resources removed.
+	Removed int `json:"removed"`
+}
+
+// GarbageCollector is implemented by mounters running the janitor on demand.
+type GarbageCollector interface {
+	// CollectGarbage runs the janitor once with opts, whose Period is the minimum age of stale staging directories.
+	CollectGarbage(ctx context.Context, opts JanitorOptions) JanitorReport
+}
+
+// StaleResourceCleaner is implemented by runtimes that create resources besides snapshots, which can
+// be left behind by crashes.
+type StaleResourceCleaner interface {
+	// CleanStaleResources removes at most limit stale resources, or only logs them if dryRun is set.
+	// It returns the number of stale resources found.
+	CleanStaleResources(ctx context.Context, dryRun bool, limit int) (int, error)
+}
+
+// janitorRun tracks the removals of a single janitor run against its limit.
+type janitorRun struct {
+	JanitorOptions
+	removals int
+	report   JanitorReport
+}
+
+func (r *janitorRun) remaining() int {
+	if r.MaxRemovals <= 0 {
+		return -1
+	}
+
+	if left := r.MaxRemovals - r.removals; left > 0 {
+		return left
+	}
+
+	return 0
+}
+
+// remove calls fn to remove a stale resource unless the limit is reached or it is a dry run.
+func (r *janitorRun) remove(kind, name string, fn func() error) {
+	r.report.Stale = append(r.report.Stale, fmt.Sprintf("%s %q", kind, name))
+	if r.DryRun {
+		klog.Infof("[dry-run] would remove stale %s %q", kind, name)
+		return
+	}
+
+	if r.remaining() == 0 {
+		klog.V(4).Infof("reached the limit of removals. stale %s %q will be removed in the next run", kind, name)
+		return
+	}
+
+	if err := fn(); err != nil {
+		errorlog.Errorf("unable to remove stale %s %q: %s", kind, name, err)
+		return
+	}
+
+	r.removals++
+	r.report.Removed++
+	klog.Infof("removed stale %s %q", kind, name)
+}
+
+// StartJanitor removes stale snapshots, runtime resources, and staging directories every period until
+// the context is done.
+func (s *SnapshotMounter) StartJanitor(ctx context.Context, opts JanitorOptions) {
+	go func() {
+		ticker := time.NewTicker(opts.Period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.cleanStaleResources(ctx, opts)
+			}
+		}
+	}()
+}
+
+// CollectGarbage implements GarbageCollector.
+func (s *SnapshotMounter) CollectGarbage(ctx context.Context, opts JanitorOptions) JanitorReport {
+	return s.cleanStaleResources(ctx, opts)
+}
+
+func (s *SnapshotMounter) cleanStaleResources(ctx context.Context, opts JanitorOptions) JanitorReport {
+	run := &janitorRun{JanitorOptions: opts}
+	s.cleanStaleSnapshots(ctx, run)
+
+	if cleaner, ok := s.runtime.(StaleResourceCleaner); ok {
+		if run.DryRun || run.remaining() != 0 {
+			found, err := cleaner.CleanStaleResources(ctx, run.DryRun, run.remaining())
+			if err != nil {
+				errorlog.Errorf("unable to clean stale runtime resources: %s", err)
+			}
+			run.removals += found
+			run.report.RuntimeResources = found
+			if !run.DryRun {
+				run.report.Removed += found
+			}
+		}
+	}
+
+	if opts.KubeletRoot != "" {
+		s.cleanStaleStagingDirs(run)
+	}
+
+	return run.report
+}
+
+// cleanStaleSnapshots removes read-only snapshots of the driver which are neither referred by any volume nor retained.
+func (s *SnapshotMounter) cleanStaleSnapshots(ctx context.Context, run *janitorRun) {
+	snapshots, err := s.runtime.ListSnapshots(ctx)
+	if err != nil {
+		errorlog.Errorf("unable to list snapshots: %s", err)
+		return
+	}
+
+	for _, metadata := range snapshots {
+		key := metadata.GetSnapshotKey()
+		if s.isROSnapshotReferred(key) {
+			continue
+		}
+
+		run.remove("snapshot", string(key), func() error {
+			// Check again under the lock, so that the snapshot can't be referred in the meantime.
+			s.guard.Lock()
+			defer s.guard.Unlock()
+			if _, found := s.roSnapshotTargetsMap[key]; found {
+				return fmt.Errorf("snapshot is referred by volumes again")
+			}
+
+			return s.runtime.DestroySnapshot(ctx, key)
+		})
+	}
+}
+
+func (s *SnapshotMounter) isROSnapshotReferred(key SnapshotKey) bool {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	_, found := s.roSnapshotTargetsMap[key]
+	return found
+}
+
+// cleanStaleStagingDirs removes empty staging directories of subpath volumes, which are left if the
+// driver crashed while mounting or unmounting. Recently created ones are kept since they may be in use, as are
+// those of volumes mounted or published since the driver started, and of journaled mutations.
+func (s *SnapshotMounter) cleanStaleStagingDirs(run *janitorRun) {
+	pattern := filepath.Join(run.KubeletRoot, "pods", "*", "volumes", "kubernetes.io~csi", "*",
+		filepath.Base(string(subPathStagingDir(""))))
+	dirs, err := filepath.Glob(pattern)
+	if err != nil {
+		errorlog.Errorf("unable to search staging directories: %s", err)
+		return
+	}
+
+	inUse, err := s.stagingDirsInUse()
+	if err != nil {
+		errorlog.Errorf("unable to find staging directories in use: %s", err)
+		return
+	}
+
+	for _, dir := range dirs {
+		if _, found := inUse[dir]; found {
+			continue
+		}
+
+		fi, err := os.Stat(dir)
+		if err != nil || !fi.IsDir() || time.Since(fi.ModTime()) < run.Period {
+			continue
+		}
+
+		if notMnt, err := k8smount.IsNotMountPoint(k8smount.New(""), dir); err != nil || !notMnt {
+			continue
+		}
+
+		if entries, err := os.ReadDir(dir); err != nil || len(entries) > 0 {
+			continue
+		}
+
+		run.remove("staging directory", dir, func() error {
+			// Check again, so that mounts started in the meantime keep their staging directories.
+			inUse, err := s.stagingDirsInUse()
+			if err != nil {
+				return err
+			}
+
+			if _, found := inUse[dir]; found {
+				return fmt.Errorf("staging directory is used by volumes again")
+			}
+
+			return os.Remove(dir)
+		})
+	}
+}
+
+// stagingDirsInUse returns staging directories of targets of volumes mounted or published since the driver started,
+// and of targets whose mutations are journaled, i.e. in progress or not rolled back yet.
+func (s *SnapshotMounter) stagingDirsInUse() (map[string]struct{}, error) {
+	dirs := make(map[string]struct{})
+	use := func(target MountTarget) {
+		dirs[string(subPathStagingDir(target))] = struct{}{}
+	}
+
+	s.volumesGuard.Lock()
+	for target := range s.volumes {
+		use(target)
+	}
+	s.volumesGuard.Unlock()
+
+	s.stagingGuard.Lock()
+	for target, stagingTarget := range s.publications {
+		use(target)
+		use(stagingTarget)
+	}
+	s.stagingGuard.Unlock()
+
+	if s.journal == nil {
+		return dirs, nil
+	}
+
+	entries, err := s.journal.load()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		use(entry.Target)
+	}
+
+	return dirs, nil
+}
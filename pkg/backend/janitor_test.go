@@ -0,0 +1,79 @@
+//go:build linux
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestJanitor removes stale snapshots and staging directories, while those of live publications and journaled
+// mounts are kept.
+func TestJanitor(t *testing.T) {
+	ctx := context.Background()
+	runtime := newFakeRuntime(t)
+	s := NewMounter(runtime)
+	s.EnableJournal(filepath.Join(runtime.dir, "journal"))
+	image, err := reference.ParseNormalizedNamed("docker.io/library/redis:latest")
+	require.NoError(t, err)
+
+	// podTarget returns the target of a volume of the pod, and creates the staging directory of the target, which
+	// is empty and older than the period of the janitor.
+	podTarget := func(pod string) MountTarget {
+		target := runtime.target(t, filepath.Join("kubelet", "pods", pod, "volumes", "kubernetes.io~csi", "pv", "mount"),
+			true)
+		staging := string(subPathStagingDir(target))
+		require.NoError(t, os.Mkdir(staging, 0o755))
+		require.NoError(t, os.Chtimes(staging, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour)))
+		return target
+	}
+
+	published := podTarget("published")
+	stagingTarget := runtime.target(t, "staging/globalmount", false)
+	require.NoError(t, s.Publish(ctx, "published", stagingTarget, published, image, MountOptions{ReadOnly: true},
+		false))
+
+	// The mount of the journaled target is still in progress.
+	journaled := podTarget("journaled")
+	s.journal.begin(&journalEntry{VolumeId: "journaled", Target: journaled}, stepMount)
+
+	stale := podTarget("stale")
+	require.NoError(t, runtime.PrepareReadOnlySnapshot(ctx, "id-library/nginx", "stale",
+		createSnapshotMetaData("/removed"), MountOptions{}))
+
+	opts := JanitorOptions{Period: time.Minute, KubeletRoot: filepath.Join(runtime.dir, "kubelet")}
+	wantStale := []string{`snapshot "stale"`, fmt.Sprintf("staging directory %q", subPathStagingDir(stale))}
+	opts.DryRun = true
+	report := s.CollectGarbage(ctx, opts)
+	assert.Equal(t, wantStale, report.Stale)
+	assert.Zero(t, report.Removed)
+	assert.True(t, runtime.SnapshotExists(ctx, "stale"))
+	assert.DirExists(t, string(subPathStagingDir(stale)))
+
+	opts.DryRun = false
+	report = s.CollectGarbage(ctx, opts)
+	assert.Equal(t, wantStale, report.Stale)
+	assert.Equal(t, 2, report.Removed)
+	assert.False(t, runtime.SnapshotExists(ctx, "stale"))
+	assert.NoDirExists(t, string(subPathStagingDir(stale)))
+
+	assert.True(t, runtime.mounted(t, published))
+	assert.True(t, runtime.mounted(t, stagingTarget))
+	assert.True(t, runtime.SnapshotExists(ctx, GenSnapshotKey("id-library/redis")))
+	assert.DirExists(t, string(subPathStagingDir(published)))
+	assert.DirExists(t, string(subPathStagingDir(journaled)))
+
+	// Staging directories are removed once their mounts complete or are rolled back.
+	s.journal.end(journaled)
+	report = s.CollectGarbage(ctx, opts)
+	assert.Equal(t, []string{fmt.Sprintf("staging directory %q", subPathStagingDir(journaled))}, report.Stale)
+	assert.NoDirExists(t, string(subPathStagingDir(journaled)))
+}
@@ -0,0 +1,177 @@
+package backend
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/warm-metal/container-image-csi-driver/pkg/errorlog"
+	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
+	"k8s.io/klog/v2"
+	k8smount "k8s.io/mount-utils"
+)
+
+// journalStep is a mutation of a volume target, which is journaled before it is made.
+type journalStep string
+
+const (
+	// stepPrepare prepares snapshots of the volume.
+	stepPrepare journalStep = "prepare"
+	// stepMount mounts snapshots of the volume to the target.
+	stepMount journalStep = "mount"
+	// stepBind binds the staged volume to the target.
+	stepBind journalStep = "bind"
+)
+
+// journalEntry is the mutation of a target in progress. Entries are removed once mutations complete or are
+// rolled back, so entries found on startup are mutations interrupted by crashes.
+type journalEntry struct {
+	VolumeId string      `json:"volumeId"`
+	Target   MountTarget `json:"target"`
+	Step     journalStep `json:"step"`
+	// Snapshot is the read-write snapshot owned by the target, which is destroyed on roll back.
+	Snapshot SnapshotKey `json:"snapshot,omitempty"`
+}
+
+// journal saves entries as files in a directory. A nil journal saves nothing.
+type journal struct {
+	dir string
+}
+
+func (j *journal) fileOf(target MountTarget) string {
+	return filepath.Join(j.dir, fmt.Sprintf("%x.json", sha256.Sum256([]byte(target))))
+}
+
+// begin journals the step of the entry before it is made. The entry is written to a temporary file first, so that
+// a partial entry is never loaded.
+func (j *journal) begin(entry *journalEntry, step journalStep) {
+	if j == nil {
+		return
+	}
+
+	entry.Step = step
+	data, err := json.Marshal(entry)
+	if err != nil {
+		errorlog.Errorf("unable to encode the journal of volume %q: %s", entry.VolumeId, err)
+		return
+	}
+
+	file := j.fileOf(entry.Target)
+	if err = os.WriteFile(file+".tmp", data, 0o600); err == nil {
+		err = os.Rename(file+".tmp", file)
+	}
+
+	if err != nil {
+		errorlog.Errorf("unable to journal %s of volume %q at %q: %s", step, entry.VolumeId, entry.Target, err)
+	}
+}
+
+// end removes the entry of the target once its mutation completes or is rolled back.
+func (j *journal) end(target MountTarget) {
+	if j == nil {
+		return
+	}
+
+	if err := os.Remove(j.fileOf(target)); err != nil && !os.IsNotExist(err) {
+		errorlog.Errorf("unable to remove the journal of target %q: %s", target, err)
+	}
+}
+
+func (j *journal) load() (entries []*journalEntry, err error) {
+	files, err := filepath.Glob(filepath.Join(j.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		entry := &journalEntry{}
+		if err = json.Unmarshal(data, entry); err != nil {
+			errorlog.Errorf("remove invalid journal %s: %s", file, err)
+			os.Remove(file)
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// EnableJournal journals mutations of targets in dir, then rolls back mutations interrupted by the last crash of
+// the driver, so that retries of kubelet start over instead of finding half-mounted volumes. It must be enabled
+// after the state store and block volumes.
+func (s *SnapshotMounter) EnableJournal(dir string) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		klog.Fatalf("unable to create the directory for the journal: %s", err)
+	}
+
+	j := &journal{dir: dir}
+	entries, err := j.load()
+	if err != nil {
+		klog.Fatalf("unable to load the journal: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 2*time.Minute)
+	defer cancel()
+	for _, entry := range entries {
+		klog.Infof("roll back interrupted %s of volume %q at %q", entry.Step, entry.VolumeId, entry.Target)
+		if err = s.rollBack(ctx, entry); err != nil {
+			// The entry is kept, so that it is rolled back again on the next start.
+			errorlog.Errorf("unable to roll back %s of volume %q at %q: %s", entry.Step, entry.VolumeId,
+				entry.Target, err)
+			metrics.ReconciledMountsCount.WithLabelValues("failed").Inc()
+			continue
+		}
+
+		metrics.ReconciledMountsCount.WithLabelValues("rolled-back").Inc()
+		j.end(entry.Target)
+	}
+
+	s.journal = j
+}
+
+// rollBack undoes the interrupted mutation of the entry.
+func (s *SnapshotMounter) rollBack(ctx context.Context, entry *journalEntry) error {
+	notMnt, err := k8smount.New("").IsLikelyNotMountPoint(string(entry.Target))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	mounted := err == nil && !notMnt
+	if entry.Step == stepBind {
+		// Only the bind is undone. The staged volume is kept for retries.
+		if !mounted {
+			return nil
+		}
+
+		return s.runtime.Unmount(ctx, entry.Target)
+	}
+
+	unlock := s.volumeLocks.lock(entry.VolumeId)
+	defer unlock()
+	if mounted {
+		return s.unmount(ctx, entry.VolumeId, entry.Target)
+	}
+
+	s.unrefOverlayImages(ctx, entry.Target)
+	s.unrefROSnapshot(ctx, entry.Target)
+	if err = s.unmountStagingDir(ctx, entry.Target); err != nil {
+		return err
+	}
+
+	if entry.Snapshot != "" && s.runtime.SnapshotExists(ctx, entry.Snapshot) {
+		klog.Infof("delete the read-write snapshot %q", entry.Snapshot)
+		return s.runtime.DestroySnapshot(ctx, entry.Snapshot)
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+//go:build linux
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnableJournal(t *testing.T) {
+	const volumeId = "csi-volume"
+	key := GenSnapshotKey(volumeId)
+	tests := []struct {
+		name       string
+		step       journalStep
+		snapshot   bool
+		mounted    bool
+		destroyErr error
+		// snapshotKept and entryKept are whether the snapshot and the entry are left after the roll back.
+		snapshotKept bool
+		entryKept    bool
+	}{
+		{
+			name: "prepare before the snapshot is created",
+			step: stepPrepare,
+		},
+		{
+			name:     "interrupted prepare",
+			step:     stepPrepare,
+			snapshot: true,
+		},
+		{
+			name:     "interrupted mount",
+			step:     stepMount,
+			snapshot: true,
+			mounted:  true,
+		},
+		{
+			name:         "interrupted bind",
+			step:         stepBind,
+			snapshot:     true,
+			mounted:      true,
+			snapshotKept: true,
+		},
+		{
+			name:         "failed roll back",
+			step:         stepPrepare,
+			snapshot:     true,
+			destroyErr:   errors.New("snapshotter is down"),
+			snapshotKept: true,
+			entryKept:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			runtime := newFakeRuntime(t)
+			target := runtime.target(t, "target", true)
+			if tt.snapshot {
+				require.NoError(t, runtime.PrepareRWSnapshot(ctx, "image", key, nil, MountOptions{}))
+			}
+
+			if tt.mounted {
+				require.NoError(t, runtime.Mount(ctx, key, target, MountOptions{}))
+			}
+
+			dir := filepath.Join(runtime.dir, "journal")
+			require.NoError(t, os.MkdirAll(dir, 0o700))
+			j := &journal{dir: dir}
+			entry := &journalEntry{VolumeId: volumeId, Target: target}
+			if tt.step != stepBind {
+				entry.Snapshot = key
+			}
+			j.begin(entry, tt.step)
+
+			runtime.destroyErr = tt.destroyErr
+			s := NewMounter(runtime)
+			s.EnableJournal(dir)
+
+			assert.False(t, runtime.mounted(t, target))
+			assert.Equal(t, tt.snapshotKept, runtime.SnapshotExists(ctx, key))
+			entries, err := j.load()
+			require.NoError(t, err)
+			if tt.entryKept {
+				require.Len(t, entries, 1)
+				assert.Equal(t, entry, entries[0])
+			} else {
+				assert.Empty(t, entries)
+			}
+		})
+	}
+}
+
+func TestJournalLoad(t *testing.T) {
+	j := &journal{dir: t.TempDir()}
+	entry := &journalEntry{VolumeId: "csi-volume", Target: "/var/lib/kubelet/target", Snapshot: "snapshot"}
+	j.begin(entry, stepMount)
+	invalid := filepath.Join(j.dir, "invalid.json")
+	require.NoError(t, os.WriteFile(invalid, []byte("{"), 0o600))
+
+	entries, err := j.load()
+	require.NoError(t, err)
+	assert.Equal(t, []*journalEntry{{VolumeId: "csi-volume", Target: "/var/lib/kubelet/target",
+		Step: stepMount, Snapshot: "snapshot"}}, entries)
+	assert.NoFileExists(t, invalid)
+
+	j.end(entry.Target)
+	entries, err = j.load()
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
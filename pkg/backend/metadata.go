@@ -3,6 +3,7 @@ package backend
 import (
 	"encoding/json"
 
+	"github.com/warm-metal/container-image-csi-driver/pkg/errorlog"
 	"k8s.io/klog/v2"
 )
 
@@ -67,7 +68,7 @@ func (m SnapshotMetadata) Encode() string {
 
 func (m SnapshotMetadata) Decode(encoded string) error {
 	if err := json.Unmarshal([]byte(encoded), &m); err != nil {
-		klog.Errorf("unable to decode snapshot metadata: %s", err)
+		errorlog.Errorf("unable to decode snapshot metadata: %s", err)
 		return err
 	}
 	return nil
@@ -3,12 +3,18 @@ package backend
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/distribution/reference"
+	"github.com/warm-metal/container-image-csi-driver/pkg/errorlog"
+	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
+	"github.com/warm-metal/container-image-csi-driver/pkg/tracing"
 	"k8s.io/klog/v2"
-	k8smount "k8s.io/utils/mount"
+	k8smount "k8s.io/mount-utils"
 )
 
 type SnapshotMounter struct {
@@ -17,8 +23,39 @@ type SnapshotMounter struct {
 	guard sync.Mutex
 	// mapping from targets to key of read-only snapshots
 	targetRoSnapshotMap map[MountTarget]SnapshotKey
-	// reference counter of read-only snapshots
+	// reference counter of read-only snapshots, including retained ones without targets
 	roSnapshotTargetsMap map[SnapshotKey]map[MountTarget]struct{}
+	// snapshots are destroyed once they are unreferred if retention is 0
+	retention time.Duration
+	// timers destroying retained snapshots
+	retained map[SnapshotKey]*time.Timer
+
+	volumesGuard sync.Mutex
+	// volumes mounted since the driver started, for health checks
+	volumes map[MountTarget]*publishedVolume
+
+	// mounts and unmounts of each volume are serialized by volumeLocks
+	volumeLocks volumeLocks
+
+	stagingGuard sync.Mutex
+	// mapping from targets to the staging targets they are published from since the driver started
+	publications map[MountTarget]MountTarget
+
+	// state of volumes is only kept in memory if state is nil
+	state *stateStore
+	// mutations of targets are not journaled if journal is nil
+	journal *journal
+
+	// image metadata is disabled if imageInspector is nil
+	metadataDir    string
+	imageInspector ImageInspector
+
+	// block volumes are disabled if loopDevices is nil
+	blockDir    string
+	blockImages *blockImageCache
+	loopDevices LoopDeviceManager
+	// dm-verity is disabled if verityDevices is nil
+	verityDevices VerityDeviceManager
 }
 
 func NewMounter(runtime ContainerRuntimeMounter) *SnapshotMounter {
@@ -26,6 +63,8 @@ func NewMounter(runtime ContainerRuntimeMounter) *SnapshotMounter {
 		runtime:              runtime,
 		targetRoSnapshotMap:  make(map[MountTarget]SnapshotKey),
 		roSnapshotTargetsMap: make(map[SnapshotKey]map[MountTarget]struct{}),
+		volumes:              make(map[MountTarget]*publishedVolume),
+		publications:         make(map[MountTarget]MountTarget),
 	}
 
 	mounter.buildSnapshotCacheOrDie()
@@ -67,13 +106,27 @@ func (s *SnapshotMounter) buildSnapshotCacheOrDie() {
 		for target := range targets {
 			// FIXME Considering using checksum of target instead to shorten metadata.
 			// But the mountpoint checking become unavailable any more.
-			if notMount, err := mounter.IsLikelyNotMountPoint(string(target)); err != nil || notMount {
-				klog.Errorf("target %q is not a mountpoint yet. trying to release the ref of snapshot %q",
+			if notMount, err := mounter.IsLikelyNotMountPoint(string(volumeTargetOf(target))); err != nil || notMount {
+				errorlog.Errorf("target %q is not a mountpoint yet. trying to release the ref of snapshot %q",
 					target, key)
 				delete(targets, target)
 				continue
 			}
 
+			if isOrphanTarget(target) {
+				klog.Warningf("target %q of snapshot %q is no longer tracked by kubelet. unmount it", target, key)
+				if err := s.unmountOrphan(ctx, target); err != nil {
+					errorlog.Errorf("unable to unmount orphan target %q: %s", target, err)
+					metrics.ReconciledMountsCount.WithLabelValues("failed").Inc()
+				} else {
+					metrics.ReconciledMountsCount.WithLabelValues("unmounted").Inc()
+					delete(targets, target)
+					continue
+				}
+			} else {
+				metrics.ReconciledMountsCount.WithLabelValues("adopted").Inc()
+			}
+
 			s.targetRoSnapshotMap[target] = key
 			klog.Infof("snapshot %q mounted to %s", key, target)
 		}
@@ -96,8 +149,29 @@ func (s *SnapshotMounter) buildSnapshotCacheOrDie() {
 	}
 }
 
+// isOrphanTarget checks whether kubelet still tracks the volume mounted to the target. Kubelet saves
+// the volume data of CSI volumes next to their mount points, and removes it only after unpublishing.
+// Without the data, kubelet will never unpublish the volume, e.g. if the pod was removed while the driver
+// was down.
+func isOrphanTarget(target MountTarget) bool {
+	volData := filepath.Join(filepath.Dir(string(volumeTargetOf(target))), "vol_data.json")
+	_, err := os.Stat(volData)
+	return os.IsNotExist(err)
+}
+
+// unmountOrphan unmounts the volume target of an orphan target.
+func (s *SnapshotMounter) unmountOrphan(ctx context.Context, target MountTarget) error {
+	target = volumeTargetOf(target)
+	if err := s.runtime.Unmount(ctx, target); err != nil {
+		return err
+	}
+
+	return s.unmountStagingDir(ctx, target)
+}
+
 func (s *SnapshotMounter) refROSnapshot(
 	ctx context.Context, target MountTarget, imageID string, key SnapshotKey, metadata SnapshotMetadata,
+	opts MountOptions,
 ) (err error) {
 	s.guard.Lock()
 	defer s.guard.Unlock()
@@ -106,15 +180,18 @@ func (s *SnapshotMounter) refROSnapshot(
 		klog.Fatalf("target %q has already been mounted to snapshot %q", target, s.targetRoSnapshotMap[target])
 	}
 
-	if len(s.roSnapshotTargetsMap[key]) > 0 {
+	if len(s.roSnapshotTargetsMap[key]) > 0 || s.reuseRetained(ctx, key) {
 		klog.Infof("snapshot %q has already been used by other volumes. update its metadata to refer", key)
 		metadata.CopyTargets(s.roSnapshotTargetsMap[key])
 		if err := s.runtime.UpdateSnapshotMetadata(ctx, key, metadata); err != nil {
+			if len(s.roSnapshotTargetsMap[key]) == 0 {
+				s.retain(key)
+			}
 			return err
 		}
 	} else {
 		klog.Infof("create snapshot %q of image %q and refer it", key, imageID)
-		if err := s.runtime.PrepareReadOnlySnapshot(ctx, imageID, key, metadata); err != nil {
+		if err := s.runtime.PrepareReadOnlySnapshot(ctx, imageID, key, metadata, opts); err != nil {
 			return err
 		}
 		s.roSnapshotTargetsMap[key] = map[MountTarget]struct{}{}
@@ -152,8 +229,18 @@ func (s *SnapshotMounter) unrefROSnapshot(ctx context.Context, target MountTarge
 		klog.Fatalf("refcount of snapshot %q is 0", key)
 	}
 
+	if s.retention > 0 {
+		// Metadata still refers to the target, so that the snapshot is destroyed if the driver restarts.
+		delete(targets, target)
+		delete(s.targetRoSnapshotMap, target)
+		s.retain(key)
+		return true
+	}
+
 	klog.Infof("snapshot %q isn't used by other volumes. delete it", key)
-	if err := s.runtime.DestroySnapshot(ctx, key); err != nil {
+	if !s.runtime.SnapshotExists(ctx, key) {
+		klog.Warningf("snapshot %q has already been removed from the runtime", key)
+	} else if err := s.runtime.DestroySnapshot(ctx, key); err != nil {
 		klog.Fatalf("unable to destroy snapshot %q: %s. We will crash. Dangling snapshots will be destroyed "+
 			"when restarting", key, err)
 	}
@@ -164,71 +251,307 @@ func (s *SnapshotMounter) unrefROSnapshot(ctx context.Context, target MountTarge
 }
 
 func (s *SnapshotMounter) Mount(
-	ctx context.Context, volumeId string, target MountTarget, image reference.Named, ro bool,
+	ctx context.Context, volumeId string, target MountTarget, image reference.Named, opts MountOptions,
+) error {
+	unlock := s.volumeLocks.lock(volumeId)
+	defer unlock()
+	return s.mount(ctx, volumeId, target, image, opts)
+}
+
+func (s *SnapshotMounter) mount(
+	ctx context.Context, volumeId string, target MountTarget, image reference.Named, opts MountOptions,
 ) (err error) {
+	entry := &journalEntry{VolumeId: volumeId, Target: target}
+	s.journal.begin(entry, stepPrepare)
+	// Failed mutations are rolled back below, and the state store takes over once the volume is mounted.
+	defer s.journal.end(target)
+
+	parentCtx := ctx
+	logger := klog.FromContext(ctx)
+	prepareCtx, prepareSpan := tracing.Start(ctx, "PrepareSnapshots", tracing.ImageAttributes(image)...)
+	prepareDone := metrics.StartMountStage(metrics.MountStagePrepare)
+	prepared := false
+	defer func() {
+		if !prepared {
+			tracing.End(prepareSpan, err)
+			prepareDone(err != nil)
+		}
+	}()
+	ctx = prepareCtx
+
 	var key SnapshotKey
-	imageID := s.runtime.GetImageIDOrDie(ctx, image)
-	if ro {
+	imageID := s.runtime.GetImageIDOrDie(ctx, image, opts)
+	if opts.ImageMetadata {
+		if opts.MetadataDir, err = s.imageMetadataLayer(ctx, image); err != nil {
+			return err
+		}
+	}
+
+	// Referrers are merged the same way as image metadata.
+	if opts.ReferrersDir != "" && s.imageInspector == nil {
+		return fmt.Errorf("referrers can't be mounted since image metadata is not enabled")
+	}
+
+	if opts.ReadOnly {
 		// Use the image ID as the key of the read-only snapshot
 		if imageID == "" {
 			klog.Fatalf("invalid image id of image %q", image)
 		}
 
-		key = GenSnapshotKey(imageID)
-		klog.Infof("refer read-only snapshot of image %q with key %q", image, key)
-		if err := s.refROSnapshot(ctx, target, imageID, key, createSnapshotMetaData(target)); err != nil {
+		// Snapshots of other filesystems can't be shared with overlayfs ones, so they are keyed separately.
+		if opts.FSType != "" {
+			key = GenSnapshotKey(opts.FSType + "-" + imageID)
+		} else {
+			key = GenSnapshotKey(imageID)
+		}
+
+		logger.Info("refer read-only snapshot", "key", key)
+		if err := s.refROSnapshot(ctx, target, imageID, key, createSnapshotMetaData(target), opts); err != nil {
 			return err
 		}
 
 		defer func() {
 			if err != nil {
-				klog.Infof("unref read-only snapshot because of error %s", err)
+				logger.Info("unref read-only snapshot because of error", "err", err)
 				if !s.unrefROSnapshot(ctx, target) {
 					klog.Fatalf("target %q not found in the snapshot cache", target)
 				}
 			}
 		}()
+	} else if opts.PersistentScratch {
+		// Persistent scratch layers are reused across mounts of the same volume, so they are neither
+		// recreated nor destroyed here.
+		key = GenScratchKey(volumeId)
+		if opts.CloneSource != "" && !s.runtime.SnapshotExists(ctx, key) {
+			if err := s.prepareClonedScratch(ctx, imageID, key, opts); err != nil {
+				return err
+			}
+		} else if opts.SnapshotSource != "" && !s.runtime.SnapshotExists(ctx, key) {
+			if err := s.prepareRestoredScratch(ctx, imageID, key, opts); err != nil {
+				return err
+			}
+		} else {
+			logger.Info("use persistent read-write snapshot", "key", key)
+			if err := s.runtime.PrepareRWSnapshot(ctx, imageID, key, nil, opts); err != nil {
+				return err
+			}
+		}
 	} else {
 		// For read-write volumes, they must be ephemeral volumes, that which volumeIDs are unique strings.
 		key = GenSnapshotKey(volumeId)
-		klog.Infof("create read-write snapshot of image %q with key %q", image, key)
-		if err := s.runtime.PrepareRWSnapshot(ctx, imageID, key, nil); err != nil {
+		entry.Snapshot = key
+		s.journal.begin(entry, stepPrepare)
+		logger.Info("create read-write snapshot", "key", key)
+		if err := s.runtime.PrepareRWSnapshot(ctx, imageID, key, nil, opts); err != nil {
 			return err
 		}
 
 		defer func() {
 			if err != nil {
-				klog.Infof("unref read-write snapshot because of error %s", err)
+				logger.Info("unref read-write snapshot because of error", "err", err)
 				s.runtime.DestroySnapshot(ctx, key)
 			}
 		}()
 	}
 
-	err = s.runtime.Mount(ctx, key, target, ro)
+	keys := []SnapshotKey{key}
+	if len(opts.OverlayImages) > 0 {
+		var overlayKeys []SnapshotKey
+		if overlayKeys, err = s.refOverlayImages(ctx, target, opts); err != nil {
+			return err
+		}
+
+		defer func() {
+			if err != nil {
+				logger.Info("unref snapshots of overlay images because of error", "err", err)
+				s.unrefOverlayImages(ctx, target)
+			}
+		}()
+
+		keys = append(overlayKeys, keys...)
+	}
+
+	prepared = true
+	tracing.End(prepareSpan, nil)
+	prepareDone(false)
+	ctx, mountSpan := tracing.Start(parentCtx, "MountSnapshots")
+	mountDone := metrics.StartMountStage(metrics.MountStageMount)
+	defer func() {
+		tracing.End(mountSpan, err)
+		mountDone(err != nil)
+	}()
+
+	s.journal.begin(entry, stepMount)
+	switch {
+	case opts.BlockFormat != "":
+		if err = s.mountBlock(ctx, keys, target, opts); err == nil {
+			// Block volumes are backed by standalone images, so snapshots are no longer needed.
+			s.unrefOverlayImages(ctx, target)
+			s.unrefROSnapshot(ctx, target)
+		}
+		return err
+	case opts.Path != "":
+		err = s.mountSubPath(ctx, keys, target, opts)
+	default:
+		err = s.mountSnapshots(ctx, keys, target, opts)
+	}
+
+	if err == nil {
+		s.recordVolume(volumeId, target, image, opts)
+	}
 	return err
 }
 
+// mountSnapshots mounts the snapshots to the target, merging them if there are more than one.
+func (s *SnapshotMounter) mountSnapshots(
+	ctx context.Context, keys []SnapshotKey, target MountTarget, opts MountOptions,
+) error {
+	if len(keys) > 1 {
+		return s.runtime.MountMerged(ctx, keys, target, opts)
+	}
+
+	return s.runtime.Mount(ctx, keys[0], target, opts)
+}
+
+// refOverlayImages refers read-only snapshots of the overlay images of a volume and returns their keys,
+// the topmost first. Each snapshot is referred by a pseudo target derived from the volume target.
+func (s *SnapshotMounter) refOverlayImages(
+	ctx context.Context, target MountTarget, opts MountOptions,
+) (keys []SnapshotKey, err error) {
+	if opts.FSType != "" {
+		return nil, fmt.Errorf("images of fsType %q can't be merged", opts.FSType)
+	}
+
+	for i, image := range opts.OverlayImages {
+		imageID := s.runtime.GetImageIDOrDie(ctx, image, opts)
+		if imageID == "" {
+			klog.Fatalf("invalid image id of image %q", image)
+		}
+
+		key := GenSnapshotKey(imageID)
+		overlayTarget := overlayImageTarget(target, i)
+		klog.Infof("refer read-only snapshot of overlay image %q with key %q", image, key)
+		if err = s.refROSnapshot(ctx, overlayTarget, imageID, key, createSnapshotMetaData(overlayTarget), opts); err != nil {
+			s.unrefOverlayImages(ctx, target)
+			return nil, err
+		}
+
+		keys = append([]SnapshotKey{key}, keys...)
+	}
+
+	return keys, nil
+}
+
+// unrefOverlayImages unrefers all snapshots of overlay images referred by the target.
+func (s *SnapshotMounter) unrefOverlayImages(ctx context.Context, target MountTarget) {
+	for i := 0; ; i++ {
+		if !s.unrefROSnapshot(ctx, overlayImageTarget(target, i)) {
+			return
+		}
+	}
+}
+
 func (s *SnapshotMounter) Unmount(ctx context.Context, volumeId string, target MountTarget) error {
-	klog.Infof("unmount volume %q at %q", volumeId, target)
-	if err := s.runtime.Unmount(ctx, target); err != nil {
+	unlock := s.volumeLocks.lock(volumeId)
+	defer unlock()
+	return s.unmount(ctx, volumeId, target)
+}
+
+func (s *SnapshotMounter) unmount(ctx context.Context, volumeId string, target MountTarget) (err error) {
+	logger := klog.FromContext(ctx)
+	s.stagingGuard.Lock()
+	publications := s.numPublications(target)
+	s.stagingGuard.Unlock()
+	if publications > 0 {
+		return fmt.Errorf("staged volume %q is still published to %d targets", target, publications)
+	}
+
+	logger.Info("unmount volume", "target", target)
+	unmountDone := metrics.StartMountStage(metrics.MountStageUnmount)
+	err = s.runtime.Unmount(ctx, target)
+	unmountDone(err != nil)
+	if err != nil {
 		return err
 	}
 
-	klog.Infof("try to unref read-only snapshot")
+	if s.unrefPublication(target) {
+		return nil
+	}
+
+	// Snapshots and staging directories of the volume are cleaned up once it is unmounted.
+	cleanupDone := metrics.StartMountStage(metrics.MountStageCleanup)
+	defer func() { cleanupDone(err != nil) }()
+
+	if s.isBlockVolume(target) {
+		logger.Info("detach the loop device of the block volume")
+		return s.unmountBlock(ctx, target)
+	}
+
+	if err := s.unmountStagingDir(ctx, target); err != nil {
+		return err
+	}
+
+	s.forgetVolume(target)
+	s.unrefOverlayImages(ctx, target)
+
+	logger.Info("try to unref read-only snapshot")
 	// Try to unref a read-only snapshot.
 	if s.unrefROSnapshot(ctx, target) {
 		return nil
 	}
 
-	klog.Infof("delete the read-write snapshot")
-	// Must be a read-write snapshot
-	return s.runtime.DestroySnapshot(ctx, GenSnapshotKey(volumeId))
+	if key := GenScratchKey(volumeId); s.runtime.SnapshotExists(ctx, key) {
+		logger.Info("keep the persistent read-write snapshot", "key", key)
+		return nil
+	}
+
+	// Publications of staged volumes don't have their own snapshots. They can't be told apart from
+	// read-write volumes after the driver restarted.
+	key := GenSnapshotKey(volumeId)
+	if !s.runtime.SnapshotExists(ctx, key) {
+		logger.Info("volume doesn't have a read-write snapshot")
+		return nil
+	}
+
+	logger.Info("delete the read-write snapshot", "key", key)
+	return s.runtime.DestroySnapshot(ctx, key)
+}
+
+func (s *SnapshotMounter) RemoveScratch(ctx context.Context, volumeId string) error {
+	key := GenScratchKey(volumeId)
+	if !s.runtime.SnapshotExists(ctx, key) {
+		return nil
+	}
+
+	klog.Infof("delete the persistent read-write snapshot %q of volume %q", key, volumeId)
+	return s.runtime.DestroySnapshot(ctx, key)
 }
 
 func (s *SnapshotMounter) ImageExists(ctx context.Context, image reference.Named) bool {
 	return s.runtime.ImageExists(ctx, image)
 }
 
+// overlayImageSep separates the volume target and the index of an overlay image in pseudo targets.
+const overlayImageSep = "#overlay-"
+
+// overlayImageTarget returns the pseudo target referring the snapshot of the i-th overlay image of a volume.
+func overlayImageTarget(target MountTarget, i int) MountTarget {
+	return MountTarget(fmt.Sprintf("%s%s%d", target, overlayImageSep, i))
+}
+
+// volumeTargetOf returns the volume target of a pseudo target, or the target itself.
+func volumeTargetOf(target MountTarget) MountTarget {
+	if i := strings.LastIndex(string(target), overlayImageSep); i >= 0 {
+		return target[:i]
+	}
+
+	return target
+}
+
 func GenSnapshotKey(parent string) SnapshotKey {
 	return SnapshotKey(fmt.Sprintf("container-image.csi.k8s.io-%s", parent))
 }
+
+func GenScratchKey(volumeId string) SnapshotKey {
+	return GenSnapshotKey("scratch-" + volumeId)
+}
@@ -0,0 +1,164 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+	"github.com/warm-metal/container-image-csi-driver/pkg/errorlog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"k8s.io/klog/v2"
+)
+
+// mounter delegates mounting volumes to an out-of-tree backend serving MountBackend of backend.proto.
+type mounter struct {
+	client MountBackendClient
+}
+
+// NewMounter creates a mounter using the backend plugin listening on the unix socket.
+func NewMounter(socketPath string) backend.Mounter {
+	conn, err := grpc.NewClient("unix://"+socketPath, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		klog.Fatalf("unable to connect to the backend plugin at %q: %s", socketPath, err)
+	}
+
+	klog.Infof("mount volumes via the backend plugin at %q", socketPath)
+	return &mounter{client: NewMountBackendClient(conn)}
+}
+
+// call invokes the method of the backend plugin and logs its latency.
+func call[Req, Resp any](
+	ctx context.Context, method string, invoke func(context.Context, Req, ...grpc.CallOption) (Resp, error), req Req,
+) (Resp, error) {
+	start := time.Now()
+	resp, err := invoke(ctx, req)
+	klog.V(4).Infof("backend plugin %s took %s: %v", method, time.Since(start), err)
+	if err != nil {
+		return resp, fmt.Errorf("backend plugin %s failed: %w", method, err)
+	}
+
+	return resp, nil
+}
+
+// imageID returns the ID of the image known by the backend.
+func (m mounter) imageID(ctx context.Context, image reference.Named) (string, error) {
+	resp, err := call(ctx, "ImageStatus", m.client.ImageStatus, &ImageStatusRequest{Image: image.String()})
+	if err != nil {
+		return "", err
+	}
+
+	if !resp.GetExists() {
+		return "", fmt.Errorf("image %q is not found by the backend plugin", image)
+	}
+
+	return resp.GetImageId(), nil
+}
+
+func mountOptions(opts backend.MountOptions) *MountOptions {
+	overlayImages := make([]string, 0, len(opts.OverlayImages))
+	for _, image := range opts.OverlayImages {
+		overlayImages = append(overlayImages, image.String())
+	}
+
+	return &MountOptions{
+		ReadOnly:          opts.ReadOnly,
+		FsType:            opts.FSType,
+		Quota:             opts.Quota,
+		PersistentScratch: opts.PersistentScratch,
+		Path:              opts.Path,
+		MountFlags:        opts.MountFlags,
+		SelinuxContext:    opts.SELinuxContext,
+		BlockFormat:       opts.BlockFormat,
+		OverlayImages:     overlayImages,
+	}
+}
+
+// unsupportedOptions returns an error if the options need features of the in-tree backends.
+func unsupportedOptions(opts backend.MountOptions) error {
+	if opts.TmpfsUpper || opts.ImageMetadata || opts.ReferrersDir != "" || opts.CloneSource != "" ||
+		opts.SnapshotSource != "" || opts.Verity {
+		return fmt.Errorf("tmpfs upper layers, image metadata, referrers, clones, snapshots, and dm-verity " +
+			"are not supported by backend plugins")
+	}
+
+	return nil
+}
+
+func (m mounter) Mount(
+	ctx context.Context, volumeId string, target backend.MountTarget, image reference.Named,
+	opts backend.MountOptions,
+) error {
+	if err := unsupportedOptions(opts); err != nil {
+		return err
+	}
+
+	imageID, err := m.imageID(ctx, image)
+	if err != nil {
+		return err
+	}
+
+	_, err = call(ctx, "Stage", m.client.Stage, &StageRequest{
+		VolumeId: volumeId,
+		Target:   string(target),
+		Image:    image.String(),
+		ImageId:  imageID,
+		Options:  mountOptions(opts),
+	})
+	return err
+}
+
+func (m mounter) Publish(
+	ctx context.Context, volumeId string, stagingTarget, target backend.MountTarget, image reference.Named,
+	opts backend.MountOptions, readOnly bool,
+) error {
+	if err := unsupportedOptions(opts); err != nil {
+		return err
+	}
+
+	imageID, err := m.imageID(ctx, image)
+	if err != nil {
+		return err
+	}
+
+	_, err = call(ctx, "Publish", m.client.Publish, &PublishRequest{
+		VolumeId:      volumeId,
+		StagingTarget: string(stagingTarget),
+		Target:        string(target),
+		Image:         image.String(),
+		ImageId:       imageID,
+		Options:       mountOptions(opts),
+		ReadOnly:      readOnly,
+	})
+	return err
+}
+
+func (m mounter) Unmount(ctx context.Context, volumeId string, target backend.MountTarget) error {
+	_, err := call(ctx, "Unstage", m.client.Unstage, &UnstageRequest{VolumeId: volumeId, Target: string(target)})
+	return err
+}
+
+func (m mounter) ImageExists(ctx context.Context, image reference.Named) bool {
+	if _, err := m.imageID(ctx, image); err != nil {
+		errorlog.Errorf("unable to retrieve the local image %q: %s", image, err)
+		return false
+	}
+
+	return true
+}
+
+func (m mounter) RemoveScratch(ctx context.Context, volumeId string) error {
+	_, err := call(ctx, "RemoveScratch", m.client.RemoveScratch, &RemoveScratchRequest{VolumeId: volumeId})
+	return err
+}
+
+func (m mounter) CheckMount(ctx context.Context, target backend.MountTarget) error {
+	_, err := call(ctx, "CheckMount", m.client.CheckMount, &CheckMountRequest{Target: string(target)})
+	return err
+}
+
+func (m mounter) InspectImage(context.Context, reference.Named) (*backend.ImageMetadata, error) {
+	return nil, fmt.Errorf("images can't be inspected via backend plugins")
+}
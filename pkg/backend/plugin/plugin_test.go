@@ -0,0 +1,85 @@
+package plugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+	fakeruntime "github.com/warm-metal/container-image-csi-driver/pkg/fake"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+func TestUnsupportedOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts backend.MountOptions
+		err  bool
+	}{
+		{name: "plain", opts: backend.MountOptions{ReadOnly: true, FSType: "ext4", PersistentScratch: true}},
+		{name: "tmpfs upper", opts: backend.MountOptions{TmpfsUpper: true}, err: true},
+		{name: "image metadata", opts: backend.MountOptions{ImageMetadata: true}, err: true},
+		{name: "referrers", opts: backend.MountOptions{ReferrersDir: "/referrers"}, err: true},
+		{name: "clone", opts: backend.MountOptions{CloneSource: "pv-a"}, err: true},
+		{name: "snapshot", opts: backend.MountOptions{SnapshotSource: "/snapshots/a.tar"}, err: true},
+		{name: "verity", opts: backend.MountOptions{Verity: true}, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := unsupportedOptions(tt.opts)
+			if tt.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// TestServe mounts volumes via a backend plugin served by the driver itself.
+func TestServe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	images := fakeruntime.NewImageService()
+	socket := filepath.Join(t.TempDir(), "backend.sock")
+	served := make(chan error, 1)
+	go func() { served <- Serve(ctx, socket, fakeruntime.NewMounter(images)) }()
+	require.Eventually(t, func() bool { return socketExists(socket) }, 5*time.Second,
+		10*time.Millisecond)
+
+	m := NewMounter(socket)
+	image, err := reference.ParseNormalizedNamed("docker.io/warmmetal/csi-image-test:simple-fs")
+	require.NoError(t, err)
+
+	assert.False(t, m.ImageExists(ctx, image))
+	assert.Error(t, m.Mount(ctx, "vol", "/stage", image, backend.MountOptions{}))
+
+	_, err = images.PullImage(ctx, &criapi.PullImageRequest{Image: &criapi.ImageSpec{Image: image.String()}})
+	require.NoError(t, err)
+	assert.True(t, m.ImageExists(ctx, image))
+
+	assert.Error(t, m.CheckMount(ctx, "/stage"))
+	require.NoError(t, m.Mount(ctx, "vol", "/stage", image, backend.MountOptions{ReadOnly: true}))
+	require.NoError(t, m.Publish(ctx, "vol", "/stage", "/target", image, backend.MountOptions{}, true))
+	assert.NoError(t, m.CheckMount(ctx, "/stage"))
+	assert.NoError(t, m.CheckMount(ctx, "/target"))
+	assert.NoError(t, m.RemoveScratch(ctx, "vol"))
+
+	require.NoError(t, m.Unmount(ctx, "vol", "/target"))
+	assert.Error(t, m.CheckMount(ctx, "/target"))
+
+	cancel()
+	assert.NoError(t, <-served)
+}
+
+func socketExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeSocket != 0
+}
@@ -0,0 +1,158 @@
+package plugin
+
+import (
+	"context"
+	"net"
+	"os"
+
+	"github.com/distribution/reference"
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// server serves MountBackend via an in-tree backend, so that the driver itself can be the backend plugin of
+// node plugins delegating mounts to it.
+type server struct {
+	UnimplementedMountBackendServer
+	mounter backend.Mounter
+}
+
+// NewServer creates a MountBackend server mounting volumes via the mounter.
+func NewServer(mounter backend.Mounter) MountBackendServer {
+	return &server{mounter: mounter}
+}
+
+// Serve serves MountBackend via the mounter on the unix socket until ctx is done. The socket is only accessible by
+// root, as every client can mount images anywhere on the node.
+func Serve(ctx context.Context, socketPath string, mounter backend.Mounter) error {
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Chmod(socketPath, 0o600); err != nil {
+		listener.Close()
+		return err
+	}
+
+	srv := grpc.NewServer()
+	RegisterMountBackendServer(srv, NewServer(mounter))
+	context.AfterFunc(ctx, srv.GracefulStop)
+	klog.Infof("serve the backend plugin at %q", socketPath)
+	return srv.Serve(listener)
+}
+
+func parseImage(image string) (reference.Named, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid image %q: %s", image, err)
+	}
+
+	return named, nil
+}
+
+func fromMountOptions(opts *MountOptions) (backend.MountOptions, error) {
+	overlayImages := make([]reference.Named, 0, len(opts.GetOverlayImages()))
+	for _, image := range opts.GetOverlayImages() {
+		named, err := parseImage(image)
+		if err != nil {
+			return backend.MountOptions{}, err
+		}
+
+		overlayImages = append(overlayImages, named)
+	}
+
+	return backend.MountOptions{
+		ReadOnly:          opts.GetReadOnly(),
+		FSType:            opts.GetFsType(),
+		Quota:             opts.GetQuota(),
+		PersistentScratch: opts.GetPersistentScratch(),
+		Path:              opts.GetPath(),
+		MountFlags:        opts.GetMountFlags(),
+		SELinuxContext:    opts.GetSelinuxContext(),
+		BlockFormat:       opts.GetBlockFormat(),
+		OverlayImages:     overlayImages,
+	}, nil
+}
+
+func (s server) Stage(ctx context.Context, req *StageRequest) (*Empty, error) {
+	image, err := parseImage(req.GetImage())
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := fromMountOptions(req.GetOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.mounter.Mount(ctx, req.GetVolumeId(), backend.MountTarget(req.GetTarget()), image, opts); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &Empty{}, nil
+}
+
+func (s server) Publish(ctx context.Context, req *PublishRequest) (*Empty, error) {
+	image, err := parseImage(req.GetImage())
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := fromMountOptions(req.GetOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.mounter.Publish(ctx, req.GetVolumeId(), backend.MountTarget(req.GetStagingTarget()),
+		backend.MountTarget(req.GetTarget()), image, opts, req.GetReadOnly()); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &Empty{}, nil
+}
+
+func (s server) Unstage(ctx context.Context, req *UnstageRequest) (*Empty, error) {
+	if err := s.mounter.Unmount(ctx, req.GetVolumeId(), backend.MountTarget(req.GetTarget())); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &Empty{}, nil
+}
+
+// ImageStatus returns the normalized reference as the image ID, since in-tree backends look images up by references.
+func (s server) ImageStatus(ctx context.Context, req *ImageStatusRequest) (*ImageStatusResponse, error) {
+	image, err := parseImage(req.GetImage())
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.mounter.ImageExists(ctx, image) {
+		return &ImageStatusResponse{}, nil
+	}
+
+	return &ImageStatusResponse{Exists: true, ImageId: image.String()}, nil
+}
+
+func (s server) CheckMount(ctx context.Context, req *CheckMountRequest) (*Empty, error) {
+	if err := s.mounter.CheckMount(ctx, backend.MountTarget(req.GetTarget())); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	return &Empty{}, nil
+}
+
+func (s server) RemoveScratch(ctx context.Context, req *RemoveScratchRequest) (*Empty, error) {
+	if err := s.mounter.RemoveScratch(ctx, req.GetVolumeId()); err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to remove the writable layer: %s", err)
+	}
+
+	return &Empty{}, nil
+}
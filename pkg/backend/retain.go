@@ -0,0 +1,78 @@
+package backend
+
+import (
+	"context"
+	"time"
+
+	"github.com/warm-metal/container-image-csi-driver/pkg/errorlog"
+	"k8s.io/klog/v2"
+)
+
+// retentionDestroyTimeout bounds destroying a read-only snapshot once its retention expires.
+const retentionDestroyTimeout = time.Minute
+
+// EnableSnapshotRetention keeps read-only snapshots of images for period after their last volumes are unmounted, so
+// that volumes mounted again in the meantime, e.g. of pods in CrashLoopBackOff or rolling restarts, reuse them
+// instead of preparing them again, and layers of their images stay rooted by the snapshots. Retained snapshots keep
+// the metadata of their last targets, so that they are destroyed once the driver restarts. It may be called again to
+// change the period, which snapshots already retained are not subject to.
+func (s *SnapshotMounter) EnableSnapshotRetention(period time.Duration) {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	s.retention = period
+	if s.retained == nil {
+		s.retained = make(map[SnapshotKey]*time.Timer)
+	}
+}
+
+// retain keeps the unreferred snapshot until the retention expires. s.guard must be held.
+func (s *SnapshotMounter) retain(key SnapshotKey) {
+	klog.Infof("snapshot %q isn't used by other volumes. retain it for %s", key, s.retention)
+	var timer *time.Timer
+	timer = time.AfterFunc(s.retention, func() {
+		s.releaseRetained(key, timer)
+	})
+	s.retained[key] = timer
+}
+
+// reuseRetained stops the retention of the snapshot, and returns true if it still exists and can be referred again.
+// s.guard must be held.
+func (s *SnapshotMounter) reuseRetained(ctx context.Context, key SnapshotKey) bool {
+	timer, found := s.retained[key]
+	if !found {
+		return false
+	}
+
+	timer.Stop()
+	delete(s.retained, key)
+	if !s.runtime.SnapshotExists(ctx, key) {
+		klog.Warningf("retained snapshot %q has already been removed from the runtime", key)
+		delete(s.roSnapshotTargetsMap, key)
+		return false
+	}
+
+	klog.Infof("reuse retained snapshot %q", key)
+	return true
+}
+
+// releaseRetained destroys the snapshot unless it is referred again since timer is started.
+func (s *SnapshotMounter) releaseRetained(key SnapshotKey, timer *time.Timer) {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if s.retained[key] != timer || len(s.roSnapshotTargetsMap[key]) > 0 {
+		return
+	}
+
+	delete(s.retained, key)
+	delete(s.roSnapshotTargetsMap, key)
+
+	ctx, cancel := context.WithTimeout(context.Background(), retentionDestroyTimeout)
+	defer cancel()
+	klog.Infof("retention of snapshot %q expired. delete it", key)
+	if !s.runtime.SnapshotExists(ctx, key) {
+		klog.Warningf("snapshot %q has already been removed from the runtime", key)
+	} else if err := s.runtime.DestroySnapshot(ctx, key); err != nil {
+		// The snapshot is no longer tracked, so the janitor or the next restart removes it.
+		errorlog.Errorf("unable to destroy snapshot %q: %s", key, err)
+	}
+}
@@ -8,34 +8,112 @@ import (
 
 type MountOptions struct {
 	ReadOnly bool
+
+	// FSType is the filesystem used to present image layers. Empty means the runtime default, overlayfs.
+	FSType string
+
+	// Quota is the maximum number of bytes the writable layer of a read-write volume can hold.
+	// 0 means unlimited. It is ignored for read-only volumes.
+	Quota int64
+
+	// TmpfsUpper puts the writable layer of a read-write volume on tmpfs, which size is limited by Quota.
+	TmpfsUpper bool
+
+	// PersistentScratch keeps the writable layer of a read-write volume after unmounting, so that it is
+	// reused the next time the volume is mounted. The layer is keyed by the volume ID.
+	PersistentScratch bool
+
+	// CloneSource is the ID of a volume whose persistent writable layer seeds the persistent writable layer
+	// of this volume when it is created. It is ignored if the layer already exists.
+	CloneSource string
+
+	// SnapshotSource is the path of a tarball saved from a persistent writable layer, which seeds the persistent
+	// writable layer of this volume when it is created. It is ignored if the layer already exists.
+	SnapshotSource string
+
+	// Path is a directory or a regular file in the image. If set, only the directory or file is mounted
+	// instead of the whole rootfs.
+	Path string
+
+	// OverlayImages are merged on top of the image in order, so that files in later images shadow
+	// those in earlier ones. Only overlayfs supports merging images.
+	OverlayImages []reference.Named `json:"-"`
+
+	// SELinuxContext is the SELinux context of the pod, which is applied to the mount via the context option.
+	// Kubelet sets it in the mount flags if the CSIDriver enables seLinuxMount.
+	SELinuxContext string
+
+	// MountFlags are additional per-mount flags applied to the mount, which can be noexec, nosuid, and nodev.
+	MountFlags []string
+
+	// ImageMetadata makes ImageMetadataDir at the root of the volume hold metadata of the image.
+	ImageMetadata bool
+
+	// MetadataDir is the directory which is merged on top of the image if ImageMetadata is set.
+	MetadataDir string
+
+	// ReferrersDir is a directory holding referrers of the image, which is merged on top of the image.
+	ReferrersDir string
+
+	// BlockFormat is the filesystem the image rootfs is packed into if the volume is published as a
+	// read-only block device, which is squashfs or erofs, or disk for the VM disk of containerDisk images.
+	// Empty means the volume is mounted as a directory.
+	BlockFormat string
+
+	// Verity protects the image of block volumes with dm-verity, so that tampering with the image is
+	// detected while the volume is read.
+	Verity bool
 }
 
+const (
+	// FSTypeEROFS presents each image layer as an EROFS blob instead of an unpacked directory.
+	FSTypeEROFS = "erofs"
+
+	// FSTypeSquashfs is only used to pack the image rootfs of block volumes.
+	FSTypeSquashfs = "squashfs"
+
+	// BlockFormatDisk publishes the VM disk in a containerDisk image as a block device instead of the rootfs.
+	BlockFormatDisk = "disk"
+)
+
 type SnapshotKey string
 type MountTarget string
 
 // ContainerRuntimeMounter is a container runtime specific interface
 type ContainerRuntimeMounter interface {
-	Mount(ctx context.Context, key SnapshotKey, target MountTarget, ro bool) error
+	Mount(ctx context.Context, key SnapshotKey, target MountTarget, opts MountOptions) error
 	Unmount(ctx context.Context, target MountTarget) error
 
+	// MountMerged mounts all snapshots merged into one. The first snapshot is the topmost one and is the
+	// only one which can be writable.
+	MountMerged(ctx context.Context, keys []SnapshotKey, target MountTarget, opts MountOptions) error
+
+	// Bind mounts the given path to the target in the same mount namespace as Mount.
+	Bind(ctx context.Context, source string, target MountTarget, opts MountOptions) error
+
 	// Determines if a local image exists. A false should return if errors arise.
 	ImageExists(ctx context.Context, image reference.Named) bool
 
-	// Retrieves the image ID of a local image.
+	// Retrieves the image ID of a local image, unpacking it for the filesystem in opts if needed.
 	// It should crash on any failures including not local image found.
-	GetImageIDOrDie(ctx context.Context, image reference.Named) string
+	GetImageIDOrDie(ctx context.Context, image reference.Named, opts MountOptions) string
 
 	// Create a snapshot of the image using the given key and metadata.
 	// It should throw errors if any snapshot exists with the same key.
-	PrepareReadOnlySnapshot(ctx context.Context, imageID string, key SnapshotKey, metadata SnapshotMetadata) error
+	PrepareReadOnlySnapshot(
+		ctx context.Context, imageID string, key SnapshotKey, metadata SnapshotMetadata, opts MountOptions) error
 
 	// Create a read-write snapshot of the image using the given key and metadata.
 	// It should throw errors if any snapshot exists with the same key.
-	PrepareRWSnapshot(ctx context.Context, imageID string, key SnapshotKey, metadata SnapshotMetadata) error
+	PrepareRWSnapshot(
+		ctx context.Context, imageID string, key SnapshotKey, metadata SnapshotMetadata, opts MountOptions) error
 
 	// Replace the metadata of the snapshot with the specified key with the given metadata.
 	UpdateSnapshotMetadata(ctx context.Context, key SnapshotKey, metadata SnapshotMetadata) error
 
+	// Determines if a snapshot with the given key exists. A false should return if errors arise.
+	SnapshotExists(ctx context.Context, key SnapshotKey) bool
+
 	// Destroy the snapshot with the given key.
 	// It should throw errors if the snapshot doesn't exist.
 	DestroySnapshot(ctx context.Context, key SnapshotKey) error
@@ -49,11 +127,26 @@ type ContainerRuntimeMounter interface {
 type Mounter interface {
 	// Mount mounts a specific image
 	Mount(
-		ctx context.Context, volumeId string, target MountTarget, image reference.Named, ro bool) (err error)
+		ctx context.Context, volumeId string, target MountTarget, image reference.Named, opts MountOptions) (err error)
+
+	// Publish binds a volume staged at stagingTarget to the target, staging it first if needed
+	Publish(
+		ctx context.Context, volumeId string, stagingTarget, target MountTarget, image reference.Named,
+		opts MountOptions, readOnly bool) error
 
-	// Unmount unmounts a specific image
+	// Unmount unmounts a specific image, a published volume, or a staged volume. Staged volumes can't be
+	// unmounted while they are published.
 	Unmount(ctx context.Context, volumeId string, target MountTarget) error
 
 	// ImageExists checks if the image already exists on the local machine
 	ImageExists(ctx context.Context, image reference.Named) bool
+
+	// RemoveScratch removes the persistent writable layer of a volume if it exists
+	RemoveScratch(ctx context.Context, volumeId string) error
+
+	// CheckMount returns an error if the target is not a working mount
+	CheckMount(ctx context.Context, target MountTarget) error
+
+	// InspectImage returns metadata of a local image
+	InspectImage(ctx context.Context, image reference.Named) (*ImageMetadata, error)
 }
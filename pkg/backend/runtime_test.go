@@ -0,0 +1,180 @@
+//go:build linux
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/distribution/reference"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+	k8smount "k8s.io/mount-utils"
+)
+
+// fakeRuntime is a container runtime keeping snapshots in memory, which mounts tmpfs instead of them, so that
+// mount points are found by the mounter as on nodes.
+type fakeRuntime struct {
+	// dir is the directory for targets, which is unmounted and removed once the test completes.
+	dir string
+
+	guard     sync.Mutex
+	snapshots map[SnapshotKey]SnapshotMetadata
+	// destroyErr fails DestroySnapshot if set.
+	destroyErr error
+}
+
+var _ ContainerRuntimeMounter = &fakeRuntime{}
+
+func newFakeRuntime(t *testing.T) *fakeRuntime {
+	if os.Geteuid() != 0 {
+		t.Skip("mounting volumes requires root")
+	}
+
+	r := &fakeRuntime{dir: t.TempDir(), snapshots: make(map[SnapshotKey]SnapshotMetadata)}
+	t.Cleanup(func() {
+		filepath.WalkDir(r.dir, func(path string, d os.DirEntry, err error) error {
+			if err == nil && d.IsDir() {
+				for unix.Unmount(path, unix.MNT_DETACH) == nil {
+				}
+			}
+			return nil
+		})
+	})
+	return r
+}
+
+// target returns the path of the target in dir, which is created if mkdir is true.
+func (r *fakeRuntime) target(t *testing.T, name string, mkdir bool) MountTarget {
+	target := filepath.Join(r.dir, name)
+	if mkdir {
+		require.NoError(t, os.MkdirAll(target, 0o755))
+	}
+
+	return MountTarget(target)
+}
+
+func (r *fakeRuntime) mounted(t *testing.T, target MountTarget) bool {
+	notMnt, err := k8smount.New("").IsLikelyNotMountPoint(string(target))
+	if os.IsNotExist(err) {
+		return false
+	}
+
+	require.NoError(t, err)
+	return !notMnt
+}
+
+func (r *fakeRuntime) Mount(_ context.Context, key SnapshotKey, target MountTarget, _ MountOptions) error {
+	if !r.SnapshotExists(context.TODO(), key) {
+		return fmt.Errorf("snapshot %q not found", key)
+	}
+
+	return unix.Mount("tmpfs", string(target), "tmpfs", 0, "")
+}
+
+// Unmount succeeds if the target isn't mounted, as the runtimes do.
+func (r *fakeRuntime) Unmount(_ context.Context, target MountTarget) error {
+	if err := unix.Unmount(string(target), 0); err != nil && !errors.Is(err, unix.EINVAL) &&
+		!errors.Is(err, unix.ENOENT) {
+		return err
+	}
+
+	return nil
+}
+
+func (r *fakeRuntime) MountMerged(
+	ctx context.Context, keys []SnapshotKey, target MountTarget, opts MountOptions,
+) error {
+	return r.Mount(ctx, keys[0], target, opts)
+}
+
+func (r *fakeRuntime) Bind(_ context.Context, source string, target MountTarget, _ MountOptions) error {
+	return unix.Mount(source, string(target), "", unix.MS_BIND, "")
+}
+
+func (r *fakeRuntime) ImageExists(context.Context, reference.Named) bool {
+	return true
+}
+
+func (r *fakeRuntime) GetImageIDOrDie(_ context.Context, image reference.Named, _ MountOptions) string {
+	return "id-" + reference.Path(image)
+}
+
+func (r *fakeRuntime) prepare(key SnapshotKey, metadata SnapshotMetadata) error {
+	r.guard.Lock()
+	defer r.guard.Unlock()
+	if _, found := r.snapshots[key]; found {
+		return fmt.Errorf("snapshot %q already exists", key)
+	}
+
+	r.snapshots[key] = metadata
+	return nil
+}
+
+func (r *fakeRuntime) PrepareReadOnlySnapshot(
+	_ context.Context, _ string, key SnapshotKey, metadata SnapshotMetadata, _ MountOptions,
+) error {
+	return r.prepare(key, metadata)
+}
+
+// PrepareRWSnapshot saves no metadata, so that read-write snapshots are not listed.
+func (r *fakeRuntime) PrepareRWSnapshot(
+	_ context.Context, _ string, key SnapshotKey, _ SnapshotMetadata, _ MountOptions,
+) error {
+	return r.prepare(key, nil)
+}
+
+func (r *fakeRuntime) UpdateSnapshotMetadata(_ context.Context, key SnapshotKey, metadata SnapshotMetadata) error {
+	r.guard.Lock()
+	defer r.guard.Unlock()
+	if _, found := r.snapshots[key]; !found {
+		return fmt.Errorf("snapshot %q not found", key)
+	}
+
+	r.snapshots[key] = metadata
+	return nil
+}
+
+func (r *fakeRuntime) SnapshotExists(_ context.Context, key SnapshotKey) bool {
+	r.guard.Lock()
+	defer r.guard.Unlock()
+	_, found := r.snapshots[key]
+	return found
+}
+
+func (r *fakeRuntime) DestroySnapshot(_ context.Context, key SnapshotKey) error {
+	r.guard.Lock()
+	defer r.guard.Unlock()
+	if r.destroyErr != nil {
+		return r.destroyErr
+	}
+
+	if _, found := r.snapshots[key]; !found {
+		return fmt.Errorf("snapshot %q not found", key)
+	}
+
+	delete(r.snapshots, key)
+	return nil
+}
+
+func (r *fakeRuntime) ListSnapshots(context.Context) ([]SnapshotMetadata, error) {
+	r.guard.Lock()
+	defer r.guard.Unlock()
+	var snapshots []SnapshotMetadata
+	for key, metadata := range r.snapshots {
+		if metadata == nil {
+			continue
+		}
+
+		listed := buildSnapshotMetaData(metadata.GetTargets())
+		listed.SetSnapshotKey(string(key))
+		snapshots = append(snapshots, listed)
+	}
+
+	return snapshots, nil
+}
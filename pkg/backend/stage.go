@@ -0,0 +1,150 @@
+package backend
+
+import (
+	"context"
+	"os"
+	"sync"
+
+	"github.com/distribution/reference"
+	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
+	"k8s.io/klog/v2"
+	k8smount "k8s.io/mount-utils"
+)
+
+// volumeLocks serializes mounts and unmounts of each volume, so that a volume is staged, published, and torn down by
+// one request at a time, while requests of other volumes go on.
+type volumeLocks struct {
+	guard sync.Mutex
+	locks map[string]*volumeLock
+}
+
+type volumeLock struct {
+	sync.Mutex
+	// refs counts requests holding or waiting for the lock
+	refs int
+}
+
+// lock locks the volume, and returns the function unlocking it.
+func (l *volumeLocks) lock(volumeId string) (unlock func()) {
+	l.guard.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*volumeLock)
+	}
+
+	vl, found := l.locks[volumeId]
+	if !found {
+		vl = &volumeLock{}
+		l.locks[volumeId] = vl
+	}
+
+	vl.refs++
+	l.guard.Unlock()
+
+	vl.Lock()
+	return func() {
+		vl.Unlock()
+		l.guard.Lock()
+		defer l.guard.Unlock()
+		if vl.refs--; vl.refs == 0 {
+			delete(l.locks, volumeId)
+		}
+	}
+}
+
+// stage mounts the volume to stagingTarget with opts, unless it is staged already. Staged volumes are kept until
+// stagingTarget is unmounted, and shared by all publications of the volume.
+func (s *SnapshotMounter) stage(
+	ctx context.Context, volumeId string, stagingTarget MountTarget, image reference.Named, opts MountOptions,
+) error {
+	notMnt, err := k8smount.New("").IsLikelyNotMountPoint(string(stagingTarget))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err == nil && !notMnt {
+		return nil
+	}
+
+	if opts.BlockFormat == "" {
+		if err = os.MkdirAll(string(stagingTarget), 0o755); err != nil {
+			return err
+		}
+	}
+
+	klog.FromContext(ctx).Info("stage volume", "stagingTarget", stagingTarget)
+	return s.mount(ctx, volumeId, stagingTarget, image, opts)
+}
+
+// Publish binds the volume staged at stagingTarget to the target. The volume is staged with opts first
+// if it isn't staged yet, so that all publications of a volume share the same mount and snapshot.
+// readOnly makes the target read-only even if the staged volume is writable.
+func (s *SnapshotMounter) Publish(
+	ctx context.Context, volumeId string, stagingTarget, target MountTarget, image reference.Named,
+	opts MountOptions, readOnly bool,
+) error {
+	unlock := s.volumeLocks.lock(volumeId)
+	defer unlock()
+
+	if err := s.stage(ctx, volumeId, stagingTarget, image, opts); err != nil {
+		return err
+	}
+
+	fi, err := os.Stat(string(stagingTarget))
+	if err != nil {
+		return err
+	}
+
+	// Files and devices can only be bound to files.
+	if !fi.IsDir() {
+		if err = fileTarget(target); err != nil {
+			return err
+		}
+	}
+
+	s.journal.begin(&journalEntry{VolumeId: volumeId, Target: target}, stepBind)
+	defer s.journal.end(target)
+
+	bindOpts := MountOptions{ReadOnly: opts.ReadOnly || readOnly, MountFlags: opts.MountFlags}
+	bindDone := metrics.StartMountStage(metrics.MountStageBind)
+	err = s.runtime.Bind(ctx, string(stagingTarget), target, bindOpts)
+	bindDone(err != nil)
+	if err != nil {
+		return err
+	}
+
+	s.stagingGuard.Lock()
+	s.publications[target] = stagingTarget
+	publications := s.numPublications(stagingTarget)
+	s.stagingGuard.Unlock()
+
+	record := newVolumeRecord(volumeId, target, image, bindOpts)
+	record.StagingTarget = stagingTarget
+	s.state.save(record)
+	klog.FromContext(ctx).Info("staged volume is published", "target", target, "publications", publications)
+	return nil
+}
+
+// numPublications must be called with stagingGuard held.
+func (s *SnapshotMounter) numPublications(stagingTarget MountTarget) (n int) {
+	for _, staged := range s.publications {
+		if staged == stagingTarget {
+			n++
+		}
+	}
+
+	return n
+}
+
+// unrefPublication removes the target from the publications of its staged volume, and returns whether the target
+// is a publication. Staged volumes are kept until they are unstaged.
+func (s *SnapshotMounter) unrefPublication(target MountTarget) bool {
+	s.stagingGuard.Lock()
+	defer s.stagingGuard.Unlock()
+	if _, found := s.publications[target]; !found {
+		return false
+	}
+
+	delete(s.publications, target)
+	s.state.remove(target)
+	return true
+}
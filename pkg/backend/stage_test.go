@@ -0,0 +1,160 @@
+//go:build linux
+
+package backend
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublish(t *testing.T) {
+	const volumeId = "csi-volume"
+	image, err := reference.ParseNormalizedNamed("docker.io/library/redis:latest")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		opts        MountOptions
+		publish     []string
+		unpublish   []string
+		unstage     bool
+		snapshot    SnapshotKey
+		staged      bool
+		snapshotRef int
+	}{
+		{
+			name:     "read-write volume published twice",
+			publish:  []string{"a", "b"},
+			snapshot: GenSnapshotKey(volumeId),
+			staged:   true,
+		},
+		{
+			name:      "read-write volume still published",
+			publish:   []string{"a", "b"},
+			unpublish: []string{"a"},
+			snapshot:  GenSnapshotKey(volumeId),
+			staged:    true,
+		},
+		{
+			name:      "read-write volume unpublished",
+			publish:   []string{"a", "b"},
+			unpublish: []string{"b", "a"},
+			snapshot:  GenSnapshotKey(volumeId),
+			staged:    true,
+		},
+		{
+			name:      "read-write volume unstaged while published",
+			publish:   []string{"a", "b"},
+			unpublish: []string{"a"},
+			unstage:   true,
+			snapshot:  GenSnapshotKey(volumeId),
+			staged:    true,
+		},
+		{
+			name:      "read-write volume unstaged",
+			publish:   []string{"a", "b"},
+			unpublish: []string{"b", "a"},
+			unstage:   true,
+			snapshot:  GenSnapshotKey(volumeId),
+		},
+		{
+			name:        "read-only volume published twice",
+			opts:        MountOptions{ReadOnly: true},
+			publish:     []string{"a", "b"},
+			snapshot:    GenSnapshotKey("id-library/redis"),
+			staged:      true,
+			snapshotRef: 1,
+		},
+		{
+			name:        "read-only volume still published",
+			opts:        MountOptions{ReadOnly: true},
+			publish:     []string{"a", "b"},
+			unpublish:   []string{"b"},
+			snapshot:    GenSnapshotKey("id-library/redis"),
+			staged:      true,
+			snapshotRef: 1,
+		},
+		{
+			name:        "read-only volume unpublished",
+			opts:        MountOptions{ReadOnly: true},
+			publish:     []string{"a", "b"},
+			unpublish:   []string{"a", "b"},
+			snapshot:    GenSnapshotKey("id-library/redis"),
+			staged:      true,
+			snapshotRef: 1,
+		},
+		{
+			name:      "read-only volume unstaged",
+			opts:      MountOptions{ReadOnly: true},
+			publish:   []string{"a", "b"},
+			unpublish: []string{"a", "b"},
+			unstage:   true,
+			snapshot:  GenSnapshotKey("id-library/redis"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			runtime := newFakeRuntime(t)
+			s := NewMounter(runtime)
+			stagingTarget := runtime.target(t, "staging/globalmount", false)
+			for _, name := range tt.publish {
+				target := runtime.target(t, name, true)
+				require.NoError(t, s.Publish(ctx, volumeId, stagingTarget, target, image, tt.opts, false))
+				assert.True(t, runtime.mounted(t, target))
+			}
+
+			for _, name := range tt.unpublish {
+				target := runtime.target(t, name, false)
+				require.NoError(t, s.Unmount(ctx, volumeId, target))
+				assert.False(t, runtime.mounted(t, target))
+			}
+
+			if tt.unstage {
+				// Staged volumes are only unstaged once they aren't published anymore.
+				err := s.Unmount(ctx, volumeId, stagingTarget)
+				if tt.staged {
+					assert.Error(t, err)
+				} else {
+					assert.NoError(t, err)
+				}
+			}
+
+			// The volume is staged once, and kept until it is unstaged.
+			assert.Equal(t, tt.staged, runtime.mounted(t, stagingTarget))
+			assert.Equal(t, tt.staged, runtime.SnapshotExists(ctx, tt.snapshot))
+			assert.Len(t, s.roSnapshotTargetsMap[tt.snapshot], tt.snapshotRef)
+			assert.Equal(t, len(tt.publish)-len(tt.unpublish), s.numPublications(stagingTarget))
+		})
+	}
+}
+
+func TestVolumeLocks(t *testing.T) {
+	var l volumeLocks
+	unlock := l.lock("a")
+
+	// Other volumes aren't blocked.
+	l.lock("b")()
+
+	locked := make(chan struct{})
+	go func() {
+		defer close(locked)
+		l.lock("a")()
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("volumes must be locked by one request at a time")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	unlock()
+	<-locked
+	assert.Empty(t, l.locks)
+}
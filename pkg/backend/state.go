@@ -0,0 +1,170 @@
+package backend
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/distribution/reference"
+	"github.com/warm-metal/container-image-csi-driver/pkg/errorlog"
+	"k8s.io/klog/v2"
+	k8smount "k8s.io/mount-utils"
+)
+
+// volumeRecord is saved for each volume mounted or published by the driver, so that the driver can
+// recover its state after restarts.
+type volumeRecord struct {
+	VolumeId      string       `json:"volumeId"`
+	Target        MountTarget  `json:"target"`
+	Image         string       `json:"image,omitempty"`
+	OverlayImages []string     `json:"overlayImages,omitempty"`
+	Options       MountOptions `json:"options"`
+	// StagingTarget is set if the target is a publication of a staged volume.
+	StagingTarget MountTarget `json:"stagingTarget,omitempty"`
+}
+
+// stateStore saves volume records as files in a directory. A nil store saves nothing.
+type stateStore struct {
+	dir string
+}
+
+func (st *stateStore) fileOf(target MountTarget) string {
+	return filepath.Join(st.dir, fmt.Sprintf("%x.json", sha256.Sum256([]byte(target))))
+}
+
+// save writes the record to a temporary file first, so that a partial record is never loaded.
+func (st *stateStore) save(record *volumeRecord) {
+	if st == nil {
+		return
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		errorlog.Errorf("unable to encode the record of volume %q: %s", record.VolumeId, err)
+		return
+	}
+
+	file := st.fileOf(record.Target)
+	if err = os.WriteFile(file+".tmp", data, 0o600); err == nil {
+		err = os.Rename(file+".tmp", file)
+	}
+
+	if err != nil {
+		errorlog.Errorf("unable to save the record of volume %q: %s", record.VolumeId, err)
+	}
+}
+
+func (st *stateStore) remove(target MountTarget) {
+	if st == nil {
+		return
+	}
+
+	if err := os.Remove(st.fileOf(target)); err != nil && !os.IsNotExist(err) {
+		errorlog.Errorf("unable to remove the record of target %q: %s", target, err)
+	}
+}
+
+func (st *stateStore) load() (records []*volumeRecord, err error) {
+	files, err := filepath.Glob(filepath.Join(st.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		record := &volumeRecord{}
+		if err = json.Unmarshal(data, record); err != nil {
+			errorlog.Errorf("remove invalid volume record %s: %s", file, err)
+			os.Remove(file)
+			continue
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// EnableStateStore saves the state of volumes in dir and recovers the state saved by previous runs.
+// Volumes which are no longer mounted are dropped.
+func (s *SnapshotMounter) EnableStateStore(dir string) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		klog.Fatalf("unable to create the directory for volume state: %s", err)
+	}
+
+	store := &stateStore{dir: dir}
+	records, err := store.load()
+	if err != nil {
+		klog.Fatalf("unable to load volume state: %s", err)
+	}
+
+	mounter := k8smount.New("")
+	s.stagingGuard.Lock()
+	s.volumesGuard.Lock()
+	defer s.stagingGuard.Unlock()
+	defer s.volumesGuard.Unlock()
+	for _, record := range records {
+		if notMnt, err := mounter.IsLikelyNotMountPoint(string(record.Target)); err != nil || notMnt {
+			klog.Infof("volume %q is no longer mounted to %q. drop its record", record.VolumeId, record.Target)
+			store.remove(record.Target)
+			continue
+		}
+
+		if record.StagingTarget != "" {
+			s.publications[record.Target] = record.StagingTarget
+			klog.Infof("recovered publication of volume %q at %q", record.VolumeId, record.Target)
+			continue
+		}
+
+		v, err := record.publishedVolume()
+		if err != nil {
+			errorlog.Errorf("unable to recover volume %q at %q: %s", record.VolumeId, record.Target, err)
+			store.remove(record.Target)
+			continue
+		}
+
+		s.volumes[record.Target] = v
+		klog.Infof("recovered volume %q at %q", record.VolumeId, record.Target)
+	}
+
+	s.state = store
+}
+
+func newVolumeRecord(volumeId string, target MountTarget, image reference.Named, opts MountOptions) *volumeRecord {
+	record := &volumeRecord{VolumeId: volumeId, Target: target, Options: opts}
+	if image != nil {
+		record.Image = image.String()
+	}
+
+	for _, overlayImage := range opts.OverlayImages {
+		record.OverlayImages = append(record.OverlayImages, overlayImage.String())
+	}
+
+	return record
+}
+
+func (r *volumeRecord) publishedVolume() (*publishedVolume, error) {
+	image, err := reference.ParseNamed(r.Image)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := r.Options
+	opts.OverlayImages = nil
+	for _, overlayImage := range r.OverlayImages {
+		named, err := reference.ParseNamed(overlayImage)
+		if err != nil {
+			return nil, err
+		}
+
+		opts.OverlayImages = append(opts.OverlayImages, named)
+	}
+
+	return &publishedVolume{volumeId: r.VolumeId, image: image, opts: opts}, nil
+}
@@ -0,0 +1,124 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/warm-metal/container-image-csi-driver/pkg/errorlog"
+	"k8s.io/klog/v2"
+	k8smount "k8s.io/mount-utils"
+)
+
+// subPathStagingDir returns the directory the whole image is mounted to before the directory or file
+// in MountOptions.Path is bound to the target. It is next to the target so that it stays in the volume
+// directory kubelet creates for the pod.
+func subPathStagingDir(target MountTarget) MountTarget {
+	return MountTarget(filepath.Join(filepath.Dir(string(target)), ".image-root"))
+}
+
+// mountSubPath mounts the snapshots to the staging directory of the target, then binds the given
+// directory or file in the image to the target. Symlinks in the path are resolved within the image
+// rootfs, so the path can never escape from it.
+func (s *SnapshotMounter) mountSubPath(
+	ctx context.Context, keys []SnapshotKey, target MountTarget, opts MountOptions,
+) (err error) {
+	staging := subPathStagingDir(target)
+	if err = os.MkdirAll(string(staging), 0o755); err != nil {
+		return err
+	}
+
+	if err = s.mountSnapshots(ctx, keys, staging, opts); err != nil {
+		os.Remove(string(staging))
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			if umountErr := s.unmountStagingDir(ctx, target); umountErr != nil {
+				errorlog.Errorf("unable to unmount the image rootfs at %q: %s", staging, umountErr)
+			}
+		}
+	}()
+
+	source, err := securejoin.SecureJoin(string(staging), opts.Path)
+	if err != nil {
+		return fmt.Errorf("unable to resolve path %q in the image: %w", opts.Path, err)
+	}
+
+	fi, err := os.Stat(source)
+	if err != nil {
+		return fmt.Errorf("path %q is not found in the image: %w", opts.Path, err)
+	}
+
+	switch {
+	case fi.IsDir():
+	case fi.Mode().IsRegular():
+		// A file can only be bound to a file.
+		if err = fileTarget(target); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("path %q in the image is neither a directory nor a regular file", opts.Path)
+	}
+
+	klog.Infof("bind %q of the image to %q", opts.Path, target)
+	return s.runtime.Bind(ctx, source, target, opts)
+}
+
+// unmountStagingDir unmounts and removes the staging directory of the target if it exists.
+func (s *SnapshotMounter) unmountStagingDir(ctx context.Context, target MountTarget) error {
+	return s.unmountAndRemoveDir(ctx, subPathStagingDir(target))
+}
+
+// unmountAndRemoveDir unmounts the directory if it is a mountpoint, then removes it if it exists.
+func (s *SnapshotMounter) unmountAndRemoveDir(ctx context.Context, staging MountTarget) error {
+	notMnt, err := k8smount.New("").IsLikelyNotMountPoint(string(staging))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	if !notMnt {
+		if err = s.runtime.Unmount(ctx, staging); err != nil {
+			return err
+		}
+	}
+
+	return os.Remove(string(staging))
+}
+
+// fileTarget replaces the empty directory created for the target by NodePublishVolume with an empty
+// file, so that a file can be bound to it. Kubelet removes the target after unpublishing whatever it is.
+func fileTarget(target MountTarget) error {
+	fi, err := os.Stat(string(target))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err == nil {
+		if fi.Mode().IsRegular() {
+			return nil
+		}
+
+		if !fi.IsDir() {
+			return fmt.Errorf("target %q is neither a directory nor a regular file", target)
+		}
+
+		if err = os.Remove(string(target)); err != nil {
+			return fmt.Errorf("unable to replace target %q with a file: %w", target, err)
+		}
+	}
+
+	f, err := os.OpenFile(string(target), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,53 @@
+package backend
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// VerityDeviceManager is implemented by runtimes which can protect loop devices with dm-verity in the mount
+// namespace they mount volumes in.
+type VerityDeviceManager interface {
+	// FormatVerity creates the hash tree of the image and returns its root hash.
+	FormatVerity(ctx context.Context, image, hashTree string) (string, error)
+
+	// OpenVerity maps the data device verified by the hash tree on the hash device, and returns the
+	// path of the mapped device.
+	OpenVerity(ctx context.Context, name, dataDevice, hashDevice, rootHash string) (string, error)
+
+	// CloseVerity removes the mapped device.
+	CloseVerity(ctx context.Context, name string) error
+}
+
+// verityHashTree returns the hash tree and the root hash of the image, which are created along with the
+// image if not created yet. They are kept in the cache next to the image.
+func (s *SnapshotMounter) verityHashTree(ctx context.Context, image string) (hashTree, rootHash string, err error) {
+	hashTree = image + ".verity"
+	err = s.blockImages.withGuard(func() error {
+		if data, err := os.ReadFile(image + ".roothash"); err == nil {
+			rootHash = strings.TrimSpace(string(data))
+			return nil
+		}
+
+		klog.Infof("create the verity hash tree of image %q", image)
+		if rootHash, err = s.verityDevices.FormatVerity(ctx, image, hashTree); err != nil {
+			os.Remove(hashTree)
+			return err
+		}
+
+		// The root hash is saved last, so that a partial hash tree is never taken as a complete one.
+		return os.WriteFile(image+".roothash", []byte(rootHash), 0o600)
+	})
+
+	return hashTree, rootHash, err
+}
+
+// verityDeviceName returns the name of the verity device of the block volume published to the target.
+func verityDeviceName(target MountTarget) string {
+	return fmt.Sprintf("csi-verity-%x", sha256.Sum256([]byte(target)))[:43]
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// Version is the only supported version of the config file.
+const Version = "v1"
+
+// Config holds tunables of the node plugin which can be changed without restarting it. Tunables not set in the file
+// fall back to their flags.
+type Config struct {
+	// Version must be Version.
+	Version string `json:"version"`
+
+	// PullTimeout is the timeout of image pulls of volumes without the attribute pullTimeout, as --async-pull-timeout.
+	// Only effective in async mode.
+	PullTimeout *metav1.Duration `json:"pullTimeout,omitempty"`
+
+	// MaxConcurrentRequests caps concurrent requests of CSI methods, as --max-concurrent-requests.
+	MaxConcurrentRequests map[string]int `json:"maxConcurrentRequests,omitempty"`
+
+	// RequestQueueLength is the number of requests of each method waiting for others, as --request-queue-length.
+	RequestQueueLength *int `json:"requestQueueLength,omitempty"`
+
+	// BlockCacheSize is the maximum size of images of block volumes cached on the node, as --block-cache-size.
+	BlockCacheSize *resource.Quantity `json:"blockCacheSize,omitempty"`
+
+	// SnapshotRetention is the period to keep read-only snapshots after their last unmount, as --snapshot-retention.
+	SnapshotRetention *metav1.Duration `json:"snapshotRetention,omitempty"`
+}
+
+// parse parses the config file. Unknown fields are rejected, so that typos don't go unnoticed.
+func parse(data []byte) (*Config, error) {
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	if cfg.Version != Version {
+		return nil, fmt.Errorf("unsupported config version %q, must be %q", cfg.Version, Version)
+	}
+
+	if cfg.PullTimeout != nil && cfg.PullTimeout.Duration <= 0 {
+		return nil, fmt.Errorf("invalid pullTimeout %s, must be positive", cfg.PullTimeout.Duration)
+	}
+
+	if cfg.RequestQueueLength != nil && *cfg.RequestQueueLength < 0 {
+		return nil, fmt.Errorf("invalid requestQueueLength %d, must not be negative", *cfg.RequestQueueLength)
+	}
+
+	if cfg.BlockCacheSize != nil && cfg.BlockCacheSize.Sign() < 0 {
+		return nil, fmt.Errorf("invalid blockCacheSize %s, must not be negative", cfg.BlockCacheSize)
+	}
+
+	if cfg.SnapshotRetention != nil && cfg.SnapshotRetention.Duration < 0 {
+		return nil, fmt.Errorf("invalid snapshotRetention %s, must not be negative", cfg.SnapshotRetention.Duration)
+	}
+
+	return cfg, nil
+}
+
+// Watch loads the config file and applies it, then reloads it in background on SIGHUP, and once its content changes,
+// which is checked every period, until ctx is done. Configs reloaded are applied as well. Invalid configs are logged
+// and ignored, so that the last valid one stays in effect. An error is returned if the file can't be loaded at first.
+func Watch(ctx context.Context, path string, period time.Duration, apply func(*Config)) error {
+	loaded, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := parse(loaded)
+	if err != nil {
+		return err
+	}
+
+	apply(cfg)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			force := false
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				klog.Infof("SIGHUP received. reload config %q", path)
+				force = true
+			case <-ticker.C:
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				klog.Errorf("unable to read config %q: %s", path, err)
+				metrics.OperationErrorsCount.WithLabelValues("reload-config").Inc()
+				continue
+			}
+
+			if !force && bytes.Equal(data, loaded) {
+				continue
+			}
+
+			loaded = data
+			cfg, err := parse(data)
+			if err != nil {
+				klog.Errorf("unable to reload config %q, keep the current one: %s", path, err)
+				metrics.OperationErrorsCount.WithLabelValues("reload-config").Inc()
+				continue
+			}
+
+			klog.Infof("reload config %q", path)
+			apply(cfg)
+		}
+	}()
+
+	return nil
+}
@@ -14,6 +14,15 @@ import (
 const maxMsgSize = 1024 * 1024 * 16
 
 func NewRemoteImageService(endpoint string, connectionTimeout time.Duration) (cri.ImageServiceClient, error) {
+	conn, err := dial(endpoint, connectionTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return cri.NewImageServiceClient(conn), nil
+}
+
+func dial(endpoint string, connectionTimeout time.Duration) (*grpc.ClientConn, error) {
 	addr, dialer, err := util.GetAddressAndDialer(endpoint)
 	if err != nil {
 		return nil, err
@@ -34,5 +43,5 @@ func NewRemoteImageService(endpoint string, connectionTimeout time.Duration) (cr
 		return nil, err
 	}
 
-	return cri.NewImageServiceClient(conn), nil
+	return conn, nil
 }
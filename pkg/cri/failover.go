@@ -0,0 +1,198 @@
+package cri
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	cri "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/klog/v2"
+)
+
+// FailoverImageService is an image service failing over between endpoints of the same runtime, e.g. the containerd
+// socket and a backup one. Requests go to the active endpoint, and to the next one connecting if it is unavailable.
+// Connections to unavailable endpoints are dropped, so that they are dialed again once used.
+type FailoverImageService struct {
+	endpoints         []string
+	connectionTimeout time.Duration
+
+	guard  sync.Mutex
+	conns  []*grpc.ClientConn
+	active int
+}
+
+var _ cri.ImageServiceClient = &FailoverImageService{}
+
+// NewFailoverImageService connects to the first endpoint accepting connections, which becomes the active one.
+func NewFailoverImageService(endpoints []string, connectionTimeout time.Duration) (*FailoverImageService, error) {
+	f := &FailoverImageService{
+		endpoints:         endpoints,
+		connectionTimeout: connectionTimeout,
+		conns:             make([]*grpc.ClientConn, len(endpoints)),
+	}
+
+	for i := range endpoints {
+		if _, err := f.client(i); err == nil {
+			f.active = i
+			klog.Infof("image service %q is active", endpoints[i])
+			return f, nil
+		}
+	}
+
+	return nil, fmt.Errorf("none of image services %q accepts connections", endpoints)
+}
+
+// Probe checks health of all endpoints every period until ctx is done, and activates the first healthy one in the
+// order of endpoints, so that requests go back to the preferred endpoint once it recovers.
+func (f *FailoverImageService) Probe(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for i := range f.endpoints {
+			if f.healthy(ctx, i) {
+				f.activate(i)
+				break
+			}
+		}
+	}
+}
+
+// healthy returns true if the endpoint serves ImageFsInfo in time.
+func (f *FailoverImageService) healthy(ctx context.Context, i int) bool {
+	client, err := f.client(i)
+	if err != nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, f.connectionTimeout)
+	defer cancel()
+	if _, err = client.ImageFsInfo(ctx, &cri.ImageFsInfoRequest{}); err != nil {
+		klog.Warningf("image service %q is unhealthy: %s", f.endpoints[i], err)
+		f.disconnect(i)
+		return false
+	}
+
+	return true
+}
+
+// client returns the client of the endpoint, and dials it if not connected.
+func (f *FailoverImageService) client(i int) (cri.ImageServiceClient, error) {
+	f.guard.Lock()
+	defer f.guard.Unlock()
+	if f.conns[i] == nil {
+		conn, err := dial(f.endpoints[i], f.connectionTimeout)
+		if err != nil {
+			return nil, err
+		}
+
+		f.conns[i] = conn
+	}
+
+	return cri.NewImageServiceClient(f.conns[i]), nil
+}
+
+func (f *FailoverImageService) disconnect(i int) {
+	f.guard.Lock()
+	defer f.guard.Unlock()
+	if f.conns[i] != nil {
+		f.conns[i].Close()
+		f.conns[i] = nil
+	}
+}
+
+func (f *FailoverImageService) activate(i int) {
+	f.guard.Lock()
+	defer f.guard.Unlock()
+	if f.active != i {
+		klog.Warningf("image service fails over from %q to %q", f.endpoints[f.active], f.endpoints[i])
+		f.active = i
+	}
+}
+
+// invoke calls the active endpoint, and then the others in order if it is unavailable.
+func invoke[T any](ctx context.Context, f *FailoverImageService, call func(cri.ImageServiceClient) (T, error)) (
+	resp T, err error,
+) {
+	f.guard.Lock()
+	active := f.active
+	f.guard.Unlock()
+
+	err = fmt.Errorf("none of image services %q accepts connections", f.endpoints)
+	for n := range f.endpoints {
+		i := (active + n) % len(f.endpoints)
+		client, dialErr := f.client(i)
+		if dialErr != nil {
+			continue
+		}
+
+		resp, err = call(client)
+		if status.Code(err) != codes.Unavailable || ctx.Err() != nil {
+			f.activate(i)
+			return
+		}
+
+		klog.Warningf("image service %q is unavailable: %s", f.endpoints[i], err)
+		f.disconnect(i)
+	}
+
+	return
+}
+
+func (f *FailoverImageService) ListImages(
+	ctx context.Context, in *cri.ListImagesRequest, opts ...grpc.CallOption,
+) (*cri.ListImagesResponse, error) {
+	return invoke(ctx, f, func(client cri.ImageServiceClient) (*cri.ListImagesResponse, error) {
+		return client.ListImages(ctx, in, opts...)
+	})
+}
+
+func (f *FailoverImageService) StreamImages(
+	ctx context.Context, in *cri.StreamImagesRequest, opts ...grpc.CallOption,
+) (grpc.ServerStreamingClient[cri.StreamImagesResponse], error) {
+	return invoke(ctx, f,
+		func(client cri.ImageServiceClient) (grpc.ServerStreamingClient[cri.StreamImagesResponse], error) {
+			return client.StreamImages(ctx, in, opts...)
+		})
+}
+
+func (f *FailoverImageService) ImageStatus(
+	ctx context.Context, in *cri.ImageStatusRequest, opts ...grpc.CallOption,
+) (*cri.ImageStatusResponse, error) {
+	return invoke(ctx, f, func(client cri.ImageServiceClient) (*cri.ImageStatusResponse, error) {
+		return client.ImageStatus(ctx, in, opts...)
+	})
+}
+
+func (f *FailoverImageService) PullImage(
+	ctx context.Context, in *cri.PullImageRequest, opts ...grpc.CallOption,
+) (*cri.PullImageResponse, error) {
+	return invoke(ctx, f, func(client cri.ImageServiceClient) (*cri.PullImageResponse, error) {
+		return client.PullImage(ctx, in, opts...)
+	})
+}
+
+func (f *FailoverImageService) RemoveImage(
+	ctx context.Context, in *cri.RemoveImageRequest, opts ...grpc.CallOption,
+) (*cri.RemoveImageResponse, error) {
+	return invoke(ctx, f, func(client cri.ImageServiceClient) (*cri.RemoveImageResponse, error) {
+		return client.RemoveImage(ctx, in, opts...)
+	})
+}
+
+func (f *FailoverImageService) ImageFsInfo(
+	ctx context.Context, in *cri.ImageFsInfoRequest, opts ...grpc.CallOption,
+) (*cri.ImageFsInfoResponse, error) {
+	return invoke(ctx, f, func(client cri.ImageServiceClient) (*cri.ImageFsInfoResponse, error) {
+		return client.ImageFsInfo(ctx, in, opts...)
+	})
+}
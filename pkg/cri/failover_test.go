@@ -0,0 +1,171 @@
+package cri
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	cri "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// fakeEndpoint is an image service returning its name as the image ID, or the error code set.
+type fakeEndpoint struct {
+	cri.UnimplementedImageServiceServer
+	name     string
+	endpoint string
+	code     atomic.Uint32
+	server   *grpc.Server
+}
+
+func startEndpoint(t *testing.T, dir, name string) *fakeEndpoint {
+	socket := filepath.Join(dir, name+".sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+
+	e := &fakeEndpoint{name: name, endpoint: "unix://" + socket, server: grpc.NewServer()}
+	cri.RegisterImageServiceServer(e.server, e)
+	go e.server.Serve(listener)
+	t.Cleanup(e.server.Stop)
+	return e
+}
+
+func (e *fakeEndpoint) err() error {
+	if code := codes.Code(e.code.Load()); code != codes.OK {
+		return status.Errorf(code, "%s fails", e.name)
+	}
+
+	return nil
+}
+
+func (e *fakeEndpoint) ImageStatus(context.Context, *cri.ImageStatusRequest) (*cri.ImageStatusResponse, error) {
+	if err := e.err(); err != nil {
+		return nil, err
+	}
+
+	return &cri.ImageStatusResponse{Image: &cri.Image{Id: e.name}}, nil
+}
+
+func (e *fakeEndpoint) ImageFsInfo(context.Context, *cri.ImageFsInfoRequest) (*cri.ImageFsInfoResponse, error) {
+	if err := e.err(); err != nil {
+		return nil, err
+	}
+
+	return &cri.ImageFsInfoResponse{}, nil
+}
+
+// startEndpoints starts the preferred endpoint and the backup one, and returns the failover image service of them.
+func startEndpoints(t *testing.T) (preferred, backup *fakeEndpoint, f *FailoverImageService) {
+	// Paths of unix sockets are limited to 108 bytes, which t.TempDir may exceed.
+	dir, err := os.MkdirTemp("", "failover")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	preferred = startEndpoint(t, dir, "preferred")
+	backup = startEndpoint(t, dir, "backup")
+	f, err = NewFailoverImageService([]string{preferred.endpoint, backup.endpoint}, time.Second)
+	require.NoError(t, err)
+	return
+}
+
+func (f *FailoverImageService) activeEndpoint() string {
+	f.guard.Lock()
+	defer f.guard.Unlock()
+	return f.endpoints[f.active]
+}
+
+func TestFailoverImageService(t *testing.T) {
+	tests := []struct {
+		name          string
+		preferred     codes.Code
+		backup        codes.Code
+		stopPreferred bool
+		servedBy      string
+		code          codes.Code
+		active        string
+	}{
+		{
+			name:     "preferred endpoint available",
+			servedBy: "preferred",
+			active:   "preferred",
+		},
+		{
+			name:      "preferred endpoint unavailable",
+			preferred: codes.Unavailable,
+			servedBy:  "backup",
+			active:    "backup",
+		},
+		{
+			name:          "preferred endpoint stopped",
+			stopPreferred: true,
+			servedBy:      "backup",
+			active:        "backup",
+		},
+		{
+			name:      "other errors",
+			preferred: codes.NotFound,
+			code:      codes.NotFound,
+			active:    "preferred",
+		},
+		{
+			name:      "all endpoints unavailable",
+			preferred: codes.Unavailable,
+			backup:    codes.Unavailable,
+			code:      codes.Unavailable,
+			active:    "preferred",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			preferred, backup, f := startEndpoints(t)
+			preferred.code.Store(uint32(tt.preferred))
+			backup.code.Store(uint32(tt.backup))
+			if tt.stopPreferred {
+				preferred.server.Stop()
+			}
+
+			resp, err := f.ImageStatus(context.Background(), &cri.ImageStatusRequest{})
+			assert.Equal(t, tt.code, status.Code(err))
+			if tt.code == codes.OK {
+				assert.Equal(t, tt.servedBy, resp.GetImage().GetId())
+			}
+
+			endpoints := map[string]string{"preferred": preferred.endpoint, "backup": backup.endpoint}
+			assert.Equal(t, endpoints[tt.active], f.activeEndpoint())
+		})
+	}
+}
+
+func TestFailoverImageServiceProbe(t *testing.T) {
+	preferred, _, f := startEndpoints(t)
+	preferred.code.Store(uint32(codes.Unavailable))
+	resp, err := f.ImageStatus(context.Background(), &cri.ImageStatusRequest{})
+	require.NoError(t, err)
+	require.Equal(t, "backup", resp.GetImage().GetId())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.Probe(ctx, 10*time.Millisecond)
+
+	// Requests stay on the backup endpoint while the preferred one is unhealthy.
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, f.endpoints[1], f.activeEndpoint())
+
+	preferred.code.Store(uint32(codes.OK))
+	assert.Eventually(t, func() bool {
+		return f.activeEndpoint() == preferred.endpoint
+	}, 5*time.Second, 10*time.Millisecond)
+
+	resp, err = f.ImageStatus(context.Background(), &cri.ImageStatusRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "preferred", resp.GetImage().GetId())
+}
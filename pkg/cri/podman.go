@@ -0,0 +1,188 @@
+package cri
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	cri "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/klog/v2"
+)
+
+// podmanAPIPrefix is the prefix of the versioned libpod API.
+const podmanAPIPrefix = "/v4.0.0/libpod"
+
+// podmanImageService implements the CRI image service via the libpod API of Podman, which shares its
+// containers-storage with the driver. Only RPCs used by the driver are implemented.
+type podmanImageService struct {
+	cli *http.Client
+}
+
+// NewPodmanImageService creates an image service pulling images via the Podman API listening on the socket,
+// e.g. /run/user/1000/podman/podman.sock of rootless Podman.
+func NewPodmanImageService(socketPath string) cri.ImageServiceClient {
+	return &podmanImageService{cli: &http.Client{Transport: &http.Transport{
+		DisableCompression: true,
+		DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+			return net.DialTimeout("unix", socketPath, 32*time.Second)
+		},
+	}}}
+}
+
+func (p podmanImageService) do(
+	ctx context.Context, method, path string, query url.Values, header http.Header,
+) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, "http://podman"+podmanAPIPrefix+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create http request: %w", err)
+	}
+
+	req.URL.RawQuery = query.Encode()
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	resp, err := p.cli.Do(req)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return nil, status.Errorf(codes.Unknown, "%s %s failed with status %d: %s", method, path, resp.StatusCode, data)
+	}
+
+	return resp, nil
+}
+
+func (p podmanImageService) ListImages(
+	context.Context, *cri.ListImagesRequest, ...grpc.CallOption,
+) (*cri.ListImagesResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+func (p podmanImageService) StreamImages(
+	context.Context, *cri.StreamImagesRequest, ...grpc.CallOption,
+) (grpc.ServerStreamingClient[cri.StreamImagesResponse], error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+func (p podmanImageService) ImageStatus(
+	ctx context.Context, in *cri.ImageStatusRequest, _ ...grpc.CallOption,
+) (*cri.ImageStatusResponse, error) {
+	resp, err := p.do(ctx, http.MethodGet, "/images/"+in.GetImage().GetImage()+"/json", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return &cri.ImageStatusResponse{}, nil
+	}
+
+	img := struct {
+		ID          string `json:"Id"`
+		RepoTags    []string
+		RepoDigests []string
+		Size        uint64
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(&img); err != nil {
+		return nil, status.Errorf(codes.Internal, "invalid image status: %s", err)
+	}
+
+	return &cri.ImageStatusResponse{Image: &cri.Image{
+		Id:          img.ID,
+		RepoTags:    img.RepoTags,
+		RepoDigests: img.RepoDigests,
+		Size:        img.Size,
+		Spec:        in.GetImage(),
+	}}, nil
+}
+
+func (p podmanImageService) PullImage(
+	ctx context.Context, in *cri.PullImageRequest, _ ...grpc.CallOption,
+) (*cri.PullImageResponse, error) {
+	image := in.GetImage().GetImage()
+	header := http.Header{}
+	if auth := in.GetAuth(); auth != nil {
+		data, err := json.Marshal(map[string]string{
+			"username":      auth.Username,
+			"password":      auth.Password,
+			"identitytoken": auth.IdentityToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		header.Set("X-Registry-Auth", base64.URLEncoding.EncodeToString(data))
+	}
+
+	query := url.Values{"reference": []string{image}, "quiet": []string{strconv.FormatBool(true)}}
+	resp, err := p.do(ctx, http.MethodPost, "/images/pull", query, header)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, status.Errorf(codes.NotFound, "image %q is not found", image)
+	}
+
+	// The response is a stream of reports, in which errors are reported as well.
+	var id string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		report := struct {
+			Error string `json:"error"`
+			ID    string `json:"id"`
+		}{}
+		if err = json.Unmarshal(scanner.Bytes(), &report); err != nil {
+			continue
+		}
+
+		if report.Error != "" {
+			return nil, status.Errorf(codes.Unknown, "unable to pull image %q: %s", image, report.Error)
+		}
+
+		if report.ID != "" {
+			id = report.ID
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, status.Errorf(codes.Unknown, "unable to pull image %q: %s", image, err)
+	}
+
+	klog.V(2).Infof("pulled image %q via podman as %s", image, id)
+	return &cri.PullImageResponse{ImageRef: id}, nil
+}
+
+func (p podmanImageService) RemoveImage(
+	ctx context.Context, in *cri.RemoveImageRequest, _ ...grpc.CallOption,
+) (*cri.RemoveImageResponse, error) {
+	resp, err := p.do(ctx, http.MethodDelete, "/images/"+in.GetImage().GetImage(), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body.Close()
+	return &cri.RemoveImageResponse{}, nil
+}
+
+func (p podmanImageService) ImageFsInfo(
+	context.Context, *cri.ImageFsInfoRequest, ...grpc.CallOption,
+) (*cri.ImageFsInfoResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
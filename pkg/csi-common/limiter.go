@@ -0,0 +1,95 @@
+package csicommon
+
+import (
+	"context"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// requestLimiter caps concurrent requests of each method. Requests beyond the cap wait in a queue of bounded length,
+// and those beyond the queue are rejected with ResourceExhausted, so that a storm of retries from kubelet is served
+// gradually instead of exhausting the memory of the driver. Methods without a cap are unlimited.
+type requestLimiter struct {
+	guard       sync.Mutex
+	slots       map[string]chan struct{}
+	queued      map[string]int
+	queueLength int
+}
+
+func newRequestLimiter(concurrency map[string]int, queueLength int) *requestLimiter {
+	l := &requestLimiter{
+		slots:       make(map[string]chan struct{}),
+		queued:      make(map[string]int),
+		queueLength: queueLength,
+	}
+
+	for method, limit := range concurrency {
+		if limit > 0 {
+			l.slots[method] = make(chan struct{}, limit)
+			metrics.RequestQueueLength.WithLabelValues(method)
+		}
+	}
+
+	return l
+}
+
+func (l *requestLimiter) intercept(
+	ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+) (interface{}, error) {
+	method := path.Base(info.FullMethod)
+	slots, found := l.slots[method]
+	if !found {
+		return handler(ctx, req)
+	}
+
+	select {
+	case slots <- struct{}{}:
+	default:
+		if err := l.wait(ctx, method, slots); err != nil {
+			return nil, err
+		}
+	}
+
+	defer func() { <-slots }()
+	return handler(ctx, req)
+}
+
+// wait queues the request until a slot of the method is released or ctx expires.
+func (l *requestLimiter) wait(ctx context.Context, method string, slots chan struct{}) error {
+	l.guard.Lock()
+	if l.queued[method] >= l.queueLength {
+		l.guard.Unlock()
+		klog.Warningf("too many %s requests in progress, reject the request", method)
+		metrics.OperationErrorsCount.WithLabelValues("throttle").Inc()
+		metrics.RequestQueueRejectionsCount.WithLabelValues(method, metrics.RequestQueueFull).Inc()
+		return status.Errorf(codes.ResourceExhausted, "too many %s requests in progress", method)
+	}
+
+	l.queued[method]++
+	l.guard.Unlock()
+	// The gauge is shared by limiters replaced on reloads, so it is only changed relatively.
+	metrics.RequestQueueLength.WithLabelValues(method).Inc()
+	start := time.Now()
+	defer func() {
+		metrics.RequestQueueLength.WithLabelValues(method).Dec()
+		metrics.RequestQueueWaitTimeHist.WithLabelValues(method).Observe(time.Since(start).Seconds())
+		l.guard.Lock()
+		l.queued[method]--
+		l.guard.Unlock()
+	}()
+
+	select {
+	case slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		metrics.RequestQueueRejectionsCount.WithLabelValues(method, metrics.RequestQueueExpired).Inc()
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
@@ -0,0 +1,125 @@
+package csicommon
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testMethod = "NodePublishVolume"
+
+var testInfo = &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Node/" + testMethod}
+
+// blockingHandler handles requests until release is closed, and reports each request it starts to handle.
+func blockingHandler(started chan<- struct{}, release <-chan struct{}) grpc.UnaryHandler {
+	return func(ctx context.Context, req interface{}) (interface{}, error) {
+		started <- struct{}{}
+		<-release
+		return req, nil
+	}
+}
+
+func queued(l *requestLimiter, method string) int {
+	l.guard.Lock()
+	defer l.guard.Unlock()
+	return l.queued[method]
+}
+
+func TestRequestLimiterUnlimitedMethods(t *testing.T) {
+	l := newRequestLimiter(map[string]int{testMethod: 0}, 0)
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	close(release)
+	for i := 0; i < 2; i++ {
+		resp, err := l.intercept(context.Background(), i, testInfo, blockingHandler(started, release))
+		require.NoError(t, err)
+		assert.Equal(t, i, resp)
+	}
+
+	resp, err := l.intercept(context.Background(), "probe", &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Identity/Probe"},
+		blockingHandler(started, release))
+	require.NoError(t, err)
+	assert.Equal(t, "probe", resp)
+}
+
+func TestRequestLimiter(t *testing.T) {
+	l := newRequestLimiter(map[string]int{testMethod: 1}, 1)
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	handler := blockingHandler(started, release)
+
+	results := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := l.intercept(context.Background(), i, testInfo, handler)
+			results <- err
+		}()
+	}
+
+	// One request is handled, and the other waits in the queue.
+	<-started
+	require.Eventually(t, func() bool { return queued(l, testMethod) == 1 }, time.Second, time.Millisecond)
+	select {
+	case <-started:
+		t.Fatal("requests beyond the limit must not be handled")
+	default:
+	}
+
+	// Requests beyond the queue are rejected.
+	_, err := l.intercept(context.Background(), 2, testInfo, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// The queued request is handled once the slot is released.
+	close(release)
+	require.NoError(t, <-results)
+	require.NoError(t, <-results)
+	<-started
+	assert.Zero(t, queued(l, testMethod))
+	assert.Len(t, l.slots[testMethod], 0)
+}
+
+func TestRequestLimiterCanceledWaits(t *testing.T) {
+	l := newRequestLimiter(map[string]int{testMethod: 1}, 1)
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	handler := blockingHandler(started, release)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := l.intercept(context.Background(), 0, testInfo, handler)
+		done <- err
+	}()
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	waited := make(chan error, 1)
+	go func() {
+		_, err := l.intercept(ctx, 1, testInfo, handler)
+		waited <- err
+	}()
+
+	require.Eventually(t, func() bool { return queued(l, testMethod) == 1 }, time.Second, time.Millisecond)
+	cancel()
+	assert.Equal(t, codes.Canceled, status.Code(<-waited))
+	assert.Zero(t, queued(l, testMethod))
+
+	// Expired waits leave the queue and the slot to other requests.
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := l.intercept(ctx, 2, testInfo, handler)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+
+	close(release)
+	require.NoError(t, <-done)
+	select {
+	case <-started:
+		t.Fatal("canceled requests must not be handled")
+	default:
+	}
+}
@@ -2,14 +2,22 @@ package csicommon
 
 import (
 	"context"
+	"crypto/rand"
 	"fmt"
 	"net"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
+	"github.com/warm-metal/container-image-csi-driver/pkg/errorlog"
+	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 	"k8s.io/klog/v2"
 )
 
@@ -23,6 +31,10 @@ type NonBlockingGRPCServer interface {
 	Stop()
 	// Stops the service forcefully
 	ForceStop()
+	// Limits concurrent requests of methods, e.g. NodePublishVolume, and the number of requests of each method
+	// waiting for others to finish. May be called again to change limits, which requests in progress or waiting
+	// are not subject to.
+	SetRequestLimits(concurrency map[string]int, queueLength int)
 }
 
 func NewNonBlockingGRPCServer() NonBlockingGRPCServer {
@@ -31,8 +43,9 @@ func NewNonBlockingGRPCServer() NonBlockingGRPCServer {
 
 // NonBlocking server
 type nonBlockingGRPCServer struct {
-	wg     sync.WaitGroup
-	server *grpc.Server
+	wg      sync.WaitGroup
+	server  *grpc.Server
+	limiter atomic.Pointer[requestLimiter]
 }
 
 func (s *nonBlockingGRPCServer) Start(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) {
@@ -52,7 +65,23 @@ func (s *nonBlockingGRPCServer) ForceStop() {
 	s.server.Stop()
 }
 
+func (s *nonBlockingGRPCServer) SetRequestLimits(concurrency map[string]int, queueLength int) {
+	s.limiter.Store(newRequestLimiter(concurrency, queueLength))
+}
+
+// limit passes requests to the current limiter if any.
+func (s *nonBlockingGRPCServer) limit(
+	ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if limiter := s.limiter.Load(); limiter != nil {
+		return limiter.intercept(ctx, req, info, handler)
+	}
+
+	return handler(ctx, req)
+}
+
 func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) {
+	defer s.wg.Done()
 	proto, addr, err := parseEndpoint(endpoint)
 	if err != nil {
 		klog.Fatal(err.Error())
@@ -70,8 +99,10 @@ func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, c
 		klog.Fatalf("Failed to listen: %v", err)
 	}
 
+	interceptors := []grpc.UnaryServerInterceptor{metrics.GRPCServer.UnaryServerInterceptor(), s.logGRPC, s.limit}
+
 	opts := []grpc.ServerOption{
-		grpc.UnaryInterceptor(logGRPC),
+		grpc.ChainUnaryInterceptor(interceptors...),
 	}
 	server := grpc.NewServer(opts...)
 	s.server = server
@@ -85,6 +116,7 @@ func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, c
 	if ns != nil {
 		csi.RegisterNodeServer(server, ns)
 	}
+	metrics.GRPCServer.InitializeMetrics(server)
 
 	klog.Infof("Listening for connections on address: %#v", listener.Addr())
 
@@ -105,9 +137,103 @@ func parseEndpoint(ep string) (string, string, error) {
 	return "", "", fmt.Errorf("invalid endpoint: %v", ep)
 }
 
-func logGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	klog.V(3).Infof("GRPC call: %s", info.FullMethod)
-	return handler(ctx, req)
+// volumeRequest is implemented by CSI requests on a volume.
+type volumeRequest interface {
+	GetVolumeId() string
+}
+
+// volumeContextRequest is implemented by CSI requests carrying volume attributes.
+type volumeContextRequest interface {
+	GetVolumeContext() map[string]string
+}
+
+// parametersRequest is implemented by CSI requests carrying StorageClass parameters.
+type parametersRequest interface {
+	GetParameters() map[string]string
+}
+
+// podUIDKey is the volume attribute kubelet passes the UID of the pod in if podInfoOnMount of the CSIDriver is set.
+const podUIDKey = "csi.storage.k8s.io/pod.uid"
+
+// serviceAccountTokensKey is the volume attribute kubelet passes service account tokens of pods in.
+const serviceAccountTokensKey = "csi.storage.k8s.io/serviceAccount.tokens"
+
+// StripSecrets is protosanitizer.StripSecrets which also redacts service account tokens in volume attributes, which
+// are not marked as secrets by the CSI spec.
+func StripSecrets(msg interface{}) fmt.Stringer {
+	if r, ok := msg.(*csi.NodePublishVolumeRequest); ok && r.GetVolumeContext()[serviceAccountTokensKey] != "" {
+		r = proto.Clone(r).(*csi.NodePublishVolumeRequest)
+		r.VolumeContext[serviceAccountTokensKey] = "***stripped***"
+		msg = r
+	}
+
+	return protosanitizer.StripSecrets(msg)
+}
+
+// logGRPC logs each request with secrets redacted, along with the volume and the image it refers to, then records
+// its latency by method and gRPC code. Handlers get a contextual logger via klog.FromContext carrying a request ID,
+// the volume, the image, and the pod UID, so that logs of the same request can be correlated. Errors repeated by
+// retries are rate limited by errorlog.SetInterval.
+func (s *nonBlockingGRPCServer) logGRPC(
+	ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+) (interface{}, error) {
+	var volumeId, image, podUID string
+	if r, ok := req.(volumeRequest); ok {
+		volumeId = r.GetVolumeId()
+	}
+
+	// The volume attribute and the StorageClass parameter of the image are both named "image".
+	if r, ok := req.(volumeContextRequest); ok {
+		image = r.GetVolumeContext()["image"]
+		podUID = r.GetVolumeContext()[podUIDKey]
+	} else if r, ok := req.(parametersRequest); ok {
+		image = r.GetParameters()["image"]
+	}
+
+	logger := klog.LoggerWithValues(klog.FromContext(ctx), "requestID", rand.Text(), "method", info.FullMethod)
+	if volumeId != "" {
+		logger = klog.LoggerWithValues(logger, "volumeID", volumeId)
+	}
+
+	if image != "" {
+		logger = klog.LoggerWithValues(logger, "image", image)
+	}
+
+	if podUID != "" {
+		logger = klog.LoggerWithValues(logger, "podUID", podUID)
+	}
+
+	ctx = klog.NewContext(ctx, logger)
+	logger.V(3).Info("GRPC call")
+	logger.V(5).Info("GRPC request", "request", StripSecrets(req))
+
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	elapsed := time.Since(start)
+	if err != nil {
+		s.logError(logger, info.FullMethod, volumeId, err, "code", status.Code(err).String(), "duration", elapsed)
+		return resp, err
+	}
+
+	logger.V(3).Info("GRPC call succeeded", "duration", elapsed)
+	logger.V(5).Info("GRPC response", "response", protosanitizer.StripSecrets(resp))
+	return resp, nil
+}
+
+// logError logs the failure of the method on the volume unless identical ones are being suppressed.
+func (s *nonBlockingGRPCServer) logError(
+	logger klog.Logger, method, volumeId string, err error, keysAndValues ...interface{},
+) {
+	logged, suppressed := errorlog.Allow(method, volumeId, err.Error())
+	if !logged {
+		return
+	}
+
+	if suppressed > 0 {
+		keysAndValues = append(keysAndValues, "suppressedIdenticalErrors", suppressed)
+	}
+
+	logger.Error(err, "GRPC call failed", keysAndValues...)
 }
 
 // CSIDriver object
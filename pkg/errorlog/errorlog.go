@@ -0,0 +1,129 @@
+// Package errorlog rate limits logs of identical errors. Kubelet retries requests of stuck volumes every few seconds,
+// and loops of the driver, e.g. health checks, janitors and watchers, hit the same error each round while something
+// stays broken, so that logs of identical errors are suppressed for an interval to keep node logs readable.
+package errorlog
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+type key struct {
+	source  string
+	subject string
+	err     string
+}
+
+type entry struct {
+	logged     time.Time
+	suppressed int
+}
+
+// Limiter rate limits logs of identical errors of the same source and subject, e.g. a CSI method and a volume. The
+// first error is logged, and identical ones are suppressed until interval passes, after which the next one is logged
+// along with the number suppressed.
+type Limiter struct {
+	interval time.Duration
+	guard    sync.Mutex
+	errors   map[key]*entry
+	now      func() time.Time
+}
+
+func NewLimiter(interval time.Duration) *Limiter {
+	return &Limiter{
+		interval: interval,
+		errors:   make(map[key]*entry),
+		now:      time.Now,
+	}
+}
+
+// Allow returns whether the error of the source on the subject is logged, and the number of identical errors
+// suppressed since it was last logged.
+func (l *Limiter) Allow(source, subject, err string) (bool, int) {
+	now := l.now()
+	k := key{source: source, subject: subject, err: err}
+	l.guard.Lock()
+	defer l.guard.Unlock()
+	l.forget(now)
+
+	e, found := l.errors[k]
+	if !found {
+		l.errors[k] = &entry{logged: now}
+		return true, 0
+	}
+
+	if now.Sub(e.logged) < l.interval {
+		e.suppressed++
+		return false, 0
+	}
+
+	suppressed := e.suppressed
+	e.logged, e.suppressed = now, 0
+	return true, suppressed
+}
+
+// forget drops errors not logged for two intervals, i.e. those which stopped repeating, and logs summaries of their
+// suppressed errors.
+func (l *Limiter) forget(now time.Time) {
+	for k, e := range l.errors {
+		if now.Sub(e.logged) < 2*l.interval {
+			continue
+		}
+
+		if e.suppressed > 0 {
+			klog.Background().Info("Suppressed identical errors", "source", k.source, "subject", k.subject,
+				"err", k.err, "count", e.suppressed)
+		}
+
+		delete(l.errors, k)
+	}
+}
+
+// errors are all logged if limiter is nil.
+var limiter atomic.Pointer[Limiter]
+
+// SetInterval logs identical errors at most once per interval, along with the number of those suppressed in between.
+// Errors are all logged if interval is 0.
+func SetInterval(interval time.Duration) {
+	if interval <= 0 {
+		limiter.Store(nil)
+		return
+	}
+
+	limiter.Store(NewLimiter(interval))
+}
+
+// Allow returns whether the error of the source on the subject is logged, and the number of identical errors
+// suppressed since it was last logged, as SetInterval configures.
+func Allow(source, subject, err string) (bool, int) {
+	if l := limiter.Load(); l != nil {
+		return l.Allow(source, subject, err)
+	}
+
+	return true, 0
+}
+
+// Errorf logs the error as klog.Errorf does, unless identical errors of the caller are being suppressed.
+func Errorf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	source := "unknown"
+	if _, file, line, ok := runtime.Caller(1); ok {
+		source = fmt.Sprintf("%s:%d", file, line)
+	}
+
+	logged, suppressed := Allow(source, "", msg)
+	if !logged {
+		return
+	}
+
+	if suppressed > 0 {
+		msg = fmt.Sprintf("%s (%d identical errors suppressed)", msg, suppressed)
+	}
+
+	klog.ErrorDepth(1, msg)
+}
@@ -0,0 +1,79 @@
+package errorlog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLimiter(interval time.Duration) (*Limiter, *time.Time) {
+	now := time.Unix(0, 0)
+	l := NewLimiter(interval)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestLimiterSuppression(t *testing.T) {
+	l, _ := newTestLimiter(time.Minute)
+	logged, suppressed := l.Allow("NodePublishVolume", "vol", "pull failed")
+	assert.True(t, logged)
+	assert.Zero(t, suppressed)
+
+	for i := 0; i < 3; i++ {
+		logged, _ = l.Allow("NodePublishVolume", "vol", "pull failed")
+		assert.False(t, logged)
+	}
+
+	// Other errors, sources or subjects are logged.
+	logged, _ = l.Allow("NodePublishVolume", "vol", "mount failed")
+	assert.True(t, logged)
+	logged, _ = l.Allow("NodeUnpublishVolume", "vol", "pull failed")
+	assert.True(t, logged)
+	logged, _ = l.Allow("NodePublishVolume", "another-vol", "pull failed")
+	assert.True(t, logged)
+}
+
+func TestLimiterWindowReset(t *testing.T) {
+	l, now := newTestLimiter(time.Minute)
+	l.Allow("health", "", "volume is broken")
+	*now = now.Add(30 * time.Second)
+	logged, _ := l.Allow("health", "", "volume is broken")
+	assert.False(t, logged)
+	logged, _ = l.Allow("health", "", "volume is broken")
+	assert.False(t, logged)
+
+	// The next error after the interval is logged with the number suppressed, and starts a new window.
+	*now = now.Add(30 * time.Second)
+	logged, suppressed := l.Allow("health", "", "volume is broken")
+	assert.True(t, logged)
+	assert.Equal(t, 2, suppressed)
+
+	*now = now.Add(59 * time.Second)
+	logged, _ = l.Allow("health", "", "volume is broken")
+	assert.False(t, logged)
+
+	// Errors which stopped repeating are forgotten after two intervals.
+	*now = now.Add(2 * time.Minute)
+	l.Allow("health", "", "another error")
+	assert.Len(t, l.errors, 1)
+	logged, suppressed = l.Allow("health", "", "volume is broken")
+	assert.True(t, logged)
+	assert.Zero(t, suppressed)
+}
+
+func TestSetInterval(t *testing.T) {
+	defer SetInterval(0)
+
+	SetInterval(0)
+	for i := 0; i < 2; i++ {
+		logged, _ := Allow("janitor", "", "unable to list snapshots")
+		assert.True(t, logged)
+	}
+
+	SetInterval(time.Minute)
+	logged, _ := Allow("janitor", "", "unable to list snapshots")
+	assert.True(t, logged)
+	logged, _ = Allow("janitor", "", "unable to list snapshots")
+	assert.False(t, logged)
+}
@@ -0,0 +1,155 @@
+// Package fake provides an in-memory CRI image service and a mount backend, so that the driver can be run and
+// tested, e.g. by csi-sanity, without a container runtime or privileges. Nothing is pulled or mounted.
+package fake
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/distribution/reference"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// imageSize is the size of each fake image.
+const imageSize = 100 << 20
+
+// ImageService is a CRI image service keeping pulled images in memory. Images are identified by the sha256 of their
+// normalized references, which are also their digests.
+type ImageService struct {
+	// PullDelay is how long each pull takes.
+	PullDelay time.Duration
+
+	guard  sync.Mutex
+	images map[string]*criapi.Image
+}
+
+var _ criapi.ImageServiceClient = &ImageService{}
+
+func NewImageService() *ImageService {
+	return &ImageService{images: make(map[string]*criapi.Image)}
+}
+
+func imageOf(ref string) (*criapi.Image, error) {
+	named, err := reference.ParseDockerRef(ref)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid image %q: %s", ref, err)
+	}
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(named.String())))
+	image := &criapi.Image{
+		Id:          digest,
+		RepoDigests: []string{named.Name() + "@" + digest},
+		Size:        imageSize,
+		Spec:        &criapi.ImageSpec{Image: named.String()},
+	}
+
+	if _, isDigested := named.(reference.Digested); !isDigested {
+		image.RepoTags = []string{named.String()}
+	}
+
+	return image, nil
+}
+
+// Pulled returns true if the image is pulled.
+func (s *ImageService) Pulled(ref string) bool {
+	image, err := imageOf(ref)
+	if err != nil {
+		return false
+	}
+
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	_, found := s.images[image.Spec.Image]
+	return found
+}
+
+func (s *ImageService) PullImage(
+	ctx context.Context, in *criapi.PullImageRequest, _ ...grpc.CallOption,
+) (*criapi.PullImageResponse, error) {
+	image, err := imageOf(in.GetImage().GetImage())
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	case <-time.After(s.PullDelay):
+	}
+
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	s.images[image.Spec.Image] = image
+	return &criapi.PullImageResponse{ImageRef: image.Id}, nil
+}
+
+// ImageStatus returns no image if the image isn't pulled, as CRI runtimes do.
+func (s *ImageService) ImageStatus(
+	_ context.Context, in *criapi.ImageStatusRequest, _ ...grpc.CallOption,
+) (*criapi.ImageStatusResponse, error) {
+	image, err := imageOf(in.GetImage().GetImage())
+	if err != nil {
+		return nil, err
+	}
+
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	return &criapi.ImageStatusResponse{Image: s.images[image.Spec.Image]}, nil
+}
+
+func (s *ImageService) ListImages(
+	_ context.Context, _ *criapi.ListImagesRequest, _ ...grpc.CallOption,
+) (*criapi.ListImagesResponse, error) {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	resp := &criapi.ListImagesResponse{Images: []*criapi.Image{}}
+	for _, image := range s.images {
+		resp.Images = append(resp.Images, image)
+	}
+
+	sort.Slice(resp.Images, func(i, j int) bool { return resp.Images[i].Spec.Image < resp.Images[j].Spec.Image })
+	return resp, nil
+}
+
+func (s *ImageService) StreamImages(
+	context.Context, *criapi.StreamImagesRequest, ...grpc.CallOption,
+) (grpc.ServerStreamingClient[criapi.StreamImagesResponse], error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+func (s *ImageService) RemoveImage(
+	_ context.Context, in *criapi.RemoveImageRequest, _ ...grpc.CallOption,
+) (*criapi.RemoveImageResponse, error) {
+	image, err := imageOf(in.GetImage().GetImage())
+	if err != nil {
+		return nil, err
+	}
+
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	delete(s.images, image.Spec.Image)
+	return &criapi.RemoveImageResponse{}, nil
+}
+
+// ImageFsInfo reports one image filesystem holding all pulled images.
+func (s *ImageService) ImageFsInfo(
+	_ context.Context, _ *criapi.ImageFsInfoRequest, _ ...grpc.CallOption,
+) (*criapi.ImageFsInfoResponse, error) {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	return &criapi.ImageFsInfoResponse{
+		ImageFilesystems: []*criapi.FilesystemUsage{{
+			Timestamp:  time.Now().UnixNano(),
+			FsId:       &criapi.FilesystemIdentifier{Mountpoint: "/fake"},
+			UsedBytes:  &criapi.UInt64Value{Value: uint64(len(s.images)) * imageSize},
+			InodesUsed: &criapi.UInt64Value{Value: uint64(len(s.images))},
+		}},
+	}, nil
+}
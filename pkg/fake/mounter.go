@@ -0,0 +1,156 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/warm-metal/container-image-csi-driver/pkg/backend"
+)
+
+// Mounter is a mount backend recording volumes instead of mounting them. Images must be pulled via its image
+// service before they are mounted, as the driver does.
+type Mounter struct {
+	images *ImageService
+
+	guard  sync.Mutex
+	mounts map[backend.MountTarget]string
+}
+
+var (
+	_ backend.Mounter        = &Mounter{}
+	_ backend.ImageUser      = &Mounter{}
+	_ backend.StateInspector = &Mounter{}
+
+	_ backend.VolumeUsageReporter = &Mounter{}
+)
+
+func NewMounter(images *ImageService) *Mounter {
+	return &Mounter{images: images, mounts: make(map[backend.MountTarget]string)}
+}
+
+// Mounts returns images of volumes by their targets.
+func (m *Mounter) Mounts() map[backend.MountTarget]string {
+	m.guard.Lock()
+	defer m.guard.Unlock()
+	mounts := make(map[backend.MountTarget]string, len(m.mounts))
+	for target, image := range m.mounts {
+		mounts[target] = image
+	}
+
+	return mounts
+}
+
+func (m *Mounter) Mount(
+	_ context.Context, volumeId string, target backend.MountTarget, image reference.Named, _ backend.MountOptions,
+) error {
+	if !m.images.Pulled(image.String()) {
+		return fmt.Errorf("image %q of volume %q is not pulled", image, volumeId)
+	}
+
+	m.guard.Lock()
+	defer m.guard.Unlock()
+	m.mounts[target] = image.String()
+	return nil
+}
+
+func (m *Mounter) Publish(
+	ctx context.Context, volumeId string, _, target backend.MountTarget, image reference.Named,
+	opts backend.MountOptions, _ bool,
+) error {
+	return m.Mount(ctx, volumeId, target, image, opts)
+}
+
+// Unmount succeeds even if the target isn't mounted, as unmounts are retried.
+func (m *Mounter) Unmount(_ context.Context, _ string, target backend.MountTarget) error {
+	m.guard.Lock()
+	defer m.guard.Unlock()
+	delete(m.mounts, target)
+	return nil
+}
+
+func (m *Mounter) ImageExists(_ context.Context, image reference.Named) bool {
+	return m.images.Pulled(image.String())
+}
+
+// ImageInUse implements backend.ImageUser.
+func (m *Mounter) ImageInUse(image reference.Named) bool {
+	m.guard.Lock()
+	defer m.guard.Unlock()
+	for _, mounted := range m.mounts {
+		if mounted == image.String() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// InspectState implements backend.StateInspector. Volumes are reported by their targets only.
+func (m *Mounter) InspectState() backend.MounterState {
+	m.guard.Lock()
+	defer m.guard.Unlock()
+	state := backend.MounterState{Volumes: []backend.VolumeState{}, Snapshots: []backend.SnapshotState{}}
+	for target, image := range m.mounts {
+		state.Volumes = append(state.Volumes, backend.VolumeState{Target: string(target), Image: image})
+	}
+
+	sort.Slice(state.Volumes, func(i, j int) bool { return state.Volumes[i].Target < state.Volumes[j].Target })
+	return state
+}
+
+func (m *Mounter) RemoveScratch(context.Context, string) error {
+	return nil
+}
+
+func (m *Mounter) CheckMount(_ context.Context, target backend.MountTarget) error {
+	m.guard.Lock()
+	defer m.guard.Unlock()
+	if _, found := m.mounts[target]; !found {
+		return fmt.Errorf("%q is not mounted", target)
+	}
+
+	return nil
+}
+
+func (m *Mounter) InspectImage(ctx context.Context, image reference.Named) (*backend.ImageMetadata, error) {
+	if !m.images.Pulled(image.String()) {
+		return nil, fmt.Errorf("image %q is not found", image)
+	}
+
+	local, err := imageOf(image.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return &backend.ImageMetadata{
+		Image:          image.String(),
+		Registry:       reference.Domain(image),
+		Digest:         local.Id,
+		ManifestDigest: local.Id,
+		PulledAt:       time.Now(),
+	}, nil
+}
+
+// ImageSize implements backend.ImageSizer. Fake images are unpacked to twice their size.
+func (m *Mounter) ImageSize(_ context.Context, image reference.Named) (backend.ImageSize, error) {
+	if !m.images.Pulled(image.String()) {
+		return backend.ImageSize{}, fmt.Errorf("image %q is not found", image)
+	}
+
+	return backend.ImageSize{Compressed: imageSize, Unpacked: 2 * imageSize}, nil
+}
+
+// VolumeUsage implements backend.VolumeUsageReporter. Fake volumes write nothing, and share their unpacked images.
+func (m *Mounter) VolumeUsage(_ context.Context, target backend.MountTarget) (backend.SnapshotUsage, error) {
+	m.guard.Lock()
+	defer m.guard.Unlock()
+	if _, found := m.mounts[target]; !found {
+		return backend.SnapshotUsage{}, fmt.Errorf("%q is not mounted", target)
+	}
+
+	return backend.SnapshotUsage{Image: 2 * imageSize}, nil
+}
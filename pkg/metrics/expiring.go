@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultSeriesTTL is how long series of expiring gauges are exported after their last update by default.
+const DefaultSeriesTTL = time.Minute
+
+var (
+	// detailedImages labels pull gauges with images in addition to registries
+	detailedImages atomic.Bool
+	// seriesTTL is the TTL of series of expiring gauges in nanoseconds
+	seriesTTL atomic.Int64
+)
+
+func init() {
+	seriesTTL.Store(int64(DefaultSeriesTTL))
+}
+
+// ConfigureImageMetrics sets whether gauges of pulls are labeled with images in addition to registries, and how long
+// their series are exported after their last update. Gauges are only labeled with registries by default, so that the
+// number of series is bounded by the number of registries instead of growing with every image pulled on the node.
+func ConfigureImageMetrics(detailed bool, ttl time.Duration) {
+	detailedImages.Store(detailed)
+	if ttl > 0 {
+		seriesTTL.Store(int64(ttl))
+	}
+}
+
+// imageLabel returns the value of the label image, which is empty, i.e. absent, unless detailed image metrics are
+// enabled.
+func imageLabel(image string) string {
+	if detailedImages.Load() {
+		return image
+	}
+
+	return ""
+}
+
+type expiringSample struct {
+	labelValues []string
+	value       float64
+	updatedAt   time.Time
+}
+
+// expiringGaugeVec is a gauge vector whose series are dropped once they are not updated within the TTL. Expired
+// series are dropped when they are collected or updated, so that no goroutines are left behind per series.
+type expiringGaugeVec struct {
+	desc *prometheus.Desc
+
+	guard   sync.Mutex
+	samples map[string]*expiringSample
+}
+
+func newExpiringGaugeVec(name, help string, labels []string) *expiringGaugeVec {
+	return &expiringGaugeVec{
+		desc:    prometheus.NewDesc(prometheus.BuildFQName("", "warm_metal", name), help, labels, nil),
+		samples: make(map[string]*expiringSample),
+	}
+}
+
+// Set sets the series of the label values to value, and resets its TTL.
+func (g *expiringGaugeVec) Set(value float64, labelValues ...string) {
+	g.guard.Lock()
+	defer g.guard.Unlock()
+	now := time.Now()
+	g.expire(now)
+	g.samples[strings.Join(labelValues, "\x00")] = &expiringSample{
+		labelValues: labelValues,
+		value:       value,
+		updatedAt:   now,
+	}
+}
+
+// expire drops expired series. g.guard must be held.
+func (g *expiringGaugeVec) expire(now time.Time) {
+	ttl := time.Duration(seriesTTL.Load())
+	for key, sample := range g.samples {
+		if now.Sub(sample.updatedAt) >= ttl {
+			delete(g.samples, key)
+		}
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (g *expiringGaugeVec) Describe(ch chan<- *prometheus.Desc) {
+	ch <- g.desc
+}
+
+// Collect implements prometheus.Collector.
+func (g *expiringGaugeVec) Collect(ch chan<- prometheus.Metric) {
+	g.guard.Lock()
+	defer g.guard.Unlock()
+	g.expire(time.Now())
+	for _, sample := range g.samples {
+		ch <- prometheus.MustNewConstMetric(g.desc, prometheus.GaugeValue, sample.value, sample.labelValues...)
+	}
+}
@@ -0,0 +1,15 @@
+package metrics
+
+import (
+	grpcprom "github.com/grpc-ecosystem/go-grpc-middleware/providers/prometheus"
+)
+
+// GRPCServer exports RPCs of the CSI server as grpc_server_started_total, grpc_server_handled_total, and
+// grpc_server_handling_seconds by the Prometheus provider of go-grpc-middleware, so that dashboards and alerts of
+// gRPC servers work for the driver as well. Its interceptor runs first, so that RPCs rejected by request limits are
+// counted too. Call InitializeMetrics once services are registered to export series of all methods from the start.
+var GRPCServer = grpcprom.NewServerMetrics(
+	grpcprom.WithServerHandlingTimeHistogram(
+		grpcprom.WithHistogramBuckets([]float64{0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 120, 300}),
+	),
+)
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCServer(t *testing.T) {
+	server := grpc.NewServer()
+	csi.RegisterIdentityServer(server, &csi.UnimplementedIdentityServer{})
+	GRPCServer.InitializeMetrics(server)
+
+	intercept := GRPCServer.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Identity/Probe"}
+	_, err := intercept(context.Background(), &csi.ProbeRequest{}, info, func(context.Context, any) (any, error) {
+		return nil, status.Error(codes.FailedPrecondition, "unhealthy")
+	})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	families, err := RegisterMetrics().Gather()
+	assert.NoError(t, err)
+	counts := map[string]float64{}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			key := family.GetName()
+			for _, label := range m.GetLabel() {
+				key += "/" + label.GetValue()
+			}
+			counts[key] = m.GetCounter().GetValue()
+		}
+	}
+
+	assert.Equal(t, 1.0, counts["grpc_server_started_total/Probe/csi.v1.Identity/unary"])
+	assert.Equal(t, 1.0, counts["grpc_server_handled_total/FailedPrecondition/Probe/csi.v1.Identity/unary"])
+	assert.Contains(t, counts, "grpc_server_handled_total/OK/GetPluginInfo/csi.v1.Identity/unary")
+}
@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const InFlightOperationsKey = "inflight_operations"
+const InFlightOperationOldestKey = "inflight_operation_oldest_seconds"
+
+// Operations tracked in flight.
+const (
+	OperationPublish   = "publish"
+	OperationUnpublish = "unpublish"
+	OperationStage     = "stage"
+	OperationUnstage   = "unstage"
+	OperationPull      = "pull"
+)
+
+// InFlightOperations exports the number of operations in progress and the age of the oldest one by operation type,
+// so that stuck operations are visible before requests of kubelet time out and pile up. Ages are computed when
+// metrics are scraped.
+var InFlightOperations = &inFlightOperations{
+	started: map[string]map[uint64]inFlightOperation{
+		OperationPublish:   {},
+		OperationUnpublish: {},
+		OperationStage:     {},
+		OperationUnstage:   {},
+		OperationPull:      {},
+	},
+	countDesc: prometheus.NewDesc(prometheus.BuildFQName("", "warm_metal", InFlightOperationsKey),
+		"The number of operations (publish,unpublish,stage,unstage,pull) in progress", []string{"operation_type"}, nil),
+	oldestDesc: prometheus.NewDesc(prometheus.BuildFQName("", "warm_metal", InFlightOperationOldestKey),
+		"Seconds the oldest operation (publish,unpublish,stage,unstage,pull) in progress has been running, or 0 if none",
+		[]string{"operation_type"}, nil),
+}
+
+type inFlightOperations struct {
+	guard   sync.Mutex
+	next    uint64
+	started map[string]map[uint64]inFlightOperation
+
+	countDesc  *prometheus.Desc
+	oldestDesc *prometheus.Desc
+}
+
+type inFlightOperation struct {
+	started time.Time
+	// timeout is the time between the start and the deadline of the operation, or 0 if it has no deadline.
+	timeout time.Duration
+}
+
+// OverdueOperation is an operation running longer than a multiple of its timeout.
+type OverdueOperation struct {
+	Operation string
+	Age       time.Duration
+	Timeout   time.Duration
+}
+
+// Start records an operation of the type in progress until the returned function is called. The deadline of ctx, if
+// any, is the timeout of the operation.
+func (o *inFlightOperations) Start(ctx context.Context, op string) (done func()) {
+	o.guard.Lock()
+	defer o.guard.Unlock()
+	id := o.next
+	o.next++
+	if o.started[op] == nil {
+		o.started[op] = make(map[uint64]inFlightOperation)
+	}
+
+	now := time.Now()
+	current := inFlightOperation{started: now}
+	if deadline, ok := ctx.Deadline(); ok {
+		current.timeout = deadline.Sub(now)
+	}
+	o.started[op][id] = current
+
+	return func() {
+		o.guard.Lock()
+		defer o.guard.Unlock()
+		delete(o.started[op], id)
+	}
+}
+
+// Overdue returns operations running longer than factor times their timeouts, or defaultTimeout if they have no
+// deadline. They are expected to have failed by their deadlines, so that they are likely leaked or deadlocked.
+func (o *inFlightOperations) Overdue(factor float64, defaultTimeout time.Duration) []OverdueOperation {
+	o.guard.Lock()
+	defer o.guard.Unlock()
+	now := time.Now()
+	var overdue []OverdueOperation
+	for op, started := range o.started {
+		for _, current := range started {
+			timeout := current.timeout
+			if timeout <= 0 {
+				timeout = defaultTimeout
+			}
+
+			age := now.Sub(current.started)
+			if age > time.Duration(factor*float64(timeout)) {
+				overdue = append(overdue, OverdueOperation{Operation: op, Age: age, Timeout: timeout})
+			}
+		}
+	}
+
+	return overdue
+}
+
+// Describe implements prometheus.Collector.
+func (o *inFlightOperations) Describe(ch chan<- *prometheus.Desc) {
+	ch <- o.countDesc
+	ch <- o.oldestDesc
+}
+
+// Collect implements prometheus.Collector.
+func (o *inFlightOperations) Collect(ch chan<- prometheus.Metric) {
+	o.guard.Lock()
+	defer o.guard.Unlock()
+	now := time.Now()
+	for op, started := range o.started {
+		var oldest time.Duration
+		for _, current := range started {
+			oldest = max(oldest, now.Sub(current.started))
+		}
+
+		ch <- prometheus.MustNewConstMetric(o.countDesc, prometheus.GaugeValue, float64(len(started)), op)
+		ch <- prometheus.MustNewConstMetric(o.oldestDesc, prometheus.GaugeValue, oldest.Seconds(), op)
+	}
+}
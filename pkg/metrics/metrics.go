@@ -1,18 +1,77 @@
 package metrics
 
 import (
-	"fmt"
-	"net/http"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"k8s.io/klog/v2"
 )
 
 const ImagePullTimeKey = "pull_duration_seconds"
 const ImagePullTimeHistKey = "pull_duration_seconds_hist"
 const ImagePullSizeKey = "pull_size_bytes"
 const OperationErrorsCountKey = "operation_errors_total"
+const ReconciledMountsCountKey = "reconciled_mounts_total"
+const BlockImageCacheCountKey = "block_image_cache_total"
+const RuntimeInfoKey = "runtime_info"
+const BuildInfoKey = "build_info"
+const PullBytesCountKey = "pull_bytes_total"
+const PullLayersCountKey = "pull_layers_total"
+const LastPullAverageThroughputKey = "last_pull_average_throughput_bytes_per_second"
+const CredentialLookupTimeHistKey = "credential_lookup_duration_seconds"
+const MountStageTimeHistKey = "mount_stage_duration_seconds"
+const MountStageErrorsCountKey = "mount_stage_errors_total"
+const CachedImagesKey = "cached_images"
+const PullsCountKey = "pulls_total"
+const PullAttemptsCountKey = "pull_attempts_total"
+const CachedImageBytesKey = "cached_image_bytes"
+const CredentialCacheCountKey = "credential_cache_total"
+const RequestQueueLengthKey = "request_queue_length"
+const RequestQueueWaitTimeHistKey = "request_queue_wait_seconds"
+const RequestQueueRejectionsCountKey = "request_queue_rejections_total"
+const GCReclaimedBytesCountKey = "gc_reclaimed_bytes_total"
+const GCRemovedImagesCountKey = "gc_removed_images_total"
+const GCTimeHistKey = "gc_duration_seconds"
+const GCLastSuccessKey = "gc_last_success_timestamp_seconds"
+const VolumeDiskUsageKey = "volume_disk_usage_bytes"
+
+// Results of lookups of cached credentials. Lookups finding expired credentials are counted as expired rather than
+// misses.
+const (
+	CredentialCacheHit     = "hit"
+	CredentialCacheMiss    = "miss"
+	CredentialCacheExpired = "expired"
+)
+
+// Reasons requests waiting for concurrency limits are rejected for, i.e. the queue is full, or the request expires
+// in the queue.
+const (
+	RequestQueueFull    = "full"
+	RequestQueueExpired = "expired"
+)
+
+// Stages of mounts and unmounts of volumes which are timed.
+const (
+	MountStagePrepare = "prepare"
+	MountStageMount   = "mount"
+	MountStageBind    = "bind"
+	MountStageUnmount = "unmount"
+	MountStageCleanup = "cleanup"
+)
+
+// States of images cached on the node.
+const (
+	CachedImageInUse  = "in-use"
+	CachedImageUnused = "unused"
+)
+
+// Sources of credentials whose lookups are timed.
+const (
+	CredentialSourceSecret = "secret"
+	CredentialSourceECR    = "ecr"
+	CredentialSourceGCR    = "gcr"
+	CredentialSourceACR    = "acr"
+	CredentialSourcePlugin = "plugin"
+)
 
 var ImagePullTimeHist = prometheus.NewHistogramVec(
 	prometheus.HistogramOpts{
@@ -23,22 +82,185 @@ var ImagePullTimeHist = prometheus.NewHistogramVec(
 	},
 	[]string{"error"},
 )
-var ImagePullTime = prometheus.NewGaugeVec(
+
+// ImagePullTime is the duration of the latest pull of each registry, or of each image if detailed image metrics are
+// enabled. Series expire once no pulls update them within the TTL set via ConfigureImageMetrics.
+var ImagePullTime = newExpiringGaugeVec(
+	ImagePullTimeKey,
+	"The time it took to pull an image",
+	[]string{"registry", "image", "error"},
+)
+
+// ImagePullSizeBytes is the size of the latest image pulled from each registry, or of each image if detailed image
+// metrics are enabled. Series expire as those of ImagePullTime.
+var ImagePullSizeBytes = newExpiringGaugeVec(
+	ImagePullSizeKey,
+	"Size (in bytes) of pulled image",
+	[]string{"registry", "image"},
+)
+
+var PullBytesCount = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Subsystem: "warm_metal",
+		Name:      PullBytesCountKey,
+		Help:      "Cumulative bytes of images fetched from each registry, including layers shared with local images",
+	},
+	[]string{"registry"},
+)
+
+var PullLayersCount = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Subsystem: "warm_metal",
+		Name:      PullLayersCountKey,
+		Help:      "Cumulative number of layers of images fetched from each registry",
+	},
+	[]string{"registry"},
+)
+
+// LastPullAverageThroughput is the average throughput of the last completed pull fetching an image from each
+// registry. It is set once pulls end, as CRI doesn't report progress of pulls, so it doesn't reflect pulls in
+// progress. Series expire as those of ImagePullTime, so that registries are only reported while images are being
+// pulled from them.
+var LastPullAverageThroughput = newExpiringGaugeVec(
+	LastPullAverageThroughputKey,
+	"Average bytes per second of the last completed pull fetching an image from each registry",
+	[]string{"registry"},
+)
+
+var PullsCount = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Subsystem: "warm_metal",
+		Name:      PullsCountKey,
+		Help:      "Cumulative number of pulls of images from each registry by result (success,failure)",
+	},
+	[]string{"registry", "result"},
+)
+
+var PullAttemptsCount = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Subsystem: "warm_metal",
+		Name:      PullAttemptsCountKey,
+		Help:      "Cumulative number of pull requests sent to the runtime for each registry, one per credential tried",
+	},
+	[]string{"registry"},
+)
+
+var CachedImages = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Subsystem: "warm_metal",
+		Name:      CachedImagesKey,
+		Help:      "The number of images pulled by the driver present on the node by state (in-use,unused)",
+	},
+	[]string{"state"},
+)
+
+var CachedImageBytes = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Subsystem: "warm_metal",
+		Name:      CachedImageBytesKey,
+		Help:      "Total bytes of images pulled by the driver present on the node, compressed and unpacked",
+	},
+	[]string{"size"},
+)
+
+var CredentialLookupTimeHist = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Subsystem: "warm_metal",
+		Name:      CredentialLookupTimeHistKey,
+		Help:      "The time it took to fetch or look up credentials by source (secret,ecr,gcr,acr,plugin)",
+		Buckets:   []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 2, 5, 10, 30},
+	},
+	[]string{"source", "error"},
+)
+
+var CredentialCacheCount = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Subsystem: "warm_metal",
+		Name:      CredentialCacheCountKey,
+		Help:      "Cumulative number of lookups of cached credentials of plugins by source and result (hit,miss,expired)",
+	},
+	[]string{"source", "result"},
+)
+
+var RequestQueueLength = prometheus.NewGaugeVec(
 	prometheus.GaugeOpts{
 		Subsystem: "warm_metal",
-		Name:      ImagePullTimeKey,
-		Help:      "The time it took to mount an image",
+		Name:      RequestQueueLengthKey,
+		Help:      "The number of requests of each CSI method waiting for --max-concurrent-requests",
+	},
+	[]string{"method"},
+)
+
+var RequestQueueWaitTimeHist = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Subsystem: "warm_metal",
+		Name:      RequestQueueWaitTimeHistKey,
+		Help:      "The time requests of each CSI method waited for --max-concurrent-requests, including those expired",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 120, 300},
+	},
+	[]string{"method"},
+)
+
+var RequestQueueRejectionsCount = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Subsystem: "warm_metal",
+		Name:      RequestQueueRejectionsCountKey,
+		Help:      "Cumulative number of requests of each CSI method rejected while queued by reason (full,expired)",
+	},
+	[]string{"method", "reason"},
+)
+
+var GCReclaimedBytesCount = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Subsystem: "warm_metal",
+		Name:      GCReclaimedBytesCountKey,
+		Help:      "Cumulative compressed bytes of unused images removed by garbage collections",
+	},
+)
+
+var GCRemovedImagesCount = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Subsystem: "warm_metal",
+		Name:      GCRemovedImagesCountKey,
+		Help:      "Cumulative number of unused images removed by garbage collections",
+	},
+)
+
+var GCTimeHist = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Subsystem: "warm_metal",
+		Name:      GCTimeHistKey,
+		Help:      "The time it took to collect garbage",
+		Buckets:   []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600},
 	},
-	[]string{"image", "error"},
+	[]string{"error"},
 )
 
-var ImagePullSizeBytes = prometheus.NewGaugeVec(
+var GCLastSuccess = prometheus.NewGauge(
 	prometheus.GaugeOpts{
 		Subsystem: "warm_metal",
-		Name:      ImagePullSizeKey,
-		Help:      "Size (in bytes) of pulled image",
+		Name:      GCLastSuccessKey,
+		Help:      "Unix time of the latest garbage collection which succeeded",
 	},
-	[]string{"image"},
+)
+
+var MountStageTimeHist = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Subsystem: "warm_metal",
+		Name:      MountStageTimeHistKey,
+		Help:      "The time it took to run a stage (prepare,mount,bind,unmount,cleanup) of mounting or unmounting a volume",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 120, 300},
+	},
+	[]string{"stage", "error"},
+)
+
+var MountStageErrorsCount = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Subsystem: "warm_metal",
+		Name:      MountStageErrorsCountKey,
+		Help:      "Cumulative number of failed stages (prepare,mount,bind,unmount,cleanup) of mounts and unmounts",
+	},
+	[]string{"stage"},
 )
 
 var OperationErrorsCount = prometheus.NewCounterVec(
@@ -50,24 +272,189 @@ var OperationErrorsCount = prometheus.NewCounterVec(
 	[]string{"operation_type"},
 )
 
+var ReconciledMountsCount = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Subsystem: "warm_metal",
+		Name:      ReconciledMountsCountKey,
+		Help:      "Cumulative number of mounts found on startup by the action taken (adopted,unmounted,rolled-back,failed)",
+	},
+	[]string{"action"},
+)
+
+var BlockImageCacheCount = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Subsystem: "warm_metal",
+		Name:      BlockImageCacheCountKey,
+		Help:      "Cumulative number of lookups and evictions of images of block volumes by result (hit,miss,evicted)",
+	},
+	[]string{"result"},
+)
+
+var RuntimeInfo = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Subsystem: "warm_metal",
+		Name:      RuntimeInfoKey,
+		Help:      "The container runtime the node plugin works with, and whether it is detected automatically",
+	},
+	[]string{"runtime", "address", "detected"},
+)
+
+// Layers of snapshot chains of volumes in VolumeDiskUsage.
+const (
+	VolumeLayerWritable = "writable"
+	VolumeLayerImage    = "image"
+)
+
+// VolumeDiskUsage is the disk usage of snapshot chains of volumes, set when kubelet collects stats of volumes and
+// deleted once they are unpublished.
+var VolumeDiskUsage = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Subsystem: "warm_metal",
+		Name:      VolumeDiskUsageKey,
+		Help:      "Bytes of node disk used by the writable layer of each volume, and by the image layers it shares",
+	},
+	[]string{"volume_id", "layer"},
+)
+
+var BuildInfo = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Subsystem: "warm_metal",
+		Name:      BuildInfoKey,
+		Help:      "The version and git commit of the driver, the CRI API version it speaks, and its enabled features",
+	},
+	[]string{"version", "git_commit", "cri_api_version", "features"},
+)
+
+// ObserveImagePull records a pull of the image from the registry which took elapsed seconds, in the trace traceID if
+// it is not empty.
+func ObserveImagePull(registry, image string, failed bool, elapsed float64, traceID string) {
+	observeWithTraceID(ImagePullTimeHist.WithLabelValues(BoolToString(failed)), elapsed, traceID)
+	ImagePullTime.Set(elapsed, registry, imageLabel(image), BoolToString(failed))
+	result := "success"
+	if failed {
+		result = "failure"
+	}
+	PullsCount.WithLabelValues(registry, result).Inc()
+}
+
+// traceIDLabel is the label of exemplars linking observations to their traces.
+const traceIDLabel = "trace_id"
+
+// observeWithTraceID observes v with the exemplar of the trace traceID if it is not empty, so that outliers on
+// dashboards link to the traces of the slow operations. Exemplars are only exported in the OpenMetrics format.
+func observeWithTraceID(observer prometheus.Observer, v float64, traceID string) {
+	if exemplarObserver, ok := observer.(prometheus.ExemplarObserver); ok && traceID != "" {
+		exemplarObserver.ObserveWithExemplar(v, prometheus.Labels{traceIDLabel: traceID})
+		return
+	}
+
+	observer.Observe(v)
+}
+
+// ObserveImageSize records the size of the image pulled from the registry.
+func ObserveImageSize(registry, image string, size int) {
+	ImagePullSizeBytes.Set(float64(size), registry, imageLabel(image))
+}
+
+// ObserveFetchedImage records an image of size bytes and layers fetched from the registry in elapsed seconds. layers
+// is 0 if the runtime doesn't report layers of images.
+func ObserveFetchedImage(registry string, size, layers int, elapsed float64) {
+	PullBytesCount.WithLabelValues(registry).Add(float64(size))
+	PullLayersCount.WithLabelValues(registry).Add(float64(layers))
+	if elapsed > 0 {
+		LastPullAverageThroughput.Set(float64(size)/elapsed, registry)
+	}
+}
+
+// ObserveGC records a garbage collection removing images of reclaimedBytes in elapsed seconds.
+func ObserveGC(removedImages int, reclaimedBytes uint64, elapsed float64, failed bool) {
+	GCRemovedImagesCount.Add(float64(removedImages))
+	GCReclaimedBytesCount.Add(float64(reclaimedBytes))
+	GCTimeHist.WithLabelValues(BoolToString(failed)).Observe(elapsed)
+	if !failed {
+		GCLastSuccess.SetToCurrentTime()
+	}
+}
+
+// ObserveCachedImages records the numbers of images in use and unused on the node.
+func ObserveCachedImages(inUse, unused int) {
+	CachedImages.WithLabelValues(CachedImageInUse).Set(float64(inUse))
+	CachedImages.WithLabelValues(CachedImageUnused).Set(float64(unused))
+}
+
+// ObserveCachedImageBytes records total compressed and unpacked bytes of images on the node.
+func ObserveCachedImageBytes(compressed, unpacked int64) {
+	CachedImageBytes.WithLabelValues("compressed").Set(float64(compressed))
+	CachedImageBytes.WithLabelValues("unpacked").Set(float64(unpacked))
+}
+
+// ObserveVolumeDiskUsage records the usage of the writable layer of the volume and of the image layers it shares.
+func ObserveVolumeDiskUsage(volumeId string, writable, image int64) {
+	VolumeDiskUsage.WithLabelValues(volumeId, VolumeLayerWritable).Set(float64(writable))
+	VolumeDiskUsage.WithLabelValues(volumeId, VolumeLayerImage).Set(float64(image))
+}
+
+// ForgetVolumeDiskUsage drops series of the volume once it is unpublished.
+func ForgetVolumeDiskUsage(volumeId string) {
+	VolumeDiskUsage.DeletePartialMatch(prometheus.Labels{"volume_id": volumeId})
+}
+
+// StartCredentialLookup times a lookup of credentials from the source until the returned function is called with
+// whether it failed.
+func StartCredentialLookup(source string) (done func(failed bool)) {
+	start := time.Now()
+	return func(failed bool) {
+		CredentialLookupTimeHist.WithLabelValues(source, BoolToString(failed)).Observe(time.Since(start).Seconds())
+	}
+}
+
+// StartMountStage times a stage of mounting or unmounting a volume until the returned function is called with whether
+// it failed.
+func StartMountStage(stage string) (done func(failed bool)) {
+	start := time.Now()
+	return func(failed bool) {
+		MountStageTimeHist.WithLabelValues(stage, BoolToString(failed)).Observe(time.Since(start).Seconds())
+		if failed {
+			MountStageErrorsCount.WithLabelValues(stage).Inc()
+		}
+	}
+}
+
 func RegisterMetrics() *prometheus.Registry {
 	reg := prometheus.NewRegistry()
 	reg.MustRegister(ImagePullTime)
 	reg.MustRegister(ImagePullTimeHist)
 	reg.MustRegister(ImagePullSizeBytes)
+	reg.MustRegister(PullBytesCount)
+	reg.MustRegister(PullLayersCount)
+	reg.MustRegister(LastPullAverageThroughput)
+	reg.MustRegister(PullsCount)
+	reg.MustRegister(PullAttemptsCount)
 	reg.MustRegister(OperationErrorsCount)
+	reg.MustRegister(ReconciledMountsCount)
+	reg.MustRegister(BlockImageCacheCount)
+	reg.MustRegister(RuntimeInfo)
+	reg.MustRegister(BuildInfo)
+	reg.MustRegister(VolumeDiskUsage)
+	reg.MustRegister(GRPCServer)
+	reg.MustRegister(CredentialLookupTimeHist)
+	reg.MustRegister(CredentialCacheCount)
+	reg.MustRegister(RequestQueueLength)
+	reg.MustRegister(RequestQueueWaitTimeHist)
+	reg.MustRegister(RequestQueueRejectionsCount)
+	reg.MustRegister(GCReclaimedBytesCount)
+	reg.MustRegister(GCRemovedImagesCount)
+	reg.MustRegister(GCTimeHist)
+	reg.MustRegister(GCLastSuccess)
+	reg.MustRegister(MountStageTimeHist)
+	reg.MustRegister(MountStageErrorsCount)
+	reg.MustRegister(CachedImages)
+	reg.MustRegister(CachedImageBytes)
+	reg.MustRegister(InFlightOperations)
 
 	return reg
 }
 
-func StartMetricsServer(reg *prometheus.Registry, port int) {
-	go func() {
-		http.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg}))
-		klog.Infof("serving internal metrics at port %d", port)
-		klog.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
-	}()
-}
-
 func BoolToString(t bool) string {
 	if t {
 		return "true"
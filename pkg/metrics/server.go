@@ -0,0 +1,171 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/klog/v2"
+)
+
+// ServerOptions protect /metrics. Metrics are served in plaintext to all clients if they are empty. Other handlers,
+// e.g. health checks, are never authenticated, since kubelet probes can't present credentials.
+type ServerOptions struct {
+	// CertDir is the directory of the certificate tls.crt and the key tls.key the port is served with over TLS. The
+	// certificate is reloaded once it is renewed, e.g. by cert-manager.
+	CertDir string
+	// ClientCAFile is the CA bundle client certificates of scrapes must be signed by. It requires CertDir.
+	ClientCAFile string
+	// BearerTokenFile is the file of the bearer token scrapes must present. It is read on every scrape, so that the
+	// token can be rotated.
+	BearerTokenFile string
+}
+
+// NewMetricsServer returns the server of metrics of reg at /metrics on the port, along with handlers keyed by their
+// patterns, e.g. health checks. It is served over TLS if TLSConfig of the server is set.
+func NewMetricsServer(
+	reg *prometheus.Registry, port int, handlers map[string]http.Handler, opts ServerOptions,
+) (*http.Server, error) {
+	if opts.ClientCAFile != "" && opts.CertDir == "" {
+		return nil, fmt.Errorf("client certificates of metrics require a server certificate")
+	}
+
+	// Metrics are served by their own mux, so that handlers registered to the default mux, e.g. by
+	// net/http/pprof, aren't exposed on the metrics port.
+	mux := http.NewServeMux()
+	var metricsHandler http.Handler = promhttp.HandlerFor(reg,
+		promhttp.HandlerOpts{Registry: reg, EnableOpenMetrics: true})
+	if opts.BearerTokenFile != "" {
+		metricsHandler = requireBearerToken(opts.BearerTokenFile, metricsHandler)
+	}
+
+	server := &http.Server{Addr: fmt.Sprintf(":%d", port), ReadHeaderTimeout: 10 * time.Second}
+	if opts.CertDir != "" {
+		cert := &reloadingCertificate{certDir: opts.CertDir}
+		if _, err := cert.get(nil); err != nil {
+			return nil, err
+		}
+
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: cert.get}
+	}
+
+	if opts.ClientCAFile != "" {
+		pem, err := os.ReadFile(opts.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the client CA of metrics: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in the client CA of metrics %q", opts.ClientCAFile)
+		}
+
+		// Client certificates are only required by /metrics, so that kubelet can still probe health checks.
+		server.TLSConfig.ClientCAs = pool
+		server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		metricsHandler = requireClientCert(metricsHandler)
+	}
+
+	mux.Handle("/metrics", metricsHandler)
+	for pattern, handler := range handlers {
+		mux.Handle(pattern, handler)
+	}
+
+	server.Handler = mux
+	return server, nil
+}
+
+// StartMetricsServer serves metrics of reg at /metrics on the port, along with handlers keyed by their patterns,
+// e.g. health checks.
+func StartMetricsServer(reg *prometheus.Registry, port int, handlers map[string]http.Handler, opts ServerOptions) {
+	server, err := NewMetricsServer(reg, port, handlers, opts)
+	if err != nil {
+		klog.Fatalf("unable to serve metrics: %s", err)
+	}
+
+	go func() {
+		if server.TLSConfig == nil {
+			klog.Infof("serving internal metrics at port %d", port)
+			klog.Fatal(server.ListenAndServe())
+		}
+
+		klog.Infof("serving internal metrics at port %d over TLS", port)
+		klog.Fatal(server.ListenAndServeTLS("", ""))
+	}()
+}
+
+// requireBearerToken rejects requests without the bearer token in tokenFile.
+func requireBearerToken(tokenFile string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := os.ReadFile(tokenFile)
+		if err != nil {
+			klog.Errorf("unable to read the bearer token of metrics: %s", err)
+			http.Error(w, "unable to authenticate", http.StatusInternalServerError)
+			return
+		}
+
+		expected := strings.TrimSpace(string(token))
+		presented, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || expected == "" || subtle.ConstantTimeCompare([]byte(presented), []byte(expected)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireClientCert rejects requests without verified client certificates.
+func requireClientCert(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			http.Error(w, "client certificate required", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// reloadingCertificate loads the certificate tls.crt and the key tls.key in certDir, again once the certificate
+// changes.
+type reloadingCertificate struct {
+	certDir string
+
+	guard   sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func (c *reloadingCertificate) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certFile := filepath.Join(c.certDir, "tls.crt")
+	fi, err := os.Stat(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to stat the metrics certificate: %w", err)
+	}
+
+	c.guard.Lock()
+	defer c.guard.Unlock()
+	if c.cert != nil && fi.ModTime().Equal(c.modTime) {
+		return c.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, filepath.Join(c.certDir, "tls.key"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the metrics certificate: %w", err)
+	}
+
+	klog.Infof("loaded the metrics certificate from %s", c.certDir)
+	c.cert, c.modTime = &cert, fi.ModTime()
+	return c.cert, nil
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsServerAuth(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("secret\n"), 0o600))
+	healthz := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	server, err := NewMetricsServer(RegisterMetrics(), 0, map[string]http.Handler{"/healthz": healthz},
+		ServerOptions{BearerTokenFile: tokenFile})
+	assert.NoError(t, err)
+	assert.Nil(t, server.TLSConfig)
+
+	get := func(path, token string) int {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		server.Handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, get("/metrics", ""))
+	assert.Equal(t, http.StatusUnauthorized, get("/metrics", "wrong"))
+	assert.Equal(t, http.StatusOK, get("/metrics", "secret"))
+	assert.Equal(t, http.StatusOK, get("/healthz", ""))
+
+	_, err = NewMetricsServer(RegisterMetrics(), 0, nil,
+		ServerOptions{ClientCAFile: tokenFile})
+	assert.Error(t, err)
+}
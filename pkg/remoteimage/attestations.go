@@ -0,0 +1,190 @@
+package remoteimage
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/distribution/reference"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/warm-metal/container-image-csi-driver/pkg/secret"
+	"k8s.io/klog/v2"
+)
+
+// Kinds of attestations which can be required of images. Other requirements are matched against artifact types of
+// referrers and predicate types of in-toto statements as is.
+const (
+	AttestationSBOM           = "sbom"
+	AttestationSLSAProvenance = "slsa-provenance"
+)
+
+// Media types of layers of attestations.
+const (
+	inTotoMediaType       = "application/vnd.in-toto+json"
+	dsseMediaType         = "application/vnd.dsse.envelope.v1+json"
+	spdxMediaType         = "application/spdx+json"
+	cycloneDXMediaType    = "application/vnd.cyclonedx+json"
+	inTotoStatementPrefix = "https://in-toto.io/Statement/"
+	slsaProvenancePrefix  = "https://slsa.dev/provenance/"
+)
+
+// sbomPredicateTypes are predicate types of in-toto statements of SBOMs.
+var sbomPredicateTypes = []string{"https://spdx.dev/Document", "https://cyclonedx.org/bom"}
+
+// inTotoStatement is an in-toto statement, of which only subjects and the predicate type are checked.
+type inTotoStatement struct {
+	Type    string `json:"_type"`
+	Subject []struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	} `json:"subject"`
+	PredicateType string `json:"predicateType"`
+}
+
+// dsseEnvelope is a DSSE envelope. Its signatures aren't verified.
+type dsseEnvelope struct {
+	PayloadType string `json:"payloadType"`
+	Payload     string `json:"payload"`
+}
+
+// VerifyAttestations fails unless the image of digest subject has a referrer attesting each required kind,
+// artifact type, or predicate type. Referrers must refer to the subject, in-toto statements must name it among
+// their subjects, and SBOMs must be valid JSON. Signatures of attestations aren't verified.
+func VerifyAttestations(
+	ctx context.Context, image reference.Named, subject digest.Digest, keyring secret.DockerKeyring, required []string,
+) error {
+	if len(required) == 0 {
+		return nil
+	}
+
+	fetcher, referrers, err := referrersOf(ctx, image, subject, keyring)
+	if err != nil {
+		return err
+	}
+
+	missing := slices.Clone(required)
+	var errs []error
+	for _, desc := range referrers {
+		if desc.ArtifactType == NotationSignatureArtifactType {
+			continue
+		}
+
+		attested, err := attestationsOf(ctx, fetcher, desc, subject)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("referrer %s: %w", desc.Digest, err))
+			continue
+		}
+
+		missing = slices.DeleteFunc(missing, func(r string) bool { return slices.Contains(attested, r) })
+		if len(missing) == 0 {
+			klog.FromContext(ctx).V(2).Info("Verified attestations of image", "image", image, "digest", subject,
+				"attestations", required)
+			return nil
+		}
+	}
+
+	return errors.Join(append([]error{fmt.Errorf("image %q has no valid attestations of %s", image,
+		strings.Join(missing, ", "))}, errs...)...)
+}
+
+// attestationsOf returns what the referrer attests the subject, i.e. its artifact type, predicate types of its
+// in-toto statements, and kinds of them. It fails if the referrer or any of its attestations is invalid.
+func attestationsOf(
+	ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor, subject digest.Digest,
+) ([]string, error) {
+	data, err := fetchBlob(ctx, fetcher, desc)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest ocispec.Manifest
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	if manifest.Subject == nil || manifest.Subject.Digest != subject {
+		return nil, fmt.Errorf("the manifest doesn't refer to %s", subject)
+	}
+
+	attested := []string{desc.ArtifactType}
+	for _, layer := range manifest.Layers {
+		switch layer.MediaType {
+		case inTotoMediaType, dsseMediaType, spdxMediaType, cycloneDXMediaType:
+		default:
+			continue
+		}
+
+		blob, err := fetchBlob(ctx, fetcher, layer)
+		if err != nil {
+			return nil, err
+		}
+
+		switch layer.MediaType {
+		case spdxMediaType, cycloneDXMediaType:
+			if !json.Valid(blob) {
+				return nil, fmt.Errorf("invalid SBOM %s", layer.Digest)
+			}
+
+			attested = append(attested, layer.MediaType, AttestationSBOM)
+			continue
+		case dsseMediaType:
+			if blob, err = dssePayload(blob); err != nil {
+				return nil, fmt.Errorf("invalid DSSE envelope %s: %w", layer.Digest, err)
+			}
+		}
+
+		predicateType, err := verifyStatement(blob, subject)
+		if err != nil {
+			return nil, fmt.Errorf("invalid in-toto statement %s: %w", layer.Digest, err)
+		}
+
+		attested = append(attested, predicateType)
+		if strings.HasPrefix(predicateType, slsaProvenancePrefix) {
+			attested = append(attested, AttestationSLSAProvenance)
+		} else if slices.Contains(sbomPredicateTypes, predicateType) {
+			attested = append(attested, AttestationSBOM)
+		}
+	}
+
+	return attested, nil
+}
+
+// dssePayload returns the in-toto statement in the DSSE envelope.
+func dssePayload(data []byte) ([]byte, error) {
+	var envelope dsseEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, err
+	}
+
+	if envelope.PayloadType != inTotoMediaType {
+		return nil, fmt.Errorf("unsupported payload type %q", envelope.PayloadType)
+	}
+
+	return base64.StdEncoding.DecodeString(envelope.Payload)
+}
+
+// verifyStatement returns the predicate type of the in-toto statement, which must name the subject.
+func verifyStatement(data []byte, subject digest.Digest) (string, error) {
+	var statement inTotoStatement
+	if err := json.Unmarshal(data, &statement); err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(statement.Type, inTotoStatementPrefix) {
+		return "", fmt.Errorf("unknown statement type %q", statement.Type)
+	}
+
+	for _, s := range statement.Subject {
+		if s.Digest[subject.Algorithm().String()] == subject.Encoded() {
+			return statement.PredicateType, nil
+		}
+	}
+
+	return "", fmt.Errorf("the statement doesn't attest %s", subject)
+}
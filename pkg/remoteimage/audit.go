@@ -0,0 +1,86 @@
+package remoteimage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// SourceAnonymous is the credential source of pulls without credentials.
+const SourceAnonymous = "anonymous"
+
+// Results of pulls in audit records.
+const (
+	AuditResultSuccess = "success"
+	AuditResultFailure = "failure"
+)
+
+// PullRequester identifies what a pull is for, i.e. the volume and the pod it is published to if known.
+type PullRequester struct {
+	VolumeID     string `json:"volumeID,omitempty"`
+	PodNamespace string `json:"podNamespace,omitempty"`
+	PodName      string `json:"podName,omitempty"`
+	PodUID       string `json:"podUID,omitempty"`
+}
+
+// AuditRecord records a pull of an image from its registry. It never contains credentials, but only where they are
+// found.
+type AuditRecord struct {
+	Time     time.Time `json:"time"`
+	Image    string    `json:"image"`
+	Digest   string    `json:"digest,omitempty"`
+	Registry string    `json:"registry"`
+	// CredentialSource is the source of the credential the image is pulled with, or SourceAnonymous. It is the source
+	// of the last credential tried if the pull failed.
+	CredentialSource string `json:"credentialSource"`
+	PullRequester
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// AuditLog writes audit records of pulls as JSON lines.
+type AuditLog struct {
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+}
+
+// OpenAuditLog opens the audit log appending to the file at path, or writing to stdout if path is "-".
+func OpenAuditLog(path string) (*AuditLog, error) {
+	if path == "-" {
+		return NewAuditLog(os.Stdout), nil
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open audit log %q: %w", path, err)
+	}
+
+	return NewAuditLog(f), nil
+}
+
+// NewAuditLog returns the audit log writing to w.
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{w: w, enc: json.NewEncoder(w)}
+}
+
+// Record writes the record. Failures are returned but pulls are not failed by them.
+func (l *AuditLog) Record(record *AuditRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.enc.Encode(record)
+}
+
+// Close closes the file of the audit log.
+func (l *AuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if f, ok := l.w.(*os.File); ok && f != os.Stdout {
+		return f.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,784 @@
+package remoteimage
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"maps"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/distribution/reference"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/warm-metal/container-image-csi-driver/pkg/secret"
+	"k8s.io/klog/v2"
+)
+
+// Media types of Notary Project signatures. Only JWS envelopes are supported.
+const (
+	NotationSignatureArtifactType = "application/vnd.cncf.notary.signature"
+	notationJWSMediaType          = "application/jose+json"
+	notationPayloadType           = "application/vnd.cncf.notary.payload.v1+json"
+)
+
+// Signing schemes of Notary Project signatures, which tell the type of trust stores their certificates chain to.
+const (
+	notationSchemeX509             = "notary.x509"
+	notationSchemeSigningAuthority = "notary.x509.signingAuthority"
+)
+
+// Verification levels of notation trust policies.
+const (
+	NotationLevelStrict     = "strict"
+	NotationLevelPermissive = "permissive"
+	NotationLevelAudit      = "audit"
+	NotationLevelSkip       = "skip"
+)
+
+// NotationTrustPolicy is a trust policy of a notation trust policy document, which tells how images of its
+// registry scopes are verified.
+type NotationTrustPolicy struct {
+	Name string `json:"name"`
+	// RegistryScopes are repositories the policy applies to, e.g. registry.example.com/app, or "*" for all.
+	RegistryScopes        []string `json:"registryScopes"`
+	SignatureVerification struct {
+		Level string `json:"level"`
+	} `json:"signatureVerification"`
+	// TrustStores are named trust stores signing certificates must chain to, e.g. ca:acme.
+	TrustStores []string `json:"trustStores"`
+	// TrustedIdentities are subjects of signing certificates, e.g. "x509.subject: C=US, ST=WA, O=acme", or "*".
+	TrustedIdentities []string `json:"trustedIdentities"`
+
+	// subjects are attributes of distinguished names of TrustedIdentities
+	subjects []map[string]string
+	// anyIdentity is whether TrustedIdentities is "*"
+	anyIdentity bool
+}
+
+type notationTrustPolicyDocument struct {
+	Version       string                `json:"version"`
+	TrustPolicies []NotationTrustPolicy `json:"trustPolicies"`
+}
+
+// NotationVerifier verifies Notary Project signatures of images, which are referrers of images, by notation trust
+// policies and trust stores. Revocation of certificates isn't checked.
+type NotationVerifier struct {
+	policies []NotationTrustPolicy
+	// stores are certificates of trust stores keyed by <type>:<name>
+	stores map[string]*x509.CertPool
+	now    func() time.Time
+	// state is the digest of the policy document and certificates of trust stores, which keys verified images
+	state string
+
+	guard sync.Mutex
+	// verified records when images already verified expire by the state, their policies, names and digests, so
+	// that referrers aren't fetched on every publication.
+	verified map[string]time.Time
+}
+
+// notationVerifiedTTL bounds how long images are trusted without verifying their signatures again, so that
+// expiry of signatures and certificates applies to images in use.
+const notationVerifiedTTL = 10 * time.Minute
+
+// maxNotationVerified bounds the number of verified images the verifier remembers.
+const maxNotationVerified = 1024
+
+// NewNotationVerifier loads the trust policy document at policyFile, and trust stores in trustStoreDir, which are
+// laid out as notation does, i.e. x509/<type>/<name>/ holding PEM or DER certificates, where type is ca or
+// signingAuthority.
+func NewNotationVerifier(policyFile, trustStoreDir string) (*NotationVerifier, error) {
+	data, err := os.ReadFile(policyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the trust policy: %w", err)
+	}
+
+	var doc notationTrustPolicyDocument
+	if err = json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid trust policy %q: %w", policyFile, err)
+	}
+
+	if doc.Version != "1.0" {
+		return nil, fmt.Errorf("unsupported version %q of trust policy %q", doc.Version, policyFile)
+	}
+
+	state := sha256.New()
+	state.Write(data)
+	v := &NotationVerifier{stores: map[string]*x509.CertPool{}, now: time.Now, verified: map[string]time.Time{}}
+	for _, policy := range doc.TrustPolicies {
+		switch policy.SignatureVerification.Level {
+		case NotationLevelStrict, NotationLevelPermissive, NotationLevelAudit, NotationLevelSkip:
+		default:
+			return nil, fmt.Errorf("unknown verification level %q of trust policy %q", policy.SignatureVerification.Level,
+				policy.Name)
+		}
+
+		for _, store := range policy.TrustStores {
+			if _, found := v.stores[store]; found {
+				continue
+			}
+
+			if v.stores[store], err = loadTrustStore(trustStoreDir, store, state); err != nil {
+				return nil, err
+			}
+		}
+
+		if err = parseTrustedIdentities(&policy); err != nil {
+			return nil, fmt.Errorf("invalid trust policy %q: %w", policy.Name, err)
+		}
+
+		v.policies = append(v.policies, policy)
+	}
+
+	v.state = fmt.Sprintf("%x", state.Sum(nil))
+	return v, nil
+}
+
+// loadTrustStore loads certificates of the trust store <type>:<name> in dir, and writes them to state.
+func loadTrustStore(dir, store string, state hash.Hash) (*x509.CertPool, error) {
+	storeType, name, found := strings.Cut(store, ":")
+	if !found || (storeType != "ca" && storeType != "signingAuthority") || name == "" ||
+		name != filepath.Base(name) {
+		return nil, fmt.Errorf("invalid trust store %q", store)
+	}
+
+	storeDir := filepath.Join(dir, "x509", storeType, name)
+	entries, err := os.ReadDir(storeDir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read trust store %q: %w", store, err)
+	}
+
+	pool := x509.NewCertPool()
+	loaded := 0
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(storeDir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read certificate %q of trust store %q: %w", entry.Name(), store, err)
+		}
+
+		certs, err := parseCertificates(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate %q of trust store %q: %w", entry.Name(), store, err)
+		}
+
+		for _, cert := range certs {
+			pool.AddCert(cert)
+			state.Write(cert.Raw)
+			loaded++
+		}
+	}
+
+	if loaded == 0 {
+		return nil, fmt.Errorf("trust store %q has no certificates", store)
+	}
+
+	return pool, nil
+}
+
+// parseCertificates parses PEM certificates, or a DER certificate.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		certs = append(certs, cert)
+	}
+
+	if len(certs) > 0 {
+		return certs, nil
+	}
+
+	cert, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*x509.Certificate{cert}, nil
+}
+
+// policy returns the trust policy of the repository of the image, which is the one scoped to it, or the one scoped
+// to "*" otherwise.
+func (v *NotationVerifier) policy(image reference.Named) (*NotationTrustPolicy, error) {
+	var global *NotationTrustPolicy
+	for i := range v.policies {
+		policy := &v.policies[i]
+		if slices.Contains(policy.RegistryScopes, image.Name()) {
+			return policy, nil
+		}
+
+		if slices.Contains(policy.RegistryScopes, "*") {
+			global = policy
+		}
+	}
+
+	if global == nil {
+		return nil, fmt.Errorf("no trust policy applies to repository %q", image.Name())
+	}
+
+	return global, nil
+}
+
+// Enforces returns whether the trust policy of the repository of the image refuses images without verified
+// signatures, i.e. whether its level is strict or permissive.
+func (v *NotationVerifier) Enforces(image reference.Named) bool {
+	policy, err := v.policy(image)
+	if err != nil {
+		return false
+	}
+
+	level := policy.SignatureVerification.Level
+	return level == NotationLevelStrict || level == NotationLevelPermissive
+}
+
+// Verify fails if the image of digest subject has no signature verified by the trust policy of its repository.
+// Failures are only logged if the policy audits signatures.
+func (v *NotationVerifier) Verify(
+	ctx context.Context, image reference.Named, subject digest.Digest, keyring secret.DockerKeyring,
+) error {
+	policy, err := v.policy(image)
+	if err != nil {
+		return err
+	}
+
+	level := policy.SignatureVerification.Level
+	if level == NotationLevelSkip {
+		return nil
+	}
+
+	key := v.state + "/" + policy.Name + "/" + image.Name() + "@" + subject.String()
+	if v.isVerified(key) {
+		return nil
+	}
+
+	err = v.verifyReferrers(ctx, image, subject, keyring, policy)
+	if err != nil && level == NotationLevelAudit {
+		klog.FromContext(ctx).Info("Unverified signatures of image audited", "image", image, "digest", subject,
+			"policy", policy.Name, "err", err)
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("signature verification of image %q by trust policy %q failed: %w", image, policy.Name, err)
+	}
+
+	v.remember(key)
+	return nil
+}
+
+// isVerified returns whether the image of the key is verified and not expired yet.
+func (v *NotationVerifier) isVerified(key string) bool {
+	v.guard.Lock()
+	defer v.guard.Unlock()
+	expiry, found := v.verified[key]
+	if found && v.now().After(expiry) {
+		delete(v.verified, key)
+		return false
+	}
+
+	return found
+}
+
+// remember records the image of the key as verified. Expired images are forgotten once the verifier remembers
+// maxNotationVerified images, then the one expiring first.
+func (v *NotationVerifier) remember(key string) {
+	v.guard.Lock()
+	defer v.guard.Unlock()
+	now := v.now()
+	if len(v.verified) >= maxNotationVerified {
+		oldest := ""
+		for k, expiry := range v.verified {
+			if now.After(expiry) {
+				delete(v.verified, k)
+			} else if oldest == "" || expiry.Before(v.verified[oldest]) {
+				oldest = k
+			}
+		}
+
+		if len(v.verified) >= maxNotationVerified {
+			delete(v.verified, oldest)
+		}
+	}
+
+	v.verified[key] = now.Add(notationVerifiedTTL)
+}
+
+// verifyReferrers fetches signatures of the subject, and succeeds once one of them is verified.
+func (v *NotationVerifier) verifyReferrers(
+	ctx context.Context, image reference.Named, subject digest.Digest, keyring secret.DockerKeyring,
+	policy *NotationTrustPolicy,
+) error {
+	fetcher, referrers, err := referrersOf(ctx, image, subject, keyring)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, desc := range referrers {
+		if desc.ArtifactType != NotationSignatureArtifactType {
+			continue
+		}
+
+		envelope, err := fetchSignatureEnvelope(ctx, fetcher, desc)
+		if err == nil {
+			err = v.verifySignature(envelope, subject, policy)
+		}
+
+		if err == nil {
+			klog.FromContext(ctx).V(2).Info("Verified signature of image", "image", image, "digest", subject,
+				"signature", desc.Digest, "policy", policy.Name)
+			return nil
+		}
+
+		errs = append(errs, fmt.Errorf("signature %s: %w", desc.Digest, err))
+	}
+
+	if len(errs) == 0 {
+		return fmt.Errorf("no signatures found")
+	}
+
+	return errors.Join(errs...)
+}
+
+// fetchSignatureEnvelope returns the JWS envelope of the signature manifest.
+func fetchSignatureEnvelope(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor) ([]byte, error) {
+	data, err := fetchBlob(ctx, fetcher, desc)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest ocispec.Manifest
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	if len(manifest.Layers) != 1 {
+		return nil, fmt.Errorf("signature manifests must have a single layer, but it has %d", len(manifest.Layers))
+	}
+
+	if manifest.Layers[0].MediaType != notationJWSMediaType {
+		return nil, fmt.Errorf("unsupported signature envelope %q", manifest.Layers[0].MediaType)
+	}
+
+	return fetchBlob(ctx, fetcher, manifest.Layers[0])
+}
+
+type jwsEnvelope struct {
+	Payload   string `json:"payload"`
+	Protected string `json:"protected"`
+	Header    struct {
+		// X5c is the certificate chain of the signing certificate, beginning with it.
+		X5c [][]byte `json:"x5c"`
+	} `json:"header"`
+	Signature string `json:"signature"`
+}
+
+type jwsProtectedHeader struct {
+	Alg           string     `json:"alg"`
+	Cty           string     `json:"cty"`
+	Crit          []string   `json:"crit"`
+	SigningScheme string     `json:"io.cncf.notary.signingScheme"`
+	SigningTime   *time.Time `json:"io.cncf.notary.signingTime"`
+	Expiry        *time.Time `json:"io.cncf.notary.expiry"`
+}
+
+type notationPayload struct {
+	TargetArtifact ocispec.Descriptor `json:"targetArtifact"`
+}
+
+// Protected headers of Notary Project signatures which must be critical if they are present.
+const (
+	notationHeaderSigningScheme        = "io.cncf.notary.signingScheme"
+	notationHeaderExpiry               = "io.cncf.notary.expiry"
+	notationHeaderAuthenticSigningTime = "io.cncf.notary.authenticSigningTime"
+)
+
+// notationCriticalHeaders are protected headers the verifier understands if they are critical.
+var notationCriticalHeaders = []string{
+	notationHeaderSigningScheme, notationHeaderExpiry, notationHeaderAuthenticSigningTime,
+}
+
+// verifySignature verifies the JWS envelope of a signature of the subject by the policy. Expired signatures are
+// accepted if the policy is permissive.
+func (v *NotationVerifier) verifySignature(data []byte, subject digest.Digest, policy *NotationTrustPolicy) error {
+	var envelope jwsEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return fmt.Errorf("invalid envelope: %w", err)
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(envelope.Protected)
+	if err != nil {
+		return fmt.Errorf("invalid protected header: %w", err)
+	}
+
+	var header jwsProtectedHeader
+	if err = json.Unmarshal(headerJSON, &header); err != nil {
+		return fmt.Errorf("invalid protected header: %w", err)
+	}
+
+	var present map[string]json.RawMessage
+	if err = json.Unmarshal(headerJSON, &present); err != nil {
+		return fmt.Errorf("invalid protected header: %w", err)
+	}
+
+	if header.Cty != notationPayloadType {
+		return fmt.Errorf("unsupported payload %q", header.Cty)
+	}
+
+	for _, crit := range header.Crit {
+		if !slices.Contains(notationCriticalHeaders, crit) {
+			return fmt.Errorf("unsupported critical header %q", crit)
+		}
+	}
+
+	// The signing scheme must be critical, and so are the expiry and the authentic signing time if they are set.
+	for _, name := range notationCriticalHeaders {
+		if _, found := present[name]; (found || name == notationHeaderSigningScheme) &&
+			!slices.Contains(header.Crit, name) {
+			return fmt.Errorf("protected header %q must be critical", name)
+		}
+	}
+
+	if len(envelope.Header.X5c) == 0 {
+		return fmt.Errorf("no certificate chain")
+	}
+
+	chain := make([]*x509.Certificate, 0, len(envelope.Header.X5c))
+	for _, der := range envelope.Header.X5c {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("invalid certificate chain: %w", err)
+		}
+
+		chain = append(chain, cert)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(envelope.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+
+	// Integrity: the signature is made by the signing certificate.
+	if err = verifyJWS(header.Alg, chain[0].PublicKey, envelope.Protected+"."+envelope.Payload, signature); err != nil {
+		return err
+	}
+
+	// Authenticity: the signing certificate chains to a trust store of the policy, and is a trusted identity.
+	if err = v.verifyChain(chain, header, policy); err != nil {
+		return err
+	}
+
+	if !trustedIdentity(chain[0], policy) {
+		return fmt.Errorf("signing certificate %q is not a trusted identity", chain[0].Subject)
+	}
+
+	payloadJSON, err := base64.RawURLEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return fmt.Errorf("invalid payload: %w", err)
+	}
+
+	var payload notationPayload
+	if err = json.Unmarshal(payloadJSON, &payload); err != nil {
+		return fmt.Errorf("invalid payload: %w", err)
+	}
+
+	if payload.TargetArtifact.Digest != subject {
+		return fmt.Errorf("signature is of %s rather than %s", payload.TargetArtifact.Digest, subject)
+	}
+
+	if header.Expiry != nil && v.now().After(*header.Expiry) {
+		if policy.SignatureVerification.Level != NotationLevelPermissive {
+			return fmt.Errorf("signature expired at %s", header.Expiry)
+		}
+
+		klog.Infof("signature of %s expired at %s, which trust policy %q permits", subject, header.Expiry,
+			policy.Name)
+	}
+
+	return nil
+}
+
+// verifyChain verifies that the chain leads to a trust store of the policy of the type of the signing scheme, at
+// the signing time for notary.x509 signatures and now for signing authorities.
+func (v *NotationVerifier) verifyChain(
+	chain []*x509.Certificate, header jwsProtectedHeader, policy *NotationTrustPolicy,
+) error {
+	var storeType string
+	verifyTime := v.now()
+	switch header.SigningScheme {
+	case notationSchemeX509:
+		storeType = "ca"
+		if header.SigningTime == nil {
+			return fmt.Errorf("signing time is missing")
+		}
+		verifyTime = *header.SigningTime
+	case notationSchemeSigningAuthority:
+		storeType = "signingAuthority"
+	default:
+		return fmt.Errorf("unsupported signing scheme %q", header.SigningScheme)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	var errs []error
+	for _, store := range policy.TrustStores {
+		if !strings.HasPrefix(store, storeType+":") {
+			continue
+		}
+
+		_, err := chain[0].Verify(x509.VerifyOptions{
+			Roots:         v.stores[store],
+			Intermediates: intermediates,
+			CurrentTime:   verifyTime,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		})
+		if err == nil {
+			return nil
+		}
+
+		errs = append(errs, fmt.Errorf("trust store %q: %w", store, err))
+	}
+
+	if len(errs) == 0 {
+		return fmt.Errorf("no %s trust stores in trust policy %q for signing scheme %q", storeType, policy.Name,
+			header.SigningScheme)
+	}
+
+	return errors.Join(errs...)
+}
+
+// verifyJWS verifies the JWS signature of the signing input by the public key with the algorithm alg.
+func verifyJWS(alg string, publicKey crypto.PublicKey, signingInput string, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "PS256", "ES256":
+		hash = crypto.SHA256
+	case "PS384", "ES384":
+		hash = crypto.SHA384
+	case "PS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signature algorithm %q", alg)
+	}
+
+	h := hash.New()
+	h.Write([]byte(signingInput))
+	hashed := h.Sum(nil)
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "PS") {
+			return fmt.Errorf("algorithm %q doesn't match the RSA key", alg)
+		}
+
+		if err := rsa.VerifyPSS(key, hash, hashed, signature,
+			&rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}); err != nil {
+			return fmt.Errorf("invalid signature: %w", err)
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(alg, "ES") || len(signature) != 2*size {
+			return fmt.Errorf("invalid %s signature of the ECDSA key", alg)
+		}
+
+		r, s := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, hashed, r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported public key %T", publicKey)
+	}
+
+	return nil
+}
+
+// distinguishedNameAttributes are attributes of distinguished names of trusted identities by their OIDs.
+var distinguishedNameAttributes = map[string]string{
+	"2.5.4.3":  "CN",
+	"2.5.4.6":  "C",
+	"2.5.4.7":  "L",
+	"2.5.4.8":  "ST",
+	"2.5.4.10": "O",
+	"2.5.4.11": "OU",
+}
+
+// parseTrustedIdentities parses trusted identities of the policy, which are "x509.subject: <distinguished name>", or
+// "*" alone. Distinguished names must have attributes C, ST, and O.
+func parseTrustedIdentities(policy *NotationTrustPolicy) error {
+	for _, identity := range policy.TrustedIdentities {
+		if identity == "*" {
+			if len(policy.TrustedIdentities) > 1 {
+				return fmt.Errorf("trusted identity \"*\" must not be used with others")
+			}
+
+			policy.anyIdentity = true
+			return nil
+		}
+
+		dn, found := strings.CutPrefix(identity, "x509.subject:")
+		if !found {
+			return fmt.Errorf("unsupported trusted identity %q", identity)
+		}
+
+		subject, err := parseDistinguishedName(dn)
+		if err != nil {
+			return fmt.Errorf("invalid trusted identity %q: %w", identity, err)
+		}
+
+		for _, name := range []string{"C", "ST", "O"} {
+			if _, found := subject[name]; !found {
+				return fmt.Errorf("trusted identity %q doesn't have attribute %s", identity, name)
+			}
+		}
+
+		policy.subjects = append(policy.subjects, subject)
+	}
+
+	return nil
+}
+
+// parseDistinguishedName parses a distinguished name of RFC 4514, e.g. "C=US, ST=WA, O=acme\, Inc.", into its
+// attributes. Values may escape special characters with backslashes, or be hex pairs. Multi-valued RDNs, and
+// attributes other than distinguishedNameAttributes aren't supported.
+func parseDistinguishedName(dn string) (map[string]string, error) {
+	attributes := map[string]string{}
+	var name, value strings.Builder
+	// trimmed is the length of value without trailing unescaped spaces
+	trimmed := 0
+	inValue := false
+	add := func() error {
+		n := strings.TrimSpace(name.String())
+		v := value.String()[:trimmed]
+		switch {
+		case !inValue:
+			return fmt.Errorf("attribute %q has no value", n)
+		case n == "":
+			return fmt.Errorf("attribute without a name")
+		case v == "":
+			return fmt.Errorf("attribute %s has an empty value", n)
+		case !slices.Contains(slices.Collect(maps.Values(distinguishedNameAttributes)), n):
+			return fmt.Errorf("unsupported attribute %s", n)
+		}
+
+		if _, found := attributes[n]; found {
+			return fmt.Errorf("duplicate attribute %s", n)
+		}
+
+		attributes[n] = v
+		name.Reset()
+		value.Reset()
+		trimmed, inValue = 0, false
+		return nil
+	}
+
+	for i := 0; i < len(dn); i++ {
+		c := dn[i]
+		switch {
+		case c == '\\':
+			if i+1 >= len(dn) {
+				return nil, fmt.Errorf("dangling escape")
+			}
+
+			if !inValue {
+				return nil, fmt.Errorf("escapes are only supported in values")
+			}
+
+			if strings.IndexByte(`,+"\<>;= #`, dn[i+1]) >= 0 {
+				value.WriteByte(dn[i+1])
+				i++
+			} else if b, err := hex.DecodeString(dn[i+1 : min(i+3, len(dn))]); err == nil && len(b) == 1 {
+				value.WriteByte(b[0])
+				i += 2
+			} else {
+				return nil, fmt.Errorf("invalid escape at %d", i)
+			}
+
+			trimmed = value.Len()
+		case c == ',' || c == ';':
+			if err := add(); err != nil {
+				return nil, err
+			}
+		case c == '+':
+			return nil, fmt.Errorf("multi-valued RDNs aren't supported")
+		case c == '=' && !inValue:
+			inValue = true
+		case !inValue:
+			name.WriteByte(c)
+		case c == ' ' && value.Len() == 0:
+		default:
+			value.WriteByte(c)
+			if c != ' ' {
+				trimmed = value.Len()
+			}
+		}
+	}
+
+	if err := add(); err != nil {
+		return nil, err
+	}
+
+	return attributes, nil
+}
+
+// trustedIdentity returns whether the subject of the certificate has all attributes of any of the trusted
+// identities of the policy.
+func trustedIdentity(cert *x509.Certificate, policy *NotationTrustPolicy) bool {
+	if policy.anyIdentity {
+		return true
+	}
+
+	subject := map[string]string{}
+	for _, atv := range cert.Subject.Names {
+		if name, found := distinguishedNameAttributes[atv.Type.String()]; found {
+			if value, ok := atv.Value.(string); ok {
+				subject[name] = value
+			}
+		}
+	}
+
+	for _, trusted := range policy.subjects {
+		matched := true
+		for name, value := range trusted {
+			if subject[name] != value {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
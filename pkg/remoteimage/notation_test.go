@@ -0,0 +1,285 @@
+package remoteimage
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// notationSigner signs images with a certificate issued by a root CA, which is saved as trust store ca:acme.
+type notationSigner struct {
+	t       *testing.T
+	ca      *x509.Certificate
+	leaf    *x509.Certificate
+	leafKey *ecdsa.PrivateKey
+}
+
+func newNotationSigner(t *testing.T, subject pkix.Name) *notationSigner {
+	newCert := func(subject pkix.Name, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (
+		*x509.Certificate, *ecdsa.PrivateKey,
+	) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.NoError(t, err)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(time.Now().UnixNano()),
+			Subject:      subject,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		if parent == nil {
+			template.IsCA, template.BasicConstraintsValid = true, true
+			template.KeyUsage = x509.KeyUsageCertSign
+			parent, parentKey = template, key
+		} else {
+			template.KeyUsage = x509.KeyUsageDigitalSignature
+			template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+		assert.NoError(t, err)
+		cert, err := x509.ParseCertificate(der)
+		assert.NoError(t, err)
+		return cert, key
+	}
+
+	ca, caKey := newCert(pkix.Name{CommonName: "acme root"}, nil, nil)
+	leaf, leafKey := newCert(subject, ca, caKey)
+	return &notationSigner{t: t, ca: ca, leaf: leaf, leafKey: leafKey}
+}
+
+// sign returns a signature of the target with the protected header, which defaults to one expiring at expiry.
+func (s *notationSigner) sign(target digest.Digest, expiry time.Time, header map[string]any) []byte {
+	t := s.t
+	if header == nil {
+		header = map[string]any{
+			"alg": "ES256", "cty": "application/vnd.cncf.notary.payload.v1+json",
+			"crit":                         []string{"io.cncf.notary.signingScheme", "io.cncf.notary.expiry"},
+			"io.cncf.notary.signingScheme": "notary.x509", "io.cncf.notary.signingTime": time.Now(),
+			"io.cncf.notary.expiry": expiry,
+		}
+	}
+	headerJSON, err := json.Marshal(header)
+	assert.NoError(t, err)
+	payload, err := json.Marshal(map[string]any{"targetArtifact": map[string]any{
+		"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": target, "size": 100,
+	}})
+	assert.NoError(t, err)
+
+	protected := base64.RawURLEncoding.EncodeToString(headerJSON)
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+	hashed := sha256.Sum256([]byte(protected + "." + encodedPayload))
+	r, sig, err := ecdsa.Sign(rand.Reader, s.leafKey, hashed[:])
+	assert.NoError(t, err)
+	signature := append(r.FillBytes(make([]byte, 32)), sig.FillBytes(make([]byte, 32))...)
+	envelope, err := json.Marshal(map[string]any{
+		"protected": protected, "payload": encodedPayload,
+		"header":    map[string]any{"x5c": [][]byte{s.leaf.Raw, s.ca.Raw}},
+		"signature": base64.RawURLEncoding.EncodeToString(signature),
+	})
+	assert.NoError(t, err)
+	return envelope
+}
+
+// writeTrustStore saves the root CA of the signer as trust store ca:acme in dir.
+func (s *notationSigner) writeTrustStore(dir string) {
+	storeDir := filepath.Join(dir, "x509", "ca", "acme")
+	assert.NoError(s.t, os.MkdirAll(storeDir, 0o755))
+	assert.NoError(s.t, os.WriteFile(filepath.Join(storeDir, "root.crt"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.ca.Raw}), 0o644))
+}
+
+// writeTrustPolicy saves the trust policy document in dir, and returns its path.
+func writeTrustPolicy(t *testing.T, dir, policy string) string {
+	policyFile := filepath.Join(dir, "trustpolicy.json")
+	assert.NoError(t, os.WriteFile(policyFile, []byte(policy), 0o644))
+	return policyFile
+}
+
+func TestNotationVerifier(t *testing.T) {
+	signer := newNotationSigner(t, pkix.Name{Country: []string{"US"}, Province: []string{"WA"},
+		Organization: []string{"acme"}})
+	subject := digest.FromString("image")
+	sign := func(target digest.Digest, expiry time.Time) []byte {
+		return signer.sign(target, expiry, nil)
+	}
+
+	dir := t.TempDir()
+	signer.writeTrustStore(dir)
+	policyFile := writeTrustPolicy(t, dir, `{"version": "1.0", "trustPolicies": [
+		{"name": "acme", "registryScopes": ["registry.acme.io/app"], "signatureVerification": {"level": "strict"},
+		 "trustStores": ["ca:acme"], "trustedIdentities": ["x509.subject: C=US, ST=WA, O=acme"]},
+		{"name": "others", "registryScopes": ["*"], "signatureVerification": {"level": "permissive"},
+		 "trustStores": ["ca:acme"], "trustedIdentities": ["x509.subject: C=US, ST=WA, O=other"]}
+	]}`)
+
+	verifier, err := NewNotationVerifier(policyFile, dir)
+	assert.NoError(t, err)
+	acme, err := verifier.policy(mustParse(t, "registry.acme.io/app"))
+	assert.NoError(t, err)
+	assert.Equal(t, "acme", acme.Name)
+	others, err := verifier.policy(mustParse(t, "docker.io/library/redis:latest"))
+	assert.NoError(t, err)
+	assert.Equal(t, "others", others.Name)
+
+	assert.NoError(t, verifier.verifySignature(sign(subject, time.Now().Add(time.Hour)), subject, acme))
+	assert.ErrorContains(t, verifier.verifySignature(sign(digest.FromString("other"), time.Now().Add(time.Hour)),
+		subject, acme), "rather than")
+	assert.ErrorContains(t, verifier.verifySignature(sign(subject, time.Now().Add(-time.Minute)), subject, acme),
+		"expired")
+	assert.ErrorContains(t, verifier.verifySignature(sign(subject, time.Now().Add(time.Hour)), subject, others),
+		"not a trusted identity")
+
+	tampered := sign(subject, time.Now().Add(time.Hour))
+	var envelope map[string]any
+	assert.NoError(t, json.Unmarshal(tampered, &envelope))
+	envelope["payload"] = base64.RawURLEncoding.EncodeToString([]byte(`{"targetArtifact": {}}`))
+	tampered, err = json.Marshal(envelope)
+	assert.NoError(t, err)
+	assert.ErrorContains(t, verifier.verifySignature(tampered, subject, acme), "invalid signature")
+}
+
+func TestNotationCriticalHeaders(t *testing.T) {
+	signer := newNotationSigner(t, pkix.Name{Country: []string{"US"}, Province: []string{"WA"},
+		Organization: []string{"acme"}})
+	dir := t.TempDir()
+	signer.writeTrustStore(dir)
+	verifier, err := NewNotationVerifier(writeTrustPolicy(t, dir, `{"version": "1.0", "trustPolicies": [
+		{"name": "acme", "registryScopes": ["*"], "signatureVerification": {"level": "strict"},
+		 "trustStores": ["ca:acme"], "trustedIdentities": ["*"]}
+	]}`), dir)
+	require.NoError(t, err)
+	policy := &verifier.policies[0]
+
+	subject := digest.FromString("image")
+	header := func(crit []string, extra map[string]any) map[string]any {
+		h := map[string]any{
+			"alg": "ES256", "cty": "application/vnd.cncf.notary.payload.v1+json", "crit": crit,
+			"io.cncf.notary.signingScheme": "notary.x509", "io.cncf.notary.signingTime": time.Now(),
+		}
+		for k, v := range extra {
+			h[k] = v
+		}
+		return h
+	}
+
+	assert.NoError(t, verifier.verifySignature(signer.sign(subject, time.Time{},
+		header([]string{"io.cncf.notary.signingScheme"}, nil)), subject, policy))
+	assert.ErrorContains(t, verifier.verifySignature(signer.sign(subject, time.Time{},
+		header(nil, nil)), subject, policy), `"io.cncf.notary.signingScheme" must be critical`)
+	assert.ErrorContains(t, verifier.verifySignature(signer.sign(subject, time.Time{},
+		header([]string{"io.cncf.notary.signingScheme"}, map[string]any{"io.cncf.notary.expiry": time.Now().Add(time.Hour)})),
+		subject, policy), `"io.cncf.notary.expiry" must be critical`)
+	assert.ErrorContains(t, verifier.verifySignature(signer.sign(subject, time.Time{},
+		header([]string{"io.cncf.notary.signingScheme", "unknown"}, nil)), subject, policy),
+		`unsupported critical header "unknown"`)
+}
+
+func TestNotationTrustedIdentities(t *testing.T) {
+	signer := newNotationSigner(t, pkix.Name{Country: []string{"US"}, Province: []string{"WA"},
+		Organization: []string{"acme, Inc."}, CommonName: "signer"})
+	dir := t.TempDir()
+	signer.writeTrustStore(dir)
+	newVerifier := func(identities string) (*NotationVerifier, error) {
+		return NewNotationVerifier(writeTrustPolicy(t, dir, `{"version": "1.0", "trustPolicies": [
+			{"name": "acme", "registryScopes": ["*"], "signatureVerification": {"level": "strict"},
+			 "trustStores": ["ca:acme"], "trustedIdentities": `+identities+`}
+		]}`), dir)
+	}
+
+	for _, invalid := range []string{
+		`["x509.subject:"]`,
+		`["x509.subject: "]`,
+		`["x509.subject: C=US, ST=WA"]`,
+		`["x509.subject: C=US, ST=WA, O="]`,
+		`["x509.subject: C=US, ST=WA, O=acme, O=other"]`,
+		`["x509.subject: C=US, ST=WA, O=acme,"]`,
+		`["x509.subject: C=US, ST=WA, O=acme+CN=signer"]`,
+		`["x509.subject: C=US, ST=WA, O=acme\\"]`,
+		`["x509.subject: C=US, ST=WA, O=acme, serialNumber=1"]`,
+		`["x509.subject: C=US, ST, O=acme"]`,
+		`["C=US, ST=WA, O=acme"]`,
+		`["*", "x509.subject: C=US, ST=WA, O=acme"]`,
+	} {
+		_, err := newVerifier(invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	subject := digest.FromString("image")
+	envelope := signer.sign(subject, time.Now().Add(time.Hour), nil)
+	for identities, trusted := range map[string]bool{
+		`["x509.subject: C=US, ST=WA, O=acme\\, Inc."]`:                                       true,
+		`["x509.subject: C=US, ST=WA, O=acme\\2C Inc., CN=signer"]`:                           true,
+		`["x509.subject: C=US, ST=WA, O=acme"]`:                                               false,
+		`["x509.subject: C=US, ST=WA, O=acme\\, Inc., CN=other"]`:                             false,
+		`["x509.subject: C=US;ST=WA;O=acme\\, Inc."]`:                                         true,
+		`["x509.subject: C=US, ST=WA, O=other", "x509.subject: C=US, ST=WA, O=acme\\, Inc."]`: true,
+	} {
+		verifier, err := newVerifier(identities)
+		require.NoError(t, err, identities)
+		err = verifier.verifySignature(envelope, subject, &verifier.policies[0])
+		if trusted {
+			assert.NoError(t, err, identities)
+		} else {
+			assert.ErrorContains(t, err, "not a trusted identity", identities)
+		}
+	}
+}
+
+func TestNotationVerifiedImages(t *testing.T) {
+	signer := newNotationSigner(t, pkix.Name{Country: []string{"US"}, Province: []string{"WA"},
+		Organization: []string{"acme"}})
+	dir := t.TempDir()
+	signer.writeTrustStore(dir)
+	policy := `{"version": "1.0", "trustPolicies": [
+		{"name": "acme", "registryScopes": ["*"], "signatureVerification": {"level": "strict"},
+		 "trustStores": ["ca:acme"], "trustedIdentities": ["*"]}
+	]}`
+	verifier, err := NewNotationVerifier(writeTrustPolicy(t, dir, policy), dir)
+	require.NoError(t, err)
+	now := time.Now()
+	verifier.now = func() time.Time { return now }
+
+	// Verified images are forgotten once they expire.
+	verifier.remember("image")
+	assert.True(t, verifier.isVerified("image"))
+	now = now.Add(notationVerifiedTTL + time.Second)
+	assert.False(t, verifier.isVerified("image"))
+	assert.Empty(t, verifier.verified)
+
+	// The verifier remembers at most maxNotationVerified images, and forgets the one expiring first.
+	for i := 0; i <= maxNotationVerified; i++ {
+		verifier.remember(digest.FromString(string(rune(i))).String())
+		now = now.Add(time.Millisecond)
+	}
+	assert.Len(t, verifier.verified, maxNotationVerified)
+	assert.False(t, verifier.isVerified(digest.FromString(string(rune(0))).String()))
+	assert.True(t, verifier.isVerified(digest.FromString(string(rune(maxNotationVerified))).String()))
+
+	// Images are verified again once the trust policy or trust stores change.
+	same, err := NewNotationVerifier(writeTrustPolicy(t, dir, policy), dir)
+	require.NoError(t, err)
+	assert.Equal(t, verifier.state, same.state)
+	changed, err := NewNotationVerifier(writeTrustPolicy(t, dir, policy+"\n"), dir)
+	require.NoError(t, err)
+	assert.NotEqual(t, verifier.state, changed.state)
+
+	otherStore := t.TempDir()
+	newNotationSigner(t, pkix.Name{CommonName: "other"}).writeTrustStore(otherStore)
+	rotated, err := NewNotationVerifier(writeTrustPolicy(t, dir, policy), otherStore)
+	require.NoError(t, err)
+	assert.NotEqual(t, verifier.state, rotated.state)
+}
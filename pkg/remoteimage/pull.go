@@ -2,12 +2,14 @@ package remoteimage
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"time"
 
 	"github.com/distribution/reference"
 	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
 	"github.com/warm-metal/container-image-csi-driver/pkg/secret"
+	"github.com/warm-metal/container-image-csi-driver/pkg/tracing"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	cri "k8s.io/cri-api/pkg/apis/runtime/v1"
 	"k8s.io/klog/v2"
@@ -25,21 +27,45 @@ type Puller interface {
 	ImageSize(context.Context) (int, error)
 }
 
-// NewPuller creates a new image puller instance
+// runtimeHandlerAnnotation passes the runtime handler of pulls to containerd versions which don't
+// support the runtime handler of image specs.
+const runtimeHandlerAnnotation = "io.containerd.cri.runtime-handler"
+
+// NewPuller creates a new image puller instance. If runtimeHandler is not empty, the runtime unpacks
+// the image with the snapshotter of the handler.
 func NewPuller(imageSvc cri.ImageServiceClient, image reference.Named,
-	keyring secret.DockerKeyring) Puller {
+	keyring secret.DockerKeyring, runtimeHandler string) Puller {
+	return &puller{
+		imageSvc:       imageSvc,
+		image:          image,
+		keyring:        keyring,
+		runtimeHandler: runtimeHandler,
+	}
+}
+
+// NewAuditedPuller creates a new image puller instance like NewPuller, which writes a record of each pull to
+// auditLog, including the credential source and the requester of the pull. Pulls are not audited if auditLog is nil.
+func NewAuditedPuller(imageSvc cri.ImageServiceClient, image reference.Named, keyring secret.DockerKeyring,
+	runtimeHandler string, auditLog *AuditLog, requester PullRequester) Puller {
 	return &puller{
-		imageSvc: imageSvc,
-		image:    image,
-		keyring:  keyring,
+		imageSvc:       imageSvc,
+		image:          image,
+		keyring:        keyring,
+		runtimeHandler: runtimeHandler,
+		auditLog:       auditLog,
+		requester:      requester,
 	}
 }
 
 // puller implements the Puller interface
 type puller struct {
-	imageSvc cri.ImageServiceClient
-	image    reference.Named
-	keyring  secret.DockerKeyring
+	imageSvc       cri.ImageServiceClient
+	image          reference.Named
+	keyring        secret.DockerKeyring
+	runtimeHandler string
+	// pulls are not audited if auditLog is nil
+	auditLog  *AuditLog
+	requester PullRequester
 }
 
 // ImageWithTag returns the full image name with tag
@@ -55,40 +81,85 @@ func (p puller) ImageWithoutTag() string {
 // Returns the compressed size of the image that was pulled in bytes
 // see https://github.com/containerd/containerd/issues/9261
 func (p puller) ImageSize(ctx context.Context) (int, error) {
+	resp, err := p.imageStatus(ctx, false)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(resp.Image.Size), nil
+}
+
+// imageStatus returns the status of the local image, with the verbose info of the runtime if verbose is set.
+func (p puller) imageStatus(ctx context.Context, verbose bool) (*cri.ImageStatusResponse, error) {
 	imageSpec := &cri.ImageSpec{Image: p.ImageWithTag()}
 	imageStatusResponse, err := p.imageSvc.ImageStatus(ctx, &cri.ImageStatusRequest{
-		Image: imageSpec,
+		Image:   imageSpec,
+		Verbose: verbose,
 	})
 
 	if err != nil {
 		metrics.OperationErrorsCount.WithLabelValues("size-error").Inc()
-		return 0, fmt.Errorf("failed to get image status: %w", err)
+		return nil, fmt.Errorf("failed to get image status: %w", err)
 	}
 
 	if imageStatusResponse == nil {
 		metrics.OperationErrorsCount.WithLabelValues("size-error").Inc()
-		return 0, fmt.Errorf("image status response is nil")
+		return nil, fmt.Errorf("image status response is nil")
 	}
 
 	if imageStatusResponse.Image == nil {
 		metrics.OperationErrorsCount.WithLabelValues("size-error").Inc()
-		return 0, fmt.Errorf("image info is nil in status response")
+		return nil, fmt.Errorf("image info is nil in status response")
 	}
 
-	return int(imageStatusResponse.Image.Size), nil
+	return imageStatusResponse, nil
+}
+
+// localImageId returns the ID of the local image, or an empty string if it isn't on the node.
+func (p puller) localImageId(ctx context.Context) string {
+	resp, err := p.imageSvc.ImageStatus(ctx, &cri.ImageStatusRequest{Image: &cri.ImageSpec{Image: p.ImageWithTag()}})
+	if err != nil {
+		return ""
+	}
+
+	return resp.GetImage().GetId()
+}
+
+// imageLayers returns the number of layers of the image in the verbose info of its status, which containerd and
+// CRI-O report as the OCI image config in the key info. 0 is returned if the runtime doesn't report it.
+func imageLayers(info map[string]string) int {
+	verbose := struct {
+		ImageSpec struct {
+			RootFS struct {
+				DiffIDs []string `json:"diff_ids"`
+			} `json:"rootfs"`
+		} `json:"imageSpec"`
+	}{}
+
+	if err := json.Unmarshal([]byte(info["info"]), &verbose); err != nil {
+		return 0
+	}
+
+	return len(verbose.ImageSpec.RootFS.DiffIDs)
 }
 
 // Pull downloads the container image
 func (p puller) Pull(ctx context.Context) (err error) {
+	defer metrics.InFlightOperations.Start(ctx, metrics.OperationPull)()
+	ctx, span := tracing.Start(ctx, "PullImage", tracing.ImageAttributes(p.image)...)
+	defer func() { tracing.End(span, err) }()
 	startTime := time.Now()
+	localId := p.localImageId(ctx)
+	source := SourceAnonymous
 
 	// Setup deferred metrics collection
 	defer func() {
-		p.recordPullMetrics(startTime, err, ctx)
+		p.recordPullMetrics(startTime, localId, err, ctx)
+		p.recordAudit(ctx, source, err)
 	}()
 
 	// Create image spec for CRI API
-	imageSpec := &cri.ImageSpec{Image: p.ImageWithTag()}
+	imageSpec := &cri.ImageSpec{Image: p.ImageWithTag(), RuntimeHandler: p.runtimeHandler}
 
 	// First try without credentials
 	if err = p.pullWithoutCredentials(ctx, imageSpec); err == nil {
@@ -96,100 +167,135 @@ func (p puller) Pull(ctx context.Context) (err error) {
 	}
 
 	// If public pull failed, try with credentials
-	return p.pullWithCredentials(ctx, imageSpec, err)
+	source, err = p.pullWithCredentials(ctx, imageSpec, err)
+	return err
 }
 
-// recordPullMetrics records metrics about the image pull operation
-func (p puller) recordPullMetrics(startTime time.Time, err error, ctx context.Context) {
+// recordAudit writes the audit record of the pull with the credential source, if the puller is audited.
+func (p puller) recordAudit(ctx context.Context, source string, err error) {
+	if p.auditLog == nil {
+		return
+	}
+
+	record := &AuditRecord{
+		Time:             time.Now().UTC(),
+		Image:            p.ImageWithTag(),
+		Registry:         reference.Domain(p.image),
+		CredentialSource: source,
+		PullRequester:    p.requester,
+		Result:           AuditResultSuccess,
+	}
+
+	if err != nil {
+		record.Result = AuditResultFailure
+		record.Error = err.Error()
+	} else if dgst, digestErr := LocalDigest(ctx, p.imageSvc, p.image); digestErr == nil {
+		record.Digest = dgst.String()
+	}
+
+	if err := p.auditLog.Record(record); err != nil {
+		klog.FromContext(ctx).Error(err, "Unable to write the audit record of the pull")
+	}
+}
+
+// recordPullMetrics records metrics about the image pull operation. localId is the ID of the image on the node
+// before the pull, if any.
+func (p puller) recordPullMetrics(startTime time.Time, localId string, err error, ctx context.Context) {
 	elapsed := time.Since(startTime).Seconds()
 	imageTag := p.ImageWithTag()
 
 	// Record pull time metrics
-	klog.Infof("Pulled %s in %d milliseconds", imageTag, int(1000*elapsed))
-	metrics.ImagePullTimeHist.WithLabelValues(metrics.BoolToString(err != nil)).Observe(elapsed)
-	metrics.ImagePullTime.WithLabelValues(imageTag, metrics.BoolToString(err != nil)).Set(elapsed)
+	klog.FromContext(ctx).Info("Pulled image", "durationMilliseconds", int(1000*elapsed), "failed", err != nil)
+	metrics.ObserveImagePull(reference.Domain(p.image), imageTag, err != nil, elapsed, tracing.TraceID(ctx))
 
 	// Record errors if any
 	if err != nil {
 		metrics.OperationErrorsCount.WithLabelValues("pull-error").Inc()
 	}
 
-	// Schedule cleanup of metrics after 1 minute
-	go func() {
-		time.Sleep(1 * time.Minute)
-		metrics.ImagePullTime.DeleteLabelValues(imageTag, metrics.BoolToString(err != nil))
-	}()
-
 	// Record size metrics if pull was successful
 	if err == nil {
-		p.recordSizeMetrics(ctx, imageTag)
+		p.recordSizeMetrics(ctx, imageTag, localId, elapsed)
 	}
 }
 
-// recordSizeMetrics records metrics about the image size
-func (p puller) recordSizeMetrics(ctx context.Context, imageTag string) {
-	size, err := p.ImageSize(ctx)
+// recordSizeMetrics records metrics about the image size, and bytes and layers fetched unless the image was already
+// on the node. Layers shared with other local images are counted as well, as CRI doesn't report what is fetched.
+func (p puller) recordSizeMetrics(ctx context.Context, imageTag, localId string, elapsed float64) {
+	resp, err := p.imageStatus(ctx, true)
 	if err != nil {
-		return // Error already logged in ImageSize()
+		return // Error already counted in imageStatus()
 	}
 
-	klog.Infof("Pulled %s with size of %d bytes", imageTag, size)
-	metrics.ImagePullSizeBytes.WithLabelValues(imageTag).Set(float64(size))
+	size := int(resp.Image.Size)
+	logger := klog.FromContext(ctx)
+	logger.Info("Pulled image size", "bytes", size)
+	registry := reference.Domain(p.image)
+	metrics.ObserveImageSize(registry, imageTag, size)
+	if resp.Image.Id == localId {
+		logger.V(2).Info("Image was already on the node")
+		return
+	}
 
-	// Schedule cleanup of metrics after 1 minute
-	go func() {
-		time.Sleep(1 * time.Minute)
-		metrics.ImagePullSizeBytes.DeleteLabelValues(imageTag)
-	}()
+	metrics.ObserveFetchedImage(registry, size, imageLayers(resp.Info), elapsed)
 }
 
 // pullWithoutCredentials attempts to pull the image without authentication
 func (p puller) pullWithoutCredentials(ctx context.Context, imageSpec *cri.ImageSpec) error {
-	klog.V(2).Infof("Attempting to pull image %s without credentials", p.ImageWithTag())
+	logger := klog.FromContext(ctx)
+	logger.V(2).Info("Attempting to pull image without credentials")
 
+	metrics.PullAttemptsCount.WithLabelValues(reference.Domain(p.image)).Inc()
 	_, err := p.imageSvc.PullImage(ctx, &cri.PullImageRequest{
-		Image: imageSpec,
+		Image:         imageSpec,
+		SandboxConfig: p.sandboxConfig(),
 	})
 
 	if err == nil {
-		klog.V(2).Infof("Successfully pulled image %s without credentials", p.ImageWithTag())
+		logger.V(2).Info("Successfully pulled image without credentials")
 		return nil
 	}
 
-	klog.V(2).Infof("Pull without credentials failed for %s: %v", p.ImageWithTag(), err)
+	logger.V(2).Info("Pull without credentials failed", "err", err)
 	return err
 }
 
-// pullWithCredentials attempts to pull the image using credentials from the keyring
-func (p puller) pullWithCredentials(ctx context.Context, imageSpec *cri.ImageSpec, initialErr error) error {
+// pullWithCredentials attempts to pull the image using credentials from the keyring, and returns the source of the
+// credential it succeeds with, or that of the last credential tried
+func (p puller) pullWithCredentials(ctx context.Context, imageSpec *cri.ImageSpec, initialErr error) (string, error) {
 	// Look up credentials for this image repository
 	repo := p.ImageWithoutTag()
-	klog.V(2).Infof("Looking up credentials for repo=%s (full image=%s)", repo, p.ImageWithTag())
-	authConfigs, withCredentials := p.keyring.Lookup(repo)
+	logger := klog.FromContext(ctx)
+	logger.V(2).Info("Looking up credentials", "repo", repo)
+	credentials, withCredentials := secret.LookupWithSources(p.keyring, repo)
 
 	// If no credentials are available, return the original error
-	if !withCredentials || len(authConfigs) == 0 {
-		klog.V(2).Infof("No credentials found for %s", p.ImageWithTag())
-		return fmt.Errorf("failed to pull image without credentials and no credentials available: %w", initialErr)
+	if !withCredentials || len(credentials) == 0 {
+		logger.V(2).Info("No credentials found")
+		return SourceAnonymous,
+			fmt.Errorf("failed to pull image without credentials and no credentials available: %w", initialErr)
 	}
 
-	klog.V(2).Infof("Found %d credential options for image %s", len(authConfigs), p.ImageWithTag())
+	logger.V(2).Info("Found credential options", "count", len(credentials))
 
 	// Try each credential option
-	return p.tryCredentials(ctx, imageSpec, authConfigs)
+	return p.tryCredentials(ctx, imageSpec, credentials)
 }
 
-// tryCredentials attempts to pull the image with each credential option
-func (p puller) tryCredentials(ctx context.Context, imageSpec *cri.ImageSpec, authConfigs []*cri.AuthConfig) error {
+// tryCredentials attempts to pull the image with each credential option, and returns the source of the last one tried
+func (p puller) tryCredentials(ctx context.Context, imageSpec *cri.ImageSpec, credentials []secret.Credential) (
+	string, error,
+) {
 	var pullErrs []error
+	logger := klog.FromContext(ctx)
 
 	// Try each credential until one succeeds
-	for i, authConfig := range authConfigs {
-		klog.V(2).Infof("Trying credential option %d for image %s", i+1, p.ImageWithTag())
+	for i, credential := range credentials {
+		logger.V(2).Info("Trying credential option", "option", i+1, "source", credential.Source)
 
 		// Try pulling with this credential
-		if err := p.pullWithAuth(ctx, imageSpec, authConfig, i+1); err == nil {
-			return nil // Success
+		if err := p.pullWithAuth(ctx, imageSpec, credential.Auth, i+1); err == nil {
+			return credential.Source, nil // Success
 		} else {
 			pullErrs = append(pullErrs, err)
 		}
@@ -197,26 +303,37 @@ func (p puller) tryCredentials(ctx context.Context, imageSpec *cri.ImageSpec, au
 
 	// All credential options failed
 	err := utilerrors.NewAggregate(pullErrs)
-	klog.Warningf("All %d credential options failed for image %s",
-		len(authConfigs), p.ImageWithTag())
-	return err
+	logger.Error(err, "All credential options failed", "count", len(credentials))
+	return credentials[len(credentials)-1].Source, err
 }
 
 // pullWithAuth attempts to pull using a specific credential
 func (p puller) pullWithAuth(ctx context.Context, imageSpec *cri.ImageSpec, auth *cri.AuthConfig, optionNum int) error {
-	klog.V(2).Infof("Attempting pull for %s with credential option %d (username: '%s')",
-		p.ImageWithTag(), optionNum, auth.Username)
+	logger := klog.FromContext(ctx)
+	logger.V(2).Info("Attempting pull with credential option", "option", optionNum, "username", auth.Username)
 
+	metrics.PullAttemptsCount.WithLabelValues(reference.Domain(p.image)).Inc()
 	_, err := p.imageSvc.PullImage(ctx, &cri.PullImageRequest{
-		Image: imageSpec,
-		Auth:  auth,
+		Image:         imageSpec,
+		Auth:          auth,
+		SandboxConfig: p.sandboxConfig(),
 	})
 
 	if err == nil {
-		klog.Infof("Successfully pulled image %s with credential option %d", p.ImageWithTag(), optionNum)
+		logger.Info("Successfully pulled image with credential option", "option", optionNum)
 		return nil
 	}
 
-	klog.V(2).Infof("Pull with credential option %d failed: %v", optionNum, err)
+	logger.V(2).Info("Pull with credential option failed", "option", optionNum, "err", err)
 	return fmt.Errorf("auth option %d: %w", optionNum, err)
 }
+
+// sandboxConfig returns the sandbox config carrying the runtime handler, since runtimes only honor the
+// runtime handler of pulls for sandboxes.
+func (p puller) sandboxConfig() *cri.PodSandboxConfig {
+	if p.runtimeHandler == "" {
+		return nil
+	}
+
+	return &cri.PodSandboxConfig{Annotations: map[string]string{runtimeHandlerAnnotation: p.runtimeHandler}}
+}
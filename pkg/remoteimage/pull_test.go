@@ -1,12 +1,33 @@
 package remoteimage
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/distribution/reference"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/warm-metal/container-image-csi-driver/pkg/cri"
+	"github.com/warm-metal/container-image-csi-driver/pkg/fake"
+	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
+	"github.com/warm-metal/container-image-csi-driver/pkg/secret"
+	"github.com/warm-metal/container-image-csi-driver/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	v1 "k8s.io/cri-api/pkg/apis/runtime/v1"
 )
 
@@ -28,3 +49,353 @@ func TestPull(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotNil(t, r)
 }
+
+func TestImageLayers(t *testing.T) {
+	info := map[string]string{
+		"info": `{"imageSpec":{"architecture":"amd64","rootfs":{"type":"layers","diff_ids":["sha256:a","sha256:b"]}}}`,
+	}
+	assert.Equal(t, 2, imageLayers(info))
+	assert.Zero(t, imageLayers(nil))
+	assert.Zero(t, imageLayers(map[string]string{"info": "not json"}))
+}
+
+// privateImageService only pulls images with the password "right".
+type privateImageService struct {
+	*fake.ImageService
+}
+
+func (s privateImageService) PullImage(
+	ctx context.Context, in *v1.PullImageRequest, opts ...grpc.CallOption,
+) (*v1.PullImageResponse, error) {
+	if in.GetAuth().GetPassword() != "right" {
+		return nil, status.Error(codes.Unauthenticated, "401 Unauthorized")
+	}
+
+	return s.ImageService.PullImage(ctx, in, opts...)
+}
+
+func TestPullCounts(t *testing.T) {
+	registry := "private.example.com"
+	keyring := &secret.BasicDockerKeyring{}
+	keyring.Add(secret.DockerConfig{registry: &v1.AuthConfig{Username: "user", Password: "wrong"}})
+	keyring.Add(secret.DockerConfig{registry: &v1.AuthConfig{Username: "user", Password: "right"}})
+	imageSvc := privateImageService{fake.NewImageService()}
+
+	image, err := reference.ParseDockerRef(registry + "/app:v1")
+	assert.NoError(t, err)
+	assert.NoError(t, NewPuller(imageSvc, image, keyring, "").Pull(context.Background()))
+	assert.Error(t, NewPuller(imageSvc, image, &secret.BasicDockerKeyring{}, "").Pull(context.Background()))
+
+	families, err := metrics.RegisterMetrics().Gather()
+	assert.NoError(t, err)
+	counts := map[string]float64{}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			key := family.GetName()
+			for _, label := range m.GetLabel() {
+				key += "/" + label.GetValue()
+			}
+			counts[key] = m.GetCounter().GetValue()
+		}
+	}
+
+	assert.Equal(t, 1.0, counts["warm_metal_pulls_total/"+registry+"/success"])
+	assert.Equal(t, 1.0, counts["warm_metal_pulls_total/"+registry+"/failure"])
+	// Anonymous and both credentials, then the anonymous pull only.
+	assert.Equal(t, 4.0, counts["warm_metal_pull_attempts_total/"+registry])
+}
+
+// blockedImageService blocks pulls until release is closed. started is closed once a pull is blocked.
+type blockedImageService struct {
+	*fake.ImageService
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s blockedImageService) PullImage(
+	ctx context.Context, in *v1.PullImageRequest, opts ...grpc.CallOption,
+) (*v1.PullImageResponse, error) {
+	close(s.started)
+	<-s.release
+	return s.ImageService.PullImage(ctx, in, opts...)
+}
+
+func TestLastPullAverageThroughput(t *testing.T) {
+	registry := "throughput.example.com"
+	throughput := func() float64 {
+		families, err := metrics.RegisterMetrics().Gather()
+		assert.NoError(t, err)
+		for _, family := range families {
+			if family.GetName() != "warm_metal_"+metrics.LastPullAverageThroughputKey {
+				continue
+			}
+
+			for _, m := range family.GetMetric() {
+				if m.GetLabel()[0].GetValue() == registry {
+					return m.GetGauge().GetValue()
+				}
+			}
+		}
+
+		return 0
+	}
+
+	imageSvc := fake.NewImageService()
+	imageSvc.PullDelay = 100 * time.Millisecond
+	assert.NoError(t, NewPuller(imageSvc, mustParse(t, registry+"/app:v1"), &secret.BasicDockerKeyring{}, "").
+		Pull(context.Background()))
+	last := throughput()
+	assert.Positive(t, last)
+	assert.LessOrEqual(t, last, 100.0*(1<<20)/imageSvc.PullDelay.Seconds())
+
+	// The gauge keeps the average of the last completed pull while another pull is blocked.
+	blocked := blockedImageService{ImageService: imageSvc, started: make(chan struct{}), release: make(chan struct{})}
+	done := make(chan error)
+	go func() {
+		done <- NewPuller(blocked, mustParse(t, registry+"/app:v2"), &secret.BasicDockerKeyring{}, "").
+			Pull(context.Background())
+	}()
+
+	<-blocked.started
+	assert.Equal(t, last, throughput())
+	time.Sleep(100 * time.Millisecond)
+	close(blocked.release)
+	assert.NoError(t, <-done)
+	assert.Less(t, throughput(), last)
+}
+
+// recordingImageService records pull requests before passing them to the image service.
+type recordingImageService struct {
+	v1.ImageServiceClient
+	requests *[]*v1.PullImageRequest
+}
+
+func (s recordingImageService) PullImage(
+	ctx context.Context, in *v1.PullImageRequest, opts ...grpc.CallOption,
+) (*v1.PullImageResponse, error) {
+	*s.requests = append(*s.requests, in)
+	return s.ImageServiceClient.PullImage(ctx, in, opts...)
+}
+
+func TestPullRuntimeHandler(t *testing.T) {
+	keyring := &secret.BasicDockerKeyring{}
+	keyring.Add(secret.DockerConfig{"handler.example.com": &v1.AuthConfig{Username: "user", Password: "right"}})
+
+	tests := []struct {
+		name           string
+		image          string
+		imageSvc       v1.ImageServiceClient
+		runtimeHandler string
+		// pulls is the number of pull requests, i.e. the anonymous one and one with credentials if it fails
+		pulls int
+	}{
+		{
+			name:           "without credentials",
+			image:          "docker.io/library/redis:latest",
+			imageSvc:       fake.NewImageService(),
+			runtimeHandler: "nydus",
+			pulls:          1,
+		},
+		{
+			name:           "with credentials",
+			image:          "handler.example.com/app:v1",
+			imageSvc:       privateImageService{fake.NewImageService()},
+			runtimeHandler: "nydus",
+			pulls:          2,
+		},
+		{
+			name:     "default handler without credentials",
+			image:    "docker.io/library/redis:latest",
+			imageSvc: fake.NewImageService(),
+			pulls:    1,
+		},
+		{
+			name:     "default handler with credentials",
+			image:    "handler.example.com/app:v1",
+			imageSvc: privateImageService{fake.NewImageService()},
+			pulls:    2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests []*v1.PullImageRequest
+			imageSvc := recordingImageService{ImageServiceClient: tt.imageSvc, requests: &requests}
+			assert.NoError(t, NewPuller(imageSvc, mustParse(t, tt.image), keyring, tt.runtimeHandler).
+				Pull(context.Background()))
+
+			assert.Len(t, requests, tt.pulls)
+			for _, req := range requests {
+				assert.Equal(t, tt.runtimeHandler, req.GetImage().GetRuntimeHandler())
+				if tt.runtimeHandler == "" {
+					assert.Nil(t, req.GetSandboxConfig())
+					continue
+				}
+
+				assert.Equal(t, map[string]string{runtimeHandlerAnnotation: tt.runtimeHandler},
+					req.GetSandboxConfig().GetAnnotations())
+			}
+
+			// The last pull is with credentials if the anonymous one fails.
+			assert.Equal(t, tt.pulls > 1, requests[len(requests)-1].GetAuth() != nil)
+		})
+	}
+}
+
+func TestPullAudit(t *testing.T) {
+	registry := "private.example.com"
+	wrong := &secret.BasicDockerKeyring{}
+	wrong.Add(secret.DockerConfig{registry: &v1.AuthConfig{Username: "user", Password: "wrong"}})
+	right := &secret.BasicDockerKeyring{}
+	right.Add(secret.DockerConfig{registry: &v1.AuthConfig{Username: "user", Password: "right"}})
+	keyring := secret.UnionDockerKeyring{
+		secret.WithSource(wrong, secret.SourceVolumeSecrets),
+		secret.WithSource(right, secret.SecretSource("default", "pull-secret")),
+	}
+	imageSvc := privateImageService{fake.NewImageService()}
+	requester := PullRequester{VolumeID: "vol", PodNamespace: "default", PodName: "app", PodUID: "uid"}
+
+	var log bytes.Buffer
+	auditLog := NewAuditLog(&log)
+	image, err := reference.ParseDockerRef(registry + "/app:v1")
+	assert.NoError(t, err)
+	assert.NoError(t, NewAuditedPuller(imageSvc, image, keyring, "", auditLog, requester).Pull(context.Background()))
+	assert.Error(t, NewAuditedPuller(imageSvc, image, wrong, "", auditLog, requester).Pull(context.Background()))
+	assert.NotContains(t, log.String(), "right")
+
+	var records []AuditRecord
+	dec := json.NewDecoder(&log)
+	for dec.More() {
+		var record AuditRecord
+		assert.NoError(t, dec.Decode(&record))
+		records = append(records, record)
+	}
+
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, AuditResultSuccess, records[0].Result)
+		assert.Equal(t, image.String(), records[0].Image)
+		assert.Equal(t, registry, records[0].Registry)
+		assert.Equal(t, "secret:default/pull-secret", records[0].CredentialSource)
+		assert.Equal(t, requester, records[0].PullRequester)
+		assert.NotEmpty(t, records[0].Digest)
+
+		assert.Equal(t, AuditResultFailure, records[1].Result)
+		assert.Equal(t, secret.SourceUnknown, records[1].CredentialSource)
+		assert.Empty(t, records[1].Digest)
+		assert.NotEmpty(t, records[1].Error)
+	}
+}
+
+func TestPullExemplars(t *testing.T) {
+	// Spans are recorded by the SDK as they are once the driver exports them.
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.AlwaysSample()))
+	otel.SetTracerProvider(provider)
+	defer func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		_ = provider.Shutdown(context.Background())
+	}()
+
+	ctx, span := tracing.Start(context.Background(), "NodePublishVolume")
+	defer span.End()
+	traceID := tracing.TraceID(ctx)
+	assert.NotEmpty(t, traceID)
+
+	image, err := reference.ParseDockerRef("docker.io/library/exemplar:v1")
+	assert.NoError(t, err)
+	assert.NoError(t, NewPuller(fake.NewImageService(), image, &secret.BasicDockerKeyring{}, "").Pull(ctx))
+
+	server, err := metrics.NewMetricsServer(metrics.RegisterMetrics(), 0, nil, metrics.ServerOptions{})
+	assert.NoError(t, err)
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	resp := httptest.NewRecorder()
+	server.Handler.ServeHTTP(resp, req)
+
+	var exemplars []string
+	for _, line := range strings.Split(resp.Body.String(), "\n") {
+		if strings.HasPrefix(line, "warm_metal_"+metrics.ImagePullTimeHistKey+"_bucket") {
+			if _, exemplar, found := strings.Cut(line, " # "); found {
+				exemplars = append(exemplars, exemplar)
+			}
+		}
+	}
+
+	if assert.Len(t, exemplars, 1) {
+		assert.True(t, strings.HasPrefix(exemplars[0], `{trace_id="`+traceID+`"}`), exemplars[0])
+	}
+}
+
+// blobFetcher fetches blobs by their digests.
+type blobFetcher map[digest.Digest][]byte
+
+func (f blobFetcher) Fetch(_ context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	data, found := f[desc.Digest]
+	if !found {
+		return nil, fmt.Errorf("blob %s not found", desc.Digest)
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func TestAttestations(t *testing.T) {
+	subject := digest.FromString("image")
+	fetcher := blobFetcher{}
+	add := func(mediaType string, data []byte) ocispec.Descriptor {
+		desc := ocispec.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(data), Size: int64(len(data))}
+		fetcher[desc.Digest] = data
+		return desc
+	}
+	referrer := func(artifactType string, refersTo digest.Digest, layers ...ocispec.Descriptor) ocispec.Descriptor {
+		manifest, err := json.Marshal(ocispec.Manifest{
+			MediaType: ocispec.MediaTypeImageManifest, ArtifactType: artifactType, Layers: layers,
+			Subject: &ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: refersTo},
+		})
+		assert.NoError(t, err)
+		desc := add(ocispec.MediaTypeImageManifest, manifest)
+		desc.ArtifactType = artifactType
+		return desc
+	}
+	statement := func(predicateType string, attested digest.Digest) []byte {
+		data, err := json.Marshal(map[string]any{
+			"_type": "https://in-toto.io/Statement/v1", "predicateType": predicateType, "predicate": map[string]any{},
+			"subject": []any{map[string]any{"name": "app", "digest": map[string]string{"sha256": attested.Encoded()}}},
+		})
+		assert.NoError(t, err)
+		return data
+	}
+
+	attested, err := attestationsOf(context.Background(), fetcher, referrer("application/spdx+json", subject,
+		add("application/spdx+json", []byte(`{"spdxVersion": "SPDX-2.3"}`))), subject)
+	assert.NoError(t, err)
+	assert.Contains(t, attested, AttestationSBOM)
+
+	provenance := statement("https://slsa.dev/provenance/v1", subject)
+	dsse, err := json.Marshal(map[string]any{
+		"payloadType": "application/vnd.in-toto+json", "payload": base64.StdEncoding.EncodeToString(provenance),
+	})
+	assert.NoError(t, err)
+	attested, err = attestationsOf(context.Background(), fetcher, referrer("application/vnd.dsse.envelope.v1+json",
+		subject, add("application/vnd.dsse.envelope.v1+json", dsse)), subject)
+	assert.NoError(t, err)
+	assert.Contains(t, attested, AttestationSLSAProvenance)
+	assert.Contains(t, attested, "https://slsa.dev/provenance/v1")
+	assert.NotContains(t, attested, AttestationSBOM)
+
+	// Attestations of other images don't count.
+	_, err = attestationsOf(context.Background(), fetcher, referrer("application/vnd.in-toto+json", subject,
+		add("application/vnd.in-toto+json", statement("https://slsa.dev/provenance/v1", digest.FromString("other")))),
+		subject)
+	assert.ErrorContains(t, err, "doesn't attest")
+	_, err = attestationsOf(context.Background(), fetcher, referrer("application/vnd.in-toto+json",
+		digest.FromString("other"), add("application/vnd.in-toto+json", provenance)), subject)
+	assert.ErrorContains(t, err, "doesn't refer to")
+	_, err = attestationsOf(context.Background(), fetcher, referrer("application/spdx+json", subject,
+		add("application/spdx+json", []byte(`{"spdxVersion": `))), subject)
+	assert.ErrorContains(t, err, "invalid SBOM")
+}
+
+func mustParse(t *testing.T, image string) reference.Named {
+	named, err := reference.ParseDockerRef(image)
+	assert.NoError(t, err)
+	return named
+}
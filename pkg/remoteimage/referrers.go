@@ -0,0 +1,254 @@
+package remoteimage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/containerd/containerd/v2/core/remotes/docker"
+	"github.com/distribution/reference"
+	digest "github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/warm-metal/container-image-csi-driver/pkg/secret"
+	cri "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/klog/v2"
+)
+
+// ReferrersDir is the directory at the root of volumes which holds referrers of the mounted image.
+const ReferrersDir = ".image-referrers"
+
+// maxReferrerBlobSize limits the size of each blob of referrers, which are expected to be SBOMs,
+// signatures, or attestations rather than images.
+const maxReferrerBlobSize = 64 << 20
+
+// LocalDigest returns the digest the local image was pulled by.
+func LocalDigest(ctx context.Context, imageSvc cri.ImageServiceClient, image reference.Named) (digest.Digest, error) {
+	resp, err := imageSvc.ImageStatus(ctx, &cri.ImageStatusRequest{Image: &cri.ImageSpec{Image: image.String()}})
+	if err != nil {
+		return "", fmt.Errorf("failed to get image status: %w", err)
+	}
+
+	if resp.Image == nil {
+		return "", fmt.Errorf("image %q is not found", image)
+	}
+
+	for _, repoDigest := range resp.Image.RepoDigests {
+		named, err := reference.ParseNormalizedNamed(repoDigest)
+		if err != nil {
+			continue
+		}
+
+		if canonical, ok := named.(reference.Canonical); ok && named.Name() == image.Name() {
+			return canonical.Digest(), nil
+		}
+	}
+
+	return "", fmt.Errorf("image %q doesn't have a digest of repository %q", image, image.Name())
+}
+
+// NewResolver creates a registry client for the repository of the image, which authenticates with the first
+// credential found in the keyring.
+func NewResolver(image reference.Named, keyring secret.DockerKeyring) remotes.Resolver {
+	authorizer := docker.NewDockerAuthorizer(docker.WithAuthCreds(func(string) (string, string, error) {
+		authConfigs, found := keyring.Lookup(image.Name())
+		if !found || len(authConfigs) == 0 {
+			return "", "", nil
+		}
+
+		if authConfigs[0].IdentityToken != "" {
+			return "", authConfigs[0].IdentityToken, nil
+		}
+
+		return authConfigs[0].Username, authConfigs[0].Password, nil
+	}))
+
+	return docker.NewResolver(docker.ResolverOptions{
+		Hosts: docker.ConfigureDefaultRegistries(docker.WithAuthorizer(authorizer)),
+	})
+}
+
+// Resolve returns the digest of the image in its registry, which fails if the image doesn't exist.
+func Resolve(ctx context.Context, image reference.Named, keyring secret.DockerKeyring) (digest.Digest, error) {
+	_, desc, err := NewResolver(image, keyring).Resolve(ctx, reference.TagNameOnly(image).String())
+	if err != nil {
+		return "", err
+	}
+
+	return desc.Digest, nil
+}
+
+// Platforms returns platforms of manifests in the index of the image. Nothing is returned if the image is a
+// single manifest, since it doesn't tell its platform without fetching its config.
+func Platforms(ctx context.Context, image reference.Named, keyring secret.DockerKeyring) ([]ocispec.Platform, error) {
+	resolver := NewResolver(image, keyring)
+	name, desc, err := resolver.Resolve(ctx, reference.TagNameOnly(image).String())
+	if err != nil {
+		return nil, err
+	}
+
+	if desc.MediaType != ocispec.MediaTypeImageIndex && desc.MediaType != images.MediaTypeDockerSchema2ManifestList {
+		return nil, nil
+	}
+
+	fetcher, err := resolver.Fetcher(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := fetchBlob(ctx, fetcher, desc)
+	if err != nil {
+		return nil, err
+	}
+
+	var index ocispec.Index
+	if err = json.Unmarshal(blob, &index); err != nil {
+		return nil, fmt.Errorf("invalid index of image %q: %w", image, err)
+	}
+
+	var supported []ocispec.Platform
+	for _, m := range index.Manifests {
+		// Attestation manifests are attached to indexes with the platform unknown/unknown.
+		if m.Platform == nil || m.Platform.OS == "unknown" {
+			continue
+		}
+
+		supported = append(supported, *m.Platform)
+	}
+
+	return supported, nil
+}
+
+// FetchReferrers downloads the manifests and blobs of all referrers of the subject in the repository of
+// the image into ReferrersDir in dir. The referrers index is saved as index.json, and each referrer is
+// saved in a directory named after its digest. Referrers are fetched once, so nothing happens if dir exists.
+func FetchReferrers(
+	ctx context.Context, image reference.Named, subject digest.Digest, keyring secret.DockerKeyring, dir string,
+) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+
+	fetcher, referrers, err := referrersOf(ctx, image, subject, keyring)
+	if err != nil {
+		return err
+	}
+
+	// Download referrers to a temporary directory first, so that partial referrers are never mounted.
+	tmp := dir + ".tmp"
+	os.RemoveAll(tmp)
+	if err = saveReferrers(ctx, fetcher, referrers, filepath.Join(tmp, ReferrersDir)); err == nil {
+		err = os.Rename(tmp, dir)
+	}
+
+	if err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+
+	klog.Infof("fetched %d referrers of image %q", len(referrers), image)
+	return nil
+}
+
+// referrersOf returns descriptors of referrers of the subject in the repository of the image, along with the fetcher
+// of their manifests and blobs.
+func referrersOf(
+	ctx context.Context, image reference.Named, subject digest.Digest, keyring secret.DockerKeyring,
+) (remotes.Fetcher, []ocispec.Descriptor, error) {
+	fetcher, err := NewResolver(image, keyring).Fetcher(ctx, image.Name()+"@"+subject.String())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	referrersFetcher, ok := fetcher.(remotes.ReferrersFetcher)
+	if !ok {
+		return nil, nil, fmt.Errorf("the registry client doesn't support referrers")
+	}
+
+	referrers, err := referrersFetcher.FetchReferrers(ctx, subject)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to fetch referrers of %s: %w", subject, err)
+	}
+
+	return fetcher, referrers, nil
+}
+
+func saveReferrers(ctx context.Context, fetcher remotes.Fetcher, referrers []ocispec.Descriptor, dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	index, err := json.MarshalIndent(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2}, MediaType: ocispec.MediaTypeImageIndex, Manifests: referrers,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err = os.WriteFile(filepath.Join(dir, "index.json"), index, 0o644); err != nil {
+		return err
+	}
+
+	for _, desc := range referrers {
+		referrerDir := filepath.Join(dir, desc.Digest.Encoded())
+		if err = os.MkdirAll(filepath.Join(referrerDir, "blobs"), 0o755); err != nil {
+			return err
+		}
+
+		data, err := fetchBlob(ctx, fetcher, desc)
+		if err != nil {
+			return err
+		}
+
+		if err = os.WriteFile(filepath.Join(referrerDir, "manifest.json"), data, 0o644); err != nil {
+			return err
+		}
+
+		var manifest ocispec.Manifest
+		if err = json.Unmarshal(data, &manifest); err != nil {
+			return fmt.Errorf("invalid manifest of referrer %s: %w", desc.Digest, err)
+		}
+
+		for _, layer := range manifest.Layers {
+			blob, err := fetchBlob(ctx, fetcher, layer)
+			if err != nil {
+				return err
+			}
+
+			if err = os.WriteFile(filepath.Join(referrerDir, "blobs", layer.Digest.Encoded()), blob, 0o644); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// fetchBlob downloads the blob of the descriptor and verifies its digest.
+func fetchBlob(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor) ([]byte, error) {
+	if desc.Size > maxReferrerBlobSize {
+		return nil, fmt.Errorf("blob %s of %d bytes exceeds the limit of %d bytes", desc.Digest, desc.Size, maxReferrerBlobSize)
+	}
+
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch blob %s: %w", desc.Digest, err)
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, desc.Size))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read blob %s: %w", desc.Digest, err)
+	}
+
+	if desc.Digest.Algorithm().FromBytes(data) != desc.Digest {
+		return nil, fmt.Errorf("digest of blob %s mismatches", desc.Digest)
+	}
+
+	return data, nil
+}
@@ -79,7 +79,7 @@ func TestChannelClose(t *testing.T) {
 func TestNamedImageExtraction(t *testing.T) {
 	parsed, err := reference.ParseDockerRef(nonExistentImage)
 	assert.Nil(t, err, "parsing image name should succeed")
-	puller := remoteimage.NewPuller(nil, parsed, nil)
+	puller := remoteimage.NewPuller(nil, parsed, nil, "")
 	assert.Equal(t, nonExistentImage, puller.ImageWithTag(), "extracted value should match exactly %v", puller)
 	repo := strings.Split(nonExistentImage, ":")[0]
 	assert.Equal(t, repo, puller.ImageWithoutTag(), "extracted value should match exactly %v", puller)
@@ -28,8 +28,10 @@ func RunPullerLoop(
 			go func() {
 				klog.V(2).Infof("%s.RunPullerLoop(): asked to pull image %s with timeout %v\n",
 					prefix, ses.ImageWithTag(), ses.timeout)
-				ctxAsyncPullTimeoutOrShutdown, cancelDontCare := context.WithTimeout(ctx, ses.timeout) // combine session timeout and shut down signal into one
-				defer cancelDontCare()                                                                 // IF we exit, this no longer matters. calling to satisfy linter.
+				// pulls outlive requests, so they are logged with the image instead of the logger of the request
+				logCtx := klog.NewContext(ctx, klog.LoggerWithValues(klog.FromContext(ctx), "image", ses.ImageWithTag()))
+				ctxAsyncPullTimeoutOrShutdown, cancelDontCare := context.WithTimeout(logCtx, ses.timeout) // combine session timeout and shut down signal into one
+				defer cancelDontCare()                                                                    // IF we exit, this no longer matters. calling to satisfy linter.
 				pullStart := time.Now()
 				pullErr := ses.puller.Pull(ctxAsyncPullTimeoutOrShutdown) // the waiting happens here, not in the select
 				// update fields on session before declaring done
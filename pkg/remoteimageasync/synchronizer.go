@@ -3,6 +3,7 @@ package remoteimageasync
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -98,3 +99,15 @@ func (s synchronizer) WaitForPull(session *PullSession, callerTimeout context.Co
 		return err
 	}
 }
+
+// sessions are removed from the map once they complete, so all of them are queued or pulling
+func (s synchronizer) Pulls() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	images := make([]string, 0, len(s.sessionMap))
+	for image := range s.sessionMap {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images
+}
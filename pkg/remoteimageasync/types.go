@@ -35,4 +35,6 @@ type AsyncPuller interface {
 	StartPull(image string, puller remoteimage.Puller, asyncPullTimeout time.Duration) (*PullSession, error)
 	// waits for session to time out or succeed
 	WaitForPull(session *PullSession, callerTimeout context.Context) error
+	// returns images being pulled, sorted
+	Pulls() []string
 }
@@ -0,0 +1,58 @@
+// Package sandbox runs external executables, e.g. credential helpers and tools mounting volumes, with a scrubbed
+// environment, without a controlling TTY, with resource limits, and optionally in a read-only working directory, so
+// that misbehaving executables can neither read secrets of the driver, outlive their callers, nor exhaust the node.
+package sandbox
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Sandbox is how executables are run.
+type Sandbox struct {
+	// EnvAllowlist are names of environment variables of the driver passed to executables. Names ending with *
+	// match prefixes. Variables set in Env of commands are always passed.
+	EnvAllowlist []string
+	// CPUTime limits the CPU time of each process via RLIMIT_CPU. 0 means unlimited.
+	CPUTime time.Duration
+	// MemoryBytes limits the address space of each process via RLIMIT_AS. 0 means unlimited.
+	MemoryBytes uint64
+	// ReadOnlyDir runs executables in working directories which are read-only to them. Directories not on
+	// read-only mounts are remounted read-only in a private mount namespace, and executables fail to start if
+	// they can't be. Callers not requiring it check CanUnshareMounts first.
+	ReadOnlyDir bool
+}
+
+// Tools runs tools mounting volumes, which inherit locales and the search path of the driver only.
+var Tools = Sandbox{EnvAllowlist: []string{"PATH", "TMPDIR", "TZ", "LANG", "LC_*"}}
+
+// CommandContext returns the command running the executable in the sandbox. Like exec.CommandContext, the
+// executable is looked up in PATH if it contains no path separators, and the command is killed along with its
+// children once ctx is done.
+func (s Sandbox) CommandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = s.environ()
+	cmd.WaitDelay = time.Second
+	sandbox(cmd, s)
+	return cmd
+}
+
+// environ returns allowed environment variables of the driver.
+func (s Sandbox) environ() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		for _, allowed := range s.EnvAllowlist {
+			if prefix, isPrefix := strings.CutSuffix(allowed, "*"); (isPrefix && strings.HasPrefix(name, prefix)) ||
+				name == allowed {
+				env = append(env, kv)
+				break
+			}
+		}
+	}
+
+	return env
+}
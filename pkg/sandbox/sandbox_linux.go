@@ -0,0 +1,173 @@
+//go:build linux
+
+package sandbox
+
+// Resource limits and read-only working directories must be in place before executables start, so that they can't
+// be escaped by processes forked meanwhile. Commands thus re-exec the driver as a shim with the sandbox in its
+// environment, which applies them to itself, then execs the executable.
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// envShim is the sentinel env var that triggers the shim path of the re-exec child.
+	envShim = "_CSI_SANDBOX_SHIM"
+	// envExecutable, envCPUTime, envMemory, and envReadOnlyDir carry the command and the sandbox to the shim.
+	envExecutable  = "_CSI_SANDBOX_EXECUTABLE"
+	envCPUTime     = "_CSI_SANDBOX_CPU_SECONDS"
+	envMemory      = "_CSI_SANDBOX_MEMORY_BYTES"
+	envReadOnlyDir = "_CSI_SANDBOX_READONLY_DIR"
+)
+
+func init() {
+	if os.Getenv(envShim) != "1" {
+		return
+	}
+
+	if err := runShim(); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		os.Exit(127)
+	}
+}
+
+// sandbox makes cmd run via the shim in a new session, which has no controlling TTY and is killed along with the
+// driver or once the context of cmd is done.
+func sandbox(cmd *exec.Cmd, s Sandbox) {
+	if cmd.Err != nil {
+		return
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		cmd.Err = fmt.Errorf("unable to locate the sandbox shim: %w", err)
+		return
+	}
+
+	// The shim keeps argv of the command, and execs the executable looked up by the driver.
+	cmd.Env = append(cmd.Env, envShim+"=1", envExecutable+"="+cmd.Path)
+	cmd.Path = self
+	if s.CPUTime > 0 {
+		seconds := max(s.CPUTime.Round(time.Second), time.Second) / time.Second
+		cmd.Env = append(cmd.Env, envCPUTime+"="+strconv.FormatInt(int64(seconds), 10))
+	}
+
+	if s.MemoryBytes > 0 {
+		cmd.Env = append(cmd.Env, envMemory+"="+strconv.FormatUint(s.MemoryBytes, 10))
+	}
+
+	if s.ReadOnlyDir {
+		cmd.Env = append(cmd.Env, envReadOnlyDir+"=1")
+	}
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Pdeathsig: syscall.SIGKILL}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// CanUnshareMounts reports whether the driver has CAP_SYS_ADMIN, which private mount namespaces of read-only working
+// directories need.
+func CanUnshareMounts() bool {
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&header, &data[0]); err != nil {
+		return false
+	}
+
+	return data[unix.CAP_SYS_ADMIN/32].Effective&(1<<(unix.CAP_SYS_ADMIN%32)) != 0
+}
+
+// runShim applies the sandbox in the environment to the shim, then execs the executable with os.Args and without
+// the sandbox variables.
+func runShim() error {
+	// Mount namespaces are unshared per thread, which must be the one calling execve.
+	runtime.LockOSThread()
+
+	if os.Getenv(envReadOnlyDir) == "1" {
+		dir, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		if err = makeReadOnly(dir); err != nil {
+			return fmt.Errorf("unable to make the working directory %s read-only: %w", dir, err)
+		}
+	}
+
+	executable := os.Getenv(envExecutable)
+	var limits []func() error
+	for env, resource := range map[string]int{envCPUTime: unix.RLIMIT_CPU, envMemory: unix.RLIMIT_AS} {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+
+		limit, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %w", env, value, err)
+		}
+
+		limits = append(limits, func() error {
+			if err := unix.Setrlimit(resource, &unix.Rlimit{Cur: limit, Max: limit}); err != nil {
+				return fmt.Errorf("unable to set %s: %w", env, err)
+			}
+
+			return nil
+		})
+	}
+
+	for _, env := range []string{envShim, envExecutable, envCPUTime, envMemory, envReadOnlyDir} {
+		os.Unsetenv(env)
+	}
+
+	env := os.Environ()
+	// Limits are set right before execve, since the shim itself may not fit in them.
+	for _, limit := range limits {
+		if err := limit(); err != nil {
+			return err
+		}
+	}
+
+	return syscall.Exec(executable, os.Args, env)
+}
+
+// makeReadOnly remounts dir read-only in a private mount namespace of the calling thread, unless it is already on a
+// read-only mount.
+func makeReadOnly(dir string) error {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(dir, &stat); err != nil {
+		return err
+	}
+
+	if stat.Flags&unix.ST_RDONLY != 0 {
+		return nil
+	}
+
+	if err := unix.Unshare(unix.CLONE_NEWNS); err != nil {
+		return fmt.Errorf("unable to unshare the mount namespace: %w", err)
+	}
+
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return err
+	}
+
+	if err := unix.Mount(dir, dir, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return err
+	}
+
+	if err := unix.Mount("", dir, "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY, ""); err != nil {
+		return err
+	}
+
+	// The working directory still refers to the writable mount below the bind mount.
+	return unix.Chdir(dir)
+}
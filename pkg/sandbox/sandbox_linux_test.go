@@ -0,0 +1,110 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandContext(t *testing.T) {
+	t.Setenv("SANDBOX_TEST_ALLOWED", "allowed")
+	t.Setenv("SANDBOX_TEST_PREFIXED", "prefixed")
+	t.Setenv("SANDBOX_TEST_SECRET", "secret")
+
+	tests := []struct {
+		name    string
+		sandbox Sandbox
+		script  string
+		env     []string
+		output  string
+	}{
+		{
+			name:    "scrubbed environment",
+			sandbox: Sandbox{EnvAllowlist: []string{"PATH", "SANDBOX_TEST_ALLOWED", "SANDBOX_TEST_PREF*"}},
+			script:  `echo "$SANDBOX_TEST_ALLOWED,$SANDBOX_TEST_PREFIXED,$SANDBOX_TEST_SECRET,$SANDBOX_TEST_SET"`,
+			env:     []string{"SANDBOX_TEST_SET=set"},
+			output:  "allowed,prefixed,,set",
+		},
+		{
+			name:    "no sandbox variables",
+			sandbox: Sandbox{EnvAllowlist: []string{"PATH"}, CPUTime: time.Second, ReadOnlyDir: true},
+			script:  `env | grep -c _CSI_SANDBOX || true`,
+			output:  "0",
+		},
+		{
+			name:    "unlimited",
+			sandbox: Sandbox{EnvAllowlist: []string{"PATH"}},
+			script:  `echo "$(ulimit -t),$(ulimit -v)"`,
+			output:  "unlimited,unlimited",
+		},
+		{
+			name:    "limits",
+			sandbox: Sandbox{EnvAllowlist: []string{"PATH"}, CPUTime: 1500 * time.Millisecond, MemoryBytes: 1 << 30},
+			script:  `echo "$(ulimit -t),$(ulimit -v)"`,
+			output:  "2,1048576",
+		},
+		{
+			name:    "new session",
+			sandbox: Sandbox{EnvAllowlist: []string{"PATH"}},
+			script:  `[ "$(ps -o sid= -p $$)" -eq $$ ] && echo leader`,
+			output:  "leader",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := tt.sandbox.CommandContext(context.Background(), "sh", "-c", tt.script)
+			cmd.Env = append(cmd.Env, tt.env...)
+			output, err := cmd.CombinedOutput()
+			require.NoError(t, err, string(output))
+			assert.Equal(t, tt.output, strings.TrimSpace(string(output)))
+		})
+	}
+}
+
+func TestCommandContextReadOnlyDir(t *testing.T) {
+	dir := t.TempDir()
+	cmd := Sandbox{EnvAllowlist: []string{"PATH"}, ReadOnlyDir: true}.CommandContext(context.Background(),
+		"sh", "-c", "touch created")
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if strings.Contains(string(output), "unable to unshare the mount namespace") {
+		t.Skipf("mount namespaces can't be created: %s", output)
+	}
+
+	assert.Error(t, err, string(output))
+	assert.NoFileExists(t, filepath.Join(dir, "created"))
+
+	// The directory is only read-only to the sandbox.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "created"), nil, 0o644))
+}
+
+func TestCommandContextKillsSession(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	cmd := Tools.CommandContext(ctx, "sh", "-c", `sleep 30 & echo $! > "$0"; wait`, pidFile)
+	assert.Error(t, cmd.Run())
+
+	pid, err := os.ReadFile(pidFile)
+	require.NoError(t, err)
+	// Children of the killed helper are killed as well, though they may not be reaped yet.
+	assert.Eventually(t, func() bool {
+		stat, err := os.ReadFile(filepath.Join("/proc", strings.TrimSpace(string(pid)), "stat"))
+		return err != nil || strings.Contains(string(stat), ") Z ")
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestCommandContextNotFound(t *testing.T) {
+	err := Tools.CommandContext(context.Background(), "sandbox-test-not-found").Run()
+	assert.Error(t, err)
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// sandbox only scrubs the environment of cmd, and fails it if any limit is set, since processes are only limited
+// on Linux.
+func sandbox(cmd *exec.Cmd, s Sandbox) {
+	if cmd.Err == nil && (s.CPUTime > 0 || s.MemoryBytes > 0 || s.ReadOnlyDir) {
+		cmd.Err = errors.New("resource limits and read-only working directories are only supported on Linux")
+	}
+}
+
+// CanUnshareMounts is always false, since mount namespaces are only supported on Linux.
+func CanUnshareMounts() bool {
+	return false
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -21,6 +22,12 @@ type Store interface {
 	GetDockerKeyring(ctx context.Context, secretData map[string]string) (DockerKeyring, error)
 }
 
+// SourceLister is implemented by stores describing where their credentials come from, without the credentials
+type SourceLister interface {
+	// Sources returns the sources of credentials in priority order
+	Sources() []string
+}
+
 // secretDataWrapper abstracts data access for both byte slices and strings
 type secretDataWrapper interface {
 	Get(key string) (data []byte, existed bool)
@@ -130,6 +137,8 @@ func makeDockerKeyringFromMap(secretData map[string]string) (DockerKeyring, erro
 // keyringProvider is an interface for anything that can provide a DockerKeyring
 type keyringProvider interface {
 	GetKeyring(ctx context.Context) (DockerKeyring, error)
+	// String describes where the keyring comes from
+	String() string
 }
 
 // credentialStore is a unified credential store implementation
@@ -145,6 +154,20 @@ func (s credentialStore) GetDockerKeyring(ctx context.Context, secretData map[st
 	return s.createUnionKeyring(keyrings), nil
 }
 
+// Sources implements SourceLister
+func (s credentialStore) Sources() []string {
+	sources := []string{"secrets of volumes"}
+	if s.secretsFetcher != nil {
+		sources = append(sources, s.secretsFetcher.String())
+	}
+
+	if s.pluginsEnabled {
+		sources = append(sources, pluginSources()...)
+	}
+
+	return sources
+}
+
 // collectKeyrings gathers credentials from all available sources in priority order
 func (s credentialStore) collectKeyrings(ctx context.Context, secretData map[string]string) []DockerKeyring {
 	var keyrings []DockerKeyring
@@ -155,7 +178,7 @@ func (s credentialStore) collectKeyrings(ctx context.Context, secretData map[str
 		if err != nil {
 			klog.V(3).Infof("Failed to create keyring from volume context: %v", err)
 		} else if volumeKeyring != nil {
-			keyrings = append(keyrings, volumeKeyring)
+			keyrings = append(keyrings, WithSource(volumeKeyring, SourceVolumeSecrets))
 			klog.V(3).Info("Added volume context credentials to keyring")
 		}
 	}
@@ -166,14 +189,14 @@ func (s credentialStore) collectKeyrings(ctx context.Context, secretData map[str
 		if err != nil {
 			klog.V(3).Infof("Failed to get driver SA credentials: %v", err)
 		} else if secretKeyring != nil {
-			keyrings = append(keyrings, secretKeyring)
+			keyrings = append(keyrings, WithSource(secretKeyring, SourceDriverSecrets))
 			klog.V(3).Info("Added driver SA credentials to keyring")
 		}
 	}
 
 	// 3. Credential provider plugins (if enabled)
 	if s.pluginsEnabled {
-		keyrings = append(keyrings, &pluginDockerKeyring{})
+		keyrings = append(keyrings, WithSource(&pluginDockerKeyring{}, SourcePlugins))
 		klog.V(3).Info("Added plugin credentials to keyring")
 	}
 
@@ -200,7 +223,10 @@ type secretFetcher struct {
 }
 
 // Fetch gets secrets from the service account
-func (f secretFetcher) Fetch(ctx context.Context) ([]corev1.Secret, error) {
+func (f secretFetcher) Fetch(ctx context.Context) (secrets []corev1.Secret, err error) {
+	done := metrics.StartCredentialLookup(metrics.CredentialSourceSecret)
+	defer func() { done(err != nil) }()
+
 	sa, err := f.getServiceAccount(ctx)
 	if err != nil {
 		return nil, err
@@ -214,6 +240,10 @@ func (f secretFetcher) Fetch(ctx context.Context) ([]corev1.Secret, error) {
 	return f.getSecrets(ctx, sa.ImagePullSecrets)
 }
 
+func (f secretFetcher) String() string {
+	return fmt.Sprintf("imagePullSecrets of ServiceAccount %s/%s", f.Namespace, f.nodePluginSA)
+}
+
 // getServiceAccount retrieves the ServiceAccount specified in the configuration
 func (f secretFetcher) getServiceAccount(ctx context.Context) (*corev1.ServiceAccount, error) {
 	sa, err := f.Client.CoreV1().ServiceAccounts(f.Namespace).Get(ctx, f.nodePluginSA, metav1.GetOptions{})
@@ -301,6 +331,7 @@ func getCurrentNamespace() (string, error) {
 // cachedSecretsFetcher caches secrets for improved performance
 type cachedSecretsFetcher struct {
 	cachedKeyring DockerKeyring
+	source        string
 }
 
 // GetKeyring returns the pre-cached keyring
@@ -308,6 +339,10 @@ func (c cachedSecretsFetcher) GetKeyring(ctx context.Context) (DockerKeyring, er
 	return c.cachedKeyring, nil
 }
 
+func (c cachedSecretsFetcher) String() string {
+	return c.source + " cached at startup"
+}
+
 // pluginDockerKeyring is a DockerKeyring implementation that uses credential provider plugins
 type pluginDockerKeyring struct{}
 
@@ -395,7 +430,7 @@ func createCachedFetcher(fetcher *secretFetcher) keyringProvider {
 	}
 
 	klog.Info("Created cached secret store")
-	return &cachedSecretsFetcher{cachedKeyring: keyring}
+	return &cachedSecretsFetcher{cachedKeyring: keyring, source: fetcher.String()}
 }
 
 // initializeCredentialPlugins sets up credential provider plugins
@@ -0,0 +1,100 @@
+package secret
+
+import (
+	"sync"
+	"time"
+
+	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
+	cri "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// Cache key types of responses of credential provider plugins, which tell the images credentials are reused for.
+const (
+	cacheKeyTypeImage    = "Image"
+	cacheKeyTypeRegistry = "Registry"
+	cacheKeyTypeGlobal   = "Global"
+)
+
+// cachePolicy tells how long and for which images credentials returned by a plugin are reused.
+type cachePolicy struct {
+	keyType string
+	// duration is the cacheDuration of the response, or nil if it is not set, in which case the defaultCacheDuration
+	// of the plugin applies
+	duration *time.Duration
+}
+
+type cachedCredential struct {
+	auth    *cri.AuthConfig
+	expires time.Time
+}
+
+// pluginCredentialCache caches credentials of credential provider plugins as kubelet does, so that cloud registries
+// aren't asked for tokens on every pull. Credentials are cached for the cacheDuration of responses, or the
+// defaultCacheDuration of plugins, and never if it is 0.
+type pluginCredentialCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedCredential
+	now     func() time.Time
+}
+
+func newPluginCredentialCache() *pluginCredentialCache {
+	return &pluginCredentialCache{entries: map[string]cachedCredential{}, now: time.Now}
+}
+
+// pluginCredentials caches credentials of plugins invoked without service account tokens. Credentials of workloads
+// are never cached.
+var pluginCredentials = newPluginCredentialCache()
+
+// cacheKey returns the key of credentials of the plugin for the image with the key type.
+func cacheKey(plugin PluginConfig, image, keyType string) string {
+	switch keyType {
+	case cacheKeyTypeGlobal:
+		return plugin.Name + "/"
+	case cacheKeyTypeRegistry:
+		return plugin.Name + "/" + extractRegistryFromImage(image) + "/"
+	default:
+		return plugin.Name + "/" + image
+	}
+}
+
+// get returns the cached credential of the plugin for the image, and counts hits, misses, and expired credentials.
+func (c *pluginCredentialCache) get(plugin PluginConfig, image string) (*cri.AuthConfig, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := metrics.CredentialCacheMiss
+	for _, keyType := range []string{cacheKeyTypeImage, cacheKeyTypeRegistry, cacheKeyTypeGlobal} {
+		key := cacheKey(plugin, image, keyType)
+		entry, found := c.entries[key]
+		if !found {
+			continue
+		}
+
+		if c.now().Before(entry.expires) {
+			metrics.CredentialCacheCount.WithLabelValues(pluginSource(plugin), metrics.CredentialCacheHit).Inc()
+			return entry.auth, true
+		}
+
+		delete(c.entries, key)
+		result = metrics.CredentialCacheExpired
+	}
+
+	metrics.CredentialCacheCount.WithLabelValues(pluginSource(plugin), result).Inc()
+	return nil, false
+}
+
+// add caches the credential the plugin returned for the image by the policy of the response.
+func (c *pluginCredentialCache) add(plugin PluginConfig, image string, auth *cri.AuthConfig, policy cachePolicy) {
+	duration := plugin.DefaultCacheDuration
+	if policy.duration != nil {
+		duration = *policy.duration
+	}
+
+	if duration <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[cacheKey(plugin, image, policy.keyType)] = cachedCredential{auth: auth, expires: c.now().Add(duration)}
+}
@@ -0,0 +1,155 @@
+package secret
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/containers/ocicrypt"
+	"github.com/containers/ocicrypt/config"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"k8s.io/klog/v2"
+)
+
+// DecryptionKeyPrefix is the prefix of entries in secrets of volumes which are private keys to decrypt images.
+const DecryptionKeyPrefix = "decryption-key"
+
+// decryptionKeyFilePrefix prefixes files of keys installed by the driver in the key directory of the runtime.
+const decryptionKeyFilePrefix = "csi-"
+
+// DecryptionKeys returns the private keys in the secret data, keyed by their fingerprints.
+func DecryptionKeys(secretData map[string]string) map[string][]byte {
+	keys := make(map[string][]byte)
+	for name, data := range secretData {
+		if strings.HasPrefix(name, DecryptionKeyPrefix) && len(data) > 0 {
+			keys[fmt.Sprintf("%x", sha256.Sum256([]byte(data)))] = []byte(data)
+		}
+	}
+
+	return keys
+}
+
+// DecryptionKeyStore installs decryption keys to the directory the container runtime decrypts images with, while
+// images of the volumes providing them are pulled.
+type DecryptionKeyStore struct {
+	keysDir string
+	guard   sync.Mutex
+	// pulls counts requests using each installed key, keyed by fingerprints
+	pulls map[string]int
+}
+
+// NewDecryptionKeyStoreOrDie creates a store installing keys to keysDir. Keys left by previous runs are removed.
+func NewDecryptionKeyStoreOrDie(keysDir string) *DecryptionKeyStore {
+	if err := os.MkdirAll(keysDir, 0o700); err != nil {
+		klog.Fatalf("unable to create directory %s for decryption keys: %s", keysDir, err)
+	}
+
+	leftovers, err := filepath.Glob(filepath.Join(keysDir, decryptionKeyFilePrefix+"*"))
+	if err != nil {
+		klog.Fatalf("unable to list decryption keys in %s: %s", keysDir, err)
+	}
+
+	for _, file := range leftovers {
+		if err = os.Remove(file); err != nil {
+			klog.Fatalf("unable to remove decryption key %s: %s", file, err)
+		}
+	}
+
+	return &DecryptionKeyStore{keysDir: keysDir, pulls: make(map[string]int)}
+}
+
+// Install saves the keys to the key directory of the container runtime, so that they are available when
+// images are pulled and unpacked. Keys are named after their fingerprints. The returned function removes the keys
+// once no other requests use them, and must be called once images are pulled.
+func (s *DecryptionKeyStore) Install(keys map[string][]byte) (release func(), err error) {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+
+	installed := make([]string, 0, len(keys))
+	for fingerprint, key := range keys {
+		if s.pulls[fingerprint] == 0 {
+			if err = s.install(fingerprint, key); err != nil {
+				s.release(installed)
+				return nil, err
+			}
+		}
+
+		s.pulls[fingerprint]++
+		installed = append(installed, fingerprint)
+	}
+
+	return func() {
+		s.guard.Lock()
+		defer s.guard.Unlock()
+		s.release(installed)
+	}, nil
+}
+
+func (s *DecryptionKeyStore) keyFile(fingerprint string) string {
+	return filepath.Join(s.keysDir, decryptionKeyFilePrefix+fingerprint+".pem")
+}
+
+func (s *DecryptionKeyStore) install(fingerprint string, key []byte) error {
+	file := s.keyFile(fingerprint)
+	if err := os.WriteFile(file+".tmp", key, 0o600); err != nil {
+		return fmt.Errorf("unable to install decryption key: %w", err)
+	}
+
+	if err := os.Rename(file+".tmp", file); err != nil {
+		return fmt.Errorf("unable to install decryption key: %w", err)
+	}
+
+	klog.Infof("installed decryption key %s", fingerprint)
+	return nil
+}
+
+func (s *DecryptionKeyStore) release(fingerprints []string) {
+	for _, fingerprint := range fingerprints {
+		if s.pulls[fingerprint]--; s.pulls[fingerprint] > 0 {
+			continue
+		}
+
+		delete(s.pulls, fingerprint)
+		if err := os.Remove(s.keyFile(fingerprint)); err != nil && !os.IsNotExist(err) {
+			klog.Errorf("unable to remove decryption key %s: %s", fingerprint, err)
+			continue
+		}
+
+		klog.Infof("removed decryption key %s", fingerprint)
+	}
+}
+
+// AuthorizeDecryption checks that the keys can decrypt each encrypted layer, by unwrapping the keys of layers without
+// decrypting them. Images are decrypted once they are pulled and kept on nodes, so volumes must not mount images
+// pulled with keys of other volumes unless they provide keys of the images too.
+func AuthorizeDecryption(layers []ocispec.Descriptor, keys map[string][]byte) error {
+	if len(keys) == 0 {
+		return errors.New("image is encrypted but no decryption keys are given")
+	}
+
+	privateKeys := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		privateKeys = append(privateKeys, key)
+	}
+
+	cc, err := config.DecryptWithPrivKeys(privateKeys, make([][]byte, len(privateKeys)))
+	if err != nil {
+		return err
+	}
+
+	for _, layer := range layers {
+		if !strings.HasSuffix(layer.MediaType, "+encrypted") {
+			continue
+		}
+
+		if _, _, err = ocicrypt.DecryptLayer(cc.DecryptConfig, nil, layer, true); err != nil {
+			return fmt.Errorf("none of the given keys can decrypt layer %s: %w", layer.Digest, err)
+		}
+	}
+
+	return nil
+}
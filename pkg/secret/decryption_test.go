@@ -0,0 +1,109 @@
+package secret
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/ocicrypt"
+	"github.com/containers/ocicrypt/config"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newDecryptionKey returns a PEM encoded RSA private key, and its public key.
+func newDecryptionKey(t *testing.T) (private, public []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+}
+
+// encryptedLayer returns the descriptor of a layer encrypted for the public key.
+func encryptedLayer(t *testing.T, public []byte) ocispec.Descriptor {
+	cc, err := config.EncryptWithJwe([][]byte{public})
+	require.NoError(t, err)
+
+	data := []byte("layer")
+	desc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromBytes(data),
+		Size: int64(len(data))}
+	reader, finalize, err := ocicrypt.EncryptLayer(cc.EncryptConfig, bytes.NewReader(data), desc)
+	require.NoError(t, err)
+	_, err = io.Copy(io.Discard, reader)
+	require.NoError(t, err)
+
+	desc.Annotations, err = finalize()
+	require.NoError(t, err)
+	desc.MediaType += "+encrypted"
+	return desc
+}
+
+func TestAuthorizeDecryption(t *testing.T) {
+	private, public := newDecryptionKey(t)
+	otherPrivate, _ := newDecryptionKey(t)
+	layers := []ocispec.Descriptor{
+		{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromString("plain")},
+		encryptedLayer(t, public),
+	}
+
+	keys := DecryptionKeys(map[string]string{DecryptionKeyPrefix + ".pem": string(private)})
+	assert.NoError(t, AuthorizeDecryption(layers, keys))
+
+	// Any of the given keys may decrypt the image.
+	keys = DecryptionKeys(map[string]string{
+		DecryptionKeyPrefix + "-other.pem": string(otherPrivate),
+		DecryptionKeyPrefix + ".pem":       string(private),
+	})
+	assert.NoError(t, AuthorizeDecryption(layers, keys))
+
+	// Keys which can't unwrap keys of layers are refused, even if the image is already decrypted on the node.
+	keys = DecryptionKeys(map[string]string{DecryptionKeyPrefix + ".pem": string(otherPrivate)})
+	assert.ErrorContains(t, AuthorizeDecryption(layers, keys), "none of the given keys can decrypt layer")
+	assert.ErrorContains(t, AuthorizeDecryption(layers, nil), "no decryption keys are given")
+}
+
+func TestDecryptionKeyStore(t *testing.T) {
+	dir := t.TempDir()
+	leftover := filepath.Join(dir, decryptionKeyFilePrefix+"leftover.pem")
+	require.NoError(t, os.WriteFile(leftover, []byte("key"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "runtime.pem"), []byte("key"), 0o600))
+
+	// Keys left by previous runs are removed, and those of the runtime are kept.
+	s := NewDecryptionKeyStoreOrDie(dir)
+	assert.NoFileExists(t, leftover)
+	assert.FileExists(t, filepath.Join(dir, "runtime.pem"))
+
+	keys := DecryptionKeys(map[string]string{DecryptionKeyPrefix: "key-1"})
+	release, err := s.Install(keys)
+	require.NoError(t, err)
+	releaseAgain, err := s.Install(keys)
+	require.NoError(t, err)
+
+	var file string
+	for fingerprint := range keys {
+		file = s.keyFile(fingerprint)
+	}
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "key-1", string(data))
+
+	// Keys are kept until no pull uses them.
+	release()
+	assert.FileExists(t, file)
+	releaseAgain()
+	assert.NoFileExists(t, file)
+	assert.Empty(t, s.pulls)
+}
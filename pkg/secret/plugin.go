@@ -5,11 +5,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
 	cri "k8s.io/cri-api/pkg/apis/runtime/v1"
 	"k8s.io/klog/v2"
 )
@@ -39,6 +41,10 @@ type CredentialProvider struct {
 	Args []string `json:"args,omitempty"`
 	// Env are the optional environment variables to set for the plugin.
 	Env []EnvVar `json:"env,omitempty"`
+	// TokenAttributes passes service account tokens of pods to the plugin, so that it authenticates as workloads.
+	TokenAttributes *ServiceAccountTokenAttributes `json:"tokenAttributes,omitempty"`
+	// DefaultCacheDuration is how long credentials are cached if responses of the plugin don't tell, e.g. 12h.
+	DefaultCacheDuration string `json:"defaultCacheDuration,omitempty"`
 }
 
 // EnvVar represents an environment variable present in a Container.
@@ -74,6 +80,12 @@ type PluginConfig struct {
 	Env         []EnvVar
 	APIVersion  string
 	MatchImages []string
+	// TokenAudience is the audience of service account tokens passed to the plugin, if any.
+	TokenAudience string
+	// RequireServiceAccount skips the plugin if no token of TokenAudience is available.
+	RequireServiceAccount bool
+	// DefaultCacheDuration is how long credentials are cached if responses don't tell. 0 disables caching.
+	DefaultCacheDuration time.Duration
 }
 
 // RegisterCredentialProviderPlugins reads the specified config file and registers
@@ -109,9 +121,7 @@ func RegisterCredentialProviderPlugins(configFilePath, executableDir string) err
 			continue
 		}
 
-		// Register the plugin
-		registeredPluginsLock.Lock()
-		registeredPlugins[provider.Name] = PluginConfig{
+		plugin := PluginConfig{
 			Name:        provider.Name,
 			Executable:  executable,
 			Args:        provider.Args,
@@ -119,6 +129,28 @@ func RegisterCredentialProviderPlugins(configFilePath, executableDir string) err
 			APIVersion:  provider.APIVersion,
 			MatchImages: provider.MatchImages,
 		}
+
+		if provider.DefaultCacheDuration != "" {
+			if plugin.DefaultCacheDuration, err = time.ParseDuration(provider.DefaultCacheDuration); err != nil {
+				klog.Warningf("Invalid defaultCacheDuration of credential provider %s, credentials won't be cached: %v",
+					provider.Name, err)
+			}
+		}
+
+		if attrs := provider.TokenAttributes; attrs != nil {
+			// Docker credential helpers only receive server URLs.
+			if isDockerCredentialHelper(executable) || attrs.ServiceAccountTokenAudience == "" {
+				klog.Warningf("Credential provider %s can't receive service account tokens, skipping", provider.Name)
+				continue
+			}
+
+			plugin.TokenAudience = attrs.ServiceAccountTokenAudience
+			plugin.RequireServiceAccount = attrs.RequireServiceAccount == nil || *attrs.RequireServiceAccount
+		}
+
+		// Register the plugin
+		registeredPluginsLock.Lock()
+		registeredPlugins[provider.Name] = plugin
 		registeredPluginsLock.Unlock()
 
 		klog.Infof("Registered credential provider %s at path %s", provider.Name, executable)
@@ -133,6 +165,12 @@ func RegisterCredentialProviderPlugins(configFilePath, executableDir string) err
 // This function is thread-safe and may be called concurrently for different images.
 // Plugins are executed sequentially in registration order until one returns credentials.
 func GetCredentialFromPlugin(image string) (*cri.AuthConfig, error) {
+	return getCredentialFromPlugin(image, nil)
+}
+
+// getCredentialFromPlugin only invokes plugins with service account tokens if tokens is not nil, passing each the
+// token of its audience. Otherwise, plugins requiring tokens are skipped.
+func getCredentialFromPlugin(image string, tokens map[string]ServiceAccountToken) (*cri.AuthConfig, error) {
 	registeredPluginsLock.RLock()
 	defer registeredPluginsLock.RUnlock()
 
@@ -151,17 +189,48 @@ func GetCredentialFromPlugin(image string) (*cri.AuthConfig, error) {
 			continue
 		}
 
+		var token string
+		if tokens != nil {
+			saToken, found := tokens[plugin.TokenAudience]
+			if plugin.TokenAudience == "" || !found || saToken.Token == "" {
+				continue
+			}
+
+			if !saToken.ExpirationTimestamp.IsZero() && saToken.ExpirationTimestamp.Before(time.Now()) {
+				klog.V(2).Infof("Service account token of audience %s for plugin %s is expired, skipping",
+					plugin.TokenAudience, name)
+				continue
+			}
+
+			token = saToken.Token
+		} else if plugin.RequireServiceAccount {
+			klog.V(4).Infof("Plugin %s requires service account tokens, skipping", name)
+			continue
+		}
+
+		// Docker credential helpers cache credentials themselves, and credentials of workloads are never cached.
+		cacheable := !isDockerCredentialHelper(plugin.Executable) && token == ""
+		if cacheable {
+			if auth, found := pluginCredentials.get(plugin, image); found {
+				klog.V(3).Infof("Plugin %s returned cached credentials for image", name)
+				return auth, nil
+			}
+		}
+
 		klog.V(4).Infof("Trying credential plugin %s for image %s", name, image)
 
 		var auth *cri.AuthConfig
+		var policy cachePolicy
 		var err error
 
 		// Handle different plugin types
+		done := metrics.StartCredentialLookup(pluginSource(plugin))
 		if isDockerCredentialHelper(plugin.Executable) {
 			auth, err = callDockerCredentialHelper(plugin, image)
 		} else {
-			auth, err = callCustomPlugin(plugin, image)
+			auth, policy, err = callCustomPlugin(plugin, image, token)
 		}
+		done(err != nil)
 
 		if err != nil {
 			klog.V(2).Infof("Plugin %s failed: %v", name, err)
@@ -170,6 +239,9 @@ func GetCredentialFromPlugin(image string) (*cri.AuthConfig, error) {
 
 		if auth != nil {
 			klog.V(3).Infof("Plugin %s returned valid credentials for image", name)
+			if cacheable {
+				pluginCredentials.add(plugin, image, auth, policy)
+			}
 			return auth, nil
 		}
 	}
@@ -178,6 +250,26 @@ func GetCredentialFromPlugin(image string) (*cri.AuthConfig, error) {
 	return nil, nil
 }
 
+// pluginSources describes registered plugins by their names, images they match, and audiences of service account
+// tokens they receive. Their executables, args, and env are left out as they may carry secrets.
+func pluginSources() []string {
+	registeredPluginsLock.RLock()
+	defer registeredPluginsLock.RUnlock()
+
+	sources := make([]string, 0, len(registeredPlugins))
+	for name, plugin := range registeredPlugins {
+		source := fmt.Sprintf("credential provider plugin %s for images %q", name, plugin.MatchImages)
+		if plugin.TokenAudience != "" {
+			source += fmt.Sprintf(" with service account tokens of audience %q", plugin.TokenAudience)
+		}
+
+		sources = append(sources, source)
+	}
+
+	sort.Strings(sources)
+	return sources
+}
+
 // matchesImagePattern checks if an image matches any of the provided patterns
 // Patterns can include wildcards like *.dkr.ecr.*.amazonaws.com
 func matchesImagePattern(image string, patterns []string) bool {
@@ -278,6 +370,22 @@ func wildcardMatch(s, pattern string) bool {
 	return true
 }
 
+// pluginSource classifies the plugin by the cloud registry it serves credentials of, so that latencies of cloud
+// metadata endpoints can be told apart. Other plugins are reported as metrics.CredentialSourcePlugin.
+func pluginSource(plugin PluginConfig) string {
+	base := strings.ToLower(filepath.Base(plugin.Executable))
+	switch {
+	case isECRCredentialHelper(plugin.Executable) || strings.Contains(base, "ecr"):
+		return metrics.CredentialSourceECR
+	case strings.Contains(base, "gcr") || strings.Contains(base, "gcp"):
+		return metrics.CredentialSourceGCR
+	case strings.Contains(base, "acr") || strings.Contains(base, "azure"):
+		return metrics.CredentialSourceACR
+	default:
+		return metrics.CredentialSourcePlugin
+	}
+}
+
 // isDockerCredentialHelper determines if the executable is a Docker credential helper
 func isDockerCredentialHelper(executable string) bool {
 	return strings.HasPrefix(filepath.Base(executable), "docker-credential-")
@@ -370,42 +478,15 @@ func hasEnv(env []EnvVar, name string) bool {
 	return false
 }
 
-// executeCredentialHelper runs the credential helper and returns its output
+// executeCredentialHelper runs the credential helper in the sandbox and returns its output
 func executeCredentialHelper(plugin PluginConfig, serverURL string) ([]byte, string, error) {
-	// Docker credential helpers expect the "get" command
-	cmd := exec.Command(plugin.Executable, "get")
-
-	// Set up pipes for stdin/stdout/stderr
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return nil, "", fmt.Errorf("failed to create stdin pipe: %w", err)
-	}
-
 	var stdout, stderr strings.Builder
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	// Set environment variables
-	cmd.Env = os.Environ()
-	for _, env := range plugin.Env {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", env.Name, env.Value))
-	}
-
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		return nil, stderr.String(), fmt.Errorf("failed to start: %w", err)
-	}
 
-	// Write server URL to stdin followed by newline as required by Docker credential helper protocol
+	// Docker credential helpers expect the "get" command, and the server URL on stdin followed by newline.
 	// See: https://github.com/docker/docker-credential-helpers#development
-	if _, err := stdin.Write([]byte(serverURL + "\n")); err != nil {
-		_ = cmd.Process.Kill() // Kill process on error
-		return nil, stderr.String(), fmt.Errorf("failed to write to stdin: %w", err)
-	}
-	stdin.Close()
-
-	// Wait for the command to complete
-	if err := cmd.Wait(); err != nil {
+	err := helperSandbox.run(plugin.Executable, []string{"get"}, plugin.Env, strings.NewReader(serverURL+"\n"),
+		&stdout, &stderr)
+	if err != nil {
 		// Include stderr in error for better debugging
 		if stderr.String() != "" {
 			return nil, stderr.String(), fmt.Errorf("plugin execution failed: %w (stderr: %s)", err, stderr.String())
@@ -456,8 +537,9 @@ func parseDockerCredentialHelperOutput(pluginName string, output []byte, serverU
 	return auth, nil
 }
 
-// callCustomPlugin executes a custom credential plugin that uses the --image parameter
-func callCustomPlugin(plugin PluginConfig, image string) (*cri.AuthConfig, error) {
+// callCustomPlugin executes a custom credential plugin that uses the --image parameter.
+// The service account token is passed if not empty. Returns the cache policy of the response along with credentials.
+func callCustomPlugin(plugin PluginConfig, image, token string) (*cri.AuthConfig, cachePolicy, error) {
 	klog.V(4).Infof("Executing custom credential plugin: %s for image %s", plugin.Name, image)
 
 	// Prepare the request JSON according to Kubernetes credential provider spec
@@ -466,55 +548,47 @@ func callCustomPlugin(plugin PluginConfig, image string) (*cri.AuthConfig, error
 		"kind":       "CredentialProviderRequest",
 		"image":      image,
 	}
+	if token != "" {
+		request["serviceAccountToken"] = token
+	}
 	requestJSON, err := json.Marshal(request)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal plugin request: %w", err)
-	}
-
-	// Set up the command with configured args only (no --image flag!)
-	cmd := exec.Command(plugin.Executable, plugin.Args...)
-
-	// Set environment variables
-	cmd.Env = os.Environ()
-	for _, env := range plugin.Env {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", env.Name, env.Value))
+		return nil, cachePolicy{}, fmt.Errorf("failed to marshal plugin request: %w", err)
 	}
 
-	// Send request via stdin
-	cmd.Stdin = strings.NewReader(string(requestJSON))
-
-	// Set up stderr capture
-	var stderr strings.Builder
-	cmd.Stderr = &stderr
-
-	// Execute the command
-	output, err := cmd.Output()
+	// Run the plugin in the sandbox with configured args only (no --image flag!), sending the request via stdin
+	var stdout, stderr strings.Builder
+	err = helperSandbox.run(plugin.Executable, plugin.Args, plugin.Env, strings.NewReader(string(requestJSON)),
+		&stdout, &stderr)
 	if err != nil {
 		stderrOutput := stderr.String()
 		if stderrOutput != "" {
 			klog.V(2).Infof("Plugin %s stderr output: %s", plugin.Name, stderrOutput)
 		}
-		return nil, fmt.Errorf("failed to execute plugin %s: %w", plugin.Name, err)
+		return nil, cachePolicy{}, fmt.Errorf("failed to execute plugin %s: %w", plugin.Name, err)
 	}
 
-	return parseCredentialProviderResponse(plugin.Name, output)
+	return parseCredentialProviderResponse(plugin.Name, []byte(stdout.String()))
 }
 
 // parseCustomPluginOutput processes the output from a custom credential plugin
 func parseCustomPluginOutput(pluginName string, output []byte) (*cri.AuthConfig, error) {
-	return parseCredentialProviderResponse(pluginName, output)
+	auth, _, err := parseCredentialProviderResponse(pluginName, output)
+	return auth, err
 }
 
-// parseCredentialProviderResponse parses the Kubernetes credential provider plugin response
-func parseCredentialProviderResponse(pluginName string, output []byte) (*cri.AuthConfig, error) {
+// parseCredentialProviderResponse parses the Kubernetes credential provider plugin response, and its cache policy
+func parseCredentialProviderResponse(pluginName string, output []byte) (*cri.AuthConfig, cachePolicy, error) {
 	// Don't log output details as they may contain credentials
 	klog.V(4).Infof("Plugin %s returned output", pluginName)
 
 	// Parse the Kubernetes credential provider response format
 	var response struct {
-		APIVersion string `json:"apiVersion"`
-		Kind       string `json:"kind"`
-		Auth       map[string]struct {
+		APIVersion    string  `json:"apiVersion"`
+		Kind          string  `json:"kind"`
+		CacheKeyType  string  `json:"cacheKeyType"`
+		CacheDuration *string `json:"cacheDuration"`
+		Auth          map[string]struct {
 			Username string `json:"username"`
 			Password string `json:"password"`
 		} `json:"auth"`
@@ -523,13 +597,23 @@ func parseCredentialProviderResponse(pluginName string, output []byte) (*cri.Aut
 	// Trim any leading/trailing whitespace
 	outputStr := strings.TrimSpace(string(output))
 	if err := json.Unmarshal([]byte(outputStr), &response); err != nil {
-		return nil, fmt.Errorf("failed to parse plugin %s output: %w", pluginName, err)
+		return nil, cachePolicy{}, fmt.Errorf("failed to parse plugin %s output: %w", pluginName, err)
+	}
+
+	policy := cachePolicy{keyType: response.CacheKeyType}
+	if response.CacheDuration != nil {
+		duration, err := time.ParseDuration(*response.CacheDuration)
+		if err != nil {
+			klog.Warningf("Plugin %s returned invalid cacheDuration, credentials won't be cached: %v", pluginName, err)
+		}
+
+		policy.duration = &duration
 	}
 
 	// If no auth was returned
 	if len(response.Auth) == 0 {
 		klog.V(4).Infof("Plugin %s returned no credentials", pluginName)
-		return nil, nil
+		return nil, policy, nil
 	}
 
 	// Get the first (and typically only) auth entry
@@ -547,10 +631,10 @@ func parseCredentialProviderResponse(pluginName string, output []byte) (*cri.Aut
 			Username: auth.Username,
 			Password: auth.Password,
 			Auth:     authEncoded,
-		}, nil
+		}, policy, nil
 	}
 
-	return nil, nil
+	return nil, policy, nil
 }
 
 // extractServerURL extracts the server/registry URL from an image reference
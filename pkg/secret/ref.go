@@ -0,0 +1,75 @@
+package secret
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// NewClient creates a Kubernetes client using the service account of the driver.
+func NewClient() (kubernetes.Interface, error) {
+	config, err := getKubernetesConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return kubernetes.NewForConfig(config)
+}
+
+// FetchKeyring returns a keyring with credentials of the image pull secret namespace/name.
+func FetchKeyring(
+	ctx context.Context, client kubernetes.Interface, namespace, name string,
+) (keyring DockerKeyring, err error) {
+	done := metrics.StartCredentialLookup(metrics.CredentialSourceSecret)
+	defer func() { done(err != nil) }()
+
+	secret, err := client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch secret %s/%s: %w", namespace, name, err)
+	}
+
+	cred, err := parseDockerConfigFromSecretData(byteSecretData(secret.Data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret %s/%s: %w", namespace, name, err)
+	}
+
+	if cred == nil {
+		return nil, fmt.Errorf("secret %s/%s is not an image pull secret", namespace, name)
+	}
+
+	basic := &BasicDockerKeyring{}
+	basic.Add(cred)
+	return basic, nil
+}
+
+// FetchServiceAccountKeyring returns a keyring with credentials of the image pull secrets of the service account.
+// Secrets that can't be fetched are skipped.
+func FetchServiceAccountKeyring(
+	ctx context.Context, client kubernetes.Interface, namespace, serviceAccount string,
+) (keyring DockerKeyring, err error) {
+	done := metrics.StartCredentialLookup(metrics.CredentialSourceSecret)
+	defer func() { done(err != nil) }()
+
+	sa, err := client.CoreV1().ServiceAccounts(namespace).Get(ctx, serviceAccount, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch service account %s/%s: %w", namespace, serviceAccount, err)
+	}
+
+	secrets := make([]corev1.Secret, 0, len(sa.ImagePullSecrets))
+	for _, ref := range sa.ImagePullSecrets {
+		secret, err := client.CoreV1().Secrets(namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			klog.Errorf("unable to fetch secret %s/%s: %s", namespace, ref.Name, err)
+			continue
+		}
+
+		secrets = append(secrets, *secret)
+	}
+
+	return makeDockerKeyringFromSecrets(secrets)
+}
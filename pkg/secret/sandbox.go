@@ -0,0 +1,82 @@
+package secret
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/warm-metal/container-image-csi-driver/pkg/sandbox"
+	"k8s.io/klog/v2"
+)
+
+// DefaultHelperEnvAllowlist are environment variables of the driver passed to credential helpers by default, i.e.
+// those of locales, proxies, CA certificates, and credentials of cloud providers, e.g. IRSA of ECR helpers.
+var DefaultHelperEnvAllowlist = []string{
+	"PATH", "HOME", "USER", "TMPDIR", "TZ", "LANG", "LC_*",
+	"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy",
+	"SSL_CERT_FILE", "SSL_CERT_DIR",
+	"AWS_*", "AZURE_*", "GOOGLE_*", "KUBERNETES_SERVICE_HOST", "KUBERNETES_SERVICE_PORT",
+}
+
+// HelperSandbox contains credential provider plugins and docker credential helpers the driver executes, so that
+// misbehaving helpers can neither read secrets in the environment of the driver, hang pulls, nor exhaust the node.
+// Helpers run in their own session without a controlling TTY, and in the directory of their executables, which is
+// read-only to them if the driver can unshare mount namespaces or the directory is on a read-only mount.
+type HelperSandbox struct {
+	// EnvAllowlist are names of environment variables of the driver passed to helpers besides those configured of
+	// each plugin. Names ending with * match prefixes.
+	EnvAllowlist []string
+	// Timeout kills helpers running longer, along with their children. 0 means no timeout.
+	Timeout time.Duration
+	// CPUTime limits the CPU time of each helper process via RLIMIT_CPU. 0 means unlimited.
+	CPUTime time.Duration
+	// MemoryBytes limits the address space of each helper process via RLIMIT_AS. 0 means unlimited.
+	MemoryBytes uint64
+}
+
+// helperSandbox is set on startup before any helper runs.
+var helperSandbox = HelperSandbox{EnvAllowlist: DefaultHelperEnvAllowlist, Timeout: time.Minute}
+
+// SetHelperSandbox sets the sandbox of all credential helpers. It must be called before stores are created.
+func SetHelperSandbox(s HelperSandbox) {
+	helperSandbox = s
+	if !sandbox.CanUnshareMounts() {
+		klog.Warning("directories of credential helpers are only read-only to them if they are on read-only mounts, " +
+			"since the driver can't unshare mount namespaces without CAP_SYS_ADMIN")
+	}
+}
+
+// run runs the executable in the sandbox with the allowed environment of the driver along with env, and waits for
+// it to exit.
+func (s HelperSandbox) run(
+	executable string, args []string, env []EnvVar, stdin io.Reader, stdout, stderr io.Writer,
+) error {
+	ctx := context.Background()
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	cmd := sandbox.Sandbox{
+		EnvAllowlist: s.EnvAllowlist,
+		CPUTime:      s.CPUTime,
+		MemoryBytes:  s.MemoryBytes,
+		// Helpers would fail to start if their directories can't be remounted read-only.
+		ReadOnlyDir: sandbox.CanUnshareMounts(),
+	}.CommandContext(ctx, executable, args...)
+	cmd.Dir = filepath.Dir(executable)
+	for _, e := range env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", e.Name, e.Value))
+	}
+
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, stdout, stderr
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return fmt.Errorf("killed after %s: %w", s.Timeout, err)
+	}
+
+	return err
+}
@@ -0,0 +1,78 @@
+//go:build linux
+
+package secret
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warm-metal/container-image-csi-driver/pkg/sandbox"
+)
+
+func TestHelperSandboxRun(t *testing.T) {
+	t.Setenv("HELPER_TEST_SECRET", "secret")
+
+	tests := []struct {
+		name    string
+		sandbox HelperSandbox
+		script  string
+		env     []EnvVar
+		unshare bool
+		output  string
+		err     string
+	}{
+		{
+			name:    "env of plugins",
+			sandbox: HelperSandbox{EnvAllowlist: []string{"PATH"}},
+			script:  `read -r input; echo "$input,$PLUGIN_ENV,$HELPER_TEST_SECRET,$(pwd)"`,
+			env:     []EnvVar{{Name: "PLUGIN_ENV", Value: "plugin"}},
+			output:  "request,plugin,,",
+		},
+		{
+			name:    "timeout",
+			sandbox: HelperSandbox{EnvAllowlist: []string{"PATH"}, Timeout: 100 * time.Millisecond},
+			script:  "sleep 30",
+			err:     "killed after 100ms",
+		},
+		{
+			name:    "read-only working directory",
+			sandbox: HelperSandbox{EnvAllowlist: []string{"PATH"}},
+			script:  "touch created",
+			unshare: true,
+			err:     "exit status 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.unshare && !sandbox.CanUnshareMounts() {
+				t.Skip("directories of helpers aren't remounted read-only without CAP_SYS_ADMIN")
+			}
+
+			dir := t.TempDir()
+			helper := filepath.Join(dir, "helper")
+			require.NoError(t, os.WriteFile(helper, []byte("#!/bin/sh\n"+tt.script+"\n"), 0o755))
+
+			var stdout, stderr strings.Builder
+			err := tt.sandbox.run(helper, nil, tt.env, strings.NewReader("request\n"), &stdout, &stderr)
+			if strings.Contains(stderr.String(), "unable to unshare the mount namespace") {
+				t.Skipf("mount namespaces can't be created: %s", stderr.String())
+			}
+
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				assert.NoFileExists(t, filepath.Join(dir, "created"))
+				return
+			}
+
+			require.NoError(t, err, stderr.String())
+			assert.Equal(t, tt.output+dir, strings.TrimSpace(stdout.String()))
+		})
+	}
+}
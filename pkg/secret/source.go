@@ -0,0 +1,100 @@
+package secret
+
+import (
+	"fmt"
+
+	cri "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// Sources of credentials of the node plugin.
+const (
+	SourceVolumeSecrets       = "volume-secrets"
+	SourceDriverSecrets       = "driver-service-account"
+	SourcePlugins             = "credential-provider-plugins"
+	SourceServiceAccountToken = "service-account-token-plugins"
+	SourceUnknown             = "unknown"
+)
+
+// SecretSource is the source of credentials of the image pull secret namespace/name.
+func SecretSource(namespace, name string) string {
+	return fmt.Sprintf("secret:%s/%s", namespace, name)
+}
+
+// ServiceAccountSource is the source of credentials of image pull secrets of the service account namespace/name.
+func ServiceAccountSource(namespace, name string) string {
+	return fmt.Sprintf("service-account:%s/%s", namespace, name)
+}
+
+// Credential is a registry credential along with the source it is found in.
+type Credential struct {
+	Auth *cri.AuthConfig
+	// Source names where the credential is found, which is never secret
+	Source string
+}
+
+// SourcedKeyring is a DockerKeyring which tells the sources of its credentials.
+type SourcedKeyring interface {
+	DockerKeyring
+	// LookupWithSources returns the registry credentials for the specified image along with their sources.
+	LookupWithSources(image string) ([]Credential, bool)
+}
+
+// LookupWithSources returns credentials of keyring for the image in the order of Lookup, with their sources if
+// keyring tells them, or SourceUnknown.
+func LookupWithSources(keyring DockerKeyring, image string) ([]Credential, bool) {
+	if sourced, ok := keyring.(SourcedKeyring); ok {
+		return sourced.LookupWithSources(image)
+	}
+
+	auths, found := keyring.Lookup(image)
+	credentials := make([]Credential, 0, len(auths))
+	for _, auth := range auths {
+		credentials = append(credentials, Credential{Auth: auth, Source: SourceUnknown})
+	}
+
+	return credentials, found
+}
+
+// WithSource returns keyring telling that all its credentials are found in source.
+func WithSource(keyring DockerKeyring, source string) DockerKeyring {
+	return sourcedKeyring{keyring: keyring, source: source}
+}
+
+type sourcedKeyring struct {
+	keyring DockerKeyring
+	source  string
+}
+
+// Lookup implements DockerKeyring.
+func (dk sourcedKeyring) Lookup(image string) ([]*cri.AuthConfig, bool) {
+	return dk.keyring.Lookup(image)
+}
+
+// LookupWithSources implements SourcedKeyring.
+func (dk sourcedKeyring) LookupWithSources(image string) ([]Credential, bool) {
+	auths, found := dk.keyring.Lookup(image)
+	credentials := make([]Credential, 0, len(auths))
+	for _, auth := range auths {
+		credentials = append(credentials, Credential{Auth: auth, Source: dk.source})
+	}
+
+	return credentials, found
+}
+
+// LookupWithSources implements SourcedKeyring.
+func (dk UnionDockerKeyring) LookupWithSources(image string) ([]Credential, bool) {
+	var credentials []Credential
+	found := false
+	for _, subKeyring := range dk {
+		if subKeyring == nil {
+			continue
+		}
+
+		if subCredentials, ok := LookupWithSources(subKeyring, image); ok {
+			credentials = append(credentials, subCredentials...)
+			found = true
+		}
+	}
+
+	return credentials, found
+}
@@ -0,0 +1,68 @@
+package secret
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	cri "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/klog/v2"
+)
+
+// ServiceAccountTokensKey is the volume attribute, or the secret entry if serviceAccountTokenInSecrets of the
+// CSIDriver is set, kubelet passes service account tokens of pods in, once tokenRequests of the CSIDriver is set.
+const ServiceAccountTokensKey = "csi.storage.k8s.io/serviceAccount.tokens"
+
+// ServiceAccountTokenAttributes configures the service account token passed to a credential provider plugin, as
+// tokenAttributes of kubelet's CredentialProviderConfig.
+type ServiceAccountTokenAttributes struct {
+	// ServiceAccountTokenAudience is the audience of the token, which must be in tokenRequests of the CSIDriver.
+	ServiceAccountTokenAudience string `json:"serviceAccountTokenAudience"`
+	// RequireServiceAccount requires the token to invoke the plugin. Defaults to true, so that the plugin is never
+	// invoked with credentials of the driver instead of those of the pod.
+	RequireServiceAccount *bool `json:"requireServiceAccount,omitempty"`
+}
+
+// ServiceAccountToken is a token kubelet requests for the service account of a pod.
+type ServiceAccountToken struct {
+	Token               string    `json:"token"`
+	ExpirationTimestamp time.Time `json:"expirationTimestamp"`
+}
+
+// ParseServiceAccountTokens parses tokens passed by kubelet, keyed by their audiences.
+func ParseServiceAccountTokens(data string) (map[string]ServiceAccountToken, error) {
+	tokens := make(map[string]ServiceAccountToken)
+	if err := json.Unmarshal([]byte(data), &tokens); err != nil {
+		return nil, fmt.Errorf("invalid service account tokens: %w", err)
+	}
+
+	return tokens, nil
+}
+
+// serviceAccountTokenKeyring is a DockerKeyring invoking credential provider plugins with service account tokens of
+// a pod, so that registries authorize pulls per workload.
+type serviceAccountTokenKeyring struct {
+	tokens map[string]ServiceAccountToken
+}
+
+// NewServiceAccountTokenKeyring returns a keyring of plugins configured with tokenAttributes, which receive the
+// tokens of their audiences. Plugins without tokens are left to the keyring of the store.
+func NewServiceAccountTokenKeyring(tokens map[string]ServiceAccountToken) DockerKeyring {
+	return serviceAccountTokenKeyring{tokens: tokens}
+}
+
+// Lookup implements DockerKeyring.
+func (dk serviceAccountTokenKeyring) Lookup(image string) ([]*cri.AuthConfig, bool) {
+	auth, err := getCredentialFromPlugin(image, dk.tokens)
+	if err != nil {
+		klog.Warningf("Error getting credentials from plugin with service account tokens for image %s: %v", image, err)
+		return nil, false
+	}
+
+	if auth != nil {
+		klog.V(2).Infof("Found credentials for image %s using service account tokens", image)
+		return []*cri.AuthConfig{auth}, true
+	}
+
+	return nil, false
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"strings"
 
+	"github.com/warm-metal/container-image-csi-driver/pkg/metrics"
 	cri "k8s.io/cri-api/pkg/apis/runtime/v1"
 	"k8s.io/klog/v2"
 )
@@ -41,6 +42,9 @@ func (dk *BasicDockerKeyring) Add(cfg DockerConfig) {
 
 // Lookup implements DockerKeyring.
 func (dk *BasicDockerKeyring) Lookup(image string) ([]*cri.AuthConfig, bool) {
+	done := metrics.StartCredentialLookup(metrics.CredentialSourceSecret)
+	defer done(false)
+
 	// Strip any tag/digest from the image name - we don't include this
 	// when matching against the credentials.
 	var registryURL string
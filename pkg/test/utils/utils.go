@@ -24,11 +24,27 @@ type MockMounter struct {
 const hundredMB = 104857600
 
 func (m *MockMounter) Mount(
-	ctx context.Context, volumeId string, target backend.MountTarget, image reference.Named, ro bool) (err error) {
+	ctx context.Context, volumeId string, target backend.MountTarget, image reference.Named,
+	opts backend.MountOptions) (err error) {
 	m.Mounted[volumeId] = true
 	return nil
 }
 
+func (m *MockMounter) Publish(
+	ctx context.Context, volumeId string, stagingTarget, target backend.MountTarget, image reference.Named,
+	opts backend.MountOptions, readOnly bool) error {
+	m.Mounted[volumeId] = true
+	return nil
+}
+
+func (m *MockMounter) RemoveScratch(ctx context.Context, volumeId string) error {
+	return nil
+}
+
+func (m *MockMounter) CheckMount(ctx context.Context, target backend.MountTarget) error {
+	return nil
+}
+
 // Unmount unmounts a specific image
 func (m *MockMounter) Unmount(ctx context.Context, volumeId string, target backend.MountTarget) error {
 	if m.Mounted[volumeId] {
@@ -38,6 +54,10 @@ func (m *MockMounter) Unmount(ctx context.Context, volumeId string, target backe
 	return fmt.Errorf("image mount not found")
 }
 
+func (m *MockMounter) InspectImage(ctx context.Context, image reference.Named) (*backend.ImageMetadata, error) {
+	return &backend.ImageMetadata{Image: image.String()}, nil
+}
+
 // ImageExists checks if the image already exists on the local machine
 func (m *MockMounter) ImageExists(ctx context.Context, image reference.Named) bool {
 	return m.ImageSvcClient.PulledImages[image.Name()]
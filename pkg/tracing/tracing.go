@@ -0,0 +1,136 @@
+// Package tracing instruments the mount path of the driver with OpenTelemetry spans, e.g. NodePublishVolume,
+// credential resolution, pulls, snapshot preparation, and mounts, so that slow mounts can be broken down per stage.
+// Spans are recorded by the global tracer provider, which Setup registers to export spans via OTLP.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/distribution/reference"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/warm-metal/container-image-csi-driver"
+
+// Attributes of spans.
+const (
+	AttrVolumeID = attribute.Key("csi.volume.id")
+	AttrImage    = attribute.Key("container.image.name")
+	AttrRegistry = attribute.Key("container.image.registry")
+)
+
+// Protocols of OTLP exporters.
+const (
+	ProtocolGRPC = "grpc"
+	ProtocolHTTP = "http/protobuf"
+)
+
+// Options configure how spans are exported.
+type Options struct {
+	// Endpoint is the host:port of the OTLP receiver, e.g. otel-collector:4317. Spans aren't exported if empty.
+	Endpoint string
+	// Protocol is ProtocolGRPC or ProtocolHTTP.
+	Protocol string
+	// Insecure exports spans without TLS.
+	Insecure bool
+	// SamplingRatio is the ratio of traces sampled unless the parent of a span is sampled, from 0 to 1.
+	SamplingRatio float64
+	// ServiceName and ServiceVersion are set on the resource of all spans, along with the node name if not empty.
+	ServiceName    string
+	ServiceVersion string
+	NodeName       string
+}
+
+// Setup registers the global tracer provider exporting spans via OTLP, along with the W3C trace context propagator,
+// and returns the function flushing spans and shutting the provider down. It does nothing if no endpoint is set.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	if opts.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	if opts.SamplingRatio < 0 || opts.SamplingRatio > 1 {
+		return nil, fmt.Errorf("sampling ratio %g is not between 0 and 1", opts.SamplingRatio)
+	}
+
+	var client otlptrace.Client
+	switch opts.Protocol {
+	case ProtocolGRPC:
+		grpcOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(opts.Endpoint)}
+		if opts.Insecure {
+			grpcOpts = append(grpcOpts, otlptracegrpc.WithInsecure())
+		}
+		client = otlptracegrpc.NewClient(grpcOpts...)
+	case ProtocolHTTP:
+		httpOpts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(opts.Endpoint)}
+		if opts.Insecure {
+			httpOpts = append(httpOpts, otlptracehttp.WithInsecure())
+		}
+		client = otlptracehttp.NewClient(httpOpts...)
+	default:
+		return nil, fmt.Errorf("unknown OTLP protocol %q, must be %q or %q", opts.Protocol, ProtocolGRPC, ProtocolHTTP)
+	}
+
+	exporter, err := otlptrace.New(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the OTLP exporter: %w", err)
+	}
+
+	attrs := []attribute.KeyValue{
+		attribute.String("service.name", opts.ServiceName),
+		attribute.String("service.version", opts.ServiceVersion),
+	}
+	if opts.NodeName != "" {
+		attrs = append(attrs, attribute.String("k8s.node.name", opts.NodeName))
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attrs...)),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SamplingRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{},
+		propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// Start starts a span of the stage as a child of the span in ctx.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends the span, which fails with err if it isn't nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// ImageAttributes returns attributes of the image and its registry.
+func ImageAttributes(image reference.Named) []attribute.KeyValue {
+	return []attribute.KeyValue{AttrImage.String(image.String()), AttrRegistry.String(reference.Domain(image))}
+}
+
+// TraceID returns the ID of the trace of the span in ctx if it is sampled, or an empty string otherwise, e.g. if no
+// tracer provider is registered.
+func TraceID(ctx context.Context) string {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsSampled() {
+		return ""
+	}
+
+	return spanContext.TraceID().String()
+}
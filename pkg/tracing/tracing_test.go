@@ -0,0 +1,54 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestSetup(t *testing.T) {
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	for _, c := range []struct {
+		name    string
+		opts    Options
+		invalid bool
+		sampled bool
+	}{
+		{name: "disabled", opts: Options{}},
+		{name: "grpc", opts: Options{Endpoint: "127.0.0.1:4317", Protocol: ProtocolGRPC, Insecure: true,
+			SamplingRatio: 1}, sampled: true},
+		{name: "http", opts: Options{Endpoint: "127.0.0.1:4318", Protocol: ProtocolHTTP, Insecure: true,
+			SamplingRatio: 1}, sampled: true},
+		{name: "never sampled", opts: Options{Endpoint: "127.0.0.1:4317", Protocol: ProtocolGRPC, Insecure: true}},
+		{name: "unknown protocol", opts: Options{Endpoint: "127.0.0.1:4317", Protocol: "udp"}, invalid: true},
+		{name: "invalid ratio", opts: Options{Endpoint: "127.0.0.1:4317", Protocol: ProtocolGRPC, SamplingRatio: 2},
+			invalid: true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			otel.SetTracerProvider(noop.NewTracerProvider())
+			shutdown, err := Setup(context.Background(), c.opts)
+			if c.invalid {
+				assert.Error(t, err)
+				return
+			}
+
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			ctx, span := Start(context.Background(), "test")
+			assert.Equal(t, c.sampled, TraceID(ctx) != "")
+			End(span, nil)
+
+			// Nothing listens at the endpoint, so flushing spans may fail.
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			_ = shutdown(ctx)
+		})
+	}
+}
@@ -0,0 +1,45 @@
+// Package volume resolves images of volumes of the driver from their volume attributes, so that node plugins and
+// admission webhooks always agree on which images volumes mount.
+package volume
+
+import "strings"
+
+// Volume attributes naming images of volumes.
+const (
+	// AttrVolumeHandle is the image of the volume. It takes precedence over AttrImage.
+	AttrVolumeHandle = "volumeHandle"
+	// AttrImage is the image of the volume if AttrVolumeHandle is not set.
+	AttrImage = "image"
+	// AttrOverlayImages are comma-separated images merged into the volume.
+	AttrOverlayImages = "overlayImages"
+)
+
+// Image returns the image of the volume, which is the attribute volumeHandle, image, or the volume ID if neither is
+// set, e.g. the handle of a pre-provisioned PV.
+func Image(volumeID string, attributes map[string]string) string {
+	if len(attributes[AttrVolumeHandle]) > 0 {
+		return attributes[AttrVolumeHandle]
+	}
+
+	if len(attributes[AttrImage]) > 0 {
+		return attributes[AttrImage]
+	}
+
+	return volumeID
+}
+
+// Images returns the image of the volume followed by images merged into it.
+func Images(volumeID string, attributes map[string]string) []string {
+	return append([]string{Image(volumeID, attributes)}, SplitList(attributes[AttrOverlayImages])...)
+}
+
+// SplitList splits a comma-separated list of attributes, e.g. images, skipping empty items.
+func SplitList(items string) (list []string) {
+	for _, item := range strings.Split(items, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
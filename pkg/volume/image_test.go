@@ -0,0 +1,31 @@
+package volume
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImages(t *testing.T) {
+	for _, c := range []struct {
+		name       string
+		volumeID   string
+		attributes map[string]string
+		images     []string
+	}{
+		{name: "volume ID", volumeID: "redis:latest", images: []string{"redis:latest"}},
+		{name: "image", volumeID: "pvc-1", attributes: map[string]string{AttrImage: "redis:latest"},
+			images: []string{"redis:latest"}},
+		{name: "volume handle", volumeID: "pvc-1",
+			attributes: map[string]string{AttrImage: "redis:latest", AttrVolumeHandle: "nginx:latest"},
+			images:     []string{"nginx:latest"}},
+		{name: "overlay images", volumeID: "redis:latest",
+			attributes: map[string]string{AttrOverlayImages: " ghcr.io/org/data:v1,, nginx:latest "},
+			images:     []string{"redis:latest", "ghcr.io/org/data:v1", "nginx:latest"}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.images, Images(c.volumeID, c.attributes))
+			assert.Equal(t, c.images[0], Image(c.volumeID, c.attributes))
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package watcher
+
+import (
+	"context"
+	"time"
+
+	"github.com/warm-metal/container-image-csi-driver/pkg/errorlog"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// AttachmentWatcher notifies VolumeAttachments of a CSI driver to a node.
+type AttachmentWatcher struct {
+	stopChan chan struct{}
+}
+
+// WatchAttachments calls onAttach with the CSI source of the PV of each new VolumeAttachment of the given
+// driver to the given node. VolumeAttachments are created once pods using the PVs are scheduled to the node.
+func WatchAttachments(
+	ctx context.Context, resyncPeriod time.Duration, driver, node string,
+	onAttach func(source *corev1.CSIPersistentVolumeSource),
+) (*AttachmentWatcher, error) {
+	kubeConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	clientSet, err := kubernetes.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return clientSet.StorageV1().VolumeAttachments().List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return clientSet.StorageV1().VolumeAttachments().Watch(ctx, options)
+		},
+	}
+
+	informer := cache.NewSharedIndexInformer(lw, &storagev1.VolumeAttachment{}, resyncPeriod, cache.Indexers{})
+	_, err = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			va, ok := obj.(*storagev1.VolumeAttachment)
+			if !ok || va.Spec.Attacher != driver || va.Spec.NodeName != node || va.Status.Attached ||
+				va.Spec.Source.PersistentVolumeName == nil {
+				return
+			}
+
+			pv, err := clientSet.CoreV1().PersistentVolumes().Get(ctx, *va.Spec.Source.PersistentVolumeName,
+				metav1.GetOptions{})
+			if err != nil {
+				errorlog.Errorf("unable to get pv %s of VolumeAttachment %s: %s", *va.Spec.Source.PersistentVolumeName,
+					va.Name, err)
+				return
+			}
+
+			if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != driver {
+				return
+			}
+
+			klog.Infof("pv %s with volume handle %q is attached to the node", pv.Name, pv.Spec.CSI.VolumeHandle)
+			onAttach(pv.Spec.CSI)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stopChan := make(chan struct{})
+	go informer.Run(stopChan)
+
+	return &AttachmentWatcher{stopChan: stopChan}, nil
+}
+
+// Stop stops the watcher.
+func (w *AttachmentWatcher) Stop() {
+	close(w.stopChan)
+}
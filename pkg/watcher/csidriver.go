@@ -0,0 +1,105 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/warm-metal/container-image-csi-driver/pkg/errorlog"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+)
+
+// csiDriverManagedBy is the value of the label app.kubernetes.io/managed-by of CSIDriver objects the driver manages.
+const csiDriverManagedBy = "container-image-csi-driver"
+
+// ManageCSIDriver creates the CSIDriver object of the driver with spec, then applies spec again every period until
+// ctx is done, so that the object never drifts from capabilities of the driver, e.g. after upgrades. Fields not in
+// spec are left as is.
+func ManageCSIDriver(ctx context.Context, client kubernetes.Interface, name string, spec storagev1.CSIDriverSpec,
+	period time.Duration) {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := applyCSIDriver(ctx, client, name, spec); err != nil {
+			errorlog.Errorf("unable to apply CSIDriver %s: %s", name, err)
+		}
+	}, period)
+}
+
+func applyCSIDriver(ctx context.Context, client kubernetes.Interface, name string, spec storagev1.CSIDriverSpec) error {
+	csiDrivers := client.StorageV1().CSIDrivers()
+	current, err := csiDrivers.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return createCSIDriver(ctx, client, name, spec)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if csiDriverSpecApplied(&current.Spec, &spec) {
+		return nil
+	}
+
+	updated := current.DeepCopy()
+	updated.Spec.AttachRequired = spec.AttachRequired
+	updated.Spec.PodInfoOnMount = spec.PodInfoOnMount
+	updated.Spec.StorageCapacity = spec.StorageCapacity
+	updated.Spec.FSGroupPolicy = spec.FSGroupPolicy
+	updated.Spec.SELinuxMount = spec.SELinuxMount
+	updated.Spec.TokenRequests = spec.TokenRequests
+	updated.Spec.VolumeLifecycleModes = spec.VolumeLifecycleModes
+	_, err = csiDrivers.Update(ctx, updated, metav1.UpdateOptions{})
+	if apierrors.IsInvalid(err) {
+		// Some fields are immutable on older clusters. CSIDrivers only configure kubelet and attachment, so volumes
+		// in use are kept while the object is created again.
+		klog.Warningf("unable to update CSIDriver %s, create it again: %s", name, err)
+		if err = csiDrivers.Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+
+		return createCSIDriver(ctx, client, name, spec)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	klog.Infof("updated CSIDriver %s", name)
+	return nil
+}
+
+func createCSIDriver(ctx context.Context, client kubernetes.Interface, name string, spec storagev1.CSIDriverSpec) error {
+	_, err := client.StorageV1().CSIDrivers().Create(ctx, &storagev1.CSIDriver{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{"app.kubernetes.io/managed-by": csiDriverManagedBy},
+		},
+		Spec: spec,
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to create CSIDriver: %w", err)
+	}
+
+	klog.Infof("created CSIDriver %s", name)
+	return nil
+}
+
+// csiDriverSpecApplied returns true if fields of spec are applied to current.
+func csiDriverSpecApplied(current, spec *storagev1.CSIDriverSpec) bool {
+	return ptr.Equal(current.AttachRequired, spec.AttachRequired) &&
+		ptr.Equal(current.PodInfoOnMount, spec.PodInfoOnMount) &&
+		ptr.Equal(current.StorageCapacity, spec.StorageCapacity) &&
+		ptr.Equal(current.FSGroupPolicy, spec.FSGroupPolicy) &&
+		// Clusters not supporting SELinux mounts drop the field.
+		(current.SELinuxMount == nil || ptr.Equal(current.SELinuxMount, spec.SELinuxMount)) &&
+		slices.Equal(current.VolumeLifecycleModes, spec.VolumeLifecycleModes) &&
+		slices.EqualFunc(current.TokenRequests, spec.TokenRequests, func(a, b storagev1.TokenRequest) bool {
+			return a.Audience == b.Audience && ptr.Equal(a.ExpirationSeconds, b.ExpirationSeconds)
+		})
+}
@@ -0,0 +1,224 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/warm-metal/container-image-csi-driver/pkg/errorlog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ClusterImageVolumePolicyKind is the kind of the cluster-scoped objects restricting images of volumes node plugins
+// pull.
+const ClusterImageVolumePolicyKind = "ClusterImageVolumePolicy"
+
+var imageVolumePolicyResource = schema.GroupVersionResource{
+	Group: "container-image.csi.k8s.io", Version: "v1alpha1", Resource: "clusterimagevolumepolicies",
+}
+
+// ImageVolumePolicy is the spec of a ClusterImageVolumePolicy. Images of volumes must satisfy all policies applying
+// to namespaces of their pods.
+type ImageVolumePolicy struct {
+	// Name is the name of the ClusterImageVolumePolicy.
+	Name string `json:"-"`
+	// AllowedRegistries are prefixes of normalized repositories images must be in, e.g. ghcr.io or
+	// docker.io/library. All repositories are allowed if it is empty.
+	AllowedRegistries []string `json:"allowedRegistries,omitempty"`
+	// RequireDigests rejects images referred by tags, which are mutable.
+	RequireDigests bool `json:"requireDigests,omitempty"`
+	// RequireSignatures rejects images unless node plugins verify their signatures.
+	RequireSignatures bool `json:"requireSignatures,omitempty"`
+	// RequiredAttestations are attestations images must have as referrers, e.g. sbom or slsa-provenance.
+	RequiredAttestations []string `json:"requiredAttestations,omitempty"`
+	// NamespaceSelector selects namespaces of pods the policy applies to. It applies to all pods if it is nil.
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+}
+
+// check fails if the image violates the policy. Signatures are left to the caller.
+func (p *ImageVolumePolicy) check(image reference.Named) error {
+	if _, isDigested := image.(reference.Digested); p.RequireDigests && !isDigested {
+		return fmt.Errorf("image %q must be referred by digest", image)
+	}
+
+	if len(p.AllowedRegistries) == 0 {
+		return nil
+	}
+
+	for _, prefix := range p.AllowedRegistries {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if image.Name() == prefix || strings.HasPrefix(image.Name(), prefix+"/") {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("image %q is not in allowed registries %s", image, strings.Join(p.AllowedRegistries, ", "))
+}
+
+// ImageRequirements are what policies applying to a volume require of its image besides its reference.
+type ImageRequirements struct {
+	// SignaturesRequiredBy are names of policies requiring signatures of the image, sorted by name.
+	SignaturesRequiredBy []string
+	// Attestations are attestations the image must have.
+	Attestations []string
+}
+
+// ImageVolumePolicies caches ClusterImageVolumePolicies and labels of namespaces they select. The CRD must be
+// installed.
+type ImageVolumePolicies struct {
+	policies   cache.Store
+	namespaces cache.Store
+}
+
+// NewImageVolumePolicies watches ClusterImageVolumePolicies using the service account of the driver until ctx is
+// cancelled.
+func NewImageVolumePolicies(ctx context.Context, resyncPeriod time.Duration) (*ImageVolumePolicies, error) {
+	kubeConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := kubernetes.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return WatchImageVolumePolicies(ctx, dynamicClient, client, resyncPeriod)
+}
+
+// WatchImageVolumePolicies watches ClusterImageVolumePolicies and namespaces until ctx is cancelled. It returns once
+// both are cached, so that no volume is published before policies are known.
+func WatchImageVolumePolicies(
+	ctx context.Context, dynamicClient dynamic.Interface, client kubernetes.Interface, resyncPeriod time.Duration,
+) (*ImageVolumePolicies, error) {
+	policyClient := dynamicClient.Resource(imageVolumePolicyResource)
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return policyClient.List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return policyClient.Watch(ctx, options)
+		},
+	}
+	policyInformer := cache.NewSharedIndexInformer(cache.ToListWatcherWithWatchListSemantics(lw, dynamicClient),
+		&unstructured.Unstructured{}, resyncPeriod, cache.Indexers{})
+
+	namespaceLW := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.CoreV1().Namespaces().List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.CoreV1().Namespaces().Watch(ctx, options)
+		},
+	}
+	namespaceInformer := cache.NewSharedIndexInformer(cache.ToListWatcherWithWatchListSemantics(namespaceLW, client),
+		&corev1.Namespace{}, resyncPeriod, cache.Indexers{})
+
+	go policyInformer.Run(ctx.Done())
+	go namespaceInformer.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), policyInformer.HasSynced, namespaceInformer.HasSynced) {
+		return nil, fmt.Errorf("unable to sync %ss", ClusterImageVolumePolicyKind)
+	}
+
+	return &ImageVolumePolicies{
+		policies:   policyInformer.GetStore(),
+		namespaces: namespaceInformer.GetStore(),
+	}, nil
+}
+
+// Check fails if the image violates any policy applying to pods in the namespace, and returns what they require of it
+// otherwise. All policies apply if the namespace is unknown.
+func (p *ImageVolumePolicies) Check(namespace string, image reference.Named) (*ImageRequirements, error) {
+	policies, err := p.list()
+	if err != nil {
+		return nil, err
+	}
+
+	requirements := &ImageRequirements{}
+	for _, policy := range policies {
+		if !p.applies(&policy, namespace) {
+			continue
+		}
+
+		if err := policy.check(image); err != nil {
+			return nil, fmt.Errorf("%s %s: %w", ClusterImageVolumePolicyKind, policy.Name, err)
+		}
+
+		if policy.RequireSignatures {
+			requirements.SignaturesRequiredBy = append(requirements.SignaturesRequiredBy, policy.Name)
+		}
+
+		for _, attestation := range policy.RequiredAttestations {
+			if !slices.Contains(requirements.Attestations, attestation) {
+				requirements.Attestations = append(requirements.Attestations, attestation)
+			}
+		}
+	}
+
+	return requirements, nil
+}
+
+// list returns cached policies sorted by name. Malformed policies fail it, since they would allow all images if they
+// were skipped.
+func (p *ImageVolumePolicies) list() ([]ImageVolumePolicy, error) {
+	var policies []ImageVolumePolicy
+	for _, obj := range p.policies.List() {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+
+		policy := ImageVolumePolicy{Name: u.GetName()}
+		if spec, found, _ := unstructured.NestedMap(u.Object, "spec"); found {
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(spec, &policy); err != nil {
+				return nil, fmt.Errorf("invalid %s %s: %w", ClusterImageVolumePolicyKind, u.GetName(), err)
+			}
+		}
+
+		policies = append(policies, policy)
+	}
+
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Name < policies[j].Name })
+	return policies, nil
+}
+
+// applies returns whether the policy selects the namespace. Policies with invalid selectors apply to all namespaces.
+func (p *ImageVolumePolicies) applies(policy *ImageVolumePolicy, namespace string) bool {
+	if policy.NamespaceSelector == nil || namespace == "" {
+		return true
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(policy.NamespaceSelector)
+	if err != nil {
+		errorlog.Errorf("invalid namespace selector of %s %s: %s", ClusterImageVolumePolicyKind, policy.Name, err)
+		return true
+	}
+
+	namespaceLabels := labels.Set{corev1.LabelMetadataName: namespace}
+	if obj, found, _ := p.namespaces.GetByKey(namespace); found {
+		if ns, ok := obj.(*corev1.Namespace); ok {
+			namespaceLabels = ns.Labels
+		}
+	}
+
+	return selector.Matches(namespaceLabels)
+}
@@ -0,0 +1,217 @@
+package watcher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/warm-metal/container-image-csi-driver/pkg/errorlog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ImagesAnnotation is set on Nodes by node plugins to the JSON list of images of volumes present on the node.
+	ImagesAnnotation = "container-image.csi.k8s.io/images"
+	// ImageLabelPrefix prefixes labels the cache coordinator sets on Nodes for each image present on them.
+	// See ImageLabel.
+	ImageLabelPrefix = "image.container-image.csi.k8s.io/"
+
+	coordinatorLease = "container-image-csi-driver-cache-coordinator"
+)
+
+// ImageLabel returns the label the cache coordinator sets on Nodes holding the image, which is ImageLabelPrefix
+// followed by the first 40 hex digits of the sha256 of the normalized image reference, e.g. docker.io/library/nginx:1.27.
+func ImageLabel(image string) string {
+	sum := sha256.Sum256([]byte(image))
+	return ImageLabelPrefix + hex.EncodeToString(sum[:])[:40]
+}
+
+// NodeImages reports images present on nodes via annotations of Nodes, and labels Nodes by them.
+type NodeImages struct {
+	client kubernetes.Interface
+}
+
+// NewNodeImages creates a client of Nodes using the service account of the driver.
+func NewNodeImages() (*NodeImages, error) {
+	kubeConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	clientSet, err := kubernetes.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NodeImages{client: clientSet}, nil
+}
+
+// Get returns images reported by the given node.
+func (c *NodeImages) Get(ctx context.Context, node string) ([]string, error) {
+	obj, err := c.client.CoreV1().Nodes().Get(ctx, node, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return imagesOf(obj), nil
+}
+
+// Report replaces images reported by the given node.
+func (c *NodeImages) Report(ctx context.Context, node string, images []string) error {
+	data, err := json.Marshal(images)
+	if err != nil {
+		return err
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{ImagesAnnotation: string(data)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.client.CoreV1().Nodes().Patch(ctx, node, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// Coordinate labels Nodes by images they report while the caller is the leader among controllers in the namespace
+// of the driver. It blocks until ctx is cancelled.
+func (c *NodeImages) Coordinate(ctx context.Context, resyncPeriod time.Duration) error {
+	namespace, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
+	if err != nil {
+		return fmt.Errorf("unable to fetch current namespace: %w", err)
+	}
+
+	identity, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: coordinatorLease, Namespace: strings.TrimSpace(string(namespace))},
+		Client:     c.client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+
+	// RunOrDie returns once the leadership is lost, then the caller runs for it again.
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   15 * time.Second,
+			RenewDeadline:   10 * time.Second,
+			RetryPeriod:     2 * time.Second,
+			ReleaseOnCancel: true,
+			Name:            coordinatorLease,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					klog.Infof("%s starts labeling nodes by images", identity)
+					c.labelNodes(ctx, resyncPeriod)
+				},
+				OnStoppedLeading: func() {
+					klog.Infof("%s stops labeling nodes by images", identity)
+				},
+			},
+		})
+	}
+
+	return nil
+}
+
+// labelNodes keeps labels of Nodes consistent with images they report until ctx is cancelled.
+func (c *NodeImages) labelNodes(ctx context.Context, resyncPeriod time.Duration) {
+	lw := cache.NewListWatchFromClient(c.client.CoreV1().RESTClient(), "nodes", metav1.NamespaceAll,
+		fields.Everything())
+	informer := cache.NewSharedIndexInformer(lw, &corev1.Node{}, resyncPeriod, cache.Indexers{})
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if node, ok := obj.(*corev1.Node); ok {
+				c.labelNode(ctx, node)
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			if node, ok := obj.(*corev1.Node); ok {
+				c.labelNode(ctx, node)
+			}
+		},
+	})
+	if err != nil {
+		errorlog.Errorf("unable to watch nodes: %s", err)
+		return
+	}
+
+	informer.Run(ctx.Done())
+}
+
+func (c *NodeImages) labelNode(ctx context.Context, node *corev1.Node) {
+	desired := make(map[string]struct{})
+	for _, image := range imagesOf(node) {
+		desired[ImageLabel(image)] = struct{}{}
+	}
+
+	labels := make(map[string]interface{})
+	for label := range node.Labels {
+		if _, found := desired[label]; !found && strings.HasPrefix(label, ImageLabelPrefix) {
+			labels[label] = nil
+		}
+	}
+
+	for label := range desired {
+		if _, found := node.Labels[label]; !found {
+			labels[label] = "true"
+		}
+	}
+
+	if len(labels) == 0 {
+		return
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": labels},
+	})
+	if err != nil {
+		errorlog.Errorf("unable to build labels of node %s: %s", node.Name, err)
+		return
+	}
+
+	if _, err = c.client.CoreV1().Nodes().Patch(ctx, node.Name, types.MergePatchType, patch,
+		metav1.PatchOptions{}); err != nil {
+		errorlog.Errorf("unable to label node %s by images: %s", node.Name, err)
+		return
+	}
+
+	klog.V(2).Infof("node %s is labeled by %d images", node.Name, len(desired))
+}
+
+// imagesOf returns images the node reports in sorted order.
+func imagesOf(node *corev1.Node) []string {
+	data, found := node.Annotations[ImagesAnnotation]
+	if !found {
+		return nil
+	}
+
+	var images []string
+	if err := json.Unmarshal([]byte(data), &images); err != nil {
+		klog.Warningf("invalid annotation %s of node %s: %s", ImagesAnnotation, node.Name, err)
+		return nil
+	}
+
+	sort.Strings(images)
+	return images
+}
@@ -0,0 +1,86 @@
+package watcher
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// PVDeletionWatcher notifies deletion of PVs of a CSI driver.
+type PVDeletionWatcher struct {
+	stopChan chan struct{}
+}
+
+// WatchPVDeletion calls onDelete with each deleted PV of the given driver, and CSI sources of the remaining PVs of
+// the driver.
+func WatchPVDeletion(
+	ctx context.Context, resyncPeriod time.Duration, driver string,
+	onDelete func(pv *corev1.PersistentVolume, remaining []*corev1.CSIPersistentVolumeSource),
+) (*PVDeletionWatcher, error) {
+	kubeConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	clientSet, err := kubernetes.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return clientSet.CoreV1().PersistentVolumes().List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return clientSet.CoreV1().PersistentVolumes().Watch(ctx, options)
+		},
+	}
+
+	informer := cache.NewSharedIndexInformer(lw, &corev1.PersistentVolume{}, resyncPeriod, cache.Indexers{})
+	remainingSources := func() (sources []*corev1.CSIPersistentVolumeSource) {
+		for _, obj := range informer.GetStore().List() {
+			if pv, ok := obj.(*corev1.PersistentVolume); ok && pv.Spec.CSI != nil && pv.Spec.CSI.Driver == driver {
+				sources = append(sources, pv.Spec.CSI)
+			}
+		}
+
+		return sources
+	}
+
+	_, err = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+
+			pv, ok := obj.(*corev1.PersistentVolume)
+			if !ok || pv.Spec.CSI == nil || pv.Spec.CSI.Driver != driver {
+				return
+			}
+
+			klog.Infof("pv %s with volume handle %q is deleted", pv.Name, pv.Spec.CSI.VolumeHandle)
+			onDelete(pv, remainingSources())
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stopChan := make(chan struct{})
+	go informer.Run(stopChan)
+
+	return &PVDeletionWatcher{stopChan: stopChan}, nil
+}
+
+// Stop stops the watcher.
+func (w *PVDeletionWatcher) Stop() {
+	close(w.stopChan)
+}
@@ -0,0 +1,194 @@
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// SnapshotNodeAnnotation is set on VolumeSnapshotContents to the node which saved the snapshot.
+	SnapshotNodeAnnotation = "container-image.csi.k8s.io/snapshot-node"
+	// PushedImageAnnotation is set on VolumeSnapshotContents to the image the snapshot is pushed as.
+	PushedImageAnnotation = "container-image.csi.k8s.io/pushed-image"
+)
+
+var (
+	snapshotContentResource = schema.GroupVersionResource{
+		Group: "snapshot.storage.k8s.io", Version: "v1", Resource: "volumesnapshotcontents",
+	}
+	snapshotClassResource = schema.GroupVersionResource{
+		Group: "snapshot.storage.k8s.io", Version: "v1", Resource: "volumesnapshotclasses",
+	}
+)
+
+// SnapshotContent is a VolumeSnapshotContent of a CSI driver.
+type SnapshotContent struct {
+	Name string
+	// VolumeHandle is the ID of the source volume, which is empty for pre-provisioned snapshots.
+	VolumeHandle string
+	// ClassName is the name of the VolumeSnapshotClass, which is empty for pre-provisioned snapshots.
+	ClassName string
+	// Node is the node which saved the snapshot, or empty if it is not saved yet.
+	Node              string
+	CreationTimestamp time.Time
+}
+
+// SnapshotContents accesses VolumeSnapshotContents. The snapshot CRDs must be installed.
+type SnapshotContents struct {
+	client      dynamic.NamespaceableResourceInterface
+	classClient dynamic.NamespaceableResourceInterface
+}
+
+// NewSnapshotContents creates a client of VolumeSnapshotContents using the service account of the driver.
+func NewSnapshotContents() (*SnapshotContents, error) {
+	kubeConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := dynamic.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SnapshotContents{
+		client:      client.Resource(snapshotContentResource),
+		classClient: client.Resource(snapshotClassResource),
+	}, nil
+}
+
+// Get returns the VolumeSnapshotContent with the given name.
+func (s *SnapshotContents) Get(ctx context.Context, name string) (*SnapshotContent, error) {
+	obj, err := s.client.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	content, _ := snapshotContentOf(obj, "")
+	return content, nil
+}
+
+// ClassParameters returns parameters of the VolumeSnapshotClass with the given name.
+func (s *SnapshotContents) ClassParameters(ctx context.Context, name string) (map[string]string, error) {
+	obj, err := s.classClient.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	params, _, err := unstructured.NestedStringMap(obj.Object, "parameters")
+	return params, err
+}
+
+// MarkSaved annotates the VolumeSnapshotContent with the node which saved the snapshot, and the image the
+// snapshot is pushed as if it is not empty.
+func (s *SnapshotContents) MarkSaved(ctx context.Context, name, node, pushedImage string) error {
+	annotations := map[string]string{SnapshotNodeAnnotation: node}
+	if pushedImage != "" {
+		annotations[PushedImageAnnotation] = pushedImage
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// SnapshotContentWatcher notifies changes of VolumeSnapshotContents of a CSI driver.
+type SnapshotContentWatcher struct {
+	stopChan chan struct{}
+}
+
+// Watch calls onUpdate with each added or updated VolumeSnapshotContent of the given driver, and onDelete
+// with each deleted one.
+func (s *SnapshotContents) Watch(
+	ctx context.Context, resyncPeriod time.Duration, driver string, onUpdate, onDelete func(*SnapshotContent),
+) (*SnapshotContentWatcher, error) {
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return s.client.List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return s.client.Watch(ctx, options)
+		},
+	}
+
+	informer := cache.NewSharedIndexInformer(lw, &unstructured.Unstructured{}, resyncPeriod, cache.Indexers{})
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if content, ok := snapshotContentOf(obj, driver); ok {
+				onUpdate(content)
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			if content, ok := snapshotContentOf(obj, driver); ok {
+				onUpdate(content)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+
+			if content, ok := snapshotContentOf(obj, driver); ok {
+				klog.Infof("VolumeSnapshotContent %s of volume %q is deleted", content.Name, content.VolumeHandle)
+				onDelete(content)
+			}
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stopChan := make(chan struct{})
+	go informer.Run(stopChan)
+
+	return &SnapshotContentWatcher{stopChan: stopChan}, nil
+}
+
+// Stop stops the watcher.
+func (w *SnapshotContentWatcher) Stop() {
+	close(w.stopChan)
+}
+
+// snapshotContentOf converts a VolumeSnapshotContent. False is returned if it is not of the given driver.
+// All drivers are accepted if driver is empty.
+func snapshotContentOf(obj interface{}, driver string) (*SnapshotContent, bool) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, false
+	}
+
+	contentDriver, _, _ := unstructured.NestedString(u.Object, "spec", "driver")
+	if driver != "" && contentDriver != driver {
+		return nil, false
+	}
+
+	volumeHandle, _, _ := unstructured.NestedString(u.Object, "spec", "source", "volumeHandle")
+	className, _, _ := unstructured.NestedString(u.Object, "spec", "volumeSnapshotClassName")
+	return &SnapshotContent{
+		Name:              u.GetName(),
+		VolumeHandle:      volumeHandle,
+		ClassName:         className,
+		Node:              u.GetAnnotations()[SnapshotNodeAnnotation],
+		CreationTimestamp: u.GetCreationTimestamp().Time,
+	}, true
+}
@@ -0,0 +1,52 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// VolumeAttributesClasses reads VolumeAttributesClasses of PVs, which the resizer sets once the driver accepts
+// their parameters via ControllerModifyVolume.
+type VolumeAttributesClasses struct {
+	client kubernetes.Interface
+	driver string
+}
+
+// NewVolumeAttributesClasses creates a reader of PVs and VolumeAttributesClasses of the driver via the client.
+func NewVolumeAttributesClasses(client kubernetes.Interface, driver string) *VolumeAttributesClasses {
+	return &VolumeAttributesClasses{client: client, driver: driver}
+}
+
+// ParametersOf returns parameters of the VolumeAttributesClass of the PV, or nil if the PV has no class or is not
+// found, e.g. if the name isn't of a PV.
+func (v *VolumeAttributesClasses) ParametersOf(ctx context.Context, pvName string) (map[string]string, error) {
+	pv, err := v.client.CoreV1().PersistentVolumes().Get(ctx, pvName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if pv.Spec.VolumeAttributesClassName == nil || *pv.Spec.VolumeAttributesClassName == "" {
+		return nil, nil
+	}
+
+	class, err := v.client.StorageV1().VolumeAttributesClasses().Get(ctx, *pv.Spec.VolumeAttributesClassName,
+		metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	if class.DriverName != v.driver {
+		return nil, fmt.Errorf("VolumeAttributesClass %s of PV %s is of driver %s", class.Name, pvName,
+			class.DriverName)
+	}
+
+	return class.Parameters, nil
+}
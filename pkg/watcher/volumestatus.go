@@ -0,0 +1,119 @@
+package watcher
+
+import (
+	"context"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// NodeImageVolumeStatusKind is the kind of the cluster-scoped objects node plugins report image volumes of their
+	// nodes by. Each is named after its node and owned by the Node.
+	NodeImageVolumeStatusKind = "NodeImageVolumeStatus"
+
+	volumeStatusAPIVersion = "container-image.csi.k8s.io/v1alpha1"
+)
+
+var (
+	volumeStatusResource = schema.GroupVersionResource{
+		Group: "container-image.csi.k8s.io", Version: "v1alpha1", Resource: "nodeimagevolumestatuses",
+	}
+	nodeResource = schema.GroupVersionResource{Version: "v1", Resource: "nodes"}
+)
+
+// MountedImage is an image of volumes mounted on a node.
+type MountedImage struct {
+	Image  string `json:"image"`
+	Digest string `json:"digest,omitempty"`
+	// Volumes is the number of volumes mounted from the image.
+	Volumes int `json:"volumes"`
+	// CompressedBytes and UnpackedBytes are the sizes of the image, if the runtime can measure them.
+	CompressedBytes int64 `json:"compressedBytes,omitempty"`
+	UnpackedBytes   int64 `json:"unpackedBytes,omitempty"`
+}
+
+// NodeImageVolumeStatus is the status of image volumes of a node.
+type NodeImageVolumeStatus struct {
+	// MountedImages are images of volumes mounted on the node, sorted by image.
+	MountedImages []MountedImage `json:"mountedImages"`
+	// CachedImages is the number of images pulled by the driver still on the node, including those of mounted images.
+	CachedImages int `json:"cachedImages"`
+	// CachedCompressedBytes and CachedUnpackedBytes sum sizes of cached images, if the runtime can measure them.
+	CachedCompressedBytes int64  `json:"cachedCompressedBytes,omitempty"`
+	CachedUnpackedBytes   int64  `json:"cachedUnpackedBytes,omitempty"`
+	UpdateTime            string `json:"updateTime"`
+}
+
+// NodeImageVolumeStatuses reports image volumes of nodes via NodeImageVolumeStatuses. The CRD must be installed.
+type NodeImageVolumeStatuses struct {
+	client     dynamic.NamespaceableResourceInterface
+	nodeClient dynamic.NamespaceableResourceInterface
+}
+
+// NewNodeImageVolumeStatuses creates a client of NodeImageVolumeStatuses using the service account of the driver.
+func NewNodeImageVolumeStatuses() (*NodeImageVolumeStatuses, error) {
+	kubeConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := dynamic.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NodeImageVolumeStatuses{
+		client:     client.Resource(volumeStatusResource),
+		nodeClient: client.Resource(nodeResource),
+	}, nil
+}
+
+// Report replaces the status of the given node, creating its NodeImageVolumeStatus if it doesn't exist.
+func (s *NodeImageVolumeStatuses) Report(ctx context.Context, node string, status NodeImageVolumeStatus) error {
+	status.UpdateTime = time.Now().UTC().Format(time.RFC3339)
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		return err
+	}
+
+	obj, err := s.client.Get(ctx, node, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return s.create(ctx, node, content)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	obj.Object["status"] = content
+	_, err = s.client.Update(ctx, obj, metav1.UpdateOptions{})
+	return err
+}
+
+// create creates the NodeImageVolumeStatus of the node owned by the Node, so that it is removed along with the node.
+func (s *NodeImageVolumeStatuses) create(ctx context.Context, node string, status map[string]interface{}) error {
+	nodeObj, err := s.nodeClient.Get(ctx, node, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{"status": status}}
+	obj.SetAPIVersion(volumeStatusAPIVersion)
+	obj.SetKind(NodeImageVolumeStatusKind)
+	obj.SetName(node)
+	obj.SetOwnerReferences([]metav1.OwnerReference{{
+		APIVersion: "v1",
+		Kind:       "Node",
+		Name:       node,
+		UID:        nodeObj.GetUID(),
+	}})
+	_, err = s.client.Create(ctx, obj, metav1.CreateOptions{})
+	return err
+}
@@ -0,0 +1,152 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/warm-metal/container-image-csi-driver/pkg/volume"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// ImageVolumeAnnotationPrefix prefixes pod annotations declaring ephemeral volumes of images, e.g.
+// image-volume/models: docker.io/org/models:v1 declares the volume models of the image.
+const ImageVolumeAnnotationPrefix = "image-volume/"
+
+// imageAttribute is the volume attribute of the image of ephemeral volumes.
+const imageAttribute = volume.AttrImage
+
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// HandleMutation expands image volume annotations of pods into CSI ephemeral volumes of the driver at path.
+func (s *Server) HandleMutation(path, driver string) {
+	s.mux.HandleFunc(path, serveAdmission(func(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+		return mutatePod(req, driver)
+	}))
+}
+
+func mutatePod(req *admissionv1.AdmissionRequest, driver string) *admissionv1.AdmissionResponse {
+	pod := &corev1.Pod{}
+	if err := json.Unmarshal(req.Object.Raw, pod); err != nil {
+		return deny("invalid pod: %s", err)
+	}
+
+	volumes, warnings, err := expandImageVolumes(pod, driver)
+	if err != nil {
+		return deny("%s", err)
+	}
+
+	resp := &admissionv1.AdmissionResponse{Allowed: true, Warnings: warnings}
+	if volumes == nil {
+		return resp
+	}
+
+	klog.V(2).Infof("expand image volumes of pod %s/%s", req.Namespace, pod.GetName()+pod.GetGenerateName())
+	return patchVolumes(resp, volumes)
+}
+
+// patchVolumes replaces volumes of the pod with volumes in the response.
+func patchVolumes(resp *admissionv1.AdmissionResponse, volumes []corev1.Volume) *admissionv1.AdmissionResponse {
+	patch, err := json.Marshal([]patchOperation{{Op: "add", Path: "/spec/volumes", Value: volumes}})
+	if err != nil {
+		return deny("unable to build the patch of image volumes: %s", err)
+	}
+
+	patchType := admissionv1.PatchTypeJSONPatch
+	resp.Patch = patch
+	resp.PatchType = &patchType
+	return resp
+}
+
+// expandImageVolumes returns volumes of the pod with image volume annotations expanded, or nil if nothing is
+// expanded. Volumes of the annotations are appended, unless volumes with the same names are declared without
+// sources, in which case their sources are filled. Volumes with sources are left as is with warnings.
+func expandImageVolumes(pod *corev1.Pod, driver string) ([]corev1.Volume, []string, error) {
+	var names []string
+	for k := range pod.Annotations {
+		if strings.HasPrefix(k, ImageVolumeAnnotationPrefix) {
+			names = append(names, strings.TrimPrefix(k, ImageVolumeAnnotationPrefix))
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, nil, nil
+	}
+
+	// Volumes are appended in a stable order.
+	sort.Strings(names)
+	volumes := append([]corev1.Volume{}, pod.Spec.Volumes...)
+	var warnings []string
+	changed := false
+	for _, name := range names {
+		image := strings.TrimSpace(pod.Annotations[ImageVolumeAnnotationPrefix+name])
+		if _, err := reference.ParseDockerRef(image); err != nil {
+			return nil, nil, fmt.Errorf("invalid image %q of annotation %s%s: %s", image,
+				ImageVolumeAnnotationPrefix, name, err)
+		}
+
+		source := corev1.VolumeSource{
+			CSI: &corev1.CSIVolumeSource{
+				Driver:           driver,
+				VolumeAttributes: map[string]string{imageAttribute: image},
+			},
+		}
+
+		found := false
+		for i := range volumes {
+			if volumes[i].Name != name {
+				continue
+			}
+
+			found = true
+			if csi := volumes[i].CSI; csi != nil && csi.Driver == driver && csi.VolumeAttributes[imageAttribute] == image {
+				// Expanded already, e.g. if the webhook is invoked again.
+				break
+			}
+
+			if !isUnsetSource(volumes[i].VolumeSource) {
+				warnings = append(warnings, fmt.Sprintf(
+					"volume %s already has a source, annotation %s%s is ignored", name, ImageVolumeAnnotationPrefix,
+					name))
+				break
+			}
+
+			volumes[i].VolumeSource = source
+			changed = true
+		}
+
+		if !found {
+			volumes = append(volumes, corev1.Volume{Name: name, VolumeSource: source})
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil, warnings, nil
+	}
+
+	return volumes, warnings, nil
+}
+
+// isUnsetSource returns true if the volume is declared without a source. Pod defaulting gives such volumes an empty
+// EmptyDir before mutating webhooks are called, so an EmptyDir without a medium or size limit is taken as unset.
+func isUnsetSource(source corev1.VolumeSource) bool {
+	if source == (corev1.VolumeSource{}) {
+		return true
+	}
+
+	emptyDir := source.EmptyDir
+	if emptyDir == nil || emptyDir.Medium != "" || emptyDir.SizeLimit != nil {
+		return false
+	}
+
+	return source == corev1.VolumeSource{EmptyDir: emptyDir}
+}
@@ -0,0 +1,161 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func annotatedPod(annotations map[string]string, volumes ...corev1.Volume) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Annotations: annotations},
+		Spec:       corev1.PodSpec{Volumes: volumes},
+	}
+}
+
+func imageVolume(name, image string) corev1.Volume {
+	return corev1.Volume{Name: name, VolumeSource: corev1.VolumeSource{CSI: &corev1.CSIVolumeSource{
+		Driver:           testDriver,
+		VolumeAttributes: map[string]string{imageAttribute: image},
+	}}}
+}
+
+// defaultedPod gives volumes without sources an EmptyDir, as pod defaulting of the API server does before mutating
+// webhooks are called.
+func defaultedPod(pod *corev1.Pod) *corev1.Pod {
+	for i := range pod.Spec.Volumes {
+		if pod.Spec.Volumes[i].VolumeSource == (corev1.VolumeSource{}) {
+			pod.Spec.Volumes[i].EmptyDir = &corev1.EmptyDirVolumeSource{}
+		}
+	}
+
+	return pod
+}
+
+func TestMutatePod(t *testing.T) {
+	emptyDir := corev1.Volume{Name: "data", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}
+	memoryDir := corev1.Volume{Name: "data", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{
+		Medium: corev1.StorageMediumMemory,
+	}}}
+	for _, c := range []struct {
+		name     string
+		pod      *corev1.Pod
+		volumes  []corev1.Volume
+		warnings int
+		message  string
+	}{
+		{name: "no annotations", pod: annotatedPod(nil, emptyDir)},
+		{name: "other annotations", pod: annotatedPod(map[string]string{"image-volumes": "redis"})},
+		{name: "appended in order", pod: annotatedPod(map[string]string{
+			ImageVolumeAnnotationPrefix + "models": "docker.io/org/models:v1",
+			ImageVolumeAnnotationPrefix + "cache":  " redis:latest ",
+		}, emptyDir), volumes: []corev1.Volume{
+			emptyDir, imageVolume("cache", "redis:latest"), imageVolume("models", "docker.io/org/models:v1"),
+		}},
+		{name: "source filled", pod: annotatedPod(map[string]string{
+			ImageVolumeAnnotationPrefix + "models": "docker.io/org/models:v1",
+		}, corev1.Volume{Name: "models"}), volumes: []corev1.Volume{imageVolume("models", "docker.io/org/models:v1")}},
+		{name: "defaulted source filled", pod: defaultedPod(annotatedPod(map[string]string{
+			ImageVolumeAnnotationPrefix + "models": "docker.io/org/models:v1",
+		}, corev1.Volume{Name: "models"})), volumes: []corev1.Volume{imageVolume("models", "docker.io/org/models:v1")}},
+		{name: "expanded already", pod: annotatedPod(map[string]string{
+			ImageVolumeAnnotationPrefix + "models": "docker.io/org/models:v1",
+		}, imageVolume("models", "docker.io/org/models:v1"))},
+		{name: "volume with another source", pod: annotatedPod(map[string]string{
+			ImageVolumeAnnotationPrefix + "data": "redis:latest",
+		}, memoryDir), warnings: 1},
+		{name: "invalid image", pod: annotatedPod(map[string]string{
+			ImageVolumeAnnotationPrefix + "models": "docker.io/Org/Models:v1",
+		}), message: "invalid image"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			resp := mutatePod(admissionRequest(t, "Pod", c.pod), testDriver)
+			if c.message != "" {
+				assert.False(t, resp.Allowed)
+				assert.Contains(t, resp.Result.Message, c.message)
+				return
+			}
+
+			assert.True(t, resp.Allowed)
+			assert.Len(t, resp.Warnings, c.warnings)
+			if c.volumes == nil {
+				assert.Nil(t, resp.Patch)
+				return
+			}
+
+			require.Equal(t, admissionv1.PatchTypeJSONPatch, *resp.PatchType)
+			var patch []struct {
+				Op    string          `json:"op"`
+				Path  string          `json:"path"`
+				Value []corev1.Volume `json:"value"`
+			}
+			require.NoError(t, json.Unmarshal(resp.Patch, &patch))
+			require.Len(t, patch, 1)
+			assert.Equal(t, "add", patch[0].Op)
+			assert.Equal(t, "/spec/volumes", patch[0].Path)
+			assert.Equal(t, c.volumes, patch[0].Value)
+		})
+	}
+}
+
+// TestServeMutation sends AdmissionReviews to the mutating webhook as the API server does.
+func TestServeMutation(t *testing.T) {
+	s := NewServer("")
+	s.HandleMutation("/mutate-pods", testDriver)
+	server := httptest.NewServer(s.mux)
+	defer server.Close()
+
+	for _, c := range []struct {
+		name    string
+		body    []byte
+		code    int
+		allowed bool
+		patched bool
+	}{
+		{name: "patched", body: review(t, annotatedPod(map[string]string{
+			ImageVolumeAnnotationPrefix + "models": "docker.io/org/models:v1",
+		})), code: http.StatusOK, allowed: true, patched: true},
+		{name: "denied", body: review(t, annotatedPod(map[string]string{
+			ImageVolumeAnnotationPrefix + "models": "not an image",
+		})), code: http.StatusOK},
+		{name: "invalid review", body: []byte(`{"request": null}`), code: http.StatusBadRequest},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			resp, err := http.Post(server.URL+"/mutate-pods", "application/json", bytes.NewReader(c.body))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, c.code, resp.StatusCode)
+			if c.code != http.StatusOK {
+				return
+			}
+
+			out := &admissionv1.AdmissionReview{}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+			assert.Nil(t, out.Request)
+			assert.Equal(t, types.UID("review-uid"), out.Response.UID)
+			assert.Equal(t, c.allowed, out.Response.Allowed)
+			assert.Equal(t, c.patched, out.Response.Patch != nil)
+		})
+	}
+}
+
+// review returns the AdmissionReview of creating the pod.
+func review(t *testing.T, pod *corev1.Pod) []byte {
+	req := admissionRequest(t, "Pod", pod)
+	req.UID = "review-uid"
+	data, err := json.Marshal(&admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request:  req,
+	})
+	require.NoError(t, err)
+	return data
+}
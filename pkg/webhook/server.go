@@ -0,0 +1,127 @@
+package webhook
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// maxReviewSize limits the size of AdmissionReviews read from the API server.
+const maxReviewSize = 3 << 20
+
+// admitFunc admits the request. The UID of the response is set by serveAdmission.
+type admitFunc func(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse
+
+// serveAdmission decodes AdmissionReviews and responds with results of admit.
+func serveAdmission(admit admitFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxReviewSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		review := &admissionv1.AdmissionReview{}
+		if err = json.Unmarshal(body, review); err != nil || review.Request == nil {
+			http.Error(w, fmt.Sprintf("invalid AdmissionReview: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		resp := admit(review.Request)
+		resp.UID = review.Request.UID
+		review.Response = resp
+		review.Request = nil
+		data, err := json.Marshal(review)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if _, err = w.Write(data); err != nil {
+			klog.Errorf("unable to write AdmissionReview: %s", err)
+		}
+	}
+}
+
+// deny rejects the request with the message.
+func deny(format string, args ...interface{}) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusForbidden,
+			Reason:  metav1.StatusReasonForbidden,
+			Message: fmt.Sprintf(format, args...),
+		},
+	}
+}
+
+// Server serves admission webhooks over HTTPS with the certificate tls.crt and the key tls.key in a directory.
+// The certificate is reloaded once it is renewed, e.g. by cert-manager.
+type Server struct {
+	mux     *http.ServeMux
+	certDir string
+
+	guard   sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func NewServer(certDir string) *Server {
+	s := &Server{mux: http.NewServeMux(), certDir: certDir}
+	s.mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return s
+}
+
+// ListenAndServe serves webhooks registered to the server at addr until it fails.
+func (s *Server) ListenAndServe(addr string) error {
+	if _, err := s.certificate(nil); err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           s.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig:         &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: s.certificate},
+	}
+
+	klog.Infof("serving admission webhooks at %s", addr)
+	return server.ListenAndServeTLS("", "")
+}
+
+// certificate loads the certificate again if it is changed since it was loaded.
+func (s *Server) certificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certFile := filepath.Join(s.certDir, "tls.crt")
+	fi, err := os.Stat(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to stat the webhook certificate: %w", err)
+	}
+
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if s.cert != nil && fi.ModTime().Equal(s.modTime) {
+		return s.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, filepath.Join(s.certDir, "tls.key"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the webhook certificate: %w", err)
+	}
+
+	klog.Infof("loaded the webhook certificate from %s", s.certDir)
+	s.cert, s.modTime = &cert, fi.ModTime()
+	return s.cert, nil
+}
@@ -0,0 +1,147 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/distribution/reference"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+)
+
+// pullAlwaysAttribute is the volume attribute to pull images even if they are present.
+const pullAlwaysAttribute = "pullAlways"
+
+// Translation is the direction image volumes of pods are translated in.
+type Translation string
+
+const (
+	// TranslateToCSI translates native image volumes, i.e. volumes[].image of KEP-4639, into CSI ephemeral volumes of
+	// the driver, for clusters without the in-tree ImageVolume feature.
+	TranslateToCSI Translation = "csi"
+	// TranslateToNative translates CSI ephemeral volumes of the driver into native image volumes, for clusters
+	// migrating to the in-tree feature. Volumes using features native image volumes lack are kept.
+	TranslateToNative Translation = "native"
+)
+
+// HandleTranslation translates image volumes of pods to the direction at path.
+func (s *Server) HandleTranslation(path, driver string, to Translation) {
+	s.mux.HandleFunc(path, serveAdmission(func(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+		return translatePod(req, driver, to)
+	}))
+}
+
+func translatePod(req *admissionv1.AdmissionRequest, driver string, to Translation) *admissionv1.AdmissionResponse {
+	pod := &corev1.Pod{}
+	if err := json.Unmarshal(req.Object.Raw, pod); err != nil {
+		return deny("invalid pod: %s", err)
+	}
+
+	var warnings []string
+	var err error
+	volumes := append([]corev1.Volume{}, pod.Spec.Volumes...)
+	changed := false
+	for i := range volumes {
+		var translated bool
+		switch to {
+		case TranslateToCSI:
+			translated, err = translateToCSI(&volumes[i], driver)
+		case TranslateToNative:
+			translated, err = translateToNative(&volumes[i], driver)
+		default:
+			return deny("unknown translation %q", to)
+		}
+
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("volume %s is not translated: %s", volumes[i].Name, err))
+			continue
+		}
+
+		changed = changed || translated
+	}
+
+	resp := &admissionv1.AdmissionResponse{Allowed: true, Warnings: warnings}
+	if !changed {
+		return resp
+	}
+
+	klog.V(2).Infof("translate image volumes of pod %s/%s to %s", req.Namespace,
+		pod.GetName()+pod.GetGenerateName(), to)
+	return patchVolumes(resp, volumes)
+}
+
+// translateToCSI replaces the native image source of the volume with a read-only CSI ephemeral volume of the driver.
+func translateToCSI(volume *corev1.Volume, driver string) (bool, error) {
+	if volume.Image == nil {
+		return false, nil
+	}
+
+	image := volume.Image.Reference
+	named, err := reference.ParseDockerRef(image)
+	if err != nil {
+		return false, fmt.Errorf("invalid image %q: %s", image, err)
+	}
+
+	attributes := map[string]string{imageAttribute: image}
+	switch volume.Image.PullPolicy {
+	case corev1.PullAlways:
+		attributes[pullAlwaysAttribute] = "true"
+	case "":
+		// The same default as native image volumes.
+		if tagged, ok := named.(reference.Tagged); ok && tagged.Tag() == "latest" {
+			attributes[pullAlwaysAttribute] = "true"
+		}
+	case corev1.PullNever:
+		return false, fmt.Errorf("pull policy %s is not supported by the driver", corev1.PullNever)
+	}
+
+	volume.VolumeSource = corev1.VolumeSource{
+		CSI: &corev1.CSIVolumeSource{
+			Driver:           driver,
+			ReadOnly:         ptr.To(true),
+			VolumeAttributes: attributes,
+		},
+	}
+	return true, nil
+}
+
+// translateToNative replaces the CSI source of the driver of the volume with a native image source. Only read-only
+// volumes without attributes other than the image and pullAlways are translated, which native image volumes cover.
+func translateToNative(volume *corev1.Volume, driver string) (bool, error) {
+	csi := volume.CSI
+	if csi == nil || csi.Driver != driver {
+		return false, nil
+	}
+
+	if csi.ReadOnly == nil || !*csi.ReadOnly {
+		return false, fmt.Errorf("native image volumes are read-only")
+	}
+
+	if csi.NodePublishSecretRef != nil || csi.FSType != nil {
+		return false, fmt.Errorf("nodePublishSecretRef and fsType are not supported by native image volumes")
+	}
+
+	for k := range csi.VolumeAttributes {
+		if k != imageAttribute && k != pullAlwaysAttribute {
+			return false, fmt.Errorf("attribute %s is not supported by native image volumes", k)
+		}
+	}
+
+	source := &corev1.ImageVolumeSource{
+		Reference:  csi.VolumeAttributes[imageAttribute],
+		PullPolicy: corev1.PullIfNotPresent,
+	}
+	if _, err := reference.ParseDockerRef(source.Reference); err != nil {
+		return false, fmt.Errorf("invalid image %q: %s", source.Reference, err)
+	}
+
+	if strings.EqualFold(csi.VolumeAttributes[pullAlwaysAttribute], "true") {
+		source.PullPolicy = corev1.PullAlways
+	}
+
+	volume.VolumeSource = corev1.VolumeSource{Image: source}
+	return true, nil
+}
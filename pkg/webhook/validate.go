@@ -0,0 +1,89 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/warm-metal/container-image-csi-driver/pkg/volume"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// RegistryPolicy restricts images of volumes.
+type RegistryPolicy struct {
+	// AllowedPrefixes are prefixes of normalized repositories images must be in, e.g. ghcr.io or
+	// docker.io/library. All repositories are allowed if it is empty.
+	AllowedPrefixes []string
+	// RequireDigests rejects images referred by tags, which are mutable.
+	RequireDigests bool
+}
+
+func (p RegistryPolicy) check(image string) error {
+	named, err := reference.ParseDockerRef(image)
+	if err != nil {
+		return fmt.Errorf("invalid image %q: %s", image, err)
+	}
+
+	if _, isDigested := named.(reference.Digested); p.RequireDigests && !isDigested {
+		return fmt.Errorf("image %q must be referred by digest", image)
+	}
+
+	if len(p.AllowedPrefixes) == 0 {
+		return nil
+	}
+
+	for _, prefix := range p.AllowedPrefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if named.Name() == prefix || strings.HasPrefix(named.Name(), prefix+"/") {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("image %q is not in allowed repositories %s", image, strings.Join(p.AllowedPrefixes, ", "))
+}
+
+// HandleValidation rejects pods and PVs with volumes of the driver whose images violate the policy at path.
+func (s *Server) HandleValidation(path, driver string, policy RegistryPolicy) {
+	s.mux.HandleFunc(path, serveAdmission(func(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+		return validateImages(req, driver, policy)
+	}))
+}
+
+func validateImages(req *admissionv1.AdmissionRequest, driver string, policy RegistryPolicy) *admissionv1.AdmissionResponse {
+	var images []string
+	switch req.Kind.Kind {
+	case "Pod":
+		pod := &corev1.Pod{}
+		if err := json.Unmarshal(req.Object.Raw, pod); err != nil {
+			return deny("invalid pod: %s", err)
+		}
+
+		for _, v := range pod.Spec.Volumes {
+			if v.CSI != nil && v.CSI.Driver == driver {
+				images = append(images, volume.Images("", v.CSI.VolumeAttributes)...)
+			}
+		}
+	case "PersistentVolume":
+		pv := &corev1.PersistentVolume{}
+		if err := json.Unmarshal(req.Object.Raw, pv); err != nil {
+			return deny("invalid PersistentVolume: %s", err)
+		}
+
+		// Images are resolved as node plugins do, so that the image checked is the one mounted.
+		if source := pv.Spec.CSI; source != nil && source.Driver == driver {
+			images = volume.Images(source.VolumeHandle, source.VolumeAttributes)
+		}
+	default:
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+
+	for _, image := range images {
+		if err := policy.check(image); err != nil {
+			return deny("%s %s is rejected: %s", strings.ToLower(req.Kind.Kind), req.Name, err)
+		}
+	}
+
+	return &admissionv1.AdmissionResponse{Allowed: true}
+}
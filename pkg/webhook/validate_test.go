@@ -0,0 +1,136 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const testDriver = "container-image.csi.k8s.io"
+
+// admissionRequest returns the request creating the object of the kind.
+func admissionRequest(t *testing.T, kind string, obj interface{}) *admissionv1.AdmissionRequest {
+	raw, err := json.Marshal(obj)
+	assert.NoError(t, err)
+	return &admissionv1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: kind},
+		Name:      "test",
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+}
+
+func csiPod(driver string, attributes map[string]string) *corev1.Pod {
+	return &corev1.Pod{Spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+		Name: "image",
+		VolumeSource: corev1.VolumeSource{CSI: &corev1.CSIVolumeSource{
+			Driver:           driver,
+			VolumeAttributes: attributes,
+		}},
+	}}}}
+}
+
+func csiPV(handle string, attributes map[string]string) *corev1.PersistentVolume {
+	source := &corev1.CSIPersistentVolumeSource{Driver: testDriver, VolumeHandle: handle, VolumeAttributes: attributes}
+	return &corev1.PersistentVolume{Spec: corev1.PersistentVolumeSpec{
+		PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: source},
+	}}
+}
+
+func TestValidateImages(t *testing.T) {
+	policy := RegistryPolicy{AllowedPrefixes: []string{"docker.io/library", "ghcr.io/org/"}}
+	digested := "ghcr.io/org/app@sha256:" + strings.Repeat("a", 64)
+	for _, c := range []struct {
+		name    string
+		kind    string
+		obj     interface{}
+		policy  RegistryPolicy
+		allowed bool
+		message string
+	}{
+		{name: "allowed ephemeral volume", kind: "Pod",
+			obj: csiPod(testDriver, map[string]string{"image": "redis:latest"}), allowed: true},
+		{name: "denied ephemeral volume", kind: "Pod",
+			obj: csiPod(testDriver, map[string]string{"image": "evil.io/app:v1"}), message: "not in allowed"},
+		{name: "volumes of other drivers", kind: "Pod",
+			obj: csiPod("other.csi.k8s.io", map[string]string{"image": "evil.io/app:v1"}), allowed: true},
+		{name: "denied overlay image", kind: "Pod", obj: csiPod(testDriver, map[string]string{
+			"image": "redis:latest", "overlayImages": "ghcr.io/org/data:v1, evil.io/data:v1",
+		}), message: "evil.io/data:v1"},
+		{name: "ephemeral volume handle takes precedence", kind: "Pod", obj: csiPod(testDriver, map[string]string{
+			"image": "redis:latest", "volumeHandle": "evil.io/app:v1",
+		}), message: "evil.io/app:v1"},
+		{name: "missing image", kind: "Pod", obj: csiPod(testDriver, nil), message: "invalid image"},
+		{name: "allowed PV handle", kind: "PersistentVolume", obj: csiPV("ghcr.io/org/app:v1", nil), allowed: true},
+		{name: "denied PV handle", kind: "PersistentVolume", obj: csiPV("evil.io/app:v1", nil),
+			message: "evil.io/app:v1"},
+		{name: "PV image attribute", kind: "PersistentVolume",
+			obj: csiPV("pvc-1", map[string]string{"image": "redis:latest"}), allowed: true},
+		{name: "PV volume handle attribute takes precedence", kind: "PersistentVolume", obj: csiPV("pvc-1",
+			map[string]string{"image": "redis:latest", "volumeHandle": "evil.io/app:v1"}), message: "evil.io/app:v1"},
+		{name: "tags refused", kind: "PersistentVolume", obj: csiPV("ghcr.io/org/app:v1", nil),
+			policy: RegistryPolicy{RequireDigests: true}, message: "must be referred by digest"},
+		{name: "digests accepted", kind: "PersistentVolume", obj: csiPV(digested, nil),
+			policy: RegistryPolicy{RequireDigests: true}, allowed: true},
+		{name: "other kinds", kind: "ConfigMap", obj: &corev1.ConfigMap{}, allowed: true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			p := policy
+			if c.policy.RequireDigests {
+				p = c.policy
+			}
+
+			resp := validateImages(admissionRequest(t, c.kind, c.obj), testDriver, p)
+			assert.Equal(t, c.allowed, resp.Allowed)
+			if !c.allowed {
+				assert.Contains(t, resp.Result.Message, c.message)
+			}
+		})
+	}
+}
+
+// TestServeValidation sends AdmissionReviews to the validating webhook as the API server does.
+func TestServeValidation(t *testing.T) {
+	s := NewServer("")
+	s.HandleValidation("/validate-images", testDriver, RegistryPolicy{AllowedPrefixes: []string{"docker.io/library"}})
+	server := httptest.NewServer(s.mux)
+	defer server.Close()
+
+	for _, c := range []struct {
+		name    string
+		pod     *corev1.Pod
+		allowed bool
+		message string
+	}{
+		{name: "allowed", pod: csiPod(testDriver, map[string]string{"image": "redis:latest"}), allowed: true},
+		{name: "denied", pod: csiPod(testDriver, map[string]string{"image": "evil.io/app:v1"}),
+			message: "evil.io/app:v1"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			resp, err := http.Post(server.URL+"/validate-images", "application/json", bytes.NewReader(review(t, c.pod)))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			out := &admissionv1.AdmissionReview{}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+			assert.Equal(t, types.UID("review-uid"), out.Response.UID)
+			assert.Equal(t, c.allowed, out.Response.Allowed)
+			if !c.allowed {
+				assert.Equal(t, int32(http.StatusForbidden), out.Response.Result.Code)
+				assert.Contains(t, out.Response.Result.Message, c.message)
+			}
+		})
+	}
+}
@@ -1,12 +1,19 @@
 package main
 
 import (
-	"github.com/kubernetes-csi/csi-test/v5/pkg/sanity"
+	"os"
 	"testing"
+
+	"github.com/kubernetes-csi/csi-test/v5/pkg/sanity"
 )
 
 func TestCSIImage(t *testing.T) {
 	config := sanity.NewTestConfig()
 	config.Address = "/csi/csi.sock"
+	if addr := os.Getenv("CSI_ADDRESS"); addr != "" {
+		// e.g. the socket of a driver run locally with the fake runtime.
+		config.Address = addr
+	}
+
 	sanity.Test(t, config)
 }